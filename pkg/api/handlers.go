@@ -314,6 +314,7 @@ func NewQuerierHandler(
 	metadataQueryStats := usagestats.NewRequestsMiddleware("querier_metadata_query_requests")
 	cardinalityQueryStats := usagestats.NewRequestsMiddleware("querier_cardinality_query_requests")
 	formattingQueryStats := usagestats.NewRequestsMiddleware("querier_formatting_requests")
+	queryPlanStats := usagestats.NewRequestsMiddleware("querier_query_plan_requests")
 
 	// TODO(gotjosh): This custom handler is temporary until we're able to vendor the changes in:
 	// https://github.com/prometheus/prometheus/pull/7125/files
@@ -329,6 +330,7 @@ func NewQuerierHandler(
 	router.Path(path.Join(prefix, "/api/v1/cardinality/label_values")).Methods("GET", "POST").Handler(cardinalityQueryStats.Wrap(querier.LabelValuesCardinalityHandler(distributor, limits)))
 	router.Path(path.Join(prefix, "/api/v1/cardinality/active_series")).Methods("GET", "POST").Handler(cardinalityQueryStats.Wrap(querier.ActiveSeriesCardinalityHandler(distributor, limits)))
 	router.Path(path.Join(prefix, "/api/v1/format_query")).Methods("GET", "POST").Handler(formattingQueryStats.Wrap(promRouter))
+	router.Path(path.Join(prefix, "/api/v1/query_plan")).Methods("GET", "POST").Handler(queryPlanStats.Wrap(querier.QueryPlanHandler(engine)))
 
 	// Track execution time.
 	return stats.NewWallTimeMiddleware().Wrap(router)
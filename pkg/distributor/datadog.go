@@ -0,0 +1,214 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package distributor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/httpgrpc"
+	"github.com/grafana/dskit/middleware"
+	"github.com/grafana/dskit/tenant"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/util/strutil"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/grafana/mimir/pkg/util"
+	"github.com/grafana/mimir/pkg/util/spanlogger"
+	"github.com/grafana/mimir/pkg/util/validation"
+)
+
+// datadogSeriesV2PathSuffix is the suffix of the path of the Datadog series API v2. Other requests are decoded as
+// requests to the Datadog series API v1.
+const datadogSeriesV2PathSuffix = "/api/v2/series"
+
+// DatadogHandler is an http.Handler accepting JSON write requests to the Datadog series API, v1 and v2.
+func DatadogHandler(
+	maxRecvMsgSize int,
+	sourceIPs *middleware.SourceIPExtractor,
+	allowSkipLabelNameValidation bool,
+	limits *validation.Overrides,
+	retryCfg RetryConfig,
+	reg prometheus.Registerer,
+	push PushFunc,
+	logger log.Logger,
+) http.Handler {
+	datadogRequestsCounter := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "cortex_distributor_datadog_requests_total",
+		Help: "The total number of Datadog series requests that have come in to the distributor.",
+	}, []string{"user", "version"})
+
+	return handler(maxRecvMsgSize, sourceIPs, allowSkipLabelNameValidation, limits, retryCfg, push, logger, func(ctx context.Context, r *http.Request, maxRecvMsgSize int, buffers *util.RequestBuffers, req *mimirpb.PreallocWriteRequest, logger log.Logger) error {
+		if contentType := r.Header.Get("Content-Type"); contentType != "" {
+			if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != jsonContentType {
+				return httpgrpc.Errorf(http.StatusUnsupportedMediaType, "unsupported content type: %s, supported: [%s]", contentType, jsonContentType)
+			}
+		}
+
+		body, err := readPushRequestBody(r, maxRecvMsgSize, buffers)
+		if err != nil {
+			return err
+		}
+
+		tenantID, err := tenant.TenantID(ctx)
+		if err != nil {
+			return err
+		}
+
+		version := "v1"
+		decode := decodeDatadogSeriesV1
+		if strings.HasSuffix(r.URL.Path, datadogSeriesV2PathSuffix) {
+			version = "v2"
+			decode = decodeDatadogSeriesV2
+		}
+		datadogRequestsCounter.WithLabelValues(tenantID, version).Inc()
+
+		spanLogger, _ := spanlogger.NewWithLogger(ctx, logger, "Distributor.DatadogHandler.decodeAndConvert")
+		defer spanLogger.Span.Finish()
+
+		series, err := decode(body)
+		if err != nil {
+			return fmt.Errorf("unable to decode Datadog %s series: %w", version, err)
+		}
+
+		timeseries := datadogSeriesToTimeseries(series, limits.DatadogMetricSuffixesEnabled(tenantID))
+		level.Debug(spanLogger).Log("msg", "Datadog to Prometheus conversion complete", "version", version, "metric_count", len(timeseries))
+
+		req.Timeseries = timeseries
+		return nil
+	})
+}
+
+// datadogSeries is a series of the Datadog series API, regardless of the version of the API.
+type datadogSeries struct {
+	metric string
+	unit   string
+	// resources are the tags of the series which are not in tags, like the host.
+	resources []datadogResource
+	tags      []string
+	points    []mimirpb.Sample
+}
+
+type datadogResource struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+func decodeDatadogSeriesV1(body []byte) ([]datadogSeries, error) {
+	var payload struct {
+		Series []struct {
+			Metric string        `json:"metric"`
+			Points [][2]*float64 `json:"points"`
+			Host   string        `json:"host"`
+			Device string        `json:"device"`
+			Tags   []string      `json:"tags"`
+		} `json:"series"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	series := make([]datadogSeries, 0, len(payload.Series))
+	for _, s := range payload.Series {
+		ds := datadogSeries{metric: s.Metric, tags: s.Tags}
+		if s.Host != "" {
+			ds.resources = append(ds.resources, datadogResource{Type: "host", Name: s.Host})
+		}
+		if s.Device != "" {
+			ds.resources = append(ds.resources, datadogResource{Type: "device", Name: s.Device})
+		}
+		for _, p := range s.Points {
+			// The Datadog agent sends null for points without a timestamp or value, skip them.
+			if p[0] == nil || p[1] == nil {
+				continue
+			}
+			// Timestamps are in seconds.
+			ds.points = append(ds.points, mimirpb.Sample{TimestampMs: int64(*p[0] * 1000), Value: *p[1]})
+		}
+		series = append(series, ds)
+	}
+	return series, nil
+}
+
+func decodeDatadogSeriesV2(body []byte) ([]datadogSeries, error) {
+	var payload struct {
+		Series []struct {
+			Metric string `json:"metric"`
+			Points []struct {
+				Timestamp int64   `json:"timestamp"`
+				Value     float64 `json:"value"`
+			} `json:"points"`
+			Resources []datadogResource `json:"resources"`
+			Tags      []string          `json:"tags"`
+			Unit      string            `json:"unit"`
+		} `json:"series"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	series := make([]datadogSeries, 0, len(payload.Series))
+	for _, s := range payload.Series {
+		ds := datadogSeries{metric: s.Metric, unit: s.Unit, resources: s.Resources, tags: s.Tags}
+		for _, p := range s.Points {
+			// Timestamps are in seconds.
+			ds.points = append(ds.points, mimirpb.Sample{TimestampMs: p.Timestamp * 1000, Value: p.Value})
+		}
+		series = append(series, ds)
+	}
+	return series, nil
+}
+
+// datadogSeriesToTimeseries converts Datadog series to Prometheus series. Datadog tags in the "key:value" form, as well
+// as resources like the host, are converted to labels. Tags without a value are skipped, and when a tag is repeated
+// with different values, the first one is kept.
+func datadogSeriesToTimeseries(series []datadogSeries, addSuffixes bool) []mimirpb.PreallocTimeseries {
+	timeseries := mimirpb.PreallocTimeseriesSliceFromPool()
+	seriesIndexes := map[string]int{}
+
+	for _, s := range series {
+		if s.metric == "" {
+			continue
+		}
+
+		name := strutil.SanitizeFullLabelName(s.metric)
+		if unit := strutil.SanitizeLabelName(s.unit); addSuffixes && unit != "" && !strings.HasSuffix(name, "_"+unit) {
+			name += "_" + unit
+		}
+
+		lbls := []mimirpb.LabelAdapter{{Name: labels.MetricName, Value: name}}
+		seen := map[string]struct{}{labels.MetricName: {}}
+		addLabel := func(name, value string) {
+			name = strutil.SanitizeFullLabelName(name)
+			if _, ok := seen[name]; ok || value == "" {
+				return
+			}
+			seen[name] = struct{}{}
+			lbls = append(lbls, mimirpb.LabelAdapter{Name: name, Value: value})
+		}
+		for _, r := range s.resources {
+			addLabel(r.Type, r.Name)
+		}
+		for _, tag := range s.tags {
+			if key, value, ok := strings.Cut(tag, ":"); ok {
+				addLabel(key, value)
+			}
+		}
+		sort.Slice(lbls, func(i, j int) bool { return lbls[i].Name < lbls[j].Name })
+
+		for _, p := range s.points {
+			timeseries = appendSample(timeseries, seriesIndexes, lbls, p)
+		}
+	}
+
+	return timeseries
+}
@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package distributor
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/grafana/mimir/pkg/util/validation"
+)
+
+func TestDatadogHandler(t *testing.T) {
+	ts := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := map[string]struct {
+		path           string
+		body           string
+		contentType    string
+		compress       bool
+		addSuffixes    bool
+		expectedCode   int
+		expectedSeries []mimirpb.PreallocTimeseries
+	}{
+		"series v1": {
+			path: "/datadog/api/v1/series",
+			body: `{"series":[
+				{"metric":"system.load.1","points":[[1717243200,0.5],[1717243260,0.7]],"type":"gauge","host":"web-1","tags":["env:prod","role","env:dev"]},
+				{"metric":"system.load.1","points":[[1717243200,1.5]],"type":"gauge","host":"web-2","device":"sda"}
+			]}`,
+			expectedCode: http.StatusOK,
+			expectedSeries: []mimirpb.PreallocTimeseries{
+				seriesWithSamples("system_load_1", []string{"env", "prod", "host", "web-1"},
+					mimirpb.Sample{TimestampMs: ts.UnixMilli(), Value: 0.5},
+					mimirpb.Sample{TimestampMs: ts.Add(time.Minute).UnixMilli(), Value: 0.7},
+				),
+				seriesWithSamples("system_load_1", []string{"device", "sda", "host", "web-2"}, mimirpb.Sample{TimestampMs: ts.UnixMilli(), Value: 1.5}),
+			},
+		},
+		"series v1 with null points": {
+			path: "/datadog/api/v1/series",
+			body: `{"series":[
+				{"metric":"system.load.1","points":[[1717243200,null],[null,0.6],[1717243260,0.7]],"type":"gauge"}
+			]}`,
+			expectedCode: http.StatusOK,
+			expectedSeries: []mimirpb.PreallocTimeseries{
+				seriesWithSamples("system_load_1", nil, mimirpb.Sample{TimestampMs: ts.Add(time.Minute).UnixMilli(), Value: 0.7}),
+			},
+		},
+		"series v2 with deflate compression": {
+			path: "/datadog/api/v2/series",
+			body: `{"series":[
+				{"metric":"http.request.duration","type":3,"unit":"second","points":[{"timestamp":1717243200,"value":0.2}],"resources":[{"name":"web-1","type":"host"}],"tags":["env:prod"]}
+			]}`,
+			contentType:  "application/json",
+			compress:     true,
+			expectedCode: http.StatusOK,
+			expectedSeries: []mimirpb.PreallocTimeseries{
+				seriesWithSamples("http_request_duration", []string{"env", "prod", "host", "web-1"}, mimirpb.Sample{TimestampMs: ts.UnixMilli(), Value: 0.2}),
+			},
+		},
+		"series v2 with suffixes enabled": {
+			path: "/datadog/api/v2/series",
+			body: `{"series":[
+				{"metric":"http.request.duration","type":3,"unit":"second","points":[{"timestamp":1717243200,"value":0.2}]}
+			]}`,
+			addSuffixes:  true,
+			expectedCode: http.StatusOK,
+			expectedSeries: []mimirpb.PreallocTimeseries{
+				seriesWithSamples("http_request_duration_second", nil, mimirpb.Sample{TimestampMs: ts.UnixMilli(), Value: 0.2}),
+			},
+		},
+		"unsupported content type": {
+			path:         "/datadog/api/v2/series",
+			body:         `{"series":[]}`,
+			contentType:  "application/x-protobuf",
+			expectedCode: http.StatusUnsupportedMediaType,
+		},
+		"invalid JSON": {
+			path:         "/datadog/api/v1/series",
+			body:         `{"series":[`,
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			limits, err := validation.NewOverrides(validation.Limits{DatadogMetricSuffixesEnabled: testCase.addSuffixes}, nil)
+			require.NoError(t, err)
+
+			var pushed []mimirpb.PreallocTimeseries
+			handler := DatadogHandler(100000, nil, false, limits, RetryConfig{}, nil, func(_ context.Context, pushReq *Request) error {
+				req, err := pushReq.WriteRequest()
+				if err != nil {
+					pushReq.CleanUp()
+					return err
+				}
+				t.Cleanup(pushReq.CleanUp)
+				pushed = req.Timeseries
+				return nil
+			}, log.NewNopLogger())
+
+			body := []byte(testCase.body)
+			if testCase.compress {
+				var b bytes.Buffer
+				w := zlib.NewWriter(&b)
+				_, err := w.Write(body)
+				require.NoError(t, err)
+				require.NoError(t, w.Close())
+				body = b.Bytes()
+			}
+
+			req := httptest.NewRequest(http.MethodPost, testCase.path, bytes.NewReader(body))
+			req = req.WithContext(user.InjectOrgID(req.Context(), "test"))
+			if testCase.contentType != "" {
+				req.Header.Set("Content-Type", testCase.contentType)
+			}
+			if testCase.compress {
+				req.Header.Set("Content-Encoding", "deflate")
+			}
+
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			require.Equal(t, testCase.expectedCode, resp.Code, resp.Body.String())
+			assertSeriesWithSamples(t, testCase.expectedSeries, pushed)
+		})
+	}
+}
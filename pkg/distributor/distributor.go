@@ -106,6 +106,9 @@ type Distributor struct {
 	// For handling HA replicas.
 	HATracker *haTracker
 
+	// For converting the OTLP metrics with delta temporality to cumulative.
+	OTelDeltaToCumulative *deltaToCumulativeConverter
+
 	// Per-user rate limiters.
 	requestRateLimiter   *limiter.RateLimiter
 	ingestionRateLimiter *limiter.RateLimiter
@@ -458,7 +461,9 @@ func New(cfg Config, clientConfig ingester_client.Config, limits *validation.Ove
 
 	d.PushWithMiddlewares = d.wrapPushWithMiddlewares(d.push)
 
-	subservices = append(subservices, d.ingesterPool, d.activeUsers)
+	d.OTelDeltaToCumulative = newDeltaToCumulativeConverter(limits.OTelDeltaToCumulativeMaxStreams)
+
+	subservices = append(subservices, d.ingesterPool, d.activeUsers, d.OTelDeltaToCumulative)
 
 	if cfg.ReusableIngesterPushWorkers > 0 {
 		wp := concurrency.NewReusableGoroutinesPool(cfg.ReusableIngesterPushWorkers)
@@ -1337,6 +1342,7 @@ func (d *Distributor) push(ctx context.Context, pushReq *Request) error {
 	}
 
 	d.updateReceivedMetrics(req, userID)
+	recordRemoteWriteWrittenStats(ctx, req)
 
 	if len(req.Timeseries) == 0 && len(req.Metadata) == 0 {
 		return nil
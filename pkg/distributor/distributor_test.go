@@ -958,6 +958,36 @@ func TestDistributor_PushInstanceLimits(t *testing.T) {
 	}
 }
 
+func TestDistributor_Push_RecordsRemoteWriteWrittenStats(t *testing.T) {
+	var limits validation.Limits
+	flagext.DefaultValues(&limits)
+	limits.MaxLabelValueLength = 15
+
+	ds, _, _, _ := prepare(t, prepConfig{
+		numIngesters:    3,
+		happyIngesters:  3,
+		numDistributors: 1,
+		limits:          &limits,
+	})
+
+	now := time.Now().UnixMilli()
+	request := mimirpb.ToWriteRequest(
+		[][]mimirpb.LabelAdapter{
+			{{Name: model.MetricNameLabel, Value: "valid"}},
+			{{Name: model.MetricNameLabel, Value: "label_value_too_long"}},
+		},
+		[]mimirpb.Sample{{TimestampMs: now, Value: 1}, {TimestampMs: now, Value: 2}},
+		nil, nil, mimirpb.API,
+	)
+
+	// Only the series written to the ingesters are counted, not the ones failing validation.
+	stats := &remoteWriteWrittenStats{}
+	ctx := contextWithRemoteWriteWrittenStats(user.InjectOrgID(context.Background(), "user"), stats)
+	_, err := ds[0].Push(ctx, request)
+	require.Error(t, err)
+	assert.Equal(t, remoteWriteWrittenStats{samples: 1}, *stats)
+}
+
 func TestDistributor_PushHAInstances(t *testing.T) {
 	ctx := user.InjectOrgID(context.Background(), "user")
 
@@ -0,0 +1,300 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package distributor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/httpgrpc"
+	"github.com/grafana/dskit/middleware"
+	"github.com/grafana/dskit/tenant"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/util/strutil"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/grafana/mimir/pkg/util"
+	"github.com/grafana/mimir/pkg/util/spanlogger"
+	"github.com/grafana/mimir/pkg/util/validation"
+)
+
+// influxValueField is the name of the field which, by convention, holds the value of single-value measurements.
+const influxValueField = "value"
+
+// InfluxHandler is an http.Handler accepting write requests in the Influx line protocol.
+func InfluxHandler(
+	maxRecvMsgSize int,
+	sourceIPs *middleware.SourceIPExtractor,
+	allowSkipLabelNameValidation bool,
+	limits *validation.Overrides,
+	retryCfg RetryConfig,
+	reg prometheus.Registerer,
+	push PushFunc,
+	logger log.Logger,
+) http.Handler {
+	influxRequestsCounter := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "cortex_distributor_influx_requests_total",
+		Help: "The total number of Influx line protocol requests that have come in to the distributor.",
+	}, []string{"user"})
+
+	return handler(maxRecvMsgSize, sourceIPs, allowSkipLabelNameValidation, limits, retryCfg, push, logger, func(ctx context.Context, r *http.Request, maxRecvMsgSize int, buffers *util.RequestBuffers, req *mimirpb.PreallocWriteRequest, logger log.Logger) error {
+		precision, err := influxPrecision(r.URL.Query().Get("precision"))
+		if err != nil {
+			return httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+		}
+
+		body, err := readPushRequestBody(r, maxRecvMsgSize, buffers)
+		if err != nil {
+			return err
+		}
+
+		tenantID, err := tenant.TenantID(ctx)
+		if err != nil {
+			return err
+		}
+		influxRequestsCounter.WithLabelValues(tenantID).Inc()
+
+		spanLogger, _ := spanlogger.NewWithLogger(ctx, logger, "Distributor.InfluxHandler.parseAndConvert")
+		defer spanLogger.Span.Finish()
+
+		timeseries, err := influxLinesToTimeseries(string(body), precision, time.Now(), limits.InfluxMetricSuffixesEnabled(tenantID))
+		if err != nil {
+			return err
+		}
+
+		level.Debug(spanLogger).Log("msg", "Influx line protocol to Prometheus conversion complete", "metric_count", len(timeseries))
+
+		req.Timeseries = timeseries
+		return nil
+	})
+}
+
+// influxPrecision returns the unit of the timestamps of the points, given the precision query parameter.
+func influxPrecision(precision string) (time.Duration, error) {
+	switch precision {
+	case "", "n", "ns":
+		return time.Nanosecond, nil
+	case "u", "us":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	default:
+		return 0, fmt.Errorf("invalid precision %q", precision)
+	}
+}
+
+// influxLinesToTimeseries converts the points in the Influx line protocol to series. Each field of a point is
+// converted to a series named after the measurement and the field, and labelled with the tags of the point.
+// String fields are not supported and are skipped.
+func influxLinesToTimeseries(lines string, precision time.Duration, now time.Time, addSuffixes bool) ([]mimirpb.PreallocTimeseries, error) {
+	timeseries := mimirpb.PreallocTimeseriesSliceFromPool()
+	seriesIndexes := map[string]int{}
+
+	for lineNum := 1; len(lines) > 0; lineNum++ {
+		var line string
+		line, lines, _ = strings.Cut(lines, "\n")
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		point, err := parseInfluxLine(line)
+		if err != nil {
+			mimirpb.ReuseSlice(timeseries)
+			return nil, fmt.Errorf("unable to parse line %d: %w", lineNum, err)
+		}
+
+		timestampMs := now.UnixMilli()
+		if point.timestamp != nil {
+			timestampMs = *point.timestamp * int64(precision) / int64(time.Millisecond)
+		}
+
+		for _, field := range point.fields {
+			name := point.measurement
+			if field.key != influxValueField || addSuffixes {
+				name += "_" + field.key
+			}
+
+			lbls := make([]mimirpb.LabelAdapter, 0, len(point.tags)+1)
+			lbls = append(lbls, mimirpb.LabelAdapter{Name: labels.MetricName, Value: strutil.SanitizeFullLabelName(name)})
+			for _, tag := range point.tags {
+				lbls = append(lbls, mimirpb.LabelAdapter{Name: strutil.SanitizeFullLabelName(tag.key), Value: tag.value})
+			}
+			sort.Slice(lbls, func(i, j int) bool { return lbls[i].Name < lbls[j].Name })
+
+			timeseries = appendSample(timeseries, seriesIndexes, lbls, mimirpb.Sample{TimestampMs: timestampMs, Value: field.value})
+		}
+	}
+
+	return timeseries, nil
+}
+
+// appendSample appends the sample to the series with the given labels, adding the series if it's not in timeseries yet.
+func appendSample(timeseries []mimirpb.PreallocTimeseries, seriesIndexes map[string]int, lbls []mimirpb.LabelAdapter, sample mimirpb.Sample) []mimirpb.PreallocTimeseries {
+	key := mimirpb.FromLabelAdaptersToString(lbls)
+	if i, ok := seriesIndexes[key]; ok {
+		timeseries[i].Samples = append(timeseries[i].Samples, sample)
+		return timeseries
+	}
+
+	ts := mimirpb.TimeseriesFromPool()
+	ts.Labels = append(ts.Labels, lbls...)
+	ts.Samples = append(ts.Samples, sample)
+	seriesIndexes[key] = len(timeseries)
+	return append(timeseries, mimirpb.PreallocTimeseries{TimeSeries: ts})
+}
+
+type influxPoint struct {
+	measurement string
+	tags        []influxTag
+	fields      []influxField
+	timestamp   *int64
+}
+
+type influxTag struct {
+	key, value string
+}
+
+type influxField struct {
+	key   string
+	value float64
+}
+
+// parseInfluxLine parses a line of the Influx line protocol:
+//
+//	measurement[,tag_key=tag_value...] field_key=field_value[,field_key=field_value...] [timestamp]
+func parseInfluxLine(line string) (influxPoint, error) {
+	var point influxPoint
+
+	series, rest := cutUnescaped(line, ' ', false)
+	fields, timestamp := cutUnescaped(strings.TrimLeft(rest, " "), ' ', true)
+
+	measurement, tags := cutUnescaped(series, ',', false)
+	point.measurement = unescapeInflux(measurement)
+	if point.measurement == "" {
+		return point, fmt.Errorf("missing measurement")
+	}
+
+	for tags != "" {
+		var tag string
+		tag, tags = cutUnescaped(tags, ',', false)
+		key, value, ok := cutUnescapedKeyValue(tag)
+		if !ok {
+			return point, fmt.Errorf("invalid tag %q", tag)
+		}
+		point.tags = append(point.tags, influxTag{key: key, value: value})
+	}
+
+	if fields == "" {
+		return point, fmt.Errorf("missing fields")
+	}
+	for fields != "" {
+		var field string
+		field, fields = cutUnescaped(fields, ',', true)
+		key, value, ok := cutUnescapedKeyValue(field)
+		if !ok {
+			return point, fmt.Errorf("invalid field %q", field)
+		}
+
+		if strings.HasPrefix(value, `"`) {
+			// String fields can't be converted to samples.
+			continue
+		}
+		v, err := parseInfluxFieldValue(value)
+		if err != nil {
+			return point, fmt.Errorf("invalid value of field %q: %w", key, err)
+		}
+		point.fields = append(point.fields, influxField{key: key, value: v})
+	}
+
+	if timestamp = strings.TrimSpace(timestamp); timestamp != "" {
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return point, fmt.Errorf("invalid timestamp %q", timestamp)
+		}
+		point.timestamp = &ts
+	}
+
+	return point, nil
+}
+
+func parseInfluxFieldValue(value string) (float64, error) {
+	switch value {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, nil
+	}
+
+	switch {
+	case strings.HasSuffix(value, "i"):
+		v, err := strconv.ParseInt(strings.TrimSuffix(value, "i"), 10, 64)
+		return float64(v), err
+	case strings.HasSuffix(value, "u"):
+		v, err := strconv.ParseUint(strings.TrimSuffix(value, "u"), 10, 64)
+		return float64(v), err
+	default:
+		return strconv.ParseFloat(value, 64)
+	}
+}
+
+// cutUnescaped slices s around the first occurrence of sep which isn't escaped with a backslash and, if quoted is true,
+// isn't within a double-quoted string.
+func cutUnescaped(s string, sep byte, quoted bool) (before, after string) {
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quoted && s[i] == '"':
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			return s[:i], s[i+1:]
+		}
+	}
+	return s, ""
+}
+
+// cutUnescapedKeyValue splits a key=value pair, unescaping the key, and the value unless it's a string field value.
+func cutUnescapedKeyValue(s string) (key, value string, ok bool) {
+	key, value = cutUnescaped(s, '=', false)
+	if key == "" || len(key) == len(s) {
+		return "", "", false
+	}
+	if strings.HasPrefix(value, `"`) {
+		return unescapeInflux(key), value, true
+	}
+	return unescapeInflux(key), unescapeInflux(value), true
+}
+
+// unescapeInflux removes the backslashes escaping commas, equal signs, spaces and backslashes.
+func unescapeInflux(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`, =\`, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
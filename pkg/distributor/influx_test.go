@@ -0,0 +1,217 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package distributor
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/grafana/mimir/pkg/util/validation"
+)
+
+func TestInfluxLinesToTimeseries(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := map[string]struct {
+		lines       string
+		precision   time.Duration
+		addSuffixes bool
+		expected    []mimirpb.PreallocTimeseries
+		expectedErr string
+	}{
+		"multiple fields and tags": {
+			lines:     `cpu,host=a,region=eu usage_idle=90.5,usage_user=2i 1717243200000000000`,
+			precision: time.Nanosecond,
+			expected: []mimirpb.PreallocTimeseries{
+				seriesWithSamples(`cpu_usage_idle`, []string{"host", "a", "region", "eu"}, mimirpb.Sample{TimestampMs: now.UnixMilli(), Value: 90.5}),
+				seriesWithSamples(`cpu_usage_user`, []string{"host", "a", "region", "eu"}, mimirpb.Sample{TimestampMs: now.UnixMilli(), Value: 2}),
+			},
+		},
+		"value field is named after the measurement": {
+			lines:     "temperature,room=kitchen value=21.5 1717243200",
+			precision: time.Second,
+			expected: []mimirpb.PreallocTimeseries{
+				seriesWithSamples(`temperature`, []string{"room", "kitchen"}, mimirpb.Sample{TimestampMs: now.UnixMilli(), Value: 21.5}),
+			},
+		},
+		"value field with suffixes enabled": {
+			lines:       "temperature,room=kitchen value=21.5 1717243200",
+			precision:   time.Second,
+			addSuffixes: true,
+			expected: []mimirpb.PreallocTimeseries{
+				seriesWithSamples(`temperature_value`, []string{"room", "kitchen"}, mimirpb.Sample{TimestampMs: now.UnixMilli(), Value: 21.5}),
+			},
+		},
+		"samples of the same series are grouped": {
+			lines:     "requests,path=/a count=1u 1717243200000\nrequests,path=/a count=2u 1717243260000\n",
+			precision: time.Millisecond,
+			expected: []mimirpb.PreallocTimeseries{
+				seriesWithSamples(`requests_count`, []string{"path", "/a"},
+					mimirpb.Sample{TimestampMs: now.UnixMilli(), Value: 1},
+					mimirpb.Sample{TimestampMs: now.Add(time.Minute).UnixMilli(), Value: 2},
+				),
+			},
+		},
+		"booleans, strings, escapes, comments and missing timestamp": {
+			lines:     "# comment\n\nmy\\ measurement,tag\\ key=tag\\,value up=true,msg=\"hello, world\",down=F\n",
+			precision: time.Nanosecond,
+			expected: []mimirpb.PreallocTimeseries{
+				seriesWithSamples(`my_measurement_up`, []string{"tag_key", "tag,value"}, mimirpb.Sample{TimestampMs: now.UnixMilli(), Value: 1}),
+				seriesWithSamples(`my_measurement_down`, []string{"tag_key", "tag,value"}, mimirpb.Sample{TimestampMs: now.UnixMilli(), Value: 0}),
+			},
+		},
+		"missing fields": {
+			lines:       "cpu,host=a",
+			precision:   time.Nanosecond,
+			expectedErr: "unable to parse line 1: missing fields",
+		},
+		"invalid field value": {
+			lines:       "cpu value=1\ncpu value=abc",
+			precision:   time.Nanosecond,
+			expectedErr: `unable to parse line 2: invalid value of field "value"`,
+		},
+		"invalid timestamp": {
+			lines:       "cpu value=1 abc",
+			precision:   time.Nanosecond,
+			expectedErr: `unable to parse line 1: invalid timestamp "abc"`,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			timeseries, err := influxLinesToTimeseries(testCase.lines, testCase.precision, now, testCase.addSuffixes)
+			if testCase.expectedErr != "" {
+				require.ErrorContains(t, err, testCase.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assertSeriesWithSamples(t, testCase.expected, timeseries)
+		})
+	}
+}
+
+func TestInfluxHandler(t *testing.T) {
+	testCases := map[string]struct {
+		query           string
+		body            string
+		contentEncoding string
+		compress        bool
+		expectedCode    int
+		expectedSeries  int
+	}{
+		"valid request": {
+			query:          "?precision=s",
+			body:           "cpu,host=a usage_idle=90,usage_user=2 1717243200\nmem,host=a used=100i 1717243200\n",
+			expectedCode:   http.StatusOK,
+			expectedSeries: 3,
+		},
+		"valid request with gzip compression": {
+			body:            "cpu value=1\n",
+			contentEncoding: "gzip",
+			compress:        true,
+			expectedCode:    http.StatusOK,
+			expectedSeries:  1,
+		},
+		"valid request with zstd compression": {
+			body:            "cpu value=1\n",
+			contentEncoding: "zstd",
+			compress:        true,
+			expectedCode:    http.StatusOK,
+			expectedSeries:  1,
+		},
+		"valid request with lz4 compression": {
+			body:            "cpu value=1\n",
+			contentEncoding: "lz4",
+			compress:        true,
+			expectedCode:    http.StatusOK,
+			expectedSeries:  1,
+		},
+		"invalid precision": {
+			query:        "?precision=d",
+			body:         "cpu value=1",
+			expectedCode: http.StatusBadRequest,
+		},
+		"invalid line": {
+			body:         "cpu",
+			expectedCode: http.StatusBadRequest,
+		},
+		"unsupported compression": {
+			body:            "cpu value=1",
+			contentEncoding: "br",
+			expectedCode:    http.StatusUnsupportedMediaType,
+		},
+		"request too large": {
+			body:         "cpu value=1 " + strings.Repeat("1", 200),
+			expectedCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			limits, err := validation.NewOverrides(validation.Limits{}, nil)
+			require.NoError(t, err)
+
+			var pushedSeries int
+			handler := InfluxHandler(100, nil, false, limits, RetryConfig{}, nil, func(_ context.Context, pushReq *Request) error {
+				defer pushReq.CleanUp()
+				req, err := pushReq.WriteRequest()
+				if err != nil {
+					return err
+				}
+				pushedSeries = len(req.Timeseries)
+				return nil
+			}, log.NewNopLogger())
+
+			body := []byte(testCase.body)
+			if testCase.compress {
+				body = compressRequestBody(t, testCase.contentEncoding, body)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/push/influx/write"+testCase.query, io.NopCloser(bytes.NewReader(body)))
+			req = req.WithContext(user.InjectOrgID(req.Context(), "test"))
+			if testCase.contentEncoding != "" {
+				req.Header.Set("Content-Encoding", testCase.contentEncoding)
+			}
+
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			assert.Equal(t, testCase.expectedCode, resp.Code, resp.Body.String())
+			assert.Equal(t, testCase.expectedSeries, pushedSeries)
+		})
+	}
+}
+
+func seriesWithSamples(name string, lbls []string, samples ...mimirpb.Sample) mimirpb.PreallocTimeseries {
+	labels := []mimirpb.LabelAdapter{{Name: "__name__", Value: name}}
+	for i := 0; i < len(lbls); i += 2 {
+		labels = append(labels, mimirpb.LabelAdapter{Name: lbls[i], Value: lbls[i+1]})
+	}
+	return mimirpb.PreallocTimeseries{TimeSeries: &mimirpb.TimeSeries{
+		Labels:  labels,
+		Samples: samples,
+	}}
+}
+
+// assertSeriesWithSamples compares the labels and samples of the series, which are taken from a pool by the handlers
+// and so may or may not have empty exemplars and histograms.
+func assertSeriesWithSamples(t *testing.T, expected, actual []mimirpb.PreallocTimeseries) {
+	t.Helper()
+
+	require.Len(t, actual, len(expected))
+	for i := range expected {
+		assert.Equal(t, expected[i].Labels, actual[i].Labels)
+		assert.Equal(t, expected[i].Samples, actual[i].Samples)
+	}
+}
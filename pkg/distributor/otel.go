@@ -4,7 +4,6 @@ package distributor
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
 	"io"
 	"net/http"
@@ -39,6 +38,9 @@ const (
 
 	otelParseError = "otlp_parse_error"
 	maxErrMsgLen   = 1024
+
+	// otelDeltaDropped is the discard reason of the OTLP delta data points which couldn't be converted to cumulative.
+	otelDeltaDropped = "otlp_delta_dropped"
 )
 
 // OTLPHandler is an http.Handler accepting OTLP write requests.
@@ -48,12 +50,14 @@ func OTLPHandler(
 	allowSkipLabelNameValidation bool,
 	enableOtelMetadataStorage bool,
 	limits *validation.Overrides,
+	deltaToCumulative *deltaToCumulativeConverter,
 	retryCfg RetryConfig,
 	reg prometheus.Registerer,
 	push PushFunc,
 	logger log.Logger,
 ) http.Handler {
 	discardedDueToOtelParseError := validation.DiscardedSamplesCounter(reg, otelParseError)
+	discardedDueToOtelDeltaDropped := validation.DiscardedSamplesCounter(reg, otelDeltaDropped)
 
 	otlpRequestsCounter := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
 		Name: "cortex_distributor_otlp_requests_total",
@@ -67,10 +71,14 @@ func OTLPHandler(
 		switch contentEncoding {
 		case "gzip":
 			compression = util.Gzip
+		case "zstd":
+			compression = util.Zstd
+		case "lz4":
+			compression = util.Lz4
 		case "":
 			compression = util.NoCompression
 		default:
-			return httpgrpc.Errorf(http.StatusUnsupportedMediaType, "unsupported compression: %s. Only \"gzip\" or no compression supported", contentEncoding)
+			return httpgrpc.Errorf(http.StatusUnsupportedMediaType, "unsupported compression: %s. Only \"gzip\", \"zstd\", \"lz4\" or no compression supported", contentEncoding)
 		}
 
 		var decoderFunc func(io.ReadCloser) (pmetricotlp.ExportRequest, error)
@@ -101,17 +109,18 @@ func OTLPHandler(
 					sz += bytes.MinRead
 				}
 				buf := buffers.Get(sz)
-				if compression == util.Gzip {
-					var err error
-					reader, err = gzip.NewReader(reader)
+				if compression != util.NoCompression {
+					decompressingReader, err := util.NewDecompressingReader(reader, compression, maxRecvMsgSize)
 					if err != nil {
-						return exportReq, errors.Wrap(err, "create gzip reader")
+						return exportReq, err
 					}
+					defer decompressingReader.Close()
+					reader = decompressingReader
 				}
 
 				reader = http.MaxBytesReader(nil, reader, int64(maxRecvMsgSize))
 				if _, err := buf.ReadFrom(reader); err != nil {
-					if util.IsRequestBodyTooLarge(err) {
+					if util.IsRequestBodyTooLarge(err) || errors.Is(err, util.MsgSizeTooLargeErr{}) {
 						return exportReq, httpgrpc.Errorf(http.StatusRequestEntityTooLarge, distributorMaxWriteMessageSizeErr{
 							actual: -1,
 							limit:  maxRecvMsgSize,
@@ -157,7 +166,12 @@ func OTLPHandler(
 
 		otlpRequestsCounter.WithLabelValues(tenantID).Inc()
 
-		metrics, err := otelMetricsToTimeseries(tenantID, addSuffixes, discardedDueToOtelParseError, logger, otlpReq.Metrics())
+		var tenantDeltaToCumulative *deltaToCumulativeConverter
+		if limits.OTelConvertDeltaToCumulativeEnabled(tenantID) {
+			tenantDeltaToCumulative = deltaToCumulative
+		}
+
+		metrics, err := otelMetricsToTimeseries(tenantID, addSuffixes, limits.PromoteOTelResourceAttributes(tenantID), tenantDeltaToCumulative, discardedDueToOtelParseError, discardedDueToOtelDeltaDropped, logger, otlpReq.Metrics())
 		if err != nil {
 			return err
 		}
@@ -261,7 +275,20 @@ func otelMetricsToMetadata(addSuffixes bool, md pmetric.Metrics) []*mimirpb.Metr
 	return metadata
 }
 
-func otelMetricsToTimeseries(tenantID string, addSuffixes bool, discardedDueToOtelParseError *prometheus.CounterVec, logger log.Logger, md pmetric.Metrics) ([]mimirpb.PreallocTimeseries, error) {
+// otelMetricsToTimeseries converts the OTLP metrics to series. The resource attributes in promoteResourceAttributes are
+// added as labels to the series of the resource. The sums and histograms with delta temporality are converted to
+// cumulative by deltaToCumulative, if not nil, and are discarded otherwise.
+func otelMetricsToTimeseries(tenantID string, addSuffixes bool, promoteResourceAttributes []string, deltaToCumulative *deltaToCumulativeConverter, discardedDueToOtelParseError, discardedDueToOtelDeltaDropped *prometheus.CounterVec, logger log.Logger, md pmetric.Metrics) ([]mimirpb.PreallocTimeseries, error) {
+	if deltaToCumulative != nil {
+		if dropped := deltaToCumulative.convert(tenantID, md, time.Now()); dropped > 0 {
+			discardedDueToOtelDeltaDropped.WithLabelValues(tenantID, "").Add(float64(dropped))
+			level.Warn(logger).Log("msg", "OTLP delta data points not newer than the last data point of their stream, or exceeding the maximum number of delta streams, have been dropped", "count", dropped)
+		}
+	}
+	if len(promoteResourceAttributes) > 0 {
+		promoteOTelResourceAttributes(md, promoteResourceAttributes)
+	}
+
 	tsMap, errs := prometheusremotewrite.FromMetrics(md, prometheusremotewrite.Settings{
 		AddMetricSuffixes: addSuffixes,
 	})
@@ -289,6 +316,64 @@ func otelMetricsToTimeseries(tenantID string, addSuffixes bool, discardedDueToOt
 	return mimirTs, nil
 }
 
+// promoteOTelResourceAttributes copies the given resource attributes to the attributes of the data points of the
+// resource, which are converted to labels. The attributes of the data points take precedence.
+func promoteOTelResourceAttributes(md pmetric.Metrics, attributes []string) {
+	resourceMetricsSlice := md.ResourceMetrics()
+	for i := 0; i < resourceMetricsSlice.Len(); i++ {
+		resourceMetrics := resourceMetricsSlice.At(i)
+
+		promoted := pcommon.NewMap()
+		for _, name := range attributes {
+			if value, ok := resourceMetrics.Resource().Attributes().Get(name); ok {
+				value.CopyTo(promoted.PutEmpty(name))
+			}
+		}
+		if promoted.Len() == 0 {
+			continue
+		}
+
+		promote := func(dataPointAttributes pcommon.Map) {
+			promoted.Range(func(name string, value pcommon.Value) bool {
+				if _, ok := dataPointAttributes.Get(name); !ok {
+					value.CopyTo(dataPointAttributes.PutEmpty(name))
+				}
+				return true
+			})
+		}
+
+		scopeMetricsSlice := resourceMetrics.ScopeMetrics()
+		for j := 0; j < scopeMetricsSlice.Len(); j++ {
+			metricSlice := scopeMetricsSlice.At(j).Metrics()
+			for k := 0; k < metricSlice.Len(); k++ {
+				metric := metricSlice.At(k)
+				switch metric.Type() {
+				case pmetric.MetricTypeGauge:
+					for x := 0; x < metric.Gauge().DataPoints().Len(); x++ {
+						promote(metric.Gauge().DataPoints().At(x).Attributes())
+					}
+				case pmetric.MetricTypeSum:
+					for x := 0; x < metric.Sum().DataPoints().Len(); x++ {
+						promote(metric.Sum().DataPoints().At(x).Attributes())
+					}
+				case pmetric.MetricTypeHistogram:
+					for x := 0; x < metric.Histogram().DataPoints().Len(); x++ {
+						promote(metric.Histogram().DataPoints().At(x).Attributes())
+					}
+				case pmetric.MetricTypeExponentialHistogram:
+					for x := 0; x < metric.ExponentialHistogram().DataPoints().Len(); x++ {
+						promote(metric.ExponentialHistogram().DataPoints().At(x).Attributes())
+					}
+				case pmetric.MetricTypeSummary:
+					for x := 0; x < metric.Summary().DataPoints().Len(); x++ {
+						promote(metric.Summary().DataPoints().At(x).Attributes())
+					}
+				}
+			}
+		}
+	}
+}
+
 func promToMimirTimeseries(promTs *prompb.TimeSeries) mimirpb.PreallocTimeseries {
 	labels := make([]mimirpb.LabelAdapter, 0, len(promTs.Labels))
 	for _, label := range promTs.Labels {
@@ -0,0 +1,413 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package distributor
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/dskit/services"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// deltaStreamIdleTimeout is the time after which the running totals of a delta stream which hasn't received any data
+// point are dropped. The next data point of the stream starts a new cumulative series.
+const deltaStreamIdleTimeout = 15 * time.Minute
+
+// deltaToCumulativeConverter converts OTLP sums and histograms with delta temporality to cumulative, keeping the running
+// totals of each stream. A stream is identified by the tenant, the resource, the instrumentation scope, the metric and
+// the attributes of the data points. The streams of each tenant are locked separately, and the idle streams are purged
+// by the service in the background.
+type deltaToCumulativeConverter struct {
+	services.Service
+
+	maxStreams func(tenantID string) int
+
+	tenantsMtx sync.RWMutex
+	tenants    map[string]*deltaTenantStreams
+}
+
+// deltaTenantStreams holds the delta streams of a tenant.
+type deltaTenantStreams struct {
+	mtx     sync.Mutex
+	streams map[string]*deltaStream
+
+	// removed is set once the tenant has been purged, and its streams must be looked up again.
+	removed bool
+}
+
+// deltaStream holds the running totals of a delta stream.
+type deltaStream struct {
+	startTimestamp, lastTimestamp pcommon.Timestamp
+	lastSeen                      time.Time
+
+	// Sums. Integer and double values are added up separately, not to lose the precision of large integers.
+	intValue    int64
+	doubleValue float64
+
+	// Histograms and exponential histograms.
+	count uint64
+	sum   float64
+
+	// Histograms.
+	bounds  []float64
+	buckets []uint64
+
+	// Exponential histograms.
+	scale              int32
+	zeroCount          uint64
+	positive, negative deltaExpBuckets
+}
+
+// newDeltaToCumulativeConverter returns a converter keeping at most maxStreams delta streams per tenant, 0 meaning
+// unlimited.
+func newDeltaToCumulativeConverter(maxStreams func(tenantID string) int) *deltaToCumulativeConverter {
+	c := &deltaToCumulativeConverter{
+		maxStreams: maxStreams,
+		tenants:    map[string]*deltaTenantStreams{},
+	}
+	c.Service = services.NewTimerService(deltaStreamIdleTimeout/3, nil, c.iteration, nil)
+	return c
+}
+
+func (c *deltaToCumulativeConverter) iteration(_ context.Context) error {
+	c.purge(time.Now())
+	return nil
+}
+
+// convert converts in place the sums and histograms of md with delta temporality to cumulative. It returns the number
+// of data points which were dropped, either because they weren't newer than the last data point of their stream, or
+// because they would have exceeded the maximum number of streams of the tenant.
+func (c *deltaToCumulativeConverter) convert(tenantID string, md pmetric.Metrics, now time.Time) int {
+	maxStreams := c.maxStreams(tenantID)
+
+	t := c.tenantStreams(tenantID)
+	t.mtx.Lock()
+	for t.removed {
+		t.mtx.Unlock()
+		t = c.tenantStreams(tenantID)
+		t.mtx.Lock()
+	}
+	defer t.mtx.Unlock()
+
+	dropped := 0
+	resourceMetricsSlice := md.ResourceMetrics()
+	for i := 0; i < resourceMetricsSlice.Len(); i++ {
+		resourceMetrics := resourceMetricsSlice.At(i)
+		resourceKey := deltaStreamAttributesKey(resourceMetrics.Resource().Attributes())
+
+		scopeMetricsSlice := resourceMetrics.ScopeMetrics()
+		for j := 0; j < scopeMetricsSlice.Len(); j++ {
+			scopeMetrics := scopeMetricsSlice.At(j)
+			scope := scopeMetrics.Scope()
+			scopeKey := resourceKey + deltaStreamKeySeparator + scope.Name() + deltaStreamKeySeparator + scope.Version()
+
+			// The metrics whose data points have all been dropped are removed, not to be discarded again as empty.
+			scopeMetrics.Metrics().RemoveIf(func(metric pmetric.Metric) bool {
+				droppedBefore := dropped
+				metricKey := scopeKey + deltaStreamKeySeparator + metric.Type().String() + deltaStreamKeySeparator + metric.Name()
+
+				switch metric.Type() {
+				case pmetric.MetricTypeSum:
+					if metric.Sum().AggregationTemporality() != pmetric.AggregationTemporalityDelta {
+						return false
+					}
+					metric.Sum().DataPoints().RemoveIf(func(pt pmetric.NumberDataPoint) bool {
+						s, _, ok := t.stream(metricKey, pt.Attributes(), pt.StartTimestamp(), pt.Timestamp(), maxStreams, now)
+						if !ok {
+							dropped++
+							return true
+						}
+						s.addNumberDataPoint(pt)
+						return false
+					})
+					metric.Sum().SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+					return dropped > droppedBefore && metric.Sum().DataPoints().Len() == 0
+
+				case pmetric.MetricTypeHistogram:
+					if metric.Histogram().AggregationTemporality() != pmetric.AggregationTemporalityDelta {
+						return false
+					}
+					metric.Histogram().DataPoints().RemoveIf(func(pt pmetric.HistogramDataPoint) bool {
+						s, isNew, ok := t.stream(metricKey, pt.Attributes(), pt.StartTimestamp(), pt.Timestamp(), maxStreams, now)
+						if !ok {
+							dropped++
+							return true
+						}
+						s.addHistogramDataPoint(pt, isNew, now)
+						return false
+					})
+					metric.Histogram().SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+					return dropped > droppedBefore && metric.Histogram().DataPoints().Len() == 0
+
+				case pmetric.MetricTypeExponentialHistogram:
+					if metric.ExponentialHistogram().AggregationTemporality() != pmetric.AggregationTemporalityDelta {
+						return false
+					}
+					metric.ExponentialHistogram().DataPoints().RemoveIf(func(pt pmetric.ExponentialHistogramDataPoint) bool {
+						s, isNew, ok := t.stream(metricKey, pt.Attributes(), pt.StartTimestamp(), pt.Timestamp(), maxStreams, now)
+						if !ok {
+							dropped++
+							return true
+						}
+						s.addExponentialHistogramDataPoint(pt, isNew)
+						return false
+					})
+					metric.ExponentialHistogram().SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+					return dropped > droppedBefore && metric.ExponentialHistogram().DataPoints().Len() == 0
+				}
+				return false
+			})
+		}
+	}
+
+	return dropped
+}
+
+// tenantStreams returns the delta streams of the tenant, creating them if needed.
+func (c *deltaToCumulativeConverter) tenantStreams(tenantID string) *deltaTenantStreams {
+	c.tenantsMtx.RLock()
+	t, ok := c.tenants[tenantID]
+	c.tenantsMtx.RUnlock()
+	if ok {
+		return t
+	}
+
+	c.tenantsMtx.Lock()
+	defer c.tenantsMtx.Unlock()
+
+	if t, ok = c.tenants[tenantID]; !ok {
+		t = &deltaTenantStreams{streams: map[string]*deltaStream{}}
+		c.tenants[tenantID] = t
+	}
+	return t
+}
+
+// purge drops the idle streams, and the tenants left without any stream.
+func (c *deltaToCumulativeConverter) purge(now time.Time) {
+	c.tenantsMtx.RLock()
+	tenantIDs := make([]string, 0, len(c.tenants))
+	for tenantID := range c.tenants {
+		tenantIDs = append(tenantIDs, tenantID)
+	}
+	c.tenantsMtx.RUnlock()
+
+	for _, tenantID := range tenantIDs {
+		c.tenantsMtx.RLock()
+		t := c.tenants[tenantID]
+		c.tenantsMtx.RUnlock()
+		if t == nil || t.purge(now) > 0 {
+			continue
+		}
+
+		// The tenant is removed while holding both locks, so that no stream can be added to it in the meantime.
+		c.tenantsMtx.Lock()
+		t.mtx.Lock()
+		if len(t.streams) == 0 {
+			delete(c.tenants, tenantID)
+			t.removed = true
+		}
+		t.mtx.Unlock()
+		c.tenantsMtx.Unlock()
+	}
+}
+
+// stream returns the running totals of the stream of a data point, and whether the stream is new. It returns false if
+// the data point isn't newer than the last data point of the stream, or if the stream is new and the tenant already
+// has maxStreams streams. Must be called with the lock held.
+func (t *deltaTenantStreams) stream(metricKey string, attributes pcommon.Map, start, ts pcommon.Timestamp, maxStreams int, now time.Time) (*deltaStream, bool, bool) {
+	key := metricKey + deltaStreamKeySeparator + deltaStreamAttributesKey(attributes)
+
+	s, ok := t.streams[key]
+	if !ok && maxStreams > 0 && len(t.streams) >= maxStreams {
+		return nil, false, false
+	}
+	if !ok || now.Sub(s.lastSeen) > deltaStreamIdleTimeout {
+		s = &deltaStream{}
+		s.reset(start, ts, now)
+		t.streams[key] = s
+		return s, true, true
+	}
+	if ts <= s.lastTimestamp {
+		return nil, false, false
+	}
+
+	s.lastTimestamp, s.lastSeen = ts, now
+	return s, false, true
+}
+
+// purge drops the idle streams, and returns the number of streams left.
+func (t *deltaTenantStreams) purge(now time.Time) int {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for key, s := range t.streams {
+		if now.Sub(s.lastSeen) > deltaStreamIdleTimeout {
+			delete(t.streams, key)
+		}
+	}
+	return len(t.streams)
+}
+
+// reset drops the running totals of the stream, which restarts from the data point with the given timestamps.
+func (s *deltaStream) reset(start, ts pcommon.Timestamp, now time.Time) {
+	if start == 0 {
+		start = ts
+	}
+	*s = deltaStream{startTimestamp: start, lastTimestamp: ts, lastSeen: now}
+}
+
+func (s *deltaStream) addNumberDataPoint(pt pmetric.NumberDataPoint) {
+	pt.SetStartTimestamp(s.startTimestamp)
+	if pt.Flags().NoRecordedValue() {
+		return
+	}
+
+	switch pt.ValueType() {
+	case pmetric.NumberDataPointValueTypeInt:
+		s.intValue += pt.IntValue()
+		pt.SetIntValue(s.intValue)
+	case pmetric.NumberDataPointValueTypeDouble:
+		s.doubleValue += pt.DoubleValue()
+		pt.SetDoubleValue(s.doubleValue)
+	}
+}
+
+func (s *deltaStream) addHistogramDataPoint(pt pmetric.HistogramDataPoint, isNew bool, now time.Time) {
+	// The buckets can only be added up if they have the same bounds. Otherwise, the stream restarts from this data point.
+	if bounds := pt.ExplicitBounds().AsRaw(); isNew || !slices.Equal(s.bounds, bounds) {
+		s.reset(pt.StartTimestamp(), pt.Timestamp(), now)
+		s.bounds = bounds
+	}
+
+	pt.SetStartTimestamp(s.startTimestamp)
+	// The min and max of the data point are those of its delta, and can't be made cumulative.
+	pt.RemoveMin()
+	pt.RemoveMax()
+	if pt.Flags().NoRecordedValue() {
+		return
+	}
+
+	s.count += pt.Count()
+	pt.SetCount(s.count)
+	if pt.HasSum() {
+		s.sum += pt.Sum()
+		pt.SetSum(s.sum)
+	}
+
+	for i := 0; i < pt.BucketCounts().Len(); i++ {
+		if i == len(s.buckets) {
+			s.buckets = append(s.buckets, 0)
+		}
+		s.buckets[i] += pt.BucketCounts().At(i)
+	}
+	pt.BucketCounts().FromRaw(slices.Clone(s.buckets))
+}
+
+func (s *deltaStream) addExponentialHistogramDataPoint(pt pmetric.ExponentialHistogramDataPoint, isNew bool) {
+	if isNew {
+		s.scale = pt.Scale()
+	}
+
+	pt.SetStartTimestamp(s.startTimestamp)
+	pt.RemoveMin()
+	pt.RemoveMax()
+	if pt.Flags().NoRecordedValue() {
+		return
+	}
+
+	// The buckets are added up at the lowest scale of the stream so far.
+	scale := min(s.scale, pt.Scale())
+	s.positive.downscale(s.scale - scale)
+	s.negative.downscale(s.scale - scale)
+	s.scale = scale
+	s.positive.add(pt.Positive(), pt.Scale()-scale)
+	s.negative.add(pt.Negative(), pt.Scale()-scale)
+
+	s.count += pt.Count()
+	s.zeroCount += pt.ZeroCount()
+	if pt.HasSum() {
+		s.sum += pt.Sum()
+		pt.SetSum(s.sum)
+	}
+
+	pt.SetScale(s.scale)
+	pt.SetCount(s.count)
+	pt.SetZeroCount(s.zeroCount)
+	s.positive.copyTo(pt.Positive())
+	s.negative.copyTo(pt.Negative())
+}
+
+// deltaExpBuckets holds the running totals of the positive or negative buckets of an exponential histogram.
+type deltaExpBuckets struct {
+	offset int32
+	counts []uint64
+}
+
+// downscale merges the buckets to reduce their scale by the given amount.
+func (b *deltaExpBuckets) downscale(by int32) {
+	if by == 0 || len(b.counts) == 0 {
+		return
+	}
+
+	offset := b.offset >> by
+	counts := make([]uint64, (b.offset+int32(len(b.counts))-1)>>by-offset+1)
+	for i, count := range b.counts {
+		counts[(b.offset+int32(i))>>by-offset] += count
+	}
+	b.offset, b.counts = offset, counts
+}
+
+// add adds the buckets, once their scale is reduced by scaleDown.
+func (b *deltaExpBuckets) add(buckets pmetric.ExponentialHistogramDataPointBuckets, scaleDown int32) {
+	for i := 0; i < buckets.BucketCounts().Len(); i++ {
+		if count := buckets.BucketCounts().At(i); count > 0 {
+			b.increment((buckets.Offset()+int32(i))>>scaleDown, count)
+		}
+	}
+}
+
+func (b *deltaExpBuckets) increment(idx int32, count uint64) {
+	switch {
+	case len(b.counts) == 0:
+		b.offset, b.counts = idx, []uint64{0}
+	case idx < b.offset:
+		b.counts = append(make([]uint64, b.offset-idx), b.counts...)
+		b.offset = idx
+	case idx >= b.offset+int32(len(b.counts)):
+		b.counts = append(b.counts, make([]uint64, idx-b.offset-int32(len(b.counts))+1)...)
+	}
+	b.counts[idx-b.offset] += count
+}
+
+func (b *deltaExpBuckets) copyTo(buckets pmetric.ExponentialHistogramDataPointBuckets) {
+	buckets.SetOffset(b.offset)
+	buckets.BucketCounts().FromRaw(slices.Clone(b.counts))
+}
+
+const deltaStreamKeySeparator = "\xff"
+
+// deltaStreamAttributesKey returns a key identifying the attributes, regardless of their order.
+func deltaStreamAttributesKey(attributes pcommon.Map) string {
+	keys := make([]string, 0, attributes.Len())
+	attributes.Range(func(k string, _ pcommon.Value) bool {
+		keys = append(keys, k)
+		return true
+	})
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		v, _ := attributes.Get(k)
+		b.WriteString(k)
+		b.WriteString(deltaStreamKeySeparator)
+		b.WriteString(v.AsString())
+		b.WriteString(deltaStreamKeySeparator)
+	}
+	return b.String()
+}
@@ -0,0 +1,297 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package distributor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+func TestDeltaToCumulativeConverter_Sum(t *testing.T) {
+	now := time.Now()
+	ts := func(seconds int) pcommon.Timestamp {
+		return pcommon.NewTimestampFromTime(time.Unix(1717243200+int64(seconds), 0))
+	}
+
+	deltaSum := func(host string, points ...[3]int) pmetric.Metrics {
+		md := pmetric.NewMetrics()
+		rm := md.ResourceMetrics().AppendEmpty()
+		rm.Resource().Attributes().PutStr("service.name", "api")
+		m := rm.ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+		m.SetName("requests")
+		m.SetEmptySum().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+		m.Sum().SetIsMonotonic(true)
+		for _, p := range points {
+			pt := m.Sum().DataPoints().AppendEmpty()
+			pt.Attributes().PutStr("host", host)
+			pt.SetStartTimestamp(ts(p[0]))
+			pt.SetTimestamp(ts(p[1]))
+			pt.SetIntValue(int64(p[2]))
+		}
+		return md
+	}
+
+	values := func(md pmetric.Metrics) (values []int64, starts []pcommon.Timestamp) {
+		sum := md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Sum()
+		assert.Equal(t, pmetric.AggregationTemporalityCumulative, sum.AggregationTemporality())
+		for i := 0; i < sum.DataPoints().Len(); i++ {
+			values = append(values, sum.DataPoints().At(i).IntValue())
+			starts = append(starts, sum.DataPoints().At(i).StartTimestamp())
+		}
+		return values, starts
+	}
+
+	c := newDeltaToCumulativeConverter(func(string) int { return 0 })
+
+	md := deltaSum("a", [3]int{0, 10, 5}, [3]int{10, 20, 3})
+	require.Equal(t, 0, c.convert("user-1", md, now))
+	v, starts := values(md)
+	assert.Equal(t, []int64{5, 8}, v)
+	assert.Equal(t, []pcommon.Timestamp{ts(0), ts(0)}, starts)
+
+	// The running totals are kept across requests.
+	md = deltaSum("a", [3]int{20, 30, 2})
+	require.Equal(t, 0, c.convert("user-1", md, now))
+	v, starts = values(md)
+	assert.Equal(t, []int64{10}, v)
+	assert.Equal(t, []pcommon.Timestamp{ts(0)}, starts)
+
+	// Data points not newer than the last data point of their stream are dropped.
+	md = deltaSum("a", [3]int{10, 20, 3}, [3]int{30, 40, 1})
+	require.Equal(t, 1, c.convert("user-1", md, now))
+	v, _ = values(md)
+	assert.Equal(t, []int64{11}, v)
+
+	// Other attributes and other tenants have their own streams.
+	md = deltaSum("b", [3]int{30, 40, 1})
+	require.Equal(t, 0, c.convert("user-1", md, now))
+	v, starts = values(md)
+	assert.Equal(t, []int64{1}, v)
+	assert.Equal(t, []pcommon.Timestamp{ts(30)}, starts)
+
+	md = deltaSum("a", [3]int{30, 40, 1})
+	require.Equal(t, 0, c.convert("user-2", md, now))
+	v, _ = values(md)
+	assert.Equal(t, []int64{1}, v)
+
+	// Idle streams restart from their next data point.
+	md = deltaSum("a", [3]int{40, 50, 4})
+	require.Equal(t, 0, c.convert("user-1", md, now.Add(deltaStreamIdleTimeout+time.Minute)))
+	v, starts = values(md)
+	assert.Equal(t, []int64{4}, v)
+	assert.Equal(t, []pcommon.Timestamp{ts(40)}, starts)
+
+	// The idle streams are purged, and so are the tenants left without any stream.
+	c.purge(now.Add(deltaStreamIdleTimeout + time.Minute))
+	require.Contains(t, c.tenants, "user-1")
+	assert.Len(t, c.tenants["user-1"].streams, 1)
+	assert.NotContains(t, c.tenants, "user-2")
+}
+
+func TestDeltaToCumulativeConverter_SumIntAndDoubleValues(t *testing.T) {
+	deltaSum := func(ts int64, value any) pmetric.Metrics {
+		md := pmetric.NewMetrics()
+		m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+		m.SetName("requests")
+		m.SetEmptySum().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+		pt := m.Sum().DataPoints().AppendEmpty()
+		pt.SetTimestamp(pcommon.Timestamp(ts))
+		switch v := value.(type) {
+		case int64:
+			pt.SetIntValue(v)
+		case float64:
+			pt.SetDoubleValue(v)
+		}
+		return md
+	}
+	dataPoint := func(md pmetric.Metrics) pmetric.NumberDataPoint {
+		return md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Sum().DataPoints().At(0)
+	}
+
+	c := newDeltaToCumulativeConverter(func(string) int { return 0 })
+	now := time.Now()
+
+	// Integers beyond the precision of a float64 are added up exactly.
+	md := deltaSum(1, int64(1<<53+1))
+	require.Equal(t, 0, c.convert("user-1", md, now))
+	assert.Equal(t, int64(1<<53+1), dataPoint(md).IntValue())
+
+	md = deltaSum(2, int64(2))
+	require.Equal(t, 0, c.convert("user-1", md, now))
+	assert.Equal(t, int64(1<<53+3), dataPoint(md).IntValue())
+
+	// Double values have their own running total.
+	md = deltaSum(3, 0.5)
+	require.Equal(t, 0, c.convert("user-1", md, now))
+	assert.Equal(t, 0.5, dataPoint(md).DoubleValue())
+}
+
+func TestDeltaToCumulativeConverter_MaxStreams(t *testing.T) {
+	deltaSum := func(hosts ...string) pmetric.Metrics {
+		md := pmetric.NewMetrics()
+		m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+		m.SetName("requests")
+		m.SetEmptySum().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+		for _, host := range hosts {
+			pt := m.Sum().DataPoints().AppendEmpty()
+			pt.Attributes().PutStr("host", host)
+			pt.SetTimestamp(pcommon.NewTimestampFromTime(time.Now()))
+			pt.SetIntValue(1)
+		}
+		return md
+	}
+
+	c := newDeltaToCumulativeConverter(func(tenantID string) int {
+		if tenantID == "user-1" {
+			return 2
+		}
+		return 0
+	})
+	now := time.Now()
+
+	// The data points of the streams exceeding the limit are dropped.
+	require.Equal(t, 1, c.convert("user-1", deltaSum("a", "b", "c"), now))
+	assert.Len(t, c.tenants["user-1"].streams, 2)
+
+	// The existing streams keep being converted.
+	require.Equal(t, 0, c.convert("user-1", deltaSum("a"), now))
+
+	// The limit is per tenant.
+	require.Equal(t, 0, c.convert("user-2", deltaSum("a", "b", "c"), now))
+	assert.Len(t, c.tenants["user-2"].streams, 3)
+}
+
+func TestDeltaToCumulativeConverter_Histogram(t *testing.T) {
+	now := time.Now()
+	ts := func(seconds int) pcommon.Timestamp {
+		return pcommon.NewTimestampFromTime(time.Unix(1717243200+int64(seconds), 0))
+	}
+
+	deltaHistogram := func(start, end int, bounds []float64, buckets []uint64, sum float64) pmetric.Metrics {
+		md := pmetric.NewMetrics()
+		m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+		m.SetName("latency")
+		m.SetEmptyHistogram().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+		pt := m.Histogram().DataPoints().AppendEmpty()
+		pt.SetStartTimestamp(ts(start))
+		pt.SetTimestamp(ts(end))
+		pt.ExplicitBounds().FromRaw(bounds)
+		pt.BucketCounts().FromRaw(buckets)
+		var count uint64
+		for _, b := range buckets {
+			count += b
+		}
+		pt.SetCount(count)
+		pt.SetSum(sum)
+		pt.SetMin(0.1)
+		return md
+	}
+
+	dataPoint := func(md pmetric.Metrics) pmetric.HistogramDataPoint {
+		h := md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Histogram()
+		assert.Equal(t, pmetric.AggregationTemporalityCumulative, h.AggregationTemporality())
+		require.Equal(t, 1, h.DataPoints().Len())
+		return h.DataPoints().At(0)
+	}
+
+	c := newDeltaToCumulativeConverter(func(string) int { return 0 })
+
+	md := deltaHistogram(0, 10, []float64{1, 5}, []uint64{1, 2, 0}, 6)
+	require.Equal(t, 0, c.convert("user-1", md, now))
+
+	md = deltaHistogram(10, 20, []float64{1, 5}, []uint64{0, 1, 3}, 30)
+	require.Equal(t, 0, c.convert("user-1", md, now))
+	pt := dataPoint(md)
+	assert.Equal(t, ts(0), pt.StartTimestamp())
+	assert.Equal(t, []uint64{1, 3, 3}, pt.BucketCounts().AsRaw())
+	assert.Equal(t, uint64(7), pt.Count())
+	assert.Equal(t, 36.0, pt.Sum())
+	assert.False(t, pt.HasMin())
+
+	// The stream restarts when the bounds of the buckets change.
+	md = deltaHistogram(20, 30, []float64{1, 10}, []uint64{1, 1, 1}, 20)
+	require.Equal(t, 0, c.convert("user-1", md, now))
+	pt = dataPoint(md)
+	assert.Equal(t, ts(20), pt.StartTimestamp())
+	assert.Equal(t, []uint64{1, 1, 1}, pt.BucketCounts().AsRaw())
+	assert.Equal(t, uint64(3), pt.Count())
+	assert.Equal(t, 20.0, pt.Sum())
+}
+
+func TestDeltaToCumulativeConverter_ExponentialHistogram(t *testing.T) {
+	now := time.Now()
+	ts := func(seconds int) pcommon.Timestamp {
+		return pcommon.NewTimestampFromTime(time.Unix(1717243200+int64(seconds), 0))
+	}
+
+	deltaExpHistogram := func(start, end int, scale, offset int32, positive []uint64, zeroCount uint64) pmetric.Metrics {
+		md := pmetric.NewMetrics()
+		m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+		m.SetName("latency")
+		m.SetEmptyExponentialHistogram().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+		pt := m.ExponentialHistogram().DataPoints().AppendEmpty()
+		pt.SetStartTimestamp(ts(start))
+		pt.SetTimestamp(ts(end))
+		pt.SetScale(scale)
+		pt.SetZeroCount(zeroCount)
+		pt.Positive().SetOffset(offset)
+		pt.Positive().BucketCounts().FromRaw(positive)
+		count := zeroCount
+		for _, b := range positive {
+			count += b
+		}
+		pt.SetCount(count)
+		return md
+	}
+
+	dataPoint := func(md pmetric.Metrics) pmetric.ExponentialHistogramDataPoint {
+		h := md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).ExponentialHistogram()
+		assert.Equal(t, pmetric.AggregationTemporalityCumulative, h.AggregationTemporality())
+		require.Equal(t, 1, h.DataPoints().Len())
+		return h.DataPoints().At(0)
+	}
+
+	c := newDeltaToCumulativeConverter(func(string) int { return 0 })
+
+	md := deltaExpHistogram(0, 10, 2, 3, []uint64{1, 2}, 1)
+	require.Equal(t, 0, c.convert("user-1", md, now))
+	pt := dataPoint(md)
+	assert.Equal(t, int32(2), pt.Scale())
+	assert.Equal(t, int32(3), pt.Positive().Offset())
+	assert.Equal(t, []uint64{1, 2}, pt.Positive().BucketCounts().AsRaw())
+
+	// Buckets at the same scale are added up, extending the range of the buckets.
+	md = deltaExpHistogram(10, 20, 2, 1, []uint64{1, 0, 0, 1}, 0)
+	require.Equal(t, 0, c.convert("user-1", md, now))
+	pt = dataPoint(md)
+	assert.Equal(t, ts(0), pt.StartTimestamp())
+	assert.Equal(t, int32(2), pt.Scale())
+	assert.Equal(t, int32(1), pt.Positive().Offset())
+	assert.Equal(t, []uint64{1, 0, 1, 3}, pt.Positive().BucketCounts().AsRaw())
+	assert.Equal(t, uint64(6), pt.Count())
+	assert.Equal(t, uint64(1), pt.ZeroCount())
+
+	// A data point with a lower scale downscales the running totals: buckets 1, 2, 3, 4 at scale 2 become
+	// buckets 0, 1, 1, 2 at scale 1.
+	md = deltaExpHistogram(20, 30, 1, 2, []uint64{1}, 0)
+	require.Equal(t, 0, c.convert("user-1", md, now))
+	pt = dataPoint(md)
+	assert.Equal(t, int32(1), pt.Scale())
+	assert.Equal(t, int32(0), pt.Positive().Offset())
+	assert.Equal(t, []uint64{1, 1, 4}, pt.Positive().BucketCounts().AsRaw())
+	assert.Equal(t, uint64(7), pt.Count())
+
+	// A data point with a higher scale is downscaled to the scale of the running totals.
+	md = deltaExpHistogram(30, 40, 3, 0, []uint64{1, 1, 1}, 0)
+	require.Equal(t, 0, c.convert("user-1", md, now))
+	pt = dataPoint(md)
+	assert.Equal(t, int32(1), pt.Scale())
+	assert.Equal(t, int32(0), pt.Positive().Offset())
+	assert.Equal(t, []uint64{4, 1, 4}, pt.Positive().BucketCounts().AsRaw())
+	assert.Equal(t, uint64(10), pt.Count())
+}
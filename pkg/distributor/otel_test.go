@@ -15,9 +15,13 @@ import (
 	"github.com/go-kit/log"
 	"github.com/grafana/dskit/user"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/prometheus/prompb"
 	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
 	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
 
 	"github.com/grafana/mimir/pkg/mimirpb"
@@ -67,7 +71,7 @@ func BenchmarkOTLPHandler(b *testing.B) {
 		validation.NewMockTenantLimits(map[string]*validation.Limits{}),
 	)
 	require.NoError(b, err)
-	handler := OTLPHandler(100000, nil, false, true, limits, RetryConfig{}, prometheus.NewPedanticRegistry(), pushFunc, log.NewNopLogger())
+	handler := OTLPHandler(100000, nil, false, true, limits, nil, RetryConfig{}, prometheus.NewPedanticRegistry(), pushFunc, log.NewNopLogger())
 
 	b.Run("protobuf", func(b *testing.B) {
 		req := createOTLPProtoRequest(b, exportReq, false)
@@ -164,3 +168,127 @@ func (r *reusableReader) Reset() {
 }
 
 var _ io.ReadCloser = &reusableReader{}
+
+func TestOTelMetricsToTimeseries(t *testing.T) {
+	ts := pcommon.NewTimestampFromTime(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	newMetrics := func() (pmetric.Metrics, pmetric.MetricSlice) {
+		md := pmetric.NewMetrics()
+		rm := md.ResourceMetrics().AppendEmpty()
+		rm.Resource().Attributes().PutStr("service.name", "api")
+		rm.Resource().Attributes().PutStr("k8s.namespace.name", "prod")
+		rm.Resource().Attributes().PutStr("k8s.pod.name", "api-1")
+		rm.Resource().Attributes().PutStr("cloud.region", "eu")
+		return md, rm.ScopeMetrics().AppendEmpty().Metrics()
+	}
+
+	seriesByLabels := func(t *testing.T, timeseries []mimirpb.PreallocTimeseries) map[string]mimirpb.PreallocTimeseries {
+		t.Cleanup(func() { mimirpb.ReuseSlice(timeseries) })
+		series := map[string]mimirpb.PreallocTimeseries{}
+		for _, s := range timeseries {
+			series[mimirpb.FromLabelAdaptersToString(s.Labels)] = s
+		}
+		return series
+	}
+
+	t.Run("promoted resource attributes", func(t *testing.T) {
+		md, metrics := newMetrics()
+		gauge := metrics.AppendEmpty()
+		gauge.SetName("temperature")
+		pt := gauge.SetEmptyGauge().DataPoints().AppendEmpty()
+		pt.SetTimestamp(ts)
+		pt.SetDoubleValue(21.5)
+		// Data point attributes take precedence over resource attributes.
+		pt.Attributes().PutStr("cloud.region", "us")
+
+		summary := metrics.AppendEmpty()
+		summary.SetName("latency")
+		spt := summary.SetEmptySummary().DataPoints().AppendEmpty()
+		spt.SetTimestamp(ts)
+		spt.SetCount(2)
+		spt.SetSum(3)
+		quantile := spt.QuantileValues().AppendEmpty()
+		quantile.SetQuantile(0.5)
+		quantile.SetValue(1.5)
+
+		discarded := validation.DiscardedSamplesCounter(prometheus.NewPedanticRegistry(), otelParseError)
+		timeseries, err := otelMetricsToTimeseries("test", false, []string{"k8s.namespace.name", "cloud.region", "missing"}, nil, discarded, discarded, log.NewNopLogger(), md)
+		require.NoError(t, err)
+
+		series := seriesByLabels(t, timeseries)
+		require.Contains(t, series, `temperature{cloud_region="us", job="api", k8s_namespace_name="prod"}`)
+		require.Contains(t, series, `latency{cloud_region="eu", job="api", k8s_namespace_name="prod", quantile="0.5"}`)
+		require.Contains(t, series, `latency_sum{cloud_region="eu", job="api", k8s_namespace_name="prod"}`)
+		// The target_info series still holds all the resource attributes.
+		require.Contains(t, series, `target_info{cloud_region="eu", job="api", k8s_namespace_name="prod", k8s_pod_name="api-1"}`)
+	})
+
+	t.Run("delta temporality", func(t *testing.T) {
+		newDeltaSum := func() pmetric.Metrics {
+			md, metrics := newMetrics()
+			sum := metrics.AppendEmpty()
+			sum.SetName("requests")
+			sum.SetEmptySum().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+			sum.Sum().SetIsMonotonic(true)
+			pt := sum.Sum().DataPoints().AppendEmpty()
+			pt.SetStartTimestamp(ts - 10e9)
+			pt.SetTimestamp(ts)
+			pt.SetIntValue(3)
+			return md
+		}
+
+		reg := prometheus.NewPedanticRegistry()
+		discarded := validation.DiscardedSamplesCounter(reg, otelParseError)
+		discardedDelta := validation.DiscardedSamplesCounter(reg, otelDeltaDropped)
+
+		// Metrics with delta temporality are discarded, unless they're converted to cumulative.
+		timeseries, err := otelMetricsToTimeseries("test", false, nil, nil, discarded, discardedDelta, log.NewNopLogger(), newDeltaSum())
+		require.NoError(t, err)
+		require.NotContains(t, seriesByLabels(t, timeseries), `requests{job="api"}`)
+		require.Equal(t, 1.0, testutil.ToFloat64(discarded.WithLabelValues("test", "")))
+
+		converter := newDeltaToCumulativeConverter(func(string) int { return 0 })
+		timeseries, err = otelMetricsToTimeseries("test", false, nil, converter, discarded, discardedDelta, log.NewNopLogger(), newDeltaSum())
+		require.NoError(t, err)
+		series := seriesByLabels(t, timeseries)
+		require.Contains(t, series, `requests{job="api"}`)
+		assert.Equal(t, []mimirpb.Sample{{TimestampMs: ts.AsTime().UnixMilli(), Value: 3}}, series[`requests{job="api"}`].Samples)
+
+		// The same data point again isn't newer than the last data point of its stream, and is discarded.
+		timeseries, err = otelMetricsToTimeseries("test", false, nil, converter, discarded, discardedDelta, log.NewNopLogger(), newDeltaSum())
+		require.NoError(t, err)
+		series = seriesByLabels(t, timeseries)
+		require.NotContains(t, series, `requests{job="api"}`)
+		require.Equal(t, 1.0, testutil.ToFloat64(discarded.WithLabelValues("test", "")))
+		require.Equal(t, 1.0, testutil.ToFloat64(discardedDelta.WithLabelValues("test", "")))
+	})
+
+	t.Run("exponential histogram with scale above 8", func(t *testing.T) {
+		md, metrics := newMetrics()
+		histogram := metrics.AppendEmpty()
+		histogram.SetName("latency")
+		histogram.SetEmptyExponentialHistogram().SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+		pt := histogram.ExponentialHistogram().DataPoints().AppendEmpty()
+		pt.SetTimestamp(ts)
+		pt.SetScale(10)
+		pt.SetCount(6)
+		pt.SetSum(10)
+		pt.Positive().SetOffset(0)
+		pt.Positive().BucketCounts().FromRaw([]uint64{1, 1, 1, 1, 2})
+
+		discarded := validation.DiscardedSamplesCounter(prometheus.NewPedanticRegistry(), otelParseError)
+		timeseries, err := otelMetricsToTimeseries("test", false, nil, nil, discarded, discarded, log.NewNopLogger(), md)
+		require.NoError(t, err)
+		require.Equal(t, 0.0, testutil.ToFloat64(discarded.WithLabelValues("test", "")))
+
+		series := seriesByLabels(t, timeseries)
+		require.Contains(t, series, `latency{job="api"}`)
+		histograms := series[`latency{job="api"}`].Histograms
+		require.Len(t, histograms, 1)
+		// The buckets are merged 4 by 4 to downscale the histogram to the maximum scale of native histograms.
+		assert.Equal(t, int32(8), histograms[0].Schema)
+		assert.Equal(t, uint64(6), histograms[0].GetCountInt())
+		assert.Equal(t, []mimirpb.BucketSpan{{Offset: 1, Length: 2}}, histograms[0].PositiveSpans)
+		assert.Equal(t, []int64{4, -2}, histograms[0].PositiveDeltas)
+	})
+}
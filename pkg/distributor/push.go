@@ -7,17 +7,21 @@ package distributor
 
 import (
 	"bytes"
+	"compress/zlib"
 	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"math/rand"
+	"mime"
 	"net/http"
 	"strconv"
 	"sync"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/gogo/protobuf/proto"
 	"github.com/grafana/dskit/httpgrpc"
 	"github.com/grafana/dskit/httpgrpc/server"
 	"github.com/grafana/dskit/middleware"
@@ -50,6 +54,15 @@ var (
 const (
 	SkipLabelNameValidationHeader = "X-Mimir-SkipLabelNameValidation"
 	statusClientClosedRequest     = 499
+
+	// Protobuf messages of the Prometheus Remote-Write protocol, negotiated through the "proto" parameter of the Content-Type header.
+	remoteWriteV1ProtoMsg = "prometheus.WriteRequest"
+	remoteWriteV2ProtoMsg = "io.prometheus.write.v2.Request"
+
+	// Response headers of the Prometheus Remote-Write 2.0 protocol.
+	remoteWriteWrittenSamplesHeader    = "X-Prometheus-Remote-Write-Samples-Written"
+	remoteWriteWrittenHistogramsHeader = "X-Prometheus-Remote-Write-Histograms-Written"
+	remoteWriteWrittenExemplarsHeader  = "X-Prometheus-Remote-Write-Exemplars-Written"
 )
 
 type RetryConfig struct {
@@ -86,14 +99,160 @@ func Handler(
 	logger log.Logger,
 ) http.Handler {
 	return handler(maxRecvMsgSize, sourceIPs, allowSkipLabelNameValidation, limits, retryCfg, push, logger, func(ctx context.Context, r *http.Request, maxRecvMsgSize int, buffers *util.RequestBuffers, req *mimirpb.PreallocWriteRequest, _ log.Logger) error {
-		err := util.ParseProtoReader(ctx, r.Body, int(r.ContentLength), maxRecvMsgSize, buffers, req, util.RawSnappy)
-		if errors.Is(err, util.MsgSizeTooLargeErr{}) {
-			err = distributorMaxWriteMessageSizeErr{actual: int(r.ContentLength), limit: maxRecvMsgSize}
+		protoMsg, err := remoteWriteProtoMsg(r)
+		if err != nil {
+			return err
+		}
+		compression, err := remoteWriteCompression(r)
+		if err != nil {
+			return err
+		}
+
+		var msg proto.Message = req
+		if protoMsg == remoteWriteV2ProtoMsg {
+			msg = mimirpb.PreallocWriteRequestV2{PreallocWriteRequest: req}
+		}
+
+		err = util.ParseProtoReader(ctx, r.Body, int(r.ContentLength), maxRecvMsgSize, buffers, msg, compression)
+		var tooLargeErr util.MsgSizeTooLargeErr
+		if errors.As(err, &tooLargeErr) {
+			err = distributorMaxWriteMessageSizeErr{actual: tooLargeErr.Actual, limit: tooLargeErr.Limit}
 		}
 		return err
 	})
 }
 
+// remoteWriteProtoMsg returns the protobuf message of the Remote-Write request, negotiated through its Content-Type header.
+// Requests without a "proto" parameter are Remote-Write 1.0 requests.
+func remoteWriteProtoMsg(r *http.Request) (string, error) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return remoteWriteV1ProtoMsg, nil
+	}
+
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// The Content-Type header has never been enforced for Remote-Write 1.0 requests.
+		return remoteWriteV1ProtoMsg, nil
+	}
+
+	switch protoMsg := params["proto"]; protoMsg {
+	case "", remoteWriteV1ProtoMsg:
+		return remoteWriteV1ProtoMsg, nil
+	case remoteWriteV2ProtoMsg:
+		return remoteWriteV2ProtoMsg, nil
+	default:
+		return "", httpgrpc.Errorf(http.StatusUnsupportedMediaType, "unsupported remote write protobuf message: %s, supported: [%s, %s]", protoMsg, remoteWriteV1ProtoMsg, remoteWriteV2ProtoMsg)
+	}
+}
+
+// remoteWriteCompression returns the compression of the Remote-Write request, according to its Content-Encoding header.
+// Requests without a Content-Encoding header are compressed with snappy, as required by the protocol.
+func remoteWriteCompression(r *http.Request) (util.CompressionType, error) {
+	switch contentEncoding := r.Header.Get("Content-Encoding"); contentEncoding {
+	case "", "snappy":
+		return util.RawSnappy, nil
+	case "zstd":
+		return util.Zstd, nil
+	case "lz4":
+		return util.Lz4, nil
+	default:
+		return 0, httpgrpc.Errorf(http.StatusUnsupportedMediaType, "unsupported compression: %s. Only \"snappy\", \"zstd\" or \"lz4\" supported", contentEncoding)
+	}
+}
+
+// remoteWriteWrittenStats holds the number of samples, histograms and exemplars of a Remote-Write request which have
+// been pushed to the backends, once filtered by the push middlewares. They're returned in the response headers of
+// Remote-Write 2.0 requests.
+type remoteWriteWrittenStats struct {
+	samples, histograms, exemplars int
+}
+
+type remoteWriteWrittenStatsContextKey struct{}
+
+// contextWithRemoteWriteWrittenStats returns a context in which the written stats of the request are recorded by
+// recordRemoteWriteWrittenStats.
+func contextWithRemoteWriteWrittenStats(ctx context.Context, stats *remoteWriteWrittenStats) context.Context {
+	return context.WithValue(ctx, remoteWriteWrittenStatsContextKey{}, stats)
+}
+
+// recordRemoteWriteWrittenStats records the samples, histograms and exemplars of req as written, if the context
+// tracks the written stats of the request.
+func recordRemoteWriteWrittenStats(ctx context.Context, req *mimirpb.WriteRequest) {
+	stats, ok := ctx.Value(remoteWriteWrittenStatsContextKey{}).(*remoteWriteWrittenStats)
+	if !ok {
+		return
+	}
+	for _, ts := range req.Timeseries {
+		stats.samples += len(ts.Samples)
+		stats.histograms += len(ts.Histograms)
+		stats.exemplars += len(ts.Exemplars)
+	}
+}
+
+func (s *remoteWriteWrittenStats) setHeaders(w http.ResponseWriter) {
+	w.Header().Set(remoteWriteWrittenSamplesHeader, strconv.Itoa(s.samples))
+	w.Header().Set(remoteWriteWrittenHistogramsHeader, strconv.Itoa(s.histograms))
+	w.Header().Set(remoteWriteWrittenExemplarsHeader, strconv.Itoa(s.exemplars))
+}
+
+// readPushRequestBody reads the body of a push request which isn't encoded with protobuf, decompressing it according
+// to its Content-Encoding header. Supported encodings are gzip, deflate, zstd, lz4 and no compression.
+func readPushRequestBody(r *http.Request, maxRecvMsgSize int, buffers *util.RequestBuffers) ([]byte, error) {
+	if r.ContentLength > int64(maxRecvMsgSize) {
+		return nil, httpgrpc.Errorf(http.StatusRequestEntityTooLarge, distributorMaxWriteMessageSizeErr{
+			actual: int(r.ContentLength),
+			limit:  maxRecvMsgSize,
+		}.Error())
+	}
+
+	var reader io.Reader = r.Body
+	switch contentEncoding := r.Header.Get("Content-Encoding"); contentEncoding {
+	case "":
+	case "deflate":
+		zlibReader, err := zlib.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("create deflate reader: %w", err)
+		}
+		defer zlibReader.Close()
+		reader = zlibReader
+	case "gzip", "zstd", "lz4":
+		compression := util.Gzip
+		if contentEncoding == "zstd" {
+			compression = util.Zstd
+		} else if contentEncoding == "lz4" {
+			compression = util.Lz4
+		}
+		decompressingReader, err := util.NewDecompressingReader(reader, compression, maxRecvMsgSize)
+		if err != nil {
+			return nil, err
+		}
+		defer decompressingReader.Close()
+		reader = decompressingReader
+	default:
+		return nil, httpgrpc.Errorf(http.StatusUnsupportedMediaType, "unsupported compression: %s. Only \"gzip\", \"deflate\", \"zstd\", \"lz4\" or no compression supported", contentEncoding)
+	}
+
+	sz := int(r.ContentLength)
+	if sz > 0 {
+		// Extra space guarantees no reallocation
+		sz += bytes.MinRead
+	}
+	buf := buffers.Get(sz)
+	// Limit at maxRecvMsgSize+1 so we can tell when the size is exceeded.
+	_, err := buf.ReadFrom(io.LimitReader(reader, int64(maxRecvMsgSize)+1))
+	if err != nil && !errors.Is(err, util.MsgSizeTooLargeErr{}) {
+		return nil, fmt.Errorf("read write request: %w", err)
+	}
+	if err != nil || buf.Len() > maxRecvMsgSize {
+		return nil, httpgrpc.Errorf(http.StatusRequestEntityTooLarge, distributorMaxWriteMessageSizeErr{
+			actual: -1,
+			limit:  maxRecvMsgSize,
+		}.Error())
+	}
+	return buf.Bytes(), nil
+}
+
 type distributorMaxWriteMessageSizeErr struct {
 	actual, limit int
 }
@@ -126,6 +285,13 @@ func handler(
 				logger = utillog.WithSourceIPs(source, logger)
 			}
 		}
+		// The written samples are only returned to Remote-Write 2.0 clients. They're recorded by the distributor
+		// once the request has been filtered, because the series are cleaned up once pushed.
+		var writtenStats *remoteWriteWrittenStats
+		if protoMsg, _ := remoteWriteProtoMsg(r); protoMsg == remoteWriteV2ProtoMsg {
+			writtenStats = &remoteWriteWrittenStats{}
+			ctx = contextWithRemoteWriteWrittenStats(ctx, writtenStats)
+		}
 		supplier := func() (*mimirpb.WriteRequest, func(), error) {
 			rb := util.NewRequestBuffers(&bufferPool)
 			var req mimirpb.PreallocWriteRequest
@@ -171,7 +337,16 @@ func handler(
 				level.Error(logger).Log("msg", "push error", "err", err)
 			}
 			addHeaders(w, err, r, code, retryCfg)
+			// Client errors can be partial writes, in which case the valid series have been written.
+			if writtenStats != nil && code/100 != 5 {
+				writtenStats.setHeaders(w)
+			}
 			http.Error(w, msg, code)
+			return
+		}
+
+		if writtenStats != nil {
+			writtenStats.setHeaders(w)
 		}
 	})
 }
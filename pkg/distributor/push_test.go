@@ -25,6 +25,8 @@ import (
 	"github.com/grafana/dskit/httpgrpc/server"
 	"github.com/grafana/dskit/middleware"
 	"github.com/grafana/dskit/user"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
 	"github.com/pkg/errors"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/prompb"
@@ -35,6 +37,7 @@ import (
 	"go.opentelemetry.io/collector/pdata/pmetric"
 	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protowire"
 
 	"github.com/grafana/mimir/pkg/ingester/client"
 	"github.com/grafana/mimir/pkg/mimirpb"
@@ -51,6 +54,256 @@ func TestHandler_remoteWrite(t *testing.T) {
 	assert.Equal(t, 200, resp.Code)
 }
 
+func TestHandler_remoteWriteV2(t *testing.T) {
+	req := createRequest(t, createPrometheusRemoteWriteV2Protobuf(t))
+	req.Header.Set("Content-Type", "application/x-protobuf;proto=io.prometheus.write.v2.Request")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "2.0.0")
+	resp := httptest.NewRecorder()
+	verify := verifyWritePushFunc(t, mimirpb.API)
+	handler := Handler(100000, nil, false, nil, RetryConfig{}, func(ctx context.Context, pushReq *Request) error {
+		if err := verify(ctx, pushReq); err != nil {
+			return err
+		}
+		request, err := pushReq.WriteRequest()
+		require.NoError(t, err)
+		recordRemoteWriteWrittenStats(ctx, request)
+		return nil
+	}, log.NewNopLogger())
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, "1", resp.Header().Get(remoteWriteWrittenSamplesHeader))
+	assert.Equal(t, "1", resp.Header().Get(remoteWriteWrittenHistogramsHeader))
+	assert.Equal(t, "0", resp.Header().Get(remoteWriteWrittenExemplarsHeader))
+}
+
+func TestHandler_remoteWriteV2WrittenStats(t *testing.T) {
+	testCases := map[string]struct {
+		// dropHistograms simulates a push middleware filtering the histograms out of the request.
+		dropHistograms     bool
+		pushErr            error
+		expectedCode       int
+		expectedSamples    string
+		expectedHistograms string
+	}{
+		"series filtered before being written": {
+			dropHistograms:     true,
+			expectedCode:       http.StatusOK,
+			expectedSamples:    "1",
+			expectedHistograms: "0",
+		},
+		"partial write": {
+			dropHistograms:     true,
+			pushErr:            httpgrpc.Errorf(http.StatusBadRequest, "invalid histogram"),
+			expectedCode:       http.StatusBadRequest,
+			expectedSamples:    "1",
+			expectedHistograms: "0",
+		},
+		"request deduplicated without being written": {
+			pushErr:            httpgrpc.Errorf(http.StatusAccepted, "replica not elected"),
+			expectedCode:       http.StatusAccepted,
+			expectedSamples:    "0",
+			expectedHistograms: "0",
+		},
+		"server error": {
+			pushErr:      httpgrpc.Errorf(http.StatusInternalServerError, "ingesters unavailable"),
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := createRequest(t, createPrometheusRemoteWriteV2Protobuf(t))
+			req.Header.Set("Content-Type", "application/x-protobuf;proto=io.prometheus.write.v2.Request")
+			req.Header.Set("X-Prometheus-Remote-Write-Version", "2.0.0")
+			resp := httptest.NewRecorder()
+			handler := Handler(100000, nil, false, nil, RetryConfig{}, func(ctx context.Context, pushReq *Request) error {
+				request, err := pushReq.WriteRequest()
+				require.NoError(t, err)
+				if tc.dropHistograms {
+					for i := range request.Timeseries {
+						request.Timeseries[i].Histograms = nil
+					}
+					recordRemoteWriteWrittenStats(ctx, request)
+				}
+				return tc.pushErr
+			}, log.NewNopLogger())
+			handler.ServeHTTP(resp, req)
+
+			assert.Equal(t, tc.expectedCode, resp.Code)
+			assert.Equal(t, tc.expectedSamples, resp.Header().Get(remoteWriteWrittenSamplesHeader))
+			assert.Equal(t, tc.expectedHistograms, resp.Header().Get(remoteWriteWrittenHistogramsHeader))
+		})
+	}
+}
+
+func TestHandler_remoteWriteContentTypeNegotiation(t *testing.T) {
+	testCases := map[string]struct {
+		contentType            string
+		expectedCode           int
+		expectedWrittenHeaders bool
+	}{
+		"no content type": {
+			contentType:  "",
+			expectedCode: http.StatusOK,
+		},
+		"remote write 1.0 without proto parameter": {
+			contentType:  "application/x-protobuf",
+			expectedCode: http.StatusOK,
+		},
+		"remote write 1.0 with proto parameter": {
+			contentType:  "application/x-protobuf;proto=prometheus.WriteRequest",
+			expectedCode: http.StatusOK,
+		},
+		"unsupported proto parameter": {
+			contentType:  "application/x-protobuf;proto=io.prometheus.write.v3.Request",
+			expectedCode: http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := createRequest(t, createPrometheusRemoteWriteProtobuf(t))
+			req.Header.Set("Content-Type", tc.contentType)
+			resp := httptest.NewRecorder()
+			handler := Handler(100000, nil, false, nil, RetryConfig{}, readBodyPushFunc(t), log.NewNopLogger())
+			handler.ServeHTTP(resp, req)
+			assert.Equal(t, tc.expectedCode, resp.Code)
+			// The written samples are only returned to Remote-Write 2.0 clients.
+			assert.Empty(t, resp.Header().Get(remoteWriteWrittenSamplesHeader))
+		})
+	}
+}
+
+func TestHandler_remoteWriteCompression(t *testing.T) {
+	testCases := map[string]struct {
+		contentEncoding string
+		body            []byte
+		expectedCode    int
+	}{
+		"no content encoding": {
+			body:         createPrometheusRemoteWriteProtobuf(t),
+			expectedCode: http.StatusOK,
+		},
+		"snappy": {
+			contentEncoding: "snappy",
+			body:            createPrometheusRemoteWriteProtobuf(t),
+			expectedCode:    http.StatusOK,
+		},
+		"zstd": {
+			contentEncoding: "zstd",
+			body:            createPrometheusRemoteWriteProtobuf(t),
+			expectedCode:    http.StatusOK,
+		},
+		"lz4": {
+			contentEncoding: "lz4",
+			body:            createPrometheusRemoteWriteProtobuf(t),
+			expectedCode:    http.StatusOK,
+		},
+		"unsupported compression": {
+			contentEncoding: "gzip",
+			body:            createPrometheusRemoteWriteProtobuf(t),
+			expectedCode:    http.StatusUnsupportedMediaType,
+		},
+		"zstd request too big once decompressed": {
+			contentEncoding: "zstd",
+			body:            make([]byte, 1024*1024),
+			expectedCode:    http.StatusBadRequest,
+		},
+		"lz4 request too big once decompressed": {
+			contentEncoding: "lz4",
+			body:            make([]byte, 1024*1024),
+			expectedCode:    http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			body := compressRequestBody(t, tc.contentEncoding, tc.body)
+			req, err := http.NewRequest("POST", "http://localhost/", bytes.NewReader(body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/x-protobuf")
+			req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+			if tc.contentEncoding != "" {
+				req.Header.Set("Content-Encoding", tc.contentEncoding)
+			}
+
+			resp := httptest.NewRecorder()
+			handler := Handler(100000, nil, false, nil, RetryConfig{}, readBodyPushFunc(t), log.NewNopLogger())
+			handler.ServeHTTP(resp, req)
+			assert.Equal(t, tc.expectedCode, resp.Code, resp.Body.String())
+		})
+	}
+}
+
+func TestHandler_otlpCompression(t *testing.T) {
+	exportReq := TimeseriesToOTLPRequest([]prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "foo"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: time.Now().UnixMilli()}},
+	}}, nil)
+	protoBody, err := exportReq.MarshalProto()
+	require.NoError(t, err)
+	jsonBody, err := exportReq.MarshalJSON()
+	require.NoError(t, err)
+
+	limits, err := validation.NewOverrides(validation.Limits{}, nil)
+	require.NoError(t, err)
+
+	for _, contentEncoding := range []string{"zstd", "lz4"} {
+		for contentType, body := range map[string][]byte{pbContentType: protoBody, jsonContentType: jsonBody} {
+			t.Run(fmt.Sprintf("%s %s", contentEncoding, contentType), func(t *testing.T) {
+				req := createOTLPRequest(t, compressRequestBody(t, contentEncoding, body), false, contentType)
+				req.Header.Set("Content-Encoding", contentEncoding)
+
+				resp := httptest.NewRecorder()
+				handler := OTLPHandler(100000, nil, false, true, limits, nil, RetryConfig{}, nil, readBodyPushFunc(t), log.NewNopLogger())
+				handler.ServeHTTP(resp, req)
+				assert.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+			})
+
+			t.Run(fmt.Sprintf("%s %s request too big once decompressed", contentEncoding, contentType), func(t *testing.T) {
+				req := createOTLPRequest(t, compressRequestBody(t, contentEncoding, make([]byte, 1024*1024)), false, contentType)
+				req.Header.Set("Content-Encoding", contentEncoding)
+
+				resp := httptest.NewRecorder()
+				handler := OTLPHandler(100000, nil, false, true, limits, nil, RetryConfig{}, nil, readBodyPushFunc(t), log.NewNopLogger())
+				handler.ServeHTTP(resp, req)
+				assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code, resp.Body.String())
+			})
+		}
+	}
+}
+
+// compressRequestBody compresses the body according to the Content-Encoding of a push request. Remote-Write requests
+// without Content-Encoding are compressed with snappy.
+func compressRequestBody(t *testing.T, contentEncoding string, body []byte) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	switch contentEncoding {
+	case "", "snappy":
+		return snappy.Encode(nil, body)
+	case "gzip":
+		w := gzip.NewWriter(&b)
+		_, err := w.Write(body)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	case "zstd":
+		w, err := zstd.NewWriter(&b)
+		require.NoError(t, err)
+		_, err = w.Write(body)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	case "lz4":
+		w := lz4.NewWriter(&b)
+		_, err := w.Write(body)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	default:
+		require.Failf(t, "unsupported content encoding", contentEncoding)
+	}
+	return b.Bytes()
+}
+
 func TestOTelMetricsToMetadata(t *testing.T) {
 	otelMetrics := pmetric.NewMetrics()
 	rs := otelMetrics.ResourceMetrics().AppendEmpty()
@@ -237,7 +490,7 @@ func TestHandlerOTLPPush(t *testing.T) {
 				return err
 			},
 			responseCode: http.StatusUnsupportedMediaType,
-			errMessage:   "Only \"gzip\" or no compression supported",
+			errMessage:   "Only \"gzip\", \"zstd\", \"lz4\" or no compression supported",
 		},
 		{
 			name:       "Write histograms",
@@ -300,7 +553,7 @@ func TestHandlerOTLPPush(t *testing.T) {
 				t.Cleanup(pushReq.CleanUp)
 				return tt.verifyFunc(t, pushReq)
 			}
-			handler := OTLPHandler(tt.maxMsgSize, nil, false, tt.enableOtelMetadataStorage, limits, RetryConfig{}, nil, pusher, log.NewNopLogger())
+			handler := OTLPHandler(tt.maxMsgSize, nil, false, tt.enableOtelMetadataStorage, limits, nil, RetryConfig{}, nil, pusher, log.NewNopLogger())
 
 			resp := httptest.NewRecorder()
 			handler.ServeHTTP(resp, req)
@@ -361,7 +614,7 @@ func TestHandler_otlpDroppedMetricsPanic(t *testing.T) {
 
 	req := createOTLPProtoRequest(t, pmetricotlp.NewExportRequestFromMetrics(md), false)
 	resp := httptest.NewRecorder()
-	handler := OTLPHandler(100000, nil, false, true, limits, RetryConfig{}, nil, func(_ context.Context, pushReq *Request) error {
+	handler := OTLPHandler(100000, nil, false, true, limits, nil, RetryConfig{}, nil, func(_ context.Context, pushReq *Request) error {
 		request, err := pushReq.WriteRequest()
 		assert.NoError(t, err)
 		assert.Len(t, request.Timeseries, 3)
@@ -407,7 +660,7 @@ func TestHandler_otlpDroppedMetricsPanic2(t *testing.T) {
 
 	req := createOTLPProtoRequest(t, pmetricotlp.NewExportRequestFromMetrics(md), false)
 	resp := httptest.NewRecorder()
-	handler := OTLPHandler(100000, nil, false, true, limits, RetryConfig{}, nil, func(_ context.Context, pushReq *Request) error {
+	handler := OTLPHandler(100000, nil, false, true, limits, nil, RetryConfig{}, nil, func(_ context.Context, pushReq *Request) error {
 		request, err := pushReq.WriteRequest()
 		assert.NoError(t, err)
 		assert.Len(t, request.Timeseries, 2)
@@ -433,7 +686,7 @@ func TestHandler_otlpDroppedMetricsPanic2(t *testing.T) {
 
 	req = createOTLPProtoRequest(t, pmetricotlp.NewExportRequestFromMetrics(md), false)
 	resp = httptest.NewRecorder()
-	handler = OTLPHandler(100000, nil, false, true, limits, RetryConfig{}, nil, func(_ context.Context, pushReq *Request) error {
+	handler = OTLPHandler(100000, nil, false, true, limits, nil, RetryConfig{}, nil, func(_ context.Context, pushReq *Request) error {
 		request, err := pushReq.WriteRequest()
 		assert.NoError(t, err)
 		assert.Len(t, request.Timeseries, 10) // 6 buckets (including +Inf) + 2 sum/count + 2 from the first case
@@ -461,7 +714,7 @@ func TestHandler_otlpWriteRequestTooBigWithCompression(t *testing.T) {
 
 	resp := httptest.NewRecorder()
 
-	handler := OTLPHandler(140, nil, false, true, nil, RetryConfig{}, nil, readBodyPushFunc(t), log.NewNopLogger())
+	handler := OTLPHandler(140, nil, false, true, nil, nil, RetryConfig{}, nil, readBodyPushFunc(t), log.NewNopLogger())
 	handler.ServeHTTP(resp, req)
 	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
 	body, err := io.ReadAll(resp.Body)
@@ -656,6 +909,36 @@ func createPrometheusRemoteWriteProtobuf(t testing.TB) []byte {
 	return inputBytes
 }
 
+// createPrometheusRemoteWriteV2Protobuf returns an io.prometheus.write.v2.Request with the same series as createPrometheusRemoteWriteProtobuf.
+func createPrometheusRemoteWriteV2Protobuf(t testing.TB) []byte {
+	t.Helper()
+	appendMessage := func(b []byte, num protowire.Number, m []byte) []byte {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendBytes(b, m)
+	}
+
+	var req, ts []byte
+	for _, symbol := range []string{"", "__name__", "foo"} {
+		req = appendMessage(req, 4, []byte(symbol))
+	}
+
+	// Labels references.
+	ts = appendMessage(ts, 1, []byte{1, 2})
+
+	sample := mimirpb.Sample{Value: 1, TimestampMs: time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC).UnixNano()}
+	sampleBytes, err := sample.Marshal()
+	require.NoError(t, err)
+	ts = appendMessage(ts, 2, sampleBytes)
+
+	h := remote.HistogramToHistogramProto(1337, test.GenerateTestHistogram(1))
+	histogram := promToMimirHistogram(&h)
+	histogramBytes, err := histogram.Marshal()
+	require.NoError(t, err)
+	ts = appendMessage(ts, 3, histogramBytes)
+
+	return appendMessage(req, 5, ts)
+}
+
 func createMimirWriteRequestProtobuf(t *testing.T, skipLabelNameValidation bool) []byte {
 	t.Helper()
 	h := remote.HistogramToHistogramProto(1337, test.GenerateTestHistogram(1))
@@ -25,6 +25,10 @@ type instantSplitter struct {
 	outerAggregationExpr *parser.AggregateExpr
 	logger               log.Logger
 	stats                *InstantSplitterStats
+
+	// If alignSplits is true, splits are aligned to multiples of interval, based on evaluationTime.
+	alignSplits    bool
+	evaluationTime time.Time
 }
 
 // Supported vector aggregators
@@ -61,14 +65,31 @@ var cannotDoubleCountBoundaries = map[string]bool{
 
 // NewInstantQuerySplitter creates a new query range mapper.
 func NewInstantQuerySplitter(ctx context.Context, interval time.Duration, logger log.Logger, stats *InstantSplitterStats) ASTMapper {
-	instantQueryMapper := NewASTExprMapper(
-		&instantSplitter{
-			ctx:      ctx,
-			interval: interval,
-			logger:   logger,
-			stats:    stats,
-		},
-	)
+	return newInstantQuerySplitter(&instantSplitter{
+		ctx:      ctx,
+		interval: interval,
+		logger:   logger,
+		stats:    stats,
+	})
+}
+
+// NewAlignedInstantQuerySplitter creates a new query range mapper whose splits are aligned to multiples of interval.
+// The most recent split selects the data between the end of the original range and the previous multiple of interval,
+// while all other splits select a whole interval. This way, the embedded queries of all splits other than the most
+// recent one select the same data for every evaluation time within the same interval, and their results can be cached.
+func NewAlignedInstantQuerySplitter(ctx context.Context, interval time.Duration, evaluationTime time.Time, logger log.Logger, stats *InstantSplitterStats) ASTMapper {
+	return newInstantQuerySplitter(&instantSplitter{
+		ctx:            ctx,
+		interval:       interval,
+		logger:         logger,
+		stats:          stats,
+		alignSplits:    true,
+		evaluationTime: evaluationTime,
+	})
+}
+
+func newInstantQuerySplitter(splitter *instantSplitter) ASTMapper {
+	instantQueryMapper := NewASTExprMapper(splitter)
 
 	return NewMultiMapper(
 		instantQueryMapper,
@@ -341,7 +362,17 @@ func (i *instantSplitter) mapCallByRangeInterval(expr *parser.Call, rangeInterva
 // In this case, the vector aggregator should be downstream to the embedded queries in order to limit
 // the label cardinality of the parallel queries
 func (i *instantSplitter) splitAndSquashCall(expr *parser.Call, rangeInterval time.Duration) (mapped parser.Expr, finished bool, err error) {
-	splitCount := int(math.Ceil(float64(rangeInterval) / float64(i.interval)))
+	originalOffset, err := i.assertOffset(expr)
+	if err != nil {
+		return nil, false, err
+	}
+
+	firstSplitRangeInterval := i.interval
+	if i.alignSplits {
+		firstSplitRangeInterval = i.alignedFirstSplitRangeInterval(expr, originalOffset)
+	}
+
+	splitCount := 1 + int(math.Ceil(float64(rangeInterval-firstSplitRangeInterval)/float64(i.interval)))
 	if splitCount <= 1 {
 		return expr, false, nil
 	}
@@ -352,32 +383,32 @@ func (i *instantSplitter) splitAndSquashCall(expr *parser.Call, rangeInterval ti
 		embeddedQuery = i.outerAggregationExpr
 	}
 
-	originalOffset, err := i.assertOffset(expr)
-	if err != nil {
-		return nil, false, err
-	}
-
 	// Create a partial query for each split
 	embeddedQueries := make([]parser.Expr, 0, splitCount)
+	splitOffset := time.Duration(0)
 	for split := 0; split < splitCount; split++ {
-		splitOffset := time.Duration(split) * i.interval
+		fullSplitRangeInterval := i.interval
+		if split == 0 {
+			fullSplitRangeInterval = firstSplitRangeInterval
+		}
+
 		// The range interval of the last embedded query can be smaller than i.interval
-		splitRangeInterval := i.interval
+		splitRangeInterval := fullSplitRangeInterval
 		if lastSplit := split == splitCount-1; cannotDoubleCountBoundaries[expr.Func.Name] && !lastSplit {
 			splitRangeInterval -= time.Millisecond
 		}
 		if splitOffset+splitRangeInterval > rangeInterval {
 			splitRangeInterval = rangeInterval - splitOffset
 		}
-		// The offset of the embedded queries is always the original offset + a multiple of i.interval
-		splitOffset += originalOffset
-		splitExpr, err := createSplitExpr(embeddedQuery, splitRangeInterval, splitOffset)
+		// The offset of the embedded queries is always the original offset + the range of the more recent splits
+		splitExpr, err := createSplitExpr(embeddedQuery, splitRangeInterval, originalOffset+splitOffset)
 		if err != nil {
 			return nil, false, err
 		}
 
 		// Prepend to embedded queries
 		embeddedQueries = append([]parser.Expr{splitExpr}, embeddedQueries...)
+		splitOffset += fullSplitRangeInterval
 	}
 
 	squashExpr, err := vectorSquasher(embeddedQueries...)
@@ -391,6 +422,30 @@ func (i *instantSplitter) splitAndSquashCall(expr *parser.Call, rangeInterval ti
 	return squashExpr, true, nil
 }
 
+// alignedFirstSplitRangeInterval returns the range interval of the most recent split, so that it starts at the multiple
+// of i.interval preceding the end of the range selected by expr.
+func (i *instantSplitter) alignedFirstSplitRangeInterval(expr parser.Expr, offset time.Duration) time.Duration {
+	end := i.evaluationTime.UnixMilli()
+
+	// Ignore the error since we never return it.
+	visitNode(expr, func(entry parser.Node) {
+		if selector, ok := entry.(*parser.VectorSelector); ok && selector.Timestamp != nil {
+			end = *selector.Timestamp
+		}
+	})
+
+	end -= offset.Milliseconds()
+	interval := i.interval.Milliseconds()
+
+	rangeInterval := ((end % interval) + interval) % interval
+	if rangeInterval <= time.Millisecond.Milliseconds() {
+		// The range must remain positive after removing the boundary for functions that cannot double count it.
+		rangeInterval += interval
+	}
+
+	return time.Duration(rangeInterval) * time.Millisecond
+}
+
 // assertSplittableRangeInterval returns the range interval specified in the input expr and whether it is greater than
 // the configured split interval.
 func (i *instantSplitter) assertSplittableRangeInterval(expr parser.Expr) (rangeInterval time.Duration, canSplit bool, err error) {
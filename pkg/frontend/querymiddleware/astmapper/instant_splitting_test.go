@@ -442,6 +442,59 @@ func TestInstantSplitterUnevenRangeInterval(t *testing.T) {
 	}
 }
 
+func TestAlignedInstantSplitter(t *testing.T) {
+	splitInterval := 2 * time.Minute
+	evaluationTime := time.Unix(0, 0).Add(10*time.Minute + 30*time.Second)
+
+	for _, tt := range []struct {
+		in                   string
+		out                  string
+		expectedSplitQueries int
+	}{
+		{
+			in:                   `rate({app="foo"}[5m])`,
+			out:                  `sum without() (` + concat(`increase({app="foo"}[30s] offset 4m30s)`, `increase({app="foo"}[2m] offset 2m30s)`, `increase({app="foo"}[2m] offset 30s)`, `increase({app="foo"}[30s])`) + `) / 300`,
+			expectedSplitQueries: 4,
+		},
+		{
+			in:                   `sum_over_time({app="foo"}[4m] offset 1m)`,
+			out:                  `sum without() (` + concat(`sum_over_time({app="foo"}[30s] offset 4m30s)`, `sum_over_time({app="foo"}[1m59s999ms] offset 2m30s)`, `sum_over_time({app="foo"}[1m29s999ms] offset 1m)`) + `)`,
+			expectedSplitQueries: 3,
+		},
+		// Splits are aligned to the time of the @ modifier, if any.
+		{
+			in:                   `max_over_time({app="foo"}[4m] @ 600)`,
+			out:                  `max without() (` + concat(`max_over_time({app="foo"}[2m] @ 600 offset 2m)`, `max_over_time({app="foo"}[2m] @ 600)`) + `)`,
+			expectedSplitQueries: 2,
+		},
+		// The most recent split selects a whole split interval if the range ends at a multiple of the split interval.
+		{
+			in:                   `max_over_time({app="foo"}[3m] offset 30s)`,
+			out:                  `max without() (` + concat(`max_over_time({app="foo"}[1m] offset 2m30s)`, `max_over_time({app="foo"}[2m] offset 30s)`) + `)`,
+			expectedSplitQueries: 2,
+		},
+	} {
+		tt := tt
+
+		t.Run(tt.in, func(t *testing.T) {
+			stats := NewInstantSplitterStats()
+			mapper := NewAlignedInstantQuerySplitter(context.Background(), splitInterval, evaluationTime, log.NewNopLogger(), stats)
+
+			expr, err := parser.ParseExpr(tt.in)
+			require.NoError(t, err)
+			out, err := parser.ParseExpr(tt.out)
+			require.NoError(t, err)
+
+			mapped, err := mapper.Map(expr)
+			require.NoError(t, err)
+			require.Equal(t, out.String(), mapped.String())
+
+			assert.Equal(t, tt.expectedSplitQueries, stats.GetSplitQueries())
+			assert.Equal(t, noneSkippedReason, stats.GetSkippedReason())
+		})
+	}
+}
+
 func TestInstantSplitterSkippedQueryReason(t *testing.T) {
 	splitInterval := 1 * time.Minute
 
@@ -94,6 +94,9 @@ func (summer *shardSummer) MapExpr(expr parser.Expr) (mapped parser.Expr, finish
 		if CanParallelize(e, summer.logger) {
 			return summer.shardAggregate(e)
 		}
+		if canShardTopKOrBottomK(e, summer.logger) {
+			return summer.shardTopKOrBottomK(e)
+		}
 		return e, false, nil
 
 	case *parser.VectorSelector:
@@ -110,7 +113,7 @@ func (summer *shardSummer) MapExpr(expr parser.Expr) (mapped parser.Expr, finish
 			// and they don't contain aggregations over series in children exprs.
 			if isSubqueryCall(e) {
 				if containsAggregateExpr(e) {
-					return e, true, nil
+					return summer.shardSubqueryOverAggregation(e)
 				}
 				if !CanParallelize(e, summer.logger) {
 					return e, true, nil
@@ -260,6 +263,102 @@ func (summer *shardSummer) shardAndSquashFuncCall(expr *parser.Call) (mapped par
 	return squashed, true, nil
 }
 
+// shardableSubqueryAggregations maps the functions which can be sharded when applied to a subquery over an
+// aggregation to the aggregations they support, and the aggregation used to combine the per-shard results.
+var shardableSubqueryAggregations = map[string]map[parser.ItemType]parser.ItemType{
+	"sum_over_time": {parser.SUM: parser.SUM, parser.COUNT: parser.SUM},
+	"max_over_time": {parser.MAX: parser.MAX},
+	"min_over_time": {parser.MIN: parser.MIN},
+}
+
+// shardSubqueryOverAggregation attempts to shard the given function call over a subquery whose inner expression
+// is an aggregation, like sum_over_time(sum(rate(foo[1m]))[10m:1m]). If the function call can't be sharded, the
+// input expr is returned as is.
+func (summer *shardSummer) shardSubqueryOverAggregation(expr *parser.Call) (mapped parser.Expr, finished bool, err error) {
+	/*
+		parallelizing a sum_over_time() over a subquery on a sum by(foo) is representable as
+		sum by(foo) (
+		  sum_over_time(sum by(foo) (rate(bar1{__query_shard__="0_of_2"}[1m]))[10m:1m]) or
+		  sum_over_time(sum by(foo) (rate(bar1{__query_shard__="1_of_2"}[1m]))[10m:1m])
+		)
+
+		because the sum over time of the per-shard sums is the same as the sum over time of the sum.
+		The same applies to max_over_time() over max() and min_over_time() over min().
+	*/
+	aggr, ok := subqueryAggregation(expr)
+	if !ok {
+		return expr, true, nil
+	}
+	combineOp, ok := shardableSubqueryAggregations[expr.Func.Name][aggr.Op]
+	if !ok {
+		return expr, true, nil
+	}
+
+	// The aggregated expression must be fully parallelizable.
+	if containsAggregateExpr(aggr.Expr) || !CanParallelize(aggr.Expr, summer.logger) {
+		return expr, true, nil
+	}
+
+	children := make([]parser.Expr, 0, summer.shards)
+
+	// Create sub-query for each shard.
+	for i := 0; i < summer.shards; i++ {
+		cloned, err := cloneExpr(expr)
+		if err != nil {
+			return nil, true, err
+		}
+		clonedCall := cloned.(*parser.Call)
+		clonedAggr, _ := subqueryAggregation(clonedCall)
+
+		sharded, err := cloneAndMap(NewASTExprMapper(summer.CopyWithCurShard(i)), clonedAggr.Expr)
+		if err != nil {
+			return nil, true, err
+		}
+		clonedAggr.Expr = sharded
+
+		children = append(children, clonedCall)
+	}
+
+	// Update stats.
+	summer.stats.AddShardedQueries(summer.shards)
+	squashed, err := summer.squash(children...)
+	if err != nil {
+		return nil, true, err
+	}
+
+	// The results of the shards are combined preserving the grouping of the inner aggregation.
+	return &parser.AggregateExpr{
+		Op:       combineOp,
+		Expr:     squashed,
+		Grouping: aggr.Grouping,
+		Without:  aggr.Without,
+	}, true, nil
+}
+
+// subqueryAggregation returns the aggregation expression of the subquery which is the only argument of the
+// given function call, if any.
+func subqueryAggregation(expr *parser.Call) (*parser.AggregateExpr, bool) {
+	if len(expr.Args) != 1 {
+		return nil, false
+	}
+	subquery, ok := unwrapParenExpr(expr.Args[0]).(*parser.SubqueryExpr)
+	if !ok {
+		return nil, false
+	}
+	aggr, ok := unwrapParenExpr(subquery.Expr).(*parser.AggregateExpr)
+	return aggr, ok
+}
+
+func unwrapParenExpr(expr parser.Expr) parser.Expr {
+	for {
+		paren, ok := expr.(*parser.ParenExpr)
+		if !ok {
+			return expr
+		}
+		expr = paren.Expr
+	}
+}
+
 // shardAggregate attempts to shard the given aggregation expression.
 func (summer *shardSummer) shardAggregate(expr *parser.AggregateExpr) (mapped parser.Expr, finished bool, err error) {
 	switch expr.Op {
@@ -439,6 +538,44 @@ func (summer *shardSummer) shardAvg(expr *parser.AggregateExpr) (result parser.E
 	}, nil
 }
 
+// canShardTopKOrBottomK returns whether the given expression is a TOPK or BOTTOMK aggregation which can be sharded.
+// The parameter must be a constant, because it's evaluated by each shard, and the aggregated expression must be
+// fully parallelizable.
+func canShardTopKOrBottomK(expr *parser.AggregateExpr, logger log.Logger) bool {
+	if expr.Op != parser.TOPK && expr.Op != parser.BOTTOMK {
+		return false
+	}
+	return isConstantScalar(expr.Param) && noAggregates(expr.Expr) && CanParallelize(expr.Expr, logger)
+}
+
+// shardTopKOrBottomK shards the given TOPK or BOTTOMK aggregation expression.
+func (summer *shardSummer) shardTopKOrBottomK(expr *parser.AggregateExpr) (mapped parser.Expr, finished bool, err error) {
+	/*
+		parallelizing a topk using by(foo) is representable as
+		topk by(foo) (10,
+		  topk by(foo) (10, rate(bar1{__query_shard__="0_of_2",baz="blip"}[1m])) or
+		  topk by(foo) (10, rate(bar1{__query_shard__="1_of_2",baz="blip"}[1m]))
+		)
+
+		because the top K series of each group are within the union of the top K series of the group in each shard.
+		The same applies to bottomk.
+	*/
+
+	// Create a TOPK/BOTTOMK sub-query for each shard and squash it into a CONCAT expression.
+	sharded, err := summer.shardAndSquashAggregateExpr(expr, expr.Op)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &parser.AggregateExpr{
+		Op:       expr.Op,
+		Expr:     sharded,
+		Param:    expr.Param,
+		Grouping: expr.Grouping,
+		Without:  expr.Without,
+	}, true, nil
+}
+
 // shardAndSquashAggregateExpr returns a squashed CONCAT expression including N embedded
 // queries, where N is the number of shards and each sub-query queries a different shard
 // with the given "op" aggregation operation.
@@ -458,6 +595,7 @@ func (summer *shardSummer) shardAndSquashAggregateExpr(expr *parser.AggregateExp
 		children = append(children, &parser.AggregateExpr{
 			Op:       op,
 			Expr:     sharded,
+			Param:    expr.Param,
 			Grouping: expr.Grouping,
 			Without:  expr.Without,
 		})
@@ -178,11 +178,69 @@ func TestShardSummer(t *testing.T) {
 				`)`,
 			6,
 		},
+		{
+			`topk(10, rate(foo[1m]))`,
+			`topk(10, ` + concatShards(3, `topk(10, rate(foo{__query_shard__="x_of_y"}[1m]))`) + `)`,
+			3,
+		},
+		{
+			`bottomk by (foo) (5, foo{bar="baz"})`,
+			`bottomk by (foo) (5, ` + concatShards(3, `bottomk by (foo) (5, foo{__query_shard__="x_of_y",bar="baz"})`) + `)`,
+			3,
+		},
+		{
+			`topk(scalar(bar), foo)`,
+			concat(`topk(scalar(bar), foo)`),
+			0,
+		},
+		{
+			`topk(5, sum by (foo) (rate(foo[1m])))`,
+			`topk(5, sum by (foo) (` + concatShards(3, `sum by (foo) (rate(foo{__query_shard__="x_of_y"}[1m]))`) + `))`,
+			3,
+		},
+		{
+			`histogram_quantile(0.99, sum by (le) (rate(foo_bucket[5m])))`,
+			`histogram_quantile(0.99, sum by (le) (` + concatShards(3, `sum by (le) (rate(foo_bucket{__query_shard__="x_of_y"}[5m]))`) + `))`,
+			3,
+		},
 		{
 			`min_over_time(metric_counter[5m])`,
 			concat(`min_over_time(metric_counter[5m])`),
 			0,
 		},
+		{
+			`sum_over_time(
+				sum by(group_1) (
+					rate(metric_counter[5m])
+				)[10m:2m]
+			)`,
+			`sum by(group_1) (` + concatShards(3, `sum_over_time(
+					sum by(group_1) (
+						rate(metric_counter{__query_shard__="x_of_y"}[5m])
+					)[10m:2m]
+				)`) + `)`,
+			3,
+		},
+		{
+			`sum_over_time((count without(unique) (metric_counter))[10m:2m] offset 5m)`,
+			`sum without(unique) (` + concatShards(3, `sum_over_time((count without(unique) (metric_counter{__query_shard__="x_of_y"}))[10m:2m] offset 5m)`) + `)`,
+			3,
+		},
+		{
+			`max_over_time(max(rate(metric_counter[5m]))[10m:2m])`,
+			`max(` + concatShards(3, `max_over_time(max(rate(metric_counter{__query_shard__="x_of_y"}[5m]))[10m:2m])`) + `)`,
+			3,
+		},
+		{
+			`max_over_time(min(rate(metric_counter[5m]))[10m:2m])`,
+			concat(`max_over_time(min(rate(metric_counter[5m]))[10m:2m])`),
+			0,
+		},
+		{
+			`sum_over_time(sum(sum by (group_1) (metric_counter))[10m:2m])`,
+			concat(`sum_over_time(sum(sum by (group_1) (metric_counter))[10m:2m])`),
+			0,
+		},
 		{
 			`sum by (user, cluster, namespace) (quantile_over_time(0.99, cortex_ingester_active_series[7d]))`,
 			`sum by (user, cluster, namespace)(` +
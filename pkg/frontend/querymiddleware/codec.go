@@ -180,6 +180,7 @@ func newPrometheusCodecMetrics(registerer prometheus.Registerer) *prometheusCode
 type prometheusCodec struct {
 	metrics                            *prometheusCodecMetrics
 	preferredQueryResultResponseFormat string
+	responseStreamingEnabled           bool
 }
 
 type formatter interface {
@@ -196,10 +197,11 @@ var knownFormats = []formatter{
 	protobufFormatter{},
 }
 
-func NewPrometheusCodec(registerer prometheus.Registerer, queryResultResponseFormat string) Codec {
+func NewPrometheusCodec(registerer prometheus.Registerer, queryResultResponseFormat string, responseStreamingEnabled bool) Codec {
 	return prometheusCodec{
 		metrics:                            newPrometheusCodecMetrics(registerer),
 		preferredQueryResultResponseFormat: queryResultResponseFormat,
+		responseStreamingEnabled:           responseStreamingEnabled,
 	}
 }
 
@@ -514,6 +516,9 @@ func (c prometheusCodec) EncodeMetricsQueryRequest(ctx context.Context, r Metric
 	if consistency, ok := api.ReadConsistencyFromContext(ctx); ok {
 		req.Header.Add(api.ReadConsistencyHeader, consistency)
 	}
+	if priority, ok := api.QueryPriorityFromContext(ctx); ok {
+		req.Header.Set(api.QueryPriorityHeader, priority)
+	}
 
 	return req.WithContext(ctx), nil
 }
@@ -584,6 +589,9 @@ func (c prometheusCodec) EncodeLabelsQueryRequest(ctx context.Context, req Label
 	if consistency, ok := api.ReadConsistencyFromContext(ctx); ok {
 		r.Header.Add(api.ReadConsistencyHeader, consistency)
 	}
+	if priority, ok := api.QueryPriorityFromContext(ctx); ok {
+		r.Header.Set(api.QueryPriorityHeader, priority)
+	}
 
 	return r.WithContext(ctx), nil
 }
@@ -683,6 +691,15 @@ func (c prometheusCodec) EncodeResponse(ctx context.Context, req *http.Request,
 		return nil, apierror.New(apierror.TypeNotAcceptable, "none of the content types in the Accept header are supported")
 	}
 
+	if c.responseStreamingEnabled && formatter == jsonFormatterInstance && isStreamableJSONResponse(a) {
+		sp.LogFields(otlog.Bool("streaming", true))
+		resp, err := c.encodeStreamingJSONResponse(a, selectedContentType)
+		if err != nil {
+			return nil, apierror.Newf(apierror.TypeInternal, "error encoding response: %v", err)
+		}
+		return resp, nil
+	}
+
 	start := time.Now()
 	b, err := formatter.EncodeResponse(a)
 	if err != nil {
@@ -704,6 +721,80 @@ func (c prometheusCodec) EncodeResponse(ctx context.Context, req *http.Request,
 	return &resp, nil
 }
 
+// encodeStreamingJSONResponse returns a response whose body is the JSON encoding of res, written series by series
+// while the body is read, so that the whole encoded response is never held in memory. The response itself is
+// already merged in memory: the samples of each series come from all the partial queries the query has been split
+// by time into, and sharded queries are evaluated by the query-frontend, so no series is complete before all the
+// partial queries are. An error encoding the first batch of series is returned, so that the request fails
+// before the status code is sent to the client. A later error is returned when reading the body.
+func (c prometheusCodec) encodeStreamingJSONResponse(res *PrometheusResponse, contentType string) (*http.Response, error) {
+	start := time.Now()
+	body, err := newEncodingResponseBody(func(w io.Writer) error {
+		return jsonFormatterInstance.EncodeResponseTo(w, res)
+	}, func(size int64) {
+		c.metrics.duration.WithLabelValues(operationEncode, formatJSON).Observe(time.Since(start).Seconds())
+		c.metrics.size.WithLabelValues(operationEncode, formatJSON).Observe(float64(size))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Header: http.Header{
+			"Content-Type": []string{contentType},
+		},
+		Body:          body,
+		StatusCode:    http.StatusOK,
+		ContentLength: -1,
+	}, nil
+}
+
+// newEncodingResponseBody returns a body reading what encode writes while it's running. It waits until encode
+// writes for the first time, and returns the error of encode if it fails before. Errors of encode after its first
+// write are returned when reading the body. onSuccess is called with the size of the body once encode succeeds.
+func newEncodingResponseBody(encode func(io.Writer) error, onSuccess func(size int64)) (io.ReadCloser, error) {
+	reader, writer := io.Pipe()
+	firstWrite := make(chan error, 1)
+
+	go func() {
+		w := &countingWriter{w: writer, firstWrite: firstWrite}
+		err := encode(w)
+		if w.firstWrite != nil {
+			// Nothing has been written.
+			w.firstWrite <- err
+		}
+		if err == nil {
+			onSuccess(w.n)
+		}
+
+		// The error, if any, is returned to the reader of the response body.
+		_ = writer.CloseWithError(err)
+	}()
+
+	if err := <-firstWrite; err != nil {
+		_ = reader.Close()
+		return nil, err
+	}
+	return reader, nil
+}
+
+// countingWriter counts the bytes written to w, and notifies firstWrite before the first write.
+type countingWriter struct {
+	w          io.Writer
+	n          int64
+	firstWrite chan<- error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.firstWrite != nil {
+		c.firstWrite <- nil
+		c.firstWrite = nil
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
 func (prometheusCodec) negotiateContentType(acceptHeader string) (string, formatter) {
 	if acceptHeader == "" {
 		return jsonMimeType, jsonFormatterInstance
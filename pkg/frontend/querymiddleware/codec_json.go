@@ -5,9 +5,20 @@
 
 package querymiddleware
 
-import v1 "github.com/prometheus/prometheus/web/api/v1"
+import (
+	"bufio"
+	"io"
 
-const jsonMimeType = "application/json"
+	"github.com/prometheus/common/model"
+	v1 "github.com/prometheus/prometheus/web/api/v1"
+)
+
+const (
+	jsonMimeType = "application/json"
+
+	// jsonStreamingBufferSize is the size of the buffer used when streaming a JSON-encoded response.
+	jsonStreamingBufferSize = 64 * 1024
+)
 
 type jsonFormatter struct{}
 
@@ -15,6 +26,98 @@ func (j jsonFormatter) EncodeResponse(resp *PrometheusResponse) ([]byte, error)
 	return json.Marshal(resp)
 }
 
+// EncodeResponseTo writes the JSON encoding of resp to w. The result of matrix and vector responses is
+// encoded series by series, so that the whole encoded response is never held in memory. The output is
+// the same as the one of EncodeResponse.
+func (j jsonFormatter) EncodeResponseTo(w io.Writer, resp *PrometheusResponse) error {
+	if !isStreamableJSONResponse(resp) {
+		b, err := j.EncodeResponse(resp)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	}
+
+	bw := bufio.NewWriterSize(w, jsonStreamingBufferSize)
+	enc := jsonStreamEncoder{w: bw}
+
+	enc.writeString(`{"status":`)
+	enc.writeValue(resp.Status)
+	enc.writeString(`,"data":{"resultType":`)
+	enc.writeValue(resp.Data.ResultType)
+	enc.writeString(`,"result":`)
+
+	if resp.Data.Result == nil {
+		enc.writeString("null")
+	} else {
+		enc.writeString("[")
+		for i := range resp.Data.Result {
+			if i > 0 {
+				enc.writeString(",")
+			}
+			if resp.Data.ResultType == model.ValVector.String() {
+				enc.writeValue(vectorSampleStream(resp.Data.Result[i]))
+			} else {
+				enc.writeValue(&resp.Data.Result[i])
+			}
+		}
+		enc.writeString("]")
+	}
+	enc.writeString("}")
+
+	if resp.ErrorType != "" {
+		enc.writeString(`,"errorType":`)
+		enc.writeValue(resp.ErrorType)
+	}
+	if resp.Error != "" {
+		enc.writeString(`,"error":`)
+		enc.writeValue(resp.Error)
+	}
+	if len(resp.Warnings) > 0 {
+		enc.writeString(`,"warnings":`)
+		enc.writeValue(resp.Warnings)
+	}
+	enc.writeString("}")
+
+	if enc.err != nil {
+		return enc.err
+	}
+	return bw.Flush()
+}
+
+// isStreamableJSONResponse returns whether the result of resp can be JSON-encoded series by series.
+func isStreamableJSONResponse(resp *PrometheusResponse) bool {
+	if resp.Data == nil {
+		return false
+	}
+	return resp.Data.ResultType == model.ValMatrix.String() || resp.Data.ResultType == model.ValVector.String()
+}
+
+// jsonStreamEncoder writes JSON-encoded values to an io.Writer, retaining the first error encountered.
+type jsonStreamEncoder struct {
+	w   io.Writer
+	err error
+}
+
+func (e *jsonStreamEncoder) writeString(s string) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = io.WriteString(e.w, s)
+}
+
+func (e *jsonStreamEncoder) writeValue(v any) {
+	if e.err != nil {
+		return
+	}
+	var b []byte
+	if b, e.err = json.Marshal(v); e.err != nil {
+		return
+	}
+	_, e.err = e.w.Write(b)
+}
+
 func (j jsonFormatter) DecodeResponse(buf []byte) (*PrometheusResponse, error) {
 	var resp PrometheusResponse
 
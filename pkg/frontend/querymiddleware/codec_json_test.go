@@ -9,8 +9,10 @@ package querymiddleware
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"net/http"
+	"strconv"
 	"testing"
 
 	"github.com/go-kit/log"
@@ -164,7 +166,7 @@ func TestPrometheusCodec_JSONResponse(t *testing.T) {
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			reg := prometheus.NewPedanticRegistry()
-			codec := NewPrometheusCodec(reg, formatJSON)
+			codec := NewPrometheusCodec(reg, formatJSON, false)
 
 			body, err := json.Marshal(tc.resp)
 			require.NoError(t, err)
@@ -352,7 +354,7 @@ func TestPrometheusCodec_JSONEncoding(t *testing.T) {
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			reg := prometheus.NewPedanticRegistry()
-			codec := NewPrometheusCodec(reg, formatJSON)
+			codec := NewPrometheusCodec(reg, formatJSON, false)
 			httpRequest := &http.Request{
 				Header: http.Header{"Accept": []string{jsonMimeType}},
 			}
@@ -380,3 +382,194 @@ func TestPrometheusCodec_JSONEncoding(t *testing.T) {
 		})
 	}
 }
+
+func TestPrometheusCodec_JSONStreamingEncoding(t *testing.T) {
+	responseHistogram := mimirpb.FloatHistogram{
+		Schema:          3,
+		Count:           3,
+		Sum:             12.5,
+		PositiveSpans:   []mimirpb.BucketSpan{{Offset: 1, Length: 2}},
+		PositiveBuckets: []float64{1, 2},
+	}
+
+	manySeries := make([]SampleStream, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		manySeries = append(manySeries, SampleStream{
+			Labels:  []mimirpb.LabelAdapter{{Name: "__name__", Value: "up"}, {Name: "instance", Value: strconv.Itoa(i)}},
+			Samples: []mimirpb.Sample{{TimestampMs: 1_000, Value: float64(i)}, {TimestampMs: 2_000, Value: float64(i) + 0.5}},
+		})
+	}
+
+	for name, tc := range map[string]struct {
+		response          *PrometheusResponse
+		expectedStreaming bool
+	}{
+		"matrix response": {
+			response: &PrometheusResponse{
+				Status: statusSuccess,
+				Data: &PrometheusData{
+					ResultType: model.ValMatrix.String(),
+					Result: []SampleStream{
+						{Labels: []mimirpb.LabelAdapter{{Name: "foo", Value: "bar"}}, Samples: []mimirpb.Sample{{TimestampMs: 1_000, Value: 1}}},
+						{Labels: []mimirpb.LabelAdapter{{Name: "foo", Value: "baz"}}, Histograms: []mimirpb.FloatHistogramPair{{TimestampMs: 1_234, Histogram: &responseHistogram}}},
+					},
+				},
+				Warnings: []string{"warning <1>", "warning \"2\""},
+			},
+			expectedStreaming: true,
+		},
+		"matrix response with many series": {
+			response: &PrometheusResponse{
+				Status: statusSuccess,
+				Data:   &PrometheusData{ResultType: model.ValMatrix.String(), Result: manySeries},
+			},
+			expectedStreaming: true,
+		},
+		"empty matrix response": {
+			response: &PrometheusResponse{
+				Status: statusSuccess,
+				Data:   &PrometheusData{ResultType: model.ValMatrix.String(), Result: []SampleStream{}},
+			},
+			expectedStreaming: true,
+		},
+		"matrix response without result": {
+			response: &PrometheusResponse{
+				Status: statusSuccess,
+				Data:   &PrometheusData{ResultType: model.ValMatrix.String()},
+			},
+			expectedStreaming: true,
+		},
+		"vector response": {
+			response: &PrometheusResponse{
+				Status: statusSuccess,
+				Data: &PrometheusData{
+					ResultType: model.ValVector.String(),
+					Result: []SampleStream{
+						{Labels: []mimirpb.LabelAdapter{{Name: "foo", Value: "bar"}}, Samples: []mimirpb.Sample{{TimestampMs: 1_000, Value: 1}}},
+						{Labels: []mimirpb.LabelAdapter{{Name: "foo", Value: "baz"}}, Histograms: []mimirpb.FloatHistogramPair{{TimestampMs: 1_234, Histogram: &responseHistogram}}},
+					},
+				},
+			},
+			expectedStreaming: true,
+		},
+		"scalar response": {
+			response: &PrometheusResponse{
+				Status: statusSuccess,
+				Data: &PrometheusData{
+					ResultType: model.ValScalar.String(),
+					Result:     []SampleStream{{Samples: []mimirpb.Sample{{TimestampMs: 1_000, Value: 1}}}},
+				},
+			},
+		},
+		"response without data": {
+			response: &PrometheusResponse{Status: statusSuccess},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			httpRequest := &http.Request{
+				Header: http.Header{"Accept": []string{jsonMimeType}},
+			}
+
+			expected, err := NewPrometheusCodec(prometheus.NewPedanticRegistry(), formatJSON, false).EncodeResponse(context.Background(), httpRequest, tc.response)
+			require.NoError(t, err)
+			expectedJSON, err := readResponseBody(expected)
+			require.NoError(t, err)
+
+			reg := prometheus.NewPedanticRegistry()
+			encoded, err := NewPrometheusCodec(reg, formatJSON, true).EncodeResponse(context.Background(), httpRequest, tc.response)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, encoded.StatusCode)
+			require.Equal(t, jsonMimeType, encoded.Header.Get("Content-Type"))
+
+			encodedJSON, err := io.ReadAll(encoded.Body)
+			require.NoError(t, err)
+			require.NoError(t, encoded.Body.Close())
+			require.Equal(t, string(expectedJSON), string(encodedJSON))
+
+			if !tc.expectedStreaming {
+				require.Equal(t, len(encodedJSON), int(encoded.ContentLength))
+				return
+			}
+			require.Equal(t, int64(-1), encoded.ContentLength)
+
+			metrics, err := dskit_metrics.NewMetricFamilyMapFromGatherer(reg)
+			require.NoError(t, err)
+			payloadSizeHistogram, err := dskit_metrics.FindHistogramWithNameAndLabels(metrics, "cortex_frontend_query_response_codec_payload_bytes", "format", "json", "operation", "encode")
+			require.NoError(t, err)
+			require.Equal(t, uint64(1), *payloadSizeHistogram.SampleCount)
+			require.Equal(t, float64(len(encodedJSON)), *payloadSizeHistogram.SampleSum)
+		})
+	}
+}
+
+func TestPrometheusCodec_JSONStreamingEncoding_BodyClosedEarly(t *testing.T) {
+	series := make([]SampleStream, 0, 10000)
+	for i := 0; i < 10000; i++ {
+		series = append(series, SampleStream{
+			Labels:  []mimirpb.LabelAdapter{{Name: "__name__", Value: "up"}, {Name: "instance", Value: strconv.Itoa(i)}},
+			Samples: []mimirpb.Sample{{TimestampMs: 1_000, Value: float64(i)}},
+		})
+	}
+	response := &PrometheusResponse{
+		Status: statusSuccess,
+		Data:   &PrometheusData{ResultType: model.ValMatrix.String(), Result: series},
+	}
+
+	codec := NewPrometheusCodec(prometheus.NewPedanticRegistry(), formatJSON, true)
+	encoded, err := codec.EncodeResponse(context.Background(), &http.Request{Header: http.Header{}}, response)
+	require.NoError(t, err)
+
+	buf := make([]byte, 1024)
+	_, err = io.ReadFull(encoded.Body, buf)
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(buf, []byte(`{"status":"success","data":{"resultType":"matrix","result":[{"metric":`)))
+
+	// Closing the body before the whole response has been read stops the encoding.
+	require.NoError(t, encoded.Body.Close())
+	_, err = encoded.Body.Read(buf)
+	require.ErrorIs(t, err, io.ErrClosedPipe)
+}
+
+func TestNewEncodingResponseBody(t *testing.T) {
+	t.Run("error before the first write is returned", func(t *testing.T) {
+		body, err := newEncodingResponseBody(func(io.Writer) error {
+			return errors.New("encoding failed")
+		}, func(int64) {
+			t.Error("onSuccess shouldn't be called")
+		})
+		require.EqualError(t, err, "encoding failed")
+		require.Nil(t, body)
+	})
+
+	t.Run("error after the first write is returned when reading the body", func(t *testing.T) {
+		body, err := newEncodingResponseBody(func(w io.Writer) error {
+			if _, err := w.Write([]byte(`{"status":`)); err != nil {
+				return err
+			}
+			return errors.New("encoding failed")
+		}, func(int64) {
+			t.Error("onSuccess shouldn't be called")
+		})
+		require.NoError(t, err)
+
+		encoded, err := io.ReadAll(body)
+		require.EqualError(t, err, "encoding failed")
+		require.Equal(t, `{"status":`, string(encoded))
+	})
+
+	t.Run("successful encoding", func(t *testing.T) {
+		var size int64
+		body, err := newEncodingResponseBody(func(w io.Writer) error {
+			_, err := w.Write([]byte(`{"status":"success"}`))
+			return err
+		}, func(s int64) {
+			size = s
+		})
+		require.NoError(t, err)
+
+		encoded, err := io.ReadAll(body)
+		require.NoError(t, err)
+		require.Equal(t, `{"status":"success"}`, string(encoded))
+		require.Equal(t, int64(len(encoded)), size)
+	})
+}
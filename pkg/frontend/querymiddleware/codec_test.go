@@ -329,7 +329,7 @@ func TestLabelsQueryRequest(t *testing.T) {
 func TestPrometheusCodec_EncodeRequest_AcceptHeader(t *testing.T) {
 	for _, queryResultPayloadFormat := range allFormats {
 		t.Run(queryResultPayloadFormat, func(t *testing.T) {
-			codec := NewPrometheusCodec(prometheus.NewPedanticRegistry(), queryResultPayloadFormat)
+			codec := NewPrometheusCodec(prometheus.NewPedanticRegistry(), queryResultPayloadFormat, false)
 			req := PrometheusInstantQueryRequest{}
 			encodedRequest, err := codec.EncodeMetricsQueryRequest(context.Background(), &req)
 			require.NoError(t, err)
@@ -349,7 +349,7 @@ func TestPrometheusCodec_EncodeRequest_AcceptHeader(t *testing.T) {
 func TestPrometheusCodec_EncodeRequest_ReadConsistency(t *testing.T) {
 	for _, consistencyLevel := range api.ReadConsistencies {
 		t.Run(consistencyLevel, func(t *testing.T) {
-			codec := NewPrometheusCodec(prometheus.NewPedanticRegistry(), formatProtobuf)
+			codec := NewPrometheusCodec(prometheus.NewPedanticRegistry(), formatProtobuf, false)
 			ctx := api.ContextWithReadConsistency(context.Background(), consistencyLevel)
 			encodedRequest, err := codec.EncodeMetricsQueryRequest(ctx, &PrometheusInstantQueryRequest{})
 			require.NoError(t, err)
@@ -523,7 +523,7 @@ func TestPrometheusCodec_DecodeResponse_ContentTypeHandling(t *testing.T) {
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			reg := prometheus.NewPedanticRegistry()
-			codec := NewPrometheusCodec(reg, formatJSON)
+			codec := NewPrometheusCodec(reg, formatJSON, false)
 
 			resp := prometheusAPIResponse{}
 			body, err := json.Marshal(resp)
@@ -1251,5 +1251,5 @@ func TestPrometheusCodec_DecodeMultipleTimes(t *testing.T) {
 }
 
 func newTestPrometheusCodec() Codec {
-	return NewPrometheusCodec(prometheus.NewPedanticRegistry(), formatJSON)
+	return NewPrometheusCodec(prometheus.NewPedanticRegistry(), formatJSON, false)
 }
@@ -0,0 +1,233 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package querymiddleware
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+	util_math "github.com/grafana/mimir/pkg/util/math"
+	"github.com/grafana/mimir/pkg/util/spanlogger"
+)
+
+const (
+	consistencyCheckSuccess = "success"
+	consistencyCheckFailed  = "fail"
+	consistencyCheckSkipped = "skip"
+)
+
+// consistencyChecker compares the results of the queries run through the whole middleware chain with the
+// results of the same queries run without being split, sharded or cached.
+type consistencyChecker struct {
+	sampleRate        float64
+	timeout           time.Duration
+	tolerance         float64
+	useRelativeError  bool
+	skipRecentSamples time.Duration
+	logger            log.Logger
+	checks            *prometheus.CounterVec
+
+	// Replaceable for testing.
+	sample func() bool
+}
+
+func newConsistencyChecker(cfg Config, logger log.Logger, registerer prometheus.Registerer) *consistencyChecker {
+	c := &consistencyChecker{
+		sampleRate:        cfg.ConsistencyCheckSampleRate,
+		timeout:           cfg.ConsistencyCheckTimeout,
+		tolerance:         cfg.ConsistencyCheckValueTolerance,
+		useRelativeError:  cfg.ConsistencyCheckUseRelativeError,
+		skipRecentSamples: cfg.ConsistencyCheckSkipRecentSamples,
+		logger:            logger,
+		checks: promauto.With(registerer).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_query_frontend_consistency_checks_total",
+			Help: "Total number of queries whose results have been compared with the results of the same query run without splitting, sharding and caching.",
+		}, []string{"result"}),
+	}
+	c.sample = func() bool {
+		return rand.Float64() < c.sampleRate
+	}
+	return c
+}
+
+// newConsistencyCheckMiddleware creates a new MetricsQueryMiddleware running the given middlewares. For a sample of
+// the queries, the query is also run through referenceMiddlewares only, bypassing the given middlewares, and the
+// results of the two executions are compared. The query is run a second time in the background after it succeeded, so that the comparison doesn't
+// delay the response.
+func newConsistencyCheckMiddleware(checker *consistencyChecker, referenceMiddlewares []MetricsQueryMiddleware, middlewares ...MetricsQueryMiddleware) MetricsQueryMiddleware {
+	return MetricsQueryMiddlewareFunc(func(next MetricsQueryHandler) MetricsQueryHandler {
+		return &consistencyCheckMiddleware{
+			checker:   checker,
+			next:      MergeMetricsQueryMiddlewares(middlewares...).Wrap(next),
+			reference: MergeMetricsQueryMiddlewares(referenceMiddlewares...).Wrap(next),
+		}
+	})
+}
+
+type consistencyCheckMiddleware struct {
+	checker   *consistencyChecker
+	next      MetricsQueryHandler
+	reference MetricsQueryHandler
+}
+
+func (c *consistencyCheckMiddleware) Do(ctx context.Context, req MetricsQueryRequest) (Response, error) {
+	if !c.checker.sample() {
+		return c.next.Do(ctx, req)
+	}
+
+	res, err := c.next.Do(ctx, req)
+	if err != nil {
+		// The query is not run again if it failed, for example because it was blocked or it hit a limit.
+		c.checker.checks.WithLabelValues(consistencyCheckSkipped).Inc()
+		return res, err
+	}
+
+	// The reference query outlives the request, so it runs with a context detached from the request's cancellation and
+	// bounded by its own timeout. The query details and querier stats of the reference query are tracked separately,
+	// so that they don't inflate the ones of the query.
+	_, referenceCtx := ContextWithEmptyDetails(context.WithoutCancel(ctx))
+	referenceCtx, cancel := context.WithTimeout(referenceCtx, c.checker.timeout)
+
+	go func() {
+		defer cancel()
+
+		referenceRes, referenceErr := c.reference.Do(referenceCtx, req)
+		c.checker.compare(referenceCtx, req, res, referenceRes, referenceErr)
+	}()
+
+	return res, nil
+}
+
+// compare compares the response of the query with the response of the reference query, tracking the outcome.
+func (c *consistencyChecker) compare(ctx context.Context, req MetricsQueryRequest, res, referenceRes Response, referenceErr error) {
+	spanLog := spanlogger.FromContext(ctx, c.logger)
+
+	// The reference query could fail because of a limit enforced on the whole query only, so errors aren't compared.
+	if referenceErr != nil {
+		level.Debug(spanLog).Log("msg", "skipped query result consistency check because the reference query failed", "query", req.GetQuery(), "err", referenceErr)
+		c.checks.WithLabelValues(consistencyCheckSkipped).Inc()
+		return
+	}
+
+	result, compareErr := c.compareResponses(res, referenceRes)
+	c.checks.WithLabelValues(result).Inc()
+	if result != consistencyCheckFailed {
+		return
+	}
+
+	level.Warn(spanLog).Log(
+		"msg", "query result consistency check failed: the result of the query differs from the result of the query run without splitting, sharding and caching",
+		"query", req.GetQuery(),
+		"start", time.UnixMilli(req.GetStart()).UTC().Format(time.RFC3339Nano),
+		"end", time.UnixMilli(req.GetEnd()).UTC().Format(time.RFC3339Nano),
+		"step", time.Duration(req.GetStep())*time.Millisecond,
+		"err", compareErr,
+	)
+}
+
+func (c *consistencyChecker) compareResponses(res, referenceRes Response) (string, error) {
+	promRes, ok := res.(*PrometheusResponse)
+	if !ok {
+		return consistencyCheckSkipped, fmt.Errorf("unexpected response type %T", res)
+	}
+	promReferenceRes, ok := referenceRes.(*PrometheusResponse)
+	if !ok {
+		return consistencyCheckSkipped, fmt.Errorf("unexpected response type %T", referenceRes)
+	}
+
+	if promRes.Status != statusSuccess || promReferenceRes.Status != statusSuccess {
+		return consistencyCheckSkipped, nil
+	}
+	if promRes.Data == nil || promReferenceRes.Data == nil {
+		return consistencyCheckSkipped, nil
+	}
+	if promRes.Data.ResultType != promReferenceRes.Data.ResultType {
+		return consistencyCheckFailed, fmt.Errorf("expected result type %s but got %s", promReferenceRes.Data.ResultType, promRes.Data.ResultType)
+	}
+
+	if err := c.compareSampleStreams(promReferenceRes.Data.Result, promRes.Data.Result); err != nil {
+		return consistencyCheckFailed, err
+	}
+	return consistencyCheckSuccess, nil
+}
+
+// compareSampleStreams compares the series of a matrix, vector or scalar result, regardless of the order of the series.
+func (c *consistencyChecker) compareSampleStreams(expected, actual []SampleStream) error {
+	if len(expected) != len(actual) {
+		return fmt.Errorf("expected %d series but got %d", len(expected), len(actual))
+	}
+
+	actualByLabels := make(map[string]SampleStream, len(actual))
+	for _, stream := range actual {
+		actualByLabels[mimirpb.FromLabelAdaptersToString(stream.Labels)] = stream
+	}
+
+	for _, expectedStream := range expected {
+		series := mimirpb.FromLabelAdaptersToString(expectedStream.Labels)
+		actualStream, ok := actualByLabels[series]
+		if !ok {
+			return fmt.Errorf("expected series %s missing from the result", series)
+		}
+
+		if len(expectedStream.Samples) != len(actualStream.Samples) {
+			return fmt.Errorf("expected %d float samples for series %s but got %d", len(expectedStream.Samples), series, len(actualStream.Samples))
+		}
+		for i, expectedSample := range expectedStream.Samples {
+			if err := c.compareSample(expectedSample, actualStream.Samples[i]); err != nil {
+				return fmt.Errorf("float sample not matching for series %s: %w", series, err)
+			}
+		}
+
+		if len(expectedStream.Histograms) != len(actualStream.Histograms) {
+			return fmt.Errorf("expected %d histogram samples for series %s but got %d", len(expectedStream.Histograms), series, len(actualStream.Histograms))
+		}
+		for i, expectedHistogram := range expectedStream.Histograms {
+			if err := c.compareHistogram(expectedHistogram, actualStream.Histograms[i]); err != nil {
+				return fmt.Errorf("histogram sample not matching for series %s: %w", series, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *consistencyChecker) compareSample(expected, actual mimirpb.Sample) error {
+	if expected.TimestampMs != actual.TimestampMs {
+		return fmt.Errorf("expected timestamp %d but got %d", expected.TimestampMs, actual.TimestampMs)
+	}
+	if c.isRecent(expected.TimestampMs) {
+		return nil
+	}
+	if !util_math.EqualWithinTolerance(expected.Value, actual.Value, c.tolerance, c.useRelativeError) {
+		return fmt.Errorf("expected value %v for timestamp %d but got %v", expected.Value, expected.TimestampMs, actual.Value)
+	}
+	return nil
+}
+
+// compareHistogram compares histograms exactly, because the tolerance only applies to float values.
+func (c *consistencyChecker) compareHistogram(expected, actual mimirpb.FloatHistogramPair) error {
+	if expected.TimestampMs != actual.TimestampMs {
+		return fmt.Errorf("expected timestamp %d but got %d", expected.TimestampMs, actual.TimestampMs)
+	}
+	if c.isRecent(expected.TimestampMs) {
+		return nil
+	}
+	if !expected.Histogram.ToPrometheusModel().Equals(actual.Histogram.ToPrometheusModel()) {
+		return fmt.Errorf("expected histogram %s for timestamp %d but got %s", expected.Histogram.ToPrometheusModel(), expected.TimestampMs, actual.Histogram.ToPrometheusModel())
+	}
+	return nil
+}
+
+// isRecent returns whether the sample at the given timestamp is too recent to be compared, because the data
+// might have been ingested between the two executions of the query.
+func (c *consistencyChecker) isRecent(timestampMs int64) bool {
+	return c.skipRecentSamples > 0 && time.Since(time.UnixMilli(timestampMs)) < c.skipRecentSamples
+}
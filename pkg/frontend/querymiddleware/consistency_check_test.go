@@ -0,0 +1,193 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package querymiddleware
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+)
+
+func TestConsistencyCheckMiddleware(t *testing.T) {
+	req := &PrometheusRangeQueryRequest{Query: "up", Start: 0, End: time.Hour.Milliseconds(), Step: time.Minute.Milliseconds()}
+
+	matrixResponse := func(values ...float64) *PrometheusResponse {
+		var samples []mimirpb.Sample
+		for i, v := range values {
+			samples = append(samples, mimirpb.Sample{TimestampMs: int64(i) * time.Minute.Milliseconds(), Value: v})
+		}
+		return &PrometheusResponse{
+			Status: statusSuccess,
+			Data: &PrometheusData{
+				ResultType: "matrix",
+				Result: []SampleStream{
+					{Labels: []mimirpb.LabelAdapter{{Name: "__name__", Value: "up"}}, Samples: samples},
+				},
+			},
+		}
+	}
+
+	testCases := map[string]struct {
+		sampled           bool
+		tolerance         float64
+		useRelativeError  bool
+		response          *PrometheusResponse
+		err               error
+		referenceResponse *PrometheusResponse
+		referenceErr      error
+		// referenceFailures is the number of times the reference query fails with referenceErr before succeeding.
+		referenceFailures int32
+
+		expectedReferenceQueries int
+		expectedResult           string
+	}{
+		"query not sampled": {
+			response:                 matrixResponse(1, 2),
+			referenceResponse:        matrixResponse(1, 3),
+			expectedReferenceQueries: 0,
+		},
+		"matching results": {
+			sampled:                  true,
+			response:                 matrixResponse(1, 2, math.NaN()),
+			referenceResponse:        matrixResponse(1, 2, math.NaN()),
+			expectedReferenceQueries: 1,
+			expectedResult:           consistencyCheckSuccess,
+		},
+		"results matching within the tolerance": {
+			sampled:                  true,
+			tolerance:                0.001,
+			response:                 matrixResponse(1, 2.0001),
+			referenceResponse:        matrixResponse(1, 2),
+			expectedReferenceQueries: 1,
+			expectedResult:           consistencyCheckSuccess,
+		},
+		"results matching within the relative tolerance": {
+			sampled:                  true,
+			tolerance:                0.001,
+			useRelativeError:         true,
+			response:                 matrixResponse(1, 1000.5),
+			referenceResponse:        matrixResponse(1, 1000),
+			expectedReferenceQueries: 1,
+			expectedResult:           consistencyCheckSuccess,
+		},
+		"mismatching values": {
+			sampled:                  true,
+			response:                 matrixResponse(1, 2),
+			referenceResponse:        matrixResponse(1, 3),
+			expectedReferenceQueries: 1,
+			expectedResult:           consistencyCheckFailed,
+		},
+		"mismatching number of samples": {
+			sampled:                  true,
+			response:                 matrixResponse(1),
+			referenceResponse:        matrixResponse(1, 2),
+			expectedReferenceQueries: 1,
+			expectedResult:           consistencyCheckFailed,
+		},
+		"mismatching series": {
+			sampled:                  true,
+			response:                 &PrometheusResponse{Status: statusSuccess, Data: &PrometheusData{ResultType: "matrix"}},
+			referenceResponse:        matrixResponse(1, 2),
+			expectedReferenceQueries: 1,
+			expectedResult:           consistencyCheckFailed,
+		},
+		"failed query is not checked": {
+			sampled:                  true,
+			err:                      errors.New("query failed"),
+			referenceResponse:        matrixResponse(1, 2),
+			expectedReferenceQueries: 0,
+			expectedResult:           consistencyCheckSkipped,
+		},
+		"failed reference query is retried": {
+			sampled:                  true,
+			response:                 matrixResponse(1, 2),
+			referenceResponse:        matrixResponse(1, 2),
+			referenceErr:             errors.New("query failed"),
+			referenceFailures:        1,
+			expectedReferenceQueries: 2,
+			expectedResult:           consistencyCheckSuccess,
+		},
+		"failed reference query is skipped": {
+			sampled:                  true,
+			response:                 matrixResponse(1, 2),
+			referenceErr:             errors.New("query failed"),
+			expectedReferenceQueries: 2,
+			expectedResult:           consistencyCheckSkipped,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			checker := newConsistencyChecker(Config{
+				ConsistencyCheckSampleRate:       1,
+				ConsistencyCheckTimeout:          time.Minute,
+				ConsistencyCheckValueTolerance:   testCase.tolerance,
+				ConsistencyCheckUseRelativeError: testCase.useRelativeError,
+			}, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+			checker.sample = func() bool { return testCase.sampled }
+
+			// The middleware under check marks the requests it runs, so that the downstream handler can
+			// distinguish them from the reference requests.
+			checked := MetricsQueryMiddlewareFunc(func(next MetricsQueryHandler) MetricsQueryHandler {
+				return HandlerFunc(func(ctx context.Context, req MetricsQueryRequest) (Response, error) {
+					return next.Do(context.WithValue(ctx, consistencyCheckTestKey{}, true), req)
+				})
+			})
+
+			referenceQueries := atomic.NewInt32(0)
+			downstream := HandlerFunc(func(ctx context.Context, _ MetricsQueryRequest) (Response, error) {
+				if ctx.Value(consistencyCheckTestKey{}) != nil {
+					if testCase.err != nil {
+						return nil, testCase.err
+					}
+					return testCase.response, nil
+				}
+				if n := referenceQueries.Inc(); testCase.referenceErr != nil && (testCase.referenceFailures == 0 || n <= testCase.referenceFailures) {
+					return nil, testCase.referenceErr
+				}
+				return testCase.referenceResponse, nil
+			})
+
+			retry := newRetryMiddleware(log.NewNopLogger(), 2, nil)
+			handler := newConsistencyCheckMiddleware(checker, []MetricsQueryMiddleware{retry}, checked).Wrap(downstream)
+			res, err := handler.Do(user.InjectOrgID(context.Background(), "user-1"), req)
+
+			if testCase.err != nil {
+				require.ErrorIs(t, err, testCase.err)
+			} else {
+				require.NoError(t, err)
+				assert.Same(t, testCase.response, res)
+			}
+
+			// The reference query runs in the background, after the query returned.
+			if testCase.expectedResult != "" {
+				require.Eventually(t, func() bool {
+					return testutil.ToFloat64(checker.checks.WithLabelValues(testCase.expectedResult)) == 1
+				}, time.Second, 10*time.Millisecond)
+			}
+			assert.Equal(t, int32(testCase.expectedReferenceQueries), referenceQueries.Load())
+
+			for _, result := range []string{consistencyCheckSuccess, consistencyCheckFailed, consistencyCheckSkipped} {
+				expected := float64(0)
+				if result == testCase.expectedResult {
+					expected = 1
+				}
+				assert.Equal(t, expected, testutil.ToFloat64(checker.checks.WithLabelValues(result)), result)
+			}
+		})
+	}
+}
+
+type consistencyCheckTestKey struct{}
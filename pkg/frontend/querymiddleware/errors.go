@@ -28,3 +28,17 @@ func newMaxQueryExpressionSizeBytesError(actualSizeBytes, maxQuerySizeBytes int)
 func newQueryBlockedError() error {
 	return apierror.New(apierror.TypeBadData, globalerror.QueryBlocked.Message("the request has been blocked by the cluster administrator"))
 }
+
+func newMaxEstimatedQueryCostError(estimatedCost, maxEstimatedCost uint64) error {
+	return apierror.New(apierror.TypeBadData, globalerror.MaxEstimatedQueryCost.MessageWithPerTenantLimitConfig(
+		fmt.Sprintf("the estimated query cost exceeds the limit (estimated cost: %d, limit: %d)", estimatedCost, maxEstimatedCost),
+		validation.MaxEstimatedQueryCostFlag,
+	))
+}
+
+func newEstimatedQueryCostBudgetError(estimatedCost, spentBudget, budget uint64) error {
+	return apierror.New(apierror.TypeTooManyRequests, globalerror.EstimatedQueryCostBudget.MessageWithPerTenantLimitConfig(
+		fmt.Sprintf("the estimated cost of the queries run in the last minute exceeds the limit (estimated cost: %d, spent budget: %d, limit: %d)", estimatedCost, spentBudget, budget),
+		validation.EstimatedQueryCostBudgetPerMinuteFlag,
+	))
+}
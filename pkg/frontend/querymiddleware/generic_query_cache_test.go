@@ -173,6 +173,7 @@ func testGenericQueryCacheRoundTrip(t *testing.T, newRoundTripper newGenericQuer
 								userID: {
 									resultsCacheTTLForCardinalityQuery: testData.cacheTTL,
 									resultsCacheTTLForLabelsQuery:      testData.cacheTTL,
+									resultsCacheTTLForSeriesQuery:      testData.cacheTTL,
 								},
 							},
 						}
@@ -226,7 +227,7 @@ func testGenericQueryCacheRoundTrip(t *testing.T, newRoundTripper newGenericQuer
 						initialStoreCallsCount := cacheBackend.CountStoreCalls()
 
 						reg := prometheus.NewPedanticRegistry()
-						rt := newRoundTripper(cacheBackend, DefaultCacheKeyGenerator{codec: NewPrometheusCodec(reg, formatJSON)}, limits, downstream, testutil.NewLogger(t), reg)
+						rt := newRoundTripper(cacheBackend, DefaultCacheKeyGenerator{codec: NewPrometheusCodec(reg, formatJSON, false)}, limits, downstream, testutil.NewLogger(t), reg)
 						res, err := rt.RoundTrip(req)
 						require.NoError(t, err)
 
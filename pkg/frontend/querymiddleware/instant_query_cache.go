@@ -0,0 +1,315 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package querymiddleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/gogo/protobuf/proto"
+	"github.com/grafana/dskit/cache"
+	"github.com/grafana/dskit/tenant"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	apierror "github.com/grafana/mimir/pkg/api/error"
+	"github.com/grafana/mimir/pkg/util/spanlogger"
+	"github.com/grafana/mimir/pkg/util/validation"
+)
+
+// evaluationTimeFunctions are the functions whose result depends on the evaluation time when called without arguments.
+var evaluationTimeFunctions = map[string]bool{
+	"time":          true,
+	"day_of_month":  true,
+	"day_of_week":   true,
+	"day_of_year":   true,
+	"days_in_month": true,
+	"hour":          true,
+	"minute":        true,
+	"month":         true,
+	"year":          true,
+}
+
+type instantQueryCacheMiddlewareMetrics struct {
+	*resultsCacheMetrics
+
+	queryResultCacheAttemptedCount prometheus.Counter
+	queryResultCacheSkippedCount   *prometheus.CounterVec
+}
+
+func newInstantQueryCacheMiddlewareMetrics(reg prometheus.Registerer) *instantQueryCacheMiddlewareMetrics {
+	m := &instantQueryCacheMiddlewareMetrics{
+		resultsCacheMetrics: newResultsCacheMetrics(queryTypeInstant, reg),
+		queryResultCacheAttemptedCount: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_frontend_instant_query_result_cache_attempted_total",
+			Help: "Total number of instant queries that were attempted to be fetched from cache.",
+		}),
+		queryResultCacheSkippedCount: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_frontend_instant_query_result_cache_skipped_total",
+			Help: "Total number of times an instant query was not cacheable because of a reason. This metric is tracked for each partial query when time-splitting is enabled.",
+		}, []string{"reason"}),
+	}
+
+	// Initialize known label values.
+	for _, reason := range []string{notCachableReasonTooNew, notCachableReasonModifiersNotCachable} {
+		m.queryResultCacheSkippedCount.WithLabelValues(reason)
+	}
+
+	return m
+}
+
+// instantQueryCacheMiddleware is a MetricsQueryMiddleware that runs instant queries through the results cache.
+//
+// When instant query splitting is enabled, this middleware runs after the splitting, so that the result of each
+// partial query is cached on its own: the partial queries selecting old data can be served from the cache, while
+// only the partial queries selecting recent data are executed.
+type instantQueryCacheMiddleware struct {
+	next      MetricsQueryHandler
+	limits    Limits
+	cache     cache.Cache
+	keyGen    CacheKeyGenerator
+	extractor Extractor
+	logger    log.Logger
+	metrics   *instantQueryCacheMiddlewareMetrics
+
+	// Can be set from tests
+	currentTime func() time.Time
+}
+
+// newInstantQueryCacheMiddleware makes a new instantQueryCacheMiddleware.
+func newInstantQueryCacheMiddleware(
+	limits Limits,
+	cache cache.Cache,
+	keyGen CacheKeyGenerator,
+	extractor Extractor,
+	logger log.Logger,
+	reg prometheus.Registerer) MetricsQueryMiddleware {
+	metrics := newInstantQueryCacheMiddlewareMetrics(reg)
+
+	return MetricsQueryMiddlewareFunc(func(next MetricsQueryHandler) MetricsQueryHandler {
+		return &instantQueryCacheMiddleware{
+			next:        next,
+			limits:      limits,
+			cache:       cache,
+			keyGen:      keyGen,
+			extractor:   extractor,
+			logger:      logger,
+			metrics:     metrics,
+			currentTime: time.Now,
+		}
+	})
+}
+
+func (c *instantQueryCacheMiddleware) Do(ctx context.Context, req MetricsQueryRequest) (Response, error) {
+	spanLog := spanlogger.FromContext(ctx, c.logger)
+
+	if req.GetOptions().CacheDisabled {
+		return c.next.Do(ctx, req)
+	}
+
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return nil, apierror.New(apierror.TypeBadData, err.Error())
+	}
+
+	c.metrics.queryResultCacheAttemptedCount.Inc()
+
+	maxCacheFreshness := validation.MaxDurationPerTenant(tenantIDs, c.limits.MaxCacheFreshness)
+	maxCacheTime := c.currentTime().Add(-maxCacheFreshness).UnixMilli()
+
+	if !areEvaluationTimeModifiersCachable(req, maxCacheTime, c.logger) {
+		c.skip(spanLog, req, tenantIDs, notCachableReasonModifiersNotCachable)
+		return c.next.Do(ctx, req)
+	}
+
+	keyReq := instantQueryCacheKeyRequest(req)
+
+	// Do not cache the result if the data it selects is more recent than the configured max cache freshness.
+	if keyReq.GetStart() > maxCacheTime {
+		c.skip(spanLog, req, tenantIDs, notCachableReasonTooNew)
+		return c.next.Do(ctx, req)
+	}
+
+	key := c.keyGen.QueryRequest(ctx, tenant.JoinTenantIDs(tenantIDs), keyReq)
+	if cached := c.fetchCachedResponse(ctx, key, tenantIDs); cached != nil {
+		if keyReq.GetStart() != req.GetStart() {
+			setSampleTimestamps(cached, req.GetStart())
+		}
+
+		return cached, nil
+	}
+
+	res, err := c.next.Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if isResponseCachable(res, c.logger) {
+		c.storeCachedResponse(ctx, key, keyReq, tenantIDs, res)
+	}
+
+	return res, nil
+}
+
+func (c *instantQueryCacheMiddleware) skip(spanLog *spanlogger.SpanLogger, req MetricsQueryRequest, tenantIDs []string, reason string) {
+	level.Debug(spanLog).Log("msg", "skipping response cache as query is not cacheable", "query", req.GetQuery(), "reason", reason, "tenants", tenant.JoinTenantIDs(tenantIDs))
+	c.metrics.queryResultCacheSkippedCount.WithLabelValues(reason).Inc()
+}
+
+// fetchCachedResponse returns the response cached for key, or nil if there is no cached response or it has outlived
+// the configured TTL.
+func (c *instantQueryCacheMiddleware) fetchCachedResponse(ctx context.Context, key string, tenantIDs []string) Response {
+	spanLog, ctx := spanlogger.NewWithLogger(ctx, c.logger, "instantQueryCacheMiddleware.fetchCachedResponse")
+	defer spanLog.Finish()
+
+	hashedKey := cacheHashKey(key)
+	spanLog.LogKV("msg", "looking up", "key", key, "hashedKey", hashedKey)
+
+	c.metrics.cacheRequests.Inc()
+	founds := c.cache.Fetch(ctx, []string{hashedKey})
+
+	data, ok := founds[hashedKey]
+	if !ok {
+		return nil
+	}
+
+	var cached CachedResponse
+	if err := proto.Unmarshal(data, &cached); err != nil {
+		level.Error(spanLog).Log("msg", "error unmarshalling cached response", "err", err)
+		spanLog.Error(err)
+		return nil
+	}
+
+	// Ensure there's no hashed key collision.
+	if cached.Key != key || len(cached.Extents) != 1 {
+		return nil
+	}
+
+	now := c.currentTime()
+	extent := cached.Extents[0]
+	ttl, ttlInOOO, oooWindow := getResultsCacheOptions(c.limits, tenantIDs)
+	if usedTTL := getTTLForExtent(now, ttl, ttlInOOO, oooWindow, extent); extent.QueryTimestampMs < now.Add(-usedTTL).UnixMilli() {
+		spanLog.LogKV("msg", "cached response is outside ttl", "hashedKey", hashedKey)
+		return nil
+	}
+
+	res, err := extent.toResponse()
+	if err != nil {
+		level.Error(spanLog).Log("msg", "error decoding cached response", "err", err)
+		spanLog.Error(err)
+		return nil
+	}
+
+	c.metrics.cacheHits.Inc()
+	spanLog.LogKV("msg", "fetched", "hashedKey", hashedKey, "traceID", extent.TraceId, "bytes", len(data))
+
+	return res
+}
+
+// storeCachedResponse stores the response to keyReq in the cache.
+func (c *instantQueryCacheMiddleware) storeCachedResponse(ctx context.Context, key string, keyReq MetricsQueryRequest, tenantIDs []string, res Response) {
+	now := c.currentTime()
+
+	extent, err := toExtent(ctx, keyReq, c.extractor.ResponseWithoutHeaders(res), now)
+	if err != nil {
+		level.Error(c.logger).Log("msg", "error creating cached extent", "err", err)
+		return
+	}
+
+	ttl, ttlInOOO, oooWindow := getResultsCacheOptions(c.limits, tenantIDs)
+	usedTTL := getTTLForExtent(now, ttl, ttlInOOO, oooWindow, extent)
+
+	buf, err := proto.Marshal(&CachedResponse{
+		Key:     key,
+		Extents: []Extent{extent},
+	})
+	if err != nil {
+		level.Error(c.logger).Log("msg", "error marshalling cached extent", "err", err)
+		return
+	}
+
+	c.cache.StoreAsync(map[string][]byte{cacheHashKey(key): buf}, usedTTL)
+}
+
+// instantQueryCacheKeyRequest returns the request to use to generate the cache key for req.
+//
+// If all selectors in an instant query have the same offset, its result is the same as the result of the query without
+// offset evaluated at the evaluation time minus the offset, except for the timestamp of the samples. The partial queries
+// of a split instant query select the same data with different offsets at different evaluation times, so removing the
+// offset allows reusing their results across evaluation times.
+func instantQueryCacheKeyRequest(req MetricsQueryRequest) MetricsQueryRequest {
+	expr, err := parser.ParseExpr(req.GetQuery())
+	if err != nil {
+		return req
+	}
+
+	// The timestamps of the samples of a range vector are not the evaluation time, so they can't be changed when reusing
+	// the cached result.
+	if t := expr.Type(); t != parser.ValueTypeVector && t != parser.ValueTypeScalar {
+		return req
+	}
+
+	offset, ok := getCommonOffset(expr)
+	if !ok || offset == 0 {
+		return req
+	}
+
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		if selector, ok := node.(*parser.VectorSelector); ok {
+			selector.OriginalOffset = 0
+		}
+		return nil
+	})
+
+	evaluationTime := req.GetStart() - offset.Milliseconds()
+	return req.WithQuery(expr.String()).WithStartEnd(evaluationTime, evaluationTime)
+}
+
+// getCommonOffset returns the offset of all selectors in expr. It returns false if selectors have different offsets, or if
+// the result of expr depends on the evaluation time otherwise than through the data selected.
+func getCommonOffset(expr parser.Expr) (offset time.Duration, ok bool) {
+	found := false
+	ok = true
+
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		switch e := node.(type) {
+		case *parser.VectorSelector:
+			if e.Timestamp != nil || e.StartOrEnd != 0 || (found && e.OriginalOffset != offset) {
+				ok = false
+			}
+			offset = e.OriginalOffset
+			found = true
+		case *parser.SubqueryExpr:
+			// The evaluation steps of subqueries are aligned to multiples of the subquery step, so they may change
+			// when removing the offset.
+			ok = false
+		case *parser.Call:
+			if evaluationTimeFunctions[e.Func.Name] && len(e.Args) == 0 {
+				ok = false
+			}
+		}
+		return nil
+	})
+
+	return offset, ok && found
+}
+
+// setSampleTimestamps sets the timestamp of all samples in res to ts.
+func setSampleTimestamps(res Response, ts int64) {
+	promRes, ok := res.(*PrometheusResponse)
+	if !ok || promRes.Data == nil {
+		return
+	}
+
+	for _, stream := range promRes.Data.Result {
+		for i := range stream.Samples {
+			stream.Samples[i].TimestampMs = ts
+		}
+		for i := range stream.Histograms {
+			stream.Histograms[i].TimestampMs = ts
+		}
+	}
+}
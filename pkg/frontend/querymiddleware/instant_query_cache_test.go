@@ -0,0 +1,266 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package querymiddleware
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/cache"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir/pkg/mimirpb"
+)
+
+func TestInstantQueryCacheMiddleware(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	limits := mockLimits{maxCacheFreshness: 10 * time.Minute, resultsCacheTTL: resultsCacheTTL, resultsCacheOutOfOrderWindowTTL: resultsCacheLowerTTL}
+
+	type request struct {
+		query   string
+		time    time.Time
+		options Options
+
+		expectedDownstreamRequest bool
+		expectedTimestamp         time.Time
+	}
+
+	testCases := map[string]struct {
+		requests         []request
+		expectedSkipped  map[string]int
+		expectedRequests int
+		expectedHits     int
+	}{
+		"same query at same time": {
+			requests: []request{
+				{query: `sum(up)`, time: now.Add(-time.Hour), expectedDownstreamRequest: true, expectedTimestamp: now.Add(-time.Hour)},
+				{query: `sum(up)`, time: now.Add(-time.Hour), expectedDownstreamRequest: false, expectedTimestamp: now.Add(-time.Hour)},
+			},
+			expectedRequests: 2,
+			expectedHits:     1,
+		},
+		"same query at different times": {
+			requests: []request{
+				{query: `sum(up)`, time: now.Add(-time.Hour), expectedDownstreamRequest: true, expectedTimestamp: now.Add(-time.Hour)},
+				{query: `sum(up)`, time: now.Add(-2 * time.Hour), expectedDownstreamRequest: true, expectedTimestamp: now.Add(-2 * time.Hour)},
+			},
+			expectedRequests: 2,
+		},
+		"same data selected with different offsets at different times": {
+			requests: []request{
+				{query: `sum_over_time(up[1h] offset 2h)`, time: now, expectedDownstreamRequest: true, expectedTimestamp: now},
+				{query: `sum_over_time(up[1h] offset 3h)`, time: now.Add(time.Hour), expectedDownstreamRequest: false, expectedTimestamp: now.Add(time.Hour)},
+			},
+			expectedRequests: 2,
+			expectedHits:     1,
+		},
+		"query more recent than max cache freshness": {
+			requests: []request{
+				{query: `sum(up)`, time: now.Add(-time.Minute), expectedDownstreamRequest: true, expectedTimestamp: now.Add(-time.Minute)},
+				{query: `sum(up)`, time: now.Add(-time.Minute), expectedDownstreamRequest: true, expectedTimestamp: now.Add(-time.Minute)},
+			},
+			expectedSkipped: map[string]int{notCachableReasonTooNew: 2},
+		},
+		"query with negative offset": {
+			requests: []request{
+				{query: `sum(up offset -1h)`, time: now.Add(-2 * time.Hour), expectedDownstreamRequest: true, expectedTimestamp: now.Add(-2 * time.Hour)},
+				{query: `sum(up offset -1h)`, time: now.Add(-2 * time.Hour), expectedDownstreamRequest: true, expectedTimestamp: now.Add(-2 * time.Hour)},
+			},
+			expectedSkipped: map[string]int{notCachableReasonModifiersNotCachable: 2},
+		},
+		"cache disabled for the request": {
+			requests: []request{
+				{query: `sum(up)`, time: now.Add(-time.Hour), options: Options{CacheDisabled: true}, expectedDownstreamRequest: true, expectedTimestamp: now.Add(-time.Hour)},
+				{query: `sum(up)`, time: now.Add(-time.Hour), options: Options{CacheDisabled: true}, expectedDownstreamRequest: true, expectedTimestamp: now.Add(-time.Hour)},
+			},
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			reg := prometheus.NewPedanticRegistry()
+			mw := newInstantQueryCacheMiddleware(limits, cache.NewInstrumentedMockCache(), DefaultCacheKeyGenerator{interval: day}, PrometheusResponseExtractor{}, log.NewNopLogger(), reg)
+
+			downstreamReqs := 0
+			handler := mw.Wrap(HandlerFunc(func(_ context.Context, req MetricsQueryRequest) (Response, error) {
+				downstreamReqs++
+				return &PrometheusResponse{
+					Status: statusSuccess,
+					Data: &PrometheusData{
+						ResultType: model.ValVector.String(),
+						Result: []SampleStream{
+							{
+								Labels:  []mimirpb.LabelAdapter{{Name: "foo", Value: "bar"}},
+								Samples: []mimirpb.Sample{{Value: 137, TimestampMs: req.GetStart()}},
+							},
+						},
+					},
+				}, nil
+			}))
+			handler.(*instantQueryCacheMiddleware).currentTime = func() time.Time { return now }
+
+			ctx := user.InjectOrgID(context.Background(), "1")
+
+			for _, r := range testCase.requests {
+				downstreamReqsBefore := downstreamReqs
+
+				res, err := handler.Do(ctx, &PrometheusInstantQueryRequest{
+					Path:    "/api/v1/query",
+					Time:    r.time.UnixMilli(),
+					Query:   r.query,
+					Options: r.options,
+				})
+				require.NoError(t, err)
+				require.Equal(t, r.expectedDownstreamRequest, downstreamReqs > downstreamReqsBefore)
+
+				result := res.(*PrometheusResponse).Data.Result
+				require.Len(t, result, 1)
+				require.Equal(t, []mimirpb.Sample{{Value: 137, TimestampMs: r.expectedTimestamp.UnixMilli()}}, result[0].Samples)
+			}
+
+			metrics := handler.(*instantQueryCacheMiddleware).metrics
+			for _, reason := range []string{notCachableReasonTooNew, notCachableReasonModifiersNotCachable} {
+				assert.Equal(t, float64(testCase.expectedSkipped[reason]), testutil.ToFloat64(metrics.queryResultCacheSkippedCount.WithLabelValues(reason)), reason)
+			}
+			assert.Equal(t, float64(testCase.expectedRequests), testutil.ToFloat64(metrics.cacheRequests))
+			assert.Equal(t, float64(testCase.expectedHits), testutil.ToFloat64(metrics.cacheHits))
+		})
+	}
+}
+
+func TestInstantQueryCacheMiddleware_SplitQueries(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 20, 0, 0, time.UTC)
+	limits := mockLimits{maxCacheFreshness: 10 * time.Minute, resultsCacheTTL: resultsCacheTTL, resultsCacheOutOfOrderWindowTTL: resultsCacheLowerTTL, splitInstantQueriesInterval: time.Hour}
+
+	cacheMiddleware := newInstantQueryCacheMiddleware(limits, cache.NewInstrumentedMockCache(), DefaultCacheKeyGenerator{interval: day}, PrometheusResponseExtractor{}, log.NewNopLogger(), nil)
+	splitMiddleware := newSplitInstantQueryByIntervalMiddleware(limits, log.NewNopLogger(), newEngine(), true, nil)
+
+	var (
+		downstreamQueriesMx sync.Mutex
+		downstreamQueries   []string
+	)
+
+	cacheHandler := cacheMiddleware.Wrap(HandlerFunc(func(_ context.Context, req MetricsQueryRequest) (Response, error) {
+		downstreamQueriesMx.Lock()
+		downstreamQueries = append(downstreamQueries, req.GetQuery())
+		downstreamQueriesMx.Unlock()
+
+		return &PrometheusResponse{
+			Status: statusSuccess,
+			Data: &PrometheusData{
+				ResultType: model.ValVector.String(),
+				Result: []SampleStream{
+					{
+						Labels:  []mimirpb.LabelAdapter{{Name: "foo", Value: "bar"}},
+						Samples: []mimirpb.Sample{{Value: 1, TimestampMs: req.GetStart()}},
+					},
+				},
+			},
+		}, nil
+	}))
+	cacheHandler.(*instantQueryCacheMiddleware).currentTime = func() time.Time { return now }
+	handler := splitMiddleware.Wrap(cacheHandler)
+
+	ctx := user.InjectOrgID(context.Background(), "1")
+	query := func(ts time.Time) []string {
+		downstreamQueries = nil
+
+		res, err := handler.Do(ctx, &PrometheusInstantQueryRequest{Path: "/api/v1/query", Time: ts.UnixMilli(), Query: `sum_over_time(up[3h])`})
+		require.NoError(t, err)
+		require.Equal(t, []SampleStream{
+			{
+				Labels:  []mimirpb.LabelAdapter{{Name: "foo", Value: "bar"}},
+				Samples: []mimirpb.Sample{{Value: 4, TimestampMs: ts.UnixMilli()}},
+			},
+		}, res.(*PrometheusResponse).Data.Result)
+
+		sort.Strings(downstreamQueries)
+		return downstreamQueries
+	}
+
+	// All partial queries are executed the first time.
+	require.Equal(t, []string{
+		`sum_over_time(up[19m59s999ms])`,
+		`sum_over_time(up[40m] offset 2h20m)`,
+		`sum_over_time(up[59m59s999ms] offset 1h20m)`,
+		`sum_over_time(up[59m59s999ms] offset 20m)`,
+	}, query(now))
+
+	// Later in the same interval, the partial queries selecting whole intervals are served from the cache, so only the
+	// first and last partial queries are executed.
+	now = now.Add(5 * time.Minute)
+	require.Equal(t, []string{
+		`sum_over_time(up[24m59s999ms])`,
+		`sum_over_time(up[35m] offset 2h25m)`,
+	}, query(now))
+}
+
+func TestInstantQueryCacheKeyRequest(t *testing.T) {
+	const ts = int64(10 * 60 * 60 * 1000)
+
+	testCases := map[string]struct {
+		query         string
+		expectedQuery string
+		expectedTime  int64
+	}{
+		"no offset": {
+			query:         `sum_over_time(up[1h])`,
+			expectedQuery: `sum_over_time(up[1h])`,
+			expectedTime:  ts,
+		},
+		"same offset on all selectors": {
+			query:         `sum(sum_over_time(up[1h] offset 2h)) / sum(count_over_time(up[1h] offset 2h))`,
+			expectedQuery: `sum(sum_over_time(up[1h])) / sum(count_over_time(up[1h]))`,
+			expectedTime:  ts - (2 * time.Hour).Milliseconds(),
+		},
+		"different offsets": {
+			query:         `sum_over_time(up[1h] offset 2h) - sum_over_time(up[1h] offset 1h)`,
+			expectedQuery: `sum_over_time(up[1h] offset 2h) - sum_over_time(up[1h] offset 1h)`,
+			expectedTime:  ts,
+		},
+		"@ modifier": {
+			query:         `sum_over_time(up[1h] @ 3600 offset 2h)`,
+			expectedQuery: `sum_over_time(up[1h] @ 3600 offset 2h)`,
+			expectedTime:  ts,
+		},
+		"subquery": {
+			query:         `max_over_time(up[1h:1m] offset 2h)`,
+			expectedQuery: `max_over_time(up[1h:1m] offset 2h)`,
+			expectedTime:  ts,
+		},
+		"function depending on the evaluation time": {
+			query:         `time() - last_over_time(up[1h] offset 2h)`,
+			expectedQuery: `time() - last_over_time(up[1h] offset 2h)`,
+			expectedTime:  ts,
+		},
+		"date function with argument": {
+			query:         `hour(last_over_time(up[1h] offset 2h))`,
+			expectedQuery: `hour(last_over_time(up[1h]))`,
+			expectedTime:  ts - (2 * time.Hour).Milliseconds(),
+		},
+		"range vector": {
+			query:         `up[1h] offset 2h`,
+			expectedQuery: `up[1h] offset 2h`,
+			expectedTime:  ts,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := &PrometheusInstantQueryRequest{Time: ts, Query: testCase.query}
+			keyReq := instantQueryCacheKeyRequest(req)
+
+			require.Equal(t, testCase.expectedQuery, keyReq.GetQuery())
+			require.Equal(t, testCase.expectedTime, keyReq.GetStart())
+		})
+	}
+}
@@ -46,6 +46,16 @@ type Limits interface {
 	// query may be. 0 means "unlimited".
 	MaxQueryExpressionSizeBytes(userID string) int
 
+	// MaxEstimatedQueryCost returns the limit to the estimated cost of a query. 0 means "unlimited".
+	MaxEstimatedQueryCost(userID string) uint64
+
+	// EstimatedQueryCostBudgetPerMinute returns the limit to the estimated cost of the queries run in the last minute.
+	// 0 means "unlimited".
+	EstimatedQueryCostBudgetPerMinute(userID string) uint64
+
+	// EstimatedQueryCostLimitAction returns what to do with queries exceeding the estimated query cost limits.
+	EstimatedQueryCostLimitAction(userID string) string
+
 	// MaxCacheFreshness returns the period after which results are cacheable,
 	// to prevent caching of very recent results.
 	MaxCacheFreshness(userID string) time.Duration
@@ -95,12 +105,21 @@ type Limits interface {
 	// ResultsCacheTTLForLabelsQuery returns TTL for cached results for label names and values queries.
 	ResultsCacheTTLForLabelsQuery(userID string) time.Duration
 
+	// ResultsCacheTTLForSeriesQuery returns TTL for cached results for series queries.
+	ResultsCacheTTLForSeriesQuery(userID string) time.Duration
+
 	// ResultsCacheForUnalignedQueryEnabled returns whether to cache results for queries that are not step-aligned
 	ResultsCacheForUnalignedQueryEnabled(userID string) bool
 
 	// BlockedQueries returns the blocked queries.
 	BlockedQueries(userID string) []*validation.BlockedQuery
 
+	// QueryPriorityMatchers returns the rules assigning a priority to the queries of the tenant.
+	QueryPriorityMatchers(userID string) []*validation.QueryPriorityMatcher
+
+	// QueryRewriteRules returns the rules rewriting the queries of the tenant.
+	QueryRewriteRules(userID string) []*validation.QueryRewriteRule
+
 	// AlignQueriesWithStep returns if queries should be adjusted to be step-aligned
 	AlignQueriesWithStep(userID string) bool
 
@@ -370,6 +370,18 @@ func (m multiTenantMockLimits) MaxQueryExpressionSizeBytes(userID string) int {
 	return m.byTenant[userID].maxQueryExpressionSizeBytes
 }
 
+func (m multiTenantMockLimits) MaxEstimatedQueryCost(userID string) uint64 {
+	return m.byTenant[userID].maxEstimatedQueryCost
+}
+
+func (m multiTenantMockLimits) EstimatedQueryCostBudgetPerMinute(userID string) uint64 {
+	return m.byTenant[userID].estimatedQueryCostBudgetPerMinute
+}
+
+func (m multiTenantMockLimits) EstimatedQueryCostLimitAction(userID string) string {
+	return m.byTenant[userID].EstimatedQueryCostLimitAction("")
+}
+
 func (m multiTenantMockLimits) MaxQueryParallelism(userID string) int {
 	return m.byTenant[userID].maxQueryParallelism
 }
@@ -422,6 +434,10 @@ func (m multiTenantMockLimits) ResultsCacheTTLForLabelsQuery(userID string) time
 	return m.byTenant[userID].resultsCacheTTLForLabelsQuery
 }
 
+func (m multiTenantMockLimits) ResultsCacheTTLForSeriesQuery(userID string) time.Duration {
+	return m.byTenant[userID].resultsCacheTTLForSeriesQuery
+}
+
 func (m multiTenantMockLimits) ResultsCacheForUnalignedQueryEnabled(userID string) bool {
 	return m.byTenant[userID].resultsCacheForUnalignedQueryEnabled
 }
@@ -430,6 +446,14 @@ func (m multiTenantMockLimits) BlockedQueries(userID string) []*validation.Block
 	return m.byTenant[userID].blockedQueries
 }
 
+func (m multiTenantMockLimits) QueryPriorityMatchers(userID string) []*validation.QueryPriorityMatcher {
+	return m.byTenant[userID].queryPriorityMatchers
+}
+
+func (m multiTenantMockLimits) QueryRewriteRules(userID string) []*validation.QueryRewriteRule {
+	return m.byTenant[userID].queryRewriteRules
+}
+
 func (m multiTenantMockLimits) CreationGracePeriod(userID string) time.Duration {
 	return m.byTenant[userID].creationGracePeriod
 }
@@ -451,6 +475,9 @@ type mockLimits struct {
 	maxQueryLength                       time.Duration
 	maxTotalQueryLength                  time.Duration
 	maxQueryExpressionSizeBytes          int
+	maxEstimatedQueryCost                uint64
+	estimatedQueryCostBudgetPerMinute    uint64
+	estimatedQueryCostLimitAction        string
 	maxCacheFreshness                    time.Duration
 	maxQueryParallelism                  int
 	maxShardedQueries                    int
@@ -466,8 +493,11 @@ type mockLimits struct {
 	resultsCacheOutOfOrderWindowTTL      time.Duration
 	resultsCacheTTLForCardinalityQuery   time.Duration
 	resultsCacheTTLForLabelsQuery        time.Duration
+	resultsCacheTTLForSeriesQuery        time.Duration
 	resultsCacheForUnalignedQueryEnabled bool
 	blockedQueries                       []*validation.BlockedQuery
+	queryPriorityMatchers                []*validation.QueryPriorityMatcher
+	queryRewriteRules                    []*validation.QueryRewriteRule
 	alignQueriesWithStep                 bool
 	queryIngestersWithin                 time.Duration
 }
@@ -487,6 +517,21 @@ func (m mockLimits) MaxQueryExpressionSizeBytes(string) int {
 	return m.maxQueryExpressionSizeBytes
 }
 
+func (m mockLimits) MaxEstimatedQueryCost(string) uint64 {
+	return m.maxEstimatedQueryCost
+}
+
+func (m mockLimits) EstimatedQueryCostBudgetPerMinute(string) uint64 {
+	return m.estimatedQueryCostBudgetPerMinute
+}
+
+func (m mockLimits) EstimatedQueryCostLimitAction(string) string {
+	if m.estimatedQueryCostLimitAction == "" {
+		return validation.EstimatedQueryCostLimitActionReject // Flag default.
+	}
+	return m.estimatedQueryCostLimitAction
+}
+
 func (m mockLimits) MaxQueryParallelism(string) int {
 	if m.maxQueryParallelism == 0 {
 		return 14 // Flag default.
@@ -542,10 +587,22 @@ func (m mockLimits) BlockedQueries(string) []*validation.BlockedQuery {
 	return m.blockedQueries
 }
 
+func (m mockLimits) QueryPriorityMatchers(string) []*validation.QueryPriorityMatcher {
+	return m.queryPriorityMatchers
+}
+
+func (m mockLimits) QueryRewriteRules(string) []*validation.QueryRewriteRule {
+	return m.queryRewriteRules
+}
+
 func (m mockLimits) ResultsCacheTTLForLabelsQuery(string) time.Duration {
 	return m.resultsCacheTTLForLabelsQuery
 }
 
+func (m mockLimits) ResultsCacheTTLForSeriesQuery(string) time.Duration {
+	return m.resultsCacheTTLForSeriesQuery
+}
+
 func (m mockLimits) ResultsCacheForUnalignedQueryEnabled(string) bool {
 	return m.resultsCacheForUnalignedQueryEnabled
 }
@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package querymiddleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/grafana/dskit/concurrency"
+	"github.com/opentracing/opentracing-go"
+	otlog "github.com/opentracing/opentracing-go/log"
+	"github.com/pkg/errors"
+
+	"github.com/grafana/mimir/pkg/querier/stats"
+	"github.com/grafana/mimir/pkg/util/spanlogger"
+	"github.com/grafana/mimir/pkg/util/validation"
+)
+
+var errPartialRequestFailed = errors.New("partial request failed")
+
+// timeRange is a time range in milliseconds. Both start and end are inclusive.
+type timeRange struct {
+	start, end int64
+}
+
+// splitTimeRangeByInterval splits the time range [start, end] into time ranges aligned to multiples of interval.
+func splitTimeRangeByInterval(start, end int64, interval time.Duration) []timeRange {
+	intervalMs := interval.Milliseconds()
+	if intervalMs <= 0 {
+		return []timeRange{{start: start, end: end}}
+	}
+
+	var ranges []timeRange
+	for start <= end {
+		next := (start/intervalMs + 1) * intervalMs
+		if start < 0 && start%intervalMs != 0 {
+			next -= intervalMs
+		}
+
+		ranges = append(ranges, timeRange{start: start, end: min(next-1, end)})
+		start = next
+	}
+
+	return ranges
+}
+
+// getShardCountForPartialQueries returns the number of shards each of the splitCount partial queries of a query
+// should be sharded into, honoring the number of shards requested through the request header.
+func getShardCountForPartialQueries(spanLog *spanlogger.SpanLogger, tenantIDs []string, r *http.Request, limits Limits, splitCount int) int {
+	shardCount := validation.SmallestPositiveIntPerTenant(tenantIDs, limits.QueryShardingTotalShards)
+	shardCount = setShardCountFromHeader(shardCount, r, spanLog)
+
+	// Honor the max number of sharded queries across all the partial queries of the query.
+	if maxShardedQueries := validation.SmallestPositiveIntPerTenant(tenantIDs, limits.QueryShardingMaxShardedQueries); maxShardedQueries > 0 {
+		shardCount = min(shardCount, maxShardedQueries/splitCount)
+	}
+
+	return shardCount
+}
+
+// doPartialRequests executes reqs with next, running at most parallelism requests at a time, and returns the bodies
+// of their responses. If a request fails with a non-200 status code, the response to that request is returned instead,
+// so that the client receives the same error it would have received without splitting the query.
+func doPartialRequests(ctx context.Context, operationName string, reqs []*http.Request, next http.RoundTripper, parallelism int) ([][]byte, *http.Response, error) {
+	var (
+		bodies     = make([][]byte, len(reqs))
+		queryStats = stats.FromContext(ctx)
+		failedMx   sync.Mutex
+		failedResp *http.Response
+	)
+
+	err := concurrency.ForEachJob(ctx, len(reqs), parallelism, func(ctx context.Context, idx int) error {
+		partialStats, childCtx := stats.ContextWithEmptyStats(ctx)
+
+		span, childCtx := opentracing.StartSpanFromContext(childCtx, operationName)
+		defer span.Finish()
+
+		resp, err := next.RoundTrip(reqs[idx].WithContext(childCtx))
+		if err != nil {
+			span.LogFields(otlog.Error(err))
+			return err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			span.LogFields(otlog.Int("statusCode", resp.StatusCode))
+
+			failedMx.Lock()
+			defer failedMx.Unlock()
+			if failedResp == nil {
+				failedResp = resp
+			} else if resp.Body != nil {
+				_ = resp.Body.Close()
+			}
+			return errPartialRequestFailed
+		}
+
+		defer func() { _ = resp.Body.Close() }()
+
+		bodies[idx], err = io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+
+		span.LogFields(otlog.Int("bytes", len(bodies[idx])))
+		queryStats.Merge(partialStats)
+
+		return nil
+	})
+
+	if failedResp != nil {
+		return nil, failedResp, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return bodies, nil, nil
+}
@@ -0,0 +1,342 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package querymiddleware
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/gogo/protobuf/proto"
+	"github.com/grafana/dskit/cache"
+	"github.com/grafana/dskit/tenant"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	apierror "github.com/grafana/mimir/pkg/api/error"
+	"github.com/grafana/mimir/pkg/querier/api"
+	"github.com/grafana/mimir/pkg/util/spanlogger"
+	"github.com/grafana/mimir/pkg/util/validation"
+)
+
+const (
+	queryCostDecisionAdmitted      = "admitted"
+	queryCostDecisionDeprioritized = "deprioritized"
+	queryCostDecisionRejected      = "rejected"
+
+	// queryCostBudgetBuckets is the number of one second buckets the per-tenant query cost budget is tracked in.
+	queryCostBudgetBuckets = 60
+)
+
+// queryCostEstimate is the estimated cost of a query, and the figures it has been computed from.
+type queryCostEstimate struct {
+	cost uint64
+
+	series    uint64
+	selectors int
+	steps     int64
+	dataRange time.Duration
+}
+
+// queryCostMiddleware is a MetricsQueryHandler admitting queries based on their estimated cost. Queries exceeding
+// the per-tenant cost limits are either rejected or deprioritized, depending on the tenant configuration.
+type queryCostMiddleware struct {
+	next          MetricsQueryHandler
+	limits        Limits
+	cache         cache.Cache
+	splitInterval time.Duration
+	budget        *queryCostBudget
+	logger        log.Logger
+
+	decisions *prometheus.CounterVec
+}
+
+// newQueryCostMiddleware returns a MetricsQueryMiddleware enforcing the estimated query cost limits. The cache holds
+// the series count estimates of the cardinality estimation middleware, and may be nil if they aren't available.
+// splitInterval is the interval range queries are split by, and is used to find the estimates of the partial queries.
+func newQueryCostMiddleware(limits Limits, cache cache.Cache, splitInterval time.Duration, logger log.Logger, registerer prometheus.Registerer) MetricsQueryMiddleware {
+	budget := newQueryCostBudget()
+	decisions := promauto.With(registerer).NewCounterVec(prometheus.CounterOpts{
+		Name: "cortex_query_frontend_estimated_query_cost_decisions_total",
+		Help: "Total number of queries subject to cost-based admission, by decision.",
+	}, []string{"decision"})
+
+	return MetricsQueryMiddlewareFunc(func(next MetricsQueryHandler) MetricsQueryHandler {
+		return &queryCostMiddleware{
+			next:          next,
+			limits:        limits,
+			cache:         cache,
+			splitInterval: splitInterval,
+			budget:        budget,
+			logger:        logger,
+			decisions:     decisions,
+		}
+	})
+}
+
+func (m *queryCostMiddleware) Do(ctx context.Context, req MetricsQueryRequest) (Response, error) {
+	spanLog, ctx := spanlogger.NewWithLogger(ctx, m.logger, "queryCostMiddleware.Do")
+	defer spanLog.Finish()
+
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return nil, apierror.New(apierror.TypeBadData, err.Error())
+	}
+
+	maxCost := validation.SmallestPositiveNonZeroUint64PerTenant(tenantIDs, m.limits.MaxEstimatedQueryCost)
+	budget := validation.SmallestPositiveNonZeroUint64PerTenant(tenantIDs, m.limits.EstimatedQueryCostBudgetPerMinute)
+	if maxCost == 0 && budget == 0 {
+		return m.next.Do(ctx, req)
+	}
+
+	estimate, err := m.estimateQueryCost(ctx, tenantIDs, req)
+	if err != nil {
+		// Invalid queries are passed through, so that the client gets the same error it would get from queriers.
+		spanLog.DebugLog("msg", "skipped cost-based admission because the query can't be parsed", "err", err)
+		return m.next.Do(ctx, req)
+	}
+
+	decision, err := m.admit(tenantIDs, estimate.cost, maxCost, time.Now())
+	m.decisions.WithLabelValues(decision).Inc()
+	spanLog.DebugLog(
+		"msg", "estimated query cost",
+		"cost", estimate.cost,
+		"series", estimate.series,
+		"selectors", estimate.selectors,
+		"steps", estimate.steps,
+		"data_range", estimate.dataRange,
+		"decision", decision,
+	)
+
+	if details := QueryDetailsFromContext(ctx); details != nil {
+		details.EstimatedQueryCost = estimate.cost
+		details.QueryCostDecision = decision
+	}
+
+	switch decision {
+	case queryCostDecisionRejected:
+		return nil, err
+	case queryCostDecisionDeprioritized:
+		// The priority is propagated to the partial queries, so that the query-scheduler dequeues them after the
+		// queries of the tenant having a higher priority.
+		ctx = api.ContextWithQueryPriority(ctx, api.DeprioritizedQueryPriority)
+	}
+
+	return m.next.Do(ctx, req)
+}
+
+// admit decides whether a query having the given estimated cost can run, and charges its cost to the budget of the
+// tenants if it runs with its priority unchanged. The returned error is only set if the query is rejected.
+func (m *queryCostMiddleware) admit(tenantIDs []string, cost, maxCost uint64, now time.Time) (string, error) {
+	deprioritize := m.deprioritizeOnLimit(tenantIDs)
+
+	if maxCost > 0 && cost > maxCost {
+		if !deprioritize {
+			return queryCostDecisionRejected, newMaxEstimatedQueryCostError(cost, maxCost)
+		}
+		// Deprioritized queries are not charged to the budget, so that a tenant exceeding its budget gets it back
+		// once the queries it has been charged for are more than a minute old.
+		return queryCostDecisionDeprioritized, nil
+	}
+
+	spent, budget, ok := m.budget.spend(tenantIDs, cost, now, m.limits.EstimatedQueryCostBudgetPerMinute)
+	if !ok {
+		if !deprioritize {
+			return queryCostDecisionRejected, newEstimatedQueryCostBudgetError(cost, spent, budget)
+		}
+		return queryCostDecisionDeprioritized, nil
+	}
+	return queryCostDecisionAdmitted, nil
+}
+
+// deprioritizeOnLimit returns whether queries exceeding the cost limits should be deprioritized rather than rejected.
+// Queries are rejected if any of the tenants is configured to do so.
+func (m *queryCostMiddleware) deprioritizeOnLimit(tenantIDs []string) bool {
+	for _, tenantID := range tenantIDs {
+		if m.limits.EstimatedQueryCostLimitAction(tenantID) != validation.EstimatedQueryCostLimitActionDeprioritize {
+			return false
+		}
+	}
+	return len(tenantIDs) > 0
+}
+
+// estimateQueryCost returns the estimated cost of req. The cost is the estimated number of series selected by the
+// query, or the number of selectors if greater, multiplied by the number of evaluation steps plus the number of
+// minutes of data selected by the query.
+func (m *queryCostMiddleware) estimateQueryCost(ctx context.Context, tenantIDs []string, req MetricsQueryRequest) (queryCostEstimate, error) {
+	expr, err := parser.ParseExpr(req.GetQuery())
+	if err != nil {
+		return queryCostEstimate{}, err
+	}
+
+	estimate := queryCostEstimate{
+		selectors: len(parser.ExtractSelectors(expr)),
+		steps:     1,
+	}
+	if req.GetStep() > 0 {
+		estimate.steps = (req.GetEnd()-req.GetStart())/req.GetStep() + 1
+	}
+
+	if estimate.selectors > 0 {
+		minT, maxT := promql.FindMinMaxTime(&parser.EvalStmt{
+			Expr:     expr,
+			Start:    time.UnixMilli(req.GetStart()),
+			End:      time.UnixMilli(req.GetEnd()),
+			Interval: time.Duration(req.GetStep()) * time.Millisecond,
+		})
+		if maxT > minT {
+			estimate.dataRange = time.Duration(maxT-minT) * time.Millisecond
+		}
+	}
+
+	estimate.series = m.lookupEstimatedSeriesCount(ctx, tenantIDs, req)
+
+	series := max(estimate.series, uint64(estimate.selectors))
+	estimate.cost = series * (uint64(estimate.steps) + uint64(estimate.dataRange/time.Minute))
+
+	return estimate, nil
+}
+
+// lookupEstimatedSeriesCount returns the largest series count estimate of the partial queries req is split into, or
+// of req itself if it isn't split. Returns 0 if no estimate is available, which is always the case when the
+// cardinality estimation middleware is disabled: the cost is then computed from the number of selectors alone.
+func (m *queryCostMiddleware) lookupEstimatedSeriesCount(ctx context.Context, tenantIDs []string, req MetricsQueryRequest) uint64 {
+	if m.cache == nil {
+		return 0
+	}
+
+	reqs := []MetricsQueryRequest{req}
+	if m.splitInterval > 0 && req.GetStep() > 0 {
+		if splitReqs, err := splitQueryByInterval(req, m.splitInterval); err == nil {
+			reqs = splitReqs
+		}
+	}
+
+	userID := tenant.JoinTenantIDs(tenantIDs)
+	keys := make([]string, 0, len(reqs))
+	for _, r := range reqs {
+		keys = append(keys, generateCardinalityEstimationCacheKey(userID, r, cardinalityEstimateBucketSize))
+	}
+
+	var estimate uint64
+	for _, val := range m.cache.Fetch(ctx, keys) {
+		qs := &QueryStatistics{}
+		if err := proto.Unmarshal(val, qs); err != nil {
+			level.Warn(m.logger).Log("msg", "failed to unmarshal cardinality estimate")
+			continue
+		}
+		estimate = max(estimate, qs.EstimatedSeriesCount)
+	}
+
+	return estimate
+}
+
+// queryCostBudget tracks the estimated cost of the queries run by each tenant in the last minute.
+type queryCostBudget struct {
+	mtx       sync.Mutex
+	tenants   map[string]*tenantQueryCostBudget
+	lastSweep int64
+}
+
+// tenantQueryCostBudget holds the cost spent by a tenant in each second of the last minute.
+type tenantQueryCostBudget struct {
+	buckets    [queryCostBudgetBuckets]queryCostBudgetBucket
+	lastUpdate int64
+}
+
+type queryCostBudgetBucket struct {
+	second int64
+	cost   uint64
+}
+
+func newQueryCostBudget() *queryCostBudget {
+	return &queryCostBudget{tenants: map[string]*tenantQueryCostBudget{}}
+}
+
+// spend charges cost to the budget of each tenant having a budget, and returns whether the budget of all tenants
+// allows it. If not, it returns the budget spent by the first tenant exceeding its budget and the budget itself, and
+// nothing is charged.
+func (b *queryCostBudget) spend(tenantIDs []string, cost uint64, now time.Time, budgetFn func(string) uint64) (spent, budget uint64, ok bool) {
+	second := now.Unix()
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.sweep(second)
+
+	ok = true
+	for _, tenantID := range tenantIDs {
+		tenantBudget := budgetFn(tenantID)
+		if tenantBudget == 0 {
+			continue
+		}
+
+		tenantSpent := b.tenants[tenantID].spent(second)
+		if ok && tenantSpent+cost > tenantBudget {
+			spent, budget, ok = tenantSpent, tenantBudget, false
+		}
+	}
+
+	if !ok {
+		return spent, budget, false
+	}
+
+	for _, tenantID := range tenantIDs {
+		if budgetFn(tenantID) == 0 {
+			continue
+		}
+
+		t, exists := b.tenants[tenantID]
+		if !exists {
+			t = &tenantQueryCostBudget{}
+			b.tenants[tenantID] = t
+		}
+		t.add(second, cost)
+	}
+
+	return spent, budget, ok
+}
+
+// sweep removes the tenants which haven't spent any budget in the last minute. It runs at most once a minute.
+// Must be called with the lock held.
+func (b *queryCostBudget) sweep(second int64) {
+	if second-b.lastSweep < queryCostBudgetBuckets {
+		return
+	}
+	b.lastSweep = second
+
+	for tenantID, t := range b.tenants {
+		if second-t.lastUpdate >= queryCostBudgetBuckets {
+			delete(b.tenants, tenantID)
+		}
+	}
+}
+
+// spent returns the cost spent in the minute ending at second. It's safe to call on a nil tenantQueryCostBudget.
+func (t *tenantQueryCostBudget) spent(second int64) uint64 {
+	if t == nil {
+		return 0
+	}
+
+	var total uint64
+	for _, bucket := range t.buckets {
+		if second-bucket.second < queryCostBudgetBuckets {
+			total += bucket.cost
+		}
+	}
+	return total
+}
+
+func (t *tenantQueryCostBudget) add(second int64, cost uint64) {
+	bucket := &t.buckets[second%queryCostBudgetBuckets]
+	if bucket.second != second {
+		bucket.second = second
+		bucket.cost = 0
+	}
+	bucket.cost += cost
+	t.lastUpdate = second
+}
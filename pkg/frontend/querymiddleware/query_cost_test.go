@@ -0,0 +1,269 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package querymiddleware
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gogo/protobuf/proto"
+	"github.com/grafana/dskit/cache"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	apierror "github.com/grafana/mimir/pkg/api/error"
+	"github.com/grafana/mimir/pkg/querier/api"
+	"github.com/grafana/mimir/pkg/util/validation"
+)
+
+func TestQueryCostMiddleware_EstimateQueryCost(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	rangeQuery := func(query string, end time.Time) MetricsQueryRequest {
+		return &PrometheusRangeQueryRequest{Query: query, Start: start.UnixMilli(), End: end.UnixMilli(), Step: time.Minute.Milliseconds()}
+	}
+	instantQuery := func(query string) MetricsQueryRequest {
+		return &PrometheusInstantQueryRequest{Query: query, Time: start.UnixMilli()}
+	}
+
+	testCases := map[string]struct {
+		req              MetricsQueryRequest
+		splitInterval    time.Duration
+		seriesEstimates  []uint64
+		expectedEstimate queryCostEstimate
+	}{
+		"instant query": {
+			req:              instantQuery("up"),
+			expectedEstimate: queryCostEstimate{cost: 1, selectors: 1, steps: 1},
+		},
+		"instant query with range selector": {
+			req:              instantQuery("rate(up[1h])"),
+			expectedEstimate: queryCostEstimate{cost: 61, selectors: 1, steps: 1, dataRange: time.Hour},
+		},
+		"instant query without selectors": {
+			req:              instantQuery("vector(1)"),
+			expectedEstimate: queryCostEstimate{cost: 0, steps: 1},
+		},
+		"range query": {
+			req:              rangeQuery("up", start.Add(time.Hour)),
+			expectedEstimate: queryCostEstimate{cost: 121, selectors: 1, steps: 61, dataRange: time.Hour},
+		},
+		"range query with multiple selectors": {
+			req:              rangeQuery("sum(rate(a[5m])) / sum(rate(b[5m]))", start.Add(time.Hour)),
+			expectedEstimate: queryCostEstimate{cost: 252, selectors: 2, steps: 61, dataRange: time.Hour + 5*time.Minute},
+		},
+		"range query with series estimate": {
+			req:              rangeQuery("up", start.Add(time.Hour)),
+			seriesEstimates:  []uint64{1000},
+			expectedEstimate: queryCostEstimate{cost: 121000, series: 1000, selectors: 1, steps: 61, dataRange: time.Hour},
+		},
+		"range query with series estimates of the partial queries": {
+			req:              rangeQuery("up", start.Add(day)),
+			splitInterval:    day,
+			seriesEstimates:  []uint64{100, 300},
+			expectedEstimate: queryCostEstimate{cost: 300 * (1441 + 1440), series: 300, selectors: 1, steps: 1441, dataRange: day},
+		},
+		"range query with series estimates lower than the number of selectors": {
+			req:              rangeQuery("a + b + c", start.Add(time.Hour)),
+			seriesEstimates:  []uint64{2},
+			expectedEstimate: queryCostEstimate{cost: 363, series: 2, selectors: 3, steps: 61, dataRange: time.Hour},
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			c := cache.NewMockCache()
+			reqs := []MetricsQueryRequest{testCase.req}
+			if testCase.splitInterval > 0 {
+				var err error
+				reqs, err = splitQueryByInterval(testCase.req, testCase.splitInterval)
+				require.NoError(t, err)
+			}
+			require.LessOrEqual(t, len(testCase.seriesEstimates), len(reqs))
+			for i, estimate := range testCase.seriesEstimates {
+				marshaled, err := proto.Marshal(&QueryStatistics{EstimatedSeriesCount: estimate})
+				require.NoError(t, err)
+				c.StoreAsync(map[string][]byte{generateCardinalityEstimationCacheKey("user-1", reqs[i], cardinalityEstimateBucketSize): marshaled}, time.Hour)
+			}
+
+			m := &queryCostMiddleware{cache: c, splitInterval: testCase.splitInterval, logger: log.NewNopLogger()}
+			estimate, err := m.estimateQueryCost(context.Background(), []string{"user-1"}, testCase.req)
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedEstimate, estimate)
+		})
+	}
+}
+
+func TestQueryCostMiddleware_Do(t *testing.T) {
+	// The estimated cost of this query is 121.
+	req := &PrometheusRangeQueryRequest{Query: "up", Start: 0, End: time.Hour.Milliseconds(), Step: time.Minute.Milliseconds()}
+
+	testCases := map[string]struct {
+		limits              mockLimits
+		queries             int
+		expectedDecisions   []string
+		expectedStatusCode  int
+		expectedBudgetSpent uint64
+	}{
+		"no limits": {
+			limits:            mockLimits{},
+			queries:           1,
+			expectedDecisions: []string{""},
+		},
+		"query within max cost": {
+			limits:            mockLimits{maxEstimatedQueryCost: 121},
+			queries:           1,
+			expectedDecisions: []string{queryCostDecisionAdmitted},
+		},
+		"query exceeding max cost is rejected": {
+			limits:             mockLimits{maxEstimatedQueryCost: 120},
+			queries:            1,
+			expectedDecisions:  []string{queryCostDecisionRejected},
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		"query exceeding max cost is deprioritized": {
+			limits:            mockLimits{maxEstimatedQueryCost: 120, estimatedQueryCostLimitAction: validation.EstimatedQueryCostLimitActionDeprioritize},
+			queries:           1,
+			expectedDecisions: []string{queryCostDecisionDeprioritized},
+		},
+		"queries exceeding budget are rejected": {
+			limits:              mockLimits{estimatedQueryCostBudgetPerMinute: 250},
+			queries:             3,
+			expectedDecisions:   []string{queryCostDecisionAdmitted, queryCostDecisionAdmitted, queryCostDecisionRejected},
+			expectedStatusCode:  http.StatusTooManyRequests,
+			expectedBudgetSpent: 242,
+		},
+		"queries exceeding budget are deprioritized": {
+			limits:              mockLimits{estimatedQueryCostBudgetPerMinute: 250, estimatedQueryCostLimitAction: validation.EstimatedQueryCostLimitActionDeprioritize},
+			queries:             3,
+			expectedDecisions:   []string{queryCostDecisionAdmitted, queryCostDecisionAdmitted, queryCostDecisionDeprioritized},
+			expectedBudgetSpent: 242,
+		},
+		"deprioritized queries are not charged to the budget": {
+			limits:              mockLimits{estimatedQueryCostBudgetPerMinute: 250, estimatedQueryCostLimitAction: validation.EstimatedQueryCostLimitActionDeprioritize},
+			queries:             4,
+			expectedDecisions:   []string{queryCostDecisionAdmitted, queryCostDecisionAdmitted, queryCostDecisionDeprioritized, queryCostDecisionDeprioritized},
+			expectedBudgetSpent: 242,
+		},
+		"queries deprioritized for exceeding max cost are not charged to the budget": {
+			limits:              mockLimits{maxEstimatedQueryCost: 120, estimatedQueryCostBudgetPerMinute: 250, estimatedQueryCostLimitAction: validation.EstimatedQueryCostLimitActionDeprioritize},
+			queries:             3,
+			expectedDecisions:   []string{queryCostDecisionDeprioritized, queryCostDecisionDeprioritized, queryCostDecisionDeprioritized},
+			expectedBudgetSpent: 0,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			reg := prometheus.NewPedanticRegistry()
+			var priorities []string
+			next := HandlerFunc(func(ctx context.Context, _ MetricsQueryRequest) (Response, error) {
+				priority, _ := api.QueryPriorityFromContext(ctx)
+				priorities = append(priorities, priority)
+				return &PrometheusResponse{Status: statusSuccess}, nil
+			})
+			handler := newQueryCostMiddleware(testCase.limits, nil, 0, log.NewNopLogger(), reg).Wrap(next)
+
+			var lastErr error
+			for i := 0; i < testCase.queries; i++ {
+				details, ctx := ContextWithEmptyDetails(user.InjectOrgID(context.Background(), "user-1"))
+				_, lastErr = handler.Do(ctx, req)
+
+				assert.Equal(t, testCase.expectedDecisions[i], details.QueryCostDecision)
+				if details.QueryCostDecision != "" {
+					assert.Equal(t, uint64(121), details.EstimatedQueryCost)
+				}
+			}
+
+			if testCase.limits.estimatedQueryCostBudgetPerMinute > 0 {
+				spent := handler.(*queryCostMiddleware).budget.tenants["user-1"].spent(time.Now().Unix())
+				assert.Equal(t, testCase.expectedBudgetSpent, spent)
+			}
+
+			if testCase.expectedStatusCode == 0 {
+				require.NoError(t, lastErr)
+				require.Len(t, priorities, testCase.queries)
+				for i, decision := range testCase.expectedDecisions {
+					if decision == queryCostDecisionDeprioritized {
+						assert.Equal(t, api.DeprioritizedQueryPriority, priorities[i])
+					} else {
+						assert.Empty(t, priorities[i])
+					}
+				}
+				return
+			}
+
+			require.Error(t, lastErr)
+			res, ok := apierror.HTTPResponseFromError(lastErr)
+			require.True(t, ok)
+			assert.Equal(t, int32(testCase.expectedStatusCode), res.Code)
+			assert.Len(t, priorities, testCase.queries-1)
+
+			rejected := testutil.ToFloat64(handler.(*queryCostMiddleware).decisions.WithLabelValues(queryCostDecisionRejected))
+			assert.Equal(t, float64(1), rejected)
+		})
+	}
+}
+
+func TestQueryCostBudget(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	budgetFn := func(tenantID string) uint64 {
+		if tenantID == "unlimited" {
+			return 0
+		}
+		return 100
+	}
+
+	b := newQueryCostBudget()
+
+	spent, budget, ok := b.spend([]string{"user-1"}, 60, now, budgetFn)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(0), spent)
+	assert.Equal(t, uint64(0), budget)
+
+	// Exceeding the budget isn't charged.
+	spent, budget, ok = b.spend([]string{"user-1"}, 60, now.Add(30*time.Second), budgetFn)
+	assert.False(t, ok)
+	assert.Equal(t, uint64(60), spent)
+	assert.Equal(t, uint64(100), budget)
+
+	_, _, ok = b.spend([]string{"user-1"}, 40, now.Add(30*time.Second), budgetFn)
+	assert.True(t, ok)
+
+	_, _, ok = b.spend([]string{"user-1"}, 10, now.Add(40*time.Second), budgetFn)
+	assert.False(t, ok)
+	assert.Equal(t, uint64(100), b.tenants["user-1"].spent(now.Add(40*time.Second).Unix()))
+
+	// The cost spent more than a minute ago doesn't count anymore.
+	_, _, ok = b.spend([]string{"user-1"}, 40, now.Add(time.Minute), budgetFn)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(80), b.tenants["user-1"].spent(now.Add(time.Minute).Unix()))
+
+	// Tenants without a budget are not tracked, and a multi-tenant query is charged to each tenant.
+	_, _, ok = b.spend([]string{"unlimited", "user-2"}, 100, now.Add(time.Minute), budgetFn)
+	assert.True(t, ok)
+	assert.NotContains(t, b.tenants, "unlimited")
+	assert.Contains(t, b.tenants, "user-2")
+
+	_, _, ok = b.spend([]string{"user-1", "user-2"}, 1, now.Add(time.Minute), budgetFn)
+	assert.False(t, ok)
+
+	// Tenants which haven't spent any budget in the last minute are removed.
+	_, _, ok = b.spend([]string{"user-3"}, 1, now.Add(3*time.Minute), budgetFn)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"user-3"}, mapKeys(b.tenants))
+}
+
+func mapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package querymiddleware
+
+import (
+	"net/http"
+
+	"github.com/grafana/dskit/tenant"
+
+	"github.com/grafana/mimir/pkg/querier/api"
+)
+
+// newQueryPriorityRoundTripper returns a http.RoundTripper assigning a priority to the query, which is used by the
+// query-scheduler to dequeue the queries of a tenant with weighted fairness. The priority is taken from the
+// X-Query-Priority header if set and trusted (priorityHeaderEnabled), otherwise from the first of the tenant's query
+// priority matchers matching the request. The priority is stored in the request's context, so that it's propagated
+// to the partial queries too.
+func newQueryPriorityRoundTripper(next http.RoundTripper, limits Limits, priorityHeaderEnabled bool) http.RoundTripper {
+	return RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+		var priority string
+		if priorityHeaderEnabled {
+			priority = r.Header.Get(api.QueryPriorityHeader)
+		}
+		if priority == "" {
+			priority = matchQueryPriority(r, limits)
+		}
+		if priority == "" {
+			if r.Header.Get(api.QueryPriorityHeader) != "" {
+				// Don't forward the untrusted header to the query-scheduler.
+				r = r.Clone(r.Context())
+				r.Header.Del(api.QueryPriorityHeader)
+			}
+			return next.RoundTrip(r)
+		}
+
+		r = r.Clone(api.ContextWithQueryPriority(r.Context(), priority))
+		r.Header.Set(api.QueryPriorityHeader, priority)
+		return next.RoundTrip(r)
+	})
+}
+
+// matchQueryPriority returns the priority of the first query priority matcher matching the request, or an empty
+// string if none matches.
+func matchQueryPriority(r *http.Request, limits Limits) string {
+	tenantIDs, err := tenant.TenantIDs(r.Context())
+	if err != nil {
+		return ""
+	}
+
+	for _, tenantID := range tenantIDs {
+		for _, matcher := range limits.QueryPriorityMatchers(tenantID) {
+			if matcher.Matches(r.Header.Get(matcher.Header)) {
+				return matcher.Priority
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package querymiddleware
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/dskit/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir/pkg/querier/api"
+	"github.com/grafana/mimir/pkg/util/validation"
+)
+
+func TestQueryPriorityRoundTripper(t *testing.T) {
+	matchers := []*validation.QueryPriorityMatcher{
+		mustNewQueryPriorityMatcher(t, "ruler", "User-Agent", "mimir-ruler/.*"),
+		mustNewQueryPriorityMatcher(t, "dashboard", "X-Grafana-Org-Id", ".+"),
+	}
+
+	testCases := map[string]struct {
+		headers               http.Header
+		matchers              []*validation.QueryPriorityMatcher
+		priorityHeaderEnabled bool
+		expectedPriority      string
+	}{
+		"no header nor matchers": {
+			headers: http.Header{"User-Agent": {"mimir-ruler/2.12.0"}},
+		},
+		"priority from header": {
+			headers:               http.Header{api.QueryPriorityHeader: {"adhoc"}, "User-Agent": {"mimir-ruler/2.12.0"}},
+			matchers:              matchers,
+			priorityHeaderEnabled: true,
+			expectedPriority:      "adhoc",
+		},
+		"priority from header ignored when the header is not trusted": {
+			headers:          http.Header{api.QueryPriorityHeader: {"ruler"}, "User-Agent": {"Grafana/11.0.0"}, "X-Grafana-Org-Id": {"1"}},
+			matchers:         matchers,
+			expectedPriority: "dashboard",
+		},
+		"untrusted header removed when no matcher matches": {
+			headers:  http.Header{api.QueryPriorityHeader: {"ruler"}, "User-Agent": {"curl/8.0.0"}},
+			matchers: matchers,
+		},
+		"priority from first matching matcher": {
+			headers:          http.Header{"User-Agent": {"mimir-ruler/2.12.0"}, "X-Grafana-Org-Id": {"1"}},
+			matchers:         matchers,
+			expectedPriority: "ruler",
+		},
+		"priority from other matcher": {
+			headers:          http.Header{"User-Agent": {"Grafana/11.0.0"}, "X-Grafana-Org-Id": {"1"}},
+			matchers:         matchers,
+			expectedPriority: "dashboard",
+		},
+		"no matching matcher": {
+			headers:  http.Header{"User-Agent": {"curl/8.0.0"}},
+			matchers: matchers,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var (
+				downstreamHeader   string
+				downstreamPriority string
+			)
+			next := RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+				downstreamHeader = r.Header.Get(api.QueryPriorityHeader)
+				downstreamPriority, _ = api.QueryPriorityFromContext(r.Context())
+				return &http.Response{StatusCode: http.StatusOK}, nil
+			})
+			rt := newQueryPriorityRoundTripper(next, mockLimits{queryPriorityMatchers: testCase.matchers}, testCase.priorityHeaderEnabled)
+
+			ctx := user.InjectOrgID(context.Background(), "user-1")
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/api/v1/query?query=up", nil)
+			require.NoError(t, err)
+			req.Header = testCase.headers
+
+			_, err = rt.RoundTrip(req)
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedPriority, downstreamHeader)
+			assert.Equal(t, testCase.expectedPriority, downstreamPriority)
+		})
+	}
+}
+
+func mustNewQueryPriorityMatcher(t *testing.T, priority, header, regex string) *validation.QueryPriorityMatcher {
+	m, err := validation.NewQueryPriorityMatcher(priority, header, regex)
+	require.NoError(t, err)
+	return m
+}
+
+func TestQueryPriorityPropagatedToEncodedRequests(t *testing.T) {
+	codec := newTestPrometheusCodec()
+	ctx := api.ContextWithQueryPriority(context.Background(), "ruler")
+
+	req, err := codec.EncodeMetricsQueryRequest(ctx, &PrometheusInstantQueryRequest{Path: "/api/v1/query", Query: "up"})
+	require.NoError(t, err)
+	assert.Equal(t, "ruler", req.Header.Get(api.QueryPriorityHeader))
+}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package querymiddleware
+
+import (
+	"context"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/tenant"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+type queryRewriteMiddleware struct {
+	next                    MetricsQueryHandler
+	limits                  Limits
+	logger                  log.Logger
+	rewrittenQueriesCounter *prometheus.CounterVec
+}
+
+// newQueryRewriteMiddleware creates a new MetricsQueryMiddleware replacing the queries matching one of the tenant's
+// query rewrite rules with the replacement of the first matching rule. Queries spanning multiple tenants are only
+// rewritten if they're rewritten to the same query for all the tenants.
+func newQueryRewriteMiddleware(
+	limits Limits,
+	logger log.Logger,
+	registerer prometheus.Registerer,
+) MetricsQueryMiddleware {
+	rewrittenQueriesCounter := promauto.With(registerer).NewCounterVec(prometheus.CounterOpts{
+		Name: "cortex_query_frontend_rewritten_queries_total",
+		Help: "Number of queries that were rewritten by the query rewrite rules.",
+	}, []string{"user"})
+	return MetricsQueryMiddlewareFunc(func(next MetricsQueryHandler) MetricsQueryHandler {
+		return &queryRewriteMiddleware{
+			next:                    next,
+			limits:                  limits,
+			logger:                  logger,
+			rewrittenQueriesCounter: rewrittenQueriesCounter,
+		}
+	})
+}
+
+func (qr *queryRewriteMiddleware) Do(ctx context.Context, req MetricsQueryRequest) (Response, error) {
+	tenants, err := tenant.TenantIDs(ctx)
+	if err != nil || len(tenants) == 0 {
+		return qr.next.Do(ctx, req)
+	}
+
+	// A query spanning multiple tenants is only rewritten if the rules of all the tenants rewrite it the same way,
+	// so that the rules of a tenant don't change the data returned for the other tenants.
+	var rewritten string
+	var ruleIndex int
+	for i, tenant := range tenants {
+		tenantRewritten, tenantRuleIndex, ok := qr.rewrite(tenant, req.GetQuery())
+		if !ok || (i > 0 && tenantRewritten != rewritten) {
+			return qr.next.Do(ctx, req)
+		}
+		if i == 0 {
+			rewritten, ruleIndex = tenantRewritten, tenantRuleIndex
+		}
+	}
+
+	for _, tenant := range tenants {
+		qr.rewrittenQueriesCounter.WithLabelValues(tenant).Inc()
+	}
+	if details := QueryDetailsFromContext(ctx); details != nil {
+		details.OriginalQuery = req.GetQuery()
+		details.RewrittenQuery = rewritten
+		details.QueryRewriteRuleIndex = ruleIndex
+	}
+	return qr.next.Do(ctx, req.WithQuery(rewritten))
+}
+
+// rewrite returns the query rewritten by the first of the tenant's query rewrite rules matching it, and the index
+// of the rule. The last return value is false if no rule matches the query.
+func (qr *queryRewriteMiddleware) rewrite(tenant string, query string) (string, int, bool) {
+	rules := qr.limits.QueryRewriteRules(tenant)
+	if len(rules) == 0 {
+		return "", 0, false
+	}
+	logger := log.With(qr.logger, "user", tenant)
+
+	for ruleIndex, rule := range rules {
+		rewritten, ok := rule.Rewrite(query)
+		if !ok {
+			continue
+		}
+
+		// The replacement of regular expression rules is only a valid query once the capture groups are expanded.
+		if _, err := parser.ParseExpr(rewritten); err != nil {
+			level.Error(logger).Log("msg", "query rewritten by query rewrite rule is not valid, ignoring query rewrite rule", "pattern", rule.Pattern, "query", query, "rewritten_query", rewritten, "err", err, "index", ruleIndex)
+			continue
+		}
+
+		level.Debug(logger).Log("msg", "query rewritten by query rewrite rule", "pattern", rule.Pattern, "query", query, "rewritten_query", rewritten, "index", ruleIndex)
+		return rewritten, ruleIndex, true
+	}
+	return "", 0, false
+}
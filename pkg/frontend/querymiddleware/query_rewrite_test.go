@@ -0,0 +1,184 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package querymiddleware
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir/pkg/util/validation"
+)
+
+func TestQueryRewriteMiddleware_Do(t *testing.T) {
+	rules := []*validation.QueryRewriteRule{
+		mustNewQueryRewriteRule(t, `broken_(\w+)`, true, "sum($1"),
+		mustNewQueryRewriteRule(t, "sum(rate(requests_total[5m]))", false, "job:requests:rate5m"),
+		mustNewQueryRewriteRule(t, `sum by \((\w+)\) \(rate\(requests_total\[5m\]\)\)`, true, "sum by ($1) (job:requests:rate5m)"),
+		mustNewQueryRewriteRule(t, "up", true, "max($0)"),
+	}
+
+	tests := map[string]struct {
+		query               string
+		rules               []*validation.QueryRewriteRule
+		expectedQuery       string
+		expectedRuleIndex   int
+		expectedRewrittenQs int
+	}{
+		"no rules": {
+			query:         "sum(rate(requests_total[5m]))",
+			expectedQuery: "sum(rate(requests_total[5m]))",
+		},
+		"exact match": {
+			query:               " sum(rate(requests_total[5m])) ",
+			rules:               rules,
+			expectedQuery:       "job:requests:rate5m",
+			expectedRuleIndex:   1,
+			expectedRewrittenQs: 1,
+		},
+		"regex match with capture group": {
+			query:               "sum by (job) (rate(requests_total[5m]))",
+			rules:               rules,
+			expectedQuery:       "sum by (job) (job:requests:rate5m)",
+			expectedRuleIndex:   2,
+			expectedRewrittenQs: 1,
+		},
+		"regex must match the whole query": {
+			query:         "max(sum by (job) (rate(requests_total[5m])))",
+			rules:         rules,
+			expectedQuery: "max(sum by (job) (rate(requests_total[5m])))",
+		},
+		"regex match equal to the pattern expands the capture groups": {
+			query:               "up",
+			rules:               rules,
+			expectedQuery:       "max(up)",
+			expectedRuleIndex:   3,
+			expectedRewrittenQs: 1,
+		},
+		"rule rewriting to an invalid query is ignored": {
+			query:         "broken_replacement",
+			rules:         rules,
+			expectedQuery: "broken_replacement",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			reg := prometheus.NewPedanticRegistry()
+			var downstreamQuery string
+			next := HandlerFunc(func(_ context.Context, req MetricsQueryRequest) (Response, error) {
+				downstreamQuery = req.GetQuery()
+				return &PrometheusResponse{Status: statusSuccess}, nil
+			})
+			handler := newQueryRewriteMiddleware(mockLimits{queryRewriteRules: tt.rules}, log.NewNopLogger(), reg).Wrap(next)
+
+			details, ctx := ContextWithEmptyDetails(user.InjectOrgID(context.Background(), "user-1"))
+			_, err := handler.Do(ctx, &PrometheusRangeQueryRequest{Query: tt.query})
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expectedQuery, downstreamQuery)
+			if tt.expectedRewrittenQs > 0 {
+				assert.Equal(t, tt.query, details.OriginalQuery)
+				assert.Equal(t, tt.expectedQuery, details.RewrittenQuery)
+				assert.Equal(t, tt.expectedRuleIndex, details.QueryRewriteRuleIndex)
+				assert.NoError(t, promtest.GatherAndCompare(reg, strings.NewReader(`
+					# HELP cortex_query_frontend_rewritten_queries_total Number of queries that were rewritten by the query rewrite rules.
+					# TYPE cortex_query_frontend_rewritten_queries_total counter
+					cortex_query_frontend_rewritten_queries_total{user="user-1"} 1
+				`), "cortex_query_frontend_rewritten_queries_total"))
+			} else {
+				assert.Empty(t, details.OriginalQuery)
+				assert.Empty(t, details.RewrittenQuery)
+			}
+		})
+	}
+}
+
+func mustNewQueryRewriteRule(t *testing.T, pattern string, regex bool, replacement string) *validation.QueryRewriteRule {
+	r, err := validation.NewQueryRewriteRule(pattern, regex, replacement)
+	require.NoError(t, err)
+	return r
+}
+
+func TestQueryRewriteMiddleware_Do_MultipleTenants(t *testing.T) {
+	const query = "sum(rate(requests_total[5m]))"
+	rewriteRule := &validation.QueryRewriteRule{Pattern: query, Replacement: "job:requests:rate5m"}
+	otherRewriteRule := &validation.QueryRewriteRule{Pattern: query, Replacement: "sum(job:requests:rate5m)"}
+
+	tests := map[string]struct {
+		rules             map[string][]*validation.QueryRewriteRule
+		expectedQuery     string
+		expectedRewritten bool
+	}{
+		"rule matching for all the tenants": {
+			rules: map[string][]*validation.QueryRewriteRule{
+				"user-1": {rewriteRule},
+				"user-2": {rewriteRule},
+			},
+			expectedQuery:     "job:requests:rate5m",
+			expectedRewritten: true,
+		},
+		"rule matching for one of the tenants only": {
+			rules: map[string][]*validation.QueryRewriteRule{
+				"user-1": {rewriteRule},
+			},
+			expectedQuery: query,
+		},
+		"rules rewriting the query differently": {
+			rules: map[string][]*validation.QueryRewriteRule{
+				"user-1": {rewriteRule},
+				"user-2": {otherRewriteRule},
+			},
+			expectedQuery: query,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			reg := prometheus.NewPedanticRegistry()
+			var downstreamQuery string
+			next := HandlerFunc(func(_ context.Context, req MetricsQueryRequest) (Response, error) {
+				downstreamQuery = req.GetQuery()
+				return &PrometheusResponse{Status: statusSuccess}, nil
+			})
+			limits := perTenantQueryRewriteRulesLimits{rules: tt.rules}
+			handler := newQueryRewriteMiddleware(limits, log.NewNopLogger(), reg).Wrap(next)
+
+			details, ctx := ContextWithEmptyDetails(user.InjectOrgID(context.Background(), "user-1|user-2"))
+			_, err := handler.Do(ctx, &PrometheusRangeQueryRequest{Query: query})
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expectedQuery, downstreamQuery)
+			if tt.expectedRewritten {
+				assert.Equal(t, tt.expectedQuery, details.RewrittenQuery)
+			} else {
+				assert.Empty(t, details.RewrittenQuery)
+			}
+			rewrittenQueries, err := reg.Gather()
+			require.NoError(t, err)
+			if tt.expectedRewritten {
+				require.Len(t, rewrittenQueries, 1)
+				assert.Len(t, rewrittenQueries[0].GetMetric(), 2)
+			} else {
+				assert.Empty(t, rewrittenQueries)
+			}
+		})
+	}
+}
+
+// perTenantQueryRewriteRulesLimits returns the query rewrite rules of each tenant.
+type perTenantQueryRewriteRulesLimits struct {
+	mockLimits
+	rules map[string][]*validation.QueryRewriteRule
+}
+
+func (l perTenantQueryRewriteRulesLimits) QueryRewriteRules(userID string) []*validation.QueryRewriteRule {
+	return l.rules[userID]
+}
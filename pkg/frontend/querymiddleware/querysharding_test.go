@@ -421,6 +421,54 @@ func TestQuerySharding_Correctness(t *testing.T) {
 			query:                  `max by(unique) (max_over_time(metric_counter[5m])) > scalar(min(metric_counter))`,
 			expectedShardedQueries: 2,
 		},
+		"topk()": {
+			query:                  `topk(2, metric_counter{const="fixed"})`,
+			expectedShardedQueries: 1,
+		},
+		"topk() grouping 'by'": {
+			query:                  `topk by (group_1) (3, rate(metric_counter[1m]))`,
+			expectedShardedQueries: 1,
+		},
+		"bottomk()": {
+			query:                  `bottomk(2, metric_counter{const="fixed"})`,
+			expectedShardedQueries: 1,
+		},
+		"bottomk() grouping 'without'": {
+			query:                  `bottomk without (unique) (3, rate(metric_counter[1m]))`,
+			expectedShardedQueries: 1,
+		},
+		"topk() with non constant parameter": {
+			query:                  `topk(scalar(count(metric_counter{group_1="0"})) / 100, metric_counter)`,
+			expectedShardedQueries: 0,
+		},
+		"sum_over_time() of subquery over sum()": {
+			query: `sum_over_time(
+						sum by(group_1) (
+							rate(metric_counter[5m])
+						)[10m:1m]
+					)`,
+			expectedShardedQueries: 1,
+		},
+		"sum_over_time() of subquery over count() with offset": {
+			query:                  `sum_over_time((count without(unique) (metric_counter))[10m:1m] offset 5m)`,
+			expectedShardedQueries: 1,
+		},
+		"max_over_time() of subquery over max()": {
+			query:                  `max_over_time(max by(group_1) (rate(metric_counter[5m]))[10m:1m])`,
+			expectedShardedQueries: 1,
+		},
+		"min_over_time() of subquery over min()": {
+			query:                  `min_over_time(min(rate(metric_counter[5m]))[10m:])`,
+			expectedShardedQueries: 1,
+		},
+		"max by() of max_over_time() of subquery over max()": {
+			query:                  `max by(group_1) (max_over_time(max by(group_1, group_2) (rate(metric_counter[5m]))[10m:1m]))`,
+			expectedShardedQueries: 1,
+		},
+		"histogram_quantile() of sum_over_time() of subquery over sum()": {
+			query:                  `histogram_quantile(0.9, sum_over_time(sum by(le) (rate(metric_histogram_bucket[1m]))[10m:1m]))`,
+			expectedShardedQueries: 1,
+		},
 		//
 		// The following queries are not expected to be shardable.
 		//
@@ -450,14 +498,6 @@ func TestQuerySharding_Correctness(t *testing.T) {
 			query:                  `stdvar(metric_counter{const="fixed"})`,
 			expectedShardedQueries: 0,
 		},
-		"topk()": {
-			query:                  `topk(2, metric_counter{const="fixed"})`,
-			expectedShardedQueries: 0,
-		},
-		"bottomk()": {
-			query:                  `bottomk(2, metric_counter{const="fixed"})`,
-			expectedShardedQueries: 0,
-		},
 		"vector()": {
 			query:                  `vector(1)`,
 			expectedShardedQueries: 0,
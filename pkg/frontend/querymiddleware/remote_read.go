@@ -0,0 +1,576 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package querymiddleware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/grafana/dskit/tenant"
+	"github.com/grafana/dskit/user"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/storage"
+	prom_remote "github.com/prometheus/prometheus/storage/remote"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+
+	apierror "github.com/grafana/mimir/pkg/api/error"
+	"github.com/grafana/mimir/pkg/ingester/client"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/grafana/mimir/pkg/querier/api"
+	"github.com/grafana/mimir/pkg/querier/stats"
+	"github.com/grafana/mimir/pkg/storage/series"
+	"github.com/grafana/mimir/pkg/storage/sharding"
+	"github.com/grafana/mimir/pkg/util"
+	util_math "github.com/grafana/mimir/pkg/util/math"
+	"github.com/grafana/mimir/pkg/util/spanlogger"
+	"github.com/grafana/mimir/pkg/util/validation"
+)
+
+const (
+	// Queries are a set of matchers with time ranges - should not get into megabytes.
+	maxRemoteReadQuerySize = 1024 * 1024
+
+	// Maximum number of bytes in a frame of a streamed remote read response. This is the same limit used by queriers.
+	maxRemoteReadFrameBytes = 1024 * 1024
+)
+
+// remoteReadPartialQuery is a remote read query restricted to a time range and a shard of the series it selects.
+type remoteReadPartialQuery struct {
+	queryIndex int
+	query      *client.QueryRequest
+}
+
+// remoteReadRoundTripper is an http.RoundTripper that splits the queries of remote read requests by time and by
+// series shard, executes the partial queries in parallel, and merges their results.
+type remoteReadRoundTripper struct {
+	next            http.RoundTripper
+	splitInterval   time.Duration
+	shardingEnabled bool
+	limits          Limits
+	logger          log.Logger
+}
+
+func newRemoteReadRoundTripper(next http.RoundTripper, splitInterval time.Duration, shardingEnabled bool, limits Limits, logger log.Logger) http.RoundTripper {
+	return &remoteReadRoundTripper{
+		next:            next,
+		splitInterval:   splitInterval,
+		shardingEnabled: shardingEnabled,
+		limits:          limits,
+		logger:          logger,
+	}
+}
+
+func (rr *remoteReadRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	spanLog, ctx := spanlogger.NewWithLogger(r.Context(), rr.logger, "remoteReadRoundTripper.RoundTrip")
+	defer spanLog.Finish()
+
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return nil, apierror.New(apierror.TypeBadData, err.Error())
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var req client.ReadRequest
+	if err := util.ParseProtoReader(ctx, bytes.NewReader(body), len(body), maxRemoteReadQuerySize, nil, &req, util.RawSnappy); err != nil {
+		return nil, apierror.New(apierror.TypeBadData, err.Error())
+	}
+
+	respType, ok := negotiateRemoteReadResponseType(req.AcceptedResponseTypes)
+	if !ok {
+		// Let the querier reject the request with the same error it would return without this round tripper.
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		return rr.next.RoundTrip(r)
+	}
+
+	var partials []remoteReadPartialQuery
+	for i, query := range req.Queries {
+		query, err := rr.applyLimits(spanLog, tenantIDs, query)
+		if err != nil {
+			return nil, err
+		}
+		if query == nil {
+			continue
+		}
+
+		for _, partial := range rr.splitAndShard(spanLog, tenantIDs, r, query) {
+			partials = append(partials, remoteReadPartialQuery{queryIndex: i, query: partial})
+		}
+	}
+
+	spanLog.DebugLog("msg", "split and sharded remote read request", "queries", len(req.Queries), "partialQueries", len(partials))
+
+	results, failedResp, err := rr.doPartialQueries(ctx, tenantIDs, r, respType, partials)
+	if err != nil {
+		return nil, err
+	}
+	if failedResp != nil {
+		return failedResp, nil
+	}
+
+	// Partial queries are ordered by time for each query, so the samples of each merged series are sorted by time.
+	merged := make([][]mimirpb.TimeSeries, len(req.Queries))
+	for i, partial := range partials {
+		merged[partial.queryIndex] = append(merged[partial.queryIndex], results[i]...)
+	}
+	for i := range merged {
+		merged[i] = mergeRemoteReadSeries(merged[i])
+	}
+
+	if respType == client.STREAMED_XOR_CHUNKS {
+		return encodeRemoteReadStreamedResponse(r, merged)
+	}
+	return encodeRemoteReadSamplesResponse(r, merged)
+}
+
+// applyLimits enforces the per-tenant query time range limits on query. It returns nil if query is fully outside the
+// time range tenants are allowed to query.
+func (rr *remoteReadRoundTripper) applyLimits(spanLog *spanlogger.SpanLogger, tenantIDs []string, query *client.QueryRequest) (*client.QueryRequest, error) {
+	start, end := query.StartTimestampMs, query.EndTimestampMs
+
+	// Clamp the time range based on the max query lookback and block retention period.
+	blocksRetentionPeriod := validation.SmallestPositiveNonZeroDurationPerTenant(tenantIDs, rr.limits.CompactorBlocksRetentionPeriod)
+	maxQueryLookback := validation.SmallestPositiveNonZeroDurationPerTenant(tenantIDs, rr.limits.MaxQueryLookback)
+	if maxLookback := util_math.Min(blocksRetentionPeriod, maxQueryLookback); maxLookback > 0 {
+		minStartTime := util.TimeToMillis(time.Now().Add(-maxLookback))
+
+		if end < minStartTime {
+			level.Debug(spanLog).Log(
+				"msg", "skipping the execution of the remote read query because its time range is before the 'max query lookback' or 'blocks retention period' setting",
+				"reqStart", util.FormatTimeMillis(start),
+				"reqEnd", util.FormatTimeMillis(end),
+				"maxQueryLookback", maxQueryLookback,
+				"blocksRetentionPeriod", blocksRetentionPeriod)
+
+			return nil, nil
+		}
+
+		start = max(start, minStartTime)
+	}
+
+	// Enforce the max end time.
+	creationGracePeriod := validation.LargestPositiveNonZeroDurationPerTenant(tenantIDs, rr.limits.CreationGracePeriod)
+	end = min(end, util.TimeToMillis(time.Now().Add(creationGracePeriod)))
+
+	// Enforce the max query length.
+	if maxQueryLength := validation.SmallestPositiveNonZeroDurationPerTenant(tenantIDs, rr.limits.MaxTotalQueryLength); maxQueryLength > 0 {
+		queryLen := timestamp.Time(end).Sub(timestamp.Time(start))
+		if queryLen > maxQueryLength {
+			return nil, newMaxTotalQueryLengthError(queryLen, maxQueryLength)
+		}
+	}
+
+	return &client.QueryRequest{
+		StartTimestampMs:         start,
+		EndTimestampMs:           end,
+		Matchers:                 query.Matchers,
+		StreamingChunksBatchSize: query.StreamingChunksBatchSize,
+	}, nil
+}
+
+// splitAndShard splits query into partial queries selecting time ranges aligned to the split interval, and then
+// shards each of them by series.
+func (rr *remoteReadRoundTripper) splitAndShard(spanLog *spanlogger.SpanLogger, tenantIDs []string, r *http.Request, query *client.QueryRequest) []*client.QueryRequest {
+	ranges := splitTimeRangeByInterval(query.StartTimestampMs, query.EndTimestampMs, rr.splitInterval)
+
+	shardCount := 1
+	if rr.shardingEnabled && !isRemoteReadQuerySharded(query) {
+		shardCount = getShardCountForPartialQueries(spanLog, tenantIDs, r, rr.limits, len(ranges))
+	}
+
+	partials := make([]*client.QueryRequest, 0, len(ranges)*max(shardCount, 1))
+	for _, tr := range ranges {
+		if shardCount < 2 {
+			partials = append(partials, &client.QueryRequest{
+				StartTimestampMs:         tr.start,
+				EndTimestampMs:           tr.end,
+				Matchers:                 query.Matchers,
+				StreamingChunksBatchSize: query.StreamingChunksBatchSize,
+			})
+			continue
+		}
+
+		for shardIndex := 0; shardIndex < shardCount; shardIndex++ {
+			shard := sharding.ShardSelector{ShardIndex: uint64(shardIndex), ShardCount: uint64(shardCount)}
+
+			matchers := make([]*client.LabelMatcher, 0, len(query.Matchers)+1)
+			matchers = append(matchers, query.Matchers...)
+			matchers = append(matchers, &client.LabelMatcher{Type: client.EQUAL, Name: sharding.ShardLabel, Value: shard.LabelValue()})
+
+			partials = append(partials, &client.QueryRequest{
+				StartTimestampMs:         tr.start,
+				EndTimestampMs:           tr.end,
+				Matchers:                 matchers,
+				StreamingChunksBatchSize: query.StreamingChunksBatchSize,
+			})
+		}
+	}
+
+	return partials
+}
+
+// isRemoteReadQuerySharded returns true if query selects a single shard, in which case it can't be sharded again.
+func isRemoteReadQuerySharded(query *client.QueryRequest) bool {
+	for _, m := range query.Matchers {
+		if m.Name == sharding.ShardLabel {
+			return true
+		}
+	}
+
+	return false
+}
+
+// doPartialQueries executes the partial queries and returns the series returned by each of them. If a partial query
+// fails with a non-200 status code, the response of that partial query is returned instead.
+func (rr *remoteReadRoundTripper) doPartialQueries(ctx context.Context, tenantIDs []string, r *http.Request, respType client.ReadRequest_ResponseType, partials []remoteReadPartialQuery) ([][]mimirpb.TimeSeries, *http.Response, error) {
+	reqs := make([]*http.Request, 0, len(partials))
+	for _, partial := range partials {
+		req, err := buildRemoteReadPartialRequest(ctx, r, partial.query, respType)
+		if err != nil {
+			return nil, nil, apierror.New(apierror.TypeInternal, err.Error())
+		}
+		reqs = append(reqs, req)
+	}
+
+	stats.FromContext(ctx).AddSplitQueries(uint32(len(reqs)))
+
+	parallelism := validation.SmallestPositiveIntPerTenant(tenantIDs, rr.limits.MaxQueryParallelism)
+	bodies, failedResp, err := doPartialRequests(ctx, "remoteReadRoundTripper.doPartialQuery", reqs, rr.next, parallelism)
+	if err != nil || failedResp != nil {
+		return nil, failedResp, err
+	}
+
+	results := make([][]mimirpb.TimeSeries, len(partials))
+	for i, partial := range partials {
+		var partialSeries []mimirpb.TimeSeries
+		if respType == client.STREAMED_XOR_CHUNKS {
+			partialSeries, err = decodeRemoteReadStreamedResponse(bytes.NewReader(bodies[i]))
+		} else {
+			partialSeries, err = decodeRemoteReadSamplesResponse(bytes.NewReader(bodies[i]))
+		}
+		if err != nil {
+			return nil, nil, apierror.New(apierror.TypeInternal, fmt.Sprintf("failed to decode remote read response: %s", err))
+		}
+
+		// Chunks may contain samples outside the time range of the partial query, which would be duplicated by the
+		// partial queries of adjacent time ranges.
+		results[i] = trimRemoteReadSeries(partialSeries, partial.query.StartTimestampMs, partial.query.EndTimestampMs)
+	}
+
+	return results, nil, nil
+}
+
+// buildRemoteReadPartialRequest builds the remote read request running query, based on the original request r.
+func buildRemoteReadPartialRequest(ctx context.Context, r *http.Request, query *client.QueryRequest, respType client.ReadRequest_ResponseType) (*http.Request, error) {
+	data, err := proto.Marshal(&client.ReadRequest{
+		Queries:               []*client.QueryRequest{query},
+		AcceptedResponseTypes: []client.ReadRequest_ResponseType{respType},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL.Path, bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = r.Header.Clone()
+	// This is the field read by httpgrpc.FromHTTPRequest, so we need to populate it
+	// here to ensure the request makes it to the querier.
+	req.RequestURI = req.URL.String()
+
+	if err := user.InjectOrgIDIntoHTTPRequest(ctx, req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// negotiateRemoteReadResponseType returns the first of the accepted response types that is supported by queriers.
+func negotiateRemoteReadResponseType(accepted []client.ReadRequest_ResponseType) (client.ReadRequest_ResponseType, bool) {
+	if len(accepted) == 0 {
+		return client.SAMPLES, true
+	}
+
+	for _, respType := range accepted {
+		if respType == client.SAMPLES || respType == client.STREAMED_XOR_CHUNKS {
+			return respType, true
+		}
+	}
+
+	return 0, false
+}
+
+func decodeRemoteReadSamplesResponse(body io.Reader) ([]mimirpb.TimeSeries, error) {
+	compressed, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp client.ReadResponse
+	if err := proto.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Results) != 1 {
+		return nil, fmt.Errorf("expected 1 query result, got %d", len(resp.Results))
+	}
+
+	return resp.Results[0].Timeseries, nil
+}
+
+func decodeRemoteReadStreamedResponse(body io.Reader) ([]mimirpb.TimeSeries, error) {
+	var (
+		result []mimirpb.TimeSeries
+		it     chunkenc.Iterator
+	)
+
+	reader := prom_remote.NewChunkedReader(body, prom_remote.DefaultChunkedReadLimit, nil)
+
+	for {
+		var resp client.StreamReadResponse
+		if err := reader.NextProto(&resp); errors.Is(err, io.EOF) {
+			return result, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, s := range resp.ChunkedSeries {
+			// The frame buffer is reused by the reader, so labels must be copied and chunks decoded before reading the
+			// next frame.
+			ts := mimirpb.TimeSeries{Labels: make([]mimirpb.LabelAdapter, 0, len(s.Labels))}
+			for _, l := range s.Labels {
+				ts.Labels = append(ts.Labels, mimirpb.LabelAdapter{Name: strings.Clone(l.Name), Value: strings.Clone(l.Value)})
+			}
+
+			for _, chk := range s.Chunks {
+				c, err := chunkenc.FromData(chunkenc.Encoding(chk.Type), chk.Data)
+				if err != nil {
+					return nil, err
+				}
+
+				it = c.Iterator(it)
+				for valType := it.Next(); valType != chunkenc.ValNone; valType = it.Next() {
+					switch valType {
+					case chunkenc.ValFloat:
+						t, v := it.At()
+						ts.Samples = append(ts.Samples, mimirpb.Sample{TimestampMs: t, Value: v})
+					case chunkenc.ValHistogram:
+						t, h := it.AtHistogram(nil)
+						ts.Histograms = append(ts.Histograms, mimirpb.FromHistogramToHistogramProto(t, h))
+					case chunkenc.ValFloatHistogram:
+						t, h := it.AtFloatHistogram(nil)
+						ts.Histograms = append(ts.Histograms, mimirpb.FromFloatHistogramToHistogramProto(t, h))
+					default:
+						return nil, fmt.Errorf("unsupported value type: %v", valType)
+					}
+				}
+				if err := it.Err(); err != nil {
+					return nil, err
+				}
+			}
+
+			result = append(result, ts)
+		}
+	}
+}
+
+// trimRemoteReadSeries removes the samples outside [start, end] from series, and the series left without samples.
+func trimRemoteReadSeries(series []mimirpb.TimeSeries, start, end int64) []mimirpb.TimeSeries {
+	trimmed := series[:0]
+
+	for _, s := range series {
+		samples := s.Samples[:0]
+		for _, sample := range s.Samples {
+			if sample.TimestampMs >= start && sample.TimestampMs <= end {
+				samples = append(samples, sample)
+			}
+		}
+
+		histograms := s.Histograms[:0]
+		for _, h := range s.Histograms {
+			if h.Timestamp >= start && h.Timestamp <= end {
+				histograms = append(histograms, h)
+			}
+		}
+
+		if len(samples) == 0 && len(histograms) == 0 {
+			continue
+		}
+
+		s.Samples = samples
+		s.Histograms = histograms
+		trimmed = append(trimmed, s)
+	}
+
+	return trimmed
+}
+
+// mergeRemoteReadSeries merges the samples of series with the same labels, and returns the merged series sorted by
+// labels. Samples are expected to be in time order across series with the same labels.
+func mergeRemoteReadSeries(series []mimirpb.TimeSeries) []mimirpb.TimeSeries {
+	var (
+		merged   = make([]mimirpb.TimeSeries, 0, len(series))
+		byLabels = make(map[string]int, len(series))
+	)
+
+	for _, s := range series {
+		key := mimirpb.FromLabelAdaptersToString(s.Labels)
+
+		idx, ok := byLabels[key]
+		if !ok {
+			byLabels[key] = len(merged)
+			merged = append(merged, s)
+			continue
+		}
+
+		merged[idx].Samples = append(merged[idx].Samples, s.Samples...)
+		merged[idx].Histograms = append(merged[idx].Histograms, s.Histograms...)
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		return mimirpb.CompareLabelAdapters(merged[i].Labels, merged[j].Labels) < 0
+	})
+
+	return merged
+}
+
+func encodeRemoteReadSamplesResponse(r *http.Request, results [][]mimirpb.TimeSeries) (*http.Response, error) {
+	resp := client.ReadResponse{Results: make([]*client.QueryResponse, len(results))}
+	for i, result := range results {
+		resp.Results[i] = &client.QueryResponse{Timeseries: result}
+	}
+
+	data, err := proto.Marshal(&resp)
+	if err != nil {
+		return nil, apierror.New(apierror.TypeInternal, err.Error())
+	}
+
+	return newRemoteReadResponse(r, "application/x-protobuf", "snappy", snappy.Encode(nil, data)), nil
+}
+
+func encodeRemoteReadStreamedResponse(r *http.Request, results [][]mimirpb.TimeSeries) (*http.Response, error) {
+	buf := &bytes.Buffer{}
+	writer := prom_remote.NewChunkedWriter(buf, nopFlusher{})
+
+	for queryIndex, result := range results {
+		if err := writeRemoteReadStreamedSeries(writer, result, queryIndex); err != nil {
+			return nil, apierror.New(apierror.TypeInternal, err.Error())
+		}
+	}
+
+	return newRemoteReadResponse(r, api.ContentTypeRemoteReadStreamedChunks, "", buf.Bytes()), nil
+}
+
+// writeRemoteReadStreamedSeries encodes the samples of each series in chunks, and writes them to w in frames of at
+// most maxRemoteReadFrameBytes, the same way queriers do.
+func writeRemoteReadStreamedSeries(w io.Writer, result []mimirpb.TimeSeries, queryIndex int) error {
+	sorted := make([]storage.Series, 0, len(result))
+	for _, s := range result {
+		samples := make([]model.SamplePair, 0, len(s.Samples))
+		for _, sample := range s.Samples {
+			samples = append(samples, model.SamplePair{Timestamp: model.Time(sample.TimestampMs), Value: model.SampleValue(sample.Value)})
+		}
+		sorted = append(sorted, series.NewConcreteSeries(mimirpb.FromLabelAdaptersToLabels(s.Labels), samples, s.Histograms))
+	}
+
+	var (
+		chks []client.StreamChunk
+		iter chunks.Iterator
+	)
+
+	ss := storage.NewSeriesSetToChunkSet(series.NewConcreteSeriesSetFromSortedSeries(sorted))
+	for ss.Next() {
+		s := ss.At()
+		lbls := mimirpb.FromLabelsToLabelAdapters(s.Labels())
+		frameBytesRemaining := initializedRemoteReadFrameBytesRemaining(lbls)
+
+		iter = s.Iterator(iter)
+		for isNext := iter.Next(); isNext; {
+			chk := iter.At()
+			chks = append(chks, client.StreamChunk{
+				MinTimeMs: chk.MinTime,
+				MaxTimeMs: chk.MaxTime,
+				Type:      client.StreamChunk_Encoding(chk.Chunk.Encoding()),
+				Data:      chk.Chunk.Bytes(),
+			})
+			frameBytesRemaining -= chks[len(chks)-1].Size()
+
+			isNext = iter.Next()
+			if frameBytesRemaining > 0 && isNext {
+				continue
+			}
+
+			b, err := proto.Marshal(&client.StreamReadResponse{
+				ChunkedSeries: []*client.StreamChunkedSeries{{Labels: lbls, Chunks: chks}},
+				QueryIndex:    int64(queryIndex),
+			})
+			if err != nil {
+				return errors.Wrap(err, "marshal client.StreamReadResponse")
+			}
+
+			if _, err := w.Write(b); err != nil {
+				return errors.Wrap(err, "write to stream")
+			}
+
+			chks = chks[:0]
+			frameBytesRemaining = initializedRemoteReadFrameBytesRemaining(lbls)
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+	}
+
+	return ss.Err()
+}
+
+func initializedRemoteReadFrameBytesRemaining(lbls []mimirpb.LabelAdapter) int {
+	frameBytesLeft := maxRemoteReadFrameBytes
+	for _, lbl := range lbls {
+		frameBytesLeft -= lbl.Size()
+	}
+	return frameBytesLeft
+}
+
+func newRemoteReadResponse(r *http.Request, contentType, contentEncoding string, body []byte) *http.Response {
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": []string{contentType}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}
+
+	if contentEncoding != "" {
+		resp.Header.Set("Content-Encoding", contentEncoding)
+	}
+
+	return resp
+}
+
+// nopFlusher is an http.Flusher for responses that are fully buffered before being sent.
+type nopFlusher struct{}
+
+func (nopFlusher) Flush() {}
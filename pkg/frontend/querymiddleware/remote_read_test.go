@@ -0,0 +1,311 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package querymiddleware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	apierror "github.com/grafana/mimir/pkg/api/error"
+	"github.com/grafana/mimir/pkg/ingester/client"
+	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/grafana/mimir/pkg/querier"
+	"github.com/grafana/mimir/pkg/storage/sharding"
+)
+
+func TestRemoteReadRoundTripper(t *testing.T) {
+	start := time.Now().Truncate(time.Hour).Add(-6 * time.Hour)
+	end := start.Add(3*time.Hour - time.Millisecond)
+
+	var storageSeries []*promql.StorageSeries
+	for i := 0; i < 10; i++ {
+		storageSeries = append(storageSeries, newSeries(labels.FromStrings("__name__", "metric", "series", fmt.Sprint(i)), start.Add(-time.Hour), end.Add(time.Hour), 30*time.Second, factor(float64(i))))
+	}
+	storageSeries = append(storageSeries, newNativeHistogramSeries(labels.FromStrings("__name__", "metric", "series", "histogram"), start.Add(-time.Hour), end.Add(time.Hour), 30*time.Second, factor(3)))
+	storageSeries = append(storageSeries, newSeries(labels.FromStrings("__name__", "other"), start, end, 30*time.Second, factor(1)))
+
+	// The queryable returns all samples regardless of the queried time range, so the round tripper must trim them.
+	queryable := querier.NewSampleAndChunkQueryable(storageSeriesQueryable(storageSeries))
+	handler := querier.RemoteReadHandler(queryable, log.NewNopLogger())
+
+	matchers := []*client.LabelMatcher{{Type: client.EQUAL, Name: "__name__", Value: "metric"}}
+
+	testCases := map[string]struct {
+		splitInterval   time.Duration
+		shardingEnabled bool
+		limits          mockLimits
+		query           *client.QueryRequest
+
+		expectedRequests int
+		expectedShards   int
+		expectedErr      error
+		expectedEmpty    bool
+	}{
+		"no splitting and no sharding": {
+			limits:           mockLimits{},
+			query:            &client.QueryRequest{StartTimestampMs: start.UnixMilli(), EndTimestampMs: end.UnixMilli(), Matchers: matchers},
+			expectedRequests: 1,
+		},
+		"split by interval": {
+			splitInterval:    time.Hour,
+			limits:           mockLimits{},
+			query:            &client.QueryRequest{StartTimestampMs: start.UnixMilli(), EndTimestampMs: end.UnixMilli(), Matchers: matchers},
+			expectedRequests: 3,
+		},
+		"split by interval with unaligned time range": {
+			splitInterval:    time.Hour,
+			limits:           mockLimits{},
+			query:            &client.QueryRequest{StartTimestampMs: start.Add(30 * time.Minute).UnixMilli(), EndTimestampMs: end.Add(30 * time.Minute).UnixMilli(), Matchers: matchers},
+			expectedRequests: 4,
+		},
+		"sharded": {
+			shardingEnabled:  true,
+			limits:           mockLimits{totalShards: 4},
+			query:            &client.QueryRequest{StartTimestampMs: start.UnixMilli(), EndTimestampMs: end.UnixMilli(), Matchers: matchers},
+			expectedRequests: 4,
+			expectedShards:   4,
+		},
+		"split by interval and sharded": {
+			splitInterval:    time.Hour,
+			shardingEnabled:  true,
+			limits:           mockLimits{totalShards: 4, maxQueryParallelism: 2},
+			query:            &client.QueryRequest{StartTimestampMs: start.UnixMilli(), EndTimestampMs: end.UnixMilli(), Matchers: matchers},
+			expectedRequests: 12,
+			expectedShards:   4,
+		},
+		"number of shards limited by max sharded queries": {
+			splitInterval:    time.Hour,
+			shardingEnabled:  true,
+			limits:           mockLimits{totalShards: 4, maxShardedQueries: 6},
+			query:            &client.QueryRequest{StartTimestampMs: start.UnixMilli(), EndTimestampMs: end.UnixMilli(), Matchers: matchers},
+			expectedRequests: 6,
+			expectedShards:   2,
+		},
+		"sharding disabled": {
+			splitInterval:    time.Hour,
+			limits:           mockLimits{totalShards: 4},
+			query:            &client.QueryRequest{StartTimestampMs: start.UnixMilli(), EndTimestampMs: end.UnixMilli(), Matchers: matchers},
+			expectedRequests: 3,
+		},
+		"already sharded query": {
+			shardingEnabled: true,
+			limits:          mockLimits{totalShards: 4},
+			query: &client.QueryRequest{StartTimestampMs: start.UnixMilli(), EndTimestampMs: end.UnixMilli(), Matchers: append([]*client.LabelMatcher{
+				{Type: client.EQUAL, Name: sharding.ShardLabel, Value: sharding.ShardSelector{ShardIndex: 1, ShardCount: 2}.LabelValue()},
+			}, matchers...)},
+			expectedRequests: 1,
+			expectedShards:   2,
+		},
+		"start time clamped by max query lookback": {
+			splitInterval:    time.Hour,
+			limits:           mockLimits{maxQueryLookback: time.Since(start.Add(90 * time.Minute)), compactorBlocksRetentionPeriod: 30 * day},
+			query:            &client.QueryRequest{StartTimestampMs: start.UnixMilli(), EndTimestampMs: end.UnixMilli(), Matchers: matchers},
+			expectedRequests: 2,
+		},
+		"time range before max query lookback": {
+			splitInterval:    time.Hour,
+			limits:           mockLimits{maxQueryLookback: time.Since(end.Add(time.Hour)), compactorBlocksRetentionPeriod: 30 * day},
+			query:            &client.QueryRequest{StartTimestampMs: start.UnixMilli(), EndTimestampMs: end.UnixMilli(), Matchers: matchers},
+			expectedRequests: 0,
+			expectedEmpty:    true,
+		},
+		"time range longer than max total query length": {
+			splitInterval: time.Hour,
+			limits:        mockLimits{maxTotalQueryLength: time.Hour},
+			query:         &client.QueryRequest{StartTimestampMs: start.UnixMilli(), EndTimestampMs: end.UnixMilli(), Matchers: matchers},
+			expectedErr:   newMaxTotalQueryLengthError(3*time.Hour-time.Millisecond, time.Hour),
+		},
+	}
+
+	for name, testCase := range testCases {
+		for _, respType := range []client.ReadRequest_ResponseType{client.SAMPLES, client.STREAMED_XOR_CHUNKS} {
+			t.Run(fmt.Sprintf("%s, response type: %s", name, respType), func(t *testing.T) {
+				var (
+					downstreamMx      sync.Mutex
+					downstreamQueries []*client.QueryRequest
+				)
+
+				downstream := RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+					query := decodeRemoteReadRequest(t, r).Queries[0]
+
+					downstreamMx.Lock()
+					downstreamQueries = append(downstreamQueries, query)
+					downstreamMx.Unlock()
+
+					recorder := httptest.NewRecorder()
+					handler.ServeHTTP(recorder, r)
+					return recorder.Result(), nil
+				})
+
+				rr := newRemoteReadRoundTripper(downstream, testCase.splitInterval, testCase.shardingEnabled, testCase.limits, log.NewNopLogger())
+				resp, err := rr.RoundTrip(newRemoteReadRequest(t, testCase.query, respType))
+				if testCase.expectedErr != nil {
+					require.Equal(t, testCase.expectedErr, err)
+					return
+				}
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+				require.Len(t, downstreamQueries, testCase.expectedRequests)
+
+				for _, query := range downstreamQueries {
+					shard, err := shardFromRemoteReadQuery(query)
+					require.NoError(t, err)
+					if testCase.expectedShards == 0 {
+						assert.Nil(t, shard)
+					} else {
+						require.NotNil(t, shard)
+						assert.Equal(t, uint64(testCase.expectedShards), shard.ShardCount)
+					}
+				}
+
+				actual := decodeRemoteReadResponse(t, resp, respType)
+				if testCase.expectedEmpty {
+					require.Empty(t, actual)
+					return
+				}
+
+				// The result must be the same as the result of the query executed by a single querier, without the
+				// samples outside the queried time range.
+				expectedQuery := *testCase.query
+				if len(downstreamQueries) > 0 {
+					expectedQuery.StartTimestampMs = downstreamQueries[0].StartTimestampMs
+					for _, query := range downstreamQueries {
+						expectedQuery.StartTimestampMs = min(expectedQuery.StartTimestampMs, query.StartTimestampMs)
+					}
+				}
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, newRemoteReadRequest(t, &expectedQuery, respType))
+				expected := trimRemoteReadSeries(decodeRemoteReadResponse(t, recorder.Result(), respType), expectedQuery.StartTimestampMs, expectedQuery.EndTimestampMs)
+
+				if respType == client.STREAMED_XOR_CHUNKS {
+					// The counter reset hint of the first histogram of each chunk depends on where chunks are cut.
+					clearHistogramResetHints(expected)
+					clearHistogramResetHints(actual)
+				}
+
+				require.NotEmpty(t, actual)
+				require.Equal(t, expected, actual)
+			})
+		}
+	}
+}
+
+func TestRemoteReadRoundTripper_DownstreamError(t *testing.T) {
+	start := time.Now().Truncate(time.Hour).Add(-6 * time.Hour)
+	query := &client.QueryRequest{
+		StartTimestampMs: start.UnixMilli(),
+		EndTimestampMs:   start.Add(3 * time.Hour).UnixMilli(),
+		Matchers:         []*client.LabelMatcher{{Type: client.EQUAL, Name: "__name__", Value: "metric"}},
+	}
+
+	downstream := RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+		query := decodeRemoteReadRequest(t, r).Queries[0]
+		if query.StartTimestampMs > start.UnixMilli() {
+			return &http.Response{StatusCode: http.StatusBadRequest, Body: io.NopCloser(bytes.NewBufferString("limit exceeded"))}, nil
+		}
+
+		recorder := httptest.NewRecorder()
+		querier.RemoteReadHandler(querier.NewSampleAndChunkQueryable(storageSeriesQueryable(nil)), log.NewNopLogger()).ServeHTTP(recorder, r)
+		return recorder.Result(), nil
+	})
+
+	rr := newRemoteReadRoundTripper(downstream, time.Hour, false, mockLimits{}, log.NewNopLogger())
+	resp, err := rr.RoundTrip(newRemoteReadRequest(t, query, client.SAMPLES))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "limit exceeded", string(body))
+}
+
+func TestRemoteReadRoundTripper_InvalidRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/read", bytes.NewBufferString("invalid"))
+	r = r.WithContext(user.InjectOrgID(r.Context(), "test"))
+
+	rr := newRemoteReadRoundTripper(nil, time.Hour, true, mockLimits{}, log.NewNopLogger())
+	_, err := rr.RoundTrip(r)
+	require.Error(t, err)
+	require.True(t, apierror.IsAPIError(err))
+
+	resp, ok := apierror.HTTPResponseFromError(err)
+	require.True(t, ok)
+	require.Equal(t, int32(http.StatusBadRequest), resp.Code)
+}
+
+func newRemoteReadRequest(t *testing.T, query *client.QueryRequest, respType client.ReadRequest_ResponseType) *http.Request {
+	data, err := proto.Marshal(&client.ReadRequest{
+		Queries:               []*client.QueryRequest{query},
+		AcceptedResponseTypes: []client.ReadRequest_ResponseType{respType},
+	})
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/read", bytes.NewReader(snappy.Encode(nil, data)))
+	r.Header.Set("Content-Encoding", "snappy")
+	r.Header.Set("X-Scope-OrgID", "test")
+	return r.WithContext(user.InjectOrgID(context.Background(), "test"))
+}
+
+func decodeRemoteReadRequest(t *testing.T, r *http.Request) *client.ReadRequest {
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	data, err := snappy.Decode(nil, body)
+	require.NoError(t, err)
+
+	var req client.ReadRequest
+	require.NoError(t, proto.Unmarshal(data, &req))
+	require.Len(t, req.Queries, 1)
+	return &req
+}
+
+func decodeRemoteReadResponse(t *testing.T, resp *http.Response, respType client.ReadRequest_ResponseType) []mimirpb.TimeSeries {
+	var (
+		series []mimirpb.TimeSeries
+		err    error
+	)
+
+	if respType == client.STREAMED_XOR_CHUNKS {
+		series, err = decodeRemoteReadStreamedResponse(resp.Body)
+	} else {
+		series, err = decodeRemoteReadSamplesResponse(resp.Body)
+	}
+	require.NoError(t, err)
+
+	return series
+}
+
+func clearHistogramResetHints(series []mimirpb.TimeSeries) {
+	for _, s := range series {
+		for i := range s.Histograms {
+			s.Histograms[i].ResetHint = mimirpb.Histogram_UNKNOWN
+		}
+	}
+}
+
+func shardFromRemoteReadQuery(query *client.QueryRequest) (*sharding.ShardSelector, error) {
+	_, _, matchers, err := client.FromQueryRequest(query)
+	if err != nil {
+		return nil, err
+	}
+
+	shard, _, err := sharding.ShardFromMatchers(matchers)
+	return shard, err
+}
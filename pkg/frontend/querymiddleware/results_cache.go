@@ -187,6 +187,7 @@ var ErrUnsupportedRequest = errors.New("request is not cacheable")
 // consumers who wish to implement their own strategies.
 type CacheKeyGenerator interface {
 	// QueryRequest should generate a cache key based on the tenant ID and MetricsQueryRequest.
+	// The MetricsQueryRequest can be an instant query, whose step is 0.
 	QueryRequest(ctx context.Context, tenantID string, r MetricsQueryRequest) string
 
 	// LabelValues should return a cache key for a label values request. The cache key does not need to contain the tenant ID.
@@ -200,6 +201,12 @@ type CacheKeyGenerator interface {
 	// LabelValuesCardinality should return a nil *GenericQueryCacheKey when it returns an error and
 	// should always return non-nil *GenericQueryCacheKey when the returned error is nil.
 	LabelValuesCardinality(r *http.Request) (*GenericQueryCacheKey, error)
+
+	// Series should return a cache key for a series request. The cache key does not need to contain the tenant ID.
+	// Series can return ErrUnsupportedRequest, in which case the response won't be treated as an error, but the item will still not be cached.
+	// Series should return a nil *GenericQueryCacheKey when it returns an error and
+	// should always return non-nil *GenericQueryCacheKey when the returned error is nil.
+	Series(r *http.Request) (*GenericQueryCacheKey, error)
 }
 
 type DefaultCacheKeyGenerator struct {
@@ -217,6 +224,11 @@ func NewDefaultCacheKeyGenerator(codec Codec, interval time.Duration) DefaultCac
 
 // QueryRequest generates a cache key based on the userID, MetricsQueryRequest and interval.
 func (g DefaultCacheKeyGenerator) QueryRequest(_ context.Context, userID string, r MetricsQueryRequest) string {
+	// Instant queries have no step, and their results can only be reused for the same evaluation time.
+	if r.GetStep() == 0 {
+		return fmt.Sprintf("%s:%s@%d", userID, r.GetQuery(), r.GetStart())
+	}
+
 	startInterval := r.GetStart() / g.interval.Milliseconds()
 	stepOffset := r.GetStart() % r.GetStep()
 
@@ -24,6 +24,7 @@ import (
 	"github.com/prometheus/prometheus/promql/parser"
 	"golang.org/x/exp/slices"
 
+	"github.com/grafana/mimir/pkg/querier/api"
 	"github.com/grafana/mimir/pkg/util"
 )
 
@@ -35,6 +36,9 @@ const (
 	cardinalityLabelValuesPathSuffix  = "/api/v1/cardinality/label_values"
 	cardinalityActiveSeriesPathSuffix = "/api/v1/cardinality/active_series"
 	labelNamesPathSuffix              = "/api/v1/labels"
+	remoteReadPathSuffix              = "/api/v1/read"
+	seriesPathSuffix                  = "/api/v1/series"
+	exemplarsPathSuffix               = "/api/v1/query_exemplars"
 
 	// DefaultDeprecatedAlignQueriesWithStep is the default value for the deprecated querier frontend config DeprecatedAlignQueriesWithStep
 	// which has been moved to a per-tenant limit; TODO remove in Mimir 2.14
@@ -45,11 +49,17 @@ const (
 	queryTypeCardinality  = "cardinality"
 	queryTypeLabels       = "label_names_and_values"
 	queryTypeActiveSeries = "active_series"
+	queryTypeRemoteRead   = "remote_read"
+	queryTypeSeries       = "series"
+	queryTypeExemplars    = "exemplars"
 	queryTypeOther        = "other"
 )
 
 var (
 	labelValuesPathSuffix = regexp.MustCompile(`\/api\/v1\/label\/([^\/]+)\/values$`)
+
+	errInvalidConsistencyCheckSampleRate = errors.New("-query-frontend.consistency-check-sample-rate must be between 0 and 1")
+	errInvalidConsistencyCheckTimeout    = errors.New("-query-frontend.consistency-check-timeout must be greater than 0 when the consistency check is enabled")
 )
 
 // Config for query_range middleware chain.
@@ -58,12 +68,24 @@ type Config struct {
 	DeprecatedAlignQueriesWithStep bool          `yaml:"align_queries_with_step" doc:"hidden"` // Deprecated: Deprecated in Mimir 2.12, remove in Mimir 2.14 (https://github.com/grafana/mimir/issues/6712)
 	ResultsCacheConfig             `yaml:"results_cache"`
 	CacheResults                   bool          `yaml:"cache_results"`
+	CacheInstantQueries            bool          `yaml:"cache_instant_queries" category:"experimental"`
 	MaxRetries                     int           `yaml:"max_retries" category:"advanced"`
 	NotRunningTimeout              time.Duration `yaml:"not_running_timeout" category:"advanced"`
 	ShardedQueries                 bool          `yaml:"parallelize_shardable_queries"`
 	TargetSeriesPerShard           uint64        `yaml:"query_sharding_target_series_per_shard" category:"advanced"`
 	ShardActiveSeriesQueries       bool          `yaml:"shard_active_series_queries" category:"experimental"`
 	UseActiveSeriesDecoder         bool          `yaml:"use_active_series_decoder" category:"experimental"`
+	SplitAndShardRemoteReads       bool          `yaml:"split_and_shard_remote_read_queries" category:"experimental"`
+	SplitAndShardSeriesQueries     bool          `yaml:"split_and_shard_series_queries" category:"experimental"`
+	SplitExemplarQueries           bool          `yaml:"split_exemplar_queries" category:"experimental"`
+	ResponseStreamingEnabled       bool          `yaml:"response_streaming_enabled" category:"experimental"`
+	QueryPriorityHeaderEnabled     bool          `yaml:"query_priority_header_enabled" category:"experimental"`
+
+	ConsistencyCheckSampleRate        float64       `yaml:"consistency_check_sample_rate" category:"experimental"`
+	ConsistencyCheckValueTolerance    float64       `yaml:"consistency_check_value_tolerance" category:"experimental"`
+	ConsistencyCheckUseRelativeError  bool          `yaml:"consistency_check_use_relative_error" category:"experimental"`
+	ConsistencyCheckSkipRecentSamples time.Duration `yaml:"consistency_check_skip_recent_samples" category:"experimental"`
+	ConsistencyCheckTimeout           time.Duration `yaml:"consistency_check_timeout" category:"experimental"`
 
 	// CacheKeyGenerator allows to inject a CacheKeyGenerator to use for generating cache keys.
 	// If nil, the querymiddleware package uses a DefaultCacheKeyGenerator with SplitQueriesByInterval.
@@ -78,11 +100,22 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	f.DurationVar(&cfg.NotRunningTimeout, "query-frontend.not-running-timeout", 2*time.Second, "Maximum time to wait for the query-frontend to become ready before rejecting requests received before the frontend was ready. 0 to disable (i.e. fail immediately if a request is received while the frontend is still starting up)")
 	f.DurationVar(&cfg.SplitQueriesByInterval, "query-frontend.split-queries-by-interval", 24*time.Hour, "Split range queries by an interval and execute in parallel. You should use a multiple of 24 hours to optimize querying blocks. 0 to disable it.")
 	f.BoolVar(&cfg.CacheResults, "query-frontend.cache-results", false, "Cache query results.")
+	f.BoolVar(&cfg.CacheInstantQueries, "query-frontend.cache-instant-queries", false, "Cache instant query results. Requires -query-frontend.cache-results to be enabled. When instant queries are split by interval, the partial queries are aligned to the split interval, so that only the partial queries selecting recent data are executed and the results of the other ones are reused from the cache.")
 	f.BoolVar(&cfg.ShardedQueries, "query-frontend.parallelize-shardable-queries", false, "True to enable query sharding.")
 	f.Uint64Var(&cfg.TargetSeriesPerShard, "query-frontend.query-sharding-target-series-per-shard", 0, "How many series a single sharded partial query should load at most. This is not a strict requirement guaranteed to be honoured by query sharding, but a hint given to the query sharding when the query execution is initially planned. 0 to disable cardinality-based hints.")
 	f.StringVar(&cfg.QueryResultResponseFormat, "query-frontend.query-result-response-format", formatProtobuf, fmt.Sprintf("Format to use when retrieving query results from queriers. Supported values: %s", strings.Join(allFormats, ", ")))
 	f.BoolVar(&cfg.ShardActiveSeriesQueries, "query-frontend.shard-active-series-queries", false, "True to enable sharding of active series queries.")
 	f.BoolVar(&cfg.UseActiveSeriesDecoder, "query-frontend.use-active-series-decoder", false, "Set to true to use the zero-allocation response decoder for active series queries.")
+	f.BoolVar(&cfg.SplitAndShardRemoteReads, "query-frontend.split-and-shard-remote-read-queries", false, "True to split remote read queries by -query-frontend.split-queries-by-interval and, when query sharding is enabled, to shard them by series, executing the partial queries in parallel.")
+	f.BoolVar(&cfg.SplitAndShardSeriesQueries, "query-frontend.split-and-shard-series-queries", false, "True to split series queries by -query-frontend.split-queries-by-interval and, when query sharding is enabled, to shard them by series, executing the partial queries in parallel and deduplicating the series they return.")
+	f.BoolVar(&cfg.SplitExemplarQueries, "query-frontend.split-exemplar-queries", false, "True to split exemplar queries by -query-frontend.split-queries-by-interval, executing the partial queries in parallel.")
+	f.Float64Var(&cfg.ConsistencyCheckSampleRate, "query-frontend.consistency-check-sample-rate", 0, "Fraction of range and instant queries, between 0 and 1, which are also run without being split by time, sharded or cached, to compare their results with the results of the queries and report mismatches. The sampled queries are run a second time in the background once they have succeeded. 0 to disable.")
+	f.Float64Var(&cfg.ConsistencyCheckValueTolerance, "query-frontend.consistency-check-value-tolerance", 0.000001, "The tolerance to apply when comparing floating point values in the query results of the consistency check. 0 to disable tolerance and require exact match.")
+	f.BoolVar(&cfg.ConsistencyCheckUseRelativeError, "query-frontend.consistency-check-use-relative-error", false, "Use relative error tolerance when comparing floating point values in the query results of the consistency check.")
+	f.DurationVar(&cfg.ConsistencyCheckSkipRecentSamples, "query-frontend.consistency-check-skip-recent-samples", 2*time.Minute, "The window from now to skip comparing samples in the query results of the consistency check. 0 to disable.")
+	f.DurationVar(&cfg.ConsistencyCheckTimeout, "query-frontend.consistency-check-timeout", 2*time.Minute, "Timeout of the queries run without being split by time, sharded or cached by the consistency check.")
+	f.BoolVar(&cfg.ResponseStreamingEnabled, "query-frontend.response-streaming-enabled", false, "True to JSON-encode the result of instant and range queries series by series while sending it to the client, instead of encoding the whole response in memory before sending it. The query result is still merged in memory before being encoded, so series are only sent once all the partial queries the query has been split and sharded into have completed.")
+	f.BoolVar(&cfg.QueryPriorityHeaderEnabled, "query-frontend.query-priority-header-enabled", false, fmt.Sprintf("True to take the query priority from the %s request header sent by the client. Any client can set this header to claim a higher priority, so only enable it if the clients of the query-frontend are trusted, or if a proxy in front of it removes or overrides the header. When disabled, the header is removed and the query priority is only assigned by the tenant's query priority matchers.", api.QueryPriorityHeader))
 	cfg.ResultsCacheConfig.RegisterFlags(f)
 
 	// The query-frontend.align-queries-with-step flag has been moved to the limits.go file
@@ -100,6 +133,10 @@ func (cfg *Config) Validate() error {
 		}
 	}
 
+	if cfg.CacheInstantQueries && !cfg.CacheResults {
+		return errors.New("-query-frontend.cache-instant-queries may only be enabled in conjunction with -query-frontend.cache-results. Please set the latter")
+	}
+
 	if cfg.CacheResults || cfg.cardinalityBasedShardingEnabled() {
 		if err := cfg.ResultsCacheConfig.Validate(); err != nil {
 			return errors.Wrap(err, "invalid query-frontend results cache config")
@@ -110,6 +147,13 @@ func (cfg *Config) Validate() error {
 		return fmt.Errorf("unknown query result response format '%s'. Supported values: %s", cfg.QueryResultResponseFormat, strings.Join(allFormats, ", "))
 	}
 
+	if cfg.ConsistencyCheckSampleRate < 0 || cfg.ConsistencyCheckSampleRate > 1 {
+		return errInvalidConsistencyCheckSampleRate
+	}
+	if cfg.ConsistencyCheckSampleRate > 0 && cfg.ConsistencyCheckTimeout <= 0 {
+		return errInvalidConsistencyCheckTimeout
+	}
+
 	return nil
 }
 
@@ -217,12 +261,16 @@ func newQueryTripperware(
 	// Metric used to keep track of each middleware execution duration.
 	metrics := newInstrumentMiddlewareMetrics(registerer)
 	queryBlockerMiddleware := newQueryBlockerMiddleware(limits, log, registerer)
+	queryRewriteMiddleware := newQueryRewriteMiddleware(limits, log, registerer)
 	queryStatsMiddleware := newQueryStatsMiddleware(registerer, engine)
 
 	queryRangeMiddleware := []MetricsQueryMiddleware{
 		// Track query range statistics. Added first before any subsequent middleware modifies the request.
 		queryStatsMiddleware,
 		newLimitsMiddleware(limits, log),
+		// The queries are blocked after being rewritten, like instant queries, so that the blocked queries apply to
+		// the queries actually run.
+		queryRewriteMiddleware,
 		queryBlockerMiddleware,
 		newInstrumentMiddleware("step_align", metrics),
 		newStepAlignMiddleware(limits, log, registerer),
@@ -239,6 +287,18 @@ func newQueryTripperware(
 		c = cache.NewCompression(cfg.ResultsCacheConfig.Compression, c, log)
 	}
 
+	// The cost-based admission uses the series count estimates of the cardinality estimation middleware, if enabled.
+	var cardinalityEstimatesCache cache.Cache
+	if cfg.cardinalityBasedShardingEnabled() {
+		cardinalityEstimatesCache = c
+	}
+	queryCostMiddleware := newQueryCostMiddleware(limits, cardinalityEstimatesCache, cfg.SplitQueriesByInterval, log, registerer)
+	queryRangeMiddleware = append(queryRangeMiddleware, newInstrumentMiddleware("query_cost", metrics), queryCostMiddleware)
+
+	// The consistency check compares the results of the middlewares following the query cost middleware with
+	// the results of the query run bypassing them.
+	queryRangeConsistencyCheckIndex := len(queryRangeMiddleware)
+
 	cacheKeyGenerator := cfg.CacheKeyGenerator
 	if cacheKeyGenerator == nil {
 		cacheKeyGenerator = NewDefaultCacheKeyGenerator(codec, cfg.SplitQueriesByInterval)
@@ -269,9 +329,22 @@ func newQueryTripperware(
 		// Track query range statistics. Added first before any subsequent middleware modifies the request.
 		queryStatsMiddleware,
 		newLimitsMiddleware(limits, log),
-		newSplitInstantQueryByIntervalMiddleware(limits, log, engine, registerer),
+		queryRewriteMiddleware,
+		newInstrumentMiddleware("query_cost", metrics),
+		queryCostMiddleware,
+		newSplitInstantQueryByIntervalMiddleware(limits, log, engine, cfg.CacheInstantQueries, registerer),
 		queryBlockerMiddleware,
 	}
+	queryInstantConsistencyCheckIndex := len(queryInstantMiddleware) - 2
+
+	// Inject the results cache after time-based splitting, so that it can cache the results of the partial queries.
+	if cfg.CacheInstantQueries {
+		queryInstantMiddleware = append(
+			queryInstantMiddleware,
+			newInstrumentMiddleware("results_cache", metrics),
+			newInstantQueryCacheMiddleware(limits, c, cacheKeyGenerator, cacheExtractor, log, registerer),
+		)
+	}
 
 	if cfg.ShardedQueries {
 		// Inject the cardinality estimation middleware after time-based splitting and
@@ -311,15 +384,38 @@ func newQueryTripperware(
 		)
 	}
 
+	// The reference queries of the consistency check are retried like the queries they're compared with.
+	var consistencyCheckReferenceMiddleware []MetricsQueryMiddleware
 	if cfg.MaxRetries > 0 {
 		retryMiddlewareMetrics := newRetryMiddlewareMetrics(registerer)
-		queryRangeMiddleware = append(queryRangeMiddleware, newInstrumentMiddleware("retry", metrics), newRetryMiddleware(log, cfg.MaxRetries, retryMiddlewareMetrics))
-		queryInstantMiddleware = append(queryInstantMiddleware, newInstrumentMiddleware("retry", metrics), newRetryMiddleware(log, cfg.MaxRetries, retryMiddlewareMetrics))
+		retryMiddleware := newRetryMiddleware(log, cfg.MaxRetries, retryMiddlewareMetrics)
+		queryRangeMiddleware = append(queryRangeMiddleware, newInstrumentMiddleware("retry", metrics), retryMiddleware)
+		queryInstantMiddleware = append(queryInstantMiddleware, newInstrumentMiddleware("retry", metrics), retryMiddleware)
+		consistencyCheckReferenceMiddleware = append(consistencyCheckReferenceMiddleware, retryMiddleware)
+	}
+
+	if cfg.ConsistencyCheckSampleRate > 0 {
+		consistencyChecker := newConsistencyChecker(cfg, log, registerer)
+		queryRangeMiddleware = withConsistencyCheckMiddleware(queryRangeMiddleware, queryRangeConsistencyCheckIndex, consistencyChecker, consistencyCheckReferenceMiddleware, metrics)
+		queryInstantMiddleware = withConsistencyCheckMiddleware(queryInstantMiddleware, queryInstantConsistencyCheckIndex, consistencyChecker, consistencyCheckReferenceMiddleware, metrics)
 	}
 
 	return func(next http.RoundTripper) http.RoundTripper {
 		queryrange := newLimitedParallelismRoundTripper(next, codec, limits, queryRangeMiddleware...)
 		instant := newLimitedParallelismRoundTripper(next, codec, limits, queryInstantMiddleware...)
+		series := next
+		exemplars := next
+
+		// Series and exemplar queries are split before setting the query details, so that the partial queries
+		// don't overwrite the query details of the original query.
+		if cfg.SplitAndShardSeriesQueries {
+			series = newSplitAndShardSeriesRoundTripper(series, cfg.SplitQueriesByInterval, cfg.ShardedQueries, limits, log)
+		}
+		if cfg.SplitExemplarQueries {
+			exemplars = newSplitExemplarsRoundTripper(exemplars, cfg.SplitQueriesByInterval, limits, log)
+		}
+		series = newQueryDetailsStartEndRoundTripper(series)
+		exemplars = newQueryDetailsStartEndRoundTripper(exemplars)
 
 		// Wrap next for cardinality, labels queries and all other queries.
 		// That attempts to parse "start" and "end" from the HTTP request and set them in the request's QueryDetails.
@@ -328,18 +424,24 @@ func newQueryTripperware(
 		cardinality := next
 		activeSeries := next
 		labels := next
+		remoteRead := next
 
-		// Inject the cardinality and labels query cache roundtripper only if the query results cache is enabled.
+		// Inject the cardinality, labels and series query cache roundtripper only if the query results cache is enabled.
 		if cfg.CacheResults {
 			cardinality = newCardinalityQueryCacheRoundTripper(c, cacheKeyGenerator, limits, cardinality, log, registerer)
 			labels = newLabelsQueryCacheRoundTripper(c, cacheKeyGenerator, limits, labels, log, registerer)
+			series = newSeriesQueryCacheRoundTripper(c, cacheKeyGenerator, limits, series, log, registerer)
 		}
 
 		if cfg.ShardActiveSeriesQueries {
 			activeSeries = newShardActiveSeriesMiddleware(activeSeries, cfg.UseActiveSeriesDecoder, limits, log)
 		}
 
-		return RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if cfg.SplitAndShardRemoteReads {
+			remoteRead = newRemoteReadRoundTripper(remoteRead, cfg.SplitQueriesByInterval, cfg.ShardedQueries, limits, log)
+		}
+
+		return newQueryPriorityRoundTripper(RoundTripFunc(func(r *http.Request) (*http.Response, error) {
 			switch {
 			case IsRangeQuery(r.URL.Path):
 				return queryrange.RoundTrip(r)
@@ -351,13 +453,30 @@ func newQueryTripperware(
 				return activeSeries.RoundTrip(r)
 			case IsLabelsQuery(r.URL.Path):
 				return labels.RoundTrip(r)
+			case IsRemoteReadQuery(r.URL.Path):
+				return remoteRead.RoundTrip(r)
+			case IsSeriesQuery(r.URL.Path):
+				return series.RoundTrip(r)
+			case IsExemplarsQuery(r.URL.Path):
+				return exemplars.RoundTrip(r)
 			default:
 				return next.RoundTrip(r)
 			}
-		})
+		}), limits, cfg.QueryPriorityHeaderEnabled)
 	}, nil
 }
 
+// withConsistencyCheckMiddleware returns the given middlewares, with the ones starting at index wrapped by the
+// consistency check middleware. The reference queries run through referenceMiddlewares only.
+func withConsistencyCheckMiddleware(middlewares []MetricsQueryMiddleware, index int, checker *consistencyChecker, referenceMiddlewares []MetricsQueryMiddleware, metrics *instrumentMiddlewareMetrics) []MetricsQueryMiddleware {
+	checked := middlewares[index:]
+	return append(
+		middlewares[:index:index],
+		newInstrumentMiddleware("consistency_check", metrics),
+		newConsistencyCheckMiddleware(checker, referenceMiddlewares, checked...),
+	)
+}
+
 // newQueryDetailsStartEndRoundTripper parses "start" and "end" parameters from the query and sets same fields in the QueryDetails in the context.
 func newQueryDetailsStartEndRoundTripper(next http.RoundTripper) http.RoundTripper {
 	return RoundTripFunc(func(req *http.Request) (*http.Response, error) {
@@ -404,6 +523,12 @@ func newQueryCountTripperware(registerer prometheus.Registerer) Tripperware {
 				op = queryTypeActiveSeries
 			case IsLabelsQuery(r.URL.Path):
 				op = queryTypeLabels
+			case IsRemoteReadQuery(r.URL.Path):
+				op = queryTypeRemoteRead
+			case IsSeriesQuery(r.URL.Path):
+				op = queryTypeSeries
+			case IsExemplarsQuery(r.URL.Path):
+				op = queryTypeExemplars
 			}
 
 			tenantIDs, err := tenant.TenantIDs(r.Context())
@@ -448,3 +573,15 @@ func IsLabelsQuery(path string) bool {
 func IsActiveSeriesQuery(path string) bool {
 	return strings.HasSuffix(path, cardinalityActiveSeriesPathSuffix)
 }
+
+func IsRemoteReadQuery(path string) bool {
+	return strings.HasSuffix(path, remoteReadPathSuffix)
+}
+
+func IsSeriesQuery(path string) bool {
+	return strings.HasSuffix(path, seriesPathSuffix)
+}
+
+func IsExemplarsQuery(path string) bool {
+	return strings.HasSuffix(path, exemplarsPathSuffix)
+}
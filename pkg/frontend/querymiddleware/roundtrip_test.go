@@ -8,6 +8,7 @@ package querymiddleware
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -30,6 +31,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/grafana/mimir/pkg/mimirpb"
+	"github.com/grafana/mimir/pkg/util/validation"
 )
 
 func TestRangeTripperware(t *testing.T) {
@@ -423,6 +425,88 @@ func TestTripperware_Metrics(t *testing.T) {
 	}
 }
 
+func TestTripperware_BlockedQueriesApplyToRewrittenQueries(t *testing.T) {
+	limits := mockLimits{
+		queryRewriteRules: []*validation.QueryRewriteRule{
+			{Pattern: "up", Replacement: "count(up)"},
+			{Pattern: "sum(up)", Replacement: "sum(job:up)"},
+		},
+		blockedQueries: []*validation.BlockedQuery{
+			{Pattern: "count(up)"},
+			{Pattern: "sum(up)"},
+		},
+	}
+
+	s := httptest.NewServer(
+		middleware.AuthenticateUser.Wrap(
+			http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", jsonMimeType)
+				_, err := w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+				require.NoError(t, err)
+			}),
+		),
+	)
+	defer s.Close()
+
+	u, err := url.Parse(s.URL)
+	require.NoError(t, err)
+
+	downstream := singleHostRoundTripper{
+		host: u.Host,
+		next: http.DefaultTransport,
+	}
+
+	for name, tc := range map[string]struct {
+		query           string
+		expectedBlocked bool
+	}{
+		"query rewritten to a blocked query is blocked": {
+			query:           "up",
+			expectedBlocked: true,
+		},
+		"blocked query rewritten to a query which isn't blocked is run": {
+			query:           "sum(up)",
+			expectedBlocked: false,
+		},
+	} {
+		for _, path := range []string{"/api/v1/query_range?start=1536673680&end=1536716880&step=120&query=", "/api/v1/query?time=1536673680&query="} {
+			t.Run(fmt.Sprintf("%s, %s", name, path), func(t *testing.T) {
+				tw, err := NewTripperware(
+					Config{},
+					log.NewNopLogger(),
+					limits,
+					newTestPrometheusCodec(),
+					nil,
+					promql.EngineOpts{
+						Logger:     log.NewNopLogger(),
+						Reg:        nil,
+						MaxSamples: 1000,
+						Timeout:    time.Minute,
+					},
+					true,
+					prometheus.NewPedanticRegistry(),
+				)
+				require.NoError(t, err)
+
+				req, err := http.NewRequest("GET", path+url.QueryEscape(tc.query), http.NoBody)
+				require.NoError(t, err)
+
+				ctx := user.InjectOrgID(context.Background(), "user-1")
+				req = req.WithContext(ctx)
+				require.NoError(t, user.InjectOrgIDIntoHTTPRequest(ctx, req))
+
+				resp, err := tw(downstream).RoundTrip(req)
+				if tc.expectedBlocked {
+					require.ErrorContains(t, err, newQueryBlockedError().Error())
+					return
+				}
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+			})
+		}
+	}
+}
+
 func TestConfig_Validate(t *testing.T) {
 	tests := map[string]struct {
 		config        Config
@@ -436,12 +520,28 @@ func TestConfig_Validate(t *testing.T) {
 			config:        Config{QueryResultResponseFormat: "something-else"},
 			expectedError: errors.New("unknown query result response format 'something-else'. Supported values: json, protobuf"),
 		},
+		"instant queries results cache enabled without results cache": {
+			config:        Config{QueryResultResponseFormat: formatJSON, CacheInstantQueries: true},
+			expectedError: errors.New("-query-frontend.cache-instant-queries may only be enabled in conjunction with -query-frontend.cache-results. Please set the latter"),
+		},
+		"consistency check sample rate out of range": {
+			config:        Config{QueryResultResponseFormat: formatJSON, ConsistencyCheckSampleRate: 1.5},
+			expectedError: errInvalidConsistencyCheckSampleRate,
+		},
+		"consistency check without timeout": {
+			config:        Config{QueryResultResponseFormat: formatJSON, ConsistencyCheckSampleRate: 0.5},
+			expectedError: errInvalidConsistencyCheckTimeout,
+		},
 	}
 
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
 			err := test.config.Validate()
-			require.Equal(t, test.expectedError, err)
+			if test.expectedError == nil {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, test.expectedError.Error())
+			}
 		})
 	}
 }
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package querymiddleware
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/cache"
+	"github.com/prometheus/client_golang/prometheus"
+
+	apierror "github.com/grafana/mimir/pkg/api/error"
+	"github.com/grafana/mimir/pkg/util"
+)
+
+const (
+	seriesQueryCachePrefix = "sr:"
+)
+
+func newSeriesQueryCacheRoundTripper(cache cache.Cache, generator CacheKeyGenerator, limits Limits, next http.RoundTripper, logger log.Logger, reg prometheus.Registerer) http.RoundTripper {
+	ttl := &seriesQueryTTL{
+		limits: limits,
+	}
+
+	return newGenericQueryCacheRoundTripper(cache, generator.Series, ttl, next, logger, newResultsCacheMetrics(queryTypeSeries, reg))
+}
+
+type seriesQueryTTL struct {
+	limits Limits
+}
+
+func (c *seriesQueryTTL) ttl(userID string) time.Duration {
+	return c.limits.ResultsCacheTTLForSeriesQuery(userID)
+}
+
+func (DefaultCacheKeyGenerator) Series(r *http.Request) (*GenericQueryCacheKey, error) {
+	if !IsSeriesQuery(r.URL.Path) {
+		return nil, errors.New("unknown series API endpoint")
+	}
+
+	reqValues, err := util.ParseRequestFormWithoutConsumingBody(r)
+	if err != nil {
+		return nil, apierror.New(apierror.TypeBadData, err.Error())
+	}
+
+	start, end, err := DecodeLabelsQueryTimeParams(&reqValues, true)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, err := parseSeriesQueryLimit(reqValues)
+	if err != nil {
+		return nil, err
+	}
+
+	matcherSets, err := parseRequestMatchersParam(reqValues, "match[]")
+	if err != nil {
+		return nil, err
+	}
+
+	return &GenericQueryCacheKey{
+		CacheKey:       generateLabelsQueryRequestCacheKey(start, end, "", matcherSets, limit),
+		CacheKeyPrefix: seriesQueryCachePrefix,
+	}, nil
+}
@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package querymiddleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "github.com/prometheus/prometheus/web/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesQueryCache_RoundTrip(t *testing.T) {
+	testGenericQueryCacheRoundTrip(t, newSeriesQueryCacheRoundTripper, "series", map[string]testGenericQueryCacheRequestType{
+		"series request": {
+			reqPath:        "/prometheus/api/v1/series",
+			reqData:        url.Values{"start": []string{"2023-07-05T01:00:00Z"}, "end": []string{"2023-07-05T08:00:00Z"}, "match[]": []string{`{job="test_1"}`, `{job!="test_2"}`}},
+			cacheKey:       "user-1:1688515200000\x001688544000000\x00{job!=\"test_2\"},{job=\"test_1\"}",
+			hashedCacheKey: seriesQueryCachePrefix + cacheHashKey("user-1:1688515200000\x001688544000000\x00{job!=\"test_2\"},{job=\"test_1\"}"),
+		},
+	})
+}
+
+func TestDefaultCacheKeyGenerator_SeriesCacheKey(t *testing.T) {
+	tests := map[string]struct {
+		params           url.Values
+		expectedCacheKey string
+		expectedErr      string
+	}{
+		"no time range provided": {
+			params: url.Values{
+				"match[]": []string{`{job="test"}`},
+			},
+			expectedCacheKey: strings.Join([]string{
+				fmt.Sprintf("%d", v1.MinTime.UnixMilli()),
+				fmt.Sprintf("%d", v1.MaxTime.UnixMilli()),
+				`{job="test"}`,
+			}, string(stringParamSeparator)),
+		},
+		"time range and limit provided": {
+			params: url.Values{
+				"start":   []string{"2023-07-05T01:00:00Z"},
+				"end":     []string{"2023-07-05T07:00:00Z"},
+				"match[]": []string{`{job="test"}`},
+				"limit":   []string{"10"},
+			},
+			expectedCacheKey: strings.Join([]string{
+				fmt.Sprintf("%d", mustParseTime("2023-07-05T00:00:00Z")),
+				fmt.Sprintf("%d", mustParseTime("2023-07-05T08:00:00Z")),
+				`{job="test"}`,
+				"10",
+			}, string(stringParamSeparator)),
+		},
+		"multiple selectors are sorted": {
+			params: url.Values{
+				"match[]": []string{`{job="test_2"}`, `{job="test_1"}`},
+			},
+			expectedCacheKey: strings.Join([]string{
+				fmt.Sprintf("%d", v1.MinTime.UnixMilli()),
+				fmt.Sprintf("%d", v1.MaxTime.UnixMilli()),
+				`{job="test_1"},{job="test_2"}`,
+			}, string(stringParamSeparator)),
+		},
+		"invalid selector": {
+			params: url.Values{
+				"match[]": []string{`{job=`},
+			},
+			expectedErr: "invalid 'match[]' parameter",
+		},
+		"invalid limit": {
+			params: url.Values{
+				"match[]": []string{`{job="test"}`},
+				"limit":   []string{"-1"},
+			},
+			expectedErr: "limit parameter must be a non-negative number",
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			reg := prometheus.NewPedanticRegistry()
+			gen := DefaultCacheKeyGenerator{codec: NewPrometheusCodec(reg, formatJSON, false)}
+
+			req, err := http.NewRequest(http.MethodGet, "/api/v1/series?"+testData.params.Encode(), nil)
+			require.NoError(t, err)
+
+			actual, err := gen.Series(req)
+			if testData.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), testData.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, seriesQueryCachePrefix, actual.CacheKeyPrefix)
+			assert.Equal(t, testData.expectedCacheKey, actual.CacheKey)
+		})
+	}
+}
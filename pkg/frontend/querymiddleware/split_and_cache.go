@@ -431,9 +431,13 @@ func (s *splitAndCacheMiddleware) fetchCacheExtents(ctx context.Context, now tim
 }
 
 func (s *splitAndCacheMiddleware) getCacheOptions(tenantIDs []string) (ttl, ttlInOOO, oooWindow time.Duration) {
-	ttl = validation.SmallestPositiveNonZeroDurationPerTenant(tenantIDs, s.limits.ResultsCacheTTL)
-	ttlInOOO = validation.SmallestPositiveNonZeroDurationPerTenant(tenantIDs, s.limits.ResultsCacheTTLForOutOfOrderTimeWindow)
-	oooWindow = validation.MaxDurationPerTenant(tenantIDs, s.limits.OutOfOrderTimeWindow)
+	return getResultsCacheOptions(s.limits, tenantIDs)
+}
+
+func getResultsCacheOptions(limits Limits, tenantIDs []string) (ttl, ttlInOOO, oooWindow time.Duration) {
+	ttl = validation.SmallestPositiveNonZeroDurationPerTenant(tenantIDs, limits.ResultsCacheTTL)
+	ttlInOOO = validation.SmallestPositiveNonZeroDurationPerTenant(tenantIDs, limits.ResultsCacheTTLForOutOfOrderTimeWindow)
+	oooWindow = validation.MaxDurationPerTenant(tenantIDs, limits.OutOfOrderTimeWindow)
 	return
 }
 
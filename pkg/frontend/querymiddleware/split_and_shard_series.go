@@ -0,0 +1,302 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package querymiddleware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/tenant"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	v1 "github.com/prometheus/prometheus/web/api/v1"
+
+	apierror "github.com/grafana/mimir/pkg/api/error"
+	"github.com/grafana/mimir/pkg/querier/stats"
+	"github.com/grafana/mimir/pkg/storage/sharding"
+	"github.com/grafana/mimir/pkg/util"
+	"github.com/grafana/mimir/pkg/util/spanlogger"
+	"github.com/grafana/mimir/pkg/util/validation"
+)
+
+const seriesTruncatedWarning = "results truncated due to limit"
+
+type seriesResponse struct {
+	Status   string          `json:"status"`
+	Data     []labels.Labels `json:"data"`
+	Warnings []string        `json:"warnings,omitempty"`
+}
+
+// splitAndShardSeriesRoundTripper is a http.RoundTripper that splits series queries by time and shards them by
+// series, and merges the series returned by the partial queries.
+type splitAndShardSeriesRoundTripper struct {
+	next            http.RoundTripper
+	splitInterval   time.Duration
+	shardingEnabled bool
+	limits          Limits
+	logger          log.Logger
+}
+
+func newSplitAndShardSeriesRoundTripper(next http.RoundTripper, splitInterval time.Duration, shardingEnabled bool, limits Limits, logger log.Logger) http.RoundTripper {
+	return &splitAndShardSeriesRoundTripper{
+		next:            next,
+		splitInterval:   splitInterval,
+		shardingEnabled: shardingEnabled,
+		limits:          limits,
+		logger:          logger,
+	}
+}
+
+func (s *splitAndShardSeriesRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	spanLog, ctx := spanlogger.NewWithLogger(r.Context(), s.logger, "splitAndShardSeriesRoundTripper.RoundTrip")
+	defer spanLog.Finish()
+
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return nil, apierror.New(apierror.TypeBadData, err.Error())
+	}
+
+	reqValues, err := util.ParseRequestFormWithoutConsumingBody(r)
+	if err != nil {
+		return nil, apierror.New(apierror.TypeBadData, err.Error())
+	}
+
+	// Invalid requests are passed through, so that the client gets the same error it would get from queriers.
+	selectors, err := parseSeriesQuerySelectors(reqValues)
+	if err != nil {
+		spanLog.DebugLog("msg", "skipped splitting and sharding of series query because the selectors can't be parsed", "err", err)
+		return s.next.RoundTrip(r)
+	}
+	start, end, err := DecodeLabelsQueryTimeParams(&reqValues, true)
+	if err != nil {
+		spanLog.DebugLog("msg", "skipped splitting and sharding of series query because the time range can't be parsed", "err", err)
+		return s.next.RoundTrip(r)
+	}
+	limit, err := parseSeriesQueryLimit(reqValues)
+	if err != nil {
+		spanLog.DebugLog("msg", "skipped splitting and sharding of series query because the limit can't be parsed", "err", err)
+		return s.next.RoundTrip(r)
+	}
+
+	// Series queries with an open time range can't be split by time.
+	ranges := []timeRange{{start: start, end: end}}
+	splitByTime := start != v1.MinTime.UnixMilli() && end != v1.MaxTime.UnixMilli()
+	if splitByTime {
+		ranges = splitTimeRangeByInterval(start, end, s.splitInterval)
+	}
+
+	shardCount := 1
+	if s.shardingEnabled && !isSeriesQuerySharded(selectors) {
+		shardCount = max(1, getShardCountForPartialQueries(spanLog, tenantIDs, r, s.limits, len(ranges)))
+	}
+
+	if len(ranges) == 1 && shardCount == 1 {
+		spanLog.DebugLog("msg", "series query doesn't need to be split or sharded")
+		return s.next.RoundTrip(r)
+	}
+
+	spanLog.DebugLog("msg", "splitting and sharding series query", "splitQueries", len(ranges), "shards", shardCount)
+
+	reqs := make([]*http.Request, 0, len(ranges)*shardCount)
+	for _, tr := range ranges {
+		for shardIndex := 0; shardIndex < shardCount; shardIndex++ {
+			partialValues, err := buildSeriesPartialQueryValues(reqValues, selectors, tr, splitByTime, shardIndex, shardCount)
+			if err != nil {
+				return nil, apierror.New(apierror.TypeInternal, err.Error())
+			}
+
+			req, err := buildPartialFormRequest(ctx, r, partialValues)
+			if err != nil {
+				return nil, apierror.New(apierror.TypeInternal, err.Error())
+			}
+			reqs = append(reqs, req)
+		}
+	}
+
+	queryStats := stats.FromContext(ctx)
+	if len(ranges) > 1 {
+		queryStats.AddSplitQueries(uint32(len(ranges)))
+	}
+	if shardCount > 1 {
+		queryStats.AddShardedQueries(uint32(len(reqs)))
+	}
+
+	parallelism := validation.SmallestPositiveIntPerTenant(tenantIDs, s.limits.MaxQueryParallelism)
+	bodies, failedResp, err := doPartialRequests(ctx, "splitAndShardSeriesRoundTripper.doPartialQuery", reqs, s.next, parallelism)
+	if err != nil {
+		return nil, err
+	}
+	if failedResp != nil {
+		return failedResp, nil
+	}
+
+	merged, err := mergeSeriesResponses(bodies, limit)
+	if err != nil {
+		return nil, apierror.New(apierror.TypeInternal, fmt.Sprintf("failed to merge series responses: %s", err))
+	}
+
+	return encodeJSONResponse(merged)
+}
+
+// parseSeriesQuerySelectors parses the match[] parameters of a series query.
+func parseSeriesQuerySelectors(reqValues url.Values) ([][]*labels.Matcher, error) {
+	if len(reqValues["match[]"]) == 0 {
+		return nil, fmt.Errorf("no match[] parameter provided")
+	}
+
+	selectors := make([][]*labels.Matcher, 0, len(reqValues["match[]"]))
+	for _, value := range reqValues["match[]"] {
+		matchers, err := parser.ParseMetricSelector(value)
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, matchers)
+	}
+
+	return selectors, nil
+}
+
+// parseSeriesQueryLimit parses the limit parameter of a series query. A limit of 0 means unlimited.
+func parseSeriesQueryLimit(reqValues url.Values) (uint64, error) {
+	limitStr := reqValues.Get("limit")
+	if limitStr == "" {
+		return 0, nil
+	}
+
+	limit, err := strconv.ParseUint(limitStr, 10, 64)
+	if err != nil {
+		return 0, apierror.New(apierror.TypeBadData, fmt.Sprintf("limit parameter must be a non-negative number: %s", limitStr))
+	}
+
+	return limit, nil
+}
+
+func isSeriesQuerySharded(selectors [][]*labels.Matcher) bool {
+	for _, matchers := range selectors {
+		for _, m := range matchers {
+			if m.Name == sharding.ShardLabel {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// buildSeriesPartialQueryValues returns the parameters of the partial series query selecting the series of the
+// given shard within tr.
+func buildSeriesPartialQueryValues(reqValues url.Values, selectors [][]*labels.Matcher, tr timeRange, splitByTime bool, shardIndex, shardCount int) (url.Values, error) {
+	partialValues := make(url.Values, len(reqValues))
+	for name, values := range reqValues {
+		partialValues[name] = values
+	}
+
+	if splitByTime {
+		partialValues.Set("start", encodeTime(tr.start))
+		partialValues.Set("end", encodeTime(tr.end))
+	}
+
+	if shardCount > 1 {
+		matchers := make([]string, 0, len(selectors))
+		for _, selector := range selectors {
+			sharded, err := shardedSelector(shardCount, shardIndex, &parser.VectorSelector{LabelMatchers: selector})
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, sharded.String())
+		}
+		partialValues["match[]"] = matchers
+	}
+
+	return partialValues, nil
+}
+
+// buildPartialFormRequest returns a request to the same endpoint as r, sending values as a form.
+func buildPartialFormRequest(ctx context.Context, r *http.Request, values url.Values) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL.Path, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// This is the field read by httpgrpc.FromHTTPRequest, so we need to populate it
+	// here to ensure the request makes it to the querier.
+	req.RequestURI = req.URL.String()
+
+	if err := user.InjectOrgIDIntoHTTPRequest(ctx, req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// mergeSeriesResponses merges the series returned by the partial queries, removing duplicates and sorting them. If
+// limit is positive, at most limit series are returned.
+func mergeSeriesResponses(bodies [][]byte, limit uint64) (*seriesResponse, error) {
+	var (
+		merged   = &seriesResponse{Status: statusSuccess, Data: []labels.Labels{}}
+		seen     = map[string]struct{}{}
+		warnings = map[string]struct{}{}
+	)
+
+	for _, body := range bodies {
+		var partial seriesResponse
+		if err := json.Unmarshal(body, &partial); err != nil {
+			return nil, err
+		}
+
+		for _, series := range partial.Data {
+			key := series.String()
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			merged.Data = append(merged.Data, series)
+		}
+
+		for _, warning := range partial.Warnings {
+			if _, ok := warnings[warning]; ok {
+				continue
+			}
+			warnings[warning] = struct{}{}
+			merged.Warnings = append(merged.Warnings, warning)
+		}
+	}
+
+	sort.Slice(merged.Data, func(i, j int) bool {
+		return labels.Compare(merged.Data[i], merged.Data[j]) < 0
+	})
+
+	if limit > 0 && uint64(len(merged.Data)) >= limit {
+		merged.Data = merged.Data[:limit]
+		if _, ok := warnings[seriesTruncatedWarning]; !ok {
+			merged.Warnings = append(merged.Warnings, seriesTruncatedWarning)
+		}
+	}
+
+	return merged, nil
+}
+
+func encodeJSONResponse(v any) (*http.Response, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, apierror.New(apierror.TypeInternal, fmt.Sprintf("failed to encode response: %s", err))
+	}
+
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": []string{jsonMimeType}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}, nil
+}
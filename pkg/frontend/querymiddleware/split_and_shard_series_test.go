@@ -0,0 +1,259 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package querymiddleware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir/pkg/storage/sharding"
+	"github.com/grafana/mimir/pkg/util"
+)
+
+type seriesWithTimeRange struct {
+	labels     labels.Labels
+	mint, maxt int64
+}
+
+// newSeriesQueryDownstream returns a http.RoundTripper serving series queries for the given series, honoring the
+// shard selector, the time range and the limit of the request.
+func newSeriesQueryDownstream(series []seriesWithTimeRange) http.RoundTripper {
+	return RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+		values, err := util.ParseRequestFormWithoutConsumingBody(r)
+		if err != nil {
+			return nil, err
+		}
+
+		start, end, err := DecodeLabelsQueryTimeParams(&values, true)
+		if err != nil {
+			return nil, err
+		}
+		limit, err := parseSeriesQueryLimit(values)
+		if err != nil {
+			return nil, err
+		}
+
+		res := seriesResponse{Status: statusSuccess, Data: []labels.Labels{}}
+		for _, s := range series {
+			if s.maxt < start || s.mint > end {
+				continue
+			}
+
+			for _, selector := range values["match[]"] {
+				matchers, err := parser.ParseMetricSelector(selector)
+				if err != nil {
+					return nil, err
+				}
+				shard, matchers, err := sharding.RemoveShardFromMatchers(matchers)
+				if err != nil {
+					return nil, err
+				}
+				if shard != nil && s.labels.Hash()%shard.ShardCount != shard.ShardIndex {
+					continue
+				}
+				if matchesAll(matchers, s.labels) {
+					res.Data = append(res.Data, s.labels)
+					break
+				}
+			}
+		}
+
+		sort.Slice(res.Data, func(i, j int) bool { return labels.Compare(res.Data[i], res.Data[j]) < 0 })
+		if limit > 0 && uint64(len(res.Data)) >= limit {
+			res.Data = res.Data[:limit]
+			res.Warnings = append(res.Warnings, seriesTruncatedWarning)
+		}
+
+		return encodeJSONResponse(res)
+	})
+}
+
+func matchesAll(matchers []*labels.Matcher, lbls labels.Labels) bool {
+	for _, m := range matchers {
+		if !m.Matches(lbls.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSplitAndShardSeriesRoundTripper(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	var series []seriesWithTimeRange
+	for i := 0; i < 50; i++ {
+		// Each series exists for 12 hours, and series overlap with each other.
+		mint := start.Add(time.Duration(i) * 2 * time.Hour)
+		series = append(series,
+			seriesWithTimeRange{labels: labels.FromStrings(labels.MetricName, "up", "instance", strconv.Itoa(i)), mint: mint.UnixMilli(), maxt: mint.Add(12 * time.Hour).UnixMilli()},
+			seriesWithTimeRange{labels: labels.FromStrings(labels.MetricName, "other", "instance", strconv.Itoa(i)), mint: mint.UnixMilli(), maxt: mint.Add(12 * time.Hour).UnixMilli()},
+		)
+	}
+
+	testCases := map[string]struct {
+		params          url.Values
+		shardingEnabled bool
+		totalShards     int
+		maxShardedQs    int
+		headers         http.Header
+
+		expectedDownstreamRequests int
+	}{
+		"split by time": {
+			params:                     url.Values{"match[]": {`up`}, "start": {encodeTime(start.Add(time.Hour).UnixMilli())}, "end": {encodeTime(start.Add(3 * day).UnixMilli())}},
+			expectedDownstreamRequests: 4,
+		},
+		"split by time and sharded": {
+			params:                     url.Values{"match[]": {`up`}, "start": {encodeTime(start.Add(time.Hour).UnixMilli())}, "end": {encodeTime(start.Add(3 * day).UnixMilli())}},
+			shardingEnabled:            true,
+			totalShards:                4,
+			expectedDownstreamRequests: 16,
+		},
+		"sharded with multiple selectors": {
+			params:                     url.Values{"match[]": {`up{instance=~"1.*"}`, `{__name__="other", instance=~"2.*"}`}, "start": {encodeTime(start.Add(time.Hour).UnixMilli())}, "end": {encodeTime(start.Add(3 * day).UnixMilli())}},
+			shardingEnabled:            true,
+			totalShards:                2,
+			expectedDownstreamRequests: 8,
+		},
+		"open time range is only sharded": {
+			params:                     url.Values{"match[]": {`up`}},
+			shardingEnabled:            true,
+			totalShards:                4,
+			expectedDownstreamRequests: 4,
+		},
+		"shard count bounded by the max sharded queries": {
+			params:                     url.Values{"match[]": {`up`}, "start": {encodeTime(start.Add(time.Hour).UnixMilli())}, "end": {encodeTime(start.Add(3 * day).UnixMilli())}},
+			shardingEnabled:            true,
+			totalShards:                16,
+			maxShardedQs:               8,
+			expectedDownstreamRequests: 8,
+		},
+		"shard count from request header": {
+			params:                     url.Values{"match[]": {`up`}},
+			shardingEnabled:            true,
+			totalShards:                4,
+			headers:                    http.Header{totalShardsControlHeader: {"2"}},
+			expectedDownstreamRequests: 2,
+		},
+		"limit applied to the merged series": {
+			params:                     url.Values{"match[]": {`up`}, "start": {encodeTime(start.Add(time.Hour).UnixMilli())}, "end": {encodeTime(start.Add(3 * day).UnixMilli())}, "limit": {"5"}},
+			shardingEnabled:            true,
+			totalShards:                4,
+			expectedDownstreamRequests: 16,
+		},
+		"already sharded query is not sharded again": {
+			params:                     url.Values{"match[]": {`up{__query_shard__="1_of_2"}`}},
+			shardingEnabled:            true,
+			totalShards:                4,
+			expectedDownstreamRequests: 1,
+		},
+		"query within a single interval and sharding disabled is passed through": {
+			params:                     url.Values{"match[]": {`up`}, "start": {encodeTime(start.Add(time.Hour).UnixMilli())}, "end": {encodeTime(start.Add(2 * time.Hour).UnixMilli())}},
+			expectedDownstreamRequests: 1,
+		},
+		"query without selectors is passed through": {
+			params:                     url.Values{"start": {encodeTime(start.Add(time.Hour).UnixMilli())}, "end": {encodeTime(start.Add(3 * day).UnixMilli())}},
+			shardingEnabled:            true,
+			totalShards:                4,
+			expectedDownstreamRequests: 1,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			downstream := newSeriesQueryDownstream(series)
+
+			var (
+				downstreamMx       sync.Mutex
+				downstreamRequests int
+			)
+			countingDownstream := RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+				downstreamMx.Lock()
+				downstreamRequests++
+				downstreamMx.Unlock()
+				return downstream.RoundTrip(r)
+			})
+
+			limits := mockLimits{totalShards: testCase.totalShards, maxShardedQueries: testCase.maxShardedQs, maxQueryParallelism: 4}
+			rt := newSplitAndShardSeriesRoundTripper(countingDownstream, day, testCase.shardingEnabled, limits, log.NewNopLogger())
+
+			ctx := user.InjectOrgID(context.Background(), "user-1")
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/api/v1/series?"+testCase.params.Encode(), nil)
+			require.NoError(t, err)
+			req.Header = testCase.headers
+
+			expectedRes, err := downstream.RoundTrip(req.Clone(ctx))
+			require.NoError(t, err)
+			expectedBody, err := io.ReadAll(expectedRes.Body)
+			require.NoError(t, err)
+
+			res, err := rt.RoundTrip(req)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			body, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			assert.JSONEq(t, string(expectedBody), string(body))
+			assert.Equal(t, testCase.expectedDownstreamRequests, downstreamRequests)
+		})
+	}
+}
+
+func TestSplitAndShardSeriesRoundTripper_DownstreamError(t *testing.T) {
+	downstream := RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+		values, err := util.ParseRequestFormWithoutConsumingBody(r)
+		if err != nil {
+			return nil, err
+		}
+
+		matchers, err := parser.ParseMetricSelector(values.Get("match[]"))
+		if err != nil {
+			return nil, err
+		}
+		shard, _, err := sharding.RemoveShardFromMatchers(matchers)
+		if err != nil {
+			return nil, err
+		}
+
+		// Fail only one of the partial queries.
+		if shard == nil || shard.ShardIndex != 1 {
+			return encodeJSONResponse(seriesResponse{Status: statusSuccess, Data: []labels.Labels{}})
+		}
+
+		return &http.Response{
+			StatusCode: http.StatusUnprocessableEntity,
+			Header:     http.Header{"Content-Type": []string{jsonMimeType}},
+			Body:       io.NopCloser(bytes.NewReader([]byte(`{"status":"error","errorType":"execution","error":"failed"}`))),
+		}, nil
+	})
+
+	limits := mockLimits{totalShards: 2}
+	rt := newSplitAndShardSeriesRoundTripper(downstream, 24*time.Hour, true, limits, log.NewNopLogger())
+
+	ctx := user.InjectOrgID(context.Background(), "user-1")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/api/v1/series?"+url.Values{"match[]": {"up"}}.Encode(), nil)
+	require.NoError(t, err)
+
+	res, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnprocessableEntity, res.StatusCode)
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"status":"error","errorType":"execution","error":"failed"}`, string(body))
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/grafana/mimir/pkg/storage/lazyquery"
 	"github.com/grafana/mimir/pkg/util/spanlogger"
 	"github.com/grafana/mimir/pkg/util/validation"
+	"github.com/prometheus/prometheus/model/timestamp"
 )
 
 const (
@@ -36,6 +37,9 @@ type splitInstantQueryByIntervalMiddleware struct {
 
 	engine *promql.Engine
 
+	// alignSplits is true if the split partial queries should be aligned to the split interval, so that their results can be cached.
+	alignSplits bool
+
 	metrics instantQuerySplittingMetrics
 }
 
@@ -86,16 +90,18 @@ func newSplitInstantQueryByIntervalMiddleware(
 	limits Limits,
 	logger log.Logger,
 	engine *promql.Engine,
+	alignSplits bool,
 	registerer prometheus.Registerer) MetricsQueryMiddleware {
 	metrics := newInstantQuerySplittingMetrics(registerer)
 
 	return MetricsQueryMiddlewareFunc(func(next MetricsQueryHandler) MetricsQueryHandler {
 		return &splitInstantQueryByIntervalMiddleware{
-			next:    next,
-			limits:  limits,
-			logger:  logger,
-			engine:  engine,
-			metrics: metrics,
+			next:        next,
+			limits:      limits,
+			logger:      logger,
+			engine:      engine,
+			alignSplits: alignSplits,
+			metrics:     metrics,
 		}
 	})
 }
@@ -124,7 +130,12 @@ func (s *splitInstantQueryByIntervalMiddleware) Do(ctx context.Context, req Metr
 	mapperStats := astmapper.NewInstantSplitterStats()
 	mapperCtx, cancel := context.WithTimeout(ctx, shardingTimeout)
 	defer cancel()
-	mapper := astmapper.NewInstantQuerySplitter(mapperCtx, splitInterval, s.logger, mapperStats)
+	var mapper astmapper.ASTMapper
+	if s.alignSplits {
+		mapper = astmapper.NewAlignedInstantQuerySplitter(mapperCtx, splitInterval, timestamp.Time(req.GetStart()), s.logger, mapperStats)
+	} else {
+		mapper = astmapper.NewInstantQuerySplitter(mapperCtx, splitInterval, s.logger, mapperStats)
+	}
 
 	expr, err := parser.ParseExpr(req.GetQuery())
 	if err != nil {
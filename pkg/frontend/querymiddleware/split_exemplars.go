@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package querymiddleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/tenant"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/prometheus/prometheus/model/labels"
+
+	apierror "github.com/grafana/mimir/pkg/api/error"
+	"github.com/grafana/mimir/pkg/querier/stats"
+	"github.com/grafana/mimir/pkg/util"
+	"github.com/grafana/mimir/pkg/util/spanlogger"
+	"github.com/grafana/mimir/pkg/util/validation"
+)
+
+type exemplarsResponse struct {
+	Status   string                 `json:"status"`
+	Data     []exemplarsQueryResult `json:"data"`
+	Warnings []string               `json:"warnings,omitempty"`
+}
+
+type exemplarsQueryResult struct {
+	SeriesLabels labels.Labels `json:"seriesLabels"`
+	// Exemplars are kept encoded, because they're only concatenated when merging the partial results.
+	Exemplars []jsoniter.RawMessage `json:"exemplars"`
+}
+
+// splitExemplarsRoundTripper is a http.RoundTripper that splits exemplar queries by time, and merges the exemplars
+// returned by the partial queries.
+type splitExemplarsRoundTripper struct {
+	next          http.RoundTripper
+	splitInterval time.Duration
+	limits        Limits
+	logger        log.Logger
+}
+
+func newSplitExemplarsRoundTripper(next http.RoundTripper, splitInterval time.Duration, limits Limits, logger log.Logger) http.RoundTripper {
+	return &splitExemplarsRoundTripper{
+		next:          next,
+		splitInterval: splitInterval,
+		limits:        limits,
+		logger:        logger,
+	}
+}
+
+func (s *splitExemplarsRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	spanLog, ctx := spanlogger.NewWithLogger(r.Context(), s.logger, "splitExemplarsRoundTripper.RoundTrip")
+	defer spanLog.Finish()
+
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return nil, apierror.New(apierror.TypeBadData, err.Error())
+	}
+
+	reqValues, err := util.ParseRequestFormWithoutConsumingBody(r)
+	if err != nil {
+		return nil, apierror.New(apierror.TypeBadData, err.Error())
+	}
+
+	// Exemplar queries with an open time range can't be split by time, and invalid requests are passed through, so
+	// that the client gets the same error it would get from queriers.
+	if reqValues.Get("start") == "" || reqValues.Get("end") == "" {
+		spanLog.DebugLog("msg", "skipped splitting of exemplar query because the time range is open")
+		return s.next.RoundTrip(r)
+	}
+	start, end, err := DecodeLabelsQueryTimeParams(&reqValues, false)
+	if err != nil {
+		spanLog.DebugLog("msg", "skipped splitting of exemplar query because the time range can't be parsed", "err", err)
+		return s.next.RoundTrip(r)
+	}
+
+	ranges := splitTimeRangeByInterval(start, end, s.splitInterval)
+	if len(ranges) == 1 {
+		spanLog.DebugLog("msg", "exemplar query doesn't need to be split")
+		return s.next.RoundTrip(r)
+	}
+
+	spanLog.DebugLog("msg", "splitting exemplar query", "splitQueries", len(ranges))
+
+	reqs := make([]*http.Request, 0, len(ranges))
+	for _, tr := range ranges {
+		partialValues := make(url.Values, len(reqValues))
+		for name, values := range reqValues {
+			partialValues[name] = values
+		}
+		partialValues.Set("start", encodeTime(tr.start))
+		partialValues.Set("end", encodeTime(tr.end))
+
+		req, err := buildPartialFormRequest(ctx, r, partialValues)
+		if err != nil {
+			return nil, apierror.New(apierror.TypeInternal, err.Error())
+		}
+		reqs = append(reqs, req)
+	}
+
+	stats.FromContext(ctx).AddSplitQueries(uint32(len(reqs)))
+
+	parallelism := validation.SmallestPositiveIntPerTenant(tenantIDs, s.limits.MaxQueryParallelism)
+	bodies, failedResp, err := doPartialRequests(ctx, "splitExemplarsRoundTripper.doPartialQuery", reqs, s.next, parallelism)
+	if err != nil {
+		return nil, err
+	}
+	if failedResp != nil {
+		return failedResp, nil
+	}
+
+	merged, err := mergeExemplarsResponses(bodies)
+	if err != nil {
+		return nil, apierror.New(apierror.TypeInternal, fmt.Sprintf("failed to merge exemplar responses: %s", err))
+	}
+
+	return encodeJSONResponse(merged)
+}
+
+// mergeExemplarsResponses merges the exemplars returned by the partial queries, which must be ordered by time.
+// The exemplars of each series are kept in time order, and series are sorted by labels.
+func mergeExemplarsResponses(bodies [][]byte) (*exemplarsResponse, error) {
+	var (
+		merged   = &exemplarsResponse{Status: statusSuccess, Data: []exemplarsQueryResult{}}
+		series   = map[string]int{}
+		warnings = map[string]struct{}{}
+	)
+
+	for _, body := range bodies {
+		var partial exemplarsResponse
+		if err := json.Unmarshal(body, &partial); err != nil {
+			return nil, err
+		}
+
+		for _, result := range partial.Data {
+			key := result.SeriesLabels.String()
+			if idx, ok := series[key]; ok {
+				merged.Data[idx].Exemplars = append(merged.Data[idx].Exemplars, result.Exemplars...)
+				continue
+			}
+			series[key] = len(merged.Data)
+			merged.Data = append(merged.Data, result)
+		}
+
+		for _, warning := range partial.Warnings {
+			if _, ok := warnings[warning]; ok {
+				continue
+			}
+			warnings[warning] = struct{}{}
+			merged.Warnings = append(merged.Warnings, warning)
+		}
+	}
+
+	sort.Slice(merged.Data, func(i, j int) bool {
+		return labels.Compare(merged.Data[i].SeriesLabels, merged.Data[j].SeriesLabels) < 0
+	})
+
+	return merged, nil
+}
@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package querymiddleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/user"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir/pkg/util"
+)
+
+// newExemplarsQueryDownstream returns a http.RoundTripper serving exemplar queries for series having one exemplar
+// each hour between start and end, honoring the time range of the request.
+func newExemplarsQueryDownstream(series []labels.Labels, start, end time.Time) http.RoundTripper {
+	return RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+		values, err := util.ParseRequestFormWithoutConsumingBody(r)
+		if err != nil {
+			return nil, err
+		}
+
+		reqStart, reqEnd, err := DecodeLabelsQueryTimeParams(&values, true)
+		if err != nil {
+			return nil, err
+		}
+
+		res := exemplarsResponse{Status: statusSuccess, Data: []exemplarsQueryResult{}}
+		for i, lbls := range series {
+			result := exemplarsQueryResult{SeriesLabels: lbls}
+			for ts := start; !ts.After(end); ts = ts.Add(time.Hour) {
+				if ts.UnixMilli() < reqStart || ts.UnixMilli() > reqEnd {
+					continue
+				}
+				exemplar := fmt.Sprintf(`{"labels":{"trace_id":"%d"},"value":"%d","timestamp":%s}`, i, i, encodeTime(ts.UnixMilli()))
+				result.Exemplars = append(result.Exemplars, jsoniter.RawMessage(exemplar))
+			}
+
+			if len(result.Exemplars) > 0 {
+				res.Data = append(res.Data, result)
+			}
+		}
+
+		return encodeJSONResponse(res)
+	})
+}
+
+func TestSplitExemplarsRoundTripper(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(3 * day)
+
+	var series []labels.Labels
+	for i := 9; i >= 0; i-- {
+		series = append(series, labels.FromStrings(labels.MetricName, "up", "instance", strconv.Itoa(i)))
+	}
+
+	testCases := map[string]struct {
+		params                     url.Values
+		expectedDownstreamRequests int
+	}{
+		"split by time": {
+			params:                     url.Values{"query": {"up"}, "start": {encodeTime(start.Add(time.Hour).UnixMilli())}, "end": {encodeTime(end.UnixMilli())}},
+			expectedDownstreamRequests: 4,
+		},
+		"query within a single interval is passed through": {
+			params:                     url.Values{"query": {"up"}, "start": {encodeTime(start.Add(time.Hour).UnixMilli())}, "end": {encodeTime(start.Add(5 * time.Hour).UnixMilli())}},
+			expectedDownstreamRequests: 1,
+		},
+		"query without end is passed through": {
+			params:                     url.Values{"query": {"up"}, "start": {encodeTime(start.Add(time.Hour).UnixMilli())}},
+			expectedDownstreamRequests: 1,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			downstream := newExemplarsQueryDownstream(series, start, end)
+
+			var (
+				downstreamMx       sync.Mutex
+				downstreamRequests int
+			)
+			countingDownstream := RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+				downstreamMx.Lock()
+				downstreamRequests++
+				downstreamMx.Unlock()
+				return downstream.RoundTrip(r)
+			})
+
+			rt := newSplitExemplarsRoundTripper(countingDownstream, day, mockLimits{maxQueryParallelism: 4}, log.NewNopLogger())
+
+			ctx := user.InjectOrgID(context.Background(), "user-1")
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/api/v1/query_exemplars?"+testCase.params.Encode(), nil)
+			require.NoError(t, err)
+
+			res, err := rt.RoundTrip(req)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			body, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			expectedRes, err := downstream.RoundTrip(req)
+			require.NoError(t, err)
+			var expected exemplarsResponse
+			require.NoError(t, json.NewDecoder(expectedRes.Body).Decode(&expected))
+			if testCase.expectedDownstreamRequests > 1 {
+				// The merged series are sorted by labels.
+				sort.Slice(expected.Data, func(i, j int) bool {
+					return labels.Compare(expected.Data[i].SeriesLabels, expected.Data[j].SeriesLabels) < 0
+				})
+			}
+			expectedBody, err := json.Marshal(expected)
+			require.NoError(t, err)
+
+			assert.JSONEq(t, string(expectedBody), string(body))
+			assert.Equal(t, testCase.expectedDownstreamRequests, downstreamRequests)
+		})
+	}
+}
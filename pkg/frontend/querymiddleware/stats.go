@@ -145,6 +145,16 @@ type QueryDetails struct {
 
 	ResultsCacheMissBytes int
 	ResultsCacheHitBytes  int
+
+	// EstimatedQueryCost and QueryCostDecision are set when the query has been subject to cost-based admission.
+	EstimatedQueryCost uint64
+	QueryCostDecision  string
+
+	// OriginalQuery, RewrittenQuery and QueryRewriteRuleIndex are set when the query has been rewritten
+	// by one of the tenant's query rewrite rules.
+	OriginalQuery         string
+	RewrittenQuery        string
+	QueryRewriteRuleIndex int
 }
 
 type contextKey int
@@ -6,12 +6,14 @@
 package transport
 
 import (
+	"cmp"
 	"context"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -40,6 +42,11 @@ const (
 	// StatusClientClosedRequest is the status code for when a client request cancellation of an http request
 	StatusClientClosedRequest = 499
 	ServiceTimingHeaderName   = "Server-Timing"
+	QueryCostHeaderName       = "Query-Cost"
+
+	// maxLoggedOperatorStats is the maximum number of streaming PromQL engine operators included in the query stats
+	// log, to limit the size of the log line for complex or sharded queries.
+	maxLoggedOperatorStats = querier_stats.MaxMergedOperatorStats
 )
 
 var (
@@ -211,6 +218,9 @@ func (f *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	queryResponseTime := time.Since(startTime)
 
 	if err != nil {
+		if f.cfg.QueryStatsEnabled {
+			writeQueryCostHeader(w.Header(), queryDetails)
+		}
 		writeError(w, err)
 		f.reportQueryStats(r, params, startTime, queryResponseTime, 0, queryDetails, 0, err)
 		return
@@ -233,17 +243,23 @@ func (f *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	if f.cfg.QueryStatsEnabled {
 		writeServiceTimingHeader(queryResponseTime, hs, queryDetails.QuerierStats)
+		writeQueryCostHeader(hs, queryDetails)
 	}
 
 	w.WriteHeader(resp.StatusCode)
-	// we don't check for copy error as there is no much we can do at this point
-	queryResponseSize, _ := io.Copy(w, resp.Body)
+	queryResponseSize, copyErr := io.Copy(w, resp.Body)
 
 	if f.cfg.LogQueriesLongerThan > 0 && queryResponseTime > f.cfg.LogQueriesLongerThan {
 		f.reportSlowQuery(r, params, queryResponseTime, queryDetails)
 	}
 	if f.cfg.QueryStatsEnabled {
-		f.reportQueryStats(r, params, startTime, queryResponseTime, queryResponseSize, queryDetails, resp.StatusCode, nil)
+		f.reportQueryStats(r, params, startTime, queryResponseTime, queryResponseSize, queryDetails, resp.StatusCode, copyErr)
+	}
+
+	if copyErr != nil {
+		// The status code has already been sent, so abort the response to let the client know that the body is
+		// incomplete, instead of ending it as if it was complete.
+		panic(http.ErrAbortHandler)
 	}
 }
 
@@ -319,8 +335,13 @@ func (f *Handler) reportQueryStats(
 		"split_queries", stats.LoadSplitQueries(),
 		"estimated_series_count", stats.GetEstimatedSeriesCount(),
 		"queue_time_seconds", stats.LoadQueueTime().Seconds(),
+		"estimated_peak_memory_consumption_bytes", stats.LoadEstimatedPeakMemoryConsumption(),
 	}, formatQueryString(details, queryString)...)
 
+	if operatorStats := stats.LoadOperatorStats(); len(operatorStats) > 0 {
+		logMessage = append(logMessage, "operator_stats", formatOperatorStats(operatorStats))
+	}
+
 	if details != nil {
 		// Start and End may be zero when the request wasn't a query (e.g. /metadata)
 		// or if the query was a constant expression and didn't need to process samples.
@@ -337,6 +358,19 @@ func (f *Handler) reportQueryStats(
 			"results_cache_hit_bytes", details.ResultsCacheHitBytes,
 			"results_cache_miss_bytes", details.ResultsCacheMissBytes,
 		)
+		if details.QueryCostDecision != "" {
+			logMessage = append(logMessage,
+				"estimated_query_cost", details.EstimatedQueryCost,
+				"query_cost_decision", details.QueryCostDecision,
+			)
+		}
+		if details.RewrittenQuery != "" {
+			logMessage = append(logMessage,
+				"original_query", details.OriginalQuery,
+				"rewritten_query", details.RewrittenQuery,
+				"query_rewrite_rule_index", details.QueryRewriteRuleIndex,
+			)
+		}
 	}
 
 	// Log the read consistency only when explicitly defined.
@@ -418,6 +452,28 @@ func formatRequestHeaders(h *http.Header, headersToLog []string) (fields []any)
 	return fields
 }
 
+// formatOperatorStats returns a summary of the streaming PromQL engine operators that took the longest to evaluate,
+// slowest first.
+func formatOperatorStats(operators []querier_stats.OperatorStats) string {
+	slices.SortStableFunc(operators, func(a, b querier_stats.OperatorStats) int {
+		return cmp.Compare(b.WallTime, a.WallTime)
+	})
+
+	if len(operators) > maxLoggedOperatorStats {
+		operators = operators[:maxLoggedOperatorStats]
+	}
+
+	summaries := make([]string, 0, len(operators))
+	for _, o := range operators {
+		summaries = append(summaries, fmt.Sprintf(
+			"%s (series_in=%d, series_out=%d, samples_processed=%d, wall_time=%v, peak_buffered_points=%d)",
+			o.Operator, o.SeriesIn, o.SeriesOut, o.SamplesProcessed, o.WallTime, o.PeakBufferedPoints,
+		))
+	}
+
+	return strings.Join(summaries, "; ")
+}
+
 func writeError(w http.ResponseWriter, err error) {
 	switch {
 	case errors.Is(err, context.Canceled):
@@ -448,6 +504,14 @@ func writeServiceTimingHeader(queryResponseTime time.Duration, headers http.Head
 	}
 }
 
+// writeQueryCostHeader writes the estimated query cost and the admission decision, if the query has been subject to
+// cost-based admission.
+func writeQueryCostHeader(headers http.Header, details *querymiddleware.QueryDetails) {
+	if details != nil && details.QueryCostDecision != "" {
+		headers.Set(QueryCostHeaderName, fmt.Sprintf("estimate=%d, decision=%s", details.EstimatedQueryCost, details.QueryCostDecision))
+	}
+}
+
 func statsValue(name string, d time.Duration) string {
 	durationInMs := strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
 	return name + ";dur=" + durationInMs
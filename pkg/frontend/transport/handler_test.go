@@ -33,6 +33,7 @@ import (
 
 	"github.com/grafana/mimir/pkg/frontend/querymiddleware"
 	"github.com/grafana/mimir/pkg/querier/api"
+	querier_stats "github.com/grafana/mimir/pkg/querier/stats"
 	"github.com/grafana/mimir/pkg/util/activitytracker"
 )
 
@@ -227,6 +228,8 @@ func TestHandler_ServeHTTP(t *testing.T) {
 				require.EqualValues(t, 0, msg["split_queries"])
 				require.EqualValues(t, 0, msg["estimated_series_count"])
 				require.EqualValues(t, 0, msg["queue_time_seconds"])
+				require.EqualValues(t, 0, msg["estimated_peak_memory_consumption_bytes"])
+				require.NotContains(t, msg, "operator_stats")
 
 				if tt.expectedReadConsistency != "" {
 					require.Equal(t, tt.expectedReadConsistency, msg["read_consistency"])
@@ -326,6 +329,31 @@ func TestHandler_FailedRoundTrip(t *testing.T) {
 	}
 }
 
+func TestHandler_FailedResponseBodyAbortsResponse(t *testing.T) {
+	roundTripper := roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		body, writer := io.Pipe()
+		go func() {
+			_, _ = writer.Write([]byte(`{"status":"success","data":`))
+			_ = writer.CloseWithError(errors.New("encoding failed"))
+		}()
+		return &http.Response{StatusCode: http.StatusOK, Body: body, ContentLength: -1}, nil
+	})
+
+	logs := &concurrency.SyncBuffer{}
+	handler := NewHandler(HandlerConfig{QueryStatsEnabled: true}, roundTripper, log.NewLogfmtLogger(logs), prometheus.NewPedanticRegistry(), nil)
+
+	req := httptest.NewRequest("GET", "/api/v1/query?query=up", nil)
+	req = req.WithContext(user.InjectOrgID(context.Background(), "12345"))
+	resp := httptest.NewRecorder()
+
+	require.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		handler.ServeHTTP(resp, req)
+	})
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, `{"status":"success","data":`, resp.Body.String())
+	assert.Contains(t, logs.String(), "encoding failed")
+}
+
 // Test Handler.Stop.
 func TestHandler_Stop(t *testing.T) {
 	const (
@@ -419,6 +447,7 @@ func TestHandler_LogsFormattedQueryDetails(t *testing.T) {
 		expectedLoggedFields         map[string]string
 		expectedMissingFields        []string
 		expectedApproximateDurations map[string]time.Duration
+		expectedQueryCostHeader      string
 	}{
 		{
 			name:              "query_range",
@@ -467,7 +496,7 @@ func TestHandler_LogsFormattedQueryDetails(t *testing.T) {
 			setQueryDetails:              func(*querymiddleware.QueryDetails) {},
 			expectedLoggedFields:         map[string]string{},
 			expectedApproximateDurations: map[string]time.Duration{},
-			expectedMissingFields:        []string{"length", "param_time", "time_since_param_start", "time_since_param_end"},
+			expectedMissingFields:        []string{"length", "param_time", "time_since_param_start", "time_since_param_end", "estimated_query_cost", "query_cost_decision", "rewritten_query"},
 		},
 		{
 			name:              "results cache statistics",
@@ -481,6 +510,33 @@ func TestHandler_LogsFormattedQueryDetails(t *testing.T) {
 				"results_cache_hit_bytes":  "200",
 			},
 		},
+		{
+			name:              "query cost",
+			requestFormFields: []string{},
+			setQueryDetails: func(d *querymiddleware.QueryDetails) {
+				d.EstimatedQueryCost = 1500
+				d.QueryCostDecision = "deprioritized"
+			},
+			expectedLoggedFields: map[string]string{
+				"estimated_query_cost": "1500",
+				"query_cost_decision":  "deprioritized",
+			},
+			expectedQueryCostHeader: "estimate=1500, decision=deprioritized",
+		},
+		{
+			name:              "rewritten query",
+			requestFormFields: []string{},
+			setQueryDetails: func(d *querymiddleware.QueryDetails) {
+				d.OriginalQuery = "sum(rate(requests_total[5m]))"
+				d.RewrittenQuery = "job:requests:rate5m"
+				d.QueryRewriteRuleIndex = 1
+			},
+			expectedLoggedFields: map[string]string{
+				"original_query":           "sum(rate(requests_total[5m]))",
+				"rewritten_query":          "job:requests:rate5m",
+				"query_rewrite_rule_index": "1",
+			},
+		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
 			activityFile := filepath.Join(t.TempDir(), "activity-tracker")
@@ -514,6 +570,7 @@ func TestHandler_LogsFormattedQueryDetails(t *testing.T) {
 			responseData, _ := io.ReadAll(resp.Body)
 			require.Equal(t, resp.Code, http.StatusOK)
 			require.Equal(t, []byte("{}"), responseData)
+			require.Equal(t, tt.expectedQueryCostHeader, resp.Header().Get(QueryCostHeaderName))
 
 			require.Len(t, logger.logMessages, 1)
 
@@ -629,6 +686,24 @@ func (t *testLogger) Log(keyvals ...interface{}) error {
 	return nil
 }
 
+func TestFormatOperatorStats(t *testing.T) {
+	operators := []querier_stats.OperatorStats{
+		{Operator: "Aggregation: operation=sum", SeriesIn: 3, SeriesOut: 1, SamplesProcessed: 10, WallTime: time.Millisecond},
+		{Operator: "InstantVectorSelector: matchers={__name__=\"some_metric\"}", Depth: 1, SeriesOut: 3, SamplesProcessed: 30, WallTime: time.Second, PeakBufferedPoints: 2},
+	}
+
+	expected := `InstantVectorSelector: matchers={__name__="some_metric"} (series_in=0, series_out=3, samples_processed=30, wall_time=1s, peak_buffered_points=2); ` +
+		`Aggregation: operation=sum (series_in=3, series_out=1, samples_processed=10, wall_time=1ms, peak_buffered_points=0)`
+
+	require.Equal(t, expected, formatOperatorStats(operators))
+
+	for i := 0; i < maxLoggedOperatorStats; i++ {
+		operators = append(operators, querier_stats.OperatorStats{Operator: "ScalarConstant"})
+	}
+
+	require.Len(t, strings.Split(formatOperatorStats(operators), "; "), maxLoggedOperatorStats)
+}
+
 func TestFormatRequestHeaders(t *testing.T) {
 	h := http.Header{}
 	h.Add("X-Header-To-Log", "i should be logged!")
@@ -113,7 +113,7 @@ func New(cfg Config, limits Limits, log log.Logger, registerer prometheus.Regist
 	})
 
 	// additional queue dimensions not used in v1/frontend
-	f.requestQueue = queue.NewRequestQueue(log, cfg.MaxOutstandingPerTenant, false, cfg.QuerierForgetDelay, f.queueLength, f.discardedRequests, enqueueDuration)
+	f.requestQueue = queue.NewRequestQueue(log, cfg.MaxOutstandingPerTenant, false, nil, cfg.QuerierForgetDelay, f.queueLength, f.discardedRequests, enqueueDuration)
 	f.activeUsers = util.NewActiveUsersCleanupWithDefaultValues(f.cleanupInactiveUserMetrics)
 
 	var err error
@@ -214,7 +214,7 @@ func (f *Frontend) Process(server frontendv1pb.Frontend_ProcessServer) error {
 	lastUserIndex := queue.FirstUser()
 
 	for {
-		reqWrapper, idx, err := f.requestQueue.GetNextRequestForQuerier(server.Context(), lastUserIndex, querierID)
+		reqWrapper, idx, err := f.requestQueue.GetNextRequestForQuerier(server.Context(), lastUserIndex, querierID, "")
 		if err != nil {
 			return err
 		}
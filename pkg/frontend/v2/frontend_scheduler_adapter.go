@@ -14,6 +14,7 @@ import (
 	apierror "github.com/grafana/mimir/pkg/api/error"
 	"github.com/grafana/mimir/pkg/frontend/querymiddleware"
 	"github.com/grafana/mimir/pkg/querier"
+	"github.com/grafana/mimir/pkg/scheduler/queue"
 	"github.com/grafana/mimir/pkg/scheduler/schedulerpb"
 	"github.com/grafana/mimir/pkg/util"
 	"github.com/grafana/mimir/pkg/util/validation"
@@ -48,9 +49,9 @@ func (a *frontendToSchedulerAdapter) frontendToSchedulerEnqueueRequest(
 	}, nil
 }
 
-const ShouldQueryIngestersQueueDimension = "ingester"
-const ShouldQueryStoreGatewayQueueDimension = "store-gateway"
-const ShouldQueryIngestersAndStoreGatewayQueueDimension = "ingester-and-store-gateway"
+const ShouldQueryIngestersQueueDimension = queue.IngesterQueueDimension
+const ShouldQueryStoreGatewayQueueDimension = queue.StoreGatewayQueueDimension
+const ShouldQueryIngestersAndStoreGatewayQueueDimension = queue.IngesterAndStoreGatewayQueueDimension
 
 func (a *frontendToSchedulerAdapter) extractAdditionalQueueDimensions(
 	ctx context.Context, request *httpgrpc.HTTPRequest, now time.Time,
@@ -78,29 +79,46 @@ func (a *frontendToSchedulerAdapter) extractAdditionalQueueDimensions(
 		if err != nil {
 			return nil, err
 		}
+		start -= queryLookback(ctx).Milliseconds()
 		return a.queryComponentQueueDimensionFromTimeParams(tenantIDs, start, end, now), nil
 	case querymiddleware.IsInstantQuery(httpRequest.URL.Path):
 		time, err := querymiddleware.DecodeInstantQueryTimeParams(&reqValues, time.Now)
 		if err != nil {
 			return nil, err
 		}
-		return a.queryComponentQueueDimensionFromTimeParams(tenantIDs, time, time, now), nil
-	case querymiddleware.IsLabelsQuery(httpRequest.URL.Path):
+		return a.queryComponentQueueDimensionFromTimeParams(tenantIDs, time-queryLookback(ctx).Milliseconds(), time, now), nil
+	case querymiddleware.IsLabelsQuery(httpRequest.URL.Path), querymiddleware.IsSeriesQuery(httpRequest.URL.Path):
 		start, end, err := querymiddleware.DecodeLabelsQueryTimeParams(&reqValues, true)
 		if err != nil {
 			return nil, err
 		}
 		return a.queryComponentQueueDimensionFromTimeParams(tenantIDs, start, end, now), nil
-	case querymiddleware.IsCardinalityQuery(httpRequest.URL.Path), querymiddleware.IsActiveSeriesQuery(httpRequest.URL.Path):
-		// cardinality only hits ingesters
+	case querymiddleware.IsCardinalityQuery(httpRequest.URL.Path), querymiddleware.IsActiveSeriesQuery(httpRequest.URL.Path),
+		querymiddleware.IsExemplarsQuery(httpRequest.URL.Path):
+		// cardinality and exemplars only hit ingesters
 		return []string{ShouldQueryIngestersQueueDimension}, nil
 	default:
-		// no query time params to parse; cannot infer query component
+		// no query time params to parse; fall back to the time range of the original query, if known
+		if details := querymiddleware.QueryDetailsFromContext(ctx); details != nil && !details.MinT.IsZero() && !details.MaxT.IsZero() {
+			return a.queryComponentQueueDimensionFromTimeParams(tenantIDs, details.MinT.UnixMilli(), details.MaxT.UnixMilli(), now), nil
+		}
+
+		// cannot infer query component
 		level.Debug(a.log).Log("msg", "unsupported request type for additional queue dimensions", "query", httpRequest.URL.String())
 		return nil, nil
 	}
 }
 
+// queryLookback returns how far before its start time the original query selects data, for example because of
+// range selectors or offset modifiers, as recorded in the QueryDetails. Returns 0 if unknown.
+func queryLookback(ctx context.Context) time.Duration {
+	details := querymiddleware.QueryDetailsFromContext(ctx)
+	if details == nil || details.Start.IsZero() || details.MinT.IsZero() || !details.MinT.Before(details.Start) {
+		return 0
+	}
+	return details.Start.Sub(details.MinT)
+}
+
 func (a *frontendToSchedulerAdapter) queryComponentQueueDimensionFromTimeParams(
 	tenantIDs []string, queryStartUnixMs, queryEndUnixMs int64, now time.Time,
 ) []string {
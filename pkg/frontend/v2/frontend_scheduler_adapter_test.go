@@ -10,9 +10,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/go-kit/log"
 	"github.com/grafana/dskit/httpgrpc"
 	"github.com/grafana/dskit/user"
 	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir/pkg/frontend/querymiddleware"
 )
 
 const rangeURLFormat = "/api/v1/query_range?end=%d&query=&start=%d&step=%d"
@@ -188,6 +191,81 @@ func TestExtractAdditionalQueueDimensions(t *testing.T) {
 
 }
 
+func TestExtractAdditionalQueueDimensions_OtherRequestsAndQueryDetails(t *testing.T) {
+	adapter := &frontendToSchedulerAdapter{
+		log:    log.NewNopLogger(),
+		cfg:    Config{QueryStoreAfter: 12 * time.Hour},
+		limits: limits{queryIngestersWithin: 13 * time.Hour},
+	}
+
+	now := time.Now()
+	recent := now.Add(-time.Hour)
+
+	testCases := map[string]struct {
+		url                         string
+		queryDetails                *querymiddleware.QueryDetails
+		expectedAddlQueueDimensions []string
+	}{
+		"series query is classified by its time range": {
+			url:                         fmt.Sprintf("/api/v1/series?match[]=up&start=%d&end=%d", recent.Unix(), now.Unix()),
+			expectedAddlQueueDimensions: []string{ShouldQueryIngestersQueueDimension},
+		},
+		"exemplars query only hits ingesters": {
+			url:                         fmt.Sprintf("/api/v1/query_exemplars?query=up&start=%d&end=%d", now.Add(-48*time.Hour).Unix(), now.Unix()),
+			expectedAddlQueueDimensions: []string{ShouldQueryIngestersQueueDimension},
+		},
+		"range query accounts for the lookback of the original query": {
+			url: fmt.Sprintf(rangeURLFormat, now.Unix(), recent.Unix(), 60),
+			queryDetails: &querymiddleware.QueryDetails{
+				Start: recent,
+				MinT:  recent.Add(-24 * time.Hour),
+			},
+			expectedAddlQueueDimensions: []string{ShouldQueryIngestersAndStoreGatewayQueueDimension},
+		},
+		"instant query accounts for the lookback of the original query": {
+			url: fmt.Sprintf(instantURLFormat, now.Unix()),
+			queryDetails: &querymiddleware.QueryDetails{
+				Start: now,
+				MinT:  now.Add(-24 * time.Hour),
+			},
+			expectedAddlQueueDimensions: []string{ShouldQueryIngestersAndStoreGatewayQueueDimension},
+		},
+		"request without time params is classified by the time range of the original query": {
+			url: "/api/v1/read",
+			queryDetails: &querymiddleware.QueryDetails{
+				MinT: now.Add(-48 * time.Hour),
+				MaxT: now.Add(-24 * time.Hour),
+			},
+			expectedAddlQueueDimensions: []string{ShouldQueryStoreGatewayQueueDimension},
+		},
+		"request without time params nor query details is not classified": {
+			url:                         "/api/v1/read",
+			expectedAddlQueueDimensions: nil,
+		},
+	}
+
+	for testName, testData := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			ctx := user.InjectOrgID(context.Background(), "tenant-0")
+			if testData.queryDetails != nil {
+				var details *querymiddleware.QueryDetails
+				details, ctx = querymiddleware.ContextWithEmptyDetails(ctx)
+				*details = *testData.queryDetails
+			}
+
+			httpReq, err := http.NewRequestWithContext(ctx, "GET", testData.url, bytes.NewReader([]byte{}))
+			require.NoError(t, err)
+			httpReq.RequestURI = httpReq.URL.RequestURI()
+			httpgrpcReq, err := httpgrpc.FromHTTPRequest(httpReq)
+			require.NoError(t, err)
+
+			additionalQueueDimensions, err := adapter.extractAdditionalQueueDimensions(ctx, httpgrpcReq, now)
+			require.NoError(t, err)
+			require.Equal(t, testData.expectedAddlQueueDimensions, additionalQueueDimensions)
+		})
+	}
+}
+
 func TestQueryDecoding(t *testing.T) {
 	adapter := &frontendToSchedulerAdapter{
 		cfg:    Config{QueryStoreAfter: 12 * time.Hour},
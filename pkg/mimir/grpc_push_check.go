@@ -70,7 +70,7 @@ func (g *grpcInflightMethodLimiter) RPCCallStarting(ctx context.Context, methodN
 		httpMethod := getSingleMetadata(md, httpgrpc.MetadataMethod)
 		httpURL := getSingleMetadata(md, httpgrpc.MetadataURL)
 
-		if httpMethod == http.MethodPost && (strings.HasSuffix(httpURL, api.PrometheusPushEndpoint) || strings.HasSuffix(httpURL, api.OTLPPushEndpoint)) {
+		if httpMethod == http.MethodPost && isPushEndpoint(httpURL) {
 			dist := g.getDistributor()
 			if dist == nil {
 				return ctx, errNoDistributor
@@ -119,3 +119,13 @@ func getSingleMetadata(md metadata.MD, key string) string {
 	}
 	return val[0]
 }
+
+// isPushEndpoint returns whether the URL is one of the distributor push endpoints.
+func isPushEndpoint(url string) bool {
+	for _, endpoint := range []string{api.PrometheusPushEndpoint, api.OTLPPushEndpoint, api.InfluxPushEndpoint, api.DatadogSeriesV1PushEndpoint, api.DatadogSeriesV2PushEndpoint} {
+		if strings.HasSuffix(url, endpoint) {
+			return true
+		}
+	}
+	return false
+}
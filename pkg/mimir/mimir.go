@@ -322,6 +322,9 @@ func (c *Config) ValidateLimits(limits validation.Limits) error {
 	if err := c.Querier.ValidateLimits(limits); err != nil {
 		return errors.Wrap(err, "invalid limits config for querier")
 	}
+	if err := c.QueryScheduler.ValidateLimits(limits); err != nil {
+		return errors.Wrap(err, "invalid limits config for query-scheduler")
+	}
 	return nil
 }
 
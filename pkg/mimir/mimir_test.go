@@ -532,6 +532,16 @@ func TestConfig_ValidateLimits(t *testing.T) {
 			}(),
 			hasError: true,
 		},
+		{
+			name:       "deprioritizing the queries exceeding the estimated query cost limits without query priority weights should return error",
+			testConfig: newDefaultConfig(),
+			limitsConfig: func() validation.Limits {
+				limits := newDefaultConfig().LimitsConfig
+				limits.EstimatedQueryCostLimitAction = validation.EstimatedQueryCostLimitActionDeprioritize
+				return limits
+			}(),
+			hasError: true,
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			err := tc.testConfig.ValidateLimits(tc.limitsConfig)
@@ -903,6 +913,7 @@ overrides:
 	flagext.DefaultValues(&cfg)
 
 	cfg.Target = []string{Overrides}
+	cfg.ActivityTracker.Filepath = filepath.Join(dir, "metrics-activity.log")
 
 	cfg.RuntimeConfig.LoadPath = []string{loadPath}
 	cfg.RuntimeConfig.ReloadPeriod = 100 * time.Millisecond
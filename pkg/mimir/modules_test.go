@@ -237,6 +237,7 @@ func TestMultiKVSetup(t *testing.T) {
 			cfg.Server.HTTPListenPort = 0
 			cfg.Server.GRPCListenPort = 0
 			cfg.Target = []string{target}
+			cfg.ActivityTracker.Filepath = filepath.Join(t.TempDir(), "metrics-activity.log")
 
 			// Must be set, otherwise MultiKV config provider will not be set.
 			cfg.RuntimeConfig.LoadPath = []string{filepath.Join(dir, "config.yaml")}
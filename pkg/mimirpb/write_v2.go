@@ -0,0 +1,340 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package mimirpb
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the Prometheus Remote-Write 2.0 protobuf messages (io.prometheus.write.v2).
+const (
+	writeV2RequestSymbols    = 4
+	writeV2RequestTimeseries = 5
+
+	writeV2TimeSeriesLabelsRefs       = 1
+	writeV2TimeSeriesSamples          = 2
+	writeV2TimeSeriesHistograms       = 3
+	writeV2TimeSeriesExemplars        = 4
+	writeV2TimeSeriesMetadata         = 5
+	writeV2TimeSeriesCreatedTimestamp = 6
+
+	writeV2HistogramCustomValues = 16
+
+	writeV2ExemplarLabelsRefs = 1
+	writeV2ExemplarValue      = 2
+	writeV2ExemplarTimestamp  = 3
+
+	writeV2MetadataType    = 1
+	writeV2MetadataHelpRef = 3
+	writeV2MetadataUnitRef = 4
+)
+
+// PreallocWriteRequestV2 unmarshals a Prometheus Remote-Write 2.0 request (io.prometheus.write.v2.Request)
+// into the wrapped PreallocWriteRequest.
+//
+// Label names and values, as well as metadata help and unit, are resolved from the symbols table of the request
+// without being copied: they point into the unmarshalled buffer, like the strings unmarshalled by PreallocWriteRequest.
+// Created timestamps and native histograms with custom buckets are not supported by WriteRequest, so the requests
+// having them are rejected rather than ingested partially.
+type PreallocWriteRequestV2 struct {
+	*PreallocWriteRequest
+}
+
+// Unmarshal implements proto.Unmarshaler.
+func (p PreallocWriteRequestV2) Unmarshal(dAtA []byte) error {
+	p.Timeseries = PreallocTimeseriesSliceFromPool()
+
+	// The symbols table could follow the series in the message, so it's decoded first.
+	symbols, err := unmarshalWriteV2Symbols(dAtA)
+	if err != nil {
+		return err
+	}
+	if len(symbols) > 0 && symbols[0] != "" {
+		return fmt.Errorf("the first entry of the symbols table must be an empty string, got %q", symbols[0])
+	}
+
+	var metricFamilies map[string]struct{}
+	return rangeWriteV2Fields(dAtA, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != writeV2RequestTimeseries || typ != protowire.BytesType {
+			return nil
+		}
+
+		ts := TimeseriesFromPool()
+		// The series is added to the request before being unmarshalled, so that it's returned to the pool with the request.
+		p.Timeseries = append(p.Timeseries, PreallocTimeseries{TimeSeries: ts})
+		metadata, err := unmarshalWriteV2TimeSeries(value, symbols, ts)
+		if err != nil {
+			return err
+		}
+		if metadata == nil {
+			return nil
+		}
+
+		// Metadata is sent along with each series, while WriteRequest tracks it once per metric family.
+		metadata.MetricFamilyName = metricName(ts.Labels)
+		if metadata.MetricFamilyName == "" {
+			return nil
+		}
+		if _, ok := metricFamilies[metadata.MetricFamilyName]; ok {
+			return nil
+		}
+		if metricFamilies == nil {
+			metricFamilies = map[string]struct{}{}
+		}
+		metricFamilies[metadata.MetricFamilyName] = struct{}{}
+		p.Metadata = append(p.Metadata, metadata)
+		return nil
+	})
+}
+
+func unmarshalWriteV2Symbols(dAtA []byte) ([]string, error) {
+	var symbols []string
+	err := rangeWriteV2Fields(dAtA, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num == writeV2RequestSymbols && typ == protowire.BytesType {
+			symbols = append(symbols, yoloString(value))
+		}
+		return nil
+	})
+	return symbols, err
+}
+
+// unmarshalWriteV2TimeSeries unmarshals an io.prometheus.write.v2.TimeSeries into ts. The returned metadata is nil
+// if the series has no metadata.
+func unmarshalWriteV2TimeSeries(dAtA []byte, symbols []string, ts *TimeSeries) (*MetricMetadata, error) {
+	var metadata *MetricMetadata
+	refs := writeV2LabelsRefs{symbols: symbols, labels: ts.Labels}
+
+	err := rangeWriteV2Fields(dAtA, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch num {
+		case writeV2TimeSeriesLabelsRefs:
+			return refs.add(typ, value)
+
+		case writeV2TimeSeriesSamples:
+			if typ != protowire.BytesType {
+				return nil
+			}
+			var s Sample
+			if err := s.Unmarshal(value); err != nil {
+				return err
+			}
+			ts.Samples = append(ts.Samples, s)
+
+		case writeV2TimeSeriesHistograms:
+			// The Remote-Write 2.0 histogram has the same field numbers as Histogram, except for the custom values of
+			// the histograms with custom buckets, which Histogram doesn't have.
+			if typ != protowire.BytesType {
+				return nil
+			}
+			if err := checkWriteV2HistogramWithoutCustomValues(value); err != nil {
+				return err
+			}
+			var h Histogram
+			if err := h.Unmarshal(value); err != nil {
+				return err
+			}
+			ts.Histograms = append(ts.Histograms, h)
+
+		case writeV2TimeSeriesExemplars:
+			if typ != protowire.BytesType {
+				return nil
+			}
+			e, err := unmarshalWriteV2Exemplar(value, symbols)
+			if err != nil {
+				return err
+			}
+			ts.Exemplars = append(ts.Exemplars, e)
+
+		case writeV2TimeSeriesMetadata:
+			if typ != protowire.BytesType {
+				return nil
+			}
+			m, err := unmarshalWriteV2Metadata(value, symbols)
+			if err != nil {
+				return err
+			}
+			if m.Type != UNKNOWN || m.Help != "" || m.Unit != "" {
+				metadata = &m
+			}
+
+		case writeV2TimeSeriesCreatedTimestamp:
+			if typ != protowire.VarintType {
+				return nil
+			}
+			if v, _ := protowire.ConsumeVarint(value); v != 0 {
+				return fmt.Errorf("created timestamps are not supported")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ts.Labels, err = refs.result()
+	return metadata, err
+}
+
+// checkWriteV2HistogramWithoutCustomValues returns an error if the io.prometheus.write.v2.Histogram has custom
+// values, which would be dropped by Histogram.Unmarshal.
+func checkWriteV2HistogramWithoutCustomValues(dAtA []byte) error {
+	return rangeWriteV2Fields(dAtA, func(num protowire.Number, _ protowire.Type, _ []byte) error {
+		if num == writeV2HistogramCustomValues {
+			return fmt.Errorf("native histograms with custom buckets are not supported")
+		}
+		return nil
+	})
+}
+
+func unmarshalWriteV2Exemplar(dAtA []byte, symbols []string) (Exemplar, error) {
+	var e Exemplar
+	refs := writeV2LabelsRefs{symbols: symbols}
+
+	err := rangeWriteV2Fields(dAtA, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch {
+		case num == writeV2ExemplarLabelsRefs:
+			return refs.add(typ, value)
+		case num == writeV2ExemplarValue && typ == protowire.Fixed64Type:
+			v, _ := protowire.ConsumeFixed64(value)
+			e.Value = math.Float64frombits(v)
+		case num == writeV2ExemplarTimestamp && typ == protowire.VarintType:
+			v, _ := protowire.ConsumeVarint(value)
+			e.TimestampMs = int64(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return e, err
+	}
+
+	e.Labels, err = refs.result()
+	return e, err
+}
+
+func unmarshalWriteV2Metadata(dAtA []byte, symbols []string) (MetricMetadata, error) {
+	var m MetricMetadata
+
+	err := rangeWriteV2Fields(dAtA, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.VarintType {
+			return nil
+		}
+		v, _ := protowire.ConsumeVarint(value)
+
+		switch num {
+		case writeV2MetadataType:
+			// The Remote-Write 2.0 metric types have the same values as MetricMetadata_MetricType.
+			m.Type = MetricMetadata_MetricType(v)
+		case writeV2MetadataHelpRef:
+			s, err := writeV2Symbol(symbols, v)
+			if err != nil {
+				return err
+			}
+			m.Help = s
+		case writeV2MetadataUnitRef:
+			s, err := writeV2Symbol(symbols, v)
+			if err != nil {
+				return err
+			}
+			m.Unit = s
+		}
+		return nil
+	})
+	return m, err
+}
+
+// writeV2LabelsRefs resolves the pairs of label name and value references of a series or an exemplar.
+type writeV2LabelsRefs struct {
+	symbols []string
+	labels  []LabelAdapter
+
+	// name is the last label name, waiting for the reference of its value.
+	name    string
+	hasName bool
+}
+
+func (r *writeV2LabelsRefs) add(typ protowire.Type, value []byte) error {
+	switch typ {
+	case protowire.VarintType:
+		v, _ := protowire.ConsumeVarint(value)
+		return r.addRef(v)
+	case protowire.BytesType:
+		for len(value) > 0 {
+			v, n := protowire.ConsumeVarint(value)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			value = value[n:]
+			if err := r.addRef(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *writeV2LabelsRefs) addRef(ref uint64) error {
+	s, err := writeV2Symbol(r.symbols, ref)
+	if err != nil {
+		return err
+	}
+	if !r.hasName {
+		r.name, r.hasName = s, true
+		return nil
+	}
+	r.labels = append(r.labels, LabelAdapter{Name: r.name, Value: s})
+	r.name, r.hasName = "", false
+	return nil
+}
+
+func (r *writeV2LabelsRefs) result() ([]LabelAdapter, error) {
+	if r.hasName {
+		return r.labels, fmt.Errorf("odd number of labels references")
+	}
+	return r.labels, nil
+}
+
+func writeV2Symbol(symbols []string, ref uint64) (string, error) {
+	if ref >= uint64(len(symbols)) {
+		return "", fmt.Errorf("symbol reference %d out of range: the symbols table has %d entries", ref, len(symbols))
+	}
+	return symbols[ref], nil
+}
+
+// rangeWriteV2Fields calls f for each field of the protobuf message. The value passed to f is the content of
+// length-delimited fields, and the encoded value of the other fields.
+func rangeWriteV2Fields(dAtA []byte, f func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(dAtA) > 0 {
+		num, typ, n := protowire.ConsumeTag(dAtA)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		dAtA = dAtA[n:]
+
+		n = protowire.ConsumeFieldValue(num, typ, dAtA)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		value := dAtA[:n]
+		dAtA = dAtA[n:]
+
+		if typ == protowire.BytesType {
+			value, _ = protowire.ConsumeBytes(value)
+		}
+		if err := f(num, typ, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func metricName(lbls []LabelAdapter) string {
+	for _, l := range lbls {
+		if l.Name == labels.MetricName {
+			return l.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,225 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package mimirpb
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// writeV2Series is the content of an io.prometheus.write.v2.TimeSeries used to build test requests.
+type writeV2Series struct {
+	labelsRefs       []uint64
+	samples          []Sample
+	histograms       []Histogram
+	exemplars        []writeV2Exemplar
+	metadata         *writeV2Metadata
+	createdTimestamp int64
+
+	// histogramsCustomValues are added to each histogram, as the custom values of native histograms with custom buckets.
+	histogramsCustomValues []float64
+}
+
+type writeV2Exemplar struct {
+	labelsRefs []uint64
+	value      float64
+	timestamp  int64
+}
+
+type writeV2Metadata struct {
+	typ              MetricMetadata_MetricType
+	helpRef, unitRef uint64
+}
+
+func marshalWriteV2Request(t *testing.T, symbols []string, series []writeV2Series) []byte {
+	appendRefs := func(b []byte, num protowire.Number, refs []uint64) []byte {
+		var packed []byte
+		for _, ref := range refs {
+			packed = protowire.AppendVarint(packed, ref)
+		}
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendBytes(b, packed)
+	}
+	appendMessage := func(b []byte, num protowire.Number, m []byte) []byte {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendBytes(b, m)
+	}
+
+	var req []byte
+	// The series are encoded before the symbols table, to check the order doesn't matter.
+	for _, s := range series {
+		var ts []byte
+		ts = appendRefs(ts, writeV2TimeSeriesLabelsRefs, s.labelsRefs)
+		for _, sample := range s.samples {
+			m, err := sample.Marshal()
+			require.NoError(t, err)
+			ts = appendMessage(ts, writeV2TimeSeriesSamples, m)
+		}
+		for _, h := range s.histograms {
+			m, err := h.Marshal()
+			require.NoError(t, err)
+			if len(s.histogramsCustomValues) > 0 {
+				var packed []byte
+				for _, v := range s.histogramsCustomValues {
+					packed = protowire.AppendFixed64(packed, math.Float64bits(v))
+				}
+				m = appendMessage(m, writeV2HistogramCustomValues, packed)
+			}
+			ts = appendMessage(ts, writeV2TimeSeriesHistograms, m)
+		}
+		for _, e := range s.exemplars {
+			var m []byte
+			m = appendRefs(m, writeV2ExemplarLabelsRefs, e.labelsRefs)
+			m = protowire.AppendTag(m, writeV2ExemplarValue, protowire.Fixed64Type)
+			m = protowire.AppendFixed64(m, math.Float64bits(e.value))
+			m = protowire.AppendTag(m, writeV2ExemplarTimestamp, protowire.VarintType)
+			m = protowire.AppendVarint(m, uint64(e.timestamp))
+			ts = appendMessage(ts, writeV2TimeSeriesExemplars, m)
+		}
+		if s.metadata != nil {
+			var m []byte
+			m = protowire.AppendTag(m, writeV2MetadataType, protowire.VarintType)
+			m = protowire.AppendVarint(m, uint64(s.metadata.typ))
+			m = protowire.AppendTag(m, writeV2MetadataHelpRef, protowire.VarintType)
+			m = protowire.AppendVarint(m, s.metadata.helpRef)
+			m = protowire.AppendTag(m, writeV2MetadataUnitRef, protowire.VarintType)
+			m = protowire.AppendVarint(m, s.metadata.unitRef)
+			ts = appendMessage(ts, writeV2TimeSeriesMetadata, m)
+		}
+		if s.createdTimestamp != 0 {
+			ts = protowire.AppendTag(ts, writeV2TimeSeriesCreatedTimestamp, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(s.createdTimestamp))
+		}
+		req = appendMessage(req, writeV2RequestTimeseries, ts)
+	}
+	for _, s := range symbols {
+		req = appendMessage(req, writeV2RequestSymbols, []byte(s))
+	}
+	return req
+}
+
+func TestPreallocWriteRequestV2_Unmarshal(t *testing.T) {
+	symbols := []string{"", "__name__", "http_requests_total", "job", "api", "instance", "a", "b", "trace_id", "1234", "Total requests.", "requests"}
+
+	histogram := Histogram{
+		Count:          &Histogram_CountInt{CountInt: 3},
+		Sum:            10,
+		Schema:         1,
+		ZeroThreshold:  0.001,
+		ZeroCount:      &Histogram_ZeroCountInt{ZeroCountInt: 1},
+		PositiveSpans:  []BucketSpan{{Offset: 0, Length: 2}},
+		PositiveDeltas: []int64{1, 0},
+		Timestamp:      3000,
+	}
+
+	data := marshalWriteV2Request(t, symbols, []writeV2Series{
+		{
+			labelsRefs: []uint64{1, 2, 3, 4, 5, 6},
+			samples:    []Sample{{TimestampMs: 1000, Value: 1}, {TimestampMs: 2000, Value: 2}},
+			exemplars:  []writeV2Exemplar{{labelsRefs: []uint64{8, 9}, value: 1.5, timestamp: 1500}},
+			metadata:   &writeV2Metadata{typ: COUNTER, helpRef: 10, unitRef: 11},
+		},
+		{
+			labelsRefs: []uint64{1, 2, 3, 4, 5, 7},
+			histograms: []Histogram{histogram},
+			metadata:   &writeV2Metadata{typ: COUNTER, helpRef: 10, unitRef: 11},
+		},
+	})
+
+	req := &PreallocWriteRequest{}
+	require.NoError(t, PreallocWriteRequestV2{PreallocWriteRequest: req}.Unmarshal(data))
+	t.Cleanup(func() { ReuseSlice(req.Timeseries) })
+
+	require.Len(t, req.Timeseries, 2)
+	assert.Equal(t, []LabelAdapter{{Name: "__name__", Value: "http_requests_total"}, {Name: "job", Value: "api"}, {Name: "instance", Value: "a"}}, req.Timeseries[0].Labels)
+	assert.Equal(t, []Sample{{TimestampMs: 1000, Value: 1}, {TimestampMs: 2000, Value: 2}}, req.Timeseries[0].Samples)
+	assert.Equal(t, []Exemplar{{Labels: []LabelAdapter{{Name: "trace_id", Value: "1234"}}, Value: 1.5, TimestampMs: 1500}}, req.Timeseries[0].Exemplars)
+	assert.Empty(t, req.Timeseries[0].Histograms)
+
+	assert.Equal(t, []LabelAdapter{{Name: "__name__", Value: "http_requests_total"}, {Name: "job", Value: "api"}, {Name: "instance", Value: "b"}}, req.Timeseries[1].Labels)
+	assert.Empty(t, req.Timeseries[1].Samples)
+	require.Len(t, req.Timeseries[1].Histograms, 1)
+	assert.True(t, histogram.Equal(req.Timeseries[1].Histograms[0]))
+
+	// The metadata is tracked once per metric family.
+	assert.Equal(t, []*MetricMetadata{{Type: COUNTER, MetricFamilyName: "http_requests_total", Help: "Total requests.", Unit: "requests"}}, req.Metadata)
+}
+
+func TestPreallocWriteRequestV2_Unmarshal_InvalidReferences(t *testing.T) {
+	symbols := []string{"", "__name__", "up"}
+
+	testCases := map[string]writeV2Series{
+		"label reference out of range": {
+			labelsRefs: []uint64{1, 3},
+		},
+		"odd number of label references": {
+			labelsRefs: []uint64{1, 2, 1},
+		},
+		"exemplar label reference out of range": {
+			labelsRefs: []uint64{1, 2},
+			exemplars:  []writeV2Exemplar{{labelsRefs: []uint64{1, 10}}},
+		},
+		"metadata help reference out of range": {
+			labelsRefs: []uint64{1, 2},
+			metadata:   &writeV2Metadata{typ: GAUGE, helpRef: 10},
+		},
+	}
+
+	for name, series := range testCases {
+		t.Run(name, func(t *testing.T) {
+			data := marshalWriteV2Request(t, symbols, []writeV2Series{series})
+
+			req := &PreallocWriteRequest{}
+			err := PreallocWriteRequestV2{PreallocWriteRequest: req}.Unmarshal(data)
+			require.Error(t, err)
+			ReuseSlice(req.Timeseries)
+		})
+	}
+}
+
+func TestPreallocWriteRequestV2_Unmarshal_UnsupportedRequests(t *testing.T) {
+	histogram := Histogram{
+		Count:          &Histogram_CountInt{CountInt: 1},
+		Schema:         -53,
+		PositiveSpans:  []BucketSpan{{Offset: 0, Length: 1}},
+		PositiveDeltas: []int64{1},
+		Timestamp:      1000,
+	}
+
+	testCases := map[string]struct {
+		symbols     []string
+		series      writeV2Series
+		expectedErr string
+	}{
+		"first symbol not empty": {
+			symbols:     []string{"__name__", "up"},
+			series:      writeV2Series{labelsRefs: []uint64{0, 1}, samples: []Sample{{TimestampMs: 1000, Value: 1}}},
+			expectedErr: `the first entry of the symbols table must be an empty string, got "__name__"`,
+		},
+		"created timestamp": {
+			symbols:     []string{"", "__name__", "up"},
+			series:      writeV2Series{labelsRefs: []uint64{1, 2}, samples: []Sample{{TimestampMs: 1000, Value: 1}}, createdTimestamp: 500},
+			expectedErr: "created timestamps are not supported",
+		},
+		"native histogram with custom buckets": {
+			symbols:     []string{"", "__name__", "up"},
+			series:      writeV2Series{labelsRefs: []uint64{1, 2}, histograms: []Histogram{histogram}, histogramsCustomValues: []float64{0.1, 1}},
+			expectedErr: "native histograms with custom buckets are not supported",
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			data := marshalWriteV2Request(t, testCase.symbols, []writeV2Series{testCase.series})
+
+			req := &PreallocWriteRequest{}
+			err := PreallocWriteRequestV2{PreallocWriteRequest: req}.Unmarshal(data)
+			require.EqualError(t, err, testCase.expectedErr)
+			ReuseSlice(req.Timeseries)
+		})
+	}
+}
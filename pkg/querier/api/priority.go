@@ -0,0 +1,30 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"context"
+)
+
+// QueryPriorityHeader is the header carrying the priority class of a query, used by the query-scheduler
+// to dequeue the queries of a tenant with weighted fairness.
+const QueryPriorityHeader = "X-Query-Priority"
+
+// DeprioritizedQueryPriority is the priority class of the queries deprioritized by the query-frontend because they
+// exceed the estimated query cost limits of the tenant.
+const DeprioritizedQueryPriority = "low"
+
+const priorityContextKey contextKey = 2
+
+// ContextWithQueryPriority returns a new context with the given query priority.
+// The query priority can be retrieved with QueryPriorityFromContext.
+func ContextWithQueryPriority(parent context.Context, priority string) context.Context {
+	return context.WithValue(parent, priorityContextKey, priority)
+}
+
+// QueryPriorityFromContext returns the query priority from the context if set via ContextWithQueryPriority.
+// The second return value is true if a non-empty query priority was found in the context.
+func QueryPriorityFromContext(ctx context.Context) (string, bool) {
+	priority, _ := ctx.Value(priorityContextKey).(string)
+	return priority, priority != ""
+}
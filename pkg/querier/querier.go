@@ -163,7 +163,8 @@ func New(cfg Config, limits *validation.Overrides, distributor Distributor, stor
 	case standardPromQLEngine:
 		eng = promql.NewEngine(opts)
 	case streamingPromQLEngine:
-		streamingEngine, err := streamingpromql.NewEngine(opts)
+		limitsProvider := &tenantQueryLimitsProvider{limits: limits}
+		streamingEngine, err := streamingpromql.NewEngine(opts, limitsProvider, queryMetrics)
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -181,6 +182,21 @@ func New(cfg Config, limits *validation.Overrides, distributor Distributor, stor
 	return NewSampleAndChunkQueryable(lazyQueryable), exemplarQueryable, eng, nil
 }
 
+// tenantQueryLimitsProvider provides the limits for queries executed by the streaming PromQL engine from the
+// overrides of the tenants the query is for.
+type tenantQueryLimitsProvider struct {
+	limits *validation.Overrides
+}
+
+func (p *tenantQueryLimitsProvider) GetMaxEstimatedMemoryConsumptionPerQuery(ctx context.Context) (uint64, error) {
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return validation.SmallestPositiveNonZeroUint64PerTenant(tenantIDs, p.limits.MaxEstimatedMemoryConsumptionPerQuery), nil
+}
+
 // NewSampleAndChunkQueryable creates a SampleAndChunkQueryable from a Queryable.
 func NewSampleAndChunkQueryable(q storage.Queryable) storage.SampleAndChunkQueryable {
 	return &sampleAndChunkQueryable{q}
@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package querier
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/grafana/mimir/pkg/streamingpromql"
+	"github.com/grafana/mimir/pkg/util"
+)
+
+type queryPlanSuccessResult struct {
+	Status string        `json:"status"`
+	Data   queryPlanData `json:"data"`
+}
+
+type queryPlanData struct {
+	*streamingpromql.QueryPlan
+
+	// Text is the plan rendered in a human-readable format.
+	Text string `json:"text"`
+}
+
+type queryPlanErrorResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+// QueryPlanHandler creates a http.Handler that returns the operators the streaming PromQL engine would use to evaluate
+// a query, or the reason the query is not supported by the streaming engine, without evaluating the query.
+//
+// It accepts the same parameters as the instant query endpoint (query and time) or, if step is given, the range
+// query endpoint (query, start, end and step).
+func QueryPlanHandler(engine promql.QueryEngine) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		explainer, ok := engine.(streamingpromql.Explainer)
+		if !ok {
+			writeQueryPlanError(w, http.StatusNotImplemented, "query plans are only available when the streaming PromQL engine is enabled")
+			return
+		}
+
+		qs := r.FormValue("query")
+		if qs == "" {
+			writeQueryPlanError(w, http.StatusBadRequest, "query parameter is required")
+			return
+		}
+
+		var plan *streamingpromql.QueryPlan
+
+		if r.FormValue("step") == "" {
+			ts, err := util.ParseTimeParam(r, "time", util.TimeToMillis(time.Now()))
+			if err != nil {
+				writeQueryPlanError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			plan, err = explainer.ExplainInstantQuery(r.Context(), qs, util.TimeFromMillis(ts))
+			if err != nil {
+				writeQueryPlanError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		} else {
+			start, err := util.ParseTime(r.FormValue("start"))
+			if err != nil {
+				writeQueryPlanError(w, http.StatusBadRequest, fmt.Sprintf("invalid time value for 'start': %v", err))
+				return
+			}
+
+			end, err := util.ParseTime(r.FormValue("end"))
+			if err != nil {
+				writeQueryPlanError(w, http.StatusBadRequest, fmt.Sprintf("invalid time value for 'end': %v", err))
+				return
+			}
+
+			step, err := parseQueryPlanStep(r.FormValue("step"))
+			if err != nil {
+				writeQueryPlanError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			plan, err = explainer.ExplainRangeQuery(r.Context(), qs, util.TimeFromMillis(start), util.TimeFromMillis(end), step)
+			if err != nil {
+				writeQueryPlanError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		util.WriteJSONResponse(w, queryPlanSuccessResult{
+			Status: statusSuccess,
+			Data:   queryPlanData{QueryPlan: plan, Text: plan.String()},
+		})
+	})
+}
+
+// parseQueryPlanStep parses the step of a range query, given either as a number of seconds or as a Prometheus duration.
+func parseQueryPlanStep(s string) (time.Duration, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		step := f * float64(time.Second)
+		if step <= 0 || step > math.MaxInt64 || math.IsNaN(step) {
+			return 0, fmt.Errorf("invalid value for 'step': %q", s)
+		}
+
+		return time.Duration(step), nil
+	}
+
+	if d, err := model.ParseDuration(s); err == nil && d > 0 {
+		return time.Duration(d), nil
+	}
+
+	return 0, fmt.Errorf("invalid value for 'step': %q", s)
+}
+
+func writeQueryPlanError(w http.ResponseWriter, statusCode int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	util.WriteJSONResponse(w, queryPlanErrorResult{Status: statusError, Error: msg})
+}
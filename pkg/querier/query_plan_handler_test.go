@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package querier
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/prometheus/prometheus/promql"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir/pkg/querier/stats"
+	"github.com/grafana/mimir/pkg/streamingpromql"
+)
+
+func TestQueryPlanHandler(t *testing.T) {
+	streamingEngine, err := streamingpromql.NewEngine(streamingpromql.NewTestEngineOpts(), streamingpromql.NewStaticQueryLimitsProvider(0), stats.NewQueryMetrics(nil))
+	require.NoError(t, err)
+
+	testCases := map[string]struct {
+		engine         promql.QueryEngine
+		queryParams    url.Values
+		expectedStatus int
+		expectedJSON   string
+	}{
+		"instant query": {
+			engine:         streamingEngine,
+			queryParams:    url.Values{"query": {"some_metric"}, "time": {"60"}},
+			expectedStatus: http.StatusOK,
+			expectedJSON: `{
+				"status": "success",
+				"data": {
+					"query": "some_metric",
+					"supported": true,
+					"root": {
+						"type": "InstantVectorSelector",
+						"matchers": ["__name__=\"some_metric\""],
+						"timeRange": {"start": "1970-01-01T00:01:00Z", "end": "1970-01-01T00:01:00Z"}
+					},
+					"text": "- InstantVectorSelector: matchers={__name__=\"some_metric\"}, at 1970-01-01T00:01:00Z\n"
+				}
+			}`,
+		},
+		"range query": {
+			engine:         streamingEngine,
+			queryParams:    url.Values{"query": {"some_metric"}, "start": {"0"}, "end": {"1970-01-01T00:05:00Z"}, "step": {"1m"}},
+			expectedStatus: http.StatusOK,
+			expectedJSON: `{
+				"status": "success",
+				"data": {
+					"query": "some_metric",
+					"supported": true,
+					"root": {
+						"type": "InstantVectorSelector",
+						"matchers": ["__name__=\"some_metric\""],
+						"timeRange": {"start": "1970-01-01T00:00:00Z", "end": "1970-01-01T00:05:00Z", "interval": "1m"}
+					},
+					"text": "- InstantVectorSelector: matchers={__name__=\"some_metric\"}, from 1970-01-01T00:00:00Z to 1970-01-01T00:05:00Z every 1m\n"
+				}
+			}`,
+		},
+		"unsupported query": {
+			engine:         streamingEngine,
+			queryParams:    url.Values{"query": {"histogram_stddev(some_metric)"}, "time": {"60"}},
+			expectedStatus: http.StatusOK,
+			expectedJSON: `{
+				"status": "success",
+				"data": {
+					"query": "histogram_stddev(some_metric)",
+					"supported": false,
+					"notSupportedReason": "'histogram_stddev' function",
+					"text": "not supported by streaming engine: 'histogram_stddev' function\n"
+				}
+			}`,
+		},
+		"invalid query": {
+			engine:         streamingEngine,
+			queryParams:    url.Values{"query": {"sum("}},
+			expectedStatus: http.StatusBadRequest,
+			expectedJSON:   `{"status": "error", "error": "1:5: parse error: unclosed left parenthesis"}`,
+		},
+		"missing query": {
+			engine:         streamingEngine,
+			queryParams:    url.Values{},
+			expectedStatus: http.StatusBadRequest,
+			expectedJSON:   `{"status": "error", "error": "query parameter is required"}`,
+		},
+		"invalid step": {
+			engine:         streamingEngine,
+			queryParams:    url.Values{"query": {"some_metric"}, "start": {"0"}, "end": {"300"}, "step": {"-1"}},
+			expectedStatus: http.StatusBadRequest,
+			expectedJSON:   `{"status": "error", "error": "invalid value for 'step': \"-1\""}`,
+		},
+		"Prometheus' engine": {
+			engine:         promql.NewEngine(promql.EngineOpts{}),
+			queryParams:    url.Values{"query": {"some_metric"}},
+			expectedStatus: http.StatusNotImplemented,
+			expectedJSON:   `{"status": "error", "error": "query plans are only available when the streaming PromQL engine is enabled"}`,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			request, err := http.NewRequest(http.MethodGet, "/api/v1/query_plan?"+testCase.queryParams.Encode(), nil)
+			require.NoError(t, err)
+
+			recorder := httptest.NewRecorder()
+			QueryPlanHandler(testCase.engine).ServeHTTP(recorder, request)
+
+			require.Equal(t, testCase.expectedStatus, recorder.Result().StatusCode)
+			require.Equal(t, "application/json", recorder.Result().Header.Get("Content-Type"))
+
+			responseBody, err := io.ReadAll(recorder.Result().Body)
+			require.NoError(t, err)
+			require.JSONEq(t, testCase.expectedJSON, string(responseBody))
+		})
+	}
+}
@@ -11,14 +11,15 @@ import (
 )
 
 const (
-	RejectReasonMaxSeries          = "max-fetched-series-per-query"
-	RejectReasonMaxChunkBytes      = "max-fetched-chunk-bytes-per-query"
-	RejectReasonMaxChunks          = "max-fetched-chunks-per-query"
-	RejectReasonMaxEstimatedChunks = "max-estimated-fetched-chunks-per-query"
+	RejectReasonMaxSeries                          = "max-fetched-series-per-query"
+	RejectReasonMaxChunkBytes                      = "max-fetched-chunk-bytes-per-query"
+	RejectReasonMaxChunks                          = "max-fetched-chunks-per-query"
+	RejectReasonMaxEstimatedChunks                 = "max-estimated-fetched-chunks-per-query"
+	RejectReasonMaxEstimatedQueryMemoryConsumption = "max-estimated-memory-consumption-per-query"
 )
 
 var (
-	rejectReasons = []string{RejectReasonMaxSeries, RejectReasonMaxChunkBytes, RejectReasonMaxChunks, RejectReasonMaxEstimatedChunks}
+	rejectReasons = []string{RejectReasonMaxSeries, RejectReasonMaxChunkBytes, RejectReasonMaxChunks, RejectReasonMaxEstimatedChunks, RejectReasonMaxEstimatedQueryMemoryConsumption}
 )
 
 // QueryMetrics collects metrics on the number of chunks used while serving queries.
@@ -6,9 +6,13 @@
 package stats
 
 import (
+	"cmp"
 	"context"
+	"slices"
+	"sync"
 	"sync/atomic" //lint:ignore faillint we can't use go.uber.org/atomic with a protobuf struct without wrapping it.
 	"time"
+	"unsafe"
 
 	"github.com/grafana/dskit/httpgrpc"
 )
@@ -17,6 +21,20 @@ type contextKey int
 
 var ctxKey = contextKey(0)
 
+// MaxMergedOperatorStats is the maximum number of operator statistics kept when merging Stats. Only the operators
+// which took the longest to evaluate are kept.
+const MaxMergedOperatorStats = 10
+
+// operatorStatsMtxs guard OperatorStats, as Stats is a protobuf message and so cannot hold its own mutex. Each Stats
+// uses the mutex picked by its address, so that concurrent queries don't contend on the same mutex.
+var operatorStatsMtxs [256]sync.Mutex
+
+func (s *Stats) operatorStatsMtx() *sync.Mutex {
+	// Fibonacci hashing spreads the addresses, which are aligned, across all the mutexes.
+	h := uint64(uintptr(unsafe.Pointer(s))) * 0x9E3779B97F4A7C15
+	return &operatorStatsMtxs[h>>56]
+}
+
 // ContextWithEmptyStats returns a context with empty stats.
 func ContextWithEmptyStats(ctx context.Context) (*Stats, context.Context) {
 	stats := &Stats{}
@@ -187,6 +205,84 @@ func (s *Stats) LoadQueueTime() time.Duration {
 	return time.Duration(atomic.LoadInt64((*int64)(&s.QueueTime)))
 }
 
+// UpdateEstimatedPeakMemoryConsumption records b as the estimated peak memory consumption if it is greater than the
+// currently recorded value.
+func (s *Stats) UpdateEstimatedPeakMemoryConsumption(b uint64) {
+	if s == nil {
+		return
+	}
+
+	for {
+		current := atomic.LoadUint64(&s.EstimatedPeakMemoryConsumptionBytes)
+		if b <= current || atomic.CompareAndSwapUint64(&s.EstimatedPeakMemoryConsumptionBytes, current, b) {
+			return
+		}
+	}
+}
+
+func (s *Stats) LoadEstimatedPeakMemoryConsumption() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&s.EstimatedPeakMemoryConsumptionBytes)
+}
+
+// AddOperatorStats records the statistics for each operator of a query evaluated by the streaming PromQL engine.
+func (s *Stats) AddOperatorStats(operators ...OperatorStats) {
+	if s == nil || len(operators) == 0 {
+		return
+	}
+
+	mtx := s.operatorStatsMtx()
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	s.OperatorStats = append(s.OperatorStats, operators...)
+}
+
+// mergeOperatorStats adds the statistics of the operators to the ones already recorded, keeping only the
+// MaxMergedOperatorStats operators which took the longest to evaluate.
+func (s *Stats) mergeOperatorStats(operators []OperatorStats) {
+	if len(operators) == 0 {
+		return
+	}
+
+	mtx := s.operatorStatsMtx()
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	s.OperatorStats = append(s.OperatorStats, operators...)
+	if len(s.OperatorStats) <= MaxMergedOperatorStats {
+		return
+	}
+
+	slices.SortStableFunc(s.OperatorStats, func(a, b OperatorStats) int {
+		return cmp.Compare(b.WallTime, a.WallTime)
+	})
+	clear(s.OperatorStats[MaxMergedOperatorStats:])
+	s.OperatorStats = s.OperatorStats[:MaxMergedOperatorStats]
+}
+
+// LoadOperatorStats returns a copy of the operator statistics recorded with AddOperatorStats.
+func (s *Stats) LoadOperatorStats() []OperatorStats {
+	if s == nil {
+		return nil
+	}
+
+	mtx := s.operatorStatsMtx()
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	if len(s.OperatorStats) == 0 {
+		return nil
+	}
+
+	operators := make([]OperatorStats, len(s.OperatorStats))
+	copy(operators, s.OperatorStats)
+	return operators
+}
+
 // Merge the provided Stats into this one.
 func (s *Stats) Merge(other *Stats) {
 	if s == nil || other == nil {
@@ -202,6 +298,8 @@ func (s *Stats) Merge(other *Stats) {
 	s.AddFetchedIndexBytes(other.LoadFetchedIndexBytes())
 	s.AddEstimatedSeriesCount(other.LoadEstimatedSeriesCount())
 	s.AddQueueTime(other.LoadQueueTime())
+	s.UpdateEstimatedPeakMemoryConsumption(other.LoadEstimatedPeakMemoryConsumption())
+	s.mergeOperatorStats(other.LoadOperatorStats())
 }
 
 func ShouldTrackHTTPGRPCResponse(r *httpgrpc.HTTPResponse) bool {
@@ -48,6 +48,13 @@ type Stats struct {
 	EstimatedSeriesCount uint64 `protobuf:"varint,8,opt,name=estimated_series_count,json=estimatedSeriesCount,proto3" json:"estimated_series_count,omitempty"`
 	// The sum of durations that the query spent in the queue, before it was handled by querier.
 	QueueTime time.Duration `protobuf:"bytes,9,opt,name=queue_time,json=queueTime,proto3,stdduration" json:"queue_time"`
+	// The estimated peak memory consumption of the query, in bytes, as tracked by the streaming PromQL engine.
+	// For queries executed as multiple partial queries, this is the largest peak of any partial query.
+	EstimatedPeakMemoryConsumptionBytes uint64 `protobuf:"varint,10,opt,name=estimated_peak_memory_consumption_bytes,json=estimatedPeakMemoryConsumptionBytes,proto3" json:"estimated_peak_memory_consumption_bytes,omitempty"`
+	// Statistics for each operator used by the streaming PromQL engine to evaluate the query, in the order the operators
+	// appear in the query plan. For queries executed as multiple partial queries, this contains the operators of every
+	// partial query.
+	OperatorStats []OperatorStats `protobuf:"bytes,11,rep,name=operator_stats,json=operatorStats,proto3" json:"operator_stats"`
 }
 
 func (m *Stats) Reset()      { *m = Stats{} }
@@ -145,38 +152,165 @@ func (m *Stats) GetQueueTime() time.Duration {
 	return 0
 }
 
+func (m *Stats) GetEstimatedPeakMemoryConsumptionBytes() uint64 {
+	if m != nil {
+		return m.EstimatedPeakMemoryConsumptionBytes
+	}
+	return 0
+}
+
+func (m *Stats) GetOperatorStats() []OperatorStats {
+	if m != nil {
+		return m.OperatorStats
+	}
+	return nil
+}
+
+type OperatorStats struct {
+	// The operator's description, as shown in the query plan.
+	Operator string `protobuf:"bytes,1,opt,name=operator,proto3" json:"operator,omitempty"`
+	// The depth of the operator in the query plan. The root operator has depth 0.
+	Depth uint32 `protobuf:"varint,2,opt,name=depth,proto3" json:"depth,omitempty"`
+	// The number of series received from the operator's children.
+	SeriesIn uint64 `protobuf:"varint,3,opt,name=series_in,json=seriesIn,proto3" json:"series_in,omitempty"`
+	// The number of series produced by the operator.
+	SeriesOut uint64 `protobuf:"varint,4,opt,name=series_out,json=seriesOut,proto3" json:"series_out,omitempty"`
+	// The number of samples produced by the operator. Samples produced by range vector operators are counted once for
+	// each step they are selected at.
+	SamplesProcessed uint64 `protobuf:"varint,5,opt,name=samples_processed,json=samplesProcessed,proto3" json:"samples_processed,omitempty"`
+	// The time spent in the operator's SeriesMetadata and Next methods (or their equivalents), excluding time spent in
+	// the operator's children.
+	WallTime time.Duration `protobuf:"bytes,6,opt,name=wall_time,json=wallTime,proto3,stdduration" json:"wall_time"`
+	// The largest number of points the operator held between calls to its Next method.
+	PeakBufferedPoints uint64 `protobuf:"varint,7,opt,name=peak_buffered_points,json=peakBufferedPoints,proto3" json:"peak_buffered_points,omitempty"`
+}
+
+func (m *OperatorStats) Reset()      { *m = OperatorStats{} }
+func (*OperatorStats) ProtoMessage() {}
+func (*OperatorStats) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b4756a0aec8b9d44, []int{1}
+}
+func (m *OperatorStats) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *OperatorStats) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_OperatorStats.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *OperatorStats) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_OperatorStats.Merge(m, src)
+}
+func (m *OperatorStats) XXX_Size() int {
+	return m.Size()
+}
+func (m *OperatorStats) XXX_DiscardUnknown() {
+	xxx_messageInfo_OperatorStats.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_OperatorStats proto.InternalMessageInfo
+
+func (m *OperatorStats) GetOperator() string {
+	if m != nil {
+		return m.Operator
+	}
+	return ""
+}
+
+func (m *OperatorStats) GetDepth() uint32 {
+	if m != nil {
+		return m.Depth
+	}
+	return 0
+}
+
+func (m *OperatorStats) GetSeriesIn() uint64 {
+	if m != nil {
+		return m.SeriesIn
+	}
+	return 0
+}
+
+func (m *OperatorStats) GetSeriesOut() uint64 {
+	if m != nil {
+		return m.SeriesOut
+	}
+	return 0
+}
+
+func (m *OperatorStats) GetSamplesProcessed() uint64 {
+	if m != nil {
+		return m.SamplesProcessed
+	}
+	return 0
+}
+
+func (m *OperatorStats) GetWallTime() time.Duration {
+	if m != nil {
+		return m.WallTime
+	}
+	return 0
+}
+
+func (m *OperatorStats) GetPeakBufferedPoints() uint64 {
+	if m != nil {
+		return m.PeakBufferedPoints
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*Stats)(nil), "stats.Stats")
+	proto.RegisterType((*OperatorStats)(nil), "stats.OperatorStats")
 }
 
 func init() { proto.RegisterFile("stats.proto", fileDescriptor_b4756a0aec8b9d44) }
 
 var fileDescriptor_b4756a0aec8b9d44 = []byte{
-	// 381 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x92, 0xbf, 0x4e, 0xfa, 0x50,
-	0x14, 0xc7, 0x7b, 0x7f, 0xfc, 0xf9, 0xc1, 0x45, 0x34, 0x56, 0x62, 0x2a, 0xc3, 0x81, 0xe8, 0x20,
-	0x53, 0x31, 0xea, 0xe6, 0x62, 0x8a, 0x8b, 0xa3, 0xe0, 0xe4, 0xd2, 0x14, 0x7a, 0x29, 0x8d, 0xa5,
-	0x17, 0x7a, 0x6f, 0xa3, 0x6e, 0x3e, 0x82, 0xa3, 0x8f, 0xe0, 0xa3, 0x30, 0xb2, 0x98, 0x30, 0xa9,
-	0x94, 0xc5, 0x91, 0x47, 0x30, 0xf7, 0xb6, 0x25, 0xe0, 0xe4, 0xd6, 0x7b, 0x3e, 0xe7, 0x93, 0xef,
-	0xc9, 0x39, 0xc5, 0x25, 0xc6, 0x2d, 0xce, 0xf4, 0x51, 0x40, 0x39, 0x55, 0x73, 0xf2, 0x51, 0xad,
-	0x38, 0xd4, 0xa1, 0xb2, 0xd2, 0x14, 0x5f, 0x31, 0xac, 0x82, 0x43, 0xa9, 0xe3, 0x91, 0xa6, 0x7c,
-	0x75, 0xc3, 0x7e, 0xd3, 0x0e, 0x03, 0x8b, 0xbb, 0xd4, 0x8f, 0xf9, 0xe1, 0x7b, 0x06, 0xe7, 0x3a,
-	0xc2, 0x57, 0x2f, 0x71, 0xf1, 0xc1, 0xf2, 0x3c, 0x93, 0xbb, 0x43, 0xa2, 0xa1, 0x3a, 0x6a, 0x94,
-	0x4e, 0x0f, 0xf4, 0xd8, 0xd6, 0x53, 0x5b, 0xbf, 0x4a, 0x6c, 0xa3, 0x30, 0xf9, 0xa8, 0x29, 0xaf,
-	0x9f, 0x35, 0xd4, 0x2e, 0x08, 0xeb, 0xd6, 0x1d, 0x12, 0xf5, 0x04, 0x57, 0xfa, 0x84, 0xf7, 0x06,
-	0xc4, 0x36, 0x19, 0x09, 0x5c, 0xc2, 0xcc, 0x1e, 0x0d, 0x7d, 0xae, 0xfd, 0xab, 0xa3, 0x46, 0xb6,
-	0xad, 0x26, 0xac, 0x23, 0x51, 0x4b, 0x10, 0x55, 0xc7, 0x7b, 0xa9, 0xd1, 0x1b, 0x84, 0xfe, 0xbd,
-	0xd9, 0x7d, 0xe2, 0x84, 0x69, 0x19, 0x29, 0xec, 0x26, 0xa8, 0x25, 0x88, 0x21, 0xc0, 0x7a, 0x82,
-	0xec, 0x4f, 0x13, 0xb2, 0x1b, 0x09, 0x52, 0x48, 0x12, 0x8e, 0xf1, 0x0e, 0x1b, 0x58, 0x81, 0x4d,
-	0x6c, 0x73, 0x1c, 0xca, 0x64, 0x2d, 0x57, 0x47, 0x8d, 0x72, 0x7b, 0x3b, 0x29, 0xdf, 0xc4, 0x55,
-	0xf5, 0x08, 0x97, 0xd9, 0xc8, 0x73, 0xf9, 0xaa, 0x2d, 0x2f, 0xdb, 0xb6, 0x64, 0x31, 0x6d, 0x5a,
-	0x9b, 0xd7, 0xf5, 0x6d, 0xf2, 0x98, 0xcc, 0xfb, 0x7f, 0x63, 0xde, 0x6b, 0x41, 0xe2, 0x79, 0xcf,
-	0xf1, 0x3e, 0x61, 0xdc, 0x1d, 0x5a, 0xfc, 0xf7, 0x4e, 0x0a, 0x52, 0xa9, 0xac, 0xe8, 0xfa, 0x56,
-	0x0c, 0x8c, 0xc7, 0x21, 0x09, 0x49, 0x7c, 0x8a, 0xe2, 0xdf, 0x4f, 0x51, 0x94, 0x9a, 0xb8, 0x85,
-	0x71, 0x31, 0x9d, 0x83, 0x32, 0x9b, 0x83, 0xb2, 0x9c, 0x03, 0x7a, 0x8e, 0x00, 0xbd, 0x45, 0x80,
-	0x26, 0x11, 0xa0, 0x69, 0x04, 0xe8, 0x2b, 0x02, 0xf4, 0x1d, 0x81, 0xb2, 0x8c, 0x00, 0xbd, 0x2c,
-	0x40, 0x99, 0x2e, 0x40, 0x99, 0x2d, 0x40, 0xb9, 0x8b, 0x7f, 0xa5, 0x6e, 0x5e, 0x86, 0x9c, 0xfd,
-	0x04, 0x00, 0x00, 0xff, 0xff, 0x86, 0xcb, 0x53, 0xdd, 0x67, 0x02, 0x00, 0x00,
+	// 567 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x94, 0x31, 0x6f, 0xd3, 0x40,
+	0x14, 0xc7, 0x7d, 0x6d, 0x52, 0x92, 0x0b, 0x29, 0xf4, 0x88, 0x50, 0x08, 0xe2, 0x1a, 0xb5, 0x43,
+	0x23, 0x21, 0x39, 0xa8, 0xb0, 0xb1, 0x80, 0xc3, 0xd2, 0x01, 0x35, 0xb8, 0x9d, 0x58, 0x2c, 0x27,
+	0x7e, 0x49, 0xac, 0xc4, 0x3e, 0xd7, 0x77, 0x16, 0x74, 0xe3, 0x23, 0x30, 0x32, 0x31, 0xf3, 0x51,
+	0x3a, 0x66, 0xec, 0x04, 0xc4, 0x59, 0x98, 0x50, 0x3f, 0x02, 0xf2, 0xf3, 0x39, 0x4d, 0x98, 0x10,
+	0x5b, 0xde, 0xfb, 0xfd, 0xff, 0x7a, 0x2f, 0x77, 0xff, 0x33, 0xad, 0x49, 0xe5, 0x2a, 0x69, 0x46,
+	0xb1, 0x50, 0x82, 0x95, 0xb1, 0x68, 0x35, 0xc6, 0x62, 0x2c, 0xb0, 0xd3, 0xcd, 0x7e, 0xe5, 0xb0,
+	0xc5, 0xc7, 0x42, 0x8c, 0x67, 0xd0, 0xc5, 0x6a, 0x90, 0x8c, 0xba, 0x5e, 0x12, 0xbb, 0xca, 0x17,
+	0x61, 0xce, 0x0f, 0x7e, 0x97, 0x68, 0xf9, 0x2c, 0xf3, 0xb3, 0x57, 0xb4, 0xfa, 0xc1, 0x9d, 0xcd,
+	0x1c, 0xe5, 0x07, 0xd0, 0x24, 0x6d, 0xd2, 0xa9, 0x1d, 0x3f, 0x32, 0x73, 0xb7, 0x59, 0xb8, 0xcd,
+	0x37, 0xda, 0x6d, 0x55, 0xae, 0xbe, 0xef, 0x1b, 0x5f, 0x7e, 0xec, 0x13, 0xbb, 0x92, 0xb9, 0xce,
+	0xfd, 0x00, 0xd8, 0x33, 0xda, 0x18, 0x81, 0x1a, 0x4e, 0xc0, 0x73, 0x24, 0xc4, 0x3e, 0x48, 0x67,
+	0x28, 0x92, 0x50, 0x35, 0xb7, 0xda, 0xa4, 0x53, 0xb2, 0x99, 0x66, 0x67, 0x88, 0x7a, 0x19, 0x61,
+	0x26, 0x7d, 0x50, 0x38, 0x86, 0x93, 0x24, 0x9c, 0x3a, 0x83, 0x4b, 0x05, 0xb2, 0xb9, 0x8d, 0x86,
+	0x3d, 0x8d, 0x7a, 0x19, 0xb1, 0x32, 0xb0, 0x3e, 0x01, 0xf5, 0xc5, 0x84, 0xd2, 0xc6, 0x04, 0x34,
+	0xe8, 0x09, 0x47, 0xf4, 0x9e, 0x9c, 0xb8, 0xb1, 0x07, 0x9e, 0x73, 0x91, 0xe0, 0xe4, 0x66, 0xb9,
+	0x4d, 0x3a, 0x75, 0x7b, 0x57, 0xb7, 0xdf, 0xe5, 0x5d, 0x76, 0x48, 0xeb, 0x32, 0x9a, 0xf9, 0x6a,
+	0x25, 0xdb, 0x41, 0xd9, 0x5d, 0x6c, 0x16, 0xa2, 0xb5, 0x7d, 0xfd, 0xd0, 0x83, 0x8f, 0x7a, 0xdf,
+	0x3b, 0x1b, 0xfb, 0x9e, 0x64, 0x24, 0xdf, 0xf7, 0x05, 0x7d, 0x08, 0x52, 0xf9, 0x81, 0xab, 0xfe,
+	0x3e, 0x93, 0x0a, 0x5a, 0x1a, 0x2b, 0xba, 0x7e, 0x2a, 0x16, 0xa5, 0x17, 0x09, 0x24, 0x90, 0x5f,
+	0x45, 0xf5, 0xdf, 0xaf, 0xa2, 0x8a, 0x36, 0xbc, 0x8b, 0x73, 0x7a, 0x74, 0x3b, 0x39, 0x02, 0x77,
+	0xea, 0x04, 0x10, 0x88, 0xf8, 0xd2, 0x19, 0x8a, 0x50, 0x26, 0x41, 0x94, 0x39, 0xf5, 0xf6, 0x14,
+	0x57, 0x39, 0x5c, 0xc9, 0xfb, 0xe0, 0x4e, 0xdf, 0xa2, 0xb8, 0x77, 0xab, 0xcd, 0xff, 0xcf, 0x6b,
+	0xba, 0x2b, 0x22, 0x88, 0x5d, 0x25, 0x62, 0x07, 0x53, 0xd7, 0xac, 0xb5, 0xb7, 0x3b, 0xb5, 0xe3,
+	0x86, 0x89, 0x95, 0x79, 0xaa, 0x21, 0x26, 0xca, 0x2a, 0x65, 0x8b, 0xd9, 0x75, 0xb1, 0xde, 0x3c,
+	0xf8, 0xba, 0x45, 0xeb, 0x1b, 0x32, 0xd6, 0xa2, 0x95, 0x42, 0x82, 0xb9, 0xab, 0xda, 0xab, 0x9a,
+	0x35, 0x68, 0xd9, 0x83, 0x48, 0x4d, 0x30, 0x43, 0x75, 0x3b, 0x2f, 0xd8, 0x63, 0x5a, 0xd5, 0x87,
+	0xe9, 0x87, 0x3a, 0x2c, 0x95, 0xbc, 0x71, 0x12, 0xb2, 0x27, 0x94, 0x6a, 0x28, 0x92, 0x22, 0x19,
+	0x5a, 0x7e, 0x9a, 0x28, 0xf6, 0x94, 0xee, 0x49, 0x37, 0x88, 0x66, 0x20, 0x9d, 0x28, 0x16, 0x43,
+	0x90, 0x12, 0x3c, 0x8c, 0x44, 0xc9, 0xbe, 0xaf, 0x41, 0xbf, 0xe8, 0x6f, 0xbe, 0x89, 0x9d, 0xff,
+	0x7c, 0x13, 0x78, 0xfa, 0x83, 0x64, 0x34, 0x82, 0x38, 0xbb, 0x0b, 0xe1, 0x87, 0xaa, 0x88, 0x0c,
+	0xcb, 0x98, 0xa5, 0x51, 0x1f, 0x89, 0xf5, 0x72, 0xbe, 0xe0, 0xc6, 0xf5, 0x82, 0x1b, 0x37, 0x0b,
+	0x4e, 0x3e, 0xa5, 0x9c, 0x7c, 0x4b, 0x39, 0xb9, 0x4a, 0x39, 0x99, 0xa7, 0x9c, 0xfc, 0x4c, 0x39,
+	0xf9, 0x95, 0x72, 0xe3, 0x26, 0xe5, 0xe4, 0xf3, 0x92, 0x1b, 0xf3, 0x25, 0x37, 0xae, 0x97, 0xdc,
+	0x78, 0x9f, 0x7f, 0x04, 0x06, 0x3b, 0xb8, 0xd5, 0xf3, 0x3f, 0x01, 0x00, 0x00, 0xff, 0xff, 0xad,
+	0xb3, 0xbb, 0x7a, 0x21, 0x04, 0x00, 0x00,
 }
 
 func (this *Stats) Equal(that interface{}) bool {
@@ -225,13 +359,66 @@ func (this *Stats) Equal(that interface{}) bool {
 	if this.QueueTime != that1.QueueTime {
 		return false
 	}
+	if this.EstimatedPeakMemoryConsumptionBytes != that1.EstimatedPeakMemoryConsumptionBytes {
+		return false
+	}
+	if len(this.OperatorStats) != len(that1.OperatorStats) {
+		return false
+	}
+	for i := range this.OperatorStats {
+		if !this.OperatorStats[i].Equal(&that1.OperatorStats[i]) {
+			return false
+		}
+	}
+	return true
+}
+func (this *OperatorStats) Equal(that interface{}) bool {
+	if that == nil {
+		return this == nil
+	}
+
+	that1, ok := that.(*OperatorStats)
+	if !ok {
+		that2, ok := that.(OperatorStats)
+		if ok {
+			that1 = &that2
+		} else {
+			return false
+		}
+	}
+	if that1 == nil {
+		return this == nil
+	} else if this == nil {
+		return false
+	}
+	if this.Operator != that1.Operator {
+		return false
+	}
+	if this.Depth != that1.Depth {
+		return false
+	}
+	if this.SeriesIn != that1.SeriesIn {
+		return false
+	}
+	if this.SeriesOut != that1.SeriesOut {
+		return false
+	}
+	if this.SamplesProcessed != that1.SamplesProcessed {
+		return false
+	}
+	if this.WallTime != that1.WallTime {
+		return false
+	}
+	if this.PeakBufferedPoints != that1.PeakBufferedPoints {
+		return false
+	}
 	return true
 }
 func (this *Stats) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 13)
+	s := make([]string, 0, 15)
 	s = append(s, "&stats.Stats{")
 	s = append(s, "WallTime: "+fmt.Sprintf("%#v", this.WallTime)+",\n")
 	s = append(s, "FetchedSeriesCount: "+fmt.Sprintf("%#v", this.FetchedSeriesCount)+",\n")
@@ -242,6 +429,30 @@ func (this *Stats) GoString() string {
 	s = append(s, "FetchedIndexBytes: "+fmt.Sprintf("%#v", this.FetchedIndexBytes)+",\n")
 	s = append(s, "EstimatedSeriesCount: "+fmt.Sprintf("%#v", this.EstimatedSeriesCount)+",\n")
 	s = append(s, "QueueTime: "+fmt.Sprintf("%#v", this.QueueTime)+",\n")
+	s = append(s, "EstimatedPeakMemoryConsumptionBytes: "+fmt.Sprintf("%#v", this.EstimatedPeakMemoryConsumptionBytes)+",\n")
+	if this.OperatorStats != nil {
+		vs := make([]OperatorStats, len(this.OperatorStats))
+		for i := range vs {
+			vs[i] = this.OperatorStats[i]
+		}
+		s = append(s, "OperatorStats: "+fmt.Sprintf("%#v", vs)+",\n")
+	}
+	s = append(s, "}")
+	return strings.Join(s, "")
+}
+func (this *OperatorStats) GoString() string {
+	if this == nil {
+		return "nil"
+	}
+	s := make([]string, 0, 11)
+	s = append(s, "&stats.OperatorStats{")
+	s = append(s, "Operator: "+fmt.Sprintf("%#v", this.Operator)+",\n")
+	s = append(s, "Depth: "+fmt.Sprintf("%#v", this.Depth)+",\n")
+	s = append(s, "SeriesIn: "+fmt.Sprintf("%#v", this.SeriesIn)+",\n")
+	s = append(s, "SeriesOut: "+fmt.Sprintf("%#v", this.SeriesOut)+",\n")
+	s = append(s, "SamplesProcessed: "+fmt.Sprintf("%#v", this.SamplesProcessed)+",\n")
+	s = append(s, "WallTime: "+fmt.Sprintf("%#v", this.WallTime)+",\n")
+	s = append(s, "PeakBufferedPoints: "+fmt.Sprintf("%#v", this.PeakBufferedPoints)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -273,6 +484,25 @@ func (m *Stats) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if len(m.OperatorStats) > 0 {
+		for iNdEx := len(m.OperatorStats) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.OperatorStats[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintStats(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x5a
+		}
+	}
+	if m.EstimatedPeakMemoryConsumptionBytes != 0 {
+		i = encodeVarintStats(dAtA, i, uint64(m.EstimatedPeakMemoryConsumptionBytes))
+		i--
+		dAtA[i] = 0x50
+	}
 	n1, err1 := github_com_gogo_protobuf_types.StdDurationMarshalTo(m.QueueTime, dAtA[i-github_com_gogo_protobuf_types.SizeOfStdDuration(m.QueueTime):])
 	if err1 != nil {
 		return 0, err1
@@ -327,6 +557,69 @@ func (m *Stats) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	return len(dAtA) - i, nil
 }
 
+func (m *OperatorStats) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *OperatorStats) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *OperatorStats) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.PeakBufferedPoints != 0 {
+		i = encodeVarintStats(dAtA, i, uint64(m.PeakBufferedPoints))
+		i--
+		dAtA[i] = 0x38
+	}
+	n3, err3 := github_com_gogo_protobuf_types.StdDurationMarshalTo(m.WallTime, dAtA[i-github_com_gogo_protobuf_types.SizeOfStdDuration(m.WallTime):])
+	if err3 != nil {
+		return 0, err3
+	}
+	i -= n3
+	i = encodeVarintStats(dAtA, i, uint64(n3))
+	i--
+	dAtA[i] = 0x32
+	if m.SamplesProcessed != 0 {
+		i = encodeVarintStats(dAtA, i, uint64(m.SamplesProcessed))
+		i--
+		dAtA[i] = 0x28
+	}
+	if m.SeriesOut != 0 {
+		i = encodeVarintStats(dAtA, i, uint64(m.SeriesOut))
+		i--
+		dAtA[i] = 0x20
+	}
+	if m.SeriesIn != 0 {
+		i = encodeVarintStats(dAtA, i, uint64(m.SeriesIn))
+		i--
+		dAtA[i] = 0x18
+	}
+	if m.Depth != 0 {
+		i = encodeVarintStats(dAtA, i, uint64(m.Depth))
+		i--
+		dAtA[i] = 0x10
+	}
+	if len(m.Operator) > 0 {
+		i -= len(m.Operator)
+		copy(dAtA[i:], m.Operator)
+		i = encodeVarintStats(dAtA, i, uint64(len(m.Operator)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
 func encodeVarintStats(dAtA []byte, offset int, v uint64) int {
 	offset -= sovStats(v)
 	base := offset
@@ -369,6 +662,45 @@ func (m *Stats) Size() (n int) {
 	}
 	l = github_com_gogo_protobuf_types.SizeOfStdDuration(m.QueueTime)
 	n += 1 + l + sovStats(uint64(l))
+	if m.EstimatedPeakMemoryConsumptionBytes != 0 {
+		n += 1 + sovStats(uint64(m.EstimatedPeakMemoryConsumptionBytes))
+	}
+	if len(m.OperatorStats) > 0 {
+		for _, e := range m.OperatorStats {
+			l = e.Size()
+			n += 1 + l + sovStats(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *OperatorStats) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Operator)
+	if l > 0 {
+		n += 1 + l + sovStats(uint64(l))
+	}
+	if m.Depth != 0 {
+		n += 1 + sovStats(uint64(m.Depth))
+	}
+	if m.SeriesIn != 0 {
+		n += 1 + sovStats(uint64(m.SeriesIn))
+	}
+	if m.SeriesOut != 0 {
+		n += 1 + sovStats(uint64(m.SeriesOut))
+	}
+	if m.SamplesProcessed != 0 {
+		n += 1 + sovStats(uint64(m.SamplesProcessed))
+	}
+	l = github_com_gogo_protobuf_types.SizeOfStdDuration(m.WallTime)
+	n += 1 + l + sovStats(uint64(l))
+	if m.PeakBufferedPoints != 0 {
+		n += 1 + sovStats(uint64(m.PeakBufferedPoints))
+	}
 	return n
 }
 
@@ -382,6 +714,11 @@ func (this *Stats) String() string {
 	if this == nil {
 		return "nil"
 	}
+	repeatedStringForOperatorStats := "[]OperatorStats{"
+	for _, f := range this.OperatorStats {
+		repeatedStringForOperatorStats += strings.Replace(strings.Replace(f.String(), "OperatorStats", "OperatorStats", 1), `&`, ``, 1) + ","
+	}
+	repeatedStringForOperatorStats += "}"
 	s := strings.Join([]string{`&Stats{`,
 		`WallTime:` + strings.Replace(strings.Replace(fmt.Sprintf("%v", this.WallTime), "Duration", "durationpb.Duration", 1), `&`, ``, 1) + `,`,
 		`FetchedSeriesCount:` + fmt.Sprintf("%v", this.FetchedSeriesCount) + `,`,
@@ -392,6 +729,24 @@ func (this *Stats) String() string {
 		`FetchedIndexBytes:` + fmt.Sprintf("%v", this.FetchedIndexBytes) + `,`,
 		`EstimatedSeriesCount:` + fmt.Sprintf("%v", this.EstimatedSeriesCount) + `,`,
 		`QueueTime:` + strings.Replace(strings.Replace(fmt.Sprintf("%v", this.QueueTime), "Duration", "durationpb.Duration", 1), `&`, ``, 1) + `,`,
+		`EstimatedPeakMemoryConsumptionBytes:` + fmt.Sprintf("%v", this.EstimatedPeakMemoryConsumptionBytes) + `,`,
+		`OperatorStats:` + repeatedStringForOperatorStats + `,`,
+		`}`,
+	}, "")
+	return s
+}
+func (this *OperatorStats) String() string {
+	if this == nil {
+		return "nil"
+	}
+	s := strings.Join([]string{`&OperatorStats{`,
+		`Operator:` + fmt.Sprintf("%v", this.Operator) + `,`,
+		`Depth:` + fmt.Sprintf("%v", this.Depth) + `,`,
+		`SeriesIn:` + fmt.Sprintf("%v", this.SeriesIn) + `,`,
+		`SeriesOut:` + fmt.Sprintf("%v", this.SeriesOut) + `,`,
+		`SamplesProcessed:` + fmt.Sprintf("%v", this.SamplesProcessed) + `,`,
+		`WallTime:` + strings.Replace(strings.Replace(fmt.Sprintf("%v", this.WallTime), "Duration", "durationpb.Duration", 1), `&`, ``, 1) + `,`,
+		`PeakBufferedPoints:` + fmt.Sprintf("%v", this.PeakBufferedPoints) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -632,6 +987,272 @@ func (m *Stats) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 10:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field EstimatedPeakMemoryConsumptionBytes", wireType)
+			}
+			m.EstimatedPeakMemoryConsumptionBytes = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowStats
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.EstimatedPeakMemoryConsumptionBytes |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 11:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field OperatorStats", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowStats
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthStats
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthStats
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.OperatorStats = append(m.OperatorStats, OperatorStats{})
+			if err := m.OperatorStats[len(m.OperatorStats)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipStats(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthStats
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthStats
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *OperatorStats) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowStats
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: OperatorStats: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: OperatorStats: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Operator", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowStats
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthStats
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthStats
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Operator = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Depth", wireType)
+			}
+			m.Depth = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowStats
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Depth |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SeriesIn", wireType)
+			}
+			m.SeriesIn = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowStats
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.SeriesIn |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SeriesOut", wireType)
+			}
+			m.SeriesOut = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowStats
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.SeriesOut |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SamplesProcessed", wireType)
+			}
+			m.SamplesProcessed = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowStats
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.SamplesProcessed |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field WallTime", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowStats
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthStats
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthStats
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if err := github_com_gogo_protobuf_types.StdDurationUnmarshal(&m.WallTime, dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 7:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PeakBufferedPoints", wireType)
+			}
+			m.PeakBufferedPoints = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowStats
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.PeakBufferedPoints |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipStats(dAtA[iNdEx:])
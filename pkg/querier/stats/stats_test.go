@@ -132,6 +132,44 @@ func TestStats_QueueTime(t *testing.T) {
 	})
 }
 
+func TestStats_EstimatedPeakMemoryConsumption(t *testing.T) {
+	t.Run("update and load estimated peak memory consumption", func(t *testing.T) {
+		stats, _ := ContextWithEmptyStats(context.Background())
+		stats.UpdateEstimatedPeakMemoryConsumption(100)
+		stats.UpdateEstimatedPeakMemoryConsumption(300)
+		stats.UpdateEstimatedPeakMemoryConsumption(200)
+
+		assert.Equal(t, uint64(300), stats.LoadEstimatedPeakMemoryConsumption())
+	})
+
+	t.Run("update and load estimated peak memory consumption nil receiver", func(t *testing.T) {
+		var stats *Stats
+		stats.UpdateEstimatedPeakMemoryConsumption(100)
+
+		assert.Equal(t, uint64(0), stats.LoadEstimatedPeakMemoryConsumption())
+	})
+}
+
+func TestStats_OperatorStats(t *testing.T) {
+	t.Run("add and load operator stats", func(t *testing.T) {
+		stats, _ := ContextWithEmptyStats(context.Background())
+		stats.AddOperatorStats(OperatorStats{Operator: "Aggregation", SeriesIn: 3, SeriesOut: 1})
+		stats.AddOperatorStats(OperatorStats{Operator: "InstantVectorSelector", Depth: 1, SeriesOut: 3, WallTime: time.Second})
+
+		assert.Equal(t, []OperatorStats{
+			{Operator: "Aggregation", SeriesIn: 3, SeriesOut: 1},
+			{Operator: "InstantVectorSelector", Depth: 1, SeriesOut: 3, WallTime: time.Second},
+		}, stats.LoadOperatorStats())
+	})
+
+	t.Run("add and load operator stats nil receiver", func(t *testing.T) {
+		var stats *Stats
+		stats.AddOperatorStats(OperatorStats{Operator: "Aggregation"})
+
+		assert.Nil(t, stats.LoadOperatorStats())
+	})
+}
+
 func TestStats_Merge(t *testing.T) {
 	t.Run("merge two stats objects", func(t *testing.T) {
 		stats1 := &Stats{}
@@ -142,6 +180,8 @@ func TestStats_Merge(t *testing.T) {
 		stats1.AddShardedQueries(20)
 		stats1.AddSplitQueries(10)
 		stats1.AddQueueTime(5 * time.Second)
+		stats1.UpdateEstimatedPeakMemoryConsumption(1000)
+		stats1.AddOperatorStats(OperatorStats{Operator: "InstantVectorSelector", SeriesOut: 3})
 
 		stats2 := &Stats{}
 		stats2.AddWallTime(time.Second)
@@ -151,6 +191,8 @@ func TestStats_Merge(t *testing.T) {
 		stats2.AddShardedQueries(21)
 		stats2.AddSplitQueries(11)
 		stats2.AddQueueTime(10 * time.Second)
+		stats2.UpdateEstimatedPeakMemoryConsumption(500)
+		stats2.AddOperatorStats(OperatorStats{Operator: "Aggregation", SeriesIn: 2, SeriesOut: 1}, OperatorStats{Operator: "InstantVectorSelector", Depth: 1, SeriesOut: 2})
 
 		stats1.Merge(stats2)
 
@@ -161,6 +203,29 @@ func TestStats_Merge(t *testing.T) {
 		assert.Equal(t, uint32(41), stats1.LoadShardedQueries())
 		assert.Equal(t, uint32(21), stats1.LoadSplitQueries())
 		assert.Equal(t, 15*time.Second, stats1.LoadQueueTime())
+		assert.Equal(t, uint64(1000), stats1.LoadEstimatedPeakMemoryConsumption())
+		assert.Equal(t, []OperatorStats{
+			{Operator: "InstantVectorSelector", SeriesOut: 3},
+			{Operator: "Aggregation", SeriesIn: 2, SeriesOut: 1},
+			{Operator: "InstantVectorSelector", Depth: 1, SeriesOut: 2},
+		}, stats1.LoadOperatorStats())
+	})
+
+	t.Run("merge keeps only the slowest operators", func(t *testing.T) {
+		stats1 := &Stats{}
+		stats1.AddOperatorStats(OperatorStats{Operator: "Aggregation", WallTime: 5 * time.Second})
+
+		for i := 0; i < MaxMergedOperatorStats; i++ {
+			stats2 := &Stats{}
+			stats2.AddOperatorStats(OperatorStats{Operator: "InstantVectorSelector", WallTime: time.Duration(i) * time.Second})
+			stats1.Merge(stats2)
+		}
+
+		operators := stats1.LoadOperatorStats()
+		assert.Len(t, operators, MaxMergedOperatorStats)
+		assert.Equal(t, OperatorStats{Operator: "Aggregation", WallTime: 5 * time.Second}, operators[4])
+		assert.Equal(t, 9*time.Second, operators[0].WallTime)
+		assert.Equal(t, time.Second, operators[MaxMergedOperatorStats-1].WallTime)
 	})
 
 	t.Run("merge two nil stats objects", func(t *testing.T) {
@@ -176,5 +241,7 @@ func TestStats_Merge(t *testing.T) {
 		assert.Equal(t, uint32(0), stats1.LoadShardedQueries())
 		assert.Equal(t, uint32(0), stats1.LoadSplitQueries())
 		assert.Equal(t, time.Duration(0), stats1.LoadQueueTime())
+		assert.Equal(t, uint64(0), stats1.LoadEstimatedPeakMemoryConsumption())
+		assert.Nil(t, stats1.LoadOperatorStats())
 	})
 }
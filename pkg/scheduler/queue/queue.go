@@ -24,6 +24,16 @@ import (
 const (
 	// How frequently to check for disconnected queriers that should be forgotten.
 	forgetCheckPeriod = 5 * time.Second
+
+	// DefaultQueryPriority is the priority of the requests which haven't been assigned a known query priority.
+	DefaultQueryPriority = "default"
+
+	// IngesterQueueDimension, StoreGatewayQueueDimension and IngesterAndStoreGatewayQueueDimension are the
+	// additional queue dimensions of the requests expected to query respectively only the ingesters,
+	// only the store-gateways, or both.
+	IngesterQueueDimension                = "ingester"
+	StoreGatewayQueueDimension            = "store-gateway"
+	IngesterAndStoreGatewayQueueDimension = "ingester-and-store-gateway"
 )
 
 var (
@@ -40,6 +50,9 @@ type SchedulerRequest struct {
 	Request                   *httpgrpc.HTTPRequest
 	StatsEnabled              bool
 	AdditionalQueueDimensions []string
+	// Priority is the query priority class the request is enqueued under, when the tenant queues are split by
+	// query priority.
+	Priority string
 
 	EnqueueTime time.Time
 
@@ -82,6 +95,7 @@ type RequestQueue struct {
 
 	maxOutstandingPerTenant          int
 	additionalQueueDimensionsEnabled bool
+	queryPriorityWeights             map[string]int
 	forgetDelay                      time.Duration
 
 	connectedQuerierWorkers *atomic.Int32
@@ -124,6 +138,7 @@ func NewRequestQueue(
 	log log.Logger,
 	maxOutstandingPerTenant int,
 	additionalQueueDimensionsEnabled bool,
+	queryPriorityWeights map[string]int,
 	forgetDelay time.Duration,
 	queueLength *prometheus.GaugeVec,
 	discardedRequests *prometheus.CounterVec,
@@ -133,6 +148,7 @@ func NewRequestQueue(
 		log:                              log,
 		maxOutstandingPerTenant:          maxOutstandingPerTenant,
 		additionalQueueDimensionsEnabled: additionalQueueDimensionsEnabled,
+		queryPriorityWeights:             queryPriorityWeights,
 		forgetDelay:                      forgetDelay,
 
 		connectedQuerierWorkers: atomic.NewInt32(0),
@@ -163,7 +179,7 @@ func (q *RequestQueue) starting(_ context.Context) error {
 
 func (q *RequestQueue) dispatcherLoop() {
 	stopping := false
-	queueBroker := newQueueBroker(q.maxOutstandingPerTenant, q.additionalQueueDimensionsEnabled, q.forgetDelay)
+	queueBroker := newQueueBroker(q.maxOutstandingPerTenant, q.additionalQueueDimensionsEnabled, q.queryPriorityWeights, q.forgetDelay)
 	waitingGetNextRequestForQuerierCalls := list.New()
 
 	for {
@@ -285,7 +301,7 @@ func (q *RequestQueue) enqueueRequestToBroker(broker *queueBroker, r requestToEn
 // tryDispatchRequestToQuerier finds and forwards a request to a waiting GetNextRequestForQuerier call, if a suitable request is available.
 // Returns true if call should be removed from the list of waiting calls (eg. because a request has been forwarded to it), false otherwise.
 func (q *RequestQueue) tryDispatchRequestToQuerier(broker *queueBroker, call *nextRequestForQuerierCall) bool {
-	req, tenant, idx, err := broker.dequeueRequestForQuerier(call.lastUserIndex.last, call.querierID)
+	req, tenant, idx, err := broker.dequeueRequestForQuerier(call.lastUserIndex.last, call.querierID, call.preferredQueueDimension)
 	if err != nil {
 		// If this querier has told us it's shutting down, terminate GetNextRequestForQuerier with an error now...
 		call.sendError(err)
@@ -352,12 +368,15 @@ func (q *RequestQueue) EnqueueRequestToDispatcher(tenantID string, req Request,
 // GetNextRequestForQuerier find next user queue and takes the next request off of it. Will block if there are no requests.
 // By passing user index from previous call of this method, querier guarantees that it iterates over all users fairly.
 // If querier finds that request from the user is already expired, it can get a request for the same user by using UserIndex.ReuseLastUser.
-func (q *RequestQueue) GetNextRequestForQuerier(ctx context.Context, last UserIndex, querierID string) (Request, UserIndex, error) {
+// Within the queue of the selected user, the requests with preferredQueueDimension as additional queue dimension are
+// returned first, if any; pass an empty preferredQueueDimension to disable the preference.
+func (q *RequestQueue) GetNextRequestForQuerier(ctx context.Context, last UserIndex, querierID string, preferredQueueDimension string) (Request, UserIndex, error) {
 	call := &nextRequestForQuerierCall{
-		ctx:           ctx,
-		querierID:     QuerierID(querierID),
-		lastUserIndex: last,
-		processed:     make(chan nextRequestForQuerier),
+		ctx:                     ctx,
+		querierID:               QuerierID(querierID),
+		lastUserIndex:           last,
+		preferredQueueDimension: preferredQueueDimension,
+		processed:               make(chan nextRequestForQuerier),
 	}
 
 	select {
@@ -420,10 +439,11 @@ func (q *RequestQueue) GetConnectedQuerierWorkersMetric() float64 {
 }
 
 type nextRequestForQuerierCall struct {
-	ctx           context.Context
-	querierID     QuerierID
-	lastUserIndex UserIndex
-	processed     chan nextRequestForQuerier
+	ctx                     context.Context
+	querierID               QuerierID
+	lastUserIndex           UserIndex
+	preferredQueueDimension string
+	processed               chan nextRequestForQuerier
 
 	haveUsed bool // Must be set to true after sending a message to processed, to ensure we only ever try to send one message to processed.
 }
@@ -136,6 +136,7 @@ func TestMultiDimensionalQueueFairnessSlowConsumerEffects(t *testing.T) {
 			log.NewNopLogger(),
 			maxOutstandingRequestsPerTenant,
 			additionalQueueDimensionsEnabled,
+			nil,
 			forgetQuerierDelay,
 			promauto.With(nil).NewGaugeVec(prometheus.GaugeOpts{}, []string{"tenant"}),
 			promauto.With(nil).NewCounterVec(prometheus.CounterOpts{}, []string{"tenant"}),
@@ -239,6 +240,7 @@ func BenchmarkConcurrentQueueOperations(b *testing.B) {
 								log.NewNopLogger(),
 								maxOutstandingRequestsPerTenant,
 								true,
+								nil,
 								forgetQuerierDelay,
 								promauto.With(nil).NewGaugeVec(prometheus.GaugeOpts{}, []string{"tenant"}),
 								promauto.With(nil).NewCounterVec(prometheus.CounterOpts{}, []string{"tenant"}),
@@ -390,7 +392,7 @@ type consumeRequest func(request Request) error
 func queueConsume(
 	ctx context.Context, queue *RequestQueue, querierID string, lastTenantIndex UserIndex, consumeFunc consumeRequest,
 ) (UserIndex, error) {
-	request, idx, err := queue.GetNextRequestForQuerier(ctx, lastTenantIndex, querierID)
+	request, idx, err := queue.GetNextRequestForQuerier(ctx, lastTenantIndex, querierID, "")
 	if err != nil {
 		return lastTenantIndex, err
 	}
@@ -408,6 +410,7 @@ func TestRequestQueue_GetNextRequestForQuerier_ShouldGetRequestAfterReshardingBe
 	queue := NewRequestQueue(
 		log.NewNopLogger(),
 		1, true,
+		nil,
 		forgetDelay,
 		promauto.With(nil).NewGaugeVec(prometheus.GaugeOpts{}, []string{"user"}),
 		promauto.With(nil).NewCounterVec(prometheus.CounterOpts{}, []string{"user"}),
@@ -430,7 +433,7 @@ func TestRequestQueue_GetNextRequestForQuerier_ShouldGetRequestAfterReshardingBe
 	querier2wg.Add(1)
 	go func() {
 		defer querier2wg.Done()
-		_, _, err := queue.GetNextRequestForQuerier(ctx, FirstUser(), "querier-2")
+		_, _, err := queue.GetNextRequestForQuerier(ctx, FirstUser(), "querier-2", "")
 		require.NoError(t, err)
 	}()
 
@@ -462,6 +465,7 @@ func TestRequestQueue_GetNextRequestForQuerier_ShouldReturnAfterContextCancelled
 		log.NewNopLogger(),
 		1,
 		true,
+		nil,
 		forgetDelay,
 		promauto.With(nil).NewGaugeVec(prometheus.GaugeOpts{}, []string{"user"}),
 		promauto.With(nil).NewCounterVec(prometheus.CounterOpts{}, []string{"user"}),
@@ -478,7 +482,7 @@ func TestRequestQueue_GetNextRequestForQuerier_ShouldReturnAfterContextCancelled
 	ctx, cancel := context.WithCancel(context.Background())
 
 	go func() {
-		_, _, err := queue.GetNextRequestForQuerier(ctx, FirstUser(), querierID)
+		_, _, err := queue.GetNextRequestForQuerier(ctx, FirstUser(), querierID, "")
 		errChan <- err
 	}()
 
@@ -501,6 +505,7 @@ func TestRequestQueue_GetNextRequestForQuerier_ShouldReturnImmediatelyIfQuerierI
 		log.NewNopLogger(),
 		1,
 		true,
+		nil,
 		forgetDelay,
 		promauto.With(nil).NewGaugeVec(prometheus.GaugeOpts{}, []string{"user"}),
 		promauto.With(nil).NewCounterVec(prometheus.CounterOpts{}, []string{"user"}),
@@ -516,7 +521,7 @@ func TestRequestQueue_GetNextRequestForQuerier_ShouldReturnImmediatelyIfQuerierI
 	queue.RegisterQuerierConnection(querierID)
 	queue.NotifyQuerierShutdown(querierID)
 
-	_, _, err := queue.GetNextRequestForQuerier(context.Background(), FirstUser(), querierID)
+	_, _, err := queue.GetNextRequestForQuerier(context.Background(), FirstUser(), querierID, "")
 	require.EqualError(t, err, "querier has informed the scheduler it is shutting down")
 }
 
@@ -528,6 +533,7 @@ func TestRequestQueue_tryDispatchRequestToQuerier_ShouldReEnqueueAfterFailedSend
 		log.NewNopLogger(),
 		1,
 		true,
+		nil,
 		forgetDelay,
 		promauto.With(nil).NewGaugeVec(prometheus.GaugeOpts{}, []string{"user"}),
 		promauto.With(nil).NewCounterVec(prometheus.CounterOpts{}, []string{"user"}),
@@ -536,7 +542,7 @@ func TestRequestQueue_tryDispatchRequestToQuerier_ShouldReEnqueueAfterFailedSend
 
 	// bypassing queue dispatcher loop for direct usage of the queueBroker and
 	// passing a nextRequestForQuerierCall for a canceled querier connection
-	queueBroker := newQueueBroker(queue.maxOutstandingPerTenant, queue.additionalQueueDimensionsEnabled, queue.forgetDelay)
+	queueBroker := newQueueBroker(queue.maxOutstandingPerTenant, queue.additionalQueueDimensionsEnabled, queue.queryPriorityWeights, queue.forgetDelay)
 	queueBroker.addQuerierConnection(querierID)
 
 	tenantMaxQueriers := 0 // no sharding
@@ -106,9 +106,13 @@ type queueBroker struct {
 
 	maxTenantQueueSize               int
 	additionalQueueDimensionsEnabled bool
+
+	// queryPriorityWeights holds the weights of the query priorities the tenant queues are split by.
+	// Tenant queues are not split by query priority if empty.
+	queryPriorityWeights map[string]int
 }
 
-func newQueueBroker(maxTenantQueueSize int, additionalQueueDimensionsEnabled bool, forgetDelay time.Duration) *queueBroker {
+func newQueueBroker(maxTenantQueueSize int, additionalQueueDimensionsEnabled bool, queryPriorityWeights map[string]int, forgetDelay time.Duration) *queueBroker {
 	return &queueBroker{
 		tenantQueuesTree: NewTreeQueue("root"),
 		tenantQuerierAssignments: tenantQuerierAssignments{
@@ -121,6 +125,7 @@ func newQueueBroker(maxTenantQueueSize int, additionalQueueDimensionsEnabled boo
 		},
 		maxTenantQueueSize:               maxTenantQueueSize,
 		additionalQueueDimensionsEnabled: additionalQueueDimensionsEnabled,
+		queryPriorityWeights:             queryPriorityWeights,
 	}
 }
 
@@ -148,7 +153,12 @@ func (qb *queueBroker) enqueueRequestBack(request *tenantRequest, tenantMaxQueri
 	}
 
 	err = qb.tenantQueuesTree.EnqueueBackByPath(queuePath, request)
-	return err
+	if err != nil {
+		return err
+	}
+
+	qb.setTenantQueueWeights(queuePath)
+	return nil
 }
 
 // enqueueRequestFront should only be used for re-enqueueing previously dequeued requests
@@ -166,28 +176,62 @@ func (qb *queueBroker) enqueueRequestFront(request *tenantRequest, tenantMaxQuer
 	if err != nil {
 		return err
 	}
-	return qb.tenantQueuesTree.EnqueueFrontByPath(queuePath, request)
+	err = qb.tenantQueuesTree.EnqueueFrontByPath(queuePath, request)
+	if err != nil {
+		return err
+	}
+
+	qb.setTenantQueueWeights(queuePath)
+	return nil
 }
 
 func (qb *queueBroker) makeQueuePath(request *tenantRequest) (QueuePath, error) {
-	if qb.additionalQueueDimensionsEnabled {
-		if schedulerRequest, ok := request.req.(*SchedulerRequest); ok {
-			return append(QueuePath{string(request.tenantID)}, schedulerRequest.AdditionalQueueDimensions...), nil
+	queuePath := QueuePath{string(request.tenantID)}
+
+	schedulerRequest, ok := request.req.(*SchedulerRequest)
+	if !ok {
+		// request.req is a frontend/v1.request, which has no query priority nor additional queue dimensions
+		return queuePath, nil
+	}
+
+	if len(qb.queryPriorityWeights) > 0 {
+		priority := schedulerRequest.Priority
+		if _, ok := qb.queryPriorityWeights[priority]; !ok {
+			priority = DefaultQueryPriority
 		}
+		queuePath = append(queuePath, priority)
+	}
+
+	if qb.additionalQueueDimensionsEnabled {
+		queuePath = append(queuePath, schedulerRequest.AdditionalQueueDimensions...)
+	}
+
+	return queuePath, nil
+}
+
+// setTenantQueueWeights sets the query priority weights on the tenant queue node of queuePath, which may have been
+// created by the enqueue.
+func (qb *queueBroker) setTenantQueueWeights(queuePath QueuePath) {
+	if len(qb.queryPriorityWeights) == 0 {
+		return
 	}
 
-	// else request.req is a frontend/v1.request, or additional queue dimensions are disabled
-	return QueuePath{string(request.tenantID)}, nil
+	if tenantQueueNode := qb.tenantQueuesTree.getNode(queuePath[:1]); tenantQueueNode != nil {
+		tenantQueueNode.setChildQueueWeights(qb.queryPriorityWeights)
+	}
 }
 
-func (qb *queueBroker) dequeueRequestForQuerier(lastTenantIndex int, querierID QuerierID) (*tenantRequest, *queueTenant, int, error) {
+// dequeueRequestForQuerier dequeues the next request of the next tenant assigned to the querier.
+// Within the tenant queue, the requests having preferredQueueDimension as additional queue dimension are dequeued
+// first, if any; an empty preferredQueueDimension disables the preference.
+func (qb *queueBroker) dequeueRequestForQuerier(lastTenantIndex int, querierID QuerierID, preferredQueueDimension string) (*tenantRequest, *queueTenant, int, error) {
 	tenant, tenantIndex, err := qb.tenantQuerierAssignments.getNextTenantForQuerier(lastTenantIndex, querierID)
 	if tenant == nil || err != nil {
 		return nil, tenant, tenantIndex, err
 	}
 
 	queuePath := QueuePath{string(tenant.tenantID)}
-	queueElement := qb.tenantQueuesTree.DequeueByPath(queuePath)
+	queueElement := qb.tenantQueuesTree.DequeueByPathPreferringChild(queuePath, preferredQueueDimension)
 
 	queueNodeAfterDequeue := qb.tenantQueuesTree.getNode(queuePath)
 	if queueNodeAfterDequeue == nil {
@@ -21,14 +21,14 @@ import (
 )
 
 func TestQueues(t *testing.T) {
-	qb := newQueueBroker(0, true, 0)
+	qb := newQueueBroker(0, true, nil, 0)
 	assert.NotNil(t, qb)
 	assert.NoError(t, isConsistent(qb))
 
 	qb.addQuerierConnection("querier-1")
 	qb.addQuerierConnection("querier-2")
 
-	req, tenant, lastTenantIndex, err := qb.dequeueRequestForQuerier(-1, "querier-1")
+	req, tenant, lastTenantIndex, err := qb.dequeueRequestForQuerier(-1, "querier-1", "")
 	assert.Nil(t, req)
 	assert.Nil(t, tenant)
 	assert.NoError(t, err)
@@ -74,14 +74,14 @@ func TestQueues(t *testing.T) {
 	qb.removeTenantQueue("four")
 	assert.NoError(t, isConsistent(qb))
 
-	req, _, _, err = qb.dequeueRequestForQuerier(lastTenantIndex, "querier-1")
+	req, _, _, err = qb.dequeueRequestForQuerier(lastTenantIndex, "querier-1", "")
 	assert.Nil(t, req)
 	assert.NoError(t, err)
 }
 
 func TestQueuesRespectMaxTenantQueueSizeWithSubQueues(t *testing.T) {
 	maxTenantQueueSize := 100
-	qb := newQueueBroker(maxTenantQueueSize, true, 0)
+	qb := newQueueBroker(maxTenantQueueSize, true, nil, 0)
 	additionalQueueDimensions := map[int][]string{
 		0: nil,
 		1: {"ingester"},
@@ -127,7 +127,7 @@ func TestQueuesRespectMaxTenantQueueSizeWithSubQueues(t *testing.T) {
 
 	// dequeue a request
 	qb.addQuerierConnection("querier-1")
-	dequeuedTenantReq, _, _, err := qb.dequeueRequestForQuerier(-1, "querier-1")
+	dequeuedTenantReq, _, _, err := qb.dequeueRequestForQuerier(-1, "querier-1", "")
 	assert.NoError(t, err)
 	assert.NotNil(t, dequeuedTenantReq)
 
@@ -141,8 +141,56 @@ func TestQueuesRespectMaxTenantQueueSizeWithSubQueues(t *testing.T) {
 	assert.ErrorIs(t, err, ErrTooManyRequests)
 }
 
+func TestQueuesWithQueryPriorities(t *testing.T) {
+	qb := newQueueBroker(100, true, map[string]int{"ruler": 2, DefaultQueryPriority: 1}, 0)
+	qb.addQuerierConnection("querier-1")
+
+	enqueue := func(priority string, additionalQueueDimensions ...string) {
+		req := &SchedulerRequest{
+			Ctx:                       context.Background(),
+			UserID:                    "tenant-1",
+			Request:                   &httpgrpc.HTTPRequest{},
+			Priority:                  priority,
+			AdditionalQueueDimensions: additionalQueueDimensions,
+		}
+		require.NoError(t, qb.enqueueRequestBack(&tenantRequest{tenantID: "tenant-1", req: req}, 0))
+	}
+
+	for i := 0; i < 3; i++ {
+		enqueue(DefaultQueryPriority)
+		enqueue("ruler", "ingester")
+	}
+	// Requests with an unknown priority are enqueued with the default priority.
+	enqueue("unknown")
+
+	assert.Equal(t, 4, qb.tenantQueuesTree.getNode(QueuePath{"tenant-1", DefaultQueryPriority}).ItemCount())
+	assert.Equal(t, 3, qb.tenantQueuesTree.getNode(QueuePath{"tenant-1", "ruler", "ingester"}).LocalQueueLen())
+	assert.Nil(t, qb.tenantQueuesTree.getNode(QueuePath{"tenant-1", "unknown"}))
+
+	var dequeuedPriorities []string
+	lastTenantIndex := -1
+	for {
+		tenantReq, _, idx, err := qb.dequeueRequestForQuerier(lastTenantIndex, "querier-1", "")
+		require.NoError(t, err)
+		if tenantReq == nil {
+			break
+		}
+		lastTenantIndex = idx
+
+		priority := tenantReq.req.(*SchedulerRequest).Priority
+		if priority == "unknown" {
+			priority = DefaultQueryPriority
+		}
+		dequeuedPriorities = append(dequeuedPriorities, priority)
+	}
+
+	// The default priority was enqueued first, so it's dequeued from first.
+	expected := []string{DefaultQueryPriority, "ruler", "ruler", DefaultQueryPriority, "ruler", DefaultQueryPriority, DefaultQueryPriority}
+	assert.Equal(t, expected, dequeuedPriorities)
+}
+
 func TestQueuesOnTerminatingQuerier(t *testing.T) {
-	qb := newQueueBroker(0, true, 0)
+	qb := newQueueBroker(0, true, nil, 0)
 	assert.NotNil(t, qb)
 	assert.NoError(t, isConsistent(qb))
 
@@ -172,7 +220,7 @@ func TestQueuesOnTerminatingQuerier(t *testing.T) {
 }
 
 func TestQueuesWithQueriers(t *testing.T) {
-	qb := newQueueBroker(0, true, 0)
+	qb := newQueueBroker(0, true, nil, 0)
 	assert.NotNil(t, qb)
 	assert.NoError(t, isConsistent(qb))
 
@@ -186,7 +234,7 @@ func TestQueuesWithQueriers(t *testing.T) {
 		qb.addQuerierConnection(qid)
 
 		// No querier has any queues yet.
-		req, tenant, _, err := qb.dequeueRequestForQuerier(-1, qid)
+		req, tenant, _, err := qb.dequeueRequestForQuerier(-1, qid, "")
 		assert.Nil(t, req)
 		assert.Nil(t, tenant)
 		assert.NoError(t, err)
@@ -251,7 +299,7 @@ func TestQueuesConsistency(t *testing.T) {
 
 	for testName, testData := range tests {
 		t.Run(testName, func(t *testing.T) {
-			qb := newQueueBroker(0, true, testData.forgetDelay)
+			qb := newQueueBroker(0, true, nil, testData.forgetDelay)
 			assert.NotNil(t, qb)
 			assert.NoError(t, isConsistent(qb))
 
@@ -302,7 +350,7 @@ func TestQueues_ForgetDelay(t *testing.T) {
 	)
 
 	now := time.Now()
-	qb := newQueueBroker(0, true, forgetDelay)
+	qb := newQueueBroker(0, true, nil, forgetDelay)
 	assert.NotNil(t, qb)
 	assert.NoError(t, isConsistent(qb))
 
@@ -394,7 +442,7 @@ func TestQueues_ForgetDelay_ShouldCorrectlyHandleQuerierReconnectingBeforeForget
 	)
 
 	now := time.Now()
-	qb := newQueueBroker(0, true, forgetDelay)
+	qb := newQueueBroker(0, true, nil, forgetDelay)
 	assert.NotNil(t, qb)
 	assert.NoError(t, isConsistent(qb))
 
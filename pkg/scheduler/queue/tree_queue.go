@@ -4,6 +4,7 @@ package queue
 
 import (
 	"container/list"
+	"slices"
 )
 
 type QueuePath []string //nolint:revive // disallows types beginning with package name
@@ -23,6 +24,9 @@ const localQueueIndex = -1
 // from its own local queue and dequeuing recursively from its list of child TreeQueues.
 // No queue at a given level of the tree is dequeued from consecutively unless all others
 // at the same level of the tree are empty down to the leaf node.
+//
+// Child nodes can be given a weight with setChildQueueWeights; a child node of weight N is
+// dequeued from up to N consecutive times when it is its turn, instead of once.
 type TreeQueue struct {
 	// name of the tree node will be set to its segment of the queue path
 	name                   string
@@ -30,6 +34,11 @@ type TreeQueue struct {
 	currentChildQueueIndex int
 	childQueueOrder        []string
 	childQueueMap          map[string]*TreeQueue
+
+	// childQueueWeights holds the weights of the child nodes by name; child nodes not in the map have weight 1.
+	childQueueWeights map[string]int
+	// currentChildQueueDequeues is the number of consecutive dequeues from the current child node.
+	currentChildQueueDequeues int
 }
 
 func NewTreeQueue(name string) *TreeQueue {
@@ -188,6 +197,19 @@ func (q *TreeQueue) getNode(childPath QueuePath) *TreeQueue {
 	return nil
 }
 
+// setChildQueueWeights sets the weights of the child nodes of the receiver node by name.
+// Child nodes not in weights have weight 1.
+func (q *TreeQueue) setChildQueueWeights(weights map[string]int) {
+	q.childQueueWeights = weights
+}
+
+func (q *TreeQueue) childQueueWeight(name string) int {
+	if weight, ok := q.childQueueWeights[name]; ok && weight > 0 {
+		return weight
+	}
+	return 1
+}
+
 // DequeueByPath selects a child node by a given relative child path and calls Dequeue on the node.
 //
 // While the child node will recursively clean up its own empty children during dequeue,
@@ -196,12 +218,19 @@ func (q *TreeQueue) getNode(childPath QueuePath) *TreeQueue {
 //
 // childPath is relative to the receiver node; pass a zero-length path to refer to the node itself.
 func (q *TreeQueue) DequeueByPath(childPath QueuePath) any {
+	return q.DequeueByPathPreferringChild(childPath, "")
+}
+
+// DequeueByPathPreferringChild is like DequeueByPath, except that the selected child node and its
+// descendants first dequeue from their child node named preferredChildName, if any, before following
+// their round-robin order. An empty preferredChildName disables the preference.
+func (q *TreeQueue) DequeueByPathPreferringChild(childPath QueuePath, preferredChildName string) any {
 	childQueue := q.getNode(childPath)
 	if childQueue == nil {
 		return nil
 	}
 
-	v := childQueue.Dequeue()
+	v := childQueue.dequeue(preferredChildName)
 
 	if childQueue.IsEmpty() {
 		// child node will recursively clean up its own empty children during dequeue,
@@ -218,10 +247,19 @@ func (q *TreeQueue) DequeueByPath(childPath QueuePath) any {
 // Dequeuing from a node follows the round-robin order of the node's childQueueOrder,
 // dequeuing either from the node's localQueue or selecting the next child node in the order
 // and recursively calling Dequeue on the child nodes until a nonempty queue is found.
+// A child node keeps its turn until it has been dequeued from as many times as its weight.
 //
 // Nodes that empty down to the leaf after being dequeued from are deleted as the recursion returns
 // up the stack. This maintains structural guarantees relied on to make IsEmpty() non-recursive.
 func (q *TreeQueue) Dequeue() any {
+	return q.dequeue("")
+}
+
+func (q *TreeQueue) dequeue(preferredChildName string) any {
+	if v := q.dequeuePreferredChild(preferredChildName); v != nil {
+		return v
+	}
+
 	var v any
 	initialLen := len(q.childQueueOrder)
 
@@ -242,13 +280,18 @@ func (q *TreeQueue) Dequeue() any {
 			// pick the child node whose turn it is and recur
 			childQueueName := q.childQueueOrder[q.currentChildQueueIndex]
 			childQueue := q.childQueueMap[childQueueName]
-			v = childQueue.Dequeue()
+			v = childQueue.dequeue(preferredChildName)
 
 			// perform cleanup if child node is empty after dequeuing recursively
 			if childQueue.IsEmpty() {
 				// deleteNode wraps index for us
 				q.deleteNode(QueuePath{childQueueName})
+				q.currentChildQueueDequeues = 0
+			} else if v != nil && q.currentChildQueueDequeues+1 < q.childQueueWeight(childQueueName) {
+				// the child node keeps its turn until it has been dequeued from as many times as its weight
+				q.currentChildQueueDequeues++
 			} else {
+				q.currentChildQueueDequeues = 0
 				q.wrapIndex(true)
 			}
 		}
@@ -256,6 +299,30 @@ func (q *TreeQueue) Dequeue() any {
 	return v
 }
 
+// dequeuePreferredChild dequeues from the child node named preferredChildName out of the round-robin order,
+// without giving it the turn. Returns nil if there is no such child node.
+func (q *TreeQueue) dequeuePreferredChild(preferredChildName string) any {
+	childQueue, ok := q.childQueueMap[preferredChildName]
+	if preferredChildName == "" || !ok {
+		return nil
+	}
+
+	v := childQueue.dequeue(preferredChildName)
+	if childQueue.IsEmpty() {
+		childQueueIndex := slices.Index(q.childQueueOrder, preferredChildName)
+		switch {
+		case childQueueIndex < q.currentChildQueueIndex:
+			// keep the current child node's turn after removing a node placed before it
+			q.currentChildQueueIndex--
+		case childQueueIndex == q.currentChildQueueIndex:
+			// deleteNode passes the turn to the next node
+			q.currentChildQueueDequeues = 0
+		}
+		q.deleteNode(QueuePath{preferredChildName})
+	}
+	return v
+}
+
 // deleteNode removes a child node from the tree and the childQueueOrder and corrects the indices.
 func (q *TreeQueue) deleteNode(childPath QueuePath) bool {
 	if len(childPath) == 0 {
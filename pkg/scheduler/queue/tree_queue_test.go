@@ -313,6 +313,65 @@ func TestEnqueueDuringDequeueRespectsRoundRobin(t *testing.T) {
 	require.True(t, root.IsEmpty())
 }
 
+// TestDequeueWeightedChildQueues checks that a child node is dequeued from
+// as many consecutive times as its weight before moving on to the next child node.
+func TestDequeueWeightedChildQueues(t *testing.T) {
+	root := NewTreeQueue("root")
+	root.setChildQueueWeights(map[string]int{"high": 3, "mid": 2})
+
+	cache := map[string]struct{}{}
+	for _, childName := range []string{"high", "mid", "low"} {
+		for i := 0; i < 4; i++ {
+			childPath := QueuePath{childName}
+			require.NoError(t, root.EnqueueBackByPath(childPath, makeQueueItemForChildPath(root, childPath, cache)))
+		}
+	}
+
+	var dequeuedPaths []string
+	for !root.IsEmpty() {
+		dequeuedPaths = append(dequeuedPaths, getChildPathFromQueueItem(root.Dequeue())[0])
+	}
+
+	expectedPaths := []string{
+		"high", "high", "high", "mid", "mid", "low",
+		"high", "mid", "mid", "low",
+		"low",
+		"low",
+	}
+	require.Equal(t, expectedPaths, dequeuedPaths)
+}
+
+// TestDequeueByPathPreferringChild checks that the child nodes named after the preferred child
+// are dequeued from first, at any level below the selected node, before following round-robin order.
+func TestDequeueByPathPreferringChild(t *testing.T) {
+	root := NewTreeQueue("root")
+
+	cache := map[string]struct{}{}
+	for _, childPath := range []QueuePath{
+		{"tenant", "a"}, {"tenant", "b"}, {"tenant", "b"}, {"tenant", "c"},
+		{"tenant", "high", "b"}, {"tenant", "high", "a"},
+	} {
+		require.NoError(t, root.EnqueueBackByPath(childPath, makeQueueItemForChildPath(root, childPath, cache)))
+	}
+
+	var dequeuedPaths []QueuePath
+	for !root.IsEmpty() {
+		dequeuedPaths = append(dequeuedPaths, getChildPathFromQueueItem(root.DequeueByPathPreferringChild(QueuePath{"tenant"}, "b")))
+	}
+
+	expectedPaths := []QueuePath{
+		{"tenant", "b"},
+		{"tenant", "b"},
+		// once the preferred child node is empty, the round-robin order is followed,
+		// still preferring the child node in the next levels of the tree
+		{"tenant", "a"},
+		{"tenant", "c"},
+		{"tenant", "high", "b"},
+		{"tenant", "high", "a"},
+	}
+	require.Equal(t, expectedPaths, dequeuedPaths)
+}
+
 func TestNodeCannotDeleteItself(t *testing.T) {
 	root := NewTreeQueue("root")
 	require.False(t, root.deleteNode(QueuePath{}))
@@ -8,8 +8,10 @@ package scheduler
 import (
 	"context"
 	"flag"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,6 +19,7 @@ import (
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/grafana/dskit/cancellation"
+	"github.com/grafana/dskit/flagext"
 	"github.com/grafana/dskit/grpcclient"
 	"github.com/grafana/dskit/httpgrpc"
 	"github.com/grafana/dskit/middleware"
@@ -34,6 +37,7 @@ import (
 	"google.golang.org/grpc/status"
 
 	"github.com/grafana/mimir/pkg/frontend/v2/frontendv2pb"
+	querierapi "github.com/grafana/mimir/pkg/querier/api"
 	"github.com/grafana/mimir/pkg/scheduler/queue"
 	"github.com/grafana/mimir/pkg/scheduler/schedulerdiscovery"
 	"github.com/grafana/mimir/pkg/scheduler/schedulerpb"
@@ -44,6 +48,9 @@ import (
 
 var errEnqueuingRequestFailed = cancellation.NewErrorf("enqueuing request failed")
 var errFrontendDisconnected = cancellation.NewErrorf("frontend disconnected")
+var errSpreadQuerierWorkersRequiresQueueDimensions = errors.New("spreading querier workers across query components requires additional query queue dimensions to be enabled")
+var errInvalidQueryPriorityWeights = errors.New("invalid query priority weights: each entry must have the format <priority>:<weight>, with a weight greater than 0")
+var errDeprioritizedQueryPriorityWeight = fmt.Errorf("the %q estimated query cost limit action requires the %q query priority to be listed in -query-scheduler.query-priority-weights with a weight lower than the %q query priority", validation.EstimatedQueryCostLimitActionDeprioritize, querierapi.DeprioritizedQueryPriority, queue.DefaultQueryPriority)
 
 // Scheduler is responsible for queueing and dispatching queries to Queriers.
 type Scheduler struct {
@@ -57,8 +64,14 @@ type Scheduler struct {
 	connectedFrontendsMu sync.Mutex
 	connectedFrontends   map[string]*connectedFrontend
 
-	requestQueue *queue.RequestQueue
-	activeUsers  *util.ActiveUsersCleanupService
+	requestQueue         *queue.RequestQueue
+	activeUsers          *util.ActiveUsersCleanupService
+	queryPriorityWeights map[string]int
+
+	// querierWorkersByQueryComponent counts the connected querier workers preferring each of the
+	// queryComponentQueueDimensions, to spread them across the query components.
+	querierWorkersMu               sync.Mutex
+	querierWorkersByQueryComponent [len(queryComponentQueueDimensions)]int
 
 	pendingRequestsMu sync.Mutex
 	pendingRequests   map[requestKey]*queue.SchedulerRequest // request is kept in this map even after being dispatched to querier. It can still be canceled at that time.
@@ -79,6 +92,8 @@ type Scheduler struct {
 	connectedFrontendClients prometheus.GaugeFunc
 	queueDuration            *prometheus.HistogramVec
 	inflightRequests         prometheus.Summary
+	priorityQueueLength      *prometheus.GaugeVec
+	priorityQueueDuration    *prometheus.HistogramVec
 }
 
 type requestKey struct {
@@ -96,9 +111,11 @@ type connectedFrontend struct {
 }
 
 type Config struct {
-	MaxOutstandingPerTenant               int           `yaml:"max_outstanding_requests_per_tenant"`
-	AdditionalQueryQueueDimensionsEnabled bool          `yaml:"additional_query_queue_dimensions_enabled" category:"experimental"`
-	QuerierForgetDelay                    time.Duration `yaml:"querier_forget_delay" category:"experimental"`
+	MaxOutstandingPerTenant               int                    `yaml:"max_outstanding_requests_per_tenant"`
+	AdditionalQueryQueueDimensionsEnabled bool                   `yaml:"additional_query_queue_dimensions_enabled" category:"experimental"`
+	QueryPriorityWeights                  flagext.StringSliceCSV `yaml:"query_priority_weights" category:"experimental"`
+	SpreadQuerierWorkersByQueryComponent  bool                   `yaml:"spread_querier_workers_by_query_component" category:"experimental"`
+	QuerierForgetDelay                    time.Duration          `yaml:"querier_forget_delay" category:"experimental"`
 
 	GRPCClientConfig grpcclient.Config         `yaml:"grpc_client_config" doc:"description=This configures the gRPC client used to report errors back to the query-frontend."`
 	ServiceDiscovery schedulerdiscovery.Config `yaml:",inline"`
@@ -107,6 +124,8 @@ type Config struct {
 func (cfg *Config) RegisterFlags(f *flag.FlagSet, logger log.Logger) {
 	f.IntVar(&cfg.MaxOutstandingPerTenant, "query-scheduler.max-outstanding-requests-per-tenant", 100, "Maximum number of outstanding requests per tenant per query-scheduler. In-flight requests above this limit will fail with HTTP response status code 429.")
 	f.BoolVar(&cfg.AdditionalQueryQueueDimensionsEnabled, "query-scheduler.additional-query-queue-dimensions-enabled", false, "Enqueue query requests with additional queue dimensions to split tenant request queues into subqueues. This enables separate requests to proceed from a tenant's subqueues even when other subqueues are blocked on slow query requests. Must be set on both query-frontend and scheduler to take effect. (default false)")
+	f.Var(&cfg.QueryPriorityWeights, "query-scheduler.query-priority-weights", fmt.Sprintf("Comma-separated list of <priority>:<weight> entries, for example ruler:10,dashboard:5. When set, the queue of each tenant is split by the query priority, assigned by the query-frontend according to the tenant's query priority matchers or taken from the %s request header if -query-frontend.query-priority-header-enabled is set, and queries are dequeued with weighted fairness across priorities. Queries with a priority not in the list are enqueued with the %q priority, which has weight 1 unless listed.", querierapi.QueryPriorityHeader, queue.DefaultQueryPriority))
+	f.BoolVar(&cfg.SpreadQuerierWorkersByQueryComponent, "query-scheduler.spread-querier-workers-by-query-component", false, "Assign each querier worker connection a preferred query component, choosing among ingester, store-gateway and ingester-and-store-gateway the one preferred by the fewest connected querier workers, and have it dequeue the requests of a tenant expected to query its preferred component first. This prevents slow queries to a component, such as a slow store-gateway zone, from occupying all the querier workers. Requires -query-scheduler.additional-query-queue-dimensions-enabled.")
 	f.DurationVar(&cfg.QuerierForgetDelay, "query-scheduler.querier-forget-delay", 0, "If a querier disconnects without sending notification about graceful shutdown, the query-scheduler will keep the querier in the tenant's shard until the forget delay has passed. This feature is useful to reduce the blast radius when shuffle-sharding is enabled.")
 
 	cfg.GRPCClientConfig.RegisterFlagsWithPrefix("query-scheduler.grpc-client-config", f)
@@ -114,21 +133,72 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet, logger log.Logger) {
 }
 
 func (cfg *Config) Validate() error {
+	if cfg.SpreadQuerierWorkersByQueryComponent && !cfg.AdditionalQueryQueueDimensionsEnabled {
+		return errSpreadQuerierWorkersRequiresQueueDimensions
+	}
+	if _, err := parseQueryPriorityWeights(cfg.QueryPriorityWeights); err != nil {
+		return err
+	}
 	return cfg.ServiceDiscovery.Validate()
 }
 
+// ValidateLimits validates the per-tenant limits against the query-scheduler config. Deprioritizing the queries
+// exceeding the estimated query cost limits has no effect unless the deprioritized query priority is weighted
+// below the default query priority.
+func (cfg *Config) ValidateLimits(limits validation.Limits) error {
+	if limits.EstimatedQueryCostLimitAction != validation.EstimatedQueryCostLimitActionDeprioritize {
+		return nil
+	}
+
+	weights, err := parseQueryPriorityWeights(cfg.QueryPriorityWeights)
+	if err != nil {
+		return err
+	}
+	if weight, ok := weights[querierapi.DeprioritizedQueryPriority]; !ok || weight >= weights[queue.DefaultQueryPriority] {
+		return errDeprioritizedQueryPriorityWeight
+	}
+	return nil
+}
+
+// parseQueryPriorityWeights parses the <priority>:<weight> entries of the query priority weights.
+// The default query priority is always included, with weight 1 unless configured otherwise.
+// Returns nil if no query priority weights are configured.
+func parseQueryPriorityWeights(entries []string) (map[string]int, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	weights := map[string]int{queue.DefaultQueryPriority: 1}
+	for _, entry := range entries {
+		priority, weightStr, ok := strings.Cut(entry, ":")
+		if !ok || priority == "" {
+			return nil, errInvalidQueryPriorityWeights
+		}
+		weight, err := strconv.Atoi(weightStr)
+		if err != nil || weight <= 0 {
+			return nil, errInvalidQueryPriorityWeights
+		}
+		weights[priority] = weight
+	}
+	return weights, nil
+}
+
 // NewScheduler creates a new Scheduler.
 func NewScheduler(cfg Config, limits Limits, log log.Logger, registerer prometheus.Registerer) (*Scheduler, error) {
-	var err error
+	queryPriorityWeights, err := parseQueryPriorityWeights(cfg.QueryPriorityWeights)
+	if err != nil {
+		return nil, err
+	}
 
 	s := &Scheduler{
 		cfg:    cfg,
 		log:    log,
 		limits: limits,
 
-		pendingRequests:    map[requestKey]*queue.SchedulerRequest{},
-		connectedFrontends: map[string]*connectedFrontend{},
-		subservicesWatcher: services.NewFailureWatcher(),
+		queryPriorityWeights: queryPriorityWeights,
+		pendingRequests:      map[requestKey]*queue.SchedulerRequest{},
+		connectedFrontends:   map[string]*connectedFrontend{},
+		subservicesWatcher:   services.NewFailureWatcher(),
 	}
 
 	s.queueLength = promauto.With(registerer).NewGaugeVec(prometheus.GaugeOpts{
@@ -148,13 +218,22 @@ func NewScheduler(cfg Config, limits Limits, log log.Logger, registerer promethe
 		Name: "cortex_query_scheduler_enqueue_duration_seconds",
 		Help: "Time spent by requests waiting to join the queue or be rejected.",
 	})
-	s.requestQueue = queue.NewRequestQueue(s.log, cfg.MaxOutstandingPerTenant, cfg.AdditionalQueryQueueDimensionsEnabled, cfg.QuerierForgetDelay, s.queueLength, s.discardedRequests, enqueueDuration)
+	s.requestQueue = queue.NewRequestQueue(s.log, cfg.MaxOutstandingPerTenant, cfg.AdditionalQueryQueueDimensionsEnabled, queryPriorityWeights, cfg.QuerierForgetDelay, s.queueLength, s.discardedRequests, enqueueDuration)
 
 	s.queueDuration = promauto.With(registerer).NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "cortex_query_scheduler_queue_duration_seconds",
 		Help:    "Time spent by requests in queue before getting picked up by a querier.",
 		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
 	}, []string{"user", "additional_queue_dimensions"})
+	s.priorityQueueLength = promauto.With(registerer).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cortex_query_scheduler_priority_queue_length",
+		Help: "Number of queries in the queue by query priority. Only tracked when the query priority weights are set.",
+	}, []string{"priority"})
+	s.priorityQueueDuration = promauto.With(registerer).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cortex_query_scheduler_priority_queue_duration_seconds",
+		Help:    "Time spent by requests in queue before getting picked up by a querier, by query priority. Only tracked when the query priority weights are set.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"priority"})
 	s.connectedQuerierClients = promauto.With(registerer).NewGaugeFunc(prometheus.GaugeOpts{
 		Name: "cortex_query_scheduler_connected_querier_clients",
 		Help: "Number of querier worker clients currently connected to the query-scheduler.",
@@ -334,6 +413,7 @@ func (s *Scheduler) enqueueRequest(requestContext context.Context, frontendAddr
 		Request:                   msg.HttpRequest,
 		StatsEnabled:              msg.StatsEnabled,
 		AdditionalQueueDimensions: msg.AdditionalQueueDimensions,
+		Priority:                  s.queryPriority(msg.HttpRequest),
 	}
 
 	now := time.Now()
@@ -357,9 +437,32 @@ func (s *Scheduler) enqueueRequest(requestContext context.Context, frontendAddr
 		s.pendingRequestsMu.Lock()
 		s.pendingRequests[requestKey{frontendAddr: frontendAddr, queryID: msg.QueryID}] = req
 		s.pendingRequestsMu.Unlock()
+
+		if req.Priority != "" {
+			s.priorityQueueLength.WithLabelValues(req.Priority).Inc()
+		}
 	})
 }
 
+// queryPriority returns the query priority of the request, taken from the query priority header.
+// Requests without a configured query priority get the default query priority.
+// Returns an empty string if the query priority weights are not set.
+func (s *Scheduler) queryPriority(req *httpgrpc.HTTPRequest) string {
+	if len(s.queryPriorityWeights) == 0 {
+		return ""
+	}
+
+	for _, header := range req.GetHeaders() {
+		if !strings.EqualFold(header.Key, querierapi.QueryPriorityHeader) || len(header.Values) == 0 {
+			continue
+		}
+		if _, ok := s.queryPriorityWeights[header.Values[0]]; ok {
+			return header.Values[0]
+		}
+	}
+	return queue.DefaultQueryPriority
+}
+
 // This method doesn't do removal from the queue.
 func (s *Scheduler) cancelRequestAndRemoveFromPending(frontendAddr string, queryID uint64, reason string) {
 	s.pendingRequestsMu.Lock()
@@ -387,10 +490,12 @@ func (s *Scheduler) QuerierLoop(querier schedulerpb.SchedulerForQuerier_QuerierL
 	defer s.requestQueue.UnregisterQuerierConnection(querierID)
 
 	lastUserIndex := queue.FirstUser()
+	preferredQueueDimension := s.preferredQueueDimensionForQuerierWorker()
+	defer s.releaseQueueDimensionForQuerierWorker(preferredQueueDimension)
 
 	// In stopping state scheduler is not accepting new queries, but still dispatching queries in the queues.
 	for s.isRunningOrStopping() {
-		req, idx, err := s.requestQueue.GetNextRequestForQuerier(querier.Context(), lastUserIndex, querierID)
+		req, idx, err := s.requestQueue.GetNextRequestForQuerier(querier.Context(), lastUserIndex, querierID, preferredQueueDimension)
 		if err != nil {
 			// Return a more clear error if the queue is stopped because the query-scheduler is not running.
 			if errors.Is(err, queue.ErrStopped) && !s.isRunning() {
@@ -406,6 +511,10 @@ func (s *Scheduler) QuerierLoop(querier schedulerpb.SchedulerForQuerier_QuerierL
 		queueTime := time.Since(r.EnqueueTime)
 		additionalQueueDimensionLabels := strings.Join(r.AdditionalQueueDimensions, ":")
 		s.queueDuration.WithLabelValues(r.UserID, additionalQueueDimensionLabels).Observe(queueTime.Seconds())
+		if r.Priority != "" {
+			s.priorityQueueLength.WithLabelValues(r.Priority).Dec()
+			s.priorityQueueDuration.WithLabelValues(r.Priority).Observe(queueTime.Seconds())
+		}
 		r.QueueSpan.Finish()
 
 		/*
@@ -436,6 +545,54 @@ func (s *Scheduler) QuerierLoop(querier schedulerpb.SchedulerForQuerier_QuerierL
 	return schedulerpb.ErrSchedulerIsNotRunning
 }
 
+// queryComponentQueueDimensions are the additional queue dimensions the querier workers are spread across
+// when -query-scheduler.spread-querier-workers-by-query-component is enabled.
+var queryComponentQueueDimensions = [...]string{
+	queue.IngesterQueueDimension,
+	queue.StoreGatewayQueueDimension,
+	queue.IngesterAndStoreGatewayQueueDimension,
+}
+
+// preferredQueueDimensionForQuerierWorker returns the additional queue dimension a new querier worker connection
+// dequeues from first: the query component preferred by the fewest connected querier workers. Returns an empty string
+// if querier workers are not spread across query components. The returned dimension must be released with
+// releaseQueueDimensionForQuerierWorker when the querier worker disconnects.
+func (s *Scheduler) preferredQueueDimensionForQuerierWorker() string {
+	if !s.cfg.SpreadQuerierWorkersByQueryComponent {
+		return ""
+	}
+
+	s.querierWorkersMu.Lock()
+	defer s.querierWorkersMu.Unlock()
+
+	preferred := 0
+	for i, workers := range s.querierWorkersByQueryComponent {
+		if workers < s.querierWorkersByQueryComponent[preferred] {
+			preferred = i
+		}
+	}
+	s.querierWorkersByQueryComponent[preferred]++
+	return queryComponentQueueDimensions[preferred]
+}
+
+// releaseQueueDimensionForQuerierWorker releases the additional queue dimension returned by
+// preferredQueueDimensionForQuerierWorker for a querier worker which disconnected.
+func (s *Scheduler) releaseQueueDimensionForQuerierWorker(dimension string) {
+	if dimension == "" {
+		return
+	}
+
+	s.querierWorkersMu.Lock()
+	defer s.querierWorkersMu.Unlock()
+
+	for i, d := range queryComponentQueueDimensions {
+		if d == dimension {
+			s.querierWorkersByQueryComponent[i]--
+			return
+		}
+	}
+}
+
 func (s *Scheduler) NotifyQuerierShutdown(_ context.Context, req *schedulerpb.NotifyQuerierShutdownRequest) (*schedulerpb.NotifyQuerierShutdownResponse, error) {
 	level.Info(s.log).Log("msg", "received shutdown notification from querier", "querier", req.GetQuerierID())
 	s.requestQueue.NotifyQuerierShutdown(req.GetQuerierID())
@@ -572,7 +729,12 @@ func (s *Scheduler) running(ctx context.Context) error {
 // Close the Scheduler.
 func (s *Scheduler) stopping(_ error) error {
 	// This will also stop the requests queue, which stop accepting new requests and errors out any pending requests.
-	return services.StopManagerAndAwaitStopped(context.Background(), s.subservices)
+	err := services.StopManagerAndAwaitStopped(context.Background(), s.subservices)
+
+	// The pending requests have been discarded, without being dequeued.
+	s.priorityQueueLength.Reset()
+
+	return err
 }
 
 func (s *Scheduler) cleanupMetricsForInactiveUser(user string) {
@@ -32,10 +32,13 @@ import (
 	"google.golang.org/grpc/status"
 
 	"github.com/grafana/mimir/pkg/frontend/v2/frontendv2pb"
+	querierapi "github.com/grafana/mimir/pkg/querier/api"
+	"github.com/grafana/mimir/pkg/scheduler/queue"
 	"github.com/grafana/mimir/pkg/scheduler/schedulerpb"
 	"github.com/grafana/mimir/pkg/util"
 	"github.com/grafana/mimir/pkg/util/httpgrpcutil"
 	util_test "github.com/grafana/mimir/pkg/util/test"
+	"github.com/grafana/mimir/pkg/util/validation"
 )
 
 const testMaxOutstandingPerTenant = 5
@@ -494,6 +497,175 @@ func TestSchedulerQueueMetrics(t *testing.T) {
 	`), "cortex_query_scheduler_queue_length"))
 }
 
+func TestParseQueryPriorityWeights(t *testing.T) {
+	testCases := map[string]struct {
+		entries         []string
+		expectedWeights map[string]int
+		expectedErr     error
+	}{
+		"no entries": {
+			entries: nil,
+		},
+		"default priority weight added": {
+			entries:         []string{"ruler:10", "dashboard:5"},
+			expectedWeights: map[string]int{"ruler": 10, "dashboard": 5, queue.DefaultQueryPriority: 1},
+		},
+		"default priority weight overridden": {
+			entries:         []string{"ruler:10", "default:2"},
+			expectedWeights: map[string]int{"ruler": 10, queue.DefaultQueryPriority: 2},
+		},
+		"missing weight": {
+			entries:     []string{"ruler"},
+			expectedErr: errInvalidQueryPriorityWeights,
+		},
+		"missing priority": {
+			entries:     []string{":10"},
+			expectedErr: errInvalidQueryPriorityWeights,
+		},
+		"zero weight": {
+			entries:     []string{"ruler:0"},
+			expectedErr: errInvalidQueryPriorityWeights,
+		},
+		"invalid weight": {
+			entries:     []string{"ruler:high"},
+			expectedErr: errInvalidQueryPriorityWeights,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			weights, err := parseQueryPriorityWeights(testCase.entries)
+			require.ErrorIs(t, err, testCase.expectedErr)
+			require.Equal(t, testCase.expectedWeights, weights)
+		})
+	}
+}
+
+func TestConfig_ValidateLimits(t *testing.T) {
+	testCases := map[string]struct {
+		weights     []string
+		action      string
+		expectedErr error
+	}{
+		"queries exceeding the cost limits are rejected": {
+			action: validation.EstimatedQueryCostLimitActionReject,
+		},
+		"deprioritized query priority weighted below the default query priority": {
+			weights: []string{"default:2", "low:1"},
+			action:  validation.EstimatedQueryCostLimitActionDeprioritize,
+		},
+		"query priority weights not set": {
+			action:      validation.EstimatedQueryCostLimitActionDeprioritize,
+			expectedErr: errDeprioritizedQueryPriorityWeight,
+		},
+		"deprioritized query priority not listed": {
+			weights:     []string{"ruler:10"},
+			action:      validation.EstimatedQueryCostLimitActionDeprioritize,
+			expectedErr: errDeprioritizedQueryPriorityWeight,
+		},
+		"deprioritized query priority weighted as the default query priority": {
+			weights:     []string{"low:1"},
+			action:      validation.EstimatedQueryCostLimitActionDeprioritize,
+			expectedErr: errDeprioritizedQueryPriorityWeight,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{QueryPriorityWeights: testCase.weights}
+			limits := validation.Limits{EstimatedQueryCostLimitAction: testCase.action}
+			require.ErrorIs(t, cfg.ValidateLimits(limits), testCase.expectedErr)
+		})
+	}
+}
+
+func TestSchedulerQueryPriority(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.MaxOutstandingPerTenant = testMaxOutstandingPerTenant
+	cfg.QueryPriorityWeights = []string{"ruler:10", "dashboard:5"}
+
+	s, err := NewScheduler(cfg, &limits{queriers: 2}, log.NewNopLogger(), reg)
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), s))
+	t.Cleanup(func() {
+		_ = services.StopAndAwaitTerminated(context.Background(), s)
+	})
+
+	// The enqueued requests are expected to carry the span of the frontend's request.
+	_, ctx := opentracing.StartSpanFromContext(context.Background(), "test")
+
+	for queryID, priority := range []string{"ruler", "ruler", "dashboard", "unknown", ""} {
+		req := &httpgrpc.HTTPRequest{Method: "GET", Url: "/hello"}
+		if priority != "" {
+			req.Headers = []*httpgrpc.Header{{Key: querierapi.QueryPriorityHeader, Values: []string{priority}}}
+		}
+		require.NoError(t, s.enqueueRequest(ctx, "frontend-12345", &schedulerpb.FrontendToScheduler{
+			Type:        schedulerpb.ENQUEUE,
+			QueryID:     uint64(queryID),
+			UserID:      "test",
+			HttpRequest: req,
+		}))
+	}
+
+	require.NoError(t, promtest.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_query_scheduler_priority_queue_length Number of queries in the queue by query priority. Only tracked when the query priority weights are set.
+		# TYPE cortex_query_scheduler_priority_queue_length gauge
+		cortex_query_scheduler_priority_queue_length{priority="dashboard"} 1
+		cortex_query_scheduler_priority_queue_length{priority="default"} 2
+		cortex_query_scheduler_priority_queue_length{priority="ruler"} 2
+	`), "cortex_query_scheduler_priority_queue_length"))
+
+	// The queries still in the queue when the scheduler stops are not counted anymore.
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), s))
+	require.NoError(t, promtest.GatherAndCompare(reg, strings.NewReader(""), "cortex_query_scheduler_priority_queue_length"))
+}
+
+func TestSchedulerSpreadQuerierWorkersByQueryComponent(t *testing.T) {
+	t.Run("requires additional query queue dimensions", func(t *testing.T) {
+		cfg := Config{}
+		flagext.DefaultValues(&cfg)
+		cfg.SpreadQuerierWorkersByQueryComponent = true
+		require.ErrorIs(t, cfg.Validate(), errSpreadQuerierWorkersRequiresQueueDimensions)
+
+		cfg.AdditionalQueryQueueDimensionsEnabled = true
+		require.NoError(t, cfg.Validate())
+	})
+
+	t.Run("querier workers rotate between query components", func(t *testing.T) {
+		s := &Scheduler{cfg: Config{AdditionalQueryQueueDimensionsEnabled: true, SpreadQuerierWorkersByQueryComponent: true}}
+
+		var preferred []string
+		for i := 0; i < 4; i++ {
+			preferred = append(preferred, s.preferredQueueDimensionForQuerierWorker())
+		}
+		require.Equal(t, []string{queue.IngesterQueueDimension, queue.StoreGatewayQueueDimension, queue.IngesterAndStoreGatewayQueueDimension, queue.IngesterQueueDimension}, preferred)
+	})
+
+	t.Run("new querier workers prefer the query component left by disconnected querier workers", func(t *testing.T) {
+		s := &Scheduler{cfg: Config{AdditionalQueryQueueDimensionsEnabled: true, SpreadQuerierWorkersByQueryComponent: true}}
+
+		for i := 0; i < 6; i++ {
+			s.preferredQueueDimensionForQuerierWorker()
+		}
+
+		// Both the querier workers preferring the store-gateways disconnect.
+		s.releaseQueueDimensionForQuerierWorker(queue.StoreGatewayQueueDimension)
+		s.releaseQueueDimensionForQuerierWorker(queue.StoreGatewayQueueDimension)
+
+		require.Equal(t, queue.StoreGatewayQueueDimension, s.preferredQueueDimensionForQuerierWorker())
+		require.Equal(t, queue.StoreGatewayQueueDimension, s.preferredQueueDimensionForQuerierWorker())
+		require.Equal(t, queue.IngesterQueueDimension, s.preferredQueueDimensionForQuerierWorker())
+	})
+
+	t.Run("querier workers have no preference when disabled", func(t *testing.T) {
+		s := &Scheduler{cfg: Config{AdditionalQueryQueueDimensionsEnabled: true}}
+		require.Equal(t, "", s.preferredQueueDimensionForQuerierWorker())
+	})
+}
+
 func TestSchedulerQuerierMetrics(t *testing.T) {
 	reg := prometheus.NewPedanticRegistry()
 	_, _, querierClient := setupScheduler(t, reg)
@@ -9,6 +9,9 @@ import (
 	"slices"
 	"strings"
 	"time"
+
+	"github.com/grafana/dskit/crypto/tls"
+	"github.com/grafana/dskit/flagext"
 )
 
 const (
@@ -16,14 +19,22 @@ const (
 	consumeFromStart      = "start"
 	consumeFromEnd        = "end"
 	consumeFromTimestamp  = "timestamp"
+
+	saslMechanismPlain       = "PLAIN"
+	saslMechanismScramSHA256 = "SCRAM-SHA-256"
+	saslMechanismScramSHA512 = "SCRAM-SHA-512"
+	saslMechanismOAuthBearer = "OAUTHBEARER"
 )
 
 var (
 	ErrMissingKafkaAddress    = errors.New("the Kafka address has not been configured")
 	ErrMissingKafkaTopic      = errors.New("the Kafka topic has not been configured")
 	ErrInvalidConsumePosition = errors.New("the configured consume position is invalid")
+	ErrInvalidSASLMechanism   = errors.New("the configured Kafka SASL mechanism is invalid")
+	ErrMissingSASLCredentials = errors.New("the Kafka SASL credentials have not been configured")
 
 	consumeFromPositionOptions = []string{consumeFromLastOffset, consumeFromStart, consumeFromEnd, consumeFromTimestamp}
+	saslMechanismOptions       = []string{saslMechanismPlain, saslMechanismScramSHA256, saslMechanismScramSHA512, saslMechanismOAuthBearer}
 )
 
 type Config struct {
@@ -61,6 +72,14 @@ type KafkaConfig struct {
 	DialTimeout  time.Duration `yaml:"dial_timeout"`
 	WriteTimeout time.Duration `yaml:"write_timeout"`
 
+	TLSEnabled bool             `yaml:"tls_enabled" category:"advanced"`
+	TLS        tls.ClientConfig `yaml:",inline"`
+
+	SASLMechanism  string         `yaml:"sasl_mechanism" category:"advanced"`
+	SASLUsername   string         `yaml:"sasl_username" category:"advanced"`
+	SASLPassword   flagext.Secret `yaml:"sasl_password" category:"advanced"`
+	SASLOAuthToken flagext.Secret `yaml:"sasl_oauth_token" category:"advanced"`
+
 	LastProducedOffsetPollInterval time.Duration `yaml:"last_produced_offset_poll_interval"`
 	LastProducedOffsetRetryTimeout time.Duration `yaml:"last_produced_offset_retry_timeout"`
 
@@ -83,6 +102,14 @@ func (cfg *KafkaConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet)
 	f.DurationVar(&cfg.DialTimeout, prefix+".dial-timeout", 2*time.Second, "The maximum time allowed to open a connection to a Kafka broker.")
 	f.DurationVar(&cfg.WriteTimeout, prefix+".write-timeout", 10*time.Second, "How long to wait for an incoming write request to be successfully committed to the Kafka backend.")
 
+	f.BoolVar(&cfg.TLSEnabled, prefix+".tls-enabled", false, "Enable TLS for the connections to the Kafka brokers.")
+	cfg.TLS.RegisterFlagsWithPrefix(prefix, f)
+
+	f.StringVar(&cfg.SASLMechanism, prefix+".sasl-mechanism", "", fmt.Sprintf("The SASL mechanism used to authenticate to the Kafka brokers. Supported options: %s. SASL authentication is disabled when empty.", strings.Join(saslMechanismOptions, ", ")))
+	f.StringVar(&cfg.SASLUsername, prefix+".sasl-username", "", fmt.Sprintf("The username used to authenticate to the Kafka brokers with the %s, %s and %s SASL mechanisms.", saslMechanismPlain, saslMechanismScramSHA256, saslMechanismScramSHA512))
+	f.Var(&cfg.SASLPassword, prefix+".sasl-password", fmt.Sprintf("The password used to authenticate to the Kafka brokers with the %s, %s and %s SASL mechanisms.", saslMechanismPlain, saslMechanismScramSHA256, saslMechanismScramSHA512))
+	f.Var(&cfg.SASLOAuthToken, prefix+".sasl-oauth-token", fmt.Sprintf("The OAuth token used to authenticate to the Kafka brokers with the %s SASL mechanism.", saslMechanismOAuthBearer))
+
 	f.DurationVar(&cfg.LastProducedOffsetPollInterval, prefix+".last-produced-offset-poll-interval", time.Second, "How frequently to poll the last produced offset, used to enforce strong read consistency.")
 	f.DurationVar(&cfg.LastProducedOffsetRetryTimeout, prefix+".last-produced-offset-retry-timeout", 10*time.Second, "How long to retry a failed request to get the last produced offset.")
 
@@ -114,6 +141,20 @@ func (cfg *KafkaConfig) Validate() error {
 		}
 	}
 
+	switch cfg.SASLMechanism {
+	case "":
+	case saslMechanismPlain, saslMechanismScramSHA256, saslMechanismScramSHA512:
+		if cfg.SASLUsername == "" || cfg.SASLPassword.String() == "" {
+			return fmt.Errorf("%w: the %s SASL mechanism requires a username and a password", ErrMissingSASLCredentials, cfg.SASLMechanism)
+		}
+	case saslMechanismOAuthBearer:
+		if cfg.SASLOAuthToken.String() == "" {
+			return fmt.Errorf("%w: the %s SASL mechanism requires an OAuth token", ErrMissingSASLCredentials, cfg.SASLMechanism)
+		}
+	default:
+		return ErrInvalidSASLMechanism
+	}
+
 	return nil
 }
 
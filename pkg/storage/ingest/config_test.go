@@ -68,6 +68,44 @@ func TestConfig_Validate(t *testing.T) {
 			},
 			expectedErr: ErrInvalidConsumePosition,
 		},
+		"should fail if ingest storage is enabled and SASL mechanism is invalid": {
+			setup: func(cfg *Config) {
+				cfg.Enabled = true
+				cfg.KafkaConfig.Address = "localhost"
+				cfg.KafkaConfig.Topic = "test"
+				cfg.KafkaConfig.SASLMechanism = "GSSAPI"
+			},
+			expectedErr: ErrInvalidSASLMechanism,
+		},
+		"should fail if ingest storage is enabled and SASL mechanism requires a password which is not configured": {
+			setup: func(cfg *Config) {
+				cfg.Enabled = true
+				cfg.KafkaConfig.Address = "localhost"
+				cfg.KafkaConfig.Topic = "test"
+				cfg.KafkaConfig.SASLMechanism = saslMechanismScramSHA512
+				cfg.KafkaConfig.SASLUsername = "user"
+			},
+			expectedErr: ErrMissingSASLCredentials,
+		},
+		"should fail if ingest storage is enabled and SASL mechanism requires an OAuth token which is not configured": {
+			setup: func(cfg *Config) {
+				cfg.Enabled = true
+				cfg.KafkaConfig.Address = "localhost"
+				cfg.KafkaConfig.Topic = "test"
+				cfg.KafkaConfig.SASLMechanism = saslMechanismOAuthBearer
+			},
+			expectedErr: ErrMissingSASLCredentials,
+		},
+		"should pass if ingest storage is enabled and SASL credentials are configured": {
+			setup: func(cfg *Config) {
+				cfg.Enabled = true
+				cfg.KafkaConfig.Address = "localhost"
+				cfg.KafkaConfig.Topic = "test"
+				cfg.KafkaConfig.SASLMechanism = saslMechanismPlain
+				cfg.KafkaConfig.SASLUsername = "user"
+				cfg.KafkaConfig.SASLPassword = flagext.SecretWithValue("password")
+			},
+		},
 	}
 
 	for testName, testData := range tests {
@@ -387,8 +387,12 @@ func (r *PartitionReader) recordFetchesMetrics(fetches kgo.Fetches, delayObserve
 func (r *PartitionReader) newKafkaReader(at kgo.Offset) (*kgo.Client, error) {
 	const fetchMaxBytes = 100_000_000
 
-	opts := append(
-		commonKafkaClientOptions(r.kafkaCfg, r.metrics.kprom, r.logger),
+	opts, err := commonKafkaClientOptions(r.kafkaCfg, r.metrics.kprom, r.logger)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating kafka client options")
+	}
+
+	opts = append(opts,
 		kgo.ConsumePartitions(map[string]map[int32]kgo.Offset{
 			r.kafkaCfg.Topic: {r.partitionID: at},
 		}),
@@ -426,7 +430,12 @@ func (r *PartitionReader) getStartOffset(ctx context.Context) (startOffset, last
 	// We use an ephemeral client to fetch the offset and then create a new client with this offset.
 	// The reason for this is that changing the offset of an existing client requires to have used this client for fetching at least once.
 	// We don't want to do noop fetches just to warm up the client, so we create a new client instead.
-	cl, err := kgo.NewClient(commonKafkaClientOptions(r.kafkaCfg, r.metrics.kprom, r.logger)...)
+	opts, err := commonKafkaClientOptions(r.kafkaCfg, r.metrics.kprom, r.logger)
+	if err != nil {
+		return 0, -1, fmt.Errorf("unable to create bootstrap client options: %w", err)
+	}
+
+	cl, err := kgo.NewClient(opts...)
 	if err != nil {
 		return 0, -1, fmt.Errorf("unable to create bootstrap client: %w", err)
 	}
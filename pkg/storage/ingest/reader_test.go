@@ -1183,7 +1183,9 @@ func TestPartitionCommitter(t *testing.T) {
 
 		logger := testutil.NewLogger(t)
 		cfg := createTestKafkaConfig(clusterAddr, topicName)
-		client, err := kgo.NewClient(commonKafkaClientOptions(cfg, nil, logger)...)
+		opts, err := commonKafkaClientOptions(cfg, nil, logger)
+		require.NoError(t, err)
+		client, err := kgo.NewClient(opts...)
 		require.NoError(t, err)
 		t.Cleanup(client.Close)
 
@@ -1251,7 +1253,9 @@ func TestPartitionCommitter_commit(t *testing.T) {
 		_, clusterAddr := testkafka.CreateCluster(t, partitionID+1, topicName)
 
 		cfg := createTestKafkaConfig(clusterAddr, topicName)
-		client, err := kgo.NewClient(commonKafkaClientOptions(cfg, nil, log.NewNopLogger())...)
+		opts, err := commonKafkaClientOptions(cfg, nil, log.NewNopLogger())
+		require.NoError(t, err)
+		client, err := kgo.NewClient(opts...)
 		require.NoError(t, err)
 		t.Cleanup(client.Close)
 
@@ -1291,7 +1295,9 @@ func TestPartitionCommitter_commit(t *testing.T) {
 		})
 
 		cfg := createTestKafkaConfig(clusterAddr, topicName)
-		client, err := kgo.NewClient(commonKafkaClientOptions(cfg, nil, log.NewNopLogger())...)
+		opts, err := commonKafkaClientOptions(cfg, nil, log.NewNopLogger())
+		require.NoError(t, err)
+		client, err := kgo.NewClient(opts...)
 		require.NoError(t, err)
 		t.Cleanup(client.Close)
 
@@ -16,6 +16,10 @@ import (
 	"github.com/twmb/franz-go/pkg/kadm"
 	"github.com/twmb/franz-go/pkg/kgo"
 	"github.com/twmb/franz-go/pkg/kmsg"
+	"github.com/twmb/franz-go/pkg/sasl"
+	"github.com/twmb/franz-go/pkg/sasl/oauth"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
 	"github.com/twmb/franz-go/plugin/kotel"
 	"github.com/twmb/franz-go/plugin/kprom"
 )
@@ -41,7 +45,7 @@ func IngesterPartitionID(ingesterID string) (int32, error) {
 	return int32(ingesterSeq), nil
 }
 
-func commonKafkaClientOptions(cfg KafkaConfig, metrics *kprom.Metrics, logger log.Logger) []kgo.Opt {
+func commonKafkaClientOptions(cfg KafkaConfig, metrics *kprom.Metrics, logger log.Logger) ([]kgo.Opt, error) {
 	opts := []kgo.Opt{
 		kgo.ClientID(cfg.ClientID),
 		kgo.SeedBrokers(cfg.Address),
@@ -90,7 +94,36 @@ func commonKafkaClientOptions(cfg KafkaConfig, metrics *kprom.Metrics, logger lo
 		opts = append(opts, kgo.WithHooks(metrics))
 	}
 
-	return opts
+	if cfg.TLSEnabled {
+		tlsCfg, err := cfg.TLS.GetTLSConfig()
+		if err != nil {
+			return nil, errors.Wrap(err, "creating Kafka TLS config")
+		}
+		opts = append(opts, kgo.DialTLSConfig(tlsCfg))
+	}
+
+	if mechanism := kafkaSASLMechanism(cfg); mechanism != nil {
+		opts = append(opts, kgo.SASL(mechanism))
+	}
+
+	return opts, nil
+}
+
+// kafkaSASLMechanism returns the SASL mechanism used to authenticate to the Kafka brokers, or nil if SASL
+// authentication is disabled.
+func kafkaSASLMechanism(cfg KafkaConfig) sasl.Mechanism {
+	switch cfg.SASLMechanism {
+	case saslMechanismPlain:
+		return plain.Auth{User: cfg.SASLUsername, Pass: cfg.SASLPassword.String()}.AsMechanism()
+	case saslMechanismScramSHA256:
+		return scram.Auth{User: cfg.SASLUsername, Pass: cfg.SASLPassword.String()}.AsSha256Mechanism()
+	case saslMechanismScramSHA512:
+		return scram.Auth{User: cfg.SASLUsername, Pass: cfg.SASLPassword.String()}.AsSha512Mechanism()
+	case saslMechanismOAuthBearer:
+		return oauth.Auth{Token: cfg.SASLOAuthToken.String()}.AsMechanism()
+	default:
+		return nil
+	}
 }
 
 // resultPromise is a simple utility to have multiple goroutines waiting for a result from another one.
@@ -143,7 +176,13 @@ func setDefaultNumberOfPartitionsForAutocreatedTopics(cfg KafkaConfig, logger lo
 		return
 	}
 
-	cl, err := kgo.NewClient(commonKafkaClientOptions(cfg, nil, logger)...)
+	opts, err := commonKafkaClientOptions(cfg, nil, logger)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to create kafka client options", "err", err)
+		return
+	}
+
+	cl, err := kgo.NewClient(opts...)
 	if err != nil {
 		level.Error(logger).Log("msg", "failed to create kafka client", "err", err)
 		return
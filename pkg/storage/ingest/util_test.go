@@ -4,16 +4,26 @@ package ingest
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"errors"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-kit/log"
+	"github.com/grafana/dskit/flagext"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
 	"github.com/twmb/franz-go/pkg/kmsg"
+
+	"github.com/grafana/mimir/integration/ca"
+	"github.com/grafana/mimir/pkg/util/testkafka"
 )
 
 func TestIngesterPartitionID(t *testing.T) {
@@ -158,3 +168,183 @@ func TestSetDefaultNumberOfPartitionsForAutocreatedTopics(t *testing.T) {
 
 	setDefaultNumberOfPartitionsForAutocreatedTopics(cfg, log.NewNopLogger())
 }
+
+func TestCommonKafkaClientOptions_TLS(t *testing.T) {
+	const topicName = "test"
+
+	certsDir := t.TempDir()
+	caCertPath := filepath.Join(certsDir, "ca.crt")
+	serverCertPath, serverKeyPath := filepath.Join(certsDir, "server.crt"), filepath.Join(certsDir, "server.key")
+	clientCertPath, clientKeyPath := filepath.Join(certsDir, "client.crt"), filepath.Join(certsDir, "client.key")
+
+	testCA := ca.New("Test")
+	require.NoError(t, testCA.WriteCACertificate(caCertPath))
+	require.NoError(t, testCA.WriteCertificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "kafka"},
+		DNSNames:    []string{"kafka"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, serverCertPath, serverKeyPath))
+	require.NoError(t, testCA.WriteCertificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "client"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, clientCertPath, clientKeyPath))
+
+	serverCert, err := tls.LoadX509KeyPair(serverCertPath, serverKeyPath)
+	require.NoError(t, err)
+	caCert, err := os.ReadFile(caCertPath)
+	require.NoError(t, err)
+	clientCAs := x509.NewCertPool()
+	require.True(t, clientCAs.AppendCertsFromPEM(caCert))
+
+	_, clusterAddr := testkafka.CreateCluster(t, 1, topicName, kfake.TLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}))
+
+	tests := map[string]struct {
+		setup       func(cfg *KafkaConfig)
+		expectedErr bool
+	}{
+		"should connect with the CA, client certificate and server name configured": {
+			setup: func(cfg *KafkaConfig) {
+				cfg.TLS.CAPath = caCertPath
+				cfg.TLS.CertPath, cfg.TLS.KeyPath = clientCertPath, clientKeyPath
+				cfg.TLS.ServerName = "kafka"
+			},
+		},
+		"should connect skipping the verification of the server certificate": {
+			setup: func(cfg *KafkaConfig) {
+				cfg.TLS.CertPath, cfg.TLS.KeyPath = clientCertPath, clientKeyPath
+				cfg.TLS.InsecureSkipVerify = true
+			},
+		},
+		"should fail to connect if the server certificate is not trusted": {
+			setup: func(cfg *KafkaConfig) {
+				cfg.TLS.CertPath, cfg.TLS.KeyPath = clientCertPath, clientKeyPath
+				cfg.TLS.ServerName = "kafka"
+			},
+			expectedErr: true,
+		},
+		"should fail to connect if the client certificate is not configured": {
+			setup: func(cfg *KafkaConfig) {
+				cfg.TLS.CAPath = caCertPath
+				cfg.TLS.ServerName = "kafka"
+			},
+			expectedErr: true,
+		},
+		"should fail to connect if TLS is disabled": {
+			setup: func(cfg *KafkaConfig) {
+				cfg.TLSEnabled = false
+			},
+			expectedErr: true,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			cfg := createTestKafkaConfig(clusterAddr, topicName)
+			cfg.TLSEnabled = true
+			testData.setup(&cfg)
+
+			assertKafkaClientConnects(t, cfg, !testData.expectedErr)
+		})
+	}
+
+	t.Run("should fail to create the options if the TLS config is invalid", func(t *testing.T) {
+		cfg := createTestKafkaConfig(clusterAddr, topicName)
+		cfg.TLSEnabled = true
+		cfg.TLS.CertPath = clientCertPath
+
+		_, err := commonKafkaClientOptions(cfg, nil, log.NewNopLogger())
+		require.Error(t, err)
+	})
+}
+
+func TestCommonKafkaClientOptions_SASL(t *testing.T) {
+	const topicName = "test"
+
+	_, clusterAddr := testkafka.CreateCluster(t, 1, topicName,
+		kfake.EnableSASL(),
+		kfake.Superuser(saslMechanismPlain, "plain-user", "plain-password"),
+		kfake.Superuser(saslMechanismScramSHA256, "scram-256-user", "scram-256-password"),
+		kfake.Superuser(saslMechanismScramSHA512, "scram-512-user", "scram-512-password"),
+	)
+
+	tests := map[string]struct {
+		mechanism, username, password string
+		expectedErr                   bool
+	}{
+		"should authenticate with PLAIN": {
+			mechanism: saslMechanismPlain,
+			username:  "plain-user",
+			password:  "plain-password",
+		},
+		"should authenticate with SCRAM-SHA-256": {
+			mechanism: saslMechanismScramSHA256,
+			username:  "scram-256-user",
+			password:  "scram-256-password",
+		},
+		"should authenticate with SCRAM-SHA-512": {
+			mechanism: saslMechanismScramSHA512,
+			username:  "scram-512-user",
+			password:  "scram-512-password",
+		},
+		"should fail to authenticate with a wrong password": {
+			mechanism:   saslMechanismScramSHA256,
+			username:    "scram-256-user",
+			password:    "wrong",
+			expectedErr: true,
+		},
+		"should fail to authenticate with the mechanism of another user": {
+			mechanism:   saslMechanismScramSHA512,
+			username:    "scram-256-user",
+			password:    "scram-256-password",
+			expectedErr: true,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			cfg := createTestKafkaConfig(clusterAddr, topicName)
+			cfg.SASLMechanism = testData.mechanism
+			cfg.SASLUsername = testData.username
+			require.NoError(t, cfg.SASLPassword.Set(testData.password))
+
+			assertKafkaClientConnects(t, cfg, !testData.expectedErr)
+		})
+	}
+
+	t.Run("should configure the OAUTHBEARER mechanism", func(t *testing.T) {
+		cfg := createTestKafkaConfig(clusterAddr, topicName)
+		cfg.SASLMechanism = saslMechanismOAuthBearer
+		cfg.SASLOAuthToken = flagext.SecretWithValue("token")
+
+		mechanism := kafkaSASLMechanism(cfg)
+		require.NotNil(t, mechanism)
+		assert.Equal(t, saslMechanismOAuthBearer, mechanism.Name())
+	})
+}
+
+// assertKafkaClientConnects asserts whether a client created with the common options for cfg can send requests to
+// the Kafka cluster.
+func assertKafkaClientConnects(t *testing.T, cfg KafkaConfig, expected bool) {
+	t.Helper()
+
+	opts, err := commonKafkaClientOptions(cfg, nil, log.NewNopLogger())
+	require.NoError(t, err)
+
+	client, err := kgo.NewClient(opts...)
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	err = client.Ping(ctx)
+	if expected {
+		require.NoError(t, err)
+	} else {
+		require.Error(t, err)
+	}
+}
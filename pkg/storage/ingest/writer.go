@@ -189,8 +189,12 @@ func (w *Writer) newKafkaWriter(partitionID int32) (*kgo.Client, error) {
 		kprom.Registerer(prometheus.WrapRegistererWith(prometheus.Labels{"partition": strconv.Itoa(int(partitionID))}, w.registerer)),
 		kprom.FetchAndProduceDetail(kprom.Batches, kprom.Records, kprom.CompressedBytes, kprom.UncompressedBytes))
 
-	opts := append(
-		commonKafkaClientOptions(w.kafkaCfg, metrics, logger),
+	opts, err := commonKafkaClientOptions(w.kafkaCfg, metrics, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(opts,
 		kgo.RequiredAcks(kgo.AllISRAcks()),
 		kgo.DefaultProduceTopic(w.kafkaCfg.Topic),
 
@@ -83,112 +83,119 @@ func TestCases(metricSizes []int) []BenchCase {
 			Expr:  "rate(a_X[1m])",
 			Steps: 10000,
 		},
-		//// Holt-Winters and long ranges.
-		//{
-		//	Expr: "holt_winters(a_X[1d], 0.3, 0.3)",
-		//},
-		//{
-		//	Expr: "changes(a_X[1d])",
-		//},
+		// Holt-Winters and long ranges.
+		{
+			Expr: "holt_winters(a_X[1d], 0.3, 0.3)",
+		},
+		{
+			Expr: "changes(a_X[1d])",
+		},
 		{
 			Expr: "rate(a_X[1d])",
 		},
-		//{
-		//	Expr: "absent_over_time(a_X[1d])",
-		//},
+		{
+			Expr: "absent_over_time(a_X[1d])",
+		},
 		//// Unary operators.
 		//{
 		//	Expr: "-a_X",
 		//},
-		//// Binary operators.
-		//{
-		//	Expr: "a_X - b_X",
-		//},
-		//{
-		//	Expr:  "a_X - b_X",
-		//	Steps: 10000,
-		//},
-		//{
-		//	Expr: "a_X and b_X{l=~'.*[0-4]$'}",
-		//},
-		//{
-		//	Expr: "a_X or b_X{l=~'.*[0-4]$'}",
-		//},
-		//{
-		//	Expr: "a_X unless b_X{l=~'.*[0-4]$'}",
-		//},
-		//{
-		//	Expr: "a_X and b_X{l='notfound'}",
-		//},
-		//// Simple functions.
-		//{
-		//	Expr: "abs(a_X)",
-		//},
-		//{
-		//	Expr: "label_replace(a_X, 'l2', '$1', 'l', '(.*)')",
-		//},
-		//{
-		//	Expr: "label_join(a_X, 'l2', '-', 'l', 'l')",
-		//},
+		// Binary operators.
+		{
+			Expr: "a_X - b_X",
+		},
+		{
+			Expr:  "a_X - b_X",
+			Steps: 10000,
+		},
+		{
+			Expr: "a_X and b_X{l=~'.*[0-4]$'}",
+		},
+		{
+			Expr: "a_X or b_X{l=~'.*[0-4]$'}",
+		},
+		{
+			Expr: "a_X unless b_X{l=~'.*[0-4]$'}",
+		},
+		{
+			Expr: "a_X and b_X{l='notfound'}",
+		},
+		// Simple functions.
+		{
+			Expr: "abs(a_X)",
+		},
+		{
+			Expr: "label_replace(a_X, 'l2', '$1', 'l', '(.*)')",
+		},
+		{
+			Expr: "label_join(a_X, 'l2', '-', 'l', 'l')",
+		},
 		// Simple aggregations.
 		{
 			Expr: "sum(a_X)",
 		},
-		//{
-		//	Expr: "sum without (l)(h_X)",
-		//},
-		//{
-		//	Expr: "sum without (le)(h_X)",
-		//},
+		{
+			Expr: "sum without (l)(h_X)",
+		},
+		{
+			Expr: "sum without (le)(h_X)",
+		},
 		{
 			Expr: "sum by (l)(h_X)",
 		},
 		{
 			Expr: "sum by (le)(h_X)",
 		},
-		//{
-		//	Expr: "count_values('value', h_X)",
-		//  Steps: 100,
-		//},
-		//{
-		//	Expr: "topk(1, a_X)",
-		//},
-		//{
-		//	Expr: "topk(5, a_X)",
-		//},
-		//// Combinations.
-		//{
-		//	Expr: "rate(a_X[1m]) + rate(b_X[1m])",
-		//},
+		{
+			Expr:  "count_values('value', h_X)",
+			Steps: 100,
+		},
+		{
+			Expr: "topk(1, a_X)",
+		},
+		{
+			Expr: "topk(5, a_X)",
+		},
+		// Combinations.
+		{
+			Expr: "rate(a_X[1m]) + rate(b_X[1m])",
+		},
 		{
 			Expr: "sum by (le)(rate(h_X[1m]))",
 		},
-		//{
-		//	Expr: "sum without (l)(rate(a_X[1m]))",
-		//},
-		//{
-		//	Expr: "sum without (l)(rate(a_X[1m])) / sum without (l)(rate(b_X[1m]))",
-		//},
-		//{
-		//	Expr: "histogram_quantile(0.9, rate(h_X[5m]))",
-		//},
-		//// Many-to-one join.
-		//{
-		//	Expr: "a_X + on(l) group_right a_one",
-		//},
-		//// Label compared to blank string.
-		//{
-		//	Expr:  "count({__name__!=\"\"})",
-		//	Steps: 1,
-		//},
-		//{
-		//	Expr:  "count({__name__!=\"\",l=\"\"})",
-		//	Steps: 1,
-		//},
-		//// Functions which have special handling inside eval()
-		//{
-		//	Expr: "timestamp(a_X)",
-		//},
+		{
+			Expr: "sum without (l)(rate(a_X[1m]))",
+		},
+		{
+			Expr: "sum without (l)(rate(a_X[1m])) / sum without (l)(rate(b_X[1m]))",
+		},
+		{
+			Expr: "histogram_quantile(0.9, rate(h_X[5m]))",
+		},
+		// Subqueries.
+		{
+			Expr: "sum_over_time(a_X[10m:3m])",
+		},
+		{
+			Expr: "max_over_time(rate(a_X[1m])[10m:1m])",
+		},
+		// Many-to-one join.
+		{
+			Expr: "a_X + on(l) group_right a_one",
+		},
+		// Label compared to blank string.
+		{
+			Expr:  "count({__name__!=\"\"})",
+			Steps: 1,
+		},
+		{
+			Expr:  "count({__name__!=\"\",l=\"\"})",
+			Steps: 1,
+		},
+		// Functions which have special handling inside eval()
+		{
+			Expr: "timestamp(a_X)",
+		},
 	}
 
 	// X in an expr will be replaced by different metric sizes.
@@ -11,13 +11,16 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/storage"
+
+	"github.com/grafana/mimir/pkg/querier/stats"
 )
 
 const defaultLookbackDelta = 5 * time.Minute // This should be the same value as github.com/prometheus/prometheus/promql.defaultLookbackDelta.
 
-func NewEngine(opts promql.EngineOpts) (promql.QueryEngine, error) {
+func NewEngine(opts promql.EngineOpts, limitsProvider QueryLimitsProvider, metrics *stats.QueryMetrics) (promql.QueryEngine, error) {
 	lookbackDelta := opts.LookbackDelta
 	if lookbackDelta == 0 {
 		lookbackDelta = defaultLookbackDelta
@@ -36,19 +39,26 @@ func NewEngine(opts promql.EngineOpts) (promql.QueryEngine, error) {
 	}
 
 	return &Engine{
-		lookbackDelta: lookbackDelta,
+		lookbackDelta:                         lookbackDelta,
+		noStepSubqueryIntervalFn:              opts.NoStepSubqueryIntervalFn,
+		limitsProvider:                        limitsProvider,
+		queriesRejectedDueToMemoryConsumption: metrics.QueriesRejectedTotal.WithLabelValues(stats.RejectReasonMaxEstimatedQueryMemoryConsumption),
 	}, nil
 }
 
 type Engine struct {
-	lookbackDelta time.Duration
+	lookbackDelta            time.Duration
+	noStepSubqueryIntervalFn func(rangeMillis int64) int64
+	limitsProvider           QueryLimitsProvider
+
+	queriesRejectedDueToMemoryConsumption prometheus.Counter
 }
 
-func (e *Engine) NewInstantQuery(_ context.Context, q storage.Queryable, opts promql.QueryOpts, qs string, ts time.Time) (promql.Query, error) {
-	return newQuery(q, opts, qs, ts, ts, 0, e)
+func (e *Engine) NewInstantQuery(ctx context.Context, q storage.Queryable, opts promql.QueryOpts, qs string, ts time.Time) (promql.Query, error) {
+	return newQuery(ctx, q, opts, qs, ts, ts, 0, e)
 }
 
-func (e *Engine) NewRangeQuery(_ context.Context, q storage.Queryable, opts promql.QueryOpts, qs string, start, end time.Time, interval time.Duration) (promql.Query, error) {
+func (e *Engine) NewRangeQuery(ctx context.Context, q storage.Queryable, opts promql.QueryOpts, qs string, start, end time.Time, interval time.Duration) (promql.Query, error) {
 	if interval <= 0 {
 		return nil, fmt.Errorf("%v is not a valid interval for a range query, must be greater than 0", interval)
 	}
@@ -57,5 +67,5 @@ func (e *Engine) NewRangeQuery(_ context.Context, q storage.Queryable, opts prom
 		return nil, fmt.Errorf("range query time range is invalid: end time %v is before start time %v", end.Format(time.RFC3339), start.Format(time.RFC3339))
 	}
 
-	return newQuery(q, opts, qs, start, end, interval, e)
+	return newQuery(ctx, q, opts, qs, start, end, interval, e)
 }
@@ -4,35 +4,39 @@ package streamingpromql
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
 	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
 	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir/pkg/querier/stats"
+	"github.com/grafana/mimir/pkg/streamingpromql/operator"
+	"github.com/grafana/mimir/pkg/util/globalerror"
 )
 
 func TestUnsupportedPromQLFeatures(t *testing.T) {
 	opts := NewTestEngineOpts()
-	engine, err := NewEngine(opts)
+	engine, err := NewEngine(opts, NewStaticQueryLimitsProvider(0), stats.NewQueryMetrics(nil))
 	require.NoError(t, err)
 	ctx := context.Background()
 
 	// The goal of this is not to list every conceivable expression that is unsupported, but to cover all the
 	// different cases and make sure we produce a reasonable error message when these cases are encountered.
 	unsupportedExpressions := map[string]string{
-		"a + b":                        "PromQL expression type *parser.BinaryExpr",
-		"1 + 2":                        "PromQL expression type *parser.BinaryExpr",
-		"metric{} + other_metric{}":    "PromQL expression type *parser.BinaryExpr",
-		"1":                            "PromQL expression type *parser.NumberLiteral",
-		"metric{} offset 2h":           "instant vector selector with 'offset'",
-		"avg(metric{})":                "'avg' aggregation",
-		"sum without(l) (metric{})":    "grouping with 'without'",
-		"rate(metric{}[5m] offset 2h)": "range vector selector with 'offset'",
-		"avg_over_time(metric{}[5m])":  "'avg_over_time' function",
-		"-sum(metric{})":               "PromQL expression type *parser.UnaryExpr",
+		"histogram_stddev(metric{})": "'histogram_stddev' function",
+		"-sum(metric{})":             "PromQL expression type *parser.UnaryExpr",
 	}
 
 	for expression, expectedError := range unsupportedExpressions {
@@ -75,7 +79,7 @@ func TestUnsupportedPromQLFeatures(t *testing.T) {
 
 func TestNewRangeQuery_InvalidQueryTime(t *testing.T) {
 	opts := NewTestEngineOpts()
-	engine, err := NewEngine(opts)
+	engine, err := NewEngine(opts, NewStaticQueryLimitsProvider(0), stats.NewQueryMetrics(nil))
 	require.NoError(t, err)
 	ctx := context.Background()
 
@@ -89,7 +93,7 @@ func TestNewRangeQuery_InvalidQueryTime(t *testing.T) {
 
 func TestNewRangeQuery_InvalidExpressionTypes(t *testing.T) {
 	opts := NewTestEngineOpts()
-	engine, err := NewEngine(opts)
+	engine, err := NewEngine(opts, NewStaticQueryLimitsProvider(0), stats.NewQueryMetrics(nil))
 	require.NoError(t, err)
 	ctx := context.Background()
 
@@ -105,7 +109,7 @@ func TestNewRangeQuery_InvalidExpressionTypes(t *testing.T) {
 // Once the streaming engine supports all PromQL features exercised by Prometheus' test cases, we can remove these files and instead call promql.RunBuiltinTests here instead.
 func TestUpstreamTestCases(t *testing.T) {
 	opts := NewTestEngineOpts()
-	engine, err := NewEngine(opts)
+	engine, err := NewEngine(opts, NewStaticQueryLimitsProvider(0), stats.NewQueryMetrics(nil))
 	require.NoError(t, err)
 
 	testdataFS := os.DirFS("./testdata")
@@ -128,7 +132,7 @@ func TestUpstreamTestCases(t *testing.T) {
 
 func TestOurTestCases(t *testing.T) {
 	opts := NewTestEngineOpts()
-	streamingEngine, err := NewEngine(opts)
+	streamingEngine, err := NewEngine(opts, NewStaticQueryLimitsProvider(0), stats.NewQueryMetrics(nil))
 	require.NoError(t, err)
 
 	prometheusEngine := promql.NewEngine(opts)
@@ -159,3 +163,340 @@ func TestOurTestCases(t *testing.T) {
 		})
 	}
 }
+
+func TestMemoryConsumptionLimit(t *testing.T) {
+	storage := promql.LoadedStorage(t, `
+		load 1m
+			some_metric{idx="1"} 0+1x5
+			some_metric{idx="2"} 0+1x5
+			some_metric{idx="3"} 0+1x5
+			some_metric{idx="4"} 0+1x5
+			some_metric{idx="5"} 0+1x5
+	`)
+	t.Cleanup(func() { require.NoError(t, storage.Close()) })
+
+	ctx := user.InjectOrgID(context.Background(), "the-tenant")
+	start := timestamp.Time(0)
+	end := start.Add(5 * time.Minute)
+
+	testCases := map[string]func(engine promql.QueryEngine) (promql.Query, error){
+		"instant query": func(engine promql.QueryEngine) (promql.Query, error) {
+			return engine.NewInstantQuery(ctx, storage, nil, `sum(some_metric)`, end)
+		},
+		"range query": func(engine promql.QueryEngine) (promql.Query, error) {
+			return engine.NewRangeQuery(ctx, storage, nil, `sum(some_metric)`, start, end, time.Minute)
+		},
+	}
+
+	for name, createQuery := range testCases {
+		t.Run(name, func(t *testing.T) {
+			runQuery := func(t *testing.T, limit uint64) (*promql.Result, uint64, *prometheus.Registry) {
+				reg := prometheus.NewPedanticRegistry()
+				engine, err := NewEngine(NewTestEngineOpts(), NewStaticQueryLimitsProvider(limit), stats.NewQueryMetrics(reg))
+				require.NoError(t, err)
+
+				queryStats, ctx := stats.ContextWithEmptyStats(ctx)
+				q, err := createQuery(engine)
+				require.NoError(t, err)
+				t.Cleanup(q.Close)
+
+				res := q.Exec(ctx)
+				return res, queryStats.LoadEstimatedPeakMemoryConsumption(), reg
+			}
+
+			// Run the query without a limit to determine how much memory it consumes.
+			res, peak, _ := runQuery(t, 0)
+			require.NoError(t, res.Err)
+			require.NotZero(t, peak)
+
+			t.Run("limit equal to peak consumption", func(t *testing.T) {
+				res, actualPeak, reg := runQuery(t, peak)
+				require.NoError(t, res.Err)
+				require.Equal(t, peak, actualPeak)
+				assertRejectedQueryCount(t, reg, 0)
+			})
+
+			t.Run("limit below peak consumption", func(t *testing.T) {
+				res, actualPeak, reg := runQuery(t, peak-1)
+				require.Error(t, res.Err)
+				require.ErrorContains(t, res.Err, globalerror.MaxEstimatedMemoryConsumptionPerQuery.Error())
+				require.Less(t, actualPeak, peak)
+				assertRejectedQueryCount(t, reg, 1)
+			})
+		})
+	}
+}
+
+func assertRejectedQueryCount(t *testing.T, reg *prometheus.Registry, expectedRejectionCount int) {
+	expected := fmt.Sprintf(`
+		# HELP cortex_querier_queries_rejected_total Number of queries that were rejected, for example because they exceeded a limit.
+		# TYPE cortex_querier_queries_rejected_total counter
+		cortex_querier_queries_rejected_total{reason="max-estimated-memory-consumption-per-query"} %v
+		cortex_querier_queries_rejected_total{reason="max-fetched-chunk-bytes-per-query"} 0
+		cortex_querier_queries_rejected_total{reason="max-fetched-chunks-per-query"} 0
+		cortex_querier_queries_rejected_total{reason="max-fetched-series-per-query"} 0
+		cortex_querier_queries_rejected_total{reason="max-estimated-fetched-chunks-per-query"} 0
+	`, expectedRejectionCount)
+
+	require.NoError(t, promtest.GatherAndCompare(reg, strings.NewReader(expected), "cortex_querier_queries_rejected_total"))
+}
+
+// This test ensures that every operator returns all of the slices it takes from the pools, so that the memory
+// consumption of the query returns to zero once the query is closed.
+func TestMemoryConsumptionIsZeroAfterQueryIsClosed(t *testing.T) {
+	storage := promql.LoadedStorage(t, `
+		load 1m
+			some_metric{group="a", idx="1"} 0+1x10
+			some_metric{group="a", idx="2"} 0+2x10
+			some_metric{group="b", idx="3"} 0+3x10
+			some_other_metric{group="a", idx="1"} 1+1x10
+			some_histogram{idx="1"} {{schema:0 sum:4 count:4 buckets:[1 2 1]}}+{{sum:2 count:1 buckets:[1] offset:1}}x10
+			some_histogram_bucket{le="1"} 0+1x10
+			some_histogram_bucket{le="2"} 0+2x10
+			some_histogram_bucket{le="+Inf"} 0+3x10
+	`)
+	t.Cleanup(func() { require.NoError(t, storage.Close()) })
+
+	expressions := []string{
+		`some_metric`,
+		`some_metric offset 1m`,
+		`some_histogram`,
+		`rate(some_metric[5m])`,
+		`rate(some_histogram[5m])`,
+		`quantile_over_time(0.5, some_metric[5m])`,
+		`absent(nonexistent)`,
+		`absent_over_time(nonexistent[5m])`,
+		`sum by (group) (some_metric)`,
+		`quantile by (group) (0.5, some_metric)`,
+		`sum(some_histogram)`,
+		`topk(1, some_metric)`,
+		`count_values("value", some_metric)`,
+		`some_metric + some_other_metric`,
+		`some_metric * on (group) group_left sum by (group) (some_other_metric)`,
+		`some_metric and some_other_metric`,
+		`some_metric unless some_other_metric`,
+		`some_metric or some_other_metric`,
+		`some_metric * 2`,
+		`2 * 3`,
+		`scalar(some_metric{idx="1"})`,
+		`vector(1)`,
+		`time()`,
+		`abs(some_metric)`,
+		`clamp(some_metric, 1, 5)`,
+		`timestamp(some_metric)`,
+		`hour()`,
+		`label_replace(some_metric, "dst", "$1", "idx", "(.*)")`,
+		`label_join(some_metric, "dst", "-", "group", "idx")`,
+		`sort(some_metric)`,
+		`histogram_quantile(0.5, some_histogram_bucket)`,
+		`histogram_quantile(0.5, some_histogram)`,
+		`max_over_time(rate(some_metric[2m])[5m:1m])`,
+		`label_replace(some_metric, "idx", "", "idx", ".*")`, // Fails due to duplicate series.
+		`some_metric / (some_metric + some_other_metric)`,
+		`some_metric - scalar(sum(some_metric))`,
+		`(some_metric{group="nonexistent"} and some_metric) or some_metric`, // The shared some_metric is never read by 'and'.
+	}
+
+	ctx := context.Background()
+	engine, err := NewEngine(NewTestEngineOpts(), NewStaticQueryLimitsProvider(0), stats.NewQueryMetrics(nil))
+	require.NoError(t, err)
+
+	start := timestamp.Time(0).Add(5 * time.Minute)
+	end := start.Add(5 * time.Minute)
+
+	queryTypes := map[string]func(expr string) (promql.Query, error){
+		"instant query": func(expr string) (promql.Query, error) {
+			return engine.NewInstantQuery(ctx, storage, nil, expr, end)
+		},
+		"range query": func(expr string) (promql.Query, error) {
+			return engine.NewRangeQuery(ctx, storage, nil, expr, start, end, time.Minute)
+		},
+	}
+
+	for _, expr := range expressions {
+		t.Run(expr, func(t *testing.T) {
+			for queryType, createQuery := range queryTypes {
+				t.Run(queryType, func(t *testing.T) {
+					q, err := createQuery(expr)
+					require.NoError(t, err)
+
+					tracker := q.(*Query).memoryConsumptionTracker
+					q.Exec(ctx)
+					q.Close()
+
+					require.NotZero(t, tracker.PeakEstimatedMemoryConsumptionBytes)
+					require.Zero(t, tracker.CurrentEstimatedMemoryConsumptionBytes)
+				})
+			}
+		})
+	}
+}
+
+func TestCommonSubexpressionsAreOnlyEvaluatedOnce(t *testing.T) {
+	storage := promql.LoadedStorage(t, `
+		load 1m
+			some_metric{idx="1"} 0+1x10
+			some_metric{idx="2"} 0+2x10
+			other_metric{idx="1"} 0+3x10
+	`)
+	t.Cleanup(func() { require.NoError(t, storage.Close()) })
+
+	testCases := map[string]struct {
+		expr                string
+		expectedSelectCalls int
+	}{
+		"no common subexpressions": {
+			expr:                `some_metric + other_metric`,
+			expectedSelectCalls: 2,
+		},
+		"common selector": {
+			expr:                `some_metric / (some_metric + other_metric)`,
+			expectedSelectCalls: 2,
+		},
+		"common aggregation": {
+			expr:                `sum(rate(some_metric[5m])) / sum(rate(some_metric[5m]))`,
+			expectedSelectCalls: 1,
+		},
+		"common subexpression within common subexpression": {
+			expr:                `sum(some_metric) + some_metric * sum(some_metric)`,
+			expectedSelectCalls: 1,
+		},
+		"same selector with different offsets": {
+			expr:                `some_metric - some_metric offset 1m`,
+			expectedSelectCalls: 2,
+		},
+		"same expression inside and outside subquery": {
+			expr:                `sum(some_metric) - max_over_time(sum(some_metric)[3m:1m])`,
+			expectedSelectCalls: 2,
+		},
+		"same expression in two identical subqueries": {
+			expr:                `max_over_time(sum(some_metric)[3m:1m]) - min_over_time(sum(some_metric)[3m:1m])`,
+			expectedSelectCalls: 1,
+		},
+	}
+
+	engine, err := NewEngine(NewTestEngineOpts(), NewStaticQueryLimitsProvider(0), stats.NewQueryMetrics(nil))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	start := timestamp.Time(0)
+	end := start.Add(10 * time.Minute)
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			queryable := &selectCountingQueryable{inner: storage}
+			q, err := engine.NewRangeQuery(ctx, queryable, nil, testCase.expr, start, end, time.Minute)
+			require.NoError(t, err)
+			defer q.Close()
+
+			res := q.Exec(ctx)
+			require.NoError(t, res.Err)
+			require.Equal(t, testCase.expectedSelectCalls, queryable.selectCalls)
+		})
+	}
+}
+
+type selectCountingQueryable struct {
+	inner       storage.Queryable
+	selectCalls int
+}
+
+func (q *selectCountingQueryable) Querier(mint, maxt int64) (storage.Querier, error) {
+	querier, err := q.inner.Querier(mint, maxt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &selectCountingQuerier{Querier: querier, queryable: q}, nil
+}
+
+type selectCountingQuerier struct {
+	storage.Querier
+	queryable *selectCountingQueryable
+}
+
+func (q *selectCountingQuerier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	q.queryable.selectCalls++
+	return q.Querier.Select(ctx, sortSeries, hints, matchers...)
+}
+
+func TestOperatorStats(t *testing.T) {
+	storage := promql.LoadedStorage(t, `
+		load 1m
+			some_metric{env="prod", pod="1"} 0+1x10
+			some_metric{env="prod", pod="2"} 0+2x10
+			some_metric{env="test", pod="1"} 0+3x10
+	`)
+	t.Cleanup(func() { require.NoError(t, storage.Close()) })
+
+	engine, err := NewEngine(NewTestEngineOpts(), NewStaticQueryLimitsProvider(0), stats.NewQueryMetrics(nil))
+	require.NoError(t, err)
+
+	start := timestamp.Time(0).Add(5 * time.Minute)
+	end := start.Add(5 * time.Minute)
+
+	testCases := map[string]struct {
+		createQuery   func(ctx context.Context) (promql.Query, error)
+		expectedStats []stats.OperatorStats
+	}{
+		"range query": {
+			createQuery: func(ctx context.Context) (promql.Query, error) {
+				return engine.NewRangeQuery(ctx, storage, nil, `sum by (env) (rate(some_metric[5m]))`, start, end, time.Minute)
+			},
+			expectedStats: []stats.OperatorStats{
+				{Operator: "Aggregation: operation=sum, by (env), from 1970-01-01T00:05:00Z to 1970-01-01T00:10:00Z every 1m", Depth: 0, SeriesIn: 3, SeriesOut: 2, SamplesProcessed: 12},
+				{Operator: "DeduplicateAndMerge", Depth: 1, SeriesIn: 3, SeriesOut: 3, SamplesProcessed: 18},
+				{Operator: "FunctionOverRangeVector: function=rate", Depth: 2, SeriesIn: 3, SeriesOut: 3, SamplesProcessed: 18},
+				// Each step selects 6 points from each of the 3 series.
+				{Operator: `RangeVectorSelector: matchers={__name__="some_metric"}, range=5m, from 1970-01-01T00:05:00Z to 1970-01-01T00:10:00Z every 1m`, Depth: 3, SeriesOut: 3, SamplesProcessed: 108, PeakBufferedPoints: 6},
+			},
+		},
+		"instant query with common subexpression": {
+			createQuery: func(ctx context.Context) (promql.Query, error) {
+				return engine.NewInstantQuery(ctx, storage, nil, `some_metric / (some_metric + 1)`, end)
+			},
+			expectedStats: []stats.OperatorStats{
+				{Operator: "BinaryOperation: operation=/, at 1970-01-01T00:10:00Z", Depth: 0, SeriesIn: 6, SeriesOut: 3, SamplesProcessed: 3},
+				{Operator: "InstantVectorDuplicationConsumer", Depth: 1, SeriesIn: 3, SeriesOut: 3, SamplesProcessed: 3, PeakBufferedPoints: 1},
+				{Operator: `InstantVectorSelector: matchers={__name__="some_metric"}, at 1970-01-01T00:10:00Z`, Depth: 2, SeriesOut: 3, SamplesProcessed: 3},
+				{Operator: "DeduplicateAndMerge", Depth: 1, SeriesIn: 3, SeriesOut: 3, SamplesProcessed: 3},
+				{Operator: "VectorScalarBinaryOperation: operation=+, at 1970-01-01T00:10:00Z", Depth: 2, SeriesIn: 3, SeriesOut: 3, SamplesProcessed: 3},
+				// The shared selector is only evaluated once, so is only reported under the first consumer.
+				{Operator: "InstantVectorDuplicationConsumer", Depth: 3, SeriesIn: 3, SeriesOut: 3, SamplesProcessed: 3},
+				{Operator: "ScalarConstant: value=1, at 1970-01-01T00:10:00Z", Depth: 3, SamplesProcessed: 1},
+			},
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			queryStats, ctx := stats.ContextWithEmptyStats(context.Background())
+			q, err := testCase.createQuery(ctx)
+			require.NoError(t, err)
+			defer q.Close()
+
+			res := q.Exec(ctx)
+			require.NoError(t, res.Err)
+
+			operators := queryStats.LoadOperatorStats()
+			for i := range operators {
+				require.GreaterOrEqual(t, operators[i].WallTime, time.Duration(0))
+				operators[i].WallTime = 0 // Wall time varies between runs, so don't compare it.
+			}
+
+			require.Equal(t, testCase.expectedStats, operators)
+		})
+	}
+}
+
+func TestOperatorStatsNotRecordedWhenQueryStatsDisabled(t *testing.T) {
+	engine, err := NewEngine(NewTestEngineOpts(), NewStaticQueryLimitsProvider(0), stats.NewQueryMetrics(nil))
+	require.NoError(t, err)
+
+	q, err := engine.NewInstantQuery(context.Background(), nil, nil, `sum(some_metric)`, timestamp.Time(0))
+	require.NoError(t, err)
+	defer q.Close()
+
+	// Operators are only instrumented if query statistics are enabled when the query is created.
+	require.IsType(t, &operator.Aggregation{}, q.(*Query).root)
+}
@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package limiting
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir/pkg/util/globalerror"
+)
+
+func TestMemoryConsumptionTracker_Unlimited(t *testing.T) {
+	tracker := NewMemoryConsumptionTracker(0, nil)
+
+	require.NoError(t, tracker.IncreaseMemoryConsumption(128))
+	require.Equal(t, uint64(128), tracker.CurrentEstimatedMemoryConsumptionBytes)
+	require.Equal(t, uint64(128), tracker.PeakEstimatedMemoryConsumptionBytes)
+
+	// Add some more memory consumption. The current and peak stats should be updated.
+	require.NoError(t, tracker.IncreaseMemoryConsumption(2))
+	require.Equal(t, uint64(130), tracker.CurrentEstimatedMemoryConsumptionBytes)
+	require.Equal(t, uint64(130), tracker.PeakEstimatedMemoryConsumptionBytes)
+
+	// Reduce memory consumption. The current consumption should be updated, but the peak should be unchanged.
+	tracker.DecreaseMemoryConsumption(128)
+	require.Equal(t, uint64(2), tracker.CurrentEstimatedMemoryConsumptionBytes)
+	require.Equal(t, uint64(130), tracker.PeakEstimatedMemoryConsumptionBytes)
+
+	// Add some more memory consumption that doesn't take us over the previous peak.
+	require.NoError(t, tracker.IncreaseMemoryConsumption(8))
+	require.Equal(t, uint64(10), tracker.CurrentEstimatedMemoryConsumptionBytes)
+	require.Equal(t, uint64(130), tracker.PeakEstimatedMemoryConsumptionBytes)
+
+	// Decreasing by more than the current consumption is a bug.
+	require.Panics(t, func() { tracker.DecreaseMemoryConsumption(11) })
+}
+
+func TestMemoryConsumptionTracker_Limited(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	rejectionCount := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "rejected_queries",
+	})
+	reg.MustRegister(rejectionCount)
+
+	tracker := NewMemoryConsumptionTracker(11, rejectionCount)
+
+	// Add some memory consumption beneath the limit.
+	require.NoError(t, tracker.IncreaseMemoryConsumption(8))
+	require.Equal(t, uint64(8), tracker.CurrentEstimatedMemoryConsumptionBytes)
+	require.Equal(t, uint64(8), tracker.PeakEstimatedMemoryConsumptionBytes)
+	require.Equal(t, float64(0), promtest.ToFloat64(rejectionCount))
+
+	// Add some more memory consumption up to the limit.
+	require.NoError(t, tracker.IncreaseMemoryConsumption(3))
+	require.Equal(t, uint64(11), tracker.CurrentEstimatedMemoryConsumptionBytes)
+	require.Equal(t, uint64(11), tracker.PeakEstimatedMemoryConsumptionBytes)
+	require.Equal(t, float64(0), promtest.ToFloat64(rejectionCount))
+
+	// Reduce memory consumption.
+	tracker.DecreaseMemoryConsumption(2)
+	require.Equal(t, uint64(9), tracker.CurrentEstimatedMemoryConsumptionBytes)
+	require.Equal(t, uint64(11), tracker.PeakEstimatedMemoryConsumptionBytes)
+
+	// Try to add some more memory consumption where we would go over the limit.
+	err := tracker.IncreaseMemoryConsumption(3)
+	require.ErrorContains(t, err, globalerror.MaxEstimatedMemoryConsumptionPerQuery.Error())
+	require.Equal(t, uint64(9), tracker.CurrentEstimatedMemoryConsumptionBytes, "current consumption should be unchanged after a rejected increase")
+	require.Equal(t, uint64(11), tracker.PeakEstimatedMemoryConsumptionBytes)
+	require.Equal(t, float64(1), promtest.ToFloat64(rejectionCount))
+
+	// Try again. The rejection should only be counted once per query.
+	err = tracker.IncreaseMemoryConsumption(3)
+	require.ErrorContains(t, err, globalerror.MaxEstimatedMemoryConsumptionPerQuery.Error())
+	require.Equal(t, float64(1), promtest.ToFloat64(rejectionCount))
+}
@@ -0,0 +1,28 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package streamingpromql
+
+import "context"
+
+// QueryLimitsProvider provides the limits to apply to a query.
+type QueryLimitsProvider interface {
+	// GetMaxEstimatedMemoryConsumptionPerQuery returns the maximum estimated memory allowed to be consumed by a query in bytes, or 0 to disable the limit.
+	GetMaxEstimatedMemoryConsumptionPerQuery(ctx context.Context) (uint64, error)
+}
+
+// NewStaticQueryLimitsProvider returns a QueryLimitsProvider that always returns the provided limits.
+//
+// This should generally only be used in tests.
+func NewStaticQueryLimitsProvider(maxEstimatedMemoryConsumptionPerQuery uint64) QueryLimitsProvider {
+	return staticQueryLimitsProvider{
+		maxEstimatedMemoryConsumptionPerQuery: maxEstimatedMemoryConsumptionPerQuery,
+	}
+}
+
+type staticQueryLimitsProvider struct {
+	maxEstimatedMemoryConsumptionPerQuery uint64
+}
+
+func (p staticQueryLimitsProvider) GetMaxEstimatedMemoryConsumptionPerQuery(_ context.Context) (uint64, error) {
+	return p.maxEstimatedMemoryConsumptionPerQuery, nil
+}
@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// Provenance-includes-location: https://github.com/prometheus/prometheus/blob/main/promql/engine.go
+// Provenance-includes-license: Apache-2.0
+// Provenance-includes-copyright: The Prometheus Authors
+
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// Absent produces a single output series with value 1 at each step where Inner has no series with a point,
+// as used by absent and absent_over_time.
+//
+// Like Prometheus' engine, the labels of the output series are determined by the original expression rather than the
+// input series, so they are provided in Labels.
+type Absent struct {
+	Inner  InstantVectorOperator
+	Labels labels.Labels
+
+	Start    int64 // Milliseconds since Unix epoch
+	End      int64 // Milliseconds since Unix epoch
+	Interval int64 // In milliseconds
+
+	MemoryConsumptionTracker *limiting.MemoryConsumptionTracker
+
+	present  []bool // One entry per step, true if any input series has a point at that step.
+	returned bool
+}
+
+var _ InstantVectorOperator = &Absent{}
+
+func (a *Absent) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error) {
+	innerMetadata, err := a.Inner.SeriesMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer PutSeriesMetadataSlice(innerMetadata, a.MemoryConsumptionTracker)
+
+	steps := stepCount(a.Start, a.End, a.Interval)
+	a.present, err = getZeroedBoolSlice(steps, a.MemoryConsumptionTracker)
+	if err != nil {
+		return nil, err
+	}
+
+	// Whether or not there is an output point at each step depends on every input series, so we must read them all now.
+	for range innerMetadata {
+		d, err := a.Inner.Next(ctx)
+		if err != nil {
+			if errors.Is(err, EOS) {
+				return nil, fmt.Errorf("exhausted series before all series were read: %w", err)
+			}
+
+			return nil, err
+		}
+
+		for _, p := range d.Floats {
+			a.present[(p.T-a.Start)/a.Interval] = true
+		}
+
+		for _, p := range d.Histograms {
+			a.present[(p.T-a.Start)/a.Interval] = true
+		}
+
+		PutFPointSlice(d.Floats, a.MemoryConsumptionTracker)
+		PutHPointSlice(d.Histograms, a.MemoryConsumptionTracker)
+	}
+
+	metadata, err := GetSeriesMetadataSlice(1, a.MemoryConsumptionTracker)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata = append(metadata, SeriesMetadata{Labels: a.Labels})
+
+	return metadata, nil
+}
+
+func (a *Absent) Next(_ context.Context) (InstantVectorSeriesData, error) {
+	if a.returned {
+		return InstantVectorSeriesData{}, EOS
+	}
+
+	a.returned = true
+
+	pointCount := 0
+	for _, p := range a.present {
+		if !p {
+			pointCount++
+		}
+	}
+
+	if pointCount == 0 {
+		return InstantVectorSeriesData{}, nil
+	}
+
+	points, err := GetFPointSlice(pointCount, a.MemoryConsumptionTracker)
+	if err != nil {
+		return InstantVectorSeriesData{}, err
+	}
+
+	for idx, p := range a.present {
+		if !p {
+			points = append(points, promql.FPoint{T: a.Start + int64(idx)*a.Interval, F: 1})
+		}
+	}
+
+	return InstantVectorSeriesData{Floats: points}, nil
+}
+
+func (a *Absent) Close() {
+	a.Inner.Close()
+
+	if a.present != nil {
+		PutBoolSlice(a.present, a.MemoryConsumptionTracker)
+		a.present = nil
+	}
+}
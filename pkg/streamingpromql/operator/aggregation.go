@@ -14,17 +14,30 @@ import (
 
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/timestamp"
-	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
 	"github.com/prometheus/prometheus/util/zeropool"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
 )
 
+// Aggregation represents the aggregation operators that produce one output series per group, such as sum, avg or quantile.
+//
+// topk, bottomk and count_values are implemented by TopKBottomK and CountValues respectively.
 type Aggregation struct {
 	Inner    InstantVectorOperator
 	Start    time.Time
 	End      time.Time
 	Interval time.Duration
-	Grouping []string
+	Op       parser.ItemType
+	Grouping []string // Must be sorted.
+	Without  bool
+
+	// Param is the scalar parameter for this aggregation, and is only set for aggregations that take a parameter, such as quantile.
+	Param ScalarOperator
+
+	MemoryConsumptionTracker *limiting.MemoryConsumptionTracker
 
+	newAggregationGroup         func() aggregationGroup
 	remainingInnerSeriesToGroup []*group // One entry per series produced by Inner, value is the group for that series
 	remainingGroups             []*group // One entry per group, in the order we want to return them
 }
@@ -35,9 +48,8 @@ type group struct {
 	// The number of input series that belong to this group that we haven't yet seen.
 	remainingSeriesCount uint
 
-	// Sum and presence for each step.
-	sums    []float64
-	present []bool
+	// The accumulated state for this group, or nil if we haven't yet seen any series for this group.
+	aggregation aggregationGroup
 }
 
 var _ InstantVectorOperator = &Aggregation{}
@@ -46,14 +58,24 @@ var groupPool = zeropool.New(func() *group {
 	return &group{}
 })
 
+// IsSupportedAggregationOperation returns true if op is supported by Aggregation.
+func IsSupportedAggregationOperation(op parser.ItemType) bool {
+	_, supported := aggregationGroupFactories[op]
+	return supported
+}
+
 func (a *Aggregation) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error) {
+	if _, supported := aggregationGroupFactories[a.Op]; !supported {
+		return nil, fmt.Errorf("unsupported aggregation operation '%s'", a.Op)
+	}
+
 	// Fetch the source series
 	innerSeries, err := a.Inner.SeriesMetadata(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	defer PutSeriesMetadataSlice(innerSeries)
+	defer PutSeriesMetadataSlice(innerSeries, a.MemoryConsumptionTracker)
 
 	if len(innerSeries) == 0 {
 		// No input series == no output series.
@@ -71,12 +93,12 @@ func (a *Aggregation) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, err
 		// This is something we should likely fix, but at present, Prometheus' PromQL engine doesn't handle collisions either,
 		// so at least both engines will be incorrect in the same way.
 		var groupingKey uint64
-		groupingKey, buf = series.Labels.HashForLabels(buf, a.Grouping...)
+		groupingKey, buf = groupingKeyForSeries(series.Labels, a.Grouping, a.Without, buf)
 		g, groupExists := groups[groupingKey]
 
 		if !groupExists {
 			g = groupPool.Get()
-			g.labels = a.labelsForGroup(series.Labels, lb)
+			g.labels = labelsForGroup(series.Labels, a.Grouping, a.Without, lb)
 			g.remainingSeriesCount = 0
 
 			groups[groupingKey] = g
@@ -87,7 +109,11 @@ func (a *Aggregation) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, err
 	}
 
 	// Sort the list of series we'll return, and maintain the order of the corresponding groups at the same time
-	seriesMetadata := GetSeriesMetadataSlice(len(groups))
+	seriesMetadata, err := GetSeriesMetadataSlice(len(groups), a.MemoryConsumptionTracker)
+	if err != nil {
+		return nil, err
+	}
+
 	a.remainingGroups = make([]*group, 0, len(groups))
 
 	for _, g := range groups {
@@ -100,13 +126,35 @@ func (a *Aggregation) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, err
 	return seriesMetadata, nil
 }
 
-func (a *Aggregation) labelsForGroup(m labels.Labels, lb *labels.Builder) labels.Labels {
-	if len(a.Grouping) == 0 {
+// groupingKeyForSeries returns the key used to identify the group m belongs to.
+func groupingKeyForSeries(m labels.Labels, grouping []string, without bool, buf []byte) (uint64, []byte) {
+	if without {
+		return m.HashWithoutLabels(buf, grouping...)
+	}
+
+	if len(grouping) == 0 {
+		// No need to generate any hash if there are no grouping labels.
+		return 0, buf
+	}
+
+	return m.HashForLabels(buf, grouping...)
+}
+
+// labelsForGroup returns the labels of the output series for the group m belongs to.
+func labelsForGroup(m labels.Labels, grouping []string, without bool, lb *labels.Builder) labels.Labels {
+	if without {
+		lb.Reset(m)
+		lb.Del(grouping...)
+		lb.Del(labels.MetricName)
+		return lb.Labels()
+	}
+
+	if len(grouping) == 0 {
 		return labels.EmptyLabels()
 	}
 
 	lb.Reset(m)
-	lb.Keep(a.Grouping...)
+	lb.Keep(grouping...)
 	return lb.Labels()
 }
 
@@ -121,6 +169,12 @@ func (a *Aggregation) Next(ctx context.Context) (InstantVectorSeriesData, error)
 	interval := a.Interval.Milliseconds()
 	steps := stepCount(start, end, interval)
 
+	if a.newAggregationGroup == nil {
+		if err := a.initAggregationGroupFactory(ctx); err != nil {
+			return InstantVectorSeriesData{}, err
+		}
+	}
+
 	// Determine next group to return
 	thisGroup := a.remainingGroups[0]
 	a.remainingGroups = a.remainingGroups[1:]
@@ -137,54 +191,71 @@ func (a *Aggregation) Next(ctx context.Context) (InstantVectorSeriesData, error)
 			return InstantVectorSeriesData{}, err
 		}
 
+		if len(s.Histograms) > 0 {
+			PutFPointSlice(s.Floats, a.MemoryConsumptionTracker)
+			PutHPointSlice(s.Histograms, a.MemoryConsumptionTracker)
+			return InstantVectorSeriesData{}, errHistogramsNotSupported
+		}
+
 		thisSeriesGroup := a.remainingInnerSeriesToGroup[0]
 		a.remainingInnerSeriesToGroup = a.remainingInnerSeriesToGroup[1:]
 
-		if thisSeriesGroup.sums == nil {
+		if thisSeriesGroup.aggregation == nil {
 			// First series for this group, populate it
-			thisSeriesGroup.sums = GetFloatSlice(steps)[:steps]
-			thisSeriesGroup.present = GetBoolSlice(steps)[:steps]
+			thisSeriesGroup.aggregation = a.newAggregationGroup()
 		}
 
-		for _, p := range s.Floats {
-			idx := (p.T - start) / interval
-			thisSeriesGroup.sums[idx] += p.F
-			thisSeriesGroup.present[idx] = true
+		err = thisSeriesGroup.aggregation.AccumulateSeries(s, steps, start, interval, a.MemoryConsumptionTracker)
+		PutFPointSlice(s.Floats, a.MemoryConsumptionTracker)
+		PutHPointSlice(s.Histograms, a.MemoryConsumptionTracker)
+
+		if err != nil {
+			return InstantVectorSeriesData{}, err
 		}
 
-		PutFPointSlice(s.Floats)
 		thisSeriesGroup.remainingSeriesCount--
 	}
 
 	// Construct the group and return it
-	pointCount := 0
-	for _, p := range thisGroup.present {
-		if p {
-			pointCount++
-		}
-	}
+	data, err := thisGroup.aggregation.ComputeOutputSeries(start, interval, a.MemoryConsumptionTracker)
 
-	points := GetFPointSlice(pointCount)
+	thisGroup.aggregation = nil
+	groupPool.Put(thisGroup)
 
-	for i, havePoint := range thisGroup.present {
-		if havePoint {
-			t := start + int64(i)*interval
-			points = append(points, promql.FPoint{T: t, F: thisGroup.sums[i]})
-		}
+	return data, err
+}
+
+func (a *Aggregation) initAggregationGroupFactory(ctx context.Context) error {
+	factory := aggregationGroupFactories[a.Op]
+
+	if a.Param == nil {
+		a.newAggregationGroup = func() aggregationGroup { return factory(nil) }
+		return nil
 	}
 
-	PutFloatSlice(thisGroup.sums)
-	PutBoolSlice(thisGroup.present)
+	paramData, err := a.Param.GetValues(ctx)
+	if err != nil {
+		return err
+	}
 
-	thisGroup.sums = nil
-	thisGroup.present = nil
-	groupPool.Put(thisGroup)
+	params := make([]float64, 0, len(paramData.Samples))
 
-	return InstantVectorSeriesData{Floats: points}, nil
+	for _, p := range paramData.Samples {
+		params = append(params, p.F)
+	}
+
+	PutFPointSlice(paramData.Samples, a.MemoryConsumptionTracker)
+	a.newAggregationGroup = func() aggregationGroup { return factory(params) }
+
+	return nil
 }
 
 func (a *Aggregation) Close() {
 	a.Inner.Close()
+
+	if a.Param != nil {
+		a.Param.Close()
+	}
 }
 
 type groupSorter struct {
@@ -0,0 +1,409 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// Provenance-includes-location: https://github.com/prometheus/prometheus/blob/main/promql/engine.go
+// Provenance-includes-location: https://github.com/prometheus/prometheus/blob/main/promql/quantile.go
+// Provenance-includes-license: Apache-2.0
+// Provenance-includes-copyright: The Prometheus Authors
+
+package operator
+
+import (
+	"math"
+	"sort"
+
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// aggregationGroup accumulates the series for a single group of an aggregation.
+type aggregationGroup interface {
+	// AccumulateSeries takes in a series as part of the group.
+	// The caller retains ownership of data, and may return its slices to a pool once AccumulateSeries returns.
+	AccumulateSeries(data InstantVectorSeriesData, steps int, start int64, interval int64, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) error
+
+	// ComputeOutputSeries does any final calculations and returns the grouped series data.
+	// The aggregationGroup must not be used after ComputeOutputSeries is called.
+	ComputeOutputSeries(start int64, interval int64, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) (InstantVectorSeriesData, error)
+}
+
+// aggregationGroupFactories contains a function to create a new aggregationGroup for each aggregation supported by Aggregation.
+// params contains the value of the aggregation's parameter at each step, or is nil if the aggregation takes no parameter.
+var aggregationGroupFactories = map[parser.ItemType]func(params []float64) aggregationGroup{
+	parser.SUM:      func(_ []float64) aggregationGroup { return &sumAggregationGroup{} },
+	parser.AVG:      func(_ []float64) aggregationGroup { return &avgAggregationGroup{} },
+	parser.MIN:      func(_ []float64) aggregationGroup { return &minMaxAggregationGroup{isMax: false} },
+	parser.MAX:      func(_ []float64) aggregationGroup { return &minMaxAggregationGroup{isMax: true} },
+	parser.COUNT:    func(_ []float64) aggregationGroup { return &countGroupAggregationGroup{isGroup: false} },
+	parser.GROUP:    func(_ []float64) aggregationGroup { return &countGroupAggregationGroup{isGroup: true} },
+	parser.STDDEV:   func(_ []float64) aggregationGroup { return &stddevStdvarAggregationGroup{isStddev: true} },
+	parser.STDVAR:   func(_ []float64) aggregationGroup { return &stddevStdvarAggregationGroup{isStddev: false} },
+	parser.QUANTILE: func(params []float64) aggregationGroup { return &quantileAggregationGroup{q: params} },
+}
+
+type sumAggregationGroup struct {
+	sums    []float64
+	present []bool
+}
+
+func (g *sumAggregationGroup) AccumulateSeries(data InstantVectorSeriesData, steps int, start int64, interval int64, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) error {
+	if g.sums == nil {
+		var err error
+		if g.sums, err = getZeroedFloatSlice(steps, memoryConsumptionTracker); err != nil {
+			return err
+		}
+
+		if g.present, err = getZeroedBoolSlice(steps, memoryConsumptionTracker); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range data.Floats {
+		idx := (p.T - start) / interval
+		g.sums[idx] += p.F
+		g.present[idx] = true
+	}
+
+	return nil
+}
+
+func (g *sumAggregationGroup) ComputeOutputSeries(start int64, interval int64, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) (InstantVectorSeriesData, error) {
+	points, err := pointsForPresentSteps(g.present, start, interval, func(idx int) float64 { return g.sums[idx] }, memoryConsumptionTracker)
+
+	PutFloatSlice(g.sums, memoryConsumptionTracker)
+	PutBoolSlice(g.present, memoryConsumptionTracker)
+
+	return InstantVectorSeriesData{Floats: points}, err
+}
+
+type avgAggregationGroup struct {
+	means  []float64
+	counts []float64
+}
+
+func (g *avgAggregationGroup) AccumulateSeries(data InstantVectorSeriesData, steps int, start int64, interval int64, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) error {
+	if g.means == nil {
+		var err error
+		if g.means, err = getZeroedFloatSlice(steps, memoryConsumptionTracker); err != nil {
+			return err
+		}
+
+		if g.counts, err = getZeroedFloatSlice(steps, memoryConsumptionTracker); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range data.Floats {
+		idx := (p.T - start) / interval
+		g.counts[idx]++
+
+		if g.counts[idx] == 1 {
+			g.means[idx] = p.F
+			continue
+		}
+
+		// Like Prometheus' engine, compute the mean incrementally to avoid overflowing float64.
+		if math.IsInf(g.means[idx], 0) {
+			if math.IsInf(p.F, 0) && (g.means[idx] > 0) == (p.F > 0) {
+				// The mean and this value are infinities of the same sign, so the mean is already correct.
+				continue
+			}
+
+			if !math.IsInf(p.F, 0) && !math.IsNaN(p.F) {
+				// The mean is infinite, and adding a finite value won't change that.
+				// The calculation below would otherwise produce Inf - Inf = NaN.
+				continue
+			}
+		}
+
+		g.means[idx] += p.F/g.counts[idx] - g.means[idx]/g.counts[idx]
+	}
+
+	return nil
+}
+
+func (g *avgAggregationGroup) ComputeOutputSeries(start int64, interval int64, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) (InstantVectorSeriesData, error) {
+	points, err := pointsForNonZeroCounts(g.counts, start, interval, func(idx int) float64 { return g.means[idx] }, memoryConsumptionTracker)
+
+	PutFloatSlice(g.means, memoryConsumptionTracker)
+	PutFloatSlice(g.counts, memoryConsumptionTracker)
+
+	return InstantVectorSeriesData{Floats: points}, err
+}
+
+type minMaxAggregationGroup struct {
+	isMax bool
+
+	values  []float64
+	present []bool
+}
+
+func (g *minMaxAggregationGroup) AccumulateSeries(data InstantVectorSeriesData, steps int, start int64, interval int64, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) error {
+	if g.values == nil {
+		var err error
+		if g.values, err = getZeroedFloatSlice(steps, memoryConsumptionTracker); err != nil {
+			return err
+		}
+
+		if g.present, err = getZeroedBoolSlice(steps, memoryConsumptionTracker); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range data.Floats {
+		idx := (p.T - start) / interval
+
+		if !g.present[idx] || math.IsNaN(g.values[idx]) {
+			g.values[idx] = p.F
+			g.present[idx] = true
+			continue
+		}
+
+		if (g.isMax && g.values[idx] < p.F) || (!g.isMax && g.values[idx] > p.F) {
+			g.values[idx] = p.F
+		}
+	}
+
+	return nil
+}
+
+func (g *minMaxAggregationGroup) ComputeOutputSeries(start int64, interval int64, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) (InstantVectorSeriesData, error) {
+	points, err := pointsForPresentSteps(g.present, start, interval, func(idx int) float64 { return g.values[idx] }, memoryConsumptionTracker)
+
+	PutFloatSlice(g.values, memoryConsumptionTracker)
+	PutBoolSlice(g.present, memoryConsumptionTracker)
+
+	return InstantVectorSeriesData{Floats: points}, err
+}
+
+// countGroupAggregationGroup implements both count and group, as group is equivalent to count, but always returns 1.
+type countGroupAggregationGroup struct {
+	isGroup bool
+
+	counts []float64
+}
+
+func (g *countGroupAggregationGroup) AccumulateSeries(data InstantVectorSeriesData, steps int, start int64, interval int64, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) error {
+	if g.counts == nil {
+		var err error
+		if g.counts, err = getZeroedFloatSlice(steps, memoryConsumptionTracker); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range data.Floats {
+		g.counts[(p.T-start)/interval]++
+	}
+
+	return nil
+}
+
+func (g *countGroupAggregationGroup) ComputeOutputSeries(start int64, interval int64, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) (InstantVectorSeriesData, error) {
+	points, err := pointsForNonZeroCounts(g.counts, start, interval, func(idx int) float64 {
+		if g.isGroup {
+			return 1
+		}
+
+		return g.counts[idx]
+	}, memoryConsumptionTracker)
+
+	PutFloatSlice(g.counts, memoryConsumptionTracker)
+
+	return InstantVectorSeriesData{Floats: points}, err
+}
+
+type stddevStdvarAggregationGroup struct {
+	isStddev bool
+
+	counts []float64
+	means  []float64
+
+	// Sum of squared differences from the mean for each step, calculated incrementally with Welford's algorithm like Prometheus' engine.
+	m2s []float64
+}
+
+func (g *stddevStdvarAggregationGroup) AccumulateSeries(data InstantVectorSeriesData, steps int, start int64, interval int64, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) error {
+	if g.counts == nil {
+		var err error
+		if g.counts, err = getZeroedFloatSlice(steps, memoryConsumptionTracker); err != nil {
+			return err
+		}
+
+		if g.means, err = getZeroedFloatSlice(steps, memoryConsumptionTracker); err != nil {
+			return err
+		}
+
+		if g.m2s, err = getZeroedFloatSlice(steps, memoryConsumptionTracker); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range data.Floats {
+		idx := (p.T - start) / interval
+		g.counts[idx]++
+
+		if g.counts[idx] == 1 {
+			g.means[idx] = p.F
+			continue
+		}
+
+		delta := p.F - g.means[idx]
+		g.means[idx] += delta / g.counts[idx]
+		g.m2s[idx] += delta * (p.F - g.means[idx])
+	}
+
+	return nil
+}
+
+func (g *stddevStdvarAggregationGroup) ComputeOutputSeries(start int64, interval int64, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) (InstantVectorSeriesData, error) {
+	points, err := pointsForNonZeroCounts(g.counts, start, interval, func(idx int) float64 {
+		variance := g.m2s[idx] / g.counts[idx]
+
+		if g.isStddev {
+			return math.Sqrt(variance)
+		}
+
+		return variance
+	}, memoryConsumptionTracker)
+
+	PutFloatSlice(g.counts, memoryConsumptionTracker)
+	PutFloatSlice(g.means, memoryConsumptionTracker)
+	PutFloatSlice(g.m2s, memoryConsumptionTracker)
+
+	return InstantVectorSeriesData{Floats: points}, err
+}
+
+// quantileAggregationGroup must retain every value at each step, as the quantile can't be computed incrementally.
+type quantileAggregationGroup struct {
+	q []float64 // The quantile to compute at each step.
+
+	values     [][]float64
+	valueCount int // The total number of values in values, used to track memory consumption.
+}
+
+func (g *quantileAggregationGroup) AccumulateSeries(data InstantVectorSeriesData, steps int, start int64, interval int64, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) error {
+	if g.values == nil {
+		g.values = make([][]float64, steps)
+	}
+
+	if err := memoryConsumptionTracker.IncreaseMemoryConsumption(uint64(len(data.Floats)) * Float64Size); err != nil {
+		return err
+	}
+
+	g.valueCount += len(data.Floats)
+
+	for _, p := range data.Floats {
+		idx := (p.T - start) / interval
+		g.values[idx] = append(g.values[idx], p.F)
+	}
+
+	return nil
+}
+
+func (g *quantileAggregationGroup) ComputeOutputSeries(start int64, interval int64, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) (InstantVectorSeriesData, error) {
+	pointCount := 0
+
+	for _, v := range g.values {
+		if len(v) > 0 {
+			pointCount++
+		}
+	}
+
+	defer func() {
+		memoryConsumptionTracker.DecreaseMemoryConsumption(uint64(g.valueCount) * Float64Size)
+		g.values = nil
+		g.valueCount = 0
+	}()
+
+	points, err := GetFPointSlice(pointCount, memoryConsumptionTracker)
+	if err != nil {
+		return InstantVectorSeriesData{}, err
+	}
+
+	for idx, v := range g.values {
+		if len(v) == 0 {
+			continue
+		}
+
+		t := start + int64(idx)*interval
+		points = append(points, promql.FPoint{T: t, F: quantile(g.q[idx], v)})
+	}
+
+	return InstantVectorSeriesData{Floats: points}, nil
+}
+
+// quantile calculates the given quantile of values, in the same way as Prometheus' engine.
+// values is sorted in place.
+func quantile(q float64, values []float64) float64 {
+	if len(values) == 0 || math.IsNaN(q) {
+		return math.NaN()
+	}
+	if q < 0 {
+		return math.Inf(-1)
+	}
+	if q > 1 {
+		return math.Inf(+1)
+	}
+
+	// Like Prometheus' engine, NaNs are sorted before all other values.
+	sort.Float64s(values)
+
+	n := float64(len(values))
+	// When the quantile lies between two samples,
+	// we use a weighted average of the two samples.
+	rank := q * (n - 1)
+
+	lowerIndex := math.Max(0, math.Floor(rank))
+	upperIndex := math.Min(n-1, lowerIndex+1)
+
+	weight := rank - math.Floor(rank)
+	return values[int(lowerIndex)]*(1-weight) + values[int(upperIndex)]*weight
+}
+
+// pointsForPresentSteps returns a point for each step where present is true, with the value returned by value.
+func pointsForPresentSteps(present []bool, start int64, interval int64, value func(idx int) float64, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) ([]promql.FPoint, error) {
+	pointCount := 0
+	for _, p := range present {
+		if p {
+			pointCount++
+		}
+	}
+
+	points, err := GetFPointSlice(pointCount, memoryConsumptionTracker)
+	if err != nil {
+		return nil, err
+	}
+
+	for idx, havePoint := range present {
+		if havePoint {
+			t := start + int64(idx)*interval
+			points = append(points, promql.FPoint{T: t, F: value(idx)})
+		}
+	}
+
+	return points, nil
+}
+
+// pointsForNonZeroCounts returns a point for each step where counts is non-zero, with the value returned by value.
+func pointsForNonZeroCounts(counts []float64, start int64, interval int64, value func(idx int) float64, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) ([]promql.FPoint, error) {
+	pointCount := 0
+	for _, c := range counts {
+		if c > 0 {
+			pointCount++
+		}
+	}
+
+	points, err := GetFPointSlice(pointCount, memoryConsumptionTracker)
+	if err != nil {
+		return nil, err
+	}
+
+	for idx, c := range counts {
+		if c > 0 {
+			t := start + int64(idx)*interval
+			points = append(points, promql.FPoint{T: t, F: value(idx)})
+		}
+	}
+
+	return points, nil
+}
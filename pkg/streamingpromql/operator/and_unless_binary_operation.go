@@ -0,0 +1,224 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// Provenance-includes-location: https://github.com/prometheus/prometheus/blob/main/promql/engine.go
+// Provenance-includes-license: Apache-2.0
+// Provenance-includes-copyright: The Prometheus Authors
+
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// AndUnlessBinaryOperation represents the "and" and "unless" set operations between two instant vectors.
+//
+// "and" returns each sample from the left side that has a sample with the same matching labels on the right side
+// at the same time step. "unless" returns each sample from the left side that does not.
+type AndUnlessBinaryOperation struct {
+	Left           InstantVectorOperator
+	Right          InstantVectorOperator
+	VectorMatching parser.VectorMatching
+	IsUnless       bool // If true, this operator represents an 'unless', if false, this operator represents an 'and'
+
+	Start    int64 // Milliseconds since Unix epoch
+	End      int64 // Milliseconds since Unix epoch
+	Interval int64 // In milliseconds
+
+	MemoryConsumptionTracker *limiting.MemoryConsumptionTracker
+
+	rightGroups       []*setOperationGroup
+	leftSeriesGroups  []*setOperationGroup // One entry per series produced by Left, value is the group on the right side with the same matching labels, or nil if there is no such group
+	rightSeriesGroups []*setOperationGroup // One entry per series produced by Right, value is the group for that series
+	haveReadRightSide bool
+	nextLeftSeriesIdx int
+}
+
+var _ InstantVectorOperator = &AndUnlessBinaryOperation{}
+
+// setOperationGroup tracks the time steps at which any series with the same matching labels has a sample.
+type setOperationGroup struct {
+	present []bool
+}
+
+func (a *AndUnlessBinaryOperation) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error) {
+	if a.VectorMatching.Card != parser.CardManyToMany {
+		return nil, fmt.Errorf("set operations must only use many-to-many matching, but got %s", a.VectorMatching.Card)
+	}
+
+	leftMetadata, err := a.Left.SeriesMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(leftMetadata) == 0 {
+		// We can't produce any series, we are done.
+		PutSeriesMetadataSlice(leftMetadata, a.MemoryConsumptionTracker)
+		return nil, nil
+	}
+
+	rightMetadata, err := a.Right.SeriesMetadata(ctx)
+	if err != nil {
+		PutSeriesMetadataSlice(leftMetadata, a.MemoryConsumptionTracker)
+		return nil, err
+	}
+
+	defer PutSeriesMetadataSlice(rightMetadata, a.MemoryConsumptionTracker)
+
+	if len(rightMetadata) == 0 && !a.IsUnless {
+		// Nothing on the right side means 'and' can't produce any series.
+		PutSeriesMetadataSlice(leftMetadata, a.MemoryConsumptionTracker)
+		return nil, nil
+	}
+
+	groupKeyFunc := vectorMatchingGroupKeyFunc(a.VectorMatching)
+	groupsByKey := map[string]*setOperationGroup{}
+	a.rightSeriesGroups = make([]*setOperationGroup, 0, len(rightMetadata))
+
+	for _, series := range rightMetadata {
+		key := groupKeyFunc(series.Labels)
+		g, exists := groupsByKey[string(key)]
+
+		if !exists {
+			g = &setOperationGroup{}
+			groupsByKey[string(key)] = g
+			a.rightGroups = append(a.rightGroups, g)
+		}
+
+		a.rightSeriesGroups = append(a.rightSeriesGroups, g)
+	}
+
+	a.leftSeriesGroups = make([]*setOperationGroup, 0, len(leftMetadata))
+	outputMetadata := leftMetadata[:0]
+
+	for _, series := range leftMetadata {
+		g := groupsByKey[string(groupKeyFunc(series.Labels))]
+		a.leftSeriesGroups = append(a.leftSeriesGroups, g)
+
+		if g != nil || a.IsUnless {
+			// 'unless' returns every series from the left side, 'and' only returns those with a matching group on the right side.
+			outputMetadata = append(outputMetadata, series)
+		}
+	}
+
+	return outputMetadata, nil
+}
+
+func (a *AndUnlessBinaryOperation) Next(ctx context.Context) (InstantVectorSeriesData, error) {
+	if !a.haveReadRightSide {
+		if err := a.readRightSide(ctx); err != nil {
+			return InstantVectorSeriesData{}, err
+		}
+	}
+
+	for a.nextLeftSeriesIdx < len(a.leftSeriesGroups) {
+		g := a.leftSeriesGroups[a.nextLeftSeriesIdx]
+		a.nextLeftSeriesIdx++
+
+		d, err := a.Left.Next(ctx)
+		if err != nil {
+			if errors.Is(err, EOS) {
+				return InstantVectorSeriesData{}, fmt.Errorf("exhausted left side series before all series were read: %w", err)
+			}
+
+			return InstantVectorSeriesData{}, err
+		}
+
+		if g == nil {
+			if a.IsUnless {
+				// Nothing on the right side to remove, return the series unchanged.
+				return d, nil
+			}
+
+			// Nothing on the right side to match, so we don't return this series.
+			PutFPointSlice(d.Floats, a.MemoryConsumptionTracker)
+			PutHPointSlice(d.Histograms, a.MemoryConsumptionTracker)
+			continue
+		}
+
+		return a.filterPoints(d, g), nil
+	}
+
+	return InstantVectorSeriesData{}, EOS
+}
+
+func (a *AndUnlessBinaryOperation) readRightSide(ctx context.Context) error {
+	a.haveReadRightSide = true
+	numSteps := stepCount(a.Start, a.End, a.Interval)
+
+	for _, g := range a.rightSeriesGroups {
+		d, err := a.Right.Next(ctx)
+		if err != nil {
+			if errors.Is(err, EOS) {
+				return fmt.Errorf("exhausted right side series before all series were read: %w", err)
+			}
+
+			return err
+		}
+
+		if g.present == nil {
+			g.present, err = getZeroedBoolSlice(numSteps, a.MemoryConsumptionTracker)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, p := range d.Floats {
+			g.present[(p.T-a.Start)/a.Interval] = true
+		}
+
+		for _, p := range d.Histograms {
+			g.present[(p.T-a.Start)/a.Interval] = true
+		}
+
+		PutFPointSlice(d.Floats, a.MemoryConsumptionTracker)
+		PutHPointSlice(d.Histograms, a.MemoryConsumptionTracker)
+	}
+
+	a.rightSeriesGroups = nil
+
+	return nil
+}
+
+// filterPoints removes points from d that should not be returned, reusing the slices in d.
+func (a *AndUnlessBinaryOperation) filterPoints(d InstantVectorSeriesData, g *setOperationGroup) InstantVectorSeriesData {
+	filteredFloats := d.Floats[:0]
+
+	for _, p := range d.Floats {
+		matched := g.present[(p.T-a.Start)/a.Interval]
+
+		if matched != a.IsUnless {
+			filteredFloats = append(filteredFloats, p)
+		}
+	}
+
+	filteredHistograms := d.Histograms[:0]
+
+	for _, p := range d.Histograms {
+		matched := g.present[(p.T-a.Start)/a.Interval]
+
+		if matched != a.IsUnless {
+			filteredHistograms = append(filteredHistograms, p)
+		}
+	}
+
+	return InstantVectorSeriesData{Floats: filteredFloats, Histograms: filteredHistograms}
+}
+
+func (a *AndUnlessBinaryOperation) Close() {
+	a.Left.Close()
+	a.Right.Close()
+
+	for _, g := range a.rightGroups {
+		if g.present != nil {
+			PutBoolSlice(g.present, a.MemoryConsumptionTracker)
+			g.present = nil
+		}
+	}
+
+	a.rightGroups = nil
+}
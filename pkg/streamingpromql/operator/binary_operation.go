@@ -0,0 +1,704 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// Provenance-includes-location: https://github.com/prometheus/prometheus/blob/main/promql/engine.go
+// Provenance-includes-license: Apache-2.0
+// Provenance-includes-copyright: The Prometheus Authors
+
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// BinaryOperation represents an arithmetic or comparison binary operation between two instant vectors,
+// such as "<expr> + <expr>" or "<expr> > bool <expr>".
+//
+// Set operations ("and", "or" and "unless") are handled by AndUnlessBinaryOperation and OrBinaryOperation.
+type BinaryOperation struct {
+	Left           InstantVectorOperator
+	Right          InstantVectorOperator
+	Op             parser.ItemType
+	ReturnBool     bool
+	VectorMatching parser.VectorMatching
+
+	Start    int64 // Milliseconds since Unix epoch
+	End      int64 // Milliseconds since Unix epoch
+	Interval int64 // In milliseconds
+
+	MemoryConsumptionTracker *limiting.MemoryConsumptionTracker
+
+	opFunc binaryOperationFunc
+
+	remainingGroups []*binaryOperationGroup // One entry per match group, in the order we'll compute them
+	pendingOutputs  []InstantVectorSeriesData
+	leftBuffer      *instantVectorOperatorBuffer
+	rightBuffer     *instantVectorOperatorBuffer
+}
+
+var _ InstantVectorOperator = &BinaryOperation{}
+
+// binaryOperationGroup contains the series from both sides of a binary operation that have the same matching labels,
+// and the output series they produce.
+type binaryOperationGroup struct {
+	leftSeriesIndices  []int
+	leftSeriesLabels   []labels.Labels
+	rightSeriesIndices []int
+	rightSeriesLabels  []labels.Labels
+
+	// The labels of the output series produced by this group, in the order they are returned.
+	outputSeriesLabels []labels.Labels
+
+	// The index into outputSeriesLabels of the output series produced by each combination of input series.
+	// The output series for the i-th series on the "many" side and the j-th series on the "one" side is at
+	// index i*(number of series on the "one" side)+j.
+	outputSeriesIndices []int
+}
+
+func (g *binaryOperationGroup) lastLeftSeriesIndex() int {
+	return g.leftSeriesIndices[len(g.leftSeriesIndices)-1]
+}
+
+func (g *binaryOperationGroup) lastRightSeriesIndex() int {
+	return g.rightSeriesIndices[len(g.rightSeriesIndices)-1]
+}
+
+func (b *BinaryOperation) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error) {
+	var ok bool
+	b.opFunc, ok = arithmeticAndComparisonOperationFuncs[b.Op]
+	if !ok {
+		return nil, fmt.Errorf("unsupported binary operation '%s'", b.Op)
+	}
+
+	if b.VectorMatching.Card == parser.CardManyToMany {
+		return nil, fmt.Errorf("many-to-many matching is only supported for set operations, not '%s'", b.Op)
+	}
+
+	leftMetadata, err := b.Left.SeriesMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer PutSeriesMetadataSlice(leftMetadata, b.MemoryConsumptionTracker)
+
+	if len(leftMetadata) == 0 {
+		// We can't produce any series, we are done.
+		return nil, nil
+	}
+
+	rightMetadata, err := b.Right.SeriesMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer PutSeriesMetadataSlice(rightMetadata, b.MemoryConsumptionTracker)
+
+	if len(rightMetadata) == 0 {
+		// We can't produce any series, we are done.
+		return nil, nil
+	}
+
+	groupKeyFunc := vectorMatchingGroupKeyFunc(b.VectorMatching)
+	groupsByKey := map[string]*binaryOperationGroup{}
+	groups := make([]*binaryOperationGroup, 0, len(leftMetadata))
+
+	for idx, series := range leftMetadata {
+		key := groupKeyFunc(series.Labels)
+		g, exists := groupsByKey[string(key)]
+
+		if !exists {
+			g = &binaryOperationGroup{}
+			groupsByKey[string(key)] = g
+			groups = append(groups, g)
+		}
+
+		g.leftSeriesIndices = append(g.leftSeriesIndices, idx)
+		g.leftSeriesLabels = append(g.leftSeriesLabels, series.Labels)
+	}
+
+	for idx, series := range rightMetadata {
+		key := groupKeyFunc(series.Labels)
+		g, exists := groupsByKey[string(key)]
+
+		if !exists {
+			// No series on the left side with these matching labels, so there's nothing to do with this series.
+			continue
+		}
+
+		g.rightSeriesIndices = append(g.rightSeriesIndices, idx)
+		g.rightSeriesLabels = append(g.rightSeriesLabels, series.Labels)
+	}
+
+	leftSeriesUsed := make([]bool, len(leftMetadata))
+	rightSeriesUsed := make([]bool, len(rightMetadata))
+	lb := labels.NewBuilder(labels.EmptyLabels())
+	b.remainingGroups = make([]*binaryOperationGroup, 0, len(groups))
+	outputSeriesCount := 0
+
+	for _, g := range groups {
+		if len(g.rightSeriesIndices) == 0 {
+			// No series on the right side with these matching labels, so this group won't produce any output series.
+			continue
+		}
+
+		b.computeOutputSeriesForGroup(g, lb)
+		outputSeriesCount += len(g.outputSeriesLabels)
+		b.remainingGroups = append(b.remainingGroups, g)
+
+		for _, idx := range g.leftSeriesIndices {
+			leftSeriesUsed[idx] = true
+		}
+
+		for _, idx := range g.rightSeriesIndices {
+			rightSeriesUsed[idx] = true
+		}
+	}
+
+	// Compute groups in the order in which their input series are produced, to minimise the number of series
+	// we need to buffer.
+	sort.Slice(b.remainingGroups, func(i, j int) bool {
+		first, second := b.remainingGroups[i], b.remainingGroups[j]
+
+		if first.lastLeftSeriesIndex() != second.lastLeftSeriesIndex() {
+			return first.lastLeftSeriesIndex() < second.lastLeftSeriesIndex()
+		}
+
+		return first.lastRightSeriesIndex() < second.lastRightSeriesIndex()
+	})
+
+	b.leftBuffer = newInstantVectorOperatorBuffer(b.Left, leftSeriesUsed, b.MemoryConsumptionTracker)
+	b.rightBuffer = newInstantVectorOperatorBuffer(b.Right, rightSeriesUsed, b.MemoryConsumptionTracker)
+
+	metadata, err := GetSeriesMetadataSlice(outputSeriesCount, b.MemoryConsumptionTracker)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, g := range b.remainingGroups {
+		for _, l := range g.outputSeriesLabels {
+			metadata = append(metadata, SeriesMetadata{Labels: l})
+		}
+	}
+
+	return metadata, nil
+}
+
+// manyAndOneSides returns the series from the "many" and "one" sides of this operation.
+// For one-to-one matching, the left side is considered the "many" side.
+func (b *BinaryOperation) manyAndOneSides(left, right []labels.Labels) ([]labels.Labels, []labels.Labels) {
+	if b.VectorMatching.Card == parser.CardOneToMany {
+		return right, left
+	}
+
+	return left, right
+}
+
+func (b *BinaryOperation) computeOutputSeriesForGroup(g *binaryOperationGroup, lb *labels.Builder) {
+	manySide, oneSide := b.manyAndOneSides(g.leftSeriesLabels, g.rightSeriesLabels)
+	outputSeriesIndicesByLabels := map[string]int{}
+	g.outputSeriesIndices = make([]int, 0, len(manySide)*len(oneSide))
+	buf := make([]byte, 0, 1024)
+
+	for _, manySeries := range manySide {
+		for _, oneSeries := range oneSide {
+			l := b.resultMetric(manySeries, oneSeries, lb)
+			buf = l.Bytes(buf)
+			idx, exists := outputSeriesIndicesByLabels[string(buf)]
+
+			if !exists {
+				idx = len(g.outputSeriesLabels)
+				outputSeriesIndicesByLabels[string(buf)] = idx
+				g.outputSeriesLabels = append(g.outputSeriesLabels, l)
+			}
+
+			g.outputSeriesIndices = append(g.outputSeriesIndices, idx)
+		}
+	}
+}
+
+// resultMetric returns the labels of the output series for a pair of input series.
+//
+// This is based on resultMetric from Prometheus' engine.
+func (b *BinaryOperation) resultMetric(manySide, oneSide labels.Labels, lb *labels.Builder) labels.Labels {
+	lb.Reset(manySide)
+
+	if shouldDropMetricName(b.Op) || b.ReturnBool {
+		lb.Del(labels.MetricName)
+	}
+
+	if b.VectorMatching.Card == parser.CardOneToOne {
+		if b.VectorMatching.On {
+			lb.Keep(b.VectorMatching.MatchingLabels...)
+		} else {
+			lb.Del(b.VectorMatching.MatchingLabels...)
+		}
+	}
+
+	for _, ln := range b.VectorMatching.Include {
+		// Included labels from the group_left / group_right modifier are taken from the "one" side.
+		if v := oneSide.Get(ln); v != "" {
+			lb.Set(ln, v)
+		} else {
+			lb.Del(ln)
+		}
+	}
+
+	return lb.Labels()
+}
+
+func (b *BinaryOperation) Next(ctx context.Context) (InstantVectorSeriesData, error) {
+	if len(b.pendingOutputs) == 0 {
+		if len(b.remainingGroups) == 0 {
+			return InstantVectorSeriesData{}, EOS
+		}
+
+		thisGroup := b.remainingGroups[0]
+		b.remainingGroups = b.remainingGroups[1:]
+
+		var err error
+		b.pendingOutputs, err = b.computeGroupResult(ctx, thisGroup)
+		if err != nil {
+			return InstantVectorSeriesData{}, err
+		}
+	}
+
+	next := b.pendingOutputs[0]
+	b.pendingOutputs = b.pendingOutputs[1:]
+
+	return next, nil
+}
+
+func (b *BinaryOperation) computeGroupResult(ctx context.Context, g *binaryOperationGroup) ([]InstantVectorSeriesData, error) {
+	leftData, err := b.leftBuffer.getSeries(ctx, g.leftSeriesIndices)
+	if err != nil {
+		return nil, err
+	}
+
+	defer putInstantVectorSeriesDataSlices(leftData, b.MemoryConsumptionTracker)
+
+	rightData, err := b.rightBuffer.getSeries(ctx, g.rightSeriesIndices)
+	if err != nil {
+		return nil, err
+	}
+
+	defer putInstantVectorSeriesDataSlices(rightData, b.MemoryConsumptionTracker)
+
+	manySideData, oneSideData := leftData, rightData
+	_, oneSideLabels := b.manyAndOneSides(g.leftSeriesLabels, g.rightSeriesLabels)
+	oneSideName := "right"
+
+	if b.VectorMatching.Card == parser.CardOneToMany {
+		manySideData, oneSideData = rightData, leftData
+		oneSideName = "left"
+	}
+
+	numSteps := stepCount(b.Start, b.End, b.Interval)
+
+	// Determine the value on the "one" side at each step, and make sure there's at most one series with a value at each step.
+	oneSideValues, err := getZeroedFloatSlice(numSteps, b.MemoryConsumptionTracker)
+	if err != nil {
+		return nil, err
+	}
+
+	defer PutFloatSlice(oneSideValues, b.MemoryConsumptionTracker)
+
+	// The histogram on the "one" side at each step, if any. Histograms are only tracked if there are any.
+	var oneSideHistograms []*histogram.FloatHistogram
+	if containsHistograms(oneSideData) {
+		oneSideHistograms = make([]*histogram.FloatHistogram, numSteps)
+	}
+
+	oneSideSeriesIndices := make([]int, numSteps)
+	for i := range oneSideSeriesIndices {
+		oneSideSeriesIndices[i] = -1
+	}
+
+	addOneSidePoint := func(seriesIdx int, t int64, f float64, h *histogram.FloatHistogram) error {
+		step := (t - b.Start) / b.Interval
+
+		if existingSeriesIdx := oneSideSeriesIndices[step]; existingSeriesIdx != -1 {
+			matchedLabels := oneSideLabels[seriesIdx].MatchLabels(b.VectorMatching.On, b.VectorMatching.MatchingLabels...)
+
+			return fmt.Errorf("found duplicate series for the match group %s on the %s hand-side of the operation: [%s, %s];many-to-many matching not allowed: matching labels must be unique on one side",
+				matchedLabels.String(), oneSideName, oneSideLabels[seriesIdx].String(), oneSideLabels[existingSeriesIdx].String())
+		}
+
+		oneSideSeriesIndices[step] = seriesIdx
+		oneSideValues[step] = f
+		if h != nil {
+			oneSideHistograms[step] = h
+		}
+
+		return nil
+	}
+
+	for seriesIdx, d := range oneSideData {
+		for _, p := range d.Floats {
+			if err := addOneSidePoint(seriesIdx, p.T, p.F, nil); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, p := range d.Histograms {
+			if err := addOneSidePoint(seriesIdx, p.T, 0, p.H); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	outputValues := make([][]float64, len(g.outputSeriesLabels))
+	outputHistograms := make([][]*histogram.FloatHistogram, len(g.outputSeriesLabels))
+	outputPresent := make([][]bool, len(g.outputSeriesLabels))
+
+	defer func() {
+		for i := range outputValues {
+			if outputValues[i] != nil {
+				PutFloatSlice(outputValues[i], b.MemoryConsumptionTracker)
+			}
+
+			if outputPresent[i] != nil {
+				PutBoolSlice(outputPresent[i], b.MemoryConsumptionTracker)
+			}
+		}
+	}()
+
+	// For one-to-one matching, each step can only have a single match in this group.
+	var matchedAtStep []bool
+	if b.VectorMatching.Card == parser.CardOneToOne {
+		matchedAtStep, err = getZeroedBoolSlice(numSteps, b.MemoryConsumptionTracker)
+		if err != nil {
+			return nil, err
+		}
+
+		defer PutBoolSlice(matchedAtStep, b.MemoryConsumptionTracker)
+	}
+
+	addManySidePoint := func(manySeriesIdx int, t int64, f float64, h *histogram.FloatHistogram) error {
+		step := (t - b.Start) / b.Interval
+		oneSeriesIdx := oneSideSeriesIndices[step]
+
+		if oneSeriesIdx == -1 {
+			// No matching sample on the other side.
+			return nil
+		}
+
+		left, right := f, oneSideValues[step]
+		var leftH, rightH *histogram.FloatHistogram = h, nil
+		if oneSideHistograms != nil {
+			rightH = oneSideHistograms[step]
+		}
+
+		if b.VectorMatching.Card == parser.CardOneToMany {
+			left, right = right, left
+			leftH, rightH = rightH, leftH
+		}
+
+		f, h, keep := vectorElemBinop(b.Op, b.opFunc, left, right, leftH, rightH)
+
+		if b.ReturnBool {
+			f, h = btos(keep), nil
+		} else if !keep {
+			return nil
+		}
+
+		if matchedAtStep != nil {
+			if matchedAtStep[step] {
+				return errors.New("multiple matches for labels: many-to-one matching must be explicit (group_left/group_right)")
+			}
+
+			matchedAtStep[step] = true
+		}
+
+		outputIdx := g.outputSeriesIndices[manySeriesIdx*len(oneSideLabels)+oneSeriesIdx]
+
+		if outputValues[outputIdx] == nil {
+			var err error
+			if outputValues[outputIdx], err = getZeroedFloatSlice(numSteps, b.MemoryConsumptionTracker); err != nil {
+				return err
+			}
+
+			if outputPresent[outputIdx], err = getZeroedBoolSlice(numSteps, b.MemoryConsumptionTracker); err != nil {
+				return err
+			}
+		} else if outputPresent[outputIdx][step] {
+			return errors.New("multiple matches for labels: grouping labels must ensure unique matches")
+		}
+
+		if h != nil {
+			if outputHistograms[outputIdx] == nil {
+				outputHistograms[outputIdx] = make([]*histogram.FloatHistogram, numSteps)
+			}
+
+			outputHistograms[outputIdx][step] = h
+		}
+
+		outputValues[outputIdx][step] = f
+		outputPresent[outputIdx][step] = true
+
+		return nil
+	}
+
+	for manySeriesIdx, d := range manySideData {
+		for _, p := range d.Floats {
+			if err := addManySidePoint(manySeriesIdx, p.T, p.F, nil); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, p := range d.Histograms {
+			if err := addManySidePoint(manySeriesIdx, p.T, 0, p.H); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	outputs := make([]InstantVectorSeriesData, len(g.outputSeriesLabels))
+
+	for outputIdx, present := range outputPresent {
+		if present == nil {
+			// No points for this output series.
+			continue
+		}
+
+		histograms := outputHistograms[outputIdx]
+		if histograms != nil {
+			// Steps with a histogram are returned as histogram points rather than float points.
+			for step, h := range histograms {
+				if h != nil {
+					present[step] = false
+				}
+			}
+		}
+
+		points, err := stepValuesToPoints(outputValues[outputIdx], present, b.Start, b.Interval, b.MemoryConsumptionTracker)
+		if err != nil {
+			putInstantVectorSeriesDataSlices(outputs, b.MemoryConsumptionTracker)
+			return nil, err
+		}
+
+		outputs[outputIdx] = InstantVectorSeriesData{Floats: points}
+
+		if histograms != nil {
+			hPoints, err := stepHistogramsToPoints(histograms, b.Start, b.Interval, b.MemoryConsumptionTracker)
+			if err != nil {
+				PutFPointSlice(points, b.MemoryConsumptionTracker)
+				putInstantVectorSeriesDataSlices(outputs, b.MemoryConsumptionTracker)
+				return nil, err
+			}
+
+			outputs[outputIdx].Histograms = hPoints
+		}
+	}
+
+	return outputs, nil
+}
+
+func (b *BinaryOperation) BufferedPoints() int {
+	points := countPoints(b.pendingOutputs...)
+
+	if b.leftBuffer != nil {
+		points += b.leftBuffer.bufferedPoints()
+	}
+
+	if b.rightBuffer != nil {
+		points += b.rightBuffer.bufferedPoints()
+	}
+
+	return points
+}
+
+func (b *BinaryOperation) Close() {
+	b.Left.Close()
+	b.Right.Close()
+
+	if b.leftBuffer != nil {
+		b.leftBuffer.close()
+	}
+
+	if b.rightBuffer != nil {
+		b.rightBuffer.close()
+	}
+
+	putInstantVectorSeriesDataSlices(b.pendingOutputs, b.MemoryConsumptionTracker)
+
+	b.pendingOutputs = nil
+}
+
+// vectorMatchingGroupKeyFunc returns a function that computes the key used to match series from both sides of a
+// binary operation, based on the matching labels.
+//
+// This is based on signatureFunc from Prometheus' engine.
+func vectorMatchingGroupKeyFunc(vectorMatching parser.VectorMatching) func(labels.Labels) []byte {
+	buf := make([]byte, 0, 1024)
+
+	if vectorMatching.On {
+		names := slices.Clone(vectorMatching.MatchingLabels)
+		slices.Sort(names)
+
+		return func(l labels.Labels) []byte {
+			return l.BytesWithLabels(buf, names...)
+		}
+	}
+
+	names := make([]string, 0, len(vectorMatching.MatchingLabels)+1)
+	names = append(names, labels.MetricName)
+	names = append(names, vectorMatching.MatchingLabels...)
+	slices.Sort(names)
+
+	return func(l labels.Labels) []byte {
+		return l.BytesWithoutLabels(buf, names...)
+	}
+}
+
+// stepValuesToPoints converts a slice of values, one per step, to a slice of points, skipping steps that are not present.
+func stepValuesToPoints(values []float64, present []bool, start, interval int64, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) ([]promql.FPoint, error) {
+	pointCount := 0
+	for _, p := range present {
+		if p {
+			pointCount++
+		}
+	}
+
+	points, err := GetFPointSlice(pointCount, memoryConsumptionTracker)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, p := range present {
+		if p {
+			t := start + int64(i)*interval
+			points = append(points, promql.FPoint{T: t, F: values[i]})
+		}
+	}
+
+	return points, nil
+}
+
+// stepHistogramsToPoints converts a slice of histograms, one per step, to a slice of points, skipping steps without a histogram.
+func stepHistogramsToPoints(histograms []*histogram.FloatHistogram, start, interval int64, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) ([]promql.HPoint, error) {
+	pointCount := 0
+	for _, h := range histograms {
+		if h != nil {
+			pointCount++
+		}
+	}
+
+	points, err := GetHPointSlice(pointCount, memoryConsumptionTracker)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, h := range histograms {
+		if h != nil {
+			t := start + int64(i)*interval
+			points = append(points, promql.HPoint{T: t, H: h})
+		}
+	}
+
+	return points, nil
+}
+
+func putInstantVectorSeriesDataSlices(data []InstantVectorSeriesData, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) {
+	for _, d := range data {
+		PutFPointSlice(d.Floats, memoryConsumptionTracker)
+		PutHPointSlice(d.Histograms, memoryConsumptionTracker)
+	}
+}
+
+func containsHistograms(data []InstantVectorSeriesData) bool {
+	for _, d := range data {
+		if len(d.Histograms) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// binaryOperationFunc computes the result of a binary operation between two values,
+// and returns false if the operation is a comparison that filters out this result.
+type binaryOperationFunc func(left, right float64) (float64, bool)
+
+// This is based on vectorElemBinop from Prometheus' engine.
+var arithmeticAndComparisonOperationFuncs = map[parser.ItemType]binaryOperationFunc{
+	parser.ADD:   func(left, right float64) (float64, bool) { return left + right, true },
+	parser.SUB:   func(left, right float64) (float64, bool) { return left - right, true },
+	parser.MUL:   func(left, right float64) (float64, bool) { return left * right, true },
+	parser.DIV:   func(left, right float64) (float64, bool) { return left / right, true },
+	parser.POW:   func(left, right float64) (float64, bool) { return math.Pow(left, right), true },
+	parser.MOD:   func(left, right float64) (float64, bool) { return math.Mod(left, right), true },
+	parser.ATAN2: func(left, right float64) (float64, bool) { return math.Atan2(left, right), true },
+	parser.EQLC:  func(left, right float64) (float64, bool) { return left, left == right },
+	parser.NEQ:   func(left, right float64) (float64, bool) { return left, left != right },
+	parser.GTR:   func(left, right float64) (float64, bool) { return left, left > right },
+	parser.LSS:   func(left, right float64) (float64, bool) { return left, left < right },
+	parser.GTE:   func(left, right float64) (float64, bool) { return left, left >= right },
+	parser.LTE:   func(left, right float64) (float64, bool) { return left, left <= right },
+}
+
+// vectorElemBinop computes the result of a binary operation between two samples, each of which is either a float or a
+// histogram. Histograms can be added to and subtracted from histograms, and multiplied or divided by floats. Any other
+// operation involving a histogram is computed on the float values, like Prometheus' engine does, where a histogram
+// sample has a float value of 0.
+//
+// This is based on vectorElemBinop from Prometheus' engine.
+func vectorElemBinop(op parser.ItemType, opFunc binaryOperationFunc, left, right float64, leftH, rightH *histogram.FloatHistogram) (float64, *histogram.FloatHistogram, bool) {
+	switch op {
+	case parser.ADD:
+		if leftH != nil && rightH != nil {
+			return 0, leftH.Copy().Add(rightH).Compact(0), true
+		}
+	case parser.SUB:
+		if leftH != nil && rightH != nil {
+			return 0, leftH.Copy().Sub(rightH).Compact(0), true
+		}
+	case parser.MUL:
+		if leftH != nil && rightH == nil {
+			return 0, leftH.Copy().Mul(right), true
+		}
+		if leftH == nil && rightH != nil {
+			return 0, rightH.Copy().Mul(left), true
+		}
+	case parser.DIV:
+		if leftH != nil && rightH == nil {
+			return 0, leftH.Copy().Div(right), true
+		}
+	}
+
+	f, keep := opFunc(left, right)
+	return f, nil, keep
+}
+
+// IsSupportedBinaryOperation returns true if op is an arithmetic or comparison operation supported by
+// BinaryOperation, VectorScalarBinaryOperation and ScalarScalarBinaryOperation.
+func IsSupportedBinaryOperation(op parser.ItemType) bool {
+	_, ok := arithmeticAndComparisonOperationFuncs[op]
+	return ok
+}
+
+func shouldDropMetricName(op parser.ItemType) bool {
+	switch op {
+	case parser.ADD, parser.SUB, parser.DIV, parser.MUL, parser.POW, parser.MOD, parser.ATAN2:
+		return true
+	default:
+		return false
+	}
+}
+
+func btos(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,185 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// Provenance-includes-location: https://github.com/prometheus/prometheus/blob/main/promql/engine.go
+// Provenance-includes-license: Apache-2.0
+// Provenance-includes-copyright: The Prometheus Authors
+
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"golang.org/x/exp/slices"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// CountValues represents the count_values aggregation.
+//
+// The output series of count_values depend on the values of the input series, so, unlike other aggregations,
+// all input series are read in SeriesMetadata.
+type CountValues struct {
+	Inner     InstantVectorOperator
+	LabelName string
+	Grouping  []string // Must be sorted.
+	Without   bool
+
+	Start    int64 // Milliseconds since Unix epoch
+	End      int64 // Milliseconds since Unix epoch
+	Interval int64 // In milliseconds
+
+	MemoryConsumptionTracker *limiting.MemoryConsumptionTracker
+
+	outputCounts [][]float64 // One entry per output series, containing the count at each step.
+}
+
+var _ InstantVectorOperator = &CountValues{}
+
+type countValuesGroup struct {
+	labels labels.Labels
+	counts []float64
+}
+
+func (c *CountValues) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error) {
+	if !model.LabelName(c.LabelName).IsValid() {
+		return nil, fmt.Errorf("invalid label name %q", c.LabelName)
+	}
+
+	innerMetadata, err := c.Inner.SeriesMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer PutSeriesMetadataSlice(innerMetadata, c.MemoryConsumptionTracker)
+
+	grouping := c.Grouping
+
+	if !c.Without {
+		// Like Prometheus' engine, the label added by count_values is always part of the grouping when using 'by'.
+		grouping = append(slices.Clone(c.Grouping), c.LabelName)
+		slices.Sort(grouping)
+	}
+
+	steps := stepCount(c.Start, c.End, c.Interval)
+	groups := map[uint64]*countValuesGroup{}
+	buf := make([]byte, 0, 1024)
+	lb := labels.NewBuilder(labels.EmptyLabels())
+	groupLabelsBuilder := labels.NewBuilder(labels.EmptyLabels())
+
+	releaseGroups := func() {
+		for _, g := range groups {
+			PutFloatSlice(g.counts, c.MemoryConsumptionTracker)
+		}
+	}
+
+	for _, series := range innerMetadata {
+		d, err := c.Inner.Next(ctx)
+		if err != nil {
+			releaseGroups()
+
+			if errors.Is(err, EOS) {
+				return nil, fmt.Errorf("exhausted series before all series were read: %w", err)
+			}
+
+			return nil, err
+		}
+
+		if len(d.Histograms) > 0 {
+			PutFPointSlice(d.Floats, c.MemoryConsumptionTracker)
+			PutHPointSlice(d.Histograms, c.MemoryConsumptionTracker)
+			releaseGroups()
+			return nil, errHistogramsNotSupported
+		}
+
+		for _, p := range d.Floats {
+			lb.Reset(series.Labels)
+			lb.Set(c.LabelName, strconv.FormatFloat(p.F, 'f', -1, 64))
+			m := lb.Labels()
+
+			// Note that, like Prometheus' engine, this doesn't handle hash collisions between groups.
+			var groupingKey uint64
+			groupingKey, buf = groupingKeyForSeries(m, grouping, c.Without, buf)
+			g, groupExists := groups[groupingKey]
+
+			if !groupExists {
+				counts, err := getZeroedFloatSlice(steps, c.MemoryConsumptionTracker)
+				if err != nil {
+					PutFPointSlice(d.Floats, c.MemoryConsumptionTracker)
+					PutHPointSlice(d.Histograms, c.MemoryConsumptionTracker)
+					releaseGroups()
+					return nil, err
+				}
+
+				g = &countValuesGroup{
+					labels: labelsForGroup(m, grouping, c.Without, groupLabelsBuilder),
+					counts: counts,
+				}
+
+				groups[groupingKey] = g
+			}
+
+			g.counts[(p.T-c.Start)/c.Interval]++
+		}
+
+		PutFPointSlice(d.Floats, c.MemoryConsumptionTracker)
+		PutHPointSlice(d.Histograms, c.MemoryConsumptionTracker)
+	}
+
+	outputGroups := make([]*countValuesGroup, 0, len(groups))
+
+	for _, g := range groups {
+		outputGroups = append(outputGroups, g)
+	}
+
+	sort.Slice(outputGroups, func(i, j int) bool {
+		return labels.Compare(outputGroups[i].labels, outputGroups[j].labels) < 0
+	})
+
+	outputMetadata, err := GetSeriesMetadataSlice(len(outputGroups), c.MemoryConsumptionTracker)
+	if err != nil {
+		releaseGroups()
+		return nil, err
+	}
+
+	c.outputCounts = make([][]float64, 0, len(outputGroups))
+
+	for _, g := range outputGroups {
+		outputMetadata = append(outputMetadata, SeriesMetadata{Labels: g.labels})
+		c.outputCounts = append(c.outputCounts, g.counts)
+	}
+
+	return outputMetadata, nil
+}
+
+func (c *CountValues) Next(_ context.Context) (InstantVectorSeriesData, error) {
+	if len(c.outputCounts) == 0 {
+		return InstantVectorSeriesData{}, EOS
+	}
+
+	counts := c.outputCounts[0]
+	c.outputCounts = c.outputCounts[1:]
+
+	points, err := pointsForNonZeroCounts(counts, c.Start, c.Interval, func(idx int) float64 { return counts[idx] }, c.MemoryConsumptionTracker)
+	PutFloatSlice(counts, c.MemoryConsumptionTracker)
+
+	if err != nil {
+		return InstantVectorSeriesData{}, err
+	}
+
+	return InstantVectorSeriesData{Floats: points}, nil
+}
+
+func (c *CountValues) Close() {
+	c.Inner.Close()
+
+	for _, counts := range c.outputCounts {
+		PutFloatSlice(counts, c.MemoryConsumptionTracker)
+	}
+
+	c.outputCounts = nil
+}
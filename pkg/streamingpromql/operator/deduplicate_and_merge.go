@@ -0,0 +1,209 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package operator
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// DeduplicateAndMerge merges series with the same labels into a single output series.
+//
+// This is required after operations that may produce multiple series with the same labels, such as
+// operations that drop the metric name (eg. `metric * 2`) or set operations (eg. `a or b`).
+//
+// Like Prometheus' engine, series with the same labels are allowed if they never have samples at the same time step.
+// If they do, the query fails, as there is no way to decide which sample to keep.
+type DeduplicateAndMerge struct {
+	Inner InstantVectorOperator
+
+	// If RejectNonOverlappingSeries is true, the query fails if more than one series with the same labels has samples,
+	// even if they never have samples at the same time step. This matches the behaviour of Prometheus' engine for
+	// functions over range vectors, such as rate.
+	RejectNonOverlappingSeries bool
+
+	MemoryConsumptionTracker *limiting.MemoryConsumptionTracker
+
+	// If groups is nil, the inner operator produced no duplicate series and all series can be passed through unchanged.
+	groups [][]int // One entry per output series, containing the indices of the inner series that make up that output series.
+	buffer *instantVectorOperatorBuffer
+}
+
+var _ InstantVectorOperator = &DeduplicateAndMerge{}
+
+var errVectorContainsSameLabelset = errors.New("vector cannot contain metrics with the same labelset")
+
+func (d *DeduplicateAndMerge) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error) {
+	innerMetadata, err := d.Inner.SeriesMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	groupIndices := make(map[uint64]int, len(innerMetadata))
+	haveDuplicates := false
+	groups := make([][]int, 0, len(innerMetadata))
+	outputMetadata, err := GetSeriesMetadataSlice(len(innerMetadata), d.MemoryConsumptionTracker)
+	if err != nil {
+		PutSeriesMetadataSlice(innerMetadata, d.MemoryConsumptionTracker)
+		return nil, err
+	}
+
+	for innerIdx, series := range innerMetadata {
+		// Note that, like Prometheus' engine, this doesn't handle hash collisions.
+		hash := series.Labels.Hash()
+
+		if groupIdx, exists := groupIndices[hash]; exists {
+			groups[groupIdx] = append(groups[groupIdx], innerIdx)
+			haveDuplicates = true
+			continue
+		}
+
+		groupIndices[hash] = len(groups)
+		groups = append(groups, []int{innerIdx})
+		outputMetadata = append(outputMetadata, series)
+	}
+
+	if !haveDuplicates {
+		// Nothing to merge, so there's no need to do anything further.
+		PutSeriesMetadataSlice(outputMetadata, d.MemoryConsumptionTracker)
+		return innerMetadata, nil
+	}
+
+	PutSeriesMetadataSlice(innerMetadata, d.MemoryConsumptionTracker)
+	d.groups = groups
+	d.buffer = newInstantVectorOperatorBuffer(d.Inner, nil, d.MemoryConsumptionTracker)
+
+	return outputMetadata, nil
+}
+
+func (d *DeduplicateAndMerge) Next(ctx context.Context) (InstantVectorSeriesData, error) {
+	if d.groups == nil {
+		return d.Inner.Next(ctx)
+	}
+
+	if len(d.groups) == 0 {
+		return InstantVectorSeriesData{}, EOS
+	}
+
+	thisGroup := d.groups[0]
+	d.groups = d.groups[1:]
+
+	if len(thisGroup) == 1 {
+		return d.buffer.getSingleSeries(ctx, thisGroup[0])
+	}
+
+	allSeries, err := d.buffer.getSeries(ctx, thisGroup)
+	if err != nil {
+		return InstantVectorSeriesData{}, err
+	}
+
+	floatCount := 0
+	histogramCount := 0
+	seriesWithSamplesCount := 0
+	for _, s := range allSeries {
+		floatCount += len(s.Floats)
+		histogramCount += len(s.Histograms)
+
+		if len(s.Floats)+len(s.Histograms) > 0 {
+			seriesWithSamplesCount++
+		}
+	}
+
+	if d.RejectNonOverlappingSeries && seriesWithSamplesCount > 1 {
+		putInstantVectorSeriesDataSlices(allSeries, d.MemoryConsumptionTracker)
+		return InstantVectorSeriesData{}, errVectorContainsSameLabelset
+	}
+
+	var floats []promql.FPoint
+	var histograms []promql.HPoint
+
+	if floatCount > 0 {
+		if floats, err = GetFPointSlice(floatCount, d.MemoryConsumptionTracker); err != nil {
+			putInstantVectorSeriesDataSlices(allSeries, d.MemoryConsumptionTracker)
+			return InstantVectorSeriesData{}, err
+		}
+	}
+
+	if histogramCount > 0 {
+		if histograms, err = GetHPointSlice(histogramCount, d.MemoryConsumptionTracker); err != nil {
+			PutFPointSlice(floats, d.MemoryConsumptionTracker)
+			putInstantVectorSeriesDataSlices(allSeries, d.MemoryConsumptionTracker)
+			return InstantVectorSeriesData{}, err
+		}
+	}
+
+	for _, s := range allSeries {
+		floats = append(floats, s.Floats...)
+		histograms = append(histograms, s.Histograms...)
+		PutFPointSlice(s.Floats, d.MemoryConsumptionTracker)
+		PutHPointSlice(s.Histograms, d.MemoryConsumptionTracker)
+	}
+
+	sort.Slice(floats, func(i, j int) bool {
+		return floats[i].T < floats[j].T
+	})
+
+	sort.Slice(histograms, func(i, j int) bool {
+		return histograms[i].T < histograms[j].T
+	})
+
+	if haveDuplicateTimestamps(floats, histograms) {
+		PutFPointSlice(floats, d.MemoryConsumptionTracker)
+		PutHPointSlice(histograms, d.MemoryConsumptionTracker)
+		return InstantVectorSeriesData{}, errVectorContainsSameLabelset
+	}
+
+	return InstantVectorSeriesData{Floats: floats, Histograms: histograms}, nil
+}
+
+// haveDuplicateTimestamps returns true if any two points in floats and histograms have the same timestamp.
+// floats and histograms must each be sorted by timestamp.
+func haveDuplicateTimestamps(floats []promql.FPoint, histograms []promql.HPoint) bool {
+	for i := 1; i < len(floats); i++ {
+		if floats[i].T == floats[i-1].T {
+			return true
+		}
+	}
+
+	for i := 1; i < len(histograms); i++ {
+		if histograms[i].T == histograms[i-1].T {
+			return true
+		}
+	}
+
+	floatIdx, histogramIdx := 0, 0
+
+	for floatIdx < len(floats) && histogramIdx < len(histograms) {
+		switch {
+		case floats[floatIdx].T == histograms[histogramIdx].T:
+			return true
+		case floats[floatIdx].T < histograms[histogramIdx].T:
+			floatIdx++
+		default:
+			histogramIdx++
+		}
+	}
+
+	return false
+}
+
+func (d *DeduplicateAndMerge) BufferedPoints() int {
+	if d.buffer == nil {
+		return 0
+	}
+
+	return d.buffer.bufferedPoints()
+}
+
+func (d *DeduplicateAndMerge) Close() {
+	d.Inner.Close()
+
+	if d.buffer != nil {
+		d.buffer.close()
+	}
+}
@@ -0,0 +1,175 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// Provenance-includes-location: https://github.com/prometheus/prometheus/blob/main/promql/engine.go
+// Provenance-includes-license: Apache-2.0
+// Provenance-includes-copyright: The Prometheus Authors
+
+package operator
+
+import (
+	"context"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// FunctionOverInstantVector performs a function over each point of each series in an instant vector, such as abs or clamp.
+//
+// If the function drops the metric name, the output may contain multiple series with the same labels,
+// so this operator should be wrapped in a DeduplicateAndMerge.
+type FunctionOverInstantVector struct {
+	Inner        InstantVectorOperator
+	Func         InstantVectorFunction
+	FunctionName string // Only used to describe this operator, eg. in query plans.
+
+	// Args contains the scalar arguments to the function, in the order they appear in the expression, excluding the
+	// instant vector (eg. for clamp(metric, 0, 10), Args contains one operator for 0 and one for 10).
+	Args []ScalarOperator
+
+	Start    int64 // Milliseconds since Unix epoch
+	End      int64 // Milliseconds since Unix epoch
+	Interval int64 // In milliseconds
+
+	MemoryConsumptionTracker *limiting.MemoryConsumptionTracker
+
+	argValues [][]promql.FPoint // One entry per argument, containing the value of that argument at each step.
+}
+
+var _ InstantVectorOperator = &FunctionOverInstantVector{}
+
+// InstantVectorFunction describes a function that operates on each point of each series in an instant vector.
+//
+// The output of the function is always a float. Each of FloatFunc and HistogramFunc returns false if there is no
+// output for that point.
+type InstantVectorFunction struct {
+	// FloatFunc computes the output for a float point, given the value of each of the function's scalar arguments at
+	// the same step. If FloatFunc is nil, float points are dropped.
+	FloatFunc func(p promql.FPoint, args []float64) (float64, bool)
+
+	// HistogramFunc computes the output for a histogram point, given the value of each of the function's scalar arguments
+	// at the same step. If HistogramFunc is nil, histogram points are dropped.
+	HistogramFunc func(p promql.HPoint, args []float64) (float64, bool)
+
+	// KeepMetricName is true if the output series of this function retain the metric name of the input series.
+	KeepMetricName bool
+}
+
+func (f *FunctionOverInstantVector) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error) {
+	if err := f.readArgs(ctx); err != nil {
+		return nil, err
+	}
+
+	metadata, err := f.Inner.SeriesMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !f.Func.KeepMetricName {
+		lb := labels.NewBuilder(labels.EmptyLabels())
+		for i := range metadata {
+			metadata[i].Labels = dropMetricName(metadata[i].Labels, lb)
+		}
+	}
+
+	return metadata, nil
+}
+
+func (f *FunctionOverInstantVector) readArgs(ctx context.Context) error {
+	f.argValues = make([][]promql.FPoint, 0, len(f.Args))
+
+	for _, a := range f.Args {
+		d, err := a.GetValues(ctx)
+		if err != nil {
+			return err
+		}
+
+		f.argValues = append(f.argValues, d.Samples)
+	}
+
+	return nil
+}
+
+func (f *FunctionOverInstantVector) Next(ctx context.Context) (InstantVectorSeriesData, error) {
+	series, err := f.Inner.Next(ctx)
+	if err != nil {
+		return InstantVectorSeriesData{}, err
+	}
+
+	defer PutHPointSlice(series.Histograms, f.MemoryConsumptionTracker)
+
+	args := make([]float64, len(f.argValues))
+	argsAt := func(t int64) []float64 {
+		// Scalars always have a value at every step, so we can find the corresponding value by its step index.
+		stepIdx := (t - f.Start) / f.Interval
+
+		for i, a := range f.argValues {
+			args[i] = a[stepIdx].F
+		}
+
+		return args
+	}
+
+	// We reuse the input series' float slice for the output if we can, but if there are histograms as well, we may
+	// produce more output points than there are input float points.
+	var output []promql.FPoint
+
+	if f.Func.HistogramFunc != nil && len(series.Histograms) > 0 {
+		output, err = GetFPointSlice(len(series.Floats)+len(series.Histograms), f.MemoryConsumptionTracker)
+		if err != nil {
+			PutFPointSlice(series.Floats, f.MemoryConsumptionTracker)
+			return InstantVectorSeriesData{}, err
+		}
+
+		defer PutFPointSlice(series.Floats, f.MemoryConsumptionTracker)
+	} else {
+		output = series.Floats[:0]
+	}
+
+	floatIdx, histogramIdx := 0, 0
+
+	// Floats and histograms are each sorted by timestamp, so merge them to produce output points in timestamp order.
+	for floatIdx < len(series.Floats) || histogramIdx < len(series.Histograms) {
+		if histogramIdx >= len(series.Histograms) || (floatIdx < len(series.Floats) && series.Floats[floatIdx].T < series.Histograms[histogramIdx].T) {
+			p := series.Floats[floatIdx]
+			floatIdx++
+
+			if f.Func.FloatFunc == nil {
+				continue
+			}
+
+			if v, ok := f.Func.FloatFunc(p, argsAt(p.T)); ok {
+				output = append(output, promql.FPoint{T: p.T, F: v})
+			}
+
+			continue
+		}
+
+		p := series.Histograms[histogramIdx]
+		histogramIdx++
+
+		if f.Func.HistogramFunc == nil {
+			continue
+		}
+
+		if v, ok := f.Func.HistogramFunc(p, argsAt(p.T)); ok {
+			output = append(output, promql.FPoint{T: p.T, F: v})
+		}
+	}
+
+	return InstantVectorSeriesData{Floats: output}, nil
+}
+
+func (f *FunctionOverInstantVector) Close() {
+	f.Inner.Close()
+
+	for _, a := range f.Args {
+		a.Close()
+	}
+
+	for _, a := range f.argValues {
+		PutFPointSlice(a, f.MemoryConsumptionTracker)
+	}
+
+	f.argValues = nil
+}
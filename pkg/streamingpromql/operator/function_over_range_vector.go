@@ -0,0 +1,199 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// Provenance-includes-location: https://github.com/prometheus/prometheus/blob/main/promql/engine.go
+// Provenance-includes-license: Apache-2.0
+// Provenance-includes-copyright: The Prometheus Authors
+
+package operator
+
+import (
+	"context"
+	"errors"
+
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// FunctionOverRangeVector performs a function over each series in a range vector, such as rate or max_over_time.
+//
+// The range vector can be a range vector selector (eg. rate(some_metric[5m])) or a subquery
+// (eg. max_over_time(rate(some_metric[5m])[1h:1m])).
+type FunctionOverRangeVector struct {
+	Inner        RangeVectorOperator
+	Func         RangeVectorFunction
+	FunctionName string // Only used to describe this operator, eg. in query plans.
+
+	// Args contains the scalar arguments to the function, in the order they appear in the expression, excluding the
+	// range vector (eg. for quantile_over_time(0.9, metric[5m]), Args contains one operator for 0.9).
+	Args []ScalarOperator
+
+	MemoryConsumptionTracker *limiting.MemoryConsumptionTracker
+
+	rangeSeconds float64
+	numSteps     int
+	argValues    [][]promql.FPoint // One entry per argument, containing the value of that argument at each step.
+
+	floats     *FPointRingBuffer
+	histograms *HPointRingBuffer
+}
+
+var _ InstantVectorOperator = &FunctionOverRangeVector{}
+
+// RangeVectorFunction describes a function that operates over the points in a range vector selector.
+type RangeVectorFunction struct {
+	// StepFunc computes the output of this function for a single series at a single step.
+	StepFunc RangeVectorStepFunction
+
+	// KeepMetricName is true if the output series of this function retain the metric name of the input series.
+	KeepMetricName bool
+}
+
+// RangeVectorStepFunction computes the output of a range vector function for a single series at a single step.
+//
+// args contains the value of each of the function's scalar arguments at this step.
+// memoryConsumptionTracker is the tracker for the query, for use with any slices taken from the pools.
+// It returns a float value (with hasFloat set to true), a histogram value, or neither if there is no output at this step.
+// Any histogram returned must not be shared with the points in step.
+type RangeVectorStepFunction func(step RangeVectorStepData, rangeSeconds float64, args []float64, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) (f float64, hasFloat bool, h *histogram.FloatHistogram, err error)
+
+func (m *FunctionOverRangeVector) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error) {
+	if err := m.readArgs(ctx); err != nil {
+		return nil, err
+	}
+
+	metadata, err := m.Inner.SeriesMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compute values we need on every call to Next() once, here.
+	m.rangeSeconds = m.Inner.Range().Seconds()
+	m.numSteps = m.Inner.StepCount()
+
+	if !m.Func.KeepMetricName {
+		lb := labels.NewBuilder(labels.EmptyLabels())
+		for i := range metadata {
+			metadata[i].Labels = dropMetricName(metadata[i].Labels, lb)
+		}
+	}
+
+	return metadata, nil
+}
+
+func (m *FunctionOverRangeVector) readArgs(ctx context.Context) error {
+	m.argValues = make([][]promql.FPoint, 0, len(m.Args))
+
+	for _, a := range m.Args {
+		d, err := a.GetValues(ctx)
+		if err != nil {
+			return err
+		}
+
+		m.argValues = append(m.argValues, d.Samples)
+	}
+
+	return nil
+}
+
+func dropMetricName(l labels.Labels, lb *labels.Builder) labels.Labels {
+	lb.Reset(l)
+	lb.Del(labels.MetricName)
+	return lb.Labels()
+}
+
+func (m *FunctionOverRangeVector) Next(ctx context.Context) (InstantVectorSeriesData, error) {
+	if m.floats == nil {
+		m.floats = NewFPointRingBuffer(m.MemoryConsumptionTracker)
+		m.histograms = NewHPointRingBuffer(m.MemoryConsumptionTracker)
+	}
+
+	if err := m.Inner.NextSeries(ctx); err != nil {
+		return InstantVectorSeriesData{}, err
+	}
+
+	m.floats.Reset()
+	m.histograms.Reset()
+
+	data := InstantVectorSeriesData{}
+	args := make([]float64, len(m.argValues))
+
+	for stepIdx := 0; ; stepIdx++ {
+		step, err := m.Inner.NextStepSamples(m.floats, m.histograms)
+
+		if errors.Is(err, EOS) {
+			return data, nil
+		}
+
+		if err != nil {
+			PutFPointSlice(data.Floats, m.MemoryConsumptionTracker)
+			PutHPointSlice(data.Histograms, m.MemoryConsumptionTracker)
+			return InstantVectorSeriesData{}, err
+		}
+
+		if len(step.FloatsHead)+len(step.FloatsTail)+len(step.HistogramsHead)+len(step.HistogramsTail) == 0 {
+			// Like Prometheus' engine, functions are only evaluated when there is at least one point in the range.
+			continue
+		}
+
+		for i, a := range m.argValues {
+			args[i] = a[stepIdx].F
+		}
+
+		f, hasFloat, h, err := m.Func.StepFunc(step, m.rangeSeconds, args, m.MemoryConsumptionTracker)
+		if err != nil {
+			PutFPointSlice(data.Floats, m.MemoryConsumptionTracker)
+			PutHPointSlice(data.Histograms, m.MemoryConsumptionTracker)
+			return InstantVectorSeriesData{}, err
+		}
+
+		if hasFloat {
+			if data.Floats == nil {
+				data.Floats, err = GetFPointSlice(m.numSteps, m.MemoryConsumptionTracker)
+				if err != nil {
+					PutHPointSlice(data.Histograms, m.MemoryConsumptionTracker)
+					return InstantVectorSeriesData{}, err
+				}
+			}
+
+			data.Floats = append(data.Floats, promql.FPoint{T: step.StepT, F: f})
+		}
+
+		if h != nil {
+			if data.Histograms == nil {
+				data.Histograms, err = GetHPointSlice(m.numSteps, m.MemoryConsumptionTracker)
+				if err != nil {
+					PutFPointSlice(data.Floats, m.MemoryConsumptionTracker)
+					return InstantVectorSeriesData{}, err
+				}
+			}
+
+			data.Histograms = append(data.Histograms, promql.HPoint{T: step.StepT, H: h})
+		}
+	}
+}
+
+func (m *FunctionOverRangeVector) Close() {
+	if m.Inner != nil {
+		m.Inner.Close()
+	}
+
+	for _, a := range m.Args {
+		a.Close()
+	}
+
+	for _, a := range m.argValues {
+		PutFPointSlice(a, m.MemoryConsumptionTracker)
+	}
+
+	m.argValues = nil
+
+	if m.floats != nil {
+		m.floats.Close()
+	}
+
+	if m.histograms != nil {
+		m.histograms.Close()
+	}
+}
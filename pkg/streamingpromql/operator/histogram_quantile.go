@@ -0,0 +1,312 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// Provenance-includes-location: https://github.com/prometheus/prometheus/blob/main/promql/functions.go
+// Provenance-includes-license: Apache-2.0
+// Provenance-includes-copyright: The Prometheus Authors
+
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// HistogramQuantile represents the histogram_quantile function.
+//
+// Both classic histograms (one series per bucket, identified by the 'le' label) and native histograms are supported.
+// Like Prometheus' engine, if there is both a classic histogram and a native histogram with the same labels at a
+// step, neither is used and there is no output at that step.
+//
+// The output for a classic histogram can only be computed once all of its bucket series have been read, so, like
+// Aggregation, input series are grouped by their output series, and each group is computed once all of its input
+// series have been read.
+//
+// Different classic or native histograms may produce output series with the same labels (eg. if they only differ by
+// metric name), so this operator should be wrapped in a DeduplicateAndMerge.
+type HistogramQuantile struct {
+	Inner InstantVectorOperator
+	Phi   ScalarOperator // The quantile to compute at each step.
+
+	Start    int64 // Milliseconds since Unix epoch
+	End      int64 // Milliseconds since Unix epoch
+	Interval int64 // In milliseconds
+
+	MemoryConsumptionTracker *limiting.MemoryConsumptionTracker
+
+	phiValues                   []promql.FPoint
+	remainingInnerSeriesToGroup []histogramQuantileInnerSeries // One entry per series produced by Inner that we haven't yet read.
+	remainingGroups             []*histogramQuantileGroup      // One entry per group, in the order we want to return them.
+}
+
+var _ InstantVectorOperator = &HistogramQuantile{}
+
+type histogramQuantileInnerSeries struct {
+	// The group for the float points in this series, or nil if this series is not a classic histogram bucket.
+	classicGroup *histogramQuantileGroup
+	upperBound   float64
+
+	// The group for the histogram points in this series.
+	nativeGroup *histogramQuantileGroup
+}
+
+type histogramQuantileGroup struct {
+	labels labels.Labels
+
+	// The number of input series that belong to this group that we haven't yet seen.
+	remainingSeriesCount uint
+
+	classicBuckets   []buckets       // One entry per step, or nil if no classic histogram buckets have been seen.
+	nativeHistograms []promql.HPoint // Sorted by timestamp.
+}
+
+func (h *HistogramQuantile) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error) {
+	innerMetadata, err := h.Inner.SeriesMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer PutSeriesMetadataSlice(innerMetadata, h.MemoryConsumptionTracker)
+
+	phi, err := h.Phi.GetValues(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	h.phiValues = phi.Samples
+
+	// Like Prometheus' engine, classic histogram buckets are grouped by all labels except 'le', and native histograms
+	// are grouped by all labels, so a native histogram with the same labels as a classic histogram (excluding 'le')
+	// is in the same group.
+	groups := map[string]*histogramQuantileGroup{}
+	buf := make([]byte, 0, 1024)
+	lb := labels.NewBuilder(labels.EmptyLabels())
+	h.remainingInnerSeriesToGroup = make([]histogramQuantileInnerSeries, 0, len(innerMetadata))
+
+	getGroup := func(key []byte, series labels.Labels, isClassic bool) *histogramQuantileGroup {
+		g, exists := groups[string(key)]
+
+		if !exists {
+			lb.Reset(series)
+			lb.Del(labels.MetricName)
+
+			if isClassic {
+				lb.Del(labels.BucketLabel)
+			}
+
+			g = &histogramQuantileGroup{labels: lb.Labels()}
+			groups[string(key)] = g
+		}
+
+		g.remainingSeriesCount++
+		return g
+	}
+
+	for _, series := range innerMetadata {
+		s := histogramQuantileInnerSeries{}
+
+		if upperBound, err := strconv.ParseFloat(series.Labels.Get(labels.BucketLabel), 64); err == nil {
+			buf = series.Labels.BytesWithoutLabels(buf, labels.BucketLabel)
+			s.classicGroup = getGroup(buf, series.Labels, true)
+			s.upperBound = upperBound
+		}
+
+		buf = series.Labels.Bytes(buf)
+		s.nativeGroup = getGroup(buf, series.Labels, false)
+
+		h.remainingInnerSeriesToGroup = append(h.remainingInnerSeriesToGroup, s)
+	}
+
+	outputMetadata, err := GetSeriesMetadataSlice(len(groups), h.MemoryConsumptionTracker)
+	if err != nil {
+		return nil, err
+	}
+
+	h.remainingGroups = make([]*histogramQuantileGroup, 0, len(groups))
+
+	for _, g := range groups {
+		outputMetadata = append(outputMetadata, SeriesMetadata{Labels: g.labels})
+		h.remainingGroups = append(h.remainingGroups, g)
+	}
+
+	sort.Sort(histogramQuantileGroupSorter{outputMetadata, h.remainingGroups})
+
+	return outputMetadata, nil
+}
+
+func (h *HistogramQuantile) Next(ctx context.Context) (InstantVectorSeriesData, error) {
+	if len(h.remainingGroups) == 0 {
+		return InstantVectorSeriesData{}, EOS
+	}
+
+	thisGroup := h.remainingGroups[0]
+
+	// Read inner series until the desired group is complete.
+	for thisGroup.remainingSeriesCount > 0 {
+		if err := h.readNextInnerSeries(ctx); err != nil {
+			return InstantVectorSeriesData{}, err
+		}
+	}
+
+	h.remainingGroups = h.remainingGroups[1:]
+
+	return h.computeOutputSeries(thisGroup)
+}
+
+func (h *HistogramQuantile) readNextInnerSeries(ctx context.Context) error {
+	d, err := h.Inner.Next(ctx)
+	if err != nil {
+		if errors.Is(err, EOS) {
+			return fmt.Errorf("exhausted series before all groups were completed: %w", err)
+		}
+
+		return err
+	}
+
+	s := h.remainingInnerSeriesToGroup[0]
+	h.remainingInnerSeriesToGroup = h.remainingInnerSeriesToGroup[1:]
+
+	if s.classicGroup != nil {
+		g := s.classicGroup
+
+		if len(d.Floats) > 0 && g.classicBuckets == nil {
+			g.classicBuckets = make([]buckets, stepCount(h.Start, h.End, h.Interval))
+		}
+
+		for _, p := range d.Floats {
+			stepIdx := (p.T - h.Start) / h.Interval
+			g.classicBuckets[stepIdx] = append(g.classicBuckets[stepIdx], bucket{upperBound: s.upperBound, count: p.F})
+		}
+
+		g.remainingSeriesCount--
+	}
+
+	PutFPointSlice(d.Floats, h.MemoryConsumptionTracker)
+
+	g := s.nativeGroup
+
+	if g.nativeHistograms == nil {
+		g.nativeHistograms = d.Histograms
+	} else {
+		// There's more than one series with the same labels, which is only possible if the inner operator returns
+		// duplicate series.
+		combined, err := GetHPointSlice(len(g.nativeHistograms)+len(d.Histograms), h.MemoryConsumptionTracker)
+		if err != nil {
+			PutHPointSlice(d.Histograms, h.MemoryConsumptionTracker)
+			return err
+		}
+
+		combined = append(combined, g.nativeHistograms...)
+		combined = append(combined, d.Histograms...)
+		PutHPointSlice(g.nativeHistograms, h.MemoryConsumptionTracker)
+		PutHPointSlice(d.Histograms, h.MemoryConsumptionTracker)
+		g.nativeHistograms = combined
+
+		sort.Slice(g.nativeHistograms, func(i, j int) bool {
+			return g.nativeHistograms[i].T < g.nativeHistograms[j].T
+		})
+	}
+
+	g.remainingSeriesCount--
+
+	return nil
+}
+
+func (h *HistogramQuantile) computeOutputSeries(g *histogramQuantileGroup) (InstantVectorSeriesData, error) {
+	defer func() {
+		PutHPointSlice(g.nativeHistograms, h.MemoryConsumptionTracker)
+		g.nativeHistograms = nil
+	}()
+
+	steps := stepCount(h.Start, h.End, h.Interval)
+	var points []promql.FPoint
+	nativeIdx := 0
+
+	for stepIdx := 0; stepIdx < steps; stepIdx++ {
+		t := h.Start + int64(stepIdx)*h.Interval
+		phi := h.phiValues[stepIdx].F
+
+		var classicBuckets buckets
+		if g.classicBuckets != nil {
+			classicBuckets = g.classicBuckets[stepIdx]
+		}
+
+		haveNative := nativeIdx < len(g.nativeHistograms) && g.nativeHistograms[nativeIdx].T == t
+
+		if haveNative && nativeIdx+1 < len(g.nativeHistograms) && g.nativeHistograms[nativeIdx+1].T == t {
+			PutFPointSlice(points, h.MemoryConsumptionTracker)
+			return InstantVectorSeriesData{}, errVectorContainsSameLabelset
+		}
+
+		var f float64
+
+		switch {
+		case haveNative && len(classicBuckets) > 0:
+			// Mixed classic and native histograms: there's no output at this step.
+			nativeIdx++
+			continue
+		case haveNative:
+			f = histogramQuantile(phi, g.nativeHistograms[nativeIdx].H)
+			nativeIdx++
+		case len(classicBuckets) > 0:
+			f, _, _ = bucketQuantile(phi, classicBuckets)
+		default:
+			continue
+		}
+
+		if points == nil {
+			var err error
+			points, err = GetFPointSlice(steps-stepIdx, h.MemoryConsumptionTracker)
+			if err != nil {
+				return InstantVectorSeriesData{}, err
+			}
+		}
+
+		points = append(points, promql.FPoint{T: t, F: f})
+	}
+
+	g.classicBuckets = nil
+
+	return InstantVectorSeriesData{Floats: points}, nil
+}
+
+func (h *HistogramQuantile) Close() {
+	h.Inner.Close()
+	h.Phi.Close()
+
+	if h.phiValues != nil {
+		PutFPointSlice(h.phiValues, h.MemoryConsumptionTracker)
+		h.phiValues = nil
+	}
+
+	for _, g := range h.remainingGroups {
+		PutHPointSlice(g.nativeHistograms, h.MemoryConsumptionTracker)
+		g.nativeHistograms = nil
+	}
+
+	h.remainingGroups = nil
+}
+
+type histogramQuantileGroupSorter struct {
+	metadata []SeriesMetadata
+	groups   []*histogramQuantileGroup
+}
+
+func (g histogramQuantileGroupSorter) Len() int {
+	return len(g.metadata)
+}
+
+func (g histogramQuantileGroupSorter) Less(i, j int) bool {
+	return labels.Compare(g.metadata[i].Labels, g.metadata[j].Labels) < 0
+}
+
+func (g histogramQuantileGroupSorter) Swap(i, j int) {
+	g.metadata[i], g.metadata[j] = g.metadata[j], g.metadata[i]
+	g.groups[i], g.groups[j] = g.groups[j], g.groups[i]
+}
@@ -0,0 +1,219 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package operator
+
+import (
+	"context"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// InstantVectorDuplicationBuffer evaluates an instant vector expression once and shares its result with many consumers,
+// so that expressions that appear more than once in a query (eg. `a` in `a / (a + b)`) are only evaluated once.
+//
+// Consumers may read series at different rates, so series read from Inner are buffered until every consumer that
+// needs them has read them. Buffered series remain charged to the query's memory consumption limit until they are
+// released.
+//
+// Each consumer is an InstantVectorDuplicationConsumer created with NewConsumer, and must be closed once it is no longer
+// needed. Inner is closed once all consumers are closed.
+type InstantVectorDuplicationBuffer struct {
+	Inner                    InstantVectorOperator
+	MemoryConsumptionTracker *limiting.MemoryConsumptionTracker
+
+	consumers []*InstantVectorDuplicationConsumer
+
+	seriesMetadata             []SeriesMetadata
+	seriesMetadataReturnedFrom int // The number of consumers that have already called SeriesMetadata.
+	haveReadSeriesMetadata     bool
+	seriesCount                int
+
+	// buffer contains the series read from Inner that at least one consumer has not yet read, in the order they were read.
+	// The first series in buffer is the series at index firstBufferedSeriesIndex.
+	buffer                   []InstantVectorSeriesData
+	firstBufferedSeriesIndex int
+	nextSeriesIndexToRead    int
+}
+
+// NewInstantVectorDuplicationBuffer returns a new InstantVectorDuplicationBuffer with no consumers.
+func NewInstantVectorDuplicationBuffer(inner InstantVectorOperator, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) *InstantVectorDuplicationBuffer {
+	return &InstantVectorDuplicationBuffer{
+		Inner:                    inner,
+		MemoryConsumptionTracker: memoryConsumptionTracker,
+	}
+}
+
+// NewConsumer returns a new operator that produces the same series as Inner.
+//
+// All consumers must be created before any consumer is used.
+func (b *InstantVectorDuplicationBuffer) NewConsumer() *InstantVectorDuplicationConsumer {
+	c := &InstantVectorDuplicationConsumer{Buffer: b}
+	b.consumers = append(b.consumers, c)
+	return c
+}
+
+func (b *InstantVectorDuplicationBuffer) seriesMetadataFor(ctx context.Context) ([]SeriesMetadata, error) {
+	if !b.haveReadSeriesMetadata {
+		var err error
+		b.seriesMetadata, err = b.Inner.SeriesMetadata(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		b.haveReadSeriesMetadata = true
+		b.seriesCount = len(b.seriesMetadata)
+	}
+
+	b.seriesMetadataReturnedFrom++
+
+	if b.seriesMetadataReturnedFrom == len(b.consumers) {
+		// This is the last consumer to ask for the series metadata, so it can have the original slice.
+		metadata := b.seriesMetadata
+		b.seriesMetadata = nil
+		return metadata, nil
+	}
+
+	metadata, err := GetSeriesMetadataSlice(len(b.seriesMetadata), b.MemoryConsumptionTracker)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(metadata, b.seriesMetadata...), nil
+}
+
+func (b *InstantVectorDuplicationBuffer) nextFor(ctx context.Context, consumer *InstantVectorDuplicationConsumer) (InstantVectorSeriesData, error) {
+	seriesIndex := consumer.nextSeriesIndex
+
+	if seriesIndex >= b.seriesCount {
+		// Don't call Next on Inner again if another consumer has already reached the end of the series.
+		return InstantVectorSeriesData{}, EOS
+	}
+
+	for b.nextSeriesIndexToRead <= seriesIndex {
+		d, err := b.Inner.Next(ctx)
+		if err != nil {
+			return InstantVectorSeriesData{}, err
+		}
+
+		b.buffer = append(b.buffer, d)
+		b.nextSeriesIndexToRead++
+	}
+
+	consumer.nextSeriesIndex++
+	d := b.buffer[seriesIndex-b.firstBufferedSeriesIndex]
+
+	if b.isNeededByAnyConsumer(seriesIndex) {
+		// Another consumer still needs this series, so return a copy and keep the original.
+		return cloneInstantVectorSeriesData(d, b.MemoryConsumptionTracker)
+	}
+
+	// No other consumer needs this series. Consumers read series in order, so this must be the first buffered series.
+	b.buffer[0] = InstantVectorSeriesData{}
+	b.buffer = b.buffer[1:]
+	b.firstBufferedSeriesIndex++
+
+	return d, nil
+}
+
+// isNeededByAnyConsumer returns true if any open consumer has not yet read the series at seriesIndex.
+func (b *InstantVectorDuplicationBuffer) isNeededByAnyConsumer(seriesIndex int) bool {
+	for _, c := range b.consumers {
+		if !c.closed && c.nextSeriesIndex <= seriesIndex {
+			return true
+		}
+	}
+
+	return false
+}
+
+// releaseUnneededSeries returns the slices for any buffered series that no open consumer still needs to the pool.
+func (b *InstantVectorDuplicationBuffer) releaseUnneededSeries() {
+	for len(b.buffer) > 0 && !b.isNeededByAnyConsumer(b.firstBufferedSeriesIndex) {
+		PutFPointSlice(b.buffer[0].Floats, b.MemoryConsumptionTracker)
+		PutHPointSlice(b.buffer[0].Histograms, b.MemoryConsumptionTracker)
+		b.buffer[0] = InstantVectorSeriesData{}
+		b.buffer = b.buffer[1:]
+		b.firstBufferedSeriesIndex++
+	}
+}
+
+func (b *InstantVectorDuplicationBuffer) closeConsumer(consumer *InstantVectorDuplicationConsumer) {
+	if consumer.closed {
+		return
+	}
+
+	consumer.closed = true
+	b.releaseUnneededSeries()
+
+	for _, c := range b.consumers {
+		if !c.closed {
+			return
+		}
+	}
+
+	// All consumers are closed, so nothing else will be read from Inner.
+	b.Inner.Close()
+
+	if b.seriesMetadata != nil {
+		PutSeriesMetadataSlice(b.seriesMetadata, b.MemoryConsumptionTracker)
+		b.seriesMetadata = nil
+	}
+}
+
+func cloneInstantVectorSeriesData(d InstantVectorSeriesData, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) (InstantVectorSeriesData, error) {
+	clone := InstantVectorSeriesData{}
+
+	if len(d.Floats) > 0 {
+		var err error
+		clone.Floats, err = GetFPointSlice(len(d.Floats), memoryConsumptionTracker)
+		if err != nil {
+			return InstantVectorSeriesData{}, err
+		}
+
+		clone.Floats = append(clone.Floats, d.Floats...)
+	}
+
+	if len(d.Histograms) > 0 {
+		var err error
+		clone.Histograms, err = GetHPointSlice(len(d.Histograms), memoryConsumptionTracker)
+		if err != nil {
+			PutFPointSlice(clone.Floats, memoryConsumptionTracker)
+			return InstantVectorSeriesData{}, err
+		}
+
+		// Consumers may modify the histograms they receive, so each consumer needs its own copy.
+		for _, p := range d.Histograms {
+			p.H = p.H.Copy()
+			clone.Histograms = append(clone.Histograms, p)
+		}
+	}
+
+	return clone, nil
+}
+
+// InstantVectorDuplicationConsumer produces the series from an InstantVectorDuplicationBuffer.
+type InstantVectorDuplicationConsumer struct {
+	Buffer *InstantVectorDuplicationBuffer
+
+	nextSeriesIndex int
+	closed          bool
+}
+
+var _ InstantVectorOperator = &InstantVectorDuplicationConsumer{}
+
+func (c *InstantVectorDuplicationConsumer) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error) {
+	return c.Buffer.seriesMetadataFor(ctx)
+}
+
+func (c *InstantVectorDuplicationConsumer) Next(ctx context.Context) (InstantVectorSeriesData, error) {
+	return c.Buffer.nextFor(ctx, c)
+}
+
+// BufferedPoints returns the number of points buffered for all consumers of the shared expression.
+func (c *InstantVectorDuplicationConsumer) BufferedPoints() int {
+	return countPoints(c.Buffer.buffer...)
+}
+
+func (c *InstantVectorDuplicationConsumer) Close() {
+	c.Buffer.closeConsumer(c)
+}
@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// Provenance-includes-location: https://github.com/prometheus/prometheus/blob/main/promql/functions.go
+// Provenance-includes-license: Apache-2.0
+// Provenance-includes-copyright: The Prometheus Authors
+
+package operator
+
+import (
+	"math"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+)
+
+// InstantVectorFunctions contains the functions supported by FunctionOverInstantVector, by name.
+//
+// Like Prometheus' engine, functions that only operate on floats ignore histograms.
+//
+// Date functions with no arguments (eg. hour()) are implemented by applying the function to vector(time()).
+var InstantVectorFunctions = map[string]InstantVectorFunction{
+	"abs":   floatTransformation(math.Abs),
+	"ceil":  floatTransformation(math.Ceil),
+	"floor": floatTransformation(math.Floor),
+	"exp":   floatTransformation(math.Exp),
+	"sqrt":  floatTransformation(math.Sqrt),
+	"ln":    floatTransformation(math.Log),
+	"log2":  floatTransformation(math.Log2),
+	"log10": floatTransformation(math.Log10),
+	"sin":   floatTransformation(math.Sin),
+	"cos":   floatTransformation(math.Cos),
+	"tan":   floatTransformation(math.Tan),
+	"asin":  floatTransformation(math.Asin),
+	"acos":  floatTransformation(math.Acos),
+	"atan":  floatTransformation(math.Atan),
+	"sinh":  floatTransformation(math.Sinh),
+	"cosh":  floatTransformation(math.Cosh),
+	"tanh":  floatTransformation(math.Tanh),
+	"asinh": floatTransformation(math.Asinh),
+	"acosh": floatTransformation(math.Acosh),
+	"atanh": floatTransformation(math.Atanh),
+	"rad":   floatTransformation(func(f float64) float64 { return f * math.Pi / 180 }),
+	"deg":   floatTransformation(func(f float64) float64 { return f * 180 / math.Pi }),
+	"sgn":   floatTransformation(sgn),
+
+	"clamp":     {FloatFunc: clamp},
+	"clamp_min": {FloatFunc: clampMin},
+	"clamp_max": {FloatFunc: clampMax},
+	"round":     {FloatFunc: round},
+
+	"timestamp": {FloatFunc: timestampOfFloat, HistogramFunc: timestampOfHistogram},
+
+	"days_in_month": dateFunction(func(t time.Time) float64 {
+		return float64(32 - time.Date(t.Year(), t.Month(), 32, 0, 0, 0, 0, time.UTC).Day())
+	}),
+	"day_of_month": dateFunction(func(t time.Time) float64 { return float64(t.Day()) }),
+	"day_of_week":  dateFunction(func(t time.Time) float64 { return float64(t.Weekday()) }),
+	"day_of_year":  dateFunction(func(t time.Time) float64 { return float64(t.YearDay()) }),
+	"hour":         dateFunction(func(t time.Time) float64 { return float64(t.Hour()) }),
+	"minute":       dateFunction(func(t time.Time) float64 { return float64(t.Minute()) }),
+	"month":        dateFunction(func(t time.Time) float64 { return float64(t.Month()) }),
+	"year":         dateFunction(func(t time.Time) float64 { return float64(t.Year()) }),
+
+	"histogram_count":    {HistogramFunc: histogramCount},
+	"histogram_sum":      {HistogramFunc: histogramSum},
+	"histogram_fraction": {HistogramFunc: histogramFractionFunc},
+}
+
+// SampleTimestampFunction is used for timestamp() when its argument is an InstantVectorSelector with
+// ReturnSampleTimestamps set: the selector has already computed the output values, so the function only needs to drop
+// the metric name.
+var SampleTimestampFunction = InstantVectorFunction{
+	FloatFunc: func(p promql.FPoint, _ []float64) (float64, bool) { return p.F, true },
+}
+
+func floatTransformation(f func(float64) float64) InstantVectorFunction {
+	return InstantVectorFunction{
+		FloatFunc: func(p promql.FPoint, _ []float64) (float64, bool) {
+			return f(p.F), true
+		},
+	}
+}
+
+func sgn(f float64) float64 {
+	switch {
+	case f < 0:
+		return -1
+	case f > 0:
+		return 1
+	default:
+		return f
+	}
+}
+
+func clamp(p promql.FPoint, args []float64) (float64, bool) {
+	minVal, maxVal := args[0], args[1]
+
+	if maxVal < minVal {
+		return 0, false
+	}
+
+	return math.Max(minVal, math.Min(maxVal, p.F)), true
+}
+
+func clampMin(p promql.FPoint, args []float64) (float64, bool) {
+	return math.Max(args[0], p.F), true
+}
+
+func clampMax(p promql.FPoint, args []float64) (float64, bool) {
+	return math.Min(args[0], p.F), true
+}
+
+func round(p promql.FPoint, args []float64) (float64, bool) {
+	toNearest := float64(1)
+	if len(args) > 0 {
+		toNearest = args[0]
+	}
+
+	// Like Prometheus' engine, we invert toNearest as it seems to cause fewer floating point accuracy issues,
+	// and ties are rounded up.
+	toNearestInverse := 1.0 / toNearest
+
+	return math.Floor(p.F*toNearestInverse+0.5) / toNearestInverse, true
+}
+
+func timestampOfFloat(p promql.FPoint, _ []float64) (float64, bool) {
+	return float64(p.T) / 1000, true
+}
+
+func timestampOfHistogram(p promql.HPoint, _ []float64) (float64, bool) {
+	return float64(p.T) / 1000, true
+}
+
+func dateFunction(f func(time.Time) float64) InstantVectorFunction {
+	return InstantVectorFunction{
+		FloatFunc: func(p promql.FPoint, _ []float64) (float64, bool) {
+			return f(time.Unix(int64(p.F), 0).UTC()), true
+		},
+	}
+}
+
+func histogramCount(p promql.HPoint, _ []float64) (float64, bool) {
+	return p.H.Count, true
+}
+
+func histogramSum(p promql.HPoint, _ []float64) (float64, bool) {
+	return p.H.Sum, true
+}
+
+func histogramFractionFunc(p promql.HPoint, args []float64) (float64, bool) {
+	lower, upper := args[0], args[1]
+	return histogramFraction(lower, upper, p.H), true
+}
@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package operator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// instantVectorOperatorBuffer buffers series data until it is needed by an operator.
+//
+// For example, if this buffer is being used for a binary operation and the source operator produces series in order A, B, C,
+// but their corresponding output series from the binary operation are in order B, A, C, instantVectorOperatorBuffer
+// will buffer the data for series A while series B is produced, then return series A when needed.
+type instantVectorOperatorBuffer struct {
+	source          InstantVectorOperator
+	nextIndexToRead int
+
+	// If seriesUsed[i] is false, then the series at index i will never be requested and can be discarded immediately.
+	// If seriesUsed is nil, then all series are assumed to be used.
+	seriesUsed []bool
+
+	buffer map[int]InstantVectorSeriesData
+
+	memoryConsumptionTracker *limiting.MemoryConsumptionTracker
+}
+
+func newInstantVectorOperatorBuffer(source InstantVectorOperator, seriesUsed []bool, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) *instantVectorOperatorBuffer {
+	return &instantVectorOperatorBuffer{
+		source:                   source,
+		seriesUsed:               seriesUsed,
+		buffer:                   map[int]InstantVectorSeriesData{},
+		memoryConsumptionTracker: memoryConsumptionTracker,
+	}
+}
+
+// getSeries returns the data for the series at each of the given indices.
+//
+// Each series may only be requested once.
+func (b *instantVectorOperatorBuffer) getSeries(ctx context.Context, indices []int) ([]InstantVectorSeriesData, error) {
+	data := make([]InstantVectorSeriesData, len(indices))
+
+	for i, seriesIndex := range indices {
+		d, err := b.getSingleSeries(ctx, seriesIndex)
+		if err != nil {
+			return nil, err
+		}
+
+		data[i] = d
+	}
+
+	return data, nil
+}
+
+func (b *instantVectorOperatorBuffer) getSingleSeries(ctx context.Context, seriesIndex int) (InstantVectorSeriesData, error) {
+	if d, isBuffered := b.buffer[seriesIndex]; isBuffered {
+		delete(b.buffer, seriesIndex)
+		return d, nil
+	}
+
+	if seriesIndex < b.nextIndexToRead {
+		return InstantVectorSeriesData{}, fmt.Errorf("series at index %v has already been read and is not buffered", seriesIndex)
+	}
+
+	for b.nextIndexToRead <= seriesIndex {
+		d, err := b.source.Next(ctx)
+		if err != nil {
+			return InstantVectorSeriesData{}, err
+		}
+
+		idx := b.nextIndexToRead
+		b.nextIndexToRead++
+
+		if idx == seriesIndex {
+			return d, nil
+		}
+
+		if b.seriesUsed == nil || b.seriesUsed[idx] {
+			// We'll need this series later, so hold onto it.
+			b.buffer[idx] = d
+		} else {
+			// We don't need this series at all, return the slices to the pool now.
+			PutFPointSlice(d.Floats, b.memoryConsumptionTracker)
+			PutHPointSlice(d.Histograms, b.memoryConsumptionTracker)
+		}
+	}
+
+	panic("unreachable")
+}
+
+// bufferedPoints returns the number of points in all series currently held by this buffer.
+func (b *instantVectorOperatorBuffer) bufferedPoints() int {
+	points := 0
+
+	for _, d := range b.buffer {
+		points += countPoints(d)
+	}
+
+	return points
+}
+
+func (b *instantVectorOperatorBuffer) close() {
+	for _, d := range b.buffer {
+		PutFPointSlice(d.Floats, b.memoryConsumptionTracker)
+		PutHPointSlice(d.Histograms, b.memoryConsumptionTracker)
+	}
+
+	b.buffer = nil
+}
@@ -7,7 +7,6 @@ package operator
 
 import (
 	"context"
-	"errors"
 	"fmt"
 
 	"github.com/prometheus/prometheus/model/histogram"
@@ -15,11 +14,19 @@ import (
 	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
 )
 
 type InstantVectorSelector struct {
 	Selector *Selector
 
+	// If ReturnSampleTimestamps is true, the value of each output point is the timestamp of the selected sample in
+	// seconds, rather than the value of the sample. This is used for timestamp() when applied directly to a selector.
+	ReturnSampleTimestamps bool
+
+	MemoryConsumptionTracker *limiting.MemoryConsumptionTracker
+
 	numSteps int
 
 	chunkIterator    chunkenc.Iterator
@@ -48,9 +55,8 @@ func (v *InstantVectorSelector) Next(_ context.Context) (InstantVectorSeriesData
 
 	v.memoizedIterator.Reset(v.chunkIterator)
 
-	data := InstantVectorSeriesData{
-		Floats: GetFPointSlice(v.numSteps), // TODO: only allocate this if we have any floats (once we support native histograms)
-	}
+	data := InstantVectorSeriesData{}
+	var lastHistogram *histogram.FloatHistogram
 
 	for stepT := v.Selector.Start; stepT <= v.Selector.End; stepT += v.Selector.Interval {
 		var t int64
@@ -62,6 +68,8 @@ func (v *InstantVectorSelector) Next(_ context.Context) (InstantVectorSeriesData
 			ts = *v.Selector.Timestamp
 		}
 
+		ts -= v.Selector.Offset
+
 		valueType := v.memoizedIterator.Seek(ts)
 
 		switch valueType {
@@ -71,6 +79,8 @@ func (v *InstantVectorSelector) Next(_ context.Context) (InstantVectorSeriesData
 			}
 		case chunkenc.ValFloat:
 			t, val = v.memoizedIterator.At()
+		case chunkenc.ValFloatHistogram:
+			t, h = v.memoizedIterator.AtFloatHistogram()
 		default:
 			return InstantVectorSeriesData{}, fmt.Errorf("streaming PromQL engine: unknown value type %s", valueType.String())
 		}
@@ -78,9 +88,6 @@ func (v *InstantVectorSelector) Next(_ context.Context) (InstantVectorSeriesData
 		if valueType == chunkenc.ValNone || t > ts {
 			var ok bool
 			t, val, h, ok = v.memoizedIterator.PeekPrev()
-			if h != nil {
-				return InstantVectorSeriesData{}, errors.New("streaming PromQL engine doesn't support histograms yet")
-			}
 			if !ok || t < ts-v.Selector.LookbackDelta.Milliseconds() {
 				continue
 			}
@@ -89,6 +96,45 @@ func (v *InstantVectorSelector) Next(_ context.Context) (InstantVectorSeriesData
 			continue
 		}
 
+		if v.ReturnSampleTimestamps {
+			if data.Floats == nil {
+				data.Floats, err = GetFPointSlice(v.numSteps, v.MemoryConsumptionTracker)
+				if err != nil {
+					return InstantVectorSeriesData{}, err
+				}
+			}
+
+			data.Floats = append(data.Floats, promql.FPoint{T: stepT, F: float64(t) / 1000})
+			continue
+		}
+
+		if h != nil {
+			if h == lastHistogram {
+				// The same sample is selected at more than one step, but each point must have its own histogram,
+				// as operators may modify the histograms they receive.
+				h = h.Copy()
+			} else {
+				lastHistogram = h
+			}
+
+			if data.Histograms == nil {
+				data.Histograms, err = GetHPointSlice(v.numSteps, v.MemoryConsumptionTracker)
+				if err != nil {
+					return InstantVectorSeriesData{}, err
+				}
+			}
+
+			data.Histograms = append(data.Histograms, promql.HPoint{T: stepT, H: h})
+			continue
+		}
+
+		if data.Floats == nil {
+			data.Floats, err = GetFPointSlice(v.numSteps, v.MemoryConsumptionTracker)
+			if err != nil {
+				return InstantVectorSeriesData{}, err
+			}
+		}
+
 		data.Floats = append(data.Floats, promql.FPoint{T: stepT, F: val})
 	}
 
@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// Provenance-includes-location: https://github.com/prometheus/prometheus/blob/main/promql/functions.go
+// Provenance-includes-license: Apache-2.0
+// Provenance-includes-copyright: The Prometheus Authors
+
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// InstantVectorToScalar is an operator that converts an instant vector to a scalar, as used by scalar().
+//
+// At each step, the value of the scalar is the value of the only float point in the instant vector at that step, or
+// NaN if there are no float points or more than one float point at that step. Histograms are ignored.
+type InstantVectorToScalar struct {
+	Inner InstantVectorOperator
+
+	Start    int64 // Milliseconds since Unix epoch
+	End      int64 // Milliseconds since Unix epoch
+	Interval int64 // In milliseconds
+
+	MemoryConsumptionTracker *limiting.MemoryConsumptionTracker
+}
+
+var _ ScalarOperator = &InstantVectorToScalar{}
+
+func (i *InstantVectorToScalar) GetValues(ctx context.Context) (ScalarData, error) {
+	metadata, err := i.Inner.SeriesMetadata(ctx)
+	if err != nil {
+		return ScalarData{}, err
+	}
+
+	defer PutSeriesMetadataSlice(metadata, i.MemoryConsumptionTracker)
+
+	steps := stepCount(i.Start, i.End, i.Interval)
+	values, err := getZeroedFloatSlice(steps, i.MemoryConsumptionTracker)
+	if err != nil {
+		return ScalarData{}, err
+	}
+
+	defer PutFloatSlice(values, i.MemoryConsumptionTracker)
+
+	seenPoint, err := getZeroedBoolSlice(steps, i.MemoryConsumptionTracker)
+	if err != nil {
+		return ScalarData{}, err
+	}
+
+	defer PutBoolSlice(seenPoint, i.MemoryConsumptionTracker)
+
+	for range metadata {
+		d, err := i.Inner.Next(ctx)
+		if err != nil {
+			if errors.Is(err, EOS) {
+				return ScalarData{}, fmt.Errorf("exhausted series before all series were read: %w", err)
+			}
+
+			return ScalarData{}, err
+		}
+
+		for _, p := range d.Floats {
+			stepIdx := (p.T - i.Start) / i.Interval
+
+			if seenPoint[stepIdx] {
+				// We've already seen another point at this step, so the value of the scalar at this step is NaN.
+				values[stepIdx] = math.NaN()
+			} else {
+				seenPoint[stepIdx] = true
+				values[stepIdx] = p.F
+			}
+		}
+
+		PutFPointSlice(d.Floats, i.MemoryConsumptionTracker)
+		PutHPointSlice(d.Histograms, i.MemoryConsumptionTracker)
+	}
+
+	output, err := GetFPointSlice(steps, i.MemoryConsumptionTracker)
+	if err != nil {
+		return ScalarData{}, err
+	}
+
+	for stepIdx := 0; stepIdx < steps; stepIdx++ {
+		f := values[stepIdx]
+
+		if !seenPoint[stepIdx] {
+			f = math.NaN()
+		}
+
+		output = append(output, promql.FPoint{T: i.Start + int64(stepIdx)*i.Interval, F: f})
+	}
+
+	return ScalarData{Samples: output}, nil
+}
+
+func (i *InstantVectorToScalar) Close() {
+	i.Inner.Close()
+}
@@ -0,0 +1,199 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package operator
+
+import (
+	"context"
+	"time"
+)
+
+// OperatorStats contains statistics about the evaluation of a single operator.
+type OperatorStats struct {
+	SeriesOut          int
+	SamplesProcessed   int // Samples from range vector operators are counted once for each step they are selected at.
+	WallTime           time.Duration
+	PeakBufferedPoints int
+}
+
+// StatsRecorder attributes the time spent evaluating a query to the instrumented operator that is currently running,
+// so that the time spent in an operator does not include the time spent in its children.
+//
+// A StatsRecorder must only be used by operators of a single query, and is not safe for concurrent use.
+type StatsRecorder struct {
+	current *OperatorStats
+	since   time.Time
+}
+
+// enter starts attributing time to s, and returns the stats of the operator time was previously attributed to.
+func (r *StatsRecorder) enter(s *OperatorStats) *OperatorStats {
+	now := time.Now()
+	previous := r.current
+
+	if previous != nil {
+		previous.WallTime += now.Sub(r.since)
+	}
+
+	r.current = s
+	r.since = now
+
+	return previous
+}
+
+// exit stops attributing time to the current operator, and resumes attributing time to previous.
+func (r *StatsRecorder) exit(previous *OperatorStats) {
+	now := time.Now()
+	r.current.WallTime += now.Sub(r.since)
+	r.current = previous
+	r.since = now
+}
+
+// BufferedPointsCounter is implemented by operators that hold points between calls to Next.
+type BufferedPointsCounter interface {
+	// BufferedPoints returns the number of points currently held by this operator.
+	BufferedPoints() int
+}
+
+func countPoints(data ...InstantVectorSeriesData) int {
+	points := 0
+
+	for _, d := range data {
+		points += len(d.Floats) + len(d.Histograms)
+	}
+
+	return points
+}
+
+// InstrumentedInstantVectorOperator records statistics about the evaluation of Inner.
+type InstrumentedInstantVectorOperator struct {
+	Inner    InstantVectorOperator
+	Stats    *OperatorStats
+	Recorder *StatsRecorder
+}
+
+var _ InstantVectorOperator = &InstrumentedInstantVectorOperator{}
+
+func NewInstrumentedInstantVectorOperator(inner InstantVectorOperator, recorder *StatsRecorder) *InstrumentedInstantVectorOperator {
+	return &InstrumentedInstantVectorOperator{
+		Inner:    inner,
+		Stats:    &OperatorStats{},
+		Recorder: recorder,
+	}
+}
+
+func (o *InstrumentedInstantVectorOperator) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error) {
+	defer o.Recorder.exit(o.Recorder.enter(o.Stats))
+
+	series, err := o.Inner.SeriesMetadata(ctx)
+	o.Stats.SeriesOut += len(series)
+	o.updatePeakBufferedPoints()
+
+	return series, err
+}
+
+func (o *InstrumentedInstantVectorOperator) Next(ctx context.Context) (InstantVectorSeriesData, error) {
+	defer o.Recorder.exit(o.Recorder.enter(o.Stats))
+
+	d, err := o.Inner.Next(ctx)
+	o.Stats.SamplesProcessed += countPoints(d)
+	o.updatePeakBufferedPoints()
+
+	return d, err
+}
+
+func (o *InstrumentedInstantVectorOperator) updatePeakBufferedPoints() {
+	if c, ok := o.Inner.(BufferedPointsCounter); ok {
+		o.Stats.PeakBufferedPoints = max(o.Stats.PeakBufferedPoints, c.BufferedPoints())
+	}
+}
+
+func (o *InstrumentedInstantVectorOperator) Close() {
+	o.Inner.Close()
+}
+
+// InstrumentedRangeVectorOperator records statistics about the evaluation of Inner.
+//
+// The points in the range of each step are held in the ring buffers passed to NextStepSamples, so the peak number of
+// buffered points is the largest number of points in the range of a single step.
+type InstrumentedRangeVectorOperator struct {
+	Inner    RangeVectorOperator
+	Stats    *OperatorStats
+	Recorder *StatsRecorder
+}
+
+var _ RangeVectorOperator = &InstrumentedRangeVectorOperator{}
+
+func NewInstrumentedRangeVectorOperator(inner RangeVectorOperator, recorder *StatsRecorder) *InstrumentedRangeVectorOperator {
+	return &InstrumentedRangeVectorOperator{
+		Inner:    inner,
+		Stats:    &OperatorStats{},
+		Recorder: recorder,
+	}
+}
+
+func (o *InstrumentedRangeVectorOperator) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error) {
+	defer o.Recorder.exit(o.Recorder.enter(o.Stats))
+
+	series, err := o.Inner.SeriesMetadata(ctx)
+	o.Stats.SeriesOut += len(series)
+
+	return series, err
+}
+
+func (o *InstrumentedRangeVectorOperator) StepCount() int {
+	return o.Inner.StepCount()
+}
+
+func (o *InstrumentedRangeVectorOperator) Range() time.Duration {
+	return o.Inner.Range()
+}
+
+func (o *InstrumentedRangeVectorOperator) NextSeries(ctx context.Context) error {
+	defer o.Recorder.exit(o.Recorder.enter(o.Stats))
+
+	return o.Inner.NextSeries(ctx)
+}
+
+func (o *InstrumentedRangeVectorOperator) NextStepSamples(floats *FPointRingBuffer, histograms *HPointRingBuffer) (RangeVectorStepData, error) {
+	defer o.Recorder.exit(o.Recorder.enter(o.Stats))
+
+	step, err := o.Inner.NextStepSamples(floats, histograms)
+	points := len(step.FloatsHead) + len(step.FloatsTail) + len(step.HistogramsHead) + len(step.HistogramsTail)
+	o.Stats.SamplesProcessed += points
+	o.Stats.PeakBufferedPoints = max(o.Stats.PeakBufferedPoints, points)
+
+	return step, err
+}
+
+func (o *InstrumentedRangeVectorOperator) Close() {
+	o.Inner.Close()
+}
+
+// InstrumentedScalarOperator records statistics about the evaluation of Inner.
+type InstrumentedScalarOperator struct {
+	Inner    ScalarOperator
+	Stats    *OperatorStats
+	Recorder *StatsRecorder
+}
+
+var _ ScalarOperator = &InstrumentedScalarOperator{}
+
+func NewInstrumentedScalarOperator(inner ScalarOperator, recorder *StatsRecorder) *InstrumentedScalarOperator {
+	return &InstrumentedScalarOperator{
+		Inner:    inner,
+		Stats:    &OperatorStats{},
+		Recorder: recorder,
+	}
+}
+
+func (o *InstrumentedScalarOperator) GetValues(ctx context.Context) (ScalarData, error) {
+	defer o.Recorder.exit(o.Recorder.enter(o.Stats))
+
+	d, err := o.Inner.GetValues(ctx)
+	o.Stats.SamplesProcessed += len(d.Samples)
+
+	return d, err
+}
+
+func (o *InstrumentedScalarOperator) Close() {
+	o.Inner.Close()
+}
@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// Provenance-includes-location: https://github.com/prometheus/prometheus/blob/main/promql/functions.go
+// Provenance-includes-license: Apache-2.0
+// Provenance-includes-copyright: The Prometheus Authors
+
+package operator
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// LabelReplace represents the label_replace function.
+//
+// label_replace only changes the labels of each series, so the points of each series are returned unchanged.
+//
+// Like Prometheus' engine, it is an error for label_replace to produce multiple series with the same labels, even if
+// they never have points at the same step, so this operator should be wrapped in a DeduplicateAndMerge with
+// RejectNonOverlappingSeries set.
+type LabelReplace struct {
+	Inner            InstantVectorOperator
+	DestinationLabel string
+	Replacement      string
+	SourceLabel      string
+	Regex            string
+}
+
+var _ InstantVectorOperator = &LabelReplace{}
+
+func (l *LabelReplace) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error) {
+	regex, err := regexp.Compile("^(?:" + l.Regex + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression in label_replace(): %s", l.Regex)
+	}
+
+	if !model.LabelNameRE.MatchString(l.DestinationLabel) {
+		return nil, fmt.Errorf("invalid destination label name in label_replace(): %s", l.DestinationLabel)
+	}
+
+	metadata, err := l.Inner.SeriesMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lb := labels.NewBuilder(labels.EmptyLabels())
+
+	for i := range metadata {
+		srcVal := metadata[i].Labels.Get(l.SourceLabel)
+		indexes := regex.FindStringSubmatchIndex(srcVal)
+
+		if indexes == nil {
+			// Only replace when the regex matches.
+			continue
+		}
+
+		res := regex.ExpandString([]byte{}, l.Replacement, srcVal, indexes)
+		lb.Reset(metadata[i].Labels)
+		lb.Set(l.DestinationLabel, string(res))
+		metadata[i].Labels = lb.Labels()
+	}
+
+	return metadata, nil
+}
+
+func (l *LabelReplace) Next(ctx context.Context) (InstantVectorSeriesData, error) {
+	return l.Inner.Next(ctx)
+}
+
+func (l *LabelReplace) Close() {
+	l.Inner.Close()
+}
+
+// LabelJoin represents the label_join function.
+//
+// label_join only changes the labels of each series, so the points of each series are returned unchanged.
+//
+// Like Prometheus' engine, label_join does not check if it produces multiple series with the same labels.
+type LabelJoin struct {
+	Inner            InstantVectorOperator
+	DestinationLabel string
+	Separator        string
+	SourceLabels     []string
+}
+
+var _ InstantVectorOperator = &LabelJoin{}
+
+func (l *LabelJoin) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error) {
+	for _, src := range l.SourceLabels {
+		if !model.LabelName(src).IsValid() {
+			return nil, fmt.Errorf("invalid source label name in label_join(): %s", src)
+		}
+	}
+
+	if !model.LabelName(l.DestinationLabel).IsValid() {
+		return nil, fmt.Errorf("invalid destination label name in label_join(): %s", l.DestinationLabel)
+	}
+
+	metadata, err := l.Inner.SeriesMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	srcVals := make([]string, len(l.SourceLabels))
+	lb := labels.NewBuilder(labels.EmptyLabels())
+
+	for i := range metadata {
+		for j, src := range l.SourceLabels {
+			srcVals[j] = metadata[i].Labels.Get(src)
+		}
+
+		lb.Reset(metadata[i].Labels)
+		lb.Set(l.DestinationLabel, strings.Join(srcVals, l.Separator))
+		metadata[i].Labels = lb.Labels()
+	}
+
+	return metadata, nil
+}
+
+func (l *LabelJoin) Next(ctx context.Context) (InstantVectorSeriesData, error) {
+	return l.Inner.Next(ctx)
+}
+
+func (l *LabelJoin) Close() {
+	l.Inner.Close()
+}
@@ -5,13 +5,23 @@ package operator
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/promql"
 )
 
+// Operator represents all operators.
+type Operator interface {
+	// Close frees all resources associated with this operator.
+	// Calling SeriesMetadata, Next or GetValues after calling Close may result in unpredictable behaviour, corruption or crashes.
+	Close()
+}
+
 // InstantVectorOperator represents all operators that produce instant vectors.
 type InstantVectorOperator interface {
+	Operator
+
 	// SeriesMetadata returns a list of all series that will be returned by this operator.
 	// The returned []SeriesMetadata can be modified by the caller or returned to a pool.
 	// SeriesMetadata may return series in any order, but the same order must be used by both SeriesMetadata and Next.
@@ -23,14 +33,70 @@ type InstantVectorOperator interface {
 	// The returned InstantVectorSeriesData can be modified by the caller or returned to a pool.
 	// The returned InstantVectorSeriesData can contain no points.
 	Next(ctx context.Context) (InstantVectorSeriesData, error)
+}
 
-	// Close frees all resources associated with this operator.
-	// Calling SeriesMetadata or Next after calling Close may result in unpredictable behaviour, corruption or crashes.
-	Close()
+// RangeVectorOperator represents all operators that produce range vectors.
+type RangeVectorOperator interface {
+	Operator
+
+	// SeriesMetadata returns a list of all series that will be returned by this operator.
+	// The returned []SeriesMetadata can be modified by the caller or returned to a pool.
+	// SeriesMetadata may return series in any order, but the same order must be used by both SeriesMetadata and NextSeries.
+	// SeriesMetadata should be called no more than once.
+	SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error)
+
+	// StepCount returns the number of time steps produced for each series by this operator.
+	// StepCount must only be called after calling SeriesMetadata.
+	StepCount() int
+
+	// Range returns the time range selected by this operator at each time step.
+	//
+	// For example, if this operator represents the selector "some_metric[5m]", Range returns 5 minutes.
+	Range() time.Duration
+
+	// NextSeries advances to the next series produced by this operator, or EOS if no more series are available.
+	// SeriesMetadata must be called exactly once before calling NextSeries.
+	NextSeries(ctx context.Context) error
+
+	// NextStepSamples populates floats and histograms with the points for the next time step of the current series,
+	// discarding any points in them that are before the range of that step, and returns details of that step,
+	// or EOS if no more time steps are available.
+	//
+	// The same buffers must be passed to every call of NextStepSamples for a series, and must be reset by the caller
+	// before reading the first time step of each series.
+	NextStepSamples(floats *FPointRingBuffer, histograms *HPointRingBuffer) (RangeVectorStepData, error)
+}
+
+// RangeVectorStepData contains the points in the range of a single series at a single step.
+//
+// Points are held in two segments each, as they come from a ring buffer: all points in the head segment are before
+// all points in the tail segment. Either segment may be empty. Callers must not modify the points.
+type RangeVectorStepData struct {
+	FloatsHead     []promql.FPoint
+	FloatsTail     []promql.FPoint
+	HistogramsHead []promql.HPoint
+	HistogramsTail []promql.HPoint
+
+	StepT      int64 // Timestamp of the step, in milliseconds since Unix epoch.
+	RangeStart int64 // Start of the range (inclusive), in milliseconds since Unix epoch.
+	RangeEnd   int64 // End of the range (inclusive), in milliseconds since Unix epoch.
+}
+
+// ScalarOperator represents all operators that produce scalars.
+type ScalarOperator interface {
+	Operator
+
+	// GetValues returns the samples for this scalar, one for each step in the query.
+	// The returned ScalarData can be modified by the caller or returned to a pool.
+	// GetValues should be called no more than once.
+	GetValues(ctx context.Context) (ScalarData, error)
 }
 
 var EOS = errors.New("operator stream exhausted") //nolint:revive
 
+// errHistogramsNotSupported is returned by operators that do not yet support native histograms if they receive a histogram.
+var errHistogramsNotSupported = errors.New("streaming PromQL engine doesn't support histograms yet")
+
 type SeriesMetadata struct {
 	Labels labels.Labels
 }
@@ -39,3 +105,7 @@ type InstantVectorSeriesData struct {
 	Floats     []promql.FPoint
 	Histograms []promql.HPoint
 }
+
+type ScalarData struct {
+	Samples []promql.FPoint
+}
@@ -0,0 +1,197 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// Provenance-includes-location: https://github.com/prometheus/prometheus/blob/main/promql/engine.go
+// Provenance-includes-license: Apache-2.0
+// Provenance-includes-copyright: The Prometheus Authors
+
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// OrBinaryOperation represents the "or" set operation between two instant vectors.
+//
+// It returns all samples from the left side, and each sample from the right side that does not have a sample
+// with the same matching labels on the left side at the same time step.
+//
+// All series from the left side are returned first, followed by all series from the right side. The output
+// may contain multiple series with the same labels (eg. for "a or a"), so this operator should be wrapped in
+// a DeduplicateAndMerge.
+type OrBinaryOperation struct {
+	Left           InstantVectorOperator
+	Right          InstantVectorOperator
+	VectorMatching parser.VectorMatching
+
+	Start    int64 // Milliseconds since Unix epoch
+	End      int64 // Milliseconds since Unix epoch
+	Interval int64 // In milliseconds
+
+	MemoryConsumptionTracker *limiting.MemoryConsumptionTracker
+
+	leftGroups         []*setOperationGroup
+	leftSeriesGroups   []*setOperationGroup // One entry per series produced by Left, value is the group for that series, or nil if there are no series on the right side with the same matching labels
+	rightSeriesGroups  []*setOperationGroup // One entry per series produced by Right, value is the group on the left side with the same matching labels, or nil if there is no such group
+	nextLeftSeriesIdx  int
+	nextRightSeriesIdx int
+}
+
+var _ InstantVectorOperator = &OrBinaryOperation{}
+
+func (o *OrBinaryOperation) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error) {
+	if o.VectorMatching.Card != parser.CardManyToMany {
+		return nil, fmt.Errorf("set operations must only use many-to-many matching, but got %s", o.VectorMatching.Card)
+	}
+
+	leftMetadata, err := o.Left.SeriesMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rightMetadata, err := o.Right.SeriesMetadata(ctx)
+	if err != nil {
+		PutSeriesMetadataSlice(leftMetadata, o.MemoryConsumptionTracker)
+		return nil, err
+	}
+
+	defer PutSeriesMetadataSlice(leftMetadata, o.MemoryConsumptionTracker)
+	defer PutSeriesMetadataSlice(rightMetadata, o.MemoryConsumptionTracker)
+
+	groupKeyFunc := vectorMatchingGroupKeyFunc(o.VectorMatching)
+	rightKeys := make(map[string]struct{}, len(rightMetadata))
+
+	for _, series := range rightMetadata {
+		rightKeys[string(groupKeyFunc(series.Labels))] = struct{}{}
+	}
+
+	groupsByKey := map[string]*setOperationGroup{}
+	o.leftSeriesGroups = make([]*setOperationGroup, 0, len(leftMetadata))
+
+	for _, series := range leftMetadata {
+		key := groupKeyFunc(series.Labels)
+
+		if _, matchesRightSide := rightKeys[string(key)]; !matchesRightSide {
+			// No series on the right side will be affected by this series, so we don't need to track when it is present.
+			o.leftSeriesGroups = append(o.leftSeriesGroups, nil)
+			continue
+		}
+
+		g, exists := groupsByKey[string(key)]
+
+		if !exists {
+			g = &setOperationGroup{}
+			groupsByKey[string(key)] = g
+			o.leftGroups = append(o.leftGroups, g)
+		}
+
+		o.leftSeriesGroups = append(o.leftSeriesGroups, g)
+	}
+
+	o.rightSeriesGroups = make([]*setOperationGroup, 0, len(rightMetadata))
+
+	for _, series := range rightMetadata {
+		o.rightSeriesGroups = append(o.rightSeriesGroups, groupsByKey[string(groupKeyFunc(series.Labels))])
+	}
+
+	metadata, err := GetSeriesMetadataSlice(len(leftMetadata)+len(rightMetadata), o.MemoryConsumptionTracker)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata = append(metadata, leftMetadata...)
+	return append(metadata, rightMetadata...), nil
+}
+
+func (o *OrBinaryOperation) Next(ctx context.Context) (InstantVectorSeriesData, error) {
+	if o.nextLeftSeriesIdx < len(o.leftSeriesGroups) {
+		g := o.leftSeriesGroups[o.nextLeftSeriesIdx]
+		o.nextLeftSeriesIdx++
+
+		d, err := o.Left.Next(ctx)
+		if err != nil {
+			if errors.Is(err, EOS) {
+				return InstantVectorSeriesData{}, fmt.Errorf("exhausted left side series before all series were read: %w", err)
+			}
+
+			return InstantVectorSeriesData{}, err
+		}
+
+		if g != nil {
+			if g.present == nil {
+				g.present, err = getZeroedBoolSlice(stepCount(o.Start, o.End, o.Interval), o.MemoryConsumptionTracker)
+				if err != nil {
+					return InstantVectorSeriesData{}, err
+				}
+			}
+
+			for _, p := range d.Floats {
+				g.present[(p.T-o.Start)/o.Interval] = true
+			}
+
+			for _, p := range d.Histograms {
+				g.present[(p.T-o.Start)/o.Interval] = true
+			}
+		}
+
+		// Left side series are always returned unchanged.
+		return d, nil
+	}
+
+	if o.nextRightSeriesIdx < len(o.rightSeriesGroups) {
+		g := o.rightSeriesGroups[o.nextRightSeriesIdx]
+		o.nextRightSeriesIdx++
+
+		d, err := o.Right.Next(ctx)
+		if err != nil {
+			if errors.Is(err, EOS) {
+				return InstantVectorSeriesData{}, fmt.Errorf("exhausted right side series before all series were read: %w", err)
+			}
+
+			return InstantVectorSeriesData{}, err
+		}
+
+		if g == nil || g.present == nil {
+			// No series on the left side with the same matching labels had any samples, so return this series unchanged.
+			return d, nil
+		}
+
+		filteredFloats := d.Floats[:0]
+
+		for _, p := range d.Floats {
+			if !g.present[(p.T-o.Start)/o.Interval] {
+				filteredFloats = append(filteredFloats, p)
+			}
+		}
+
+		filteredHistograms := d.Histograms[:0]
+
+		for _, p := range d.Histograms {
+			if !g.present[(p.T-o.Start)/o.Interval] {
+				filteredHistograms = append(filteredHistograms, p)
+			}
+		}
+
+		return InstantVectorSeriesData{Floats: filteredFloats, Histograms: filteredHistograms}, nil
+	}
+
+	return InstantVectorSeriesData{}, EOS
+}
+
+func (o *OrBinaryOperation) Close() {
+	o.Left.Close()
+	o.Right.Close()
+
+	for _, g := range o.leftGroups {
+		if g.present != nil {
+			PutBoolSlice(g.present, o.MemoryConsumptionTracker)
+			g.present = nil
+		}
+	}
+
+	o.leftGroups = nil
+}
@@ -3,8 +3,11 @@
 package operator
 
 import (
+	"unsafe"
+
 	"github.com/prometheus/prometheus/promql"
 
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
 	"github.com/grafana/mimir/pkg/util/pool"
 )
 
@@ -19,6 +22,10 @@ var (
 		return make([]promql.FPoint, 0, size)
 	})
 
+	hPointSlicePool = pool.NewBucketedPool(1, maxExpectedPointsPerSeries, 10, func(size int) []promql.HPoint {
+		return make([]promql.HPoint, 0, size)
+	})
+
 	matrixPool = pool.NewBucketedPool(1, maxExpectedSeriesPerResult, 10, func(size int) promql.Matrix {
 		return make(promql.Matrix, 0, size)
 	})
@@ -41,65 +48,172 @@ var (
 	})
 )
 
-func GetFPointSlice(size int) []promql.FPoint {
-	return fPointSlicePool.Get(size)
+// Sizes of the elements of the slices held in the pools below, used to estimate the memory consumption of a query.
+var (
+	FPointSize         = uint64(unsafe.Sizeof(promql.FPoint{}))
+	HPointSize         = uint64(unsafe.Sizeof(promql.HPoint{}))
+	SampleSize         = uint64(unsafe.Sizeof(promql.Sample{}))
+	SeriesSize         = uint64(unsafe.Sizeof(promql.Series{}))
+	SeriesMetadataSize = uint64(unsafe.Sizeof(SeriesMetadata{}))
+	Float64Size        = uint64(unsafe.Sizeof(float64(0)))
+	BoolSize           = uint64(unsafe.Sizeof(false))
+)
+
+// All of the functions below report the estimated size of the slices they return to memoryConsumptionTracker, and
+// return an error if this would cause the query to exceed its memory consumption limit.
+//
+// Slices must be returned with the Put function that corresponds to the Get function used to obtain them, with the
+// same memoryConsumptionTracker, and must not be grown in the meantime, so that memoryConsumptionTracker remains
+// accurate.
+
+func GetFPointSlice(size int, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) ([]promql.FPoint, error) {
+	s := fPointSlicePool.Get(size)
+
+	if err := memoryConsumptionTracker.IncreaseMemoryConsumption(uint64(cap(s)) * FPointSize); err != nil {
+		fPointSlicePool.Put(s)
+		return nil, err
+	}
+
+	return s, nil
 }
 
-func PutFPointSlice(s []promql.FPoint) {
+func PutFPointSlice(s []promql.FPoint, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) {
+	memoryConsumptionTracker.DecreaseMemoryConsumption(uint64(cap(s)) * FPointSize)
 	fPointSlicePool.Put(s)
 }
 
-func GetMatrix(size int) promql.Matrix {
-	return matrixPool.Get(size)
+func GetHPointSlice(size int, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) ([]promql.HPoint, error) {
+	s := hPointSlicePool.Get(size)
+
+	if err := memoryConsumptionTracker.IncreaseMemoryConsumption(uint64(cap(s)) * HPointSize); err != nil {
+		hPointSlicePool.Put(s)
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func PutHPointSlice(s []promql.HPoint, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) {
+	memoryConsumptionTracker.DecreaseMemoryConsumption(uint64(cap(s)) * HPointSize)
+
+	// Remove references to histograms so they can be garbage collected.
+	for i := range s {
+		s[i].H = nil
+	}
+
+	hPointSlicePool.Put(s)
 }
 
-func PutMatrix(m promql.Matrix) {
+func GetMatrix(size int, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) (promql.Matrix, error) {
+	m := matrixPool.Get(size)
+
+	if err := memoryConsumptionTracker.IncreaseMemoryConsumption(uint64(cap(m)) * SeriesSize); err != nil {
+		matrixPool.Put(m)
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func PutMatrix(m promql.Matrix, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) {
+	memoryConsumptionTracker.DecreaseMemoryConsumption(uint64(cap(m)) * SeriesSize)
 	matrixPool.Put(m)
 }
 
-func GetVector(size int) promql.Vector {
-	return vectorPool.Get(size)
+func GetVector(size int, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) (promql.Vector, error) {
+	v := vectorPool.Get(size)
+
+	if err := memoryConsumptionTracker.IncreaseMemoryConsumption(uint64(cap(v)) * SampleSize); err != nil {
+		vectorPool.Put(v)
+		return nil, err
+	}
+
+	return v, nil
 }
 
-func PutVector(v promql.Vector) {
+func PutVector(v promql.Vector, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) {
+	memoryConsumptionTracker.DecreaseMemoryConsumption(uint64(cap(v)) * SampleSize)
 	vectorPool.Put(v)
 }
 
-func GetSeriesMetadataSlice(size int) []SeriesMetadata {
-	return seriesMetadataSlicePool.Get(size)
+func GetSeriesMetadataSlice(size int, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) ([]SeriesMetadata, error) {
+	s := seriesMetadataSlicePool.Get(size)
+
+	if err := memoryConsumptionTracker.IncreaseMemoryConsumption(uint64(cap(s)) * SeriesMetadataSize); err != nil {
+		seriesMetadataSlicePool.Put(s)
+		return nil, err
+	}
+
+	return s, nil
 }
 
-func PutSeriesMetadataSlice(s []SeriesMetadata) {
+func PutSeriesMetadataSlice(s []SeriesMetadata, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) {
+	memoryConsumptionTracker.DecreaseMemoryConsumption(uint64(cap(s)) * SeriesMetadataSize)
 	seriesMetadataSlicePool.Put(s)
 }
 
-func GetFloatSlice(size int) []float64 {
+func GetFloatSlice(size int, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) ([]float64, error) {
 	s := floatSlicePool.Get(size)
 	if s != nil {
-		return zeroFloatSlice(s, size)
+		s = zeroFloatSlice(s, size)
+	} else {
+		s = make([]float64, 0, size)
 	}
 
-	return make([]float64, 0, size)
+	if err := memoryConsumptionTracker.IncreaseMemoryConsumption(uint64(cap(s)) * Float64Size); err != nil {
+		floatSlicePool.Put(s)
+		return nil, err
+	}
+
+	return s, nil
 }
 
-func PutFloatSlice(s []float64) {
+func PutFloatSlice(s []float64, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) {
+	memoryConsumptionTracker.DecreaseMemoryConsumption(uint64(cap(s)) * Float64Size)
 	floatSlicePool.Put(s)
 }
 
-func GetBoolSlice(size int) []bool {
+func GetBoolSlice(size int, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) ([]bool, error) {
 	s := boolSlicePool.Get(size)
-
 	if s != nil {
-		return zeroBoolSlice(s, size)
+		s = zeroBoolSlice(s, size)
+	} else {
+		s = make([]bool, 0, size)
 	}
 
-	return make([]bool, 0, size)
+	if err := memoryConsumptionTracker.IncreaseMemoryConsumption(uint64(cap(s)) * BoolSize); err != nil {
+		boolSlicePool.Put(s)
+		return nil, err
+	}
+
+	return s, nil
 }
 
-func PutBoolSlice(s []bool) {
+func PutBoolSlice(s []bool, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) {
+	memoryConsumptionTracker.DecreaseMemoryConsumption(uint64(cap(s)) * BoolSize)
 	boolSlicePool.Put(s)
 }
 
+// getZeroedFloatSlice returns a slice of size zeroed float64 values.
+func getZeroedFloatSlice(size int, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) ([]float64, error) {
+	s, err := GetFloatSlice(size, memoryConsumptionTracker)
+	if err != nil {
+		return nil, err
+	}
+
+	return s[:size], nil
+}
+
+// getZeroedBoolSlice returns a slice of size false values.
+func getZeroedBoolSlice(size int, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) ([]bool, error) {
+	s, err := GetBoolSlice(size, memoryConsumptionTracker)
+	if err != nil {
+		return nil, err
+	}
+
+	return s[:size], nil
+}
+
 func zeroFloatSlice(s []float64, size int) []float64 {
 	s = s[:size]
 
@@ -0,0 +1,649 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// Provenance-includes-location: https://github.com/prometheus/prometheus/blob/main/promql/functions.go
+// Provenance-includes-license: Apache-2.0
+// Provenance-includes-copyright: The Prometheus Authors
+
+package operator
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// RangeVectorFunctions contains the functions supported by FunctionOverRangeVector, by name.
+//
+// absent_over_time is implemented with present_over_time and Absent.
+var RangeVectorFunctions = map[string]RangeVectorFunction{
+	"rate":               {StepFunc: rate},
+	"increase":           {StepFunc: increase},
+	"delta":              {StepFunc: delta},
+	"irate":              {StepFunc: irate},
+	"idelta":             {StepFunc: idelta},
+	"deriv":              {StepFunc: deriv},
+	"predict_linear":     {StepFunc: predictLinear},
+	"resets":             {StepFunc: resets},
+	"changes":            {StepFunc: changes},
+	"avg_over_time":      {StepFunc: avgOverTime},
+	"min_over_time":      {StepFunc: minOverTime},
+	"max_over_time":      {StepFunc: maxOverTime},
+	"sum_over_time":      {StepFunc: sumOverTime},
+	"count_over_time":    {StepFunc: countOverTime},
+	"last_over_time":     {StepFunc: lastOverTime, KeepMetricName: true},
+	"present_over_time":  {StepFunc: presentOverTime},
+	"stddev_over_time":   {StepFunc: stddevOverTime},
+	"stdvar_over_time":   {StepFunc: stdvarOverTime},
+	"quantile_over_time": {StepFunc: quantileOverTime},
+	"holt_winters":       {StepFunc: holtWinters},
+}
+
+func rate(step RangeVectorStepData, rangeSeconds float64, _ []float64, _ *limiting.MemoryConsumptionTracker) (float64, bool, *histogram.FloatHistogram, error) {
+	return extrapolatedRate(step, rangeSeconds, true, true)
+}
+
+func increase(step RangeVectorStepData, rangeSeconds float64, _ []float64, _ *limiting.MemoryConsumptionTracker) (float64, bool, *histogram.FloatHistogram, error) {
+	return extrapolatedRate(step, rangeSeconds, true, false)
+}
+
+func delta(step RangeVectorStepData, rangeSeconds float64, _ []float64, _ *limiting.MemoryConsumptionTracker) (float64, bool, *histogram.FloatHistogram, error) {
+	return extrapolatedRate(step, rangeSeconds, false, false)
+}
+
+// extrapolatedRate implements rate, increase and delta.
+// It calculates the change over the range (allowing for counter resets if isCounter is true), extrapolates if the
+// first or last point is close to the boundary of the range, and returns the result as either per-second (if isRate
+// is true) or overall.
+//
+// https://github.com/prometheus/prometheus/pull/13725 has a good explanation of the intended behaviour here.
+func extrapolatedRate(step RangeVectorStepData, rangeSeconds float64, isCounter, isRate bool) (float64, bool, *histogram.FloatHistogram, error) {
+	floatCount := len(step.FloatsHead) + len(step.FloatsTail)
+	histogramCount := len(step.HistogramsHead) + len(step.HistogramsTail)
+
+	if floatCount > 0 && histogramCount > 0 {
+		// We need either at least two floats and no histograms, or at least two histograms and no floats.
+		return 0, false, nil, nil
+	}
+
+	var (
+		resultFloat        float64
+		resultHistogram    *histogram.FloatHistogram
+		firstT, lastT      int64
+		numSamplesMinusOne int
+		firstFloat         promql.FPoint
+	)
+
+	switch {
+	case histogramCount > 1:
+		numSamplesMinusOne = histogramCount - 1
+		firstT = pointAt(step.HistogramsHead, step.HistogramsTail, 0).T
+		lastT = pointAt(step.HistogramsHead, step.HistogramsTail, numSamplesMinusOne).T
+		resultHistogram = histogramRate(step, isCounter)
+	case floatCount > 1:
+		numSamplesMinusOne = floatCount - 1
+		firstFloat = pointAt(step.FloatsHead, step.FloatsTail, 0)
+		lastFloat := pointAt(step.FloatsHead, step.FloatsTail, numSamplesMinusOne)
+		firstT = firstFloat.T
+		lastT = lastFloat.T
+		resultFloat = lastFloat.F - firstFloat.F
+
+		if isCounter {
+			// Handle counter resets.
+			previousValue := firstFloat.F
+
+			for _, points := range [][]promql.FPoint{step.FloatsHead, step.FloatsTail} {
+				for _, p := range points {
+					if p.F < previousValue {
+						resultFloat += previousValue
+					}
+
+					previousValue = p.F
+				}
+			}
+		}
+	default:
+		// Not enough points, skip.
+		return 0, false, nil, nil
+	}
+
+	durationToStart := float64(firstT-step.RangeStart) / 1000
+	durationToEnd := float64(step.RangeEnd-lastT) / 1000
+
+	sampledInterval := float64(lastT-firstT) / 1000
+	averageDurationBetweenSamples := sampledInterval / float64(numSamplesMinusOne)
+
+	extrapolationThreshold := averageDurationBetweenSamples * 1.1
+	extrapolateToInterval := sampledInterval
+
+	if durationToStart >= extrapolationThreshold {
+		durationToStart = averageDurationBetweenSamples / 2
+	}
+
+	if isCounter && resultFloat > 0 && floatCount > 0 && firstFloat.F >= 0 {
+		// Counters cannot be negative, so don't extrapolate the start of the range past the point where the counter would be zero.
+		// Like Prometheus' engine, this is only done for floats.
+		durationToZero := sampledInterval * (firstFloat.F / resultFloat)
+		if durationToZero < durationToStart {
+			durationToStart = durationToZero
+		}
+	}
+
+	extrapolateToInterval += durationToStart
+
+	if durationToEnd >= extrapolationThreshold {
+		durationToEnd = averageDurationBetweenSamples / 2
+	}
+
+	extrapolateToInterval += durationToEnd
+
+	factor := extrapolateToInterval / sampledInterval
+	if isRate {
+		factor /= rangeSeconds
+	}
+
+	if resultHistogram != nil {
+		return 0, false, resultHistogram.Mul(factor), nil
+	}
+
+	return resultFloat * factor, true, nil, nil
+}
+
+// histogramRate computes the change between the first and last histograms in step, allowing for counter resets if
+// isCounter is true. step must contain at least two histograms.
+func histogramRate(step RangeVectorStepData, isCounter bool) *histogram.FloatHistogram {
+	count := len(step.HistogramsHead) + len(step.HistogramsTail)
+	first := pointAt(step.HistogramsHead, step.HistogramsTail, 0).H
+	last := pointAt(step.HistogramsHead, step.HistogramsTail, count-1).H
+
+	minSchema := first.Schema
+	if last.Schema < minSchema {
+		minSchema = last.Schema
+	}
+
+	if isCounter {
+		for i := 1; i < count-1; i++ {
+			if s := pointAt(step.HistogramsHead, step.HistogramsTail, i).H.Schema; s < minSchema {
+				minSchema = s
+			}
+		}
+	}
+
+	h := last.CopyToSchema(minSchema)
+	h.Sub(first)
+
+	if isCounter {
+		// Deal with counter resets.
+		previous := first
+
+		for i := 1; i < count; i++ {
+			current := pointAt(step.HistogramsHead, step.HistogramsTail, i).H
+			if current.DetectReset(previous) {
+				h.Add(previous)
+			}
+
+			previous = current
+		}
+	}
+
+	h.CounterResetHint = histogram.GaugeType
+	return h.Compact(0)
+}
+
+func irate(step RangeVectorStepData, _ float64, _ []float64, _ *limiting.MemoryConsumptionTracker) (float64, bool, *histogram.FloatHistogram, error) {
+	return instantValue(step, true)
+}
+
+func idelta(step RangeVectorStepData, _ float64, _ []float64, _ *limiting.MemoryConsumptionTracker) (float64, bool, *histogram.FloatHistogram, error) {
+	return instantValue(step, false)
+}
+
+// instantValue implements irate and idelta, which only consider the last two floats in the range.
+func instantValue(step RangeVectorStepData, isRate bool) (float64, bool, *histogram.FloatHistogram, error) {
+	count := len(step.FloatsHead) + len(step.FloatsTail)
+	if count < 2 {
+		// Not enough points, skip.
+		return 0, false, nil, nil
+	}
+
+	lastPoint := pointAt(step.FloatsHead, step.FloatsTail, count-1)
+	previousPoint := pointAt(step.FloatsHead, step.FloatsTail, count-2)
+
+	var result float64
+	if isRate && lastPoint.F < previousPoint.F {
+		// Counter reset.
+		result = lastPoint.F
+	} else {
+		result = lastPoint.F - previousPoint.F
+	}
+
+	sampledInterval := lastPoint.T - previousPoint.T
+	if sampledInterval == 0 {
+		// Avoid dividing by 0.
+		return 0, false, nil, nil
+	}
+
+	if isRate {
+		// Convert to per-second.
+		result /= float64(sampledInterval) / 1000
+	}
+
+	return result, true, nil, nil
+}
+
+func deriv(step RangeVectorStepData, _ float64, _ []float64, _ *limiting.MemoryConsumptionTracker) (float64, bool, *histogram.FloatHistogram, error) {
+	if len(step.FloatsHead)+len(step.FloatsTail) < 2 {
+		// Not enough points, skip.
+		return 0, false, nil, nil
+	}
+
+	// Like Prometheus' engine, we use the time of the first point as the intercept time to avoid floating point
+	// accuracy issues, see https://github.com/prometheus/prometheus/issues/2674
+	slope, _ := linearRegression(step, pointAt(step.FloatsHead, step.FloatsTail, 0).T)
+	return slope, true, nil, nil
+}
+
+// predictLinear takes a single argument: the number of seconds after the step time to predict the value for.
+func predictLinear(step RangeVectorStepData, _ float64, args []float64, _ *limiting.MemoryConsumptionTracker) (float64, bool, *histogram.FloatHistogram, error) {
+	if len(step.FloatsHead)+len(step.FloatsTail) < 2 {
+		// Not enough points, skip.
+		return 0, false, nil, nil
+	}
+
+	duration := args[0]
+	slope, intercept := linearRegression(step, step.StepT)
+	return slope*duration + intercept, true, nil, nil
+}
+
+// linearRegression performs a least-square linear regression analysis on the floats in step.
+// It returns the slope, and the intercept value at interceptTime.
+func linearRegression(step RangeVectorStepData, interceptTime int64) (slope, intercept float64) {
+	var (
+		n          float64
+		sumX, cX   float64
+		sumY, cY   float64
+		sumXY, cXY float64
+		sumX2, cX2 float64
+		initY      float64
+		constY     bool
+	)
+
+	initY = pointAt(step.FloatsHead, step.FloatsTail, 0).F
+	constY = true
+
+	for _, points := range [][]promql.FPoint{step.FloatsHead, step.FloatsTail} {
+		for _, p := range points {
+			// Set constY to false if any new y values are encountered.
+			if constY && p.F != initY {
+				constY = false
+			}
+
+			n += 1.0
+			x := float64(p.T-interceptTime) / 1e3
+			sumX, cX = kahanSumInc(x, sumX, cX)
+			sumY, cY = kahanSumInc(p.F, sumY, cY)
+			sumXY, cXY = kahanSumInc(x*p.F, sumXY, cXY)
+			sumX2, cX2 = kahanSumInc(x*x, sumX2, cX2)
+		}
+	}
+
+	if constY {
+		if math.IsInf(initY, 0) {
+			return math.NaN(), math.NaN()
+		}
+
+		return 0, initY
+	}
+
+	sumX += cX
+	sumY += cY
+	sumXY += cXY
+	sumX2 += cX2
+
+	covXY := sumXY - sumX*sumY/n
+	varX := sumX2 - sumX*sumX/n
+
+	slope = covXY / varX
+	intercept = sumY/n - slope*sumX/n
+	return slope, intercept
+}
+
+func resets(step RangeVectorStepData, _ float64, _ []float64, _ *limiting.MemoryConsumptionTracker) (float64, bool, *histogram.FloatHistogram, error) {
+	count := 0
+
+	if len(step.FloatsHead)+len(step.FloatsTail) > 1 {
+		previous := pointAt(step.FloatsHead, step.FloatsTail, 0).F
+
+		for _, points := range [][]promql.FPoint{step.FloatsHead, step.FloatsTail} {
+			for _, p := range points {
+				if p.F < previous {
+					count++
+				}
+
+				previous = p.F
+			}
+		}
+	}
+
+	histogramCount := len(step.HistogramsHead) + len(step.HistogramsTail)
+
+	if histogramCount > 1 {
+		previous := pointAt(step.HistogramsHead, step.HistogramsTail, 0).H
+
+		for i := 1; i < histogramCount; i++ {
+			current := pointAt(step.HistogramsHead, step.HistogramsTail, i).H
+			if current.DetectReset(previous) {
+				count++
+			}
+
+			previous = current
+		}
+	}
+
+	return float64(count), true, nil, nil
+}
+
+func changes(step RangeVectorStepData, _ float64, _ []float64, _ *limiting.MemoryConsumptionTracker) (float64, bool, *histogram.FloatHistogram, error) {
+	if len(step.FloatsHead)+len(step.FloatsTail) == 0 {
+		// Like Prometheus' engine, histograms are ignored.
+		return 0, false, nil, nil
+	}
+
+	count := 0
+	previous := pointAt(step.FloatsHead, step.FloatsTail, 0).F
+
+	for _, points := range [][]promql.FPoint{step.FloatsHead, step.FloatsTail} {
+		for _, p := range points {
+			if p.F != previous && !(math.IsNaN(p.F) && math.IsNaN(previous)) {
+				count++
+			}
+
+			previous = p.F
+		}
+	}
+
+	return float64(count), true, nil, nil
+}
+
+func avgOverTime(step RangeVectorStepData, _ float64, _ []float64, _ *limiting.MemoryConsumptionTracker) (float64, bool, *histogram.FloatHistogram, error) {
+	floatCount := len(step.FloatsHead) + len(step.FloatsTail)
+
+	if floatCount > 0 && len(step.HistogramsHead)+len(step.HistogramsTail) > 0 {
+		// Mixed floats and histograms, skip.
+		return 0, false, nil, nil
+	}
+
+	if floatCount == 0 {
+		var mean *histogram.FloatHistogram
+		count := 0
+
+		for _, points := range [][]promql.HPoint{step.HistogramsHead, step.HistogramsTail} {
+			for _, p := range points {
+				count++
+
+				if mean == nil {
+					mean = p.H.Copy()
+					continue
+				}
+
+				left := p.H.Copy().Div(float64(count))
+				right := mean.Copy().Div(float64(count))
+				mean.Add(left.Sub(right))
+			}
+		}
+
+		return 0, false, mean, nil
+	}
+
+	var mean, count, c float64
+
+	for _, points := range [][]promql.FPoint{step.FloatsHead, step.FloatsTail} {
+		for _, p := range points {
+			count++
+
+			if math.IsInf(mean, 0) {
+				if math.IsInf(p.F, 0) && (mean > 0) == (p.F > 0) {
+					// The mean and this value are infinities of the same sign, so the mean is already correct.
+					continue
+				}
+
+				if !math.IsInf(p.F, 0) && !math.IsNaN(p.F) {
+					// The mean is infinite, and adding a finite value won't change that.
+					// The calculation below would otherwise produce Inf - Inf = NaN.
+					continue
+				}
+			}
+
+			mean, c = kahanSumInc(p.F/count-mean/count, mean, c)
+		}
+	}
+
+	if math.IsInf(mean, 0) {
+		return mean, true, nil, nil
+	}
+
+	return mean + c, true, nil, nil
+}
+
+func minOverTime(step RangeVectorStepData, _ float64, _ []float64, _ *limiting.MemoryConsumptionTracker) (float64, bool, *histogram.FloatHistogram, error) {
+	return minMaxOverTime(step, false)
+}
+
+func maxOverTime(step RangeVectorStepData, _ float64, _ []float64, _ *limiting.MemoryConsumptionTracker) (float64, bool, *histogram.FloatHistogram, error) {
+	return minMaxOverTime(step, true)
+}
+
+func minMaxOverTime(step RangeVectorStepData, isMax bool) (float64, bool, *histogram.FloatHistogram, error) {
+	if len(step.FloatsHead)+len(step.FloatsTail) == 0 {
+		// Like Prometheus' engine, histograms are ignored.
+		return 0, false, nil, nil
+	}
+
+	result := pointAt(step.FloatsHead, step.FloatsTail, 0).F
+
+	for _, points := range [][]promql.FPoint{step.FloatsHead, step.FloatsTail} {
+		for _, p := range points {
+			if math.IsNaN(result) || (isMax && p.F > result) || (!isMax && p.F < result) {
+				result = p.F
+			}
+		}
+	}
+
+	return result, true, nil, nil
+}
+
+func sumOverTime(step RangeVectorStepData, _ float64, _ []float64, _ *limiting.MemoryConsumptionTracker) (float64, bool, *histogram.FloatHistogram, error) {
+	floatCount := len(step.FloatsHead) + len(step.FloatsTail)
+
+	if floatCount > 0 && len(step.HistogramsHead)+len(step.HistogramsTail) > 0 {
+		// Mixed floats and histograms, skip.
+		return 0, false, nil, nil
+	}
+
+	if floatCount == 0 {
+		var sum *histogram.FloatHistogram
+
+		for _, points := range [][]promql.HPoint{step.HistogramsHead, step.HistogramsTail} {
+			for _, p := range points {
+				if sum == nil {
+					sum = p.H.Copy()
+					continue
+				}
+
+				sum.Add(p.H)
+			}
+		}
+
+		return 0, false, sum, nil
+	}
+
+	var sum, c float64
+
+	for _, points := range [][]promql.FPoint{step.FloatsHead, step.FloatsTail} {
+		for _, p := range points {
+			sum, c = kahanSumInc(p.F, sum, c)
+		}
+	}
+
+	if math.IsInf(sum, 0) {
+		return sum, true, nil, nil
+	}
+
+	return sum + c, true, nil, nil
+}
+
+func countOverTime(step RangeVectorStepData, _ float64, _ []float64, _ *limiting.MemoryConsumptionTracker) (float64, bool, *histogram.FloatHistogram, error) {
+	count := len(step.FloatsHead) + len(step.FloatsTail) + len(step.HistogramsHead) + len(step.HistogramsTail)
+	return float64(count), true, nil, nil
+}
+
+func lastOverTime(step RangeVectorStepData, _ float64, _ []float64, _ *limiting.MemoryConsumptionTracker) (float64, bool, *histogram.FloatHistogram, error) {
+	floatCount := len(step.FloatsHead) + len(step.FloatsTail)
+	histogramCount := len(step.HistogramsHead) + len(step.HistogramsTail)
+
+	var lastFloat promql.FPoint
+	if floatCount > 0 {
+		lastFloat = pointAt(step.FloatsHead, step.FloatsTail, floatCount-1)
+	}
+
+	if histogramCount == 0 {
+		return lastFloat.F, true, nil, nil
+	}
+
+	lastHistogram := pointAt(step.HistogramsHead, step.HistogramsTail, histogramCount-1)
+	if floatCount > 0 && lastHistogram.T < lastFloat.T {
+		return lastFloat.F, true, nil, nil
+	}
+
+	return 0, false, lastHistogram.H.Copy(), nil
+}
+
+func presentOverTime(_ RangeVectorStepData, _ float64, _ []float64, _ *limiting.MemoryConsumptionTracker) (float64, bool, *histogram.FloatHistogram, error) {
+	// FunctionOverRangeVector only calls this function if there is at least one point in the range.
+	return 1, true, nil, nil
+}
+
+func stddevOverTime(step RangeVectorStepData, _ float64, _ []float64, _ *limiting.MemoryConsumptionTracker) (float64, bool, *histogram.FloatHistogram, error) {
+	if len(step.FloatsHead)+len(step.FloatsTail) == 0 {
+		// Like Prometheus' engine, histograms are ignored.
+		return 0, false, nil, nil
+	}
+
+	return math.Sqrt(variance(step)), true, nil, nil
+}
+
+func stdvarOverTime(step RangeVectorStepData, _ float64, _ []float64, _ *limiting.MemoryConsumptionTracker) (float64, bool, *histogram.FloatHistogram, error) {
+	if len(step.FloatsHead)+len(step.FloatsTail) == 0 {
+		// Like Prometheus' engine, histograms are ignored.
+		return 0, false, nil, nil
+	}
+
+	return variance(step), true, nil, nil
+}
+
+// variance computes the population variance of the floats in step, in the same way as Prometheus' engine.
+func variance(step RangeVectorStepData) float64 {
+	var count float64
+	var mean, cMean float64
+	var aux, cAux float64
+
+	for _, points := range [][]promql.FPoint{step.FloatsHead, step.FloatsTail} {
+		for _, p := range points {
+			count++
+			delta := p.F - (mean + cMean)
+			mean, cMean = kahanSumInc(delta/count, mean, cMean)
+			aux, cAux = kahanSumInc(delta*(p.F-(mean+cMean)), aux, cAux)
+		}
+	}
+
+	return (aux + cAux) / count
+}
+
+// quantileOverTime takes a single argument: the quantile to compute.
+func quantileOverTime(step RangeVectorStepData, _ float64, args []float64, memoryConsumptionTracker *limiting.MemoryConsumptionTracker) (float64, bool, *histogram.FloatHistogram, error) {
+	count := len(step.FloatsHead) + len(step.FloatsTail)
+	if count == 0 {
+		// Like Prometheus' engine, histograms are ignored.
+		return 0, false, nil, nil
+	}
+
+	values, err := GetFloatSlice(count, memoryConsumptionTracker)
+	if err != nil {
+		return 0, false, nil, err
+	}
+
+	defer PutFloatSlice(values, memoryConsumptionTracker)
+
+	for _, points := range [][]promql.FPoint{step.FloatsHead, step.FloatsTail} {
+		for _, p := range points {
+			values = append(values, p.F)
+		}
+	}
+
+	return quantile(args[0], values), true, nil, nil
+}
+
+// holtWinters takes two arguments: the smoothing factor and the trend factor.
+//
+// Like Prometheus' engine, this is an implementation of double exponential smoothing, see
+// https://en.wikipedia.org/wiki/Exponential_smoothing#Double_exponential_smoothing_(Holt_linear)
+func holtWinters(step RangeVectorStepData, _ float64, args []float64, _ *limiting.MemoryConsumptionTracker) (float64, bool, *histogram.FloatHistogram, error) {
+	smoothingFactor := args[0]
+	trendFactor := args[1]
+
+	if smoothingFactor <= 0 || smoothingFactor >= 1 {
+		return 0, false, nil, fmt.Errorf("invalid smoothing factor. Expected: 0 < sf < 1, got: %f", smoothingFactor)
+	}
+
+	if trendFactor <= 0 || trendFactor >= 1 {
+		return 0, false, nil, fmt.Errorf("invalid trend factor. Expected: 0 < tf < 1, got: %f", trendFactor)
+	}
+
+	count := len(step.FloatsHead) + len(step.FloatsTail)
+	if count < 2 {
+		// Can't do the smoothing operation with less than two points.
+		return 0, false, nil, nil
+	}
+
+	var s0, s1, b float64
+	s1 = pointAt(step.FloatsHead, step.FloatsTail, 0).F
+	b = pointAt(step.FloatsHead, step.FloatsTail, 1).F - s1
+
+	for i := 1; i < count; i++ {
+		// Scale the raw value against the smoothing factor.
+		x := smoothingFactor * pointAt(step.FloatsHead, step.FloatsTail, i).F
+
+		// Scale the last smoothed value with the trend at this point.
+		if i > 1 {
+			b = trendFactor*(s1-s0) + (1-trendFactor)*b
+		}
+
+		y := (1 - smoothingFactor) * (s1 + b)
+
+		s0, s1 = s1, x+y
+	}
+
+	return s1, true, nil, nil
+}
+
+func kahanSumInc(inc, sum, c float64) (newSum, newC float64) {
+	t := sum + inc
+	// Using Neumaier improvement, swap if next term larger than sum.
+	if math.Abs(sum) >= math.Abs(inc) {
+		c += (sum - t) + inc
+	} else {
+		c += (inc - t) + sum
+	}
+	return t, c
+}
+
+// pointAt returns the point at index i, where head and tail are the two segments returned by a ring buffer.
+func pointAt[T promql.FPoint | promql.HPoint](head, tail []T, i int) T {
+	if i < len(head) {
+		return head[i]
+	}
+
+	return tail[i-len(head)]
+}
@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// Provenance-includes-location: https://github.com/prometheus/prometheus/blob/main/promql/engine.go
+// Provenance-includes-license: Apache-2.0
+// Provenance-includes-copyright: The Prometheus Authors
+
+package operator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// RangeVectorSelector selects the points in the range of a range vector selector (eg. some_metric[5m]) at each step.
+//
+// The points in the range for each step are held in ring buffers, so each point is only read from the selector once,
+// regardless of how many steps it is part of.
+type RangeVectorSelector struct {
+	Selector *Selector
+
+	rangeMilliseconds int64
+	numSteps          int
+	nextT             int64
+
+	chunkIterator chunkenc.Iterator
+}
+
+var _ RangeVectorOperator = &RangeVectorSelector{}
+
+func (m *RangeVectorSelector) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error) {
+	// Compute values we need on every call to NextStepSamples() once, here.
+	m.rangeMilliseconds = m.Selector.Range.Milliseconds()
+	m.numSteps = stepCount(m.Selector.Start, m.Selector.End, m.Selector.Interval)
+
+	return m.Selector.SeriesMetadata(ctx)
+}
+
+func (m *RangeVectorSelector) StepCount() int {
+	return m.numSteps
+}
+
+func (m *RangeVectorSelector) Range() time.Duration {
+	return m.Selector.Range
+}
+
+func (m *RangeVectorSelector) NextSeries(_ context.Context) error {
+	var err error
+	m.chunkIterator, err = m.Selector.Next(m.chunkIterator)
+	if err != nil {
+		return err
+	}
+
+	m.nextT = m.Selector.Start
+	return nil
+}
+
+func (m *RangeVectorSelector) NextStepSamples(floats *FPointRingBuffer, histograms *HPointRingBuffer) (RangeVectorStepData, error) {
+	if m.nextT > m.Selector.End {
+		return RangeVectorStepData{}, EOS
+	}
+
+	stepT := m.nextT
+	rangeEnd := stepT
+
+	if m.Selector.Timestamp != nil {
+		rangeEnd = *m.Selector.Timestamp
+	}
+
+	rangeEnd -= m.Selector.Offset
+	rangeStart := rangeEnd - m.rangeMilliseconds
+	floats.DiscardPointsBefore(rangeStart)
+	histograms.DiscardPointsBefore(rangeStart)
+
+	if err := m.fillBuffers(floats, histograms, rangeStart, rangeEnd); err != nil {
+		return RangeVectorStepData{}, err
+	}
+
+	m.nextT += m.Selector.Interval
+
+	// The buffers may contain a point after the end of this range (eg. if there is a gap in the series, or the range
+	// is shifted by an offset or @ modifier), so exclude it from the step.
+	step := RangeVectorStepData{StepT: stepT, RangeStart: rangeStart, RangeEnd: rangeEnd}
+	step.FloatsHead, step.FloatsTail = floats.PointsAtOrBefore(rangeEnd)
+	step.HistogramsHead, step.HistogramsTail = histograms.PointsAtOrBefore(rangeEnd)
+
+	return step, nil
+}
+
+func (m *RangeVectorSelector) fillBuffers(floats *FPointRingBuffer, histograms *HPointRingBuffer, rangeStart, rangeEnd int64) error {
+	// Keep filling the buffers until we reach the end of the range or the end of the iterator.
+	for {
+		valueType := m.chunkIterator.Next()
+
+		switch valueType {
+		case chunkenc.ValNone:
+			// No more data. We are done.
+			return m.chunkIterator.Err()
+		case chunkenc.ValFloat:
+			t, f := m.chunkIterator.At()
+			if value.IsStaleNaN(f) || t < rangeStart {
+				continue
+			}
+
+			if err := floats.Append(promql.FPoint{T: t, F: f}); err != nil {
+				return err
+			}
+
+			if t >= rangeEnd {
+				return nil
+			}
+		case chunkenc.ValHistogram, chunkenc.ValFloatHistogram:
+			t := m.chunkIterator.AtT()
+			if t < rangeStart {
+				continue
+			}
+
+			_, h := m.chunkIterator.AtFloatHistogram(nil)
+			if value.IsStaleNaN(h.Sum) {
+				continue
+			}
+
+			if err := histograms.Append(promql.HPoint{T: t, H: h}); err != nil {
+				return err
+			}
+
+			if t >= rangeEnd {
+				return nil
+			}
+		default:
+			return fmt.Errorf("unknown value type %s", valueType.String())
+		}
+	}
+}
+
+func (m *RangeVectorSelector) Close() {
+	if m.Selector != nil {
+		m.Selector.Close()
+	}
+}
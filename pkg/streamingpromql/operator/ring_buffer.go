@@ -2,16 +2,188 @@
 
 package operator
 
-import "github.com/prometheus/prometheus/promql"
+import (
+	"github.com/prometheus/prometheus/promql"
 
-type RingBuffer struct {
-	points     []promql.FPoint
-	firstIndex int // Index into 'points' of first point in this buffer.
-	size       int // Number of points in this buffer.
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// FPointRingBuffer is a ring buffer of float points, used to hold the points in the range of a range vector selector.
+type FPointRingBuffer struct {
+	points                   []promql.FPoint
+	firstIndex               int // Index into 'points' of first point in this buffer.
+	size                     int // Number of points in this buffer.
+	memoryConsumptionTracker *limiting.MemoryConsumptionTracker
+}
+
+func NewFPointRingBuffer(memoryConsumptionTracker *limiting.MemoryConsumptionTracker) *FPointRingBuffer {
+	return &FPointRingBuffer{memoryConsumptionTracker: memoryConsumptionTracker}
+}
+
+// DiscardPointsBefore discards all points in this buffer with timestamp less than t.
+func (b *FPointRingBuffer) DiscardPointsBefore(t int64) {
+	for b.size > 0 && b.points[b.firstIndex].T < t {
+		b.firstIndex++
+		b.size--
+
+		if b.firstIndex >= len(b.points) {
+			b.firstIndex = 0
+		}
+	}
+
+	if b.size == 0 {
+		b.firstIndex = 0
+	}
+}
+
+// Points returns slices of the points in this buffer.
+// Either or both slice could be empty.
+// Callers must not modify the values in the returned slices.
+//
+// FIXME: the fact we have to expose this is a bit gross, but the overhead of calling a function with ForEach is terrible.
+// Perhaps we can use range-over function iterators (https://go.dev/wiki/RangefuncExperiment) once this is not experimental?
+func (b *FPointRingBuffer) Points() ([]promql.FPoint, []promql.FPoint) {
+	endOfTailSegment := b.firstIndex + b.size
+
+	if endOfTailSegment > len(b.points) {
+		// Need to wrap around.
+		endOfHeadSegment := endOfTailSegment % len(b.points)
+		endOfTailSegment = len(b.points)
+		return b.points[b.firstIndex:endOfTailSegment], b.points[0:endOfHeadSegment]
+	}
+
+	return b.points[b.firstIndex:endOfTailSegment], nil
+}
+
+// PointsAtOrBefore returns slices of the points in this buffer with timestamp less than or equal to t.
+// Either or both slice could be empty.
+// Callers must not modify the values in the returned slices.
+func (b *FPointRingBuffer) PointsAtOrBefore(t int64) ([]promql.FPoint, []promql.FPoint) {
+	head, tail := b.Points()
+
+	for len(tail) > 0 && tail[len(tail)-1].T > t {
+		tail = tail[:len(tail)-1]
+	}
+
+	if len(tail) > 0 {
+		return head, tail
+	}
+
+	for len(head) > 0 && head[len(head)-1].T > t {
+		head = head[:len(head)-1]
+	}
+
+	return head, nil
+}
+
+// ForEach calls f for each point in this buffer.
+func (b *FPointRingBuffer) ForEach(f func(p promql.FPoint)) {
+	if b.size == 0 {
+		return
+	}
+
+	lastIndexPlusOne := b.firstIndex + b.size
+
+	if lastIndexPlusOne > len(b.points) {
+		lastIndexPlusOne = len(b.points)
+	}
+
+	for i := b.firstIndex; i < lastIndexPlusOne; i++ {
+		f(b.points[i])
+	}
+
+	if b.firstIndex+b.size < len(b.points) {
+		// Don't need to wrap around to start of buffer.
+		return
+	}
+
+	for i := 0; i < (b.firstIndex+b.size)%len(b.points); i++ {
+		f(b.points[i])
+	}
+}
+
+// Append adds p to this buffer, expanding it if required.
+// If this buffer is non-empty, p.T must be greater than or equal to the
+// timestamp of the last point in the buffer.
+// It returns an error if expanding the buffer would exceed the query's memory consumption limit.
+func (b *FPointRingBuffer) Append(p promql.FPoint) error {
+	if b.size == len(b.points) {
+		// Create a new slice, copy the elements from the current slice.
+		newSize := b.size * 2
+		if newSize == 0 {
+			newSize = 2
+		}
+
+		newSlice, err := GetFPointSlice(newSize, b.memoryConsumptionTracker)
+		if err != nil {
+			return err
+		}
+
+		newSlice = newSlice[:cap(newSlice)]
+		pointsAtEnd := b.size - b.firstIndex
+		copy(newSlice, b.points[b.firstIndex:])
+		copy(newSlice[pointsAtEnd:], b.points[:b.firstIndex])
+
+		PutFPointSlice(b.points, b.memoryConsumptionTracker)
+		b.points = newSlice
+		b.firstIndex = 0
+	}
+
+	nextIndex := (b.firstIndex + b.size) % len(b.points)
+	b.points[nextIndex] = p
+	b.size++
+	return nil
+}
+
+// Reset clears the contents of this buffer.
+func (b *FPointRingBuffer) Reset() {
+	b.firstIndex = 0
+	b.size = 0
+}
+
+// Close releases any resources associated with this buffer.
+func (b *FPointRingBuffer) Close() {
+	b.Reset()
+	PutFPointSlice(b.points, b.memoryConsumptionTracker)
+	b.points = nil
+}
+
+// First returns the first point in this buffer.
+// It panics if the buffer is empty.
+func (b *FPointRingBuffer) First() promql.FPoint {
+	if b.size == 0 {
+		panic("Can't get first element of empty buffer")
+	}
+
+	return b.points[b.firstIndex]
+}
+
+// Last returns the last point in this buffer.
+// It panics if the buffer is empty.
+func (b *FPointRingBuffer) Last() promql.FPoint {
+	if b.size == 0 {
+		panic("Can't get last element of empty buffer")
+	}
+
+	return b.points[(b.firstIndex+b.size-1)%len(b.points)]
+}
+
+// HPointRingBuffer is a ring buffer of histogram points, used to hold the points in the range of a range vector selector.
+//
+// It is identical to FPointRingBuffer, but holds promql.HPoint values rather than promql.FPoint values.
+type HPointRingBuffer struct {
+	points                   []promql.HPoint
+	firstIndex               int // Index into 'points' of first point in this buffer.
+	size                     int // Number of points in this buffer.
+	memoryConsumptionTracker *limiting.MemoryConsumptionTracker
+}
+
+func NewHPointRingBuffer(memoryConsumptionTracker *limiting.MemoryConsumptionTracker) *HPointRingBuffer {
+	return &HPointRingBuffer{memoryConsumptionTracker: memoryConsumptionTracker}
 }
 
 // DiscardPointsBefore discards all points in this buffer with timestamp less than t.
-func (b *RingBuffer) DiscardPointsBefore(t int64) {
+func (b *HPointRingBuffer) DiscardPointsBefore(t int64) {
 	for b.size > 0 && b.points[b.firstIndex].T < t {
 		b.firstIndex++
 		b.size--
@@ -32,7 +204,7 @@ func (b *RingBuffer) DiscardPointsBefore(t int64) {
 //
 // FIXME: the fact we have to expose this is a bit gross, but the overhead of calling a function with ForEach is terrible.
 // Perhaps we can use range-over function iterators (https://go.dev/wiki/RangefuncExperiment) once this is not experimental?
-func (b *RingBuffer) Points() ([]promql.FPoint, []promql.FPoint) {
+func (b *HPointRingBuffer) Points() ([]promql.HPoint, []promql.HPoint) {
 	endOfTailSegment := b.firstIndex + b.size
 
 	if endOfTailSegment > len(b.points) {
@@ -45,8 +217,29 @@ func (b *RingBuffer) Points() ([]promql.FPoint, []promql.FPoint) {
 	return b.points[b.firstIndex:endOfTailSegment], nil
 }
 
+// PointsAtOrBefore returns slices of the points in this buffer with timestamp less than or equal to t.
+// Either or both slice could be empty.
+// Callers must not modify the values in the returned slices.
+func (b *HPointRingBuffer) PointsAtOrBefore(t int64) ([]promql.HPoint, []promql.HPoint) {
+	head, tail := b.Points()
+
+	for len(tail) > 0 && tail[len(tail)-1].T > t {
+		tail = tail[:len(tail)-1]
+	}
+
+	if len(tail) > 0 {
+		return head, tail
+	}
+
+	for len(head) > 0 && head[len(head)-1].T > t {
+		head = head[:len(head)-1]
+	}
+
+	return head, nil
+}
+
 // ForEach calls f for each point in this buffer.
-func (b *RingBuffer) ForEach(f func(p promql.FPoint)) {
+func (b *HPointRingBuffer) ForEach(f func(p promql.HPoint)) {
 	if b.size == 0 {
 		return
 	}
@@ -74,7 +267,8 @@ func (b *RingBuffer) ForEach(f func(p promql.FPoint)) {
 // Append adds p to this buffer, expanding it if required.
 // If this buffer is non-empty, p.T must be greater than or equal to the
 // timestamp of the last point in the buffer.
-func (b *RingBuffer) Append(p promql.FPoint) {
+// It returns an error if expanding the buffer would exceed the query's memory consumption limit.
+func (b *HPointRingBuffer) Append(p promql.HPoint) error {
 	if b.size == len(b.points) {
 		// Create a new slice, copy the elements from the current slice.
 		newSize := b.size * 2
@@ -82,13 +276,17 @@ func (b *RingBuffer) Append(p promql.FPoint) {
 			newSize = 2
 		}
 
-		newSlice := GetFPointSlice(newSize)
+		newSlice, err := GetHPointSlice(newSize, b.memoryConsumptionTracker)
+		if err != nil {
+			return err
+		}
+
 		newSlice = newSlice[:cap(newSlice)]
 		pointsAtEnd := b.size - b.firstIndex
 		copy(newSlice, b.points[b.firstIndex:])
 		copy(newSlice[pointsAtEnd:], b.points[:b.firstIndex])
 
-		PutFPointSlice(b.points)
+		PutHPointSlice(b.points, b.memoryConsumptionTracker)
 		b.points = newSlice
 		b.firstIndex = 0
 	}
@@ -96,24 +294,25 @@ func (b *RingBuffer) Append(p promql.FPoint) {
 	nextIndex := (b.firstIndex + b.size) % len(b.points)
 	b.points[nextIndex] = p
 	b.size++
+	return nil
 }
 
 // Reset clears the contents of this buffer.
-func (b *RingBuffer) Reset() {
+func (b *HPointRingBuffer) Reset() {
 	b.firstIndex = 0
 	b.size = 0
 }
 
 // Close releases any resources associated with this buffer.
-func (b *RingBuffer) Close() {
+func (b *HPointRingBuffer) Close() {
 	b.Reset()
-	PutFPointSlice(b.points)
+	PutHPointSlice(b.points, b.memoryConsumptionTracker)
 	b.points = nil
 }
 
 // First returns the first point in this buffer.
 // It panics if the buffer is empty.
-func (b *RingBuffer) First() promql.FPoint {
+func (b *HPointRingBuffer) First() promql.HPoint {
 	if b.size == 0 {
 		panic("Can't get first element of empty buffer")
 	}
@@ -123,7 +322,7 @@ func (b *RingBuffer) First() promql.FPoint {
 
 // Last returns the last point in this buffer.
 // It panics if the buffer is empty.
-func (b *RingBuffer) Last() promql.FPoint {
+func (b *HPointRingBuffer) Last() promql.HPoint {
 	if b.size == 0 {
 		panic("Can't get last element of empty buffer")
 	}
@@ -5,54 +5,57 @@ package operator
 import (
 	"testing"
 
+	"github.com/prometheus/prometheus/model/histogram"
 	"github.com/prometheus/prometheus/promql"
 	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
 )
 
-func TestRingBuffer(t *testing.T) {
-	buf := &RingBuffer{}
-	shouldHaveNoPoints(t, buf)
+func TestFPointRingBuffer(t *testing.T) {
+	buf := NewFPointRingBuffer(limiting.NewMemoryConsumptionTracker(0, nil))
+	shouldHaveNoPoints[promql.FPoint](t, buf)
 
 	buf.DiscardPointsBefore(1) // Should handle empty buffer.
-	shouldHaveNoPoints(t, buf)
+	shouldHaveNoPoints[promql.FPoint](t, buf)
 
-	buf.Append(promql.FPoint{T: 1, F: 100})
-	shouldHavePoints(t, buf, promql.FPoint{T: 1, F: 100})
+	require.NoError(t, buf.Append(promql.FPoint{T: 1, F: 100}))
+	shouldHavePoints[promql.FPoint](t, buf, promql.FPoint{T: 1, F: 100})
 
-	buf.Append(promql.FPoint{T: 2, F: 200})
-	shouldHavePoints(t, buf, promql.FPoint{T: 1, F: 100}, promql.FPoint{T: 2, F: 200})
+	require.NoError(t, buf.Append(promql.FPoint{T: 2, F: 200}))
+	shouldHavePoints[promql.FPoint](t, buf, promql.FPoint{T: 1, F: 100}, promql.FPoint{T: 2, F: 200})
 
 	buf.DiscardPointsBefore(1)
-	shouldHavePoints(t, buf, promql.FPoint{T: 1, F: 100}, promql.FPoint{T: 2, F: 200}) // No change.
+	shouldHavePoints[promql.FPoint](t, buf, promql.FPoint{T: 1, F: 100}, promql.FPoint{T: 2, F: 200}) // No change.
 
 	buf.DiscardPointsBefore(2)
-	shouldHavePoints(t, buf, promql.FPoint{T: 2, F: 200})
+	shouldHavePoints[promql.FPoint](t, buf, promql.FPoint{T: 2, F: 200})
 
-	buf.Append(promql.FPoint{T: 3, F: 300})
-	shouldHavePoints(t, buf, promql.FPoint{T: 2, F: 200}, promql.FPoint{T: 3, F: 300})
+	require.NoError(t, buf.Append(promql.FPoint{T: 3, F: 300}))
+	shouldHavePoints[promql.FPoint](t, buf, promql.FPoint{T: 2, F: 200}, promql.FPoint{T: 3, F: 300})
 
 	buf.DiscardPointsBefore(4)
-	shouldHaveNoPoints(t, buf)
+	shouldHaveNoPoints[promql.FPoint](t, buf)
 
-	buf.Append(promql.FPoint{T: 4, F: 400})
-	buf.Append(promql.FPoint{T: 5, F: 500})
-	shouldHavePoints(t, buf, promql.FPoint{T: 4, F: 400}, promql.FPoint{T: 5, F: 500})
+	require.NoError(t, buf.Append(promql.FPoint{T: 4, F: 400}))
+	require.NoError(t, buf.Append(promql.FPoint{T: 5, F: 500}))
+	shouldHavePoints[promql.FPoint](t, buf, promql.FPoint{T: 4, F: 400}, promql.FPoint{T: 5, F: 500})
 
 	// Trigger expansion of buffer (we resize in powers of two, but the underlying slice comes from a pool that uses a factor of 10).
 	// Ideally we wouldn't reach into the internals here, but this helps ensure the test is testing the correct scenario.
 	require.Len(t, buf.points, 10, "expected underlying slice to have length 10, if this assertion fails, the test setup is not as expected")
-	buf.Append(promql.FPoint{T: 6, F: 600})
-	buf.Append(promql.FPoint{T: 7, F: 700})
-	buf.Append(promql.FPoint{T: 8, F: 800})
-	buf.Append(promql.FPoint{T: 9, F: 900})
-	buf.Append(promql.FPoint{T: 10, F: 1000})
-	buf.Append(promql.FPoint{T: 11, F: 1100})
-	buf.Append(promql.FPoint{T: 12, F: 1200})
-	buf.Append(promql.FPoint{T: 13, F: 1300})
-	buf.Append(promql.FPoint{T: 14, F: 1400})
+	require.NoError(t, buf.Append(promql.FPoint{T: 6, F: 600}))
+	require.NoError(t, buf.Append(promql.FPoint{T: 7, F: 700}))
+	require.NoError(t, buf.Append(promql.FPoint{T: 8, F: 800}))
+	require.NoError(t, buf.Append(promql.FPoint{T: 9, F: 900}))
+	require.NoError(t, buf.Append(promql.FPoint{T: 10, F: 1000}))
+	require.NoError(t, buf.Append(promql.FPoint{T: 11, F: 1100}))
+	require.NoError(t, buf.Append(promql.FPoint{T: 12, F: 1200}))
+	require.NoError(t, buf.Append(promql.FPoint{T: 13, F: 1300}))
+	require.NoError(t, buf.Append(promql.FPoint{T: 14, F: 1400}))
 	require.Greater(t, len(buf.points), 10, "expected underlying slice to be expanded, if this assertion fails, the test setup is not as expected")
 
-	shouldHavePoints(t,
+	shouldHavePoints[promql.FPoint](t,
 		buf,
 		promql.FPoint{T: 4, F: 400},
 		promql.FPoint{T: 5, F: 500},
@@ -68,40 +71,40 @@ func TestRingBuffer(t *testing.T) {
 	)
 
 	buf.Reset()
-	shouldHaveNoPoints(t, buf)
+	shouldHaveNoPoints[promql.FPoint](t, buf)
 
-	buf.Append(promql.FPoint{T: 9, F: 900})
-	shouldHavePoints(t, buf, promql.FPoint{T: 9, F: 900})
+	require.NoError(t, buf.Append(promql.FPoint{T: 9, F: 900}))
+	shouldHavePoints[promql.FPoint](t, buf, promql.FPoint{T: 9, F: 900})
 }
 
-func TestRingBuffer_DiscardPointsBefore_ThroughWrapAround(t *testing.T) {
+func TestFPointRingBuffer_DiscardPointsBefore_ThroughWrapAround(t *testing.T) {
 	// Set up the buffer so that the first point is part-way through the underlying slice.
 	// We resize in powers of two, but the underlying slice comes from a pool that uses a factor of 10.
-	buf := &RingBuffer{}
-	buf.Append(promql.FPoint{T: 1, F: 100})
-	buf.Append(promql.FPoint{T: 2, F: 200})
-	buf.Append(promql.FPoint{T: 3, F: 300})
-	buf.Append(promql.FPoint{T: 4, F: 400})
-	buf.Append(promql.FPoint{T: 5, F: 500})
-	buf.Append(promql.FPoint{T: 6, F: 600})
-	buf.Append(promql.FPoint{T: 7, F: 700})
-	buf.Append(promql.FPoint{T: 8, F: 800})
-	buf.Append(promql.FPoint{T: 9, F: 900})
-	buf.Append(promql.FPoint{T: 10, F: 1000})
+	buf := NewFPointRingBuffer(limiting.NewMemoryConsumptionTracker(0, nil))
+	require.NoError(t, buf.Append(promql.FPoint{T: 1, F: 100}))
+	require.NoError(t, buf.Append(promql.FPoint{T: 2, F: 200}))
+	require.NoError(t, buf.Append(promql.FPoint{T: 3, F: 300}))
+	require.NoError(t, buf.Append(promql.FPoint{T: 4, F: 400}))
+	require.NoError(t, buf.Append(promql.FPoint{T: 5, F: 500}))
+	require.NoError(t, buf.Append(promql.FPoint{T: 6, F: 600}))
+	require.NoError(t, buf.Append(promql.FPoint{T: 7, F: 700}))
+	require.NoError(t, buf.Append(promql.FPoint{T: 8, F: 800}))
+	require.NoError(t, buf.Append(promql.FPoint{T: 9, F: 900}))
+	require.NoError(t, buf.Append(promql.FPoint{T: 10, F: 1000}))
 
 	// Ideally we wouldn't reach into the internals here, but this helps ensure the test is testing the correct scenario.
 	require.Len(t, buf.points, 10, "expected underlying slice to have length 10, if this assertion fails, the test setup is not as expected")
 	buf.DiscardPointsBefore(8)
-	buf.Append(promql.FPoint{T: 11, F: 1100})
-	buf.Append(promql.FPoint{T: 12, F: 1200})
-	buf.Append(promql.FPoint{T: 13, F: 1300})
+	require.NoError(t, buf.Append(promql.FPoint{T: 11, F: 1100}))
+	require.NoError(t, buf.Append(promql.FPoint{T: 12, F: 1200}))
+	require.NoError(t, buf.Append(promql.FPoint{T: 13, F: 1300}))
 
 	// Should not have expanded slice.
 	require.Len(t, buf.points, 10, "expected underlying slice to have length 10, if this assertion fails, the test setup is not as expected")
 
 	// Discard before end of underlying slice.
 	buf.DiscardPointsBefore(9)
-	shouldHavePoints(t,
+	shouldHavePoints[promql.FPoint](t,
 		buf,
 		promql.FPoint{T: 9, F: 900},
 		promql.FPoint{T: 10, F: 1000},
@@ -114,14 +117,92 @@ func TestRingBuffer_DiscardPointsBefore_ThroughWrapAround(t *testing.T) {
 
 	// Discard after wraparound.
 	buf.DiscardPointsBefore(12)
-	shouldHavePoints(t,
+	shouldHavePoints[promql.FPoint](t,
 		buf,
 		promql.FPoint{T: 12, F: 1200},
 		promql.FPoint{T: 13, F: 1300},
 	)
 }
 
-func shouldHaveNoPoints(t *testing.T, buf *RingBuffer) {
+func TestFPointRingBuffer_PointsAtOrBefore(t *testing.T) {
+	buf := NewFPointRingBuffer(limiting.NewMemoryConsumptionTracker(0, nil))
+	head, tail := buf.PointsAtOrBefore(10) // Should handle empty buffer.
+	require.Empty(t, head)
+	require.Empty(t, tail)
+
+	// Set up the buffer so that the points wrap around the end of the underlying slice.
+	for ts := int64(1); ts <= 10; ts++ {
+		require.NoError(t, buf.Append(promql.FPoint{T: ts, F: float64(ts * 100)}))
+	}
+
+	buf.DiscardPointsBefore(8)
+	require.NoError(t, buf.Append(promql.FPoint{T: 11, F: 1100}))
+	require.NoError(t, buf.Append(promql.FPoint{T: 12, F: 1200}))
+
+	head, tail = buf.PointsAtOrBefore(12)
+	require.Equal(t, []promql.FPoint{{T: 8, F: 800}, {T: 9, F: 900}, {T: 10, F: 1000}}, head)
+	require.Equal(t, []promql.FPoint{{T: 11, F: 1100}, {T: 12, F: 1200}}, tail)
+
+	head, tail = buf.PointsAtOrBefore(11)
+	require.Equal(t, []promql.FPoint{{T: 8, F: 800}, {T: 9, F: 900}, {T: 10, F: 1000}}, head)
+	require.Equal(t, []promql.FPoint{{T: 11, F: 1100}}, tail)
+
+	head, tail = buf.PointsAtOrBefore(9)
+	require.Equal(t, []promql.FPoint{{T: 8, F: 800}, {T: 9, F: 900}}, head)
+	require.Empty(t, tail)
+
+	head, tail = buf.PointsAtOrBefore(7)
+	require.Empty(t, head)
+	require.Empty(t, tail)
+}
+
+func TestHPointRingBuffer(t *testing.T) {
+	h1 := &histogram.FloatHistogram{Count: 1}
+	h2 := &histogram.FloatHistogram{Count: 2}
+	h3 := &histogram.FloatHistogram{Count: 3}
+	h4 := &histogram.FloatHistogram{Count: 4}
+
+	buf := NewHPointRingBuffer(limiting.NewMemoryConsumptionTracker(0, nil))
+	shouldHaveNoPoints[promql.HPoint](t, buf)
+
+	buf.DiscardPointsBefore(1) // Should handle empty buffer.
+	shouldHaveNoPoints[promql.HPoint](t, buf)
+
+	require.NoError(t, buf.Append(promql.HPoint{T: 1, H: h1}))
+	shouldHavePoints[promql.HPoint](t, buf, promql.HPoint{T: 1, H: h1})
+
+	require.NoError(t, buf.Append(promql.HPoint{T: 2, H: h2}))
+	shouldHavePoints[promql.HPoint](t, buf, promql.HPoint{T: 1, H: h1}, promql.HPoint{T: 2, H: h2})
+
+	buf.DiscardPointsBefore(2)
+	shouldHavePoints[promql.HPoint](t, buf, promql.HPoint{T: 2, H: h2})
+
+	require.NoError(t, buf.Append(promql.HPoint{T: 3, H: h3}))
+	require.NoError(t, buf.Append(promql.HPoint{T: 4, H: h4}))
+	shouldHavePoints[promql.HPoint](t, buf, promql.HPoint{T: 2, H: h2}, promql.HPoint{T: 3, H: h3}, promql.HPoint{T: 4, H: h4})
+
+	head, tail := buf.PointsAtOrBefore(3)
+	require.Equal(t, []promql.HPoint{{T: 2, H: h2}, {T: 3, H: h3}}, append(head, tail...))
+
+	buf.DiscardPointsBefore(5)
+	shouldHaveNoPoints[promql.HPoint](t, buf)
+
+	require.NoError(t, buf.Append(promql.HPoint{T: 5, H: h1}))
+	buf.Reset()
+	shouldHaveNoPoints[promql.HPoint](t, buf)
+
+	require.NoError(t, buf.Append(promql.HPoint{T: 9, H: h4}))
+	shouldHavePoints[promql.HPoint](t, buf, promql.HPoint{T: 9, H: h4})
+}
+
+type ringBuffer[T any] interface {
+	ForEach(f func(p T))
+	Points() ([]T, []T)
+	First() T
+	Last() T
+}
+
+func shouldHaveNoPoints[T any](t *testing.T, buf ringBuffer[T]) {
 	shouldHavePoints(
 		t,
 		buf,
@@ -129,10 +210,10 @@ func shouldHaveNoPoints(t *testing.T, buf *RingBuffer) {
 	)
 }
 
-func shouldHavePoints(t *testing.T, buf *RingBuffer, expected ...promql.FPoint) {
-	var actual []promql.FPoint
+func shouldHavePoints[T any](t *testing.T, buf ringBuffer[T], expected ...T) {
+	var actual []T
 
-	buf.ForEach(func(p promql.FPoint) {
+	buf.ForEach(func(p T) {
 		actual = append(actual, p)
 	})
 
@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package operator
+
+import (
+	"context"
+
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// ScalarConstant is an operator that produces the same value at every step, such as a number literal.
+type ScalarConstant struct {
+	Value    float64
+	Start    int64 // Milliseconds since Unix epoch
+	End      int64 // Milliseconds since Unix epoch
+	Interval int64 // In milliseconds
+
+	MemoryConsumptionTracker *limiting.MemoryConsumptionTracker
+}
+
+var _ ScalarOperator = &ScalarConstant{}
+
+func (s *ScalarConstant) GetValues(_ context.Context) (ScalarData, error) {
+	samples, err := GetFPointSlice(stepCount(s.Start, s.End, s.Interval), s.MemoryConsumptionTracker)
+	if err != nil {
+		return ScalarData{}, err
+	}
+
+	for t := s.Start; t <= s.End; t += s.Interval {
+		samples = append(samples, promql.FPoint{T: t, F: s.Value})
+	}
+
+	return ScalarData{Samples: samples}, nil
+}
+
+func (s *ScalarConstant) Close() {
+	// Nothing to do.
+}
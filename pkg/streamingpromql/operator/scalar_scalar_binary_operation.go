@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// Provenance-includes-location: https://github.com/prometheus/prometheus/blob/main/promql/engine.go
+// Provenance-includes-license: Apache-2.0
+// Provenance-includes-copyright: The Prometheus Authors
+
+package operator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// ScalarScalarBinaryOperation represents a binary operation between two scalars, such as "1 + 2" or "time() > bool 3".
+type ScalarScalarBinaryOperation struct {
+	Left  ScalarOperator
+	Right ScalarOperator
+	Op    parser.ItemType
+
+	MemoryConsumptionTracker *limiting.MemoryConsumptionTracker
+}
+
+var _ ScalarOperator = &ScalarScalarBinaryOperation{}
+
+func (s *ScalarScalarBinaryOperation) GetValues(ctx context.Context) (ScalarData, error) {
+	opFunc, ok := arithmeticAndComparisonOperationFuncs[s.Op]
+	if !ok {
+		return ScalarData{}, fmt.Errorf("unsupported binary operation '%s'", s.Op)
+	}
+
+	leftValues, err := s.Left.GetValues(ctx)
+	if err != nil {
+		return ScalarData{}, err
+	}
+
+	rightValues, err := s.Right.GetValues(ctx)
+	if err != nil {
+		PutFPointSlice(leftValues.Samples, s.MemoryConsumptionTracker)
+		return ScalarData{}, err
+	}
+
+	defer PutFPointSlice(rightValues.Samples, s.MemoryConsumptionTracker)
+
+	isComparison := s.Op.IsComparisonOperator()
+
+	// We reuse the left side's slice for the output.
+	for i, p := range leftValues.Samples {
+		f, keep := opFunc(p.F, rightValues.Samples[i].F)
+
+		if isComparison {
+			// Comparisons between scalars must use 'bool', so always return 0 or 1.
+			f = btos(keep)
+		}
+
+		leftValues.Samples[i].F = f
+	}
+
+	return leftValues, nil
+}
+
+func (s *ScalarScalarBinaryOperation) Close() {
+	s.Left.Close()
+	s.Right.Close()
+}
@@ -0,0 +1,53 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package operator
+
+import (
+	"context"
+
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// ScalarToInstantVector is an operator that converts a scalar to an instant vector with a single series with no
+// labels, as used by vector().
+type ScalarToInstantVector struct {
+	Scalar ScalarOperator
+
+	MemoryConsumptionTracker *limiting.MemoryConsumptionTracker
+
+	returned bool
+}
+
+var _ InstantVectorOperator = &ScalarToInstantVector{}
+
+func (s *ScalarToInstantVector) SeriesMetadata(_ context.Context) ([]SeriesMetadata, error) {
+	metadata, err := GetSeriesMetadataSlice(1, s.MemoryConsumptionTracker)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata = append(metadata, SeriesMetadata{Labels: labels.EmptyLabels()})
+
+	return metadata, nil
+}
+
+func (s *ScalarToInstantVector) Next(ctx context.Context) (InstantVectorSeriesData, error) {
+	if s.returned {
+		return InstantVectorSeriesData{}, EOS
+	}
+
+	s.returned = true
+
+	d, err := s.Scalar.GetValues(ctx)
+	if err != nil {
+		return InstantVectorSeriesData{}, err
+	}
+
+	return InstantVectorSeriesData{Floats: d.Samples}, nil
+}
+
+func (s *ScalarToInstantVector) Close() {
+	s.Scalar.Close()
+}
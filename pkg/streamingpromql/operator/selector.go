@@ -11,22 +11,30 @@ import (
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
 )
 
 type Selector struct {
 	Queryable storage.Queryable
-	Start     int64 // Milliseconds since Unix epoch
-	End       int64 // Milliseconds since Unix epoch
-	Timestamp *int64
-	Interval  int64 // In milliseconds
+	Start     int64  // Milliseconds since Unix epoch
+	End       int64  // Milliseconds since Unix epoch
+	Timestamp *int64 // Milliseconds since Unix epoch, only set if selector uses @ modifier (eg. metric{...} @ 123)
+	Interval  int64  // In milliseconds
 	Matchers  []*labels.Matcher
 
+	// Offset in milliseconds to apply to the evaluation time of each step (eg. 3600000 for metric{...} offset 1h).
+	// This is applied after Timestamp, if it is set.
+	Offset int64
+
 	// Set for instant vector selectors, otherwise 0.
 	LookbackDelta time.Duration
 
 	// Set for range vector selectors, otherwise 0.
 	Range time.Duration
 
+	MemoryConsumptionTracker *limiting.MemoryConsumptionTracker
+
 	querier storage.Querier
 	series  *seriesList
 }
@@ -48,6 +56,9 @@ func (s *Selector) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error)
 		endTimestamp = *s.Timestamp
 	}
 
+	startTimestamp -= s.Offset
+	endTimestamp -= s.Offset
+
 	rangeMilliseconds := s.Range.Milliseconds()
 	start := startTimestamp - s.LookbackDelta.Milliseconds() - rangeMilliseconds
 
@@ -80,7 +91,11 @@ func (s *Selector) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error)
 		s.series.Add(ss.At())
 	}
 
-	return s.series.ToSeriesMetadata(), ss.Err()
+	if err := ss.Err(); err != nil {
+		return nil, err
+	}
+
+	return s.series.ToSeriesMetadata(s.MemoryConsumptionTracker)
 }
 
 func (s *Selector) Next(existing chunkenc.Iterator) (chunkenc.Iterator, error) {
@@ -144,8 +159,12 @@ func (l *seriesList) Len() int {
 // ToSeriesMetadata returns a SeriesMetadata value for each series added to this seriesList.
 //
 // Calling ToSeriesMetadata after calling Pop may return an incomplete list.
-func (l *seriesList) ToSeriesMetadata() []SeriesMetadata {
-	metadata := GetSeriesMetadataSlice(l.length)
+func (l *seriesList) ToSeriesMetadata(memoryConsumptionTracker *limiting.MemoryConsumptionTracker) ([]SeriesMetadata, error) {
+	metadata, err := GetSeriesMetadataSlice(l.length, memoryConsumptionTracker)
+	if err != nil {
+		return nil, err
+	}
+
 	batch := l.currentSeriesBatch
 
 	for batch != nil {
@@ -156,7 +175,7 @@ func (l *seriesList) ToSeriesMetadata() []SeriesMetadata {
 		batch = batch.next
 	}
 
-	return metadata
+	return metadata, nil
 }
 
 // Pop returns the next series from the head of this seriesList, and advances
@@ -11,6 +11,8 @@ import (
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
 	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
 )
 
 func TestSeriesList_BasicListOperations(t *testing.T) {
@@ -81,7 +83,13 @@ func requireSeriesListContents(t *testing.T, list *seriesList, series ...storage
 		expectedMetadata = append(expectedMetadata, SeriesMetadata{Labels: s.Labels()})
 	}
 
-	require.Equal(t, expectedMetadata, list.ToSeriesMetadata())
+	memoryConsumptionTracker := limiting.NewMemoryConsumptionTracker(0, nil)
+	metadata, err := list.ToSeriesMetadata(memoryConsumptionTracker)
+	require.NoError(t, err)
+	require.Equal(t, expectedMetadata, metadata)
+
+	PutSeriesMetadataSlice(metadata, memoryConsumptionTracker)
+	require.Zero(t, memoryConsumptionTracker.CurrentEstimatedMemoryConsumptionBytes)
 }
 
 type mockSeries struct {
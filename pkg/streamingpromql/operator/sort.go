@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// Provenance-includes-location: https://github.com/prometheus/prometheus/blob/main/promql/functions.go
+// Provenance-includes-license: Apache-2.0
+// Provenance-includes-copyright: The Prometheus Authors
+
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// Sort represents the sort and sort_desc functions.
+//
+// sort and sort_desc have no effect on range queries, as the results of range queries are always sorted by labels.
+// For instant queries, the order of the output series depends on their values, so all input series are read in
+// SeriesMetadata.
+type Sort struct {
+	Inner      InstantVectorOperator
+	Descending bool
+
+	Start int64 // Milliseconds since Unix epoch
+	End   int64 // Milliseconds since Unix epoch
+
+	MemoryConsumptionTracker *limiting.MemoryConsumptionTracker
+
+	allData []InstantVectorSeriesData // Only populated for instant queries.
+}
+
+var _ InstantVectorOperator = &Sort{}
+
+func (s *Sort) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error) {
+	innerMetadata, err := s.Inner.SeriesMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Start != s.End {
+		// Range query: we don't need to do anything.
+		return innerMetadata, nil
+	}
+
+	s.allData = make([]InstantVectorSeriesData, 0, len(innerMetadata))
+
+	for range innerMetadata {
+		d, err := s.Inner.Next(ctx)
+		if err != nil {
+			PutSeriesMetadataSlice(innerMetadata, s.MemoryConsumptionTracker)
+
+			if errors.Is(err, EOS) {
+				return nil, fmt.Errorf("exhausted series before all series were read: %w", err)
+			}
+
+			return nil, err
+		}
+
+		s.allData = append(s.allData, d)
+	}
+
+	sort.Stable(sortSorter{metadata: innerMetadata, data: s.allData, descending: s.Descending})
+
+	return innerMetadata, nil
+}
+
+func (s *Sort) Next(ctx context.Context) (InstantVectorSeriesData, error) {
+	if s.Start != s.End {
+		return s.Inner.Next(ctx)
+	}
+
+	if len(s.allData) == 0 {
+		return InstantVectorSeriesData{}, EOS
+	}
+
+	d := s.allData[0]
+	s.allData = s.allData[1:]
+
+	return d, nil
+}
+
+func (s *Sort) BufferedPoints() int {
+	return countPoints(s.allData...)
+}
+
+func (s *Sort) Close() {
+	s.Inner.Close()
+
+	for _, d := range s.allData {
+		PutFPointSlice(d.Floats, s.MemoryConsumptionTracker)
+		PutHPointSlice(d.Histograms, s.MemoryConsumptionTracker)
+	}
+
+	s.allData = nil
+}
+
+type sortSorter struct {
+	metadata   []SeriesMetadata
+	data       []InstantVectorSeriesData
+	descending bool
+}
+
+func (s sortSorter) Len() int {
+	return len(s.metadata)
+}
+
+func (s sortSorter) Less(i, j int) bool {
+	vi, vj := sortValue(s.data[i]), sortValue(s.data[j])
+
+	// Like Prometheus' engine, NaN values are always sorted last, regardless of the sort order.
+	if math.IsNaN(vi) {
+		return false
+	}
+
+	if math.IsNaN(vj) {
+		return true
+	}
+
+	if s.descending {
+		return vi > vj
+	}
+
+	return vi < vj
+}
+
+func (s sortSorter) Swap(i, j int) {
+	s.metadata[i], s.metadata[j] = s.metadata[j], s.metadata[i]
+	s.data[i], s.data[j] = s.data[j], s.data[i]
+}
+
+// sortValue returns the value used to order d, which contains at most one point.
+//
+// Like Prometheus' engine, a histogram is sorted as if it had the value 0.
+func sortValue(d InstantVectorSeriesData) float64 {
+	if len(d.Floats) > 0 {
+		return d.Floats[0].F
+	}
+
+	return 0
+}
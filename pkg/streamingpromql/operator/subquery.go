@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// Provenance-includes-location: https://github.com/prometheus/prometheus/blob/main/promql/engine.go
+// Provenance-includes-license: Apache-2.0
+// Provenance-includes-copyright: The Prometheus Authors
+
+package operator
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// Subquery presents the result of evaluating an instant vector expression at each step of a subquery
+// (eg. rate(some_metric[5m])[1h:1m]) as a range vector.
+//
+// Inner must be configured to evaluate the expression at the steps of the subquery, not the steps of the parent query.
+// Series are read from Inner one at a time, so only the points of the current series are held in memory.
+type Subquery struct {
+	Inner InstantVectorOperator
+
+	// The time range of the parent query or subquery.
+	ParentStart    int64 // Milliseconds since Unix epoch
+	ParentEnd      int64 // Milliseconds since Unix epoch
+	ParentInterval int64 // In milliseconds
+
+	Timestamp     *int64 // Milliseconds since Unix epoch, only set if subquery uses @ modifier (eg. metric[1h:1m] @ 123)
+	Offset        int64  // In milliseconds, applied after Timestamp, if it is set.
+	SubqueryRange time.Duration
+
+	MemoryConsumptionTracker *limiting.MemoryConsumptionTracker
+
+	rangeMilliseconds int64
+	numSteps          int
+	nextT             int64
+
+	currentSeries      InstantVectorSeriesData
+	nextFloatIndex     int
+	nextHistogramIndex int
+}
+
+var _ RangeVectorOperator = &Subquery{}
+
+func (s *Subquery) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error) {
+	// Compute values we need on every call to NextStepSamples() once, here.
+	s.rangeMilliseconds = s.SubqueryRange.Milliseconds()
+	s.numSteps = stepCount(s.ParentStart, s.ParentEnd, s.ParentInterval)
+
+	return s.Inner.SeriesMetadata(ctx)
+}
+
+func (s *Subquery) StepCount() int {
+	return s.numSteps
+}
+
+func (s *Subquery) Range() time.Duration {
+	return s.SubqueryRange
+}
+
+func (s *Subquery) NextSeries(ctx context.Context) error {
+	s.releaseCurrentSeries()
+
+	var err error
+	s.currentSeries, err = s.Inner.Next(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.nextT = s.ParentStart
+	return nil
+}
+
+func (s *Subquery) NextStepSamples(floats *FPointRingBuffer, histograms *HPointRingBuffer) (RangeVectorStepData, error) {
+	if s.nextT > s.ParentEnd {
+		return RangeVectorStepData{}, EOS
+	}
+
+	stepT := s.nextT
+	rangeEnd := stepT
+
+	if s.Timestamp != nil {
+		rangeEnd = *s.Timestamp
+	}
+
+	rangeEnd -= s.Offset
+	rangeStart := rangeEnd - s.rangeMilliseconds
+	floats.DiscardPointsBefore(rangeStart)
+	histograms.DiscardPointsBefore(rangeStart)
+
+	// Points are sorted by timestamp, so we can stop as soon as we reach a point after the end of this range.
+	for ; s.nextFloatIndex < len(s.currentSeries.Floats); s.nextFloatIndex++ {
+		p := s.currentSeries.Floats[s.nextFloatIndex]
+
+		if p.T > rangeEnd {
+			break
+		}
+
+		if p.T >= rangeStart {
+			if err := floats.Append(p); err != nil {
+				return RangeVectorStepData{}, err
+			}
+		}
+	}
+
+	for ; s.nextHistogramIndex < len(s.currentSeries.Histograms); s.nextHistogramIndex++ {
+		p := s.currentSeries.Histograms[s.nextHistogramIndex]
+
+		if p.T > rangeEnd {
+			break
+		}
+
+		if p.T >= rangeStart {
+			if err := histograms.Append(p); err != nil {
+				return RangeVectorStepData{}, err
+			}
+		}
+	}
+
+	s.nextT += s.ParentInterval
+
+	step := RangeVectorStepData{StepT: stepT, RangeStart: rangeStart, RangeEnd: rangeEnd}
+	step.FloatsHead, step.FloatsTail = floats.PointsAtOrBefore(rangeEnd)
+	step.HistogramsHead, step.HistogramsTail = histograms.PointsAtOrBefore(rangeEnd)
+
+	return step, nil
+}
+
+func (s *Subquery) releaseCurrentSeries() {
+	PutFPointSlice(s.currentSeries.Floats, s.MemoryConsumptionTracker)
+	PutHPointSlice(s.currentSeries.Histograms, s.MemoryConsumptionTracker)
+	s.currentSeries = InstantVectorSeriesData{}
+	s.nextFloatIndex = 0
+	s.nextHistogramIndex = 0
+}
+
+func (s *Subquery) Close() {
+	s.Inner.Close()
+	s.releaseCurrentSeries()
+}
@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package operator
+
+import (
+	"context"
+
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// Time is an operator that produces the timestamp of each step in seconds, as returned by time().
+type Time struct {
+	Start    int64 // Milliseconds since Unix epoch
+	End      int64 // Milliseconds since Unix epoch
+	Interval int64 // In milliseconds
+
+	MemoryConsumptionTracker *limiting.MemoryConsumptionTracker
+}
+
+var _ ScalarOperator = &Time{}
+
+func (t *Time) GetValues(_ context.Context) (ScalarData, error) {
+	samples, err := GetFPointSlice(stepCount(t.Start, t.End, t.Interval), t.MemoryConsumptionTracker)
+	if err != nil {
+		return ScalarData{}, err
+	}
+
+	for ts := t.Start; ts <= t.End; ts += t.Interval {
+		samples = append(samples, promql.FPoint{T: ts, F: float64(ts) / 1000})
+	}
+
+	return ScalarData{Samples: samples}, nil
+}
+
+func (t *Time) Close() {
+	// Nothing to do.
+}
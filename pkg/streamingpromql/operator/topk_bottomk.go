@@ -0,0 +1,415 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// Provenance-includes-location: https://github.com/prometheus/prometheus/blob/main/promql/engine.go
+// Provenance-includes-license: Apache-2.0
+// Provenance-includes-copyright: The Prometheus Authors
+
+package operator
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// TopKBottomK represents the topk and bottomk aggregations.
+//
+// Unlike other aggregations, topk and bottomk return input series unchanged, but only at the time steps where they are
+// one of the k largest (or smallest) values in their group.
+//
+// For range queries, every input series is returned, and the output for a series is computed once all series in its group
+// have been read. Only a bounded heap of k values for each time step is retained for each group, so the input series
+// themselves do not need to be buffered.
+//
+// For instant queries, Prometheus' engine returns the selected series for each group ordered by value. As the selected
+// series can only be determined once every input series has been read, all input series are read in SeriesMetadata.
+// This is no worse than returning every series, as each input series contains at most one point.
+type TopKBottomK struct {
+	Inner     InstantVectorOperator
+	Param     ScalarOperator // The value of k at each step.
+	IsBottomK bool
+	Grouping  []string // Must be sorted.
+	Without   bool
+
+	Start    int64 // Milliseconds since Unix epoch
+	End      int64 // Milliseconds since Unix epoch
+	Interval int64 // In milliseconds
+
+	MemoryConsumptionTracker *limiting.MemoryConsumptionTracker
+
+	innerSeriesCount    int
+	k                   []int                   // The value of k at each step, or nil if not yet read.
+	innerSeriesToGroups []*topKBottomKGroup     // One entry per series produced by Inner, value is the group for that series.
+	nextInnerSeriesIdx  int                     // The index of the next series to read from Inner.
+	nextOutputSeriesIdx int                     // The index of the next series to return.
+	instantQueryResults []promql.FPoint         // For instant queries: the output value for each output series, in the same order as the output series.
+	pendingOutputs      map[int][]promql.FPoint // For range queries: the output points for series whose group is complete, by series index.
+}
+
+var _ InstantVectorOperator = &TopKBottomK{}
+
+type topKBottomKGroup struct {
+	seriesCount          int
+	remainingSeriesCount int
+	heaps                []*topKBottomKHeap // One entry per step, or nil if no series in this group have a point at that step.
+}
+
+func (t *TopKBottomK) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error) {
+	innerMetadata, err := t.Inner.SeriesMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(innerMetadata) == 0 {
+		// No input series == no output series.
+		PutSeriesMetadataSlice(innerMetadata, t.MemoryConsumptionTracker)
+		return nil, nil
+	}
+
+	t.innerSeriesCount = len(innerMetadata)
+	groups := map[uint64]*topKBottomKGroup{}
+	groupsInOrder := make([]*topKBottomKGroup, 0)
+	buf := make([]byte, 0, 1024)
+	t.innerSeriesToGroups = make([]*topKBottomKGroup, 0, len(innerMetadata))
+
+	for _, series := range innerMetadata {
+		// Note that, like Prometheus' engine, this doesn't handle hash collisions between groups.
+		var groupingKey uint64
+		groupingKey, buf = groupingKeyForSeries(series.Labels, t.Grouping, t.Without, buf)
+		g, groupExists := groups[groupingKey]
+
+		if !groupExists {
+			g = &topKBottomKGroup{}
+			groups[groupingKey] = g
+			groupsInOrder = append(groupsInOrder, g)
+		}
+
+		g.seriesCount++
+		g.remainingSeriesCount++
+		t.innerSeriesToGroups = append(t.innerSeriesToGroups, g)
+	}
+
+	if err := t.readK(ctx); err != nil {
+		PutSeriesMetadataSlice(innerMetadata, t.MemoryConsumptionTracker)
+		return nil, err
+	}
+
+	if t.Start != t.End {
+		t.pendingOutputs = map[int][]promql.FPoint{}
+		return innerMetadata, nil
+	}
+
+	defer PutSeriesMetadataSlice(innerMetadata, t.MemoryConsumptionTracker)
+
+	return t.computeInstantQueryResults(ctx, innerMetadata, groupsInOrder)
+}
+
+func (t *TopKBottomK) readK(ctx context.Context) error {
+	paramData, err := t.Param.GetValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer PutFPointSlice(paramData.Samples, t.MemoryConsumptionTracker)
+
+	t.k = make([]int, 0, len(paramData.Samples))
+
+	for _, p := range paramData.Samples {
+		if !convertibleToInt64(p.F) {
+			return fmt.Errorf("Scalar value %v overflows int64", p.F)
+		}
+
+		k := int(p.F)
+
+		if k > t.innerSeriesCount {
+			k = t.innerSeriesCount
+		}
+
+		if k < 1 {
+			k = 0
+		}
+
+		t.k = append(t.k, k)
+	}
+
+	return nil
+}
+
+// computeInstantQueryResults reads all series from Inner and returns the selected series, ordered by group and then by value.
+func (t *TopKBottomK) computeInstantQueryResults(ctx context.Context, innerMetadata []SeriesMetadata, groupsInOrder []*topKBottomKGroup) ([]SeriesMetadata, error) {
+	for t.nextInnerSeriesIdx < len(t.innerSeriesToGroups) {
+		if err := t.readNextInnerSeries(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	outputMetadata, err := GetSeriesMetadataSlice(len(innerMetadata), t.MemoryConsumptionTracker)
+	if err != nil {
+		return nil, err
+	}
+
+	t.instantQueryResults, err = GetFPointSlice(len(innerMetadata), t.MemoryConsumptionTracker)
+	if err != nil {
+		PutSeriesMetadataSlice(outputMetadata, t.MemoryConsumptionTracker)
+		return nil, err
+	}
+
+	for _, g := range groupsInOrder {
+		if g.heaps == nil || g.heaps[0] == nil {
+			continue
+		}
+
+		h := g.heaps[0]
+
+		// The heap keeps the value we'd discard next at the top, so reverse it to get the order Prometheus' engine uses.
+		sort.Sort(sort.Reverse(h))
+
+		for _, e := range h.entries {
+			outputMetadata = append(outputMetadata, innerMetadata[e.seriesIdx])
+			t.instantQueryResults = append(t.instantQueryResults, promql.FPoint{T: t.Start, F: e.value})
+		}
+	}
+
+	return outputMetadata, nil
+}
+
+func (t *TopKBottomK) Next(ctx context.Context) (InstantVectorSeriesData, error) {
+	if t.Start == t.End {
+		return t.nextInstantQuerySeries()
+	}
+
+	if t.nextOutputSeriesIdx >= len(t.innerSeriesToGroups) {
+		return InstantVectorSeriesData{}, EOS
+	}
+
+	seriesIdx := t.nextOutputSeriesIdx
+	t.nextOutputSeriesIdx++
+	g := t.innerSeriesToGroups[seriesIdx]
+
+	// Read series from Inner until the group for this series is complete.
+	for g.remainingSeriesCount > 0 {
+		if err := t.readNextInnerSeries(ctx); err != nil {
+			return InstantVectorSeriesData{}, err
+		}
+	}
+
+	if g.heaps != nil {
+		if err := t.computeRangeQueryOutputsForGroup(g); err != nil {
+			return InstantVectorSeriesData{}, err
+		}
+	}
+
+	points, havePoints := t.pendingOutputs[seriesIdx]
+	if !havePoints {
+		return InstantVectorSeriesData{}, nil
+	}
+
+	delete(t.pendingOutputs, seriesIdx)
+
+	return InstantVectorSeriesData{Floats: points}, nil
+}
+
+func (t *TopKBottomK) nextInstantQuerySeries() (InstantVectorSeriesData, error) {
+	if t.nextOutputSeriesIdx >= len(t.instantQueryResults) {
+		return InstantVectorSeriesData{}, EOS
+	}
+
+	points, err := GetFPointSlice(1, t.MemoryConsumptionTracker)
+	if err != nil {
+		return InstantVectorSeriesData{}, err
+	}
+
+	points = append(points, t.instantQueryResults[t.nextOutputSeriesIdx])
+	t.nextOutputSeriesIdx++
+
+	return InstantVectorSeriesData{Floats: points}, nil
+}
+
+func (t *TopKBottomK) readNextInnerSeries(ctx context.Context) error {
+	d, err := t.Inner.Next(ctx)
+	if err != nil {
+		if errors.Is(err, EOS) {
+			return fmt.Errorf("exhausted series before all groups were completed: %w", err)
+		}
+
+		return err
+	}
+
+	defer PutFPointSlice(d.Floats, t.MemoryConsumptionTracker)
+	defer PutHPointSlice(d.Histograms, t.MemoryConsumptionTracker)
+
+	if len(d.Histograms) > 0 {
+		return errHistogramsNotSupported
+	}
+
+	seriesIdx := t.nextInnerSeriesIdx
+	t.nextInnerSeriesIdx++
+	g := t.innerSeriesToGroups[seriesIdx]
+	g.remainingSeriesCount--
+
+	for _, p := range d.Floats {
+		stepIdx := (p.T - t.Start) / t.Interval
+		k := t.k[stepIdx]
+
+		if k == 0 {
+			continue
+		}
+
+		if g.heaps == nil {
+			g.heaps = make([]*topKBottomKHeap, len(t.k))
+		}
+
+		h := g.heaps[stepIdx]
+
+		if h == nil {
+			h = &topKBottomKHeap{
+				entries:   make([]topKBottomKHeapEntry, 0, min(k, g.seriesCount)),
+				isBottomK: t.IsBottomK,
+			}
+
+			g.heaps[stepIdx] = h
+		}
+
+		h.accumulate(topKBottomKHeapEntry{seriesIdx: seriesIdx, value: p.F}, k)
+	}
+
+	return nil
+}
+
+// computeRangeQueryOutputsForGroup converts the heaps for a complete group into output points for each series in the group.
+func (t *TopKBottomK) computeRangeQueryOutputsForGroup(g *topKBottomKGroup) error {
+	for stepIdx, h := range g.heaps {
+		if h == nil {
+			continue
+		}
+
+		ts := t.Start + int64(stepIdx)*t.Interval
+
+		for _, e := range h.entries {
+			points, exists := t.pendingOutputs[e.seriesIdx]
+
+			if !exists {
+				var err error
+				points, err = GetFPointSlice(len(g.heaps)-stepIdx, t.MemoryConsumptionTracker)
+				if err != nil {
+					return err
+				}
+			}
+
+			t.pendingOutputs[e.seriesIdx] = append(points, promql.FPoint{T: ts, F: e.value})
+		}
+	}
+
+	g.heaps = nil
+	return nil
+}
+
+func (t *TopKBottomK) BufferedPoints() int {
+	points := len(t.instantQueryResults) - t.nextOutputSeriesIdx
+
+	for _, p := range t.pendingOutputs {
+		points += len(p)
+	}
+
+	return max(points, 0)
+}
+
+func (t *TopKBottomK) Close() {
+	t.Inner.Close()
+	t.Param.Close()
+
+	if t.instantQueryResults != nil {
+		PutFPointSlice(t.instantQueryResults, t.MemoryConsumptionTracker)
+		t.instantQueryResults = nil
+	}
+
+	for _, points := range t.pendingOutputs {
+		PutFPointSlice(points, t.MemoryConsumptionTracker)
+	}
+
+	t.pendingOutputs = nil
+}
+
+type topKBottomKHeapEntry struct {
+	seriesIdx int
+	value     float64
+}
+
+// topKBottomKHeap retains the k largest (for topk) or smallest (for bottomk) values seen at a single step.
+// The value that would be discarded next is at the top of the heap. Like Prometheus' engine, NaN values are always
+// discarded before any other value.
+type topKBottomKHeap struct {
+	entries   []topKBottomKHeapEntry
+	isBottomK bool
+}
+
+var _ heap.Interface = &topKBottomKHeap{}
+
+func (h *topKBottomKHeap) Len() int {
+	return len(h.entries)
+}
+
+func (h *topKBottomKHeap) Less(i, j int) bool {
+	if math.IsNaN(h.entries[i].value) {
+		return true
+	}
+
+	if h.isBottomK {
+		return h.entries[i].value > h.entries[j].value
+	}
+
+	return h.entries[i].value < h.entries[j].value
+}
+
+func (h *topKBottomKHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+}
+
+func (h *topKBottomKHeap) Push(x any) {
+	h.entries = append(h.entries, x.(topKBottomKHeapEntry))
+}
+
+func (h *topKBottomKHeap) Pop() any {
+	last := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+	return last
+}
+
+// accumulate adds e to this heap if it is one of the k largest (for topk) or smallest (for bottomk) values seen so far.
+func (h *topKBottomKHeap) accumulate(e topKBottomKHeapEntry, k int) {
+	if len(h.entries) < k {
+		heap.Push(h, e)
+		return
+	}
+
+	top := h.entries[0].value
+	shouldReplace := (math.IsNaN(top) && !math.IsNaN(e.value)) ||
+		(!h.isBottomK && top < e.value) ||
+		(h.isBottomK && top > e.value)
+
+	if !shouldReplace {
+		return
+	}
+
+	h.entries[0] = e
+
+	if k > 1 {
+		heap.Fix(h, 0) // Maintain the heap invariant.
+	}
+}
+
+// Copied from Prometheus' engine.
+const (
+	maxInt64 = 9223372036854774784
+	minInt64 = -9223372036854775808
+)
+
+func convertibleToInt64(v float64) bool {
+	return v <= maxInt64 && v >= minInt64
+}
@@ -0,0 +1,177 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// Provenance-includes-location: https://github.com/prometheus/prometheus/blob/main/promql/engine.go
+// Provenance-includes-license: Apache-2.0
+// Provenance-includes-copyright: The Prometheus Authors
+
+package operator
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
+)
+
+// VectorScalarBinaryOperation represents a binary operation between an instant vector and a scalar,
+// such as "<expr> + 2" or "3 * <expr>".
+//
+// If the operation drops the metric name, the output may contain multiple series with the same labels,
+// so this operator should be wrapped in a DeduplicateAndMerge.
+type VectorScalarBinaryOperation struct {
+	Scalar           ScalarOperator
+	Vector           InstantVectorOperator
+	ScalarIsLeftSide bool
+	Op               parser.ItemType
+	ReturnBool       bool
+
+	Start    int64 // Milliseconds since Unix epoch
+	End      int64 // Milliseconds since Unix epoch
+	Interval int64 // In milliseconds
+
+	MemoryConsumptionTracker *limiting.MemoryConsumptionTracker
+
+	opFunc binaryOperationFunc
+
+	// We evaluate the scalar side once, the first time it's needed, and then use these values for every series.
+	scalarData *ScalarData
+}
+
+var _ InstantVectorOperator = &VectorScalarBinaryOperation{}
+
+func (v *VectorScalarBinaryOperation) SeriesMetadata(ctx context.Context) ([]SeriesMetadata, error) {
+	var ok bool
+	v.opFunc, ok = arithmeticAndComparisonOperationFuncs[v.Op]
+	if !ok {
+		return nil, fmt.Errorf("unsupported binary operation '%s'", v.Op)
+	}
+
+	metadata, err := v.Vector.SeriesMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if shouldDropMetricName(v.Op) || v.ReturnBool {
+		lb := labels.NewBuilder(labels.EmptyLabels())
+
+		for i := range metadata {
+			metadata[i].Labels = dropMetricName(metadata[i].Labels, lb)
+		}
+	}
+
+	return metadata, nil
+}
+
+func (v *VectorScalarBinaryOperation) Next(ctx context.Context) (InstantVectorSeriesData, error) {
+	if v.scalarData == nil {
+		d, err := v.Scalar.GetValues(ctx)
+		if err != nil {
+			return InstantVectorSeriesData{}, err
+		}
+
+		v.scalarData = &d
+	}
+
+	series, err := v.Vector.Next(ctx)
+	if err != nil {
+		return InstantVectorSeriesData{}, err
+	}
+
+	isComparison := v.Op.IsComparisonOperator()
+	filtered := series.Floats[:0]
+
+	for _, p := range series.Floats {
+		f, _, keep := v.computeValue(p.T, p.F, nil, isComparison)
+		if !keep {
+			continue
+		}
+
+		p.F = f
+		filtered = append(filtered, p)
+	}
+
+	// Operations on histograms may return a float (eg. comparisons), so the float points computed from histograms
+	// are appended to the float points, and the float points are sorted by timestamp if needed.
+	filteredHistograms := series.Histograms[:0]
+	floatsFromHistograms := false
+
+	for _, p := range series.Histograms {
+		f, h, keep := v.computeValue(p.T, 0, p.H, isComparison)
+		if !keep {
+			continue
+		}
+
+		if h != nil {
+			p.H = h
+			filteredHistograms = append(filteredHistograms, p)
+			continue
+		}
+
+		if filtered == nil {
+			var err error
+			if filtered, err = GetFPointSlice(len(series.Histograms), v.MemoryConsumptionTracker); err != nil {
+				PutHPointSlice(series.Histograms, v.MemoryConsumptionTracker)
+				return InstantVectorSeriesData{}, err
+			}
+		}
+
+		filtered = append(filtered, promql.FPoint{T: p.T, F: f})
+		floatsFromHistograms = true
+	}
+
+	if floatsFromHistograms {
+		slices.SortFunc(filtered, func(a, b promql.FPoint) int { return cmp.Compare(a.T, b.T) })
+	}
+
+	series.Floats = filtered
+	series.Histograms = filteredHistograms
+
+	return series, nil
+}
+
+// computeValue computes the result of the operation for the point of the vector side at timestamp t, which has either
+// the float value f or the histogram h.
+//
+// This is based on VectorscalarBinop from Prometheus' engine.
+func (v *VectorScalarBinaryOperation) computeValue(t int64, f float64, h *histogram.FloatHistogram, isComparison bool) (float64, *histogram.FloatHistogram, bool) {
+	// Scalars always have a value at every step, so we can find the corresponding scalar value by its step index.
+	scalarValue := v.scalarData.Samples[(t-v.Start)/v.Interval].F
+	left, right := f, scalarValue
+	var leftH, rightH *histogram.FloatHistogram = h, nil
+
+	if v.ScalarIsLeftSide {
+		left, right = right, left
+		leftH, rightH = rightH, leftH
+	}
+
+	resultF, resultH, keep := vectorElemBinop(v.Op, v.opFunc, left, right, leftH, rightH)
+
+	if isComparison && !v.ReturnBool {
+		// Comparisons without 'bool' always return the value from the vector side, even if the vector is on the right side.
+		if v.ScalarIsLeftSide {
+			resultF, resultH = f, h
+		}
+	}
+
+	if v.ReturnBool {
+		return btos(keep), nil, true
+	}
+
+	return resultF, resultH, keep
+}
+
+func (v *VectorScalarBinaryOperation) Close() {
+	v.Scalar.Close()
+	v.Vector.Close()
+
+	if v.scalarData != nil {
+		PutFPointSlice(v.scalarData.Samples, v.MemoryConsumptionTracker)
+		v.scalarData = nil
+	}
+}
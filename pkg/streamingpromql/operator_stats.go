@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package streamingpromql
+
+import (
+	"github.com/grafana/mimir/pkg/querier/stats"
+	"github.com/grafana/mimir/pkg/streamingpromql/operator"
+)
+
+// operatorStats returns the statistics recorded for each operator of this query, in the order the operators appear in
+// the query plan.
+//
+// The operators of a common subexpression are only included once, under the first of its consumers, as they are only
+// evaluated once.
+func (q *Query) operatorStats() []stats.OperatorStats {
+	return appendOperatorStats(nil, describeOperator(q.root), 0, map[*operator.OperatorStats]struct{}{})
+}
+
+func appendOperatorStats(s []stats.OperatorStats, n *PlanNode, depth int, seen map[*operator.OperatorStats]struct{}) []stats.OperatorStats {
+	operatorStats := n.stats
+	if operatorStats == nil {
+		// All operators should be instrumented, but if one isn't, report it without any statistics.
+		operatorStats = &operator.OperatorStats{}
+	} else if _, isSeen := seen[operatorStats]; isSeen {
+		return s
+	}
+
+	seen[operatorStats] = struct{}{}
+
+	seriesIn := 0
+	for _, c := range n.Children {
+		if c.stats != nil {
+			seriesIn += c.stats.SeriesOut
+		}
+	}
+
+	s = append(s, stats.OperatorStats{
+		Operator:           n.description(),
+		Depth:              uint32(depth),
+		SeriesIn:           uint64(seriesIn),
+		SeriesOut:          uint64(operatorStats.SeriesOut),
+		SamplesProcessed:   uint64(operatorStats.SamplesProcessed),
+		WallTime:           operatorStats.WallTime,
+		PeakBufferedPoints: uint64(operatorStats.PeakBufferedPoints),
+	})
+
+	for _, c := range n.Children {
+		s = appendOperatorStats(s, c, depth+1, seen)
+	}
+
+	return s
+}
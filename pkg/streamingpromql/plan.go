@@ -0,0 +1,437 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package streamingpromql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/grafana/mimir/pkg/streamingpromql/operator"
+)
+
+// Explainer is implemented by engines that can describe how they would evaluate a query, without evaluating it.
+type Explainer interface {
+	ExplainInstantQuery(ctx context.Context, qs string, ts time.Time) (*QueryPlan, error)
+	ExplainRangeQuery(ctx context.Context, qs string, start, end time.Time, interval time.Duration) (*QueryPlan, error)
+}
+
+// QueryPlan describes the operators the streaming engine would use to evaluate a query.
+type QueryPlan struct {
+	Query     string `json:"query"`
+	Supported bool   `json:"supported"`
+
+	// NotSupportedReason is the reason the streaming engine does not support the query, if Supported is false.
+	NotSupportedReason string `json:"notSupportedReason,omitempty"`
+
+	// Root is the root of the operator tree, if Supported is true.
+	Root *PlanNode `json:"root,omitempty"`
+}
+
+// PlanNode describes a single operator in a QueryPlan.
+//
+// Only the fields relevant to the type of operator are populated.
+type PlanNode struct {
+	Type      string         `json:"type"`
+	Function  string         `json:"function,omitempty"`
+	Operation string         `json:"operation,omitempty"`
+	Matchers  []string       `json:"matchers,omitempty"`
+	Grouping  []string       `json:"grouping,omitempty"`
+	Without   bool           `json:"without,omitempty"`
+	Label     string         `json:"label,omitempty"`
+	Value     string         `json:"value,omitempty"`
+	Range     string         `json:"range,omitempty"`
+	Offset    string         `json:"offset,omitempty"`
+	Timestamp *time.Time     `json:"timestamp,omitempty"` // Only set if the selector or subquery uses the @ modifier.
+	TimeRange *PlanTimeRange `json:"timeRange,omitempty"`
+	Children  []*PlanNode    `json:"children,omitempty"`
+
+	stats *operator.OperatorStats // Only set if the operator is instrumented.
+}
+
+// PlanTimeRange describes the steps at which an operator is evaluated.
+type PlanTimeRange struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Interval string    `json:"interval,omitempty"` // Not set for instant queries.
+}
+
+func (e *Engine) ExplainInstantQuery(ctx context.Context, qs string, ts time.Time) (*QueryPlan, error) {
+	q, err := e.NewInstantQuery(ctx, nil, nil, qs, ts)
+	return newQueryPlan(qs, q, err)
+}
+
+func (e *Engine) ExplainRangeQuery(ctx context.Context, qs string, start, end time.Time, interval time.Duration) (*QueryPlan, error) {
+	q, err := e.NewRangeQuery(ctx, nil, nil, qs, start, end, interval)
+	return newQueryPlan(qs, q, err)
+}
+
+func newQueryPlan(qs string, q promql.Query, err error) (*QueryPlan, error) {
+	if err != nil {
+		notSupportedErr := NotSupportedError{}
+		if !errors.As(err, &notSupportedErr) {
+			return nil, err
+		}
+
+		return &QueryPlan{Query: qs, NotSupportedReason: notSupportedErr.reason}, nil
+	}
+
+	defer q.Close()
+
+	return &QueryPlan{
+		Query:     qs,
+		Supported: true,
+		Root:      describeOperator(q.(*Query).root),
+	}, nil
+}
+
+func (e EngineWithFallback) ExplainInstantQuery(ctx context.Context, qs string, ts time.Time) (*QueryPlan, error) {
+	explainer, ok := e.preferred.(Explainer)
+	if !ok {
+		return nil, fmt.Errorf("preferred engine %T does not support query plans", e.preferred)
+	}
+
+	return explainer.ExplainInstantQuery(ctx, qs, ts)
+}
+
+func (e EngineWithFallback) ExplainRangeQuery(ctx context.Context, qs string, start, end time.Time, interval time.Duration) (*QueryPlan, error) {
+	explainer, ok := e.preferred.(Explainer)
+	if !ok {
+		return nil, fmt.Errorf("preferred engine %T does not support query plans", e.preferred)
+	}
+
+	return explainer.ExplainRangeQuery(ctx, qs, start, end, interval)
+}
+
+// describeOperator returns a PlanNode describing o and all of its children.
+func describeOperator(o operator.Operator) *PlanNode {
+	switch o := o.(type) {
+	case *operator.InstrumentedInstantVectorOperator:
+		return describeInstrumentedOperator(o.Inner, o.Stats)
+	case *operator.InstrumentedRangeVectorOperator:
+		return describeInstrumentedOperator(o.Inner, o.Stats)
+	case *operator.InstrumentedScalarOperator:
+		return describeInstrumentedOperator(o.Inner, o.Stats)
+	}
+
+	n := &PlanNode{Type: strings.TrimPrefix(fmt.Sprintf("%T", o), "*operator.")}
+
+	switch o := o.(type) {
+	case *operator.InstantVectorSelector:
+		describeSelector(n, o.Selector)
+	case *operator.RangeVectorSelector:
+		describeSelector(n, o.Selector)
+	case *operator.Subquery:
+		n.Range = formatDuration(o.SubqueryRange)
+		n.Offset = formatDuration(time.Duration(o.Offset) * time.Millisecond)
+		n.Timestamp = planTimestamp(o.Timestamp)
+		n.TimeRange = planTimeRange(o.ParentStart, o.ParentEnd, o.ParentInterval)
+		n.Children = describeOperators(o.Inner)
+	case *operator.Aggregation:
+		n.Operation = o.Op.String()
+		n.Grouping = o.Grouping
+		n.Without = o.Without
+		n.TimeRange = planTimeRange(timestamp.FromTime(o.Start), timestamp.FromTime(o.End), o.Interval.Milliseconds())
+		n.Children = describeOperators(o.Param, o.Inner)
+	case *operator.TopKBottomK:
+		n.Operation = parser.ItemType(parser.TOPK).String()
+		if o.IsBottomK {
+			n.Operation = parser.ItemType(parser.BOTTOMK).String()
+		}
+		n.Grouping = o.Grouping
+		n.Without = o.Without
+		n.TimeRange = planTimeRange(o.Start, o.End, o.Interval)
+		n.Children = describeOperators(o.Param, o.Inner)
+	case *operator.CountValues:
+		n.Operation = parser.ItemType(parser.COUNT_VALUES).String()
+		n.Label = o.LabelName
+		n.Grouping = o.Grouping
+		n.Without = o.Without
+		n.TimeRange = planTimeRange(o.Start, o.End, o.Interval)
+		n.Children = describeOperators(o.Inner)
+	case *operator.BinaryOperation:
+		n.Operation = describeBinaryOperation(o.Op, o.ReturnBool, &o.VectorMatching)
+		n.TimeRange = planTimeRange(o.Start, o.End, o.Interval)
+		n.Children = describeOperators(o.Left, o.Right)
+	case *operator.AndUnlessBinaryOperation:
+		op := parser.ItemType(parser.LAND)
+		if o.IsUnless {
+			op = parser.LUNLESS
+		}
+		n.Operation = describeBinaryOperation(op, false, &o.VectorMatching)
+		n.TimeRange = planTimeRange(o.Start, o.End, o.Interval)
+		n.Children = describeOperators(o.Left, o.Right)
+	case *operator.OrBinaryOperation:
+		n.Operation = describeBinaryOperation(parser.LOR, false, &o.VectorMatching)
+		n.TimeRange = planTimeRange(o.Start, o.End, o.Interval)
+		n.Children = describeOperators(o.Left, o.Right)
+	case *operator.VectorScalarBinaryOperation:
+		n.Operation = describeBinaryOperation(o.Op, o.ReturnBool, nil)
+		n.TimeRange = planTimeRange(o.Start, o.End, o.Interval)
+		if o.ScalarIsLeftSide {
+			n.Children = describeOperators(o.Scalar, o.Vector)
+		} else {
+			n.Children = describeOperators(o.Vector, o.Scalar)
+		}
+	case *operator.ScalarScalarBinaryOperation:
+		n.Operation = describeBinaryOperation(o.Op, false, nil)
+		n.Children = describeOperators(o.Left, o.Right)
+	case *operator.FunctionOverInstantVector:
+		n.Function = o.FunctionName
+		n.TimeRange = planTimeRange(o.Start, o.End, o.Interval)
+		n.Children = describeOperators(o.Inner)
+		n.Children = append(n.Children, describeOperators(scalarOperatorsToOperators(o.Args)...)...)
+	case *operator.FunctionOverRangeVector:
+		n.Function = o.FunctionName
+		n.Children = describeOperators(o.Inner)
+		n.Children = append(n.Children, describeOperators(scalarOperatorsToOperators(o.Args)...)...)
+	case *operator.HistogramQuantile:
+		n.Function = "histogram_quantile"
+		n.TimeRange = planTimeRange(o.Start, o.End, o.Interval)
+		n.Children = describeOperators(o.Phi, o.Inner)
+	case *operator.Absent:
+		n.Function = "absent"
+		n.TimeRange = planTimeRange(o.Start, o.End, o.Interval)
+		n.Children = describeOperators(o.Inner)
+	case *operator.Sort:
+		n.Function = "sort"
+		if o.Descending {
+			n.Function = "sort_desc"
+		}
+		n.Children = describeOperators(o.Inner)
+	case *operator.LabelReplace:
+		n.Function = "label_replace"
+		n.Label = o.DestinationLabel
+		n.Children = describeOperators(o.Inner)
+	case *operator.LabelJoin:
+		n.Function = "label_join"
+		n.Label = o.DestinationLabel
+		n.Children = describeOperators(o.Inner)
+	case *operator.DeduplicateAndMerge:
+		n.Children = describeOperators(o.Inner)
+	case *operator.InstantVectorDuplicationConsumer:
+		// The shared expression is described under each of its consumers, but is only evaluated once.
+		n.Children = describeOperators(o.Buffer.Inner)
+	case *operator.ScalarToInstantVector:
+		n.Function = "vector"
+		n.Children = describeOperators(o.Scalar)
+	case *operator.InstantVectorToScalar:
+		n.Function = "scalar"
+		n.TimeRange = planTimeRange(o.Start, o.End, o.Interval)
+		n.Children = describeOperators(o.Inner)
+	case *operator.ScalarConstant:
+		n.Value = strconv.FormatFloat(o.Value, 'g', -1, 64)
+		n.TimeRange = planTimeRange(o.Start, o.End, o.Interval)
+	case *operator.Time:
+		n.Function = "time"
+		n.TimeRange = planTimeRange(o.Start, o.End, o.Interval)
+	}
+
+	return n
+}
+
+func describeInstrumentedOperator(o operator.Operator, stats *operator.OperatorStats) *PlanNode {
+	n := describeOperator(o)
+	n.stats = stats
+	return n
+}
+
+func describeOperators(operators ...operator.Operator) []*PlanNode {
+	nodes := make([]*PlanNode, 0, len(operators))
+
+	for _, o := range operators {
+		if o == nil {
+			continue
+		}
+
+		nodes = append(nodes, describeOperator(o))
+	}
+
+	return nodes
+}
+
+func scalarOperatorsToOperators(scalars []operator.ScalarOperator) []operator.Operator {
+	operators := make([]operator.Operator, 0, len(scalars))
+
+	for _, s := range scalars {
+		operators = append(operators, s)
+	}
+
+	return operators
+}
+
+func describeSelector(n *PlanNode, s *operator.Selector) {
+	n.Matchers = make([]string, 0, len(s.Matchers))
+	for _, m := range s.Matchers {
+		n.Matchers = append(n.Matchers, m.String())
+	}
+
+	n.Range = formatDuration(s.Range)
+	n.Offset = formatDuration(time.Duration(s.Offset) * time.Millisecond)
+	n.Timestamp = planTimestamp(s.Timestamp)
+	n.TimeRange = planTimeRange(s.Start, s.End, s.Interval)
+}
+
+func describeBinaryOperation(op parser.ItemType, returnBool bool, matching *parser.VectorMatching) string {
+	desc := op.String()
+
+	if returnBool {
+		desc += " bool"
+	}
+
+	if matching == nil {
+		return desc
+	}
+
+	if matching.On || len(matching.MatchingLabels) > 0 {
+		desc += " " + matchingDescription(matching.On, matching.MatchingLabels)
+	}
+
+	switch matching.Card {
+	case parser.CardManyToOne:
+		desc += " group_left (" + strings.Join(matching.Include, ", ") + ")"
+	case parser.CardOneToMany:
+		desc += " group_right (" + strings.Join(matching.Include, ", ") + ")"
+	}
+
+	return desc
+}
+
+func matchingDescription(on bool, labels []string) string {
+	if on {
+		return "on (" + strings.Join(labels, ", ") + ")"
+	}
+
+	return "ignoring (" + strings.Join(labels, ", ") + ")"
+}
+
+// formatDuration returns d in the same format as durations in PromQL expressions, or an empty string if d is 0.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d == 0:
+		return ""
+	case d < 0:
+		return "-" + model.Duration(-d).String()
+	default:
+		return model.Duration(d).String()
+	}
+}
+
+func planTimestamp(ts *int64) *time.Time {
+	if ts == nil {
+		return nil
+	}
+
+	t := timestamp.Time(*ts)
+	return &t
+}
+
+func planTimeRange(start, end, interval int64) *PlanTimeRange {
+	tr := &PlanTimeRange{
+		Start: timestamp.Time(start),
+		End:   timestamp.Time(end),
+	}
+
+	if start != end {
+		// Instant queries are evaluated with an interval of 1ms, which is an implementation detail not worth showing.
+		tr.Interval = formatDuration(time.Duration(interval) * time.Millisecond)
+	}
+
+	return tr
+}
+
+// String returns a human-readable rendering of this plan, with one line per operator.
+func (p *QueryPlan) String() string {
+	b := &strings.Builder{}
+
+	if !p.Supported {
+		fmt.Fprintf(b, "%v\n", NewNotSupportedError(p.NotSupportedReason))
+		return b.String()
+	}
+
+	p.Root.write(b, 0)
+	return b.String()
+}
+
+func (n *PlanNode) write(b *strings.Builder, depth int) {
+	b.WriteString(strings.Repeat("  ", depth))
+	b.WriteString("- ")
+	b.WriteString(n.description())
+	b.WriteString("\n")
+
+	for _, c := range n.Children {
+		c.write(b, depth+1)
+	}
+}
+
+// description returns a single line describing this node, without its children.
+func (n *PlanNode) description() string {
+	details := n.details()
+	if len(details) == 0 {
+		return n.Type
+	}
+
+	return n.Type + ": " + strings.Join(details, ", ")
+}
+
+func (n *PlanNode) details() []string {
+	var details []string
+
+	if n.Function != "" {
+		details = append(details, "function="+n.Function)
+	}
+
+	if n.Operation != "" {
+		details = append(details, "operation="+n.Operation)
+	}
+
+	if n.Matchers != nil {
+		details = append(details, "matchers={"+strings.Join(n.Matchers, ", ")+"}")
+	}
+
+	if n.Grouping != nil || n.Without {
+		if n.Without {
+			details = append(details, "without ("+strings.Join(n.Grouping, ", ")+")")
+		} else {
+			details = append(details, "by ("+strings.Join(n.Grouping, ", ")+")")
+		}
+	}
+
+	if n.Label != "" {
+		details = append(details, "label="+n.Label)
+	}
+
+	if n.Value != "" {
+		details = append(details, "value="+n.Value)
+	}
+
+	if n.Range != "" {
+		details = append(details, "range="+n.Range)
+	}
+
+	if n.Offset != "" {
+		details = append(details, "offset="+n.Offset)
+	}
+
+	if n.Timestamp != nil {
+		details = append(details, "@ "+n.Timestamp.UTC().Format(time.RFC3339Nano))
+	}
+
+	if n.TimeRange != nil {
+		if n.TimeRange.Interval == "" {
+			details = append(details, "at "+n.TimeRange.Start.UTC().Format(time.RFC3339Nano))
+		} else {
+			details = append(details, fmt.Sprintf("from %v to %v every %v", n.TimeRange.Start.UTC().Format(time.RFC3339Nano), n.TimeRange.End.UTC().Format(time.RFC3339Nano), n.TimeRange.Interval))
+		}
+	}
+
+	return details
+}
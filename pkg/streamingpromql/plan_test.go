@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package streamingpromql
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/mimir/pkg/querier/stats"
+)
+
+func TestExplainInstantQuery(t *testing.T) {
+	engine, err := NewEngine(NewTestEngineOpts(), NewStaticQueryLimitsProvider(0), stats.NewQueryMetrics(nil))
+	require.NoError(t, err)
+
+	ts := timestamp.Time(0).Add(time.Hour)
+
+	testCases := map[string]struct {
+		expr         string
+		expectedText string
+	}{
+		"vector selector": {
+			expr: `some_metric{env="prod"}`,
+			expectedText: `- InstantVectorSelector: matchers={env="prod", __name__="some_metric"}, at 1970-01-01T01:00:00Z
+`,
+		},
+		"aggregation over range vector function": {
+			expr: `sum by (env) (rate(some_metric[5m] offset 1m))`,
+			expectedText: `- Aggregation: operation=sum, by (env), at 1970-01-01T01:00:00Z
+  - DeduplicateAndMerge
+    - FunctionOverRangeVector: function=rate
+      - RangeVectorSelector: matchers={__name__="some_metric"}, range=5m, offset=1m, at 1970-01-01T01:00:00Z
+`,
+		},
+		"binary operation with vector matching": {
+			expr: `a / on (env) group_left (team) b`,
+			expectedText: `- BinaryOperation: operation=/ on (env) group_left (team), at 1970-01-01T01:00:00Z
+  - InstantVectorSelector: matchers={__name__="a"}, at 1970-01-01T01:00:00Z
+  - InstantVectorSelector: matchers={__name__="b"}, at 1970-01-01T01:00:00Z
+`,
+		},
+		"common subexpression": {
+			expr: `a / (a + b)`,
+			expectedText: `- BinaryOperation: operation=/, at 1970-01-01T01:00:00Z
+  - InstantVectorDuplicationConsumer
+    - InstantVectorSelector: matchers={__name__="a"}, at 1970-01-01T01:00:00Z
+  - BinaryOperation: operation=+, at 1970-01-01T01:00:00Z
+    - InstantVectorDuplicationConsumer
+      - InstantVectorSelector: matchers={__name__="a"}, at 1970-01-01T01:00:00Z
+    - InstantVectorSelector: matchers={__name__="b"}, at 1970-01-01T01:00:00Z
+`,
+		},
+		"function with scalar argument": {
+			expr: `topk(3, clamp_min(some_metric, 0))`,
+			expectedText: `- TopKBottomK: operation=topk, at 1970-01-01T01:00:00Z
+  - ScalarConstant: value=3, at 1970-01-01T01:00:00Z
+  - DeduplicateAndMerge
+    - FunctionOverInstantVector: function=clamp_min, at 1970-01-01T01:00:00Z
+      - InstantVectorSelector: matchers={__name__="some_metric"}, at 1970-01-01T01:00:00Z
+      - ScalarConstant: value=0, at 1970-01-01T01:00:00Z
+`,
+		},
+		"subquery with @ modifier": {
+			expr: `max_over_time(some_metric[10m:5m] @ 600)`,
+			expectedText: `- DeduplicateAndMerge
+  - FunctionOverRangeVector: function=max_over_time
+    - Subquery: range=10m, @ 1970-01-01T00:10:00Z, at 1970-01-01T01:00:00Z
+      - InstantVectorSelector: matchers={__name__="some_metric"}, from 1970-01-01T00:00:00Z to 1970-01-01T00:10:00Z every 5m
+`,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			plan, err := engine.(Explainer).ExplainInstantQuery(context.Background(), testCase.expr, ts)
+			require.NoError(t, err)
+			require.True(t, plan.Supported)
+			require.Equal(t, testCase.expectedText, plan.String())
+		})
+	}
+}
+
+func TestExplainRangeQuery(t *testing.T) {
+	engine, err := NewEngine(NewTestEngineOpts(), NewStaticQueryLimitsProvider(0), stats.NewQueryMetrics(nil))
+	require.NoError(t, err)
+
+	start := timestamp.Time(0)
+	end := start.Add(10 * time.Minute)
+	plan, err := engine.(Explainer).ExplainRangeQuery(context.Background(), `count_values without (pod) ("value", some_metric)`, start, end, time.Minute)
+	require.NoError(t, err)
+
+	require.Equal(t, `- CountValues: operation=count_values, without (pod), label=value, from 1970-01-01T00:00:00Z to 1970-01-01T00:10:00Z every 1m
+  - InstantVectorSelector: matchers={__name__="some_metric"}, from 1970-01-01T00:00:00Z to 1970-01-01T00:10:00Z every 1m
+`, plan.String())
+
+	actualJSON, err := json.Marshal(plan)
+	require.NoError(t, err)
+
+	expectedJSON := `{
+		"query": "count_values without (pod) (\"value\", some_metric)",
+		"supported": true,
+		"root": {
+			"type": "CountValues",
+			"operation": "count_values",
+			"grouping": ["pod"],
+			"without": true,
+			"label": "value",
+			"timeRange": {"start": "1970-01-01T00:00:00Z", "end": "1970-01-01T00:10:00Z", "interval": "1m"},
+			"children": [
+				{
+					"type": "InstantVectorSelector",
+					"matchers": ["__name__=\"some_metric\""],
+					"timeRange": {"start": "1970-01-01T00:00:00Z", "end": "1970-01-01T00:10:00Z", "interval": "1m"}
+				}
+			]
+		}
+	}`
+	require.JSONEq(t, expectedJSON, string(actualJSON))
+}
+
+func TestExplainUnsupportedQuery(t *testing.T) {
+	engine, err := NewEngine(NewTestEngineOpts(), NewStaticQueryLimitsProvider(0), stats.NewQueryMetrics(nil))
+	require.NoError(t, err)
+
+	plan, err := engine.(Explainer).ExplainInstantQuery(context.Background(), `histogram_stddev(some_metric)`, timestamp.Time(0))
+	require.NoError(t, err)
+	require.Equal(t, &QueryPlan{Query: `histogram_stddev(some_metric)`, NotSupportedReason: "'histogram_stddev' function"}, plan)
+	require.Equal(t, "not supported by streaming engine: 'histogram_stddev' function\n", plan.String())
+
+	// Invalid expressions should return an error, rather than a plan.
+	_, err = engine.(Explainer).ExplainInstantQuery(context.Background(), `sum(`, timestamp.Time(0))
+	require.Error(t, err)
+}
@@ -9,15 +9,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"time"
 
+	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/timestamp"
 	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/promql/parser"
 	"github.com/prometheus/prometheus/storage"
-	"github.com/prometheus/prometheus/util/stats"
+	promstats "github.com/prometheus/prometheus/util/stats"
 	"golang.org/x/exp/slices"
 
+	"github.com/grafana/mimir/pkg/querier/stats"
+	"github.com/grafana/mimir/pkg/streamingpromql/limiting"
 	"github.com/grafana/mimir/pkg/streamingpromql/operator"
 )
 
@@ -25,18 +29,35 @@ type Query struct {
 	queryable storage.Queryable
 	opts      promql.QueryOpts
 	statement *parser.EvalStmt
-	root      operator.InstantVectorOperator
+	root      operator.Operator
 	engine    *Engine
 	qs        string
 
+	memoryConsumptionTracker *limiting.MemoryConsumptionTracker
+
+	// subexpressionCounts contains the number of times each instant vector expression would be evaluated if common
+	// subexpressions were not shared, and duplicationBuffers contains the shared operator for each expression evaluated
+	// more than once.
+	subexpressionCounts map[subexpressionKey]int
+	duplicationBuffers  map[subexpressionKey]*operator.InstantVectorDuplicationBuffer
+
+	// statsRecorder is only set if query statistics are enabled, in which case every operator is instrumented to
+	// record statistics about its evaluation.
+	statsRecorder *operator.StatsRecorder
+
 	result *promql.Result
 }
 
-func newQuery(queryable storage.Queryable, opts promql.QueryOpts, qs string, start, end time.Time, interval time.Duration, engine *Engine) (*Query, error) {
+func newQuery(ctx context.Context, queryable storage.Queryable, opts promql.QueryOpts, qs string, start, end time.Time, interval time.Duration, engine *Engine) (*Query, error) {
 	if opts == nil {
 		opts = promql.NewPrometheusQueryOpts(false, 0)
 	}
 
+	maxEstimatedMemoryConsumptionPerQuery, err := engine.limitsProvider.GetMaxEstimatedMemoryConsumptionPerQuery(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get memory consumption limit for query: %w", err)
+	}
+
 	expr, err := parser.ParseExpr(qs)
 	if err != nil {
 		return nil, err
@@ -45,10 +66,11 @@ func newQuery(queryable storage.Queryable, opts promql.QueryOpts, qs string, sta
 	expr = promql.PreprocessExpr(expr, start, end)
 
 	q := &Query{
-		queryable: queryable,
-		opts:      opts,
-		engine:    engine,
-		qs:        qs,
+		queryable:                queryable,
+		opts:                     opts,
+		engine:                   engine,
+		qs:                       qs,
+		memoryConsumptionTracker: limiting.NewMemoryConsumptionTracker(maxEstimatedMemoryConsumptionPerQuery, engine.queriesRejectedDueToMemoryConsumption),
 		statement: &parser.EvalStmt{
 			Expr:          expr,
 			Start:         start,
@@ -56,6 +78,8 @@ func newQuery(queryable storage.Queryable, opts promql.QueryOpts, qs string, sta
 			Interval:      interval,
 			LookbackDelta: opts.LookbackDelta(),
 		},
+		subexpressionCounts: map[subexpressionKey]int{},
+		duplicationBuffers:  map[subexpressionKey]*operator.InstantVectorDuplicationBuffer{},
 	}
 
 	if !q.IsInstant() {
@@ -64,7 +88,13 @@ func newQuery(queryable storage.Queryable, opts promql.QueryOpts, qs string, sta
 		}
 	}
 
-	q.root, err = q.convertToOperator(expr)
+	if stats.IsEnabled(ctx) {
+		q.statsRecorder = &operator.StatsRecorder{}
+	}
+
+	q.findCommonSubexpressions(expr, q.queryTimeRange())
+
+	q.root, err = q.convertToOperator(expr, q.queryTimeRange())
 	if err != nil {
 		return nil, err
 	}
@@ -72,106 +102,850 @@ func newQuery(queryable storage.Queryable, opts promql.QueryOpts, qs string, sta
 	return q, nil
 }
 
-func (q *Query) convertToOperator(expr parser.Expr) (operator.InstantVectorOperator, error) {
-	interval := q.statement.Interval
+func (q *Query) convertToOperator(expr parser.Expr, tr timeRange) (operator.Operator, error) {
+	switch expr.Type() {
+	case parser.ValueTypeScalar:
+		return q.convertToScalarOperator(expr, tr)
+	default:
+		return q.convertToInstantVectorOperator(expr, tr)
+	}
+}
 
-	if q.IsInstant() {
-		interval = time.Millisecond
+// subexpressionKey identifies an instant vector expression evaluated over a particular time range.
+//
+// The same expression may be evaluated over different time ranges in the same query (eg. both inside and outside a
+// subquery), and can only be shared if the time ranges are the same.
+type subexpressionKey struct {
+	expr string
+	tr   timeRange
+}
+
+// findCommonSubexpressions populates subexpressionCounts with the number of times each instant vector expression in
+// expr would be evaluated by convertToInstantVectorOperator.
+//
+// Children of an expression that has already been seen are not visited again, as they will only be evaluated once
+// if the expression is shared. For example, in sum(rate(x[5m])) / sum(rate(x[5m])), sum(rate(x[5m])) is shared and
+// so rate(x[5m]) is only evaluated once.
+func (q *Query) findCommonSubexpressions(expr parser.Expr, tr timeRange) {
+	expr = unwrapParenAndStepInvariantExpr(expr)
+
+	if expr.Type() == parser.ValueTypeVector {
+		key := subexpressionKey{expr: expr.String(), tr: tr}
+		q.subexpressionCounts[key]++
+
+		if q.subexpressionCounts[key] > 1 {
+			return
+		}
 	}
 
 	switch e := expr.(type) {
-	case *parser.VectorSelector:
-		lookbackDelta := q.opts.LookbackDelta()
-		if lookbackDelta == 0 {
-			lookbackDelta = q.engine.lookbackDelta
+	case *parser.MatrixSelector:
+		// The vector selector inside a range vector selector is not evaluated as an instant vector.
+		return
+	case *parser.SubqueryExpr:
+		q.findCommonSubexpressions(e.Expr, q.subqueryTimeRange(e, tr))
+		return
+	case *parser.Call:
+		if e.Func.Name == "timestamp" {
+			if _, ok := unwrapParenAndStepInvariantExpr(e.Args[0]).(*parser.VectorSelector); ok {
+				// timestamp() creates its own selector when applied directly to a selector, see convertFunctionCallToOperator.
+				return
+			}
 		}
+	}
 
-		if e.OriginalOffset != 0 || e.Offset != 0 {
-			return nil, NewNotSupportedError("instant vector selector with 'offset'")
+	for _, child := range parser.Children(expr) {
+		if childExpr, ok := child.(parser.Expr); ok {
+			q.findCommonSubexpressions(childExpr, tr)
 		}
+	}
+}
 
-		return &operator.InstantVectorSelector{
-			Selector: &operator.Selector{
-				Queryable:     q.queryable,
-				Start:         timestamp.FromTime(q.statement.Start),
-				End:           timestamp.FromTime(q.statement.End),
-				Timestamp:     e.Timestamp,
-				Interval:      interval.Milliseconds(),
-				LookbackDelta: lookbackDelta,
-				Matchers:      e.LabelMatchers,
-			},
-		}, nil
+// convertToInstantVectorOperator returns an operator for expr.
+//
+// If expr is evaluated more than once over the same time range in this query, every call returns a consumer of the
+// same InstantVectorDuplicationBuffer, so that expr is only evaluated once.
+func (q *Query) convertToInstantVectorOperator(expr parser.Expr, tr timeRange) (operator.InstantVectorOperator, error) {
+	expr = unwrapParenAndStepInvariantExpr(expr)
+	key := subexpressionKey{expr: expr.String(), tr: tr}
+
+	if q.subexpressionCounts[key] < 2 {
+		o, err := q.convertToUnsharedInstantVectorOperator(expr, tr)
+		if err != nil {
+			return nil, err
+		}
+
+		return q.instrumentInstantVectorOperator(o), nil
+	}
+
+	if buffer, exists := q.duplicationBuffers[key]; exists {
+		return q.instrumentInstantVectorOperator(buffer.NewConsumer()), nil
+	}
+
+	inner, err := q.convertToUnsharedInstantVectorOperator(expr, tr)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := operator.NewInstantVectorDuplicationBuffer(q.instrumentInstantVectorOperator(inner), q.memoryConsumptionTracker)
+	q.duplicationBuffers[key] = buffer
+
+	return q.instrumentInstantVectorOperator(buffer.NewConsumer()), nil
+}
+
+func (q *Query) convertToUnsharedInstantVectorOperator(expr parser.Expr, tr timeRange) (operator.InstantVectorOperator, error) {
+	switch e := expr.(type) {
+	case *parser.VectorSelector:
+		return q.convertToInstantVectorSelector(e, tr), nil
 	case *parser.AggregateExpr:
-		if e.Op != parser.SUM {
-			return nil, NewNotSupportedError(fmt.Sprintf("'%s' aggregation", e.Op))
+		return q.convertToAggregationOperator(e, tr)
+	case *parser.Call:
+		return q.convertFunctionCallToOperator(e, tr)
+	case *parser.BinaryExpr:
+		if e.LHS.Type() == parser.ValueTypeScalar || e.RHS.Type() == parser.ValueTypeScalar {
+			return q.convertToVectorScalarBinaryOperation(e, tr)
+		}
+
+		if e.VectorMatching == nil {
+			// Should be set by the PromQL parser, but we check here for safety.
+			return nil, fmt.Errorf("expected vector matching for binary operation between instant vectors, but got none")
+		}
+
+		lhs, err := q.convertToInstantVectorOperator(e.LHS, tr)
+		if err != nil {
+			return nil, err
 		}
 
-		if e.Param != nil {
+		rhs, err := q.convertToInstantVectorOperator(e.RHS, tr)
+		if err != nil {
+			return nil, err
+		}
+
+		switch e.Op {
+		case parser.LAND, parser.LUNLESS:
+			return &operator.AndUnlessBinaryOperation{
+				Left:                     lhs,
+				Right:                    rhs,
+				VectorMatching:           *e.VectorMatching,
+				IsUnless:                 e.Op == parser.LUNLESS,
+				Start:                    tr.start,
+				End:                      tr.end,
+				Interval:                 tr.interval,
+				MemoryConsumptionTracker: q.memoryConsumptionTracker,
+			}, nil
+		case parser.LOR:
+			return &operator.DeduplicateAndMerge{
+				Inner: q.instrumentInstantVectorOperator(&operator.OrBinaryOperation{
+					Left:                     lhs,
+					Right:                    rhs,
+					VectorMatching:           *e.VectorMatching,
+					Start:                    tr.start,
+					End:                      tr.end,
+					Interval:                 tr.interval,
+					MemoryConsumptionTracker: q.memoryConsumptionTracker,
+				}),
+				MemoryConsumptionTracker: q.memoryConsumptionTracker,
+			}, nil
+		default:
+			if !operator.IsSupportedBinaryOperation(e.Op) {
+				return nil, NewNotSupportedError(fmt.Sprintf("binary expression with '%s'", e.Op))
+			}
+
+			return &operator.BinaryOperation{
+				Left:                     lhs,
+				Right:                    rhs,
+				Op:                       e.Op,
+				ReturnBool:               e.ReturnBool,
+				VectorMatching:           *e.VectorMatching,
+				Start:                    tr.start,
+				End:                      tr.end,
+				Interval:                 tr.interval,
+				MemoryConsumptionTracker: q.memoryConsumptionTracker,
+			}, nil
+		}
+	default:
+		// Note that parentheses and step invariant expressions have already been removed by convertToInstantVectorOperator.
+		return nil, NewNotSupportedError(fmt.Sprintf("PromQL expression type %T", e))
+	}
+}
+
+func (q *Query) convertToAggregationOperator(e *parser.AggregateExpr, tr timeRange) (operator.InstantVectorOperator, error) {
+	slices.Sort(e.Grouping)
+
+	inner, err := q.convertToInstantVectorOperator(e.Expr, tr)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.Op {
+	case parser.TOPK, parser.BOTTOMK:
+		param, err := q.convertToScalarOperator(e.Param, tr)
+		if err != nil {
+			return nil, err
+		}
+
+		return &operator.TopKBottomK{
+			Inner:                    inner,
+			Param:                    param,
+			IsBottomK:                e.Op == parser.BOTTOMK,
+			Grouping:                 e.Grouping,
+			Without:                  e.Without,
+			Start:                    tr.start,
+			End:                      tr.end,
+			Interval:                 tr.interval,
+			MemoryConsumptionTracker: q.memoryConsumptionTracker,
+		}, nil
+	case parser.COUNT_VALUES:
+		labelName, ok := unwrapParenAndStepInvariantExpr(e.Param).(*parser.StringLiteral)
+		if !ok {
 			// Should be caught by the PromQL parser, but we check here for safety.
-			return nil, fmt.Errorf("unexpected parameter for %s aggregation: %s", e.Op, e.Param)
+			return nil, fmt.Errorf("expected string literal parameter for count_values, got %T", e.Param)
 		}
 
-		if e.Without {
-			return nil, NewNotSupportedError("grouping with 'without'")
+		return &operator.CountValues{
+			Inner:                    inner,
+			LabelName:                labelName.Val,
+			Grouping:                 e.Grouping,
+			Without:                  e.Without,
+			Start:                    tr.start,
+			End:                      tr.end,
+			Interval:                 tr.interval,
+			MemoryConsumptionTracker: q.memoryConsumptionTracker,
+		}, nil
+	}
+
+	if !operator.IsSupportedAggregationOperation(e.Op) {
+		return nil, NewNotSupportedError(fmt.Sprintf("'%s' aggregation", e.Op))
+	}
+
+	var param operator.ScalarOperator
+
+	if e.Op == parser.QUANTILE {
+		param, err = q.convertToScalarOperator(e.Param, tr)
+		if err != nil {
+			return nil, err
 		}
+	} else if e.Param != nil {
+		// Should be caught by the PromQL parser, but we check here for safety.
+		return nil, fmt.Errorf("unexpected parameter for %s aggregation: %s", e.Op, e.Param)
+	}
 
-		slices.Sort(e.Grouping)
+	return &operator.Aggregation{
+		Inner:                    inner,
+		Start:                    timestamp.Time(tr.start),
+		End:                      timestamp.Time(tr.end),
+		Interval:                 time.Duration(tr.interval) * time.Millisecond,
+		Op:                       e.Op,
+		Grouping:                 e.Grouping,
+		Without:                  e.Without,
+		Param:                    param,
+		MemoryConsumptionTracker: q.memoryConsumptionTracker,
+	}, nil
+}
 
-		inner, err := q.convertToOperator(e.Expr)
+func (q *Query) convertToInstantVectorSelector(e *parser.VectorSelector, tr timeRange) *operator.InstantVectorSelector {
+	lookbackDelta := q.opts.LookbackDelta()
+	if lookbackDelta == 0 {
+		lookbackDelta = q.engine.lookbackDelta
+	}
+
+	return &operator.InstantVectorSelector{
+		Selector: &operator.Selector{
+			Queryable:                q.queryable,
+			Start:                    tr.start,
+			End:                      tr.end,
+			Timestamp:                e.Timestamp,
+			Offset:                   e.OriginalOffset.Milliseconds(),
+			Interval:                 tr.interval,
+			LookbackDelta:            lookbackDelta,
+			Matchers:                 e.LabelMatchers,
+			MemoryConsumptionTracker: q.memoryConsumptionTracker,
+		},
+		MemoryConsumptionTracker: q.memoryConsumptionTracker,
+	}
+}
+
+func (q *Query) convertFunctionCallToOperator(e *parser.Call, tr timeRange) (operator.InstantVectorOperator, error) {
+	switch e.Func.Name {
+	case "absent":
+		inner, err := q.convertToInstantVectorOperator(e.Args[0], tr)
 		if err != nil {
 			return nil, err
 		}
 
-		return &operator.Aggregation{
-			Inner:    inner,
-			Start:    q.statement.Start,
-			End:      q.statement.End,
-			Interval: interval,
-			Grouping: e.Grouping,
+		return &operator.Absent{
+			Inner:                    inner,
+			Labels:                   createLabelsForAbsentFunction(unwrapParenExpr(e.Args[0])),
+			Start:                    tr.start,
+			End:                      tr.end,
+			Interval:                 tr.interval,
+			MemoryConsumptionTracker: q.memoryConsumptionTracker,
 		}, nil
-	case *parser.Call:
-		if e.Func.Name != "rate" {
-			return nil, NewNotSupportedError(fmt.Sprintf("'%s' function", e.Func.Name))
+	case "label_replace":
+		inner, err := q.convertToInstantVectorOperator(e.Args[0], tr)
+		if err != nil {
+			return nil, err
 		}
 
-		if len(e.Args) != 1 {
+		args, err := stringLiteralArgs(e, e.Args[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		// Like Prometheus' engine, label_replace fails if it produces multiple series with the same labels.
+		return &operator.DeduplicateAndMerge{
+			Inner: q.instrumentInstantVectorOperator(&operator.LabelReplace{
+				Inner:            inner,
+				DestinationLabel: args[0],
+				Replacement:      args[1],
+				SourceLabel:      args[2],
+				Regex:            args[3],
+			}),
+			RejectNonOverlappingSeries: true,
+			MemoryConsumptionTracker:   q.memoryConsumptionTracker,
+		}, nil
+	case "label_join":
+		inner, err := q.convertToInstantVectorOperator(e.Args[0], tr)
+		if err != nil {
+			return nil, err
+		}
+
+		args, err := stringLiteralArgs(e, e.Args[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		return &operator.LabelJoin{
+			Inner:            inner,
+			DestinationLabel: args[0],
+			Separator:        args[1],
+			SourceLabels:     args[2:],
+		}, nil
+	case "sort", "sort_desc":
+		inner, err := q.convertToInstantVectorOperator(e.Args[0], tr)
+		if err != nil {
+			return nil, err
+		}
+
+		return &operator.Sort{
+			Inner:                    inner,
+			Descending:               e.Func.Name == "sort_desc",
+			Start:                    tr.start,
+			End:                      tr.end,
+			MemoryConsumptionTracker: q.memoryConsumptionTracker,
+		}, nil
+	case "histogram_quantile":
+		phi, err := q.convertToScalarOperator(e.Args[0], tr)
+		if err != nil {
+			return nil, err
+		}
+
+		inner, err := q.convertToInstantVectorOperator(e.Args[1], tr)
+		if err != nil {
+			return nil, err
+		}
+
+		return &operator.DeduplicateAndMerge{
+			Inner: q.instrumentInstantVectorOperator(&operator.HistogramQuantile{
+				Inner:                    inner,
+				Phi:                      phi,
+				Start:                    tr.start,
+				End:                      tr.end,
+				Interval:                 tr.interval,
+				MemoryConsumptionTracker: q.memoryConsumptionTracker,
+			}),
+			MemoryConsumptionTracker: q.memoryConsumptionTracker,
+		}, nil
+	case "vector":
+		scalar, err := q.convertToScalarOperator(e.Args[0], tr)
+		if err != nil {
+			return nil, err
+		}
+
+		return &operator.ScalarToInstantVector{Scalar: scalar, MemoryConsumptionTracker: q.memoryConsumptionTracker}, nil
+	case "timestamp":
+		if vs, ok := unwrapParenAndStepInvariantExpr(e.Args[0]).(*parser.VectorSelector); ok {
+			// Like Prometheus' engine, timestamp() returns the timestamp of each sample when applied directly to a
+			// selector, rather than the timestamp of each step.
+			inner := q.convertToInstantVectorSelector(vs, tr)
+			inner.ReturnSampleTimestamps = true
+
+			return &operator.DeduplicateAndMerge{
+				Inner: q.instrumentInstantVectorOperator(&operator.FunctionOverInstantVector{
+					Inner:                    q.instrumentInstantVectorOperator(inner),
+					Func:                     operator.SampleTimestampFunction,
+					FunctionName:             e.Func.Name,
+					Start:                    tr.start,
+					End:                      tr.end,
+					Interval:                 tr.interval,
+					MemoryConsumptionTracker: q.memoryConsumptionTracker,
+				}),
+				MemoryConsumptionTracker: q.memoryConsumptionTracker,
+			}, nil
+		}
+	}
+
+	if f, ok := operator.InstantVectorFunctions[e.Func.Name]; ok {
+		return q.convertToFunctionOverInstantVector(e, f, tr)
+	}
+
+	return q.convertToFunctionOverRangeVector(e, tr)
+}
+
+func (q *Query) convertToFunctionOverInstantVector(e *parser.Call, f operator.InstantVectorFunction, tr timeRange) (operator.InstantVectorOperator, error) {
+	var inner operator.InstantVectorOperator
+	args := make([]operator.ScalarOperator, 0, len(e.Args))
+
+	for _, arg := range e.Args {
+		if arg.Type() != parser.ValueTypeVector {
+			a, err := q.convertToScalarOperator(arg, tr)
+			if err != nil {
+				return nil, err
+			}
+
+			args = append(args, a)
+			continue
+		}
+
+		if inner != nil {
 			// Should be caught by the PromQL parser, but we check here for safety.
-			return nil, fmt.Errorf("expected exactly one argument for rate, got %v", len(e.Args))
+			return nil, fmt.Errorf("expected at most one instant vector argument for %s, got more than one", e.Func.Name)
+		}
+
+		var err error
+		inner, err = q.convertToInstantVectorOperator(arg, tr)
+		if err != nil {
+			return nil, err
 		}
+	}
+
+	if inner == nil {
+		// Functions such as hour() operate on the timestamp of each step if no instant vector is given, which is
+		// equivalent to hour(vector(time())).
+		inner = q.instrumentInstantVectorOperator(&operator.ScalarToInstantVector{
+			Scalar:                   q.instrumentScalarOperator(q.timeOperator(tr)),
+			MemoryConsumptionTracker: q.memoryConsumptionTracker,
+		})
+	}
+
+	var o operator.InstantVectorOperator = &operator.FunctionOverInstantVector{
+		Inner:                    inner,
+		Func:                     f,
+		FunctionName:             e.Func.Name,
+		Args:                     args,
+		Start:                    tr.start,
+		End:                      tr.end,
+		Interval:                 tr.interval,
+		MemoryConsumptionTracker: q.memoryConsumptionTracker,
+	}
+
+	if !f.KeepMetricName {
+		// The metric name is dropped from the result, so we may end up with multiple series with the same labels.
+		o = &operator.DeduplicateAndMerge{Inner: q.instrumentInstantVectorOperator(o), MemoryConsumptionTracker: q.memoryConsumptionTracker}
+	}
+
+	return o, nil
+}
+
+// stringLiteralArgs returns the values of args, which must all be string literals.
+func stringLiteralArgs(e *parser.Call, args parser.Expressions) ([]string, error) {
+	values := make([]string, 0, len(args))
 
-		matrixSelector, ok := e.Args[0].(*parser.MatrixSelector)
+	for _, arg := range args {
+		s, ok := unwrapParenAndStepInvariantExpr(arg).(*parser.StringLiteral)
 		if !ok {
 			// Should be caught by the PromQL parser, but we check here for safety.
-			return nil, NewNotSupportedError(fmt.Sprintf("unsupported rate argument type %T", e.Args[0]))
+			return nil, fmt.Errorf("expected string literal argument for %s, got %T", e.Func.Name, arg)
 		}
 
-		vectorSelector := matrixSelector.VectorSelector.(*parser.VectorSelector)
+		values = append(values, s.Val)
+	}
+
+	return values, nil
+}
+
+func (q *Query) convertToFunctionOverRangeVector(e *parser.Call, tr timeRange) (operator.InstantVectorOperator, error) {
+	name := e.Func.Name
+
+	if name == "absent_over_time" {
+		// absent_over_time is computed from the output of present_over_time, see below.
+		name = "present_over_time"
+	}
+
+	f, ok := operator.RangeVectorFunctions[name]
+	if !ok {
+		return nil, NewNotSupportedError(fmt.Sprintf("'%s' function", e.Func.Name))
+	}
+
+	var inner operator.RangeVectorOperator
+	args := make([]operator.ScalarOperator, 0, len(e.Args))
+
+	for _, arg := range e.Args {
+		if arg.Type() != parser.ValueTypeMatrix {
+			a, err := q.convertToScalarOperator(arg, tr)
+			if err != nil {
+				return nil, err
+			}
 
-		if vectorSelector.OriginalOffset != 0 || vectorSelector.Offset != 0 {
-			return nil, NewNotSupportedError("range vector selector with 'offset'")
+			args = append(args, a)
+			continue
+		}
+
+		if inner != nil {
+			// Should be caught by the PromQL parser, but we check here for safety.
+			return nil, fmt.Errorf("expected exactly one range vector argument for %s, got more than one", e.Func.Name)
 		}
 
-		return &operator.RangeVectorSelectorWithTransformation{
+		var err error
+		inner, err = q.convertToRangeVectorOperator(arg, tr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if inner == nil {
+		// Should be caught by the PromQL parser, but we check here for safety.
+		return nil, fmt.Errorf("expected a range vector argument for %s, got none", e.Func.Name)
+	}
+
+	var o operator.InstantVectorOperator = &operator.FunctionOverRangeVector{
+		Inner:                    inner,
+		Func:                     f,
+		FunctionName:             name,
+		Args:                     args,
+		MemoryConsumptionTracker: q.memoryConsumptionTracker,
+	}
+
+	if e.Func.Name == "absent_over_time" {
+		return &operator.Absent{
+			Inner:                    q.instrumentInstantVectorOperator(o),
+			Labels:                   createLabelsForAbsentFunction(e.Args[0]),
+			Start:                    tr.start,
+			End:                      tr.end,
+			Interval:                 tr.interval,
+			MemoryConsumptionTracker: q.memoryConsumptionTracker,
+		}, nil
+	}
+
+	if !f.KeepMetricName {
+		// The metric name is dropped from the result, so we may end up with multiple series with the same labels.
+		o = &operator.DeduplicateAndMerge{Inner: q.instrumentInstantVectorOperator(o), RejectNonOverlappingSeries: true, MemoryConsumptionTracker: q.memoryConsumptionTracker}
+	}
+
+	return o, nil
+}
+
+func (q *Query) convertToRangeVectorOperator(expr parser.Expr, tr timeRange) (operator.RangeVectorOperator, error) {
+	o, err := q.convertToUninstrumentedRangeVectorOperator(expr, tr)
+	if err != nil {
+		return nil, err
+	}
+
+	return q.instrumentRangeVectorOperator(o), nil
+}
+
+func (q *Query) convertToUninstrumentedRangeVectorOperator(expr parser.Expr, tr timeRange) (operator.RangeVectorOperator, error) {
+	switch e := unwrapParenAndStepInvariantExpr(expr).(type) {
+	case *parser.MatrixSelector:
+		vectorSelector := e.VectorSelector.(*parser.VectorSelector)
+
+		return &operator.RangeVectorSelector{
 			Selector: &operator.Selector{
-				Queryable: q.queryable,
-				Start:     timestamp.FromTime(q.statement.Start),
-				End:       timestamp.FromTime(q.statement.End),
-				Timestamp: vectorSelector.Timestamp,
-				Interval:  interval.Milliseconds(),
-				Range:     matrixSelector.Range,
-				Matchers:  vectorSelector.LabelMatchers,
+				Queryable:                q.queryable,
+				Start:                    tr.start,
+				End:                      tr.end,
+				Timestamp:                vectorSelector.Timestamp,
+				Offset:                   vectorSelector.OriginalOffset.Milliseconds(),
+				Interval:                 tr.interval,
+				Range:                    e.Range,
+				Matchers:                 vectorSelector.LabelMatchers,
+				MemoryConsumptionTracker: q.memoryConsumptionTracker,
 			},
 		}, nil
-	case *parser.StepInvariantExpr:
-		// One day, we'll do something smarter here.
-		return q.convertToOperator(e.Expr)
-	case *parser.ParenExpr:
-		return q.convertToOperator(e.Expr)
+	case *parser.SubqueryExpr:
+		inner, err := q.convertToInstantVectorOperator(e.Expr, q.subqueryTimeRange(e, tr))
+		if err != nil {
+			return nil, err
+		}
+
+		return &operator.Subquery{
+			Inner:                    inner,
+			ParentStart:              tr.start,
+			ParentEnd:                tr.end,
+			ParentInterval:           tr.interval,
+			Timestamp:                e.Timestamp,
+			Offset:                   e.OriginalOffset.Milliseconds(),
+			SubqueryRange:            e.Range,
+			MemoryConsumptionTracker: q.memoryConsumptionTracker,
+		}, nil
+	default:
+		return nil, NewNotSupportedError(fmt.Sprintf("PromQL range vector expression type %T", e))
+	}
+}
+
+// subqueryTimeRange returns the time range to evaluate the expression inside e at, given the time range of its parent.
+//
+// Like Prometheus' engine, the steps of the subquery are aligned to multiples of the subquery's step, rather than the
+// start time of the parent.
+func (q *Query) subqueryTimeRange(e *parser.SubqueryExpr, parent timeRange) timeRange {
+	interval := e.Step.Milliseconds()
+
+	if interval == 0 {
+		interval = q.engine.noStepSubqueryIntervalFn(e.Range.Milliseconds())
+	}
+
+	start := parent.start
+	end := parent.end
+
+	if e.Timestamp != nil {
+		start = *e.Timestamp
+		end = *e.Timestamp
+	}
+
+	start -= e.OriginalOffset.Milliseconds() + e.Range.Milliseconds()
+	end -= e.OriginalOffset.Milliseconds()
+
+	// Start with the first timestamp at or after the start of the range of the parent's first step that is a multiple
+	// of the subquery's step.
+	alignedStart := interval * (start / interval)
+	if alignedStart < start {
+		alignedStart += interval
+	}
+
+	return timeRange{
+		start:    alignedStart,
+		end:      end,
+		interval: interval,
+	}
+}
+
+// createLabelsForAbsentFunction returns the labels for the output series of absent_over_time, in the same way as
+// Prometheus' engine: the labels from the equality matchers in expr, excluding the metric name and any label with
+// more than one matcher.
+func createLabelsForAbsentFunction(expr parser.Expr) labels.Labels {
+	b := labels.NewBuilder(labels.EmptyLabels())
+
+	var lm []*labels.Matcher
+	switch n := expr.(type) {
+	case *parser.VectorSelector:
+		lm = n.LabelMatchers
+	case *parser.MatrixSelector:
+		lm = n.VectorSelector.(*parser.VectorSelector).LabelMatchers
+	default:
+		return labels.EmptyLabels()
+	}
+
+	has := make(map[string]bool, len(lm))
+	for _, ma := range lm {
+		if ma.Name == labels.MetricName {
+			continue
+		}
+
+		if ma.Type == labels.MatchEqual && !has[ma.Name] {
+			b.Set(ma.Name, ma.Value)
+			has[ma.Name] = true
+		} else {
+			b.Del(ma.Name)
+		}
+	}
+
+	return b.Labels()
+}
+
+func unwrapParenExpr(expr parser.Expr) parser.Expr {
+	for {
+		e, ok := expr.(*parser.ParenExpr)
+		if !ok {
+			return expr
+		}
+
+		expr = e.Expr
+	}
+}
+
+func unwrapParenAndStepInvariantExpr(expr parser.Expr) parser.Expr {
+	for {
+		switch e := expr.(type) {
+		case *parser.ParenExpr:
+			expr = e.Expr
+		case *parser.StepInvariantExpr:
+			expr = e.Expr
+		default:
+			return expr
+		}
+	}
+}
+
+func (q *Query) convertToVectorScalarBinaryOperation(e *parser.BinaryExpr, tr timeRange) (operator.InstantVectorOperator, error) {
+	if !operator.IsSupportedBinaryOperation(e.Op) {
+		// Set operations between vectors and scalars should be rejected by the PromQL parser, but we check here for safety.
+		return nil, NewNotSupportedError(fmt.Sprintf("binary expression with '%s'", e.Op))
+	}
+
+	scalarIsLeftSide := e.LHS.Type() == parser.ValueTypeScalar
+	scalarExpr, vectorExpr := e.RHS, e.LHS
+
+	if scalarIsLeftSide {
+		scalarExpr, vectorExpr = e.LHS, e.RHS
+	}
+
+	scalar, err := q.convertToScalarOperator(scalarExpr, tr)
+	if err != nil {
+		return nil, err
+	}
+
+	vector, err := q.convertToInstantVectorOperator(vectorExpr, tr)
+	if err != nil {
+		return nil, err
+	}
+
+	var o operator.InstantVectorOperator = &operator.VectorScalarBinaryOperation{
+		Scalar:                   scalar,
+		Vector:                   vector,
+		ScalarIsLeftSide:         scalarIsLeftSide,
+		Op:                       e.Op,
+		ReturnBool:               e.ReturnBool,
+		Start:                    tr.start,
+		End:                      tr.end,
+		Interval:                 tr.interval,
+		MemoryConsumptionTracker: q.memoryConsumptionTracker,
+	}
+
+	if !e.Op.IsComparisonOperator() || e.ReturnBool {
+		// The metric name is dropped from the result, so we may end up with multiple series with the same labels.
+		o = &operator.DeduplicateAndMerge{Inner: q.instrumentInstantVectorOperator(o), MemoryConsumptionTracker: q.memoryConsumptionTracker}
+	}
+
+	return o, nil
+}
+
+func (q *Query) convertToScalarOperator(expr parser.Expr, tr timeRange) (operator.ScalarOperator, error) {
+	o, err := q.convertToUninstrumentedScalarOperator(unwrapParenAndStepInvariantExpr(expr), tr)
+	if err != nil {
+		return nil, err
+	}
+
+	return q.instrumentScalarOperator(o), nil
+}
+
+func (q *Query) convertToUninstrumentedScalarOperator(expr parser.Expr, tr timeRange) (operator.ScalarOperator, error) {
+	switch e := expr.(type) {
+	case *parser.NumberLiteral:
+		return &operator.ScalarConstant{
+			Value:                    e.Val,
+			Start:                    tr.start,
+			End:                      tr.end,
+			Interval:                 tr.interval,
+			MemoryConsumptionTracker: q.memoryConsumptionTracker,
+		}, nil
+	case *parser.BinaryExpr:
+		if !operator.IsSupportedBinaryOperation(e.Op) {
+			// Should be caught by the PromQL parser, but we check here for safety.
+			return nil, NewNotSupportedError(fmt.Sprintf("binary expression with '%s'", e.Op))
+		}
+
+		lhs, err := q.convertToScalarOperator(e.LHS, tr)
+		if err != nil {
+			return nil, err
+		}
+
+		rhs, err := q.convertToScalarOperator(e.RHS, tr)
+		if err != nil {
+			return nil, err
+		}
+
+		return &operator.ScalarScalarBinaryOperation{
+			Left:                     lhs,
+			Right:                    rhs,
+			Op:                       e.Op,
+			MemoryConsumptionTracker: q.memoryConsumptionTracker,
+		}, nil
+	case *parser.Call:
+		return q.convertFunctionCallToScalarOperator(e, tr)
 	default:
+		// Note that parentheses and step invariant expressions have already been removed by convertToScalarOperator.
 		return nil, NewNotSupportedError(fmt.Sprintf("PromQL expression type %T", e))
 	}
 }
 
+func (q *Query) convertFunctionCallToScalarOperator(e *parser.Call, tr timeRange) (operator.ScalarOperator, error) {
+	switch e.Func.Name {
+	case "time":
+		return q.timeOperator(tr), nil
+	case "scalar":
+		inner, err := q.convertToInstantVectorOperator(e.Args[0], tr)
+		if err != nil {
+			return nil, err
+		}
+
+		return &operator.InstantVectorToScalar{
+			Inner:                    inner,
+			Start:                    tr.start,
+			End:                      tr.end,
+			Interval:                 tr.interval,
+			MemoryConsumptionTracker: q.memoryConsumptionTracker,
+		}, nil
+	default:
+		return nil, NewNotSupportedError(fmt.Sprintf("'%s' function", e.Func.Name))
+	}
+}
+
+func (q *Query) instrumentInstantVectorOperator(o operator.InstantVectorOperator) operator.InstantVectorOperator {
+	if q.statsRecorder == nil {
+		return o
+	}
+
+	return operator.NewInstrumentedInstantVectorOperator(o, q.statsRecorder)
+}
+
+func (q *Query) instrumentRangeVectorOperator(o operator.RangeVectorOperator) operator.RangeVectorOperator {
+	if q.statsRecorder == nil {
+		return o
+	}
+
+	return operator.NewInstrumentedRangeVectorOperator(o, q.statsRecorder)
+}
+
+func (q *Query) instrumentScalarOperator(o operator.ScalarOperator) operator.ScalarOperator {
+	if q.statsRecorder == nil {
+		return o
+	}
+
+	return operator.NewInstrumentedScalarOperator(o, q.statsRecorder)
+}
+
+func (q *Query) timeOperator(tr timeRange) operator.ScalarOperator {
+	return &operator.Time{
+		Start:                    tr.start,
+		End:                      tr.end,
+		Interval:                 tr.interval,
+		MemoryConsumptionTracker: q.memoryConsumptionTracker,
+	}
+}
+
+// timeRange describes the steps at which an expression is evaluated: either the steps of the query, or the steps of
+// a subquery.
+type timeRange struct {
+	start    int64 // Milliseconds since Unix epoch
+	end      int64 // Milliseconds since Unix epoch
+	interval int64 // In milliseconds
+}
+
+// queryTimeRange returns the time range of this query.
+func (q *Query) queryTimeRange() timeRange {
+	interval := q.statement.Interval.Milliseconds()
+
+	if q.IsInstant() {
+		interval = time.Millisecond.Milliseconds()
+	}
+
+	return timeRange{
+		start:    timestamp.FromTime(q.statement.Start),
+		end:      timestamp.FromTime(q.statement.End),
+		interval: interval,
+	}
+}
+
 func (q *Query) IsInstant() bool {
 	return q.statement.Start == q.statement.End && q.statement.Interval == 0
 }
@@ -179,38 +953,99 @@ func (q *Query) IsInstant() bool {
 func (q *Query) Exec(ctx context.Context) *promql.Result {
 	defer q.root.Close()
 
-	series, err := q.root.SeriesMetadata(ctx)
-	if err != nil {
-		return &promql.Result{Err: err}
-	}
-	defer operator.PutSeriesMetadataSlice(series)
+	defer func() {
+		// Record the peak memory consumption even if the query failed, so that it is reported for queries that
+		// exceeded the limit.
+		stats.FromContext(ctx).UpdateEstimatedPeakMemoryConsumption(q.memoryConsumptionTracker.PeakEstimatedMemoryConsumptionBytes)
 
-	if q.IsInstant() {
-		v, err := q.populateVector(ctx, series)
+		if q.statsRecorder != nil {
+			stats.FromContext(ctx).AddOperatorStats(q.operatorStats()...)
+		}
+	}()
+
+	switch root := q.root.(type) {
+	case operator.ScalarOperator:
+		d, err := root.GetValues(ctx)
 		if err != nil {
 			return &promql.Result{Err: err}
 		}
 
-		q.result = &promql.Result{Value: v}
-	} else {
-		m, err := q.populateMatrix(ctx, series)
+		if q.IsInstant() {
+			q.result = &promql.Result{Value: q.populateScalar(d)}
+		} else {
+			m, err := q.populateMatrixFromScalar(d)
+			if err != nil {
+				return &promql.Result{Err: err}
+			}
+
+			q.result = &promql.Result{Value: m}
+		}
+	case operator.InstantVectorOperator:
+		series, err := root.SeriesMetadata(ctx)
 		if err != nil {
-			return &promql.Result{Value: m}
+			return &promql.Result{Err: err}
 		}
+		defer operator.PutSeriesMetadataSlice(series, q.memoryConsumptionTracker)
+
+		if q.IsInstant() {
+			v, err := q.populateVector(ctx, root, series)
+			if err != nil {
+				return &promql.Result{Err: err}
+			}
+
+			q.result = &promql.Result{Value: v}
+		} else {
+			m, err := q.populateMatrix(ctx, root, series)
+			if err != nil {
+				return &promql.Result{Err: err}
+			}
 
-		q.result = &promql.Result{Value: m}
+			q.result = &promql.Result{Value: m}
+		}
+	default:
+		return &promql.Result{Err: fmt.Errorf("operator %T produces unexpected value type", q.root)}
 	}
 
 	return q.result
 }
 
-func (q *Query) populateVector(ctx context.Context, series []operator.SeriesMetadata) (promql.Vector, error) {
+func (q *Query) populateScalar(d operator.ScalarData) promql.Scalar {
+	defer operator.PutFPointSlice(d.Samples, q.memoryConsumptionTracker)
+
+	if len(d.Samples) != 1 {
+		panic(fmt.Sprintf("expected exactly one sample for instant query scalar result, but got %v", len(d.Samples)))
+	}
+
+	return promql.Scalar{T: d.Samples[0].T, V: d.Samples[0].F}
+}
+
+func (q *Query) populateMatrixFromScalar(d operator.ScalarData) (promql.Matrix, error) {
+	m, err := operator.GetMatrix(1, q.memoryConsumptionTracker)
+	if err != nil {
+		operator.PutFPointSlice(d.Samples, q.memoryConsumptionTracker)
+		return nil, err
+	}
+
+	m = append(m, promql.Series{
+		Metric: labels.EmptyLabels(),
+		Floats: d.Samples,
+	})
+
+	return m, nil
+}
+
+func (q *Query) populateVector(ctx context.Context, o operator.InstantVectorOperator, series []operator.SeriesMetadata) (promql.Vector, error) {
 	ts := timeMilliseconds(q.statement.Start)
-	v := operator.GetVector(len(series))
+	v, err := operator.GetVector(len(series), q.memoryConsumptionTracker)
+	if err != nil {
+		return nil, err
+	}
 
 	for i, s := range series {
-		d, err := q.root.Next(ctx)
+		d, err := o.Next(ctx)
 		if err != nil {
+			operator.PutVector(v, q.memoryConsumptionTracker)
+
 			if errors.Is(err, operator.EOS) {
 				return nil, fmt.Errorf("expected %v series, but only received %v", len(series), i)
 			}
@@ -219,36 +1054,49 @@ func (q *Query) populateVector(ctx context.Context, series []operator.SeriesMeta
 		}
 
 		if len(d.Floats)+len(d.Histograms) != 1 {
-			operator.PutFPointSlice(d.Floats)
-			// TODO: put histogram point slice back in pool
+			operator.PutFPointSlice(d.Floats, q.memoryConsumptionTracker)
+			operator.PutHPointSlice(d.Histograms, q.memoryConsumptionTracker)
 
 			if len(d.Floats)+len(d.Histograms) == 0 {
 				continue
 			}
 
-			return nil, fmt.Errorf("expected exactly one sample for series %s, but got %v", s.Labels.String(), len(d.Floats))
+			operator.PutVector(v, q.memoryConsumptionTracker)
+			return nil, fmt.Errorf("expected exactly one sample for series %s, but got %v", s.Labels.String(), len(d.Floats)+len(d.Histograms))
 		}
 
-		point := d.Floats[0]
-		v = append(v, promql.Sample{
-			Metric: s.Labels,
-			T:      ts,
-			F:      point.F,
-		})
+		if len(d.Floats) == 1 {
+			v = append(v, promql.Sample{
+				Metric: s.Labels,
+				T:      ts,
+				F:      d.Floats[0].F,
+			})
+		} else {
+			v = append(v, promql.Sample{
+				Metric: s.Labels,
+				T:      ts,
+				H:      d.Histograms[0].H,
+			})
+		}
 
-		operator.PutFPointSlice(d.Floats)
-		// TODO: put histogram point slice back in pool
+		operator.PutFPointSlice(d.Floats, q.memoryConsumptionTracker)
+		operator.PutHPointSlice(d.Histograms, q.memoryConsumptionTracker)
 	}
 
 	return v, nil
 }
 
-func (q *Query) populateMatrix(ctx context.Context, series []operator.SeriesMetadata) (promql.Matrix, error) {
-	m := operator.GetMatrix(len(series))
+func (q *Query) populateMatrix(ctx context.Context, o operator.InstantVectorOperator, series []operator.SeriesMetadata) (promql.Matrix, error) {
+	m, err := operator.GetMatrix(len(series), q.memoryConsumptionTracker)
+	if err != nil {
+		return nil, err
+	}
 
 	for i, s := range series {
-		d, err := q.root.Next(ctx)
+		d, err := o.Next(ctx)
 		if err != nil {
+			q.putMatrix(m)
+
 			if errors.Is(err, operator.EOS) {
 				return nil, fmt.Errorf("expected %v series, but only received %v", len(series), i)
 			}
@@ -257,8 +1105,8 @@ func (q *Query) populateMatrix(ctx context.Context, series []operator.SeriesMeta
 		}
 
 		if len(d.Floats) == 0 && len(d.Histograms) == 0 {
-			operator.PutFPointSlice(d.Floats)
-			// TODO: put histogram point slice back in pool
+			operator.PutFPointSlice(d.Floats, q.memoryConsumptionTracker)
+			operator.PutHPointSlice(d.Histograms, q.memoryConsumptionTracker)
 
 			continue
 		}
@@ -270,6 +1118,9 @@ func (q *Query) populateMatrix(ctx context.Context, series []operator.SeriesMeta
 		})
 	}
 
+	// Operators may return series in any order, but range query results must be sorted by labels, just as Prometheus' engine does.
+	sort.Sort(m)
+
 	return m, nil
 }
 
@@ -280,24 +1131,30 @@ func (q *Query) Close() {
 
 	switch v := q.result.Value.(type) {
 	case promql.Matrix:
-		for _, s := range v {
-			operator.PutFPointSlice(s.Floats)
-			// TODO: put histogram point slice back in pool
-		}
-
-		operator.PutMatrix(v)
+		q.putMatrix(v)
 	case promql.Vector:
-		operator.PutVector(v)
+		operator.PutVector(v, q.memoryConsumptionTracker)
+	case promql.Scalar:
+		// Nothing to do, we already returned the slice in populateScalar.
 	default:
 		panic(fmt.Sprintf("unknown result value type %T", q.result.Value))
 	}
 }
 
+func (q *Query) putMatrix(m promql.Matrix) {
+	for _, s := range m {
+		operator.PutFPointSlice(s.Floats, q.memoryConsumptionTracker)
+		operator.PutHPointSlice(s.Histograms, q.memoryConsumptionTracker)
+	}
+
+	operator.PutMatrix(m, q.memoryConsumptionTracker)
+}
+
 func (q *Query) Statement() parser.Statement {
 	return q.statement
 }
 
-func (q *Query) Stats() *stats.Statistics {
+func (q *Query) Stats() *promstats.Statistics {
 	// Not yet supported.
 	return nil
 }
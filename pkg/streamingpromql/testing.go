@@ -16,5 +16,8 @@ func NewTestEngineOpts() promql.EngineOpts {
 		Timeout:              100 * time.Second,
 		EnableAtModifier:     true,
 		EnableNegativeOffset: true,
+		NoStepSubqueryIntervalFn: func(int64) int64 {
+			return time.Minute.Milliseconds()
+		},
 	}
 }
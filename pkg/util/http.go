@@ -23,8 +23,10 @@ import (
 	"github.com/gogo/protobuf/proto"
 	"github.com/golang/snappy"
 	"github.com/grafana/dskit/flagext"
+	"github.com/klauspost/compress/zstd"
 	"github.com/opentracing/opentracing-go"
 	otlog "github.com/opentracing/opentracing-go/log"
+	"github.com/pierrec/lz4/v4"
 	"github.com/pkg/errors"
 	"google.golang.org/grpc/codes"
 	"gopkg.in/yaml.v3"
@@ -142,8 +144,27 @@ const (
 	NoCompression CompressionType = iota
 	RawSnappy
 	Gzip
+	Zstd
+	Lz4
 )
 
+func (c CompressionType) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case RawSnappy:
+		return "snappy"
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	case Lz4:
+		return "lz4"
+	default:
+		return fmt.Sprintf("unknown (%d)", int(c))
+	}
+}
+
 // ParseProtoReader parses a compressed proto from an io.Reader.
 // You can pass in an optional RequestBuffers.
 func ParseProtoReader(ctx context.Context, reader io.Reader, expectedSize, maxSize int, buffers *RequestBuffers, req proto.Message, compression CompressionType) error {
@@ -202,7 +223,7 @@ func decompressRequest(buffers *RequestBuffers, reader io.Reader, expectedSize,
 	if expectedSize > maxSize {
 		return nil, MsgSizeTooLargeErr{Actual: expectedSize, Limit: maxSize}
 	}
-	if compression != NoCompression && compression != RawSnappy && compression != Gzip {
+	if compression != NoCompression && compression != RawSnappy && compression != Gzip && compression != Zstd && compression != Lz4 {
 		return nil, fmt.Errorf("unrecognized compression type %v", compression)
 	}
 
@@ -224,15 +245,17 @@ func decompressRequest(buffers *RequestBuffers, reader io.Reader, expectedSize,
 		sp.LogFields(otlog.Event("util.ParseProtoReader[decompress]"), otlog.Int("expectedSize", expectedSize))
 	}
 
-	if compression == Gzip {
-		var err error
-		reader, err = gzip.NewReader(reader)
+	if compression != NoCompression && compression != RawSnappy {
+		decompressingReader, err := NewDecompressingReader(reader, compression, maxSize)
 		if err != nil {
-			return nil, errors.Wrap(err, "create gzip reader")
+			return nil, err
 		}
+		defer decompressingReader.Close()
+		reader = decompressingReader
 	}
 
-	// Limit at maxSize+1 so we can tell when the size is exceeded
+	// Limit at maxSize+1 so we can tell when the size is exceeded. When the request is compressed with a streaming
+	// compression, this limits the decompressed size.
 	reader = io.LimitReader(reader, int64(maxSize)+1)
 
 	sz := expectedSize
@@ -242,8 +265,11 @@ func decompressRequest(buffers *RequestBuffers, reader io.Reader, expectedSize,
 	}
 	buf := buffers.Get(sz)
 	if _, err := buf.ReadFrom(reader); err != nil {
-		if compression == Gzip {
-			return nil, errors.Wrap(err, "decompress gzip")
+		if errors.Is(err, MsgSizeTooLargeErr{}) {
+			return nil, err
+		}
+		if compression != NoCompression && compression != RawSnappy {
+			return nil, errors.Wrapf(err, "decompress %s", compression)
 		}
 		return nil, errors.Wrap(err, "read body")
 	}
@@ -258,6 +284,53 @@ func decompressRequest(buffers *RequestBuffers, reader io.Reader, expectedSize,
 	return buf.Bytes(), nil
 }
 
+// NewDecompressingReader returns a reader which decompresses the content of reader while reading it, for the streaming
+// compression types gzip, zstd and lz4. The caller is expected to limit the size of what is read, and to close the
+// returned reader. The zstd reader fails early with MsgSizeTooLargeErr on frames that would decompress to more than
+// maxSize bytes, or would need a larger window.
+func NewDecompressingReader(reader io.Reader, compression CompressionType, maxSize int) (io.ReadCloser, error) {
+	switch compression {
+	case Gzip:
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, errors.Wrap(err, "create gzip reader")
+		}
+		return gzipReader, nil
+	case Zstd:
+		opts := []zstd.DOption{
+			// Decode synchronously, without starting goroutines for each request.
+			zstd.WithDecoderConcurrency(1),
+		}
+		if maxSize > 0 {
+			// The window of a frame is at least zstd.MinWindowSize, even if the frame is smaller.
+			opts = append(opts, zstd.WithDecoderMaxMemory(uint64(max(maxSize, zstd.MinWindowSize))))
+		}
+		zstdReader, err := zstd.NewReader(reader, opts...)
+		if err != nil {
+			return nil, errors.Wrap(err, "create zstd reader")
+		}
+		return zstdReadCloser{ReadCloser: zstdReader.IOReadCloser(), maxSize: maxSize}, nil
+	case Lz4:
+		return io.NopCloser(lz4.NewReader(reader)), nil
+	default:
+		return nil, fmt.Errorf("unrecognized streaming compression type %v", compression)
+	}
+}
+
+// zstdReadCloser converts the errors of the zstd decoder about the decompressed size to MsgSizeTooLargeErr.
+type zstdReadCloser struct {
+	io.ReadCloser
+	maxSize int
+}
+
+func (r zstdReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		err = MsgSizeTooLargeErr{Actual: -1, Limit: r.maxSize}
+	}
+	return n, err
+}
+
 func decompressSnappyFromBuffer(buffers *RequestBuffers, buffer *bytes.Buffer, maxSize int, sp opentracing.Span) ([]byte, error) {
 	if sp != nil {
 		sp.LogFields(otlog.Event("util.ParseProtoReader[decompressSnappy]"), otlog.Int("size", buffer.Len()))
@@ -19,6 +19,8 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
 	"github.com/prometheus/prometheus/prompb"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -228,6 +230,116 @@ func TestParseProtoReader(t *testing.T) {
 	}
 }
 
+func TestParseProtoReader_StreamingCompression(t *testing.T) {
+	req := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels: []prompb.Label{{Name: "foo", Value: "bar"}},
+				Samples: []prompb.Sample{
+					{Value: 10, Timestamp: 1},
+					{Value: 20, Timestamp: 2},
+					{Value: 30, Timestamp: 3},
+				},
+			},
+		},
+	}
+	data, err := req.Marshal()
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		name           string
+		compression    CompressionType
+		maxSize        int
+		expectParseErr bool
+	}{
+		{"zstd", Zstd, 53, false},
+		{"too big decoded zstd", Zstd, 50, true},
+		{"lz4", Lz4, 53, false},
+		{"too big decoded lz4", Lz4, 50, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			switch tt.compression {
+			case Zstd:
+				w, err := zstd.NewWriter(&b)
+				require.NoError(t, err)
+				_, err = w.Write(data)
+				require.NoError(t, err)
+				require.NoError(t, w.Close())
+			case Lz4:
+				w := lz4.NewWriter(&b)
+				_, err := w.Write(data)
+				require.NoError(t, err)
+				require.NoError(t, w.Close())
+			}
+
+			var fromWire prompb.WriteRequest
+			err := ParseProtoReader(context.Background(), &b, 0, tt.maxSize, nil, &fromWire, tt.compression)
+			if tt.expectParseErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, req, &fromWire)
+		})
+	}
+}
+
+func TestParseProtoReader_DecompressionBomb(t *testing.T) {
+	const maxSize = 64 * 1024
+
+	// A highly compressible payload, much bigger than the max size once decompressed.
+	payload := make([]byte, 10*1024*1024)
+
+	for name, tc := range map[string]struct {
+		compression CompressionType
+		compress    func(t *testing.T) []byte
+	}{
+		"zstd with decompressed size in the frame header": {
+			compression: Zstd,
+			compress: func(t *testing.T) []byte {
+				enc, err := zstd.NewWriter(nil)
+				require.NoError(t, err)
+				defer enc.Close()
+				return enc.EncodeAll(payload, nil)
+			},
+		},
+		"zstd without decompressed size in the frame header": {
+			compression: Zstd,
+			compress: func(t *testing.T) []byte {
+				// The streaming writer doesn't store the decompressed size in the frame header.
+				var b bytes.Buffer
+				w, err := zstd.NewWriter(&b)
+				require.NoError(t, err)
+				_, err = w.Write(payload)
+				require.NoError(t, err)
+				require.NoError(t, w.Close())
+				return b.Bytes()
+			},
+		},
+		"lz4": {
+			compression: Lz4,
+			compress: func(t *testing.T) []byte {
+				var b bytes.Buffer
+				w := lz4.NewWriter(&b)
+				_, err := w.Write(payload)
+				require.NoError(t, err)
+				require.NoError(t, w.Close())
+				return b.Bytes()
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			compressed := tc.compress(t)
+			require.Less(t, len(compressed), maxSize)
+
+			var req prompb.WriteRequest
+			err := ParseProtoReader(context.Background(), bytes.NewReader(compressed), len(compressed), maxSize, nil, &req, tc.compression)
+			require.ErrorIs(t, err, MsgSizeTooLargeErr{})
+		})
+	}
+}
+
 type bytesBuffered struct {
 	*bytes.Buffer
 }
@@ -0,0 +1,25 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package math
+
+import (
+	"math"
+)
+
+// EqualWithinTolerance returns whether actual is equal to expected, within the given tolerance. NaN and infinite values
+// of the same sign are equal. If tolerance isn't positive, the values must be bitwise equal. If useRelativeError is
+// true, the tolerance applies to the error relative to actual, unless actual is 0.
+func EqualWithinTolerance(expected, actual, tolerance float64, useRelativeError bool) bool {
+	if (math.IsNaN(expected) && math.IsNaN(actual)) ||
+		(math.IsInf(expected, 1) && math.IsInf(actual, 1)) ||
+		(math.IsInf(expected, -1) && math.IsInf(actual, -1)) {
+		return true
+	}
+	if tolerance <= 0 {
+		return math.Float64bits(expected) == math.Float64bits(actual)
+	}
+	if useRelativeError && actual != 0 {
+		return math.Abs(expected-actual)/math.Abs(actual) <= tolerance
+	}
+	return math.Abs(expected-actual) <= tolerance
+}
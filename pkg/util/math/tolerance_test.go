@@ -0,0 +1,37 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package math
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEqualWithinTolerance(t *testing.T) {
+	testCases := map[string]struct {
+		expected, actual float64
+		tolerance        float64
+		useRelativeError bool
+		equal            bool
+	}{
+		"equal values without tolerance":         {expected: 1.5, actual: 1.5, equal: true},
+		"different values without tolerance":     {expected: 1.5, actual: 1.5000001},
+		"NaN values":                             {expected: math.NaN(), actual: math.NaN(), equal: true},
+		"infinite values of the same sign":       {expected: math.Inf(-1), actual: math.Inf(-1), equal: true},
+		"infinite values of different signs":     {expected: math.Inf(1), actual: math.Inf(-1), tolerance: 1},
+		"absolute error within tolerance":        {expected: 100, actual: 100.5, tolerance: 1, equal: true},
+		"absolute error exceeding tolerance":     {expected: 100, actual: 102, tolerance: 1},
+		"relative error within tolerance":        {expected: 100, actual: 101, tolerance: 0.01, useRelativeError: true, equal: true},
+		"relative error exceeding tolerance":     {expected: 100, actual: 102, tolerance: 0.01, useRelativeError: true},
+		"relative error falls back to absolute":  {expected: 0.001, actual: 0, tolerance: 0.01, useRelativeError: true, equal: true},
+		"negative tolerance requires equal bits": {expected: 0, actual: math.Copysign(0, -1), tolerance: -1},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, testCase.equal, EqualWithinTolerance(testCase.expected, testCase.actual, testCase.tolerance, testCase.useRelativeError))
+		})
+	}
+}
@@ -29,34 +29,44 @@ import (
 )
 
 const (
-	MaxSeriesPerMetricFlag                   = "ingester.max-global-series-per-metric"
-	MaxMetadataPerMetricFlag                 = "ingester.max-global-metadata-per-metric"
-	MaxSeriesPerUserFlag                     = "ingester.max-global-series-per-user"
-	MaxMetadataPerUserFlag                   = "ingester.max-global-metadata-per-user"
-	MaxChunksPerQueryFlag                    = "querier.max-fetched-chunks-per-query"
-	MaxChunkBytesPerQueryFlag                = "querier.max-fetched-chunk-bytes-per-query"
-	MaxSeriesPerQueryFlag                    = "querier.max-fetched-series-per-query"
-	MaxEstimatedChunksPerQueryMultiplierFlag = "querier.max-estimated-fetched-chunks-per-query-multiplier"
-	MaxLabelNamesPerSeriesFlag               = "validation.max-label-names-per-series"
-	MaxLabelNameLengthFlag                   = "validation.max-length-label-name"
-	MaxLabelValueLengthFlag                  = "validation.max-length-label-value"
-	MaxMetadataLengthFlag                    = "validation.max-metadata-length"
-	maxNativeHistogramBucketsFlag            = "validation.max-native-histogram-buckets"
-	ReduceNativeHistogramOverMaxBucketsFlag  = "validation.reduce-native-histogram-over-max-buckets"
-	CreationGracePeriodFlag                  = "validation.create-grace-period"
-	MaxPartialQueryLengthFlag                = "querier.max-partial-query-length"
-	MaxTotalQueryLengthFlag                  = "query-frontend.max-total-query-length"
-	MaxQueryExpressionSizeBytesFlag          = "query-frontend.max-query-expression-size-bytes"
-	RequestRateFlag                          = "distributor.request-rate-limit"
-	RequestBurstSizeFlag                     = "distributor.request-burst-size"
-	IngestionRateFlag                        = "distributor.ingestion-rate-limit"
-	IngestionBurstSizeFlag                   = "distributor.ingestion-burst-size"
-	IngestionBurstFactorFlag                 = "distributor.ingestion-burst-factor"
-	HATrackerMaxClustersFlag                 = "distributor.ha-tracker.max-clusters"
-	resultsCacheTTLFlag                      = "query-frontend.results-cache-ttl"
-	resultsCacheTTLForOutOfOrderWindowFlag   = "query-frontend.results-cache-ttl-for-out-of-order-time-window"
-	alignQueriesWithStepFlag                 = "query-frontend.align-queries-with-step"
-	QueryIngestersWithinFlag                 = "querier.query-ingesters-within"
+	MaxSeriesPerMetricFlag                    = "ingester.max-global-series-per-metric"
+	MaxMetadataPerMetricFlag                  = "ingester.max-global-metadata-per-metric"
+	MaxSeriesPerUserFlag                      = "ingester.max-global-series-per-user"
+	MaxMetadataPerUserFlag                    = "ingester.max-global-metadata-per-user"
+	MaxChunksPerQueryFlag                     = "querier.max-fetched-chunks-per-query"
+	MaxChunkBytesPerQueryFlag                 = "querier.max-fetched-chunk-bytes-per-query"
+	MaxSeriesPerQueryFlag                     = "querier.max-fetched-series-per-query"
+	MaxEstimatedChunksPerQueryMultiplierFlag  = "querier.max-estimated-fetched-chunks-per-query-multiplier"
+	MaxEstimatedMemoryConsumptionPerQueryFlag = "querier.max-estimated-memory-consumption-per-query"
+	MaxLabelNamesPerSeriesFlag                = "validation.max-label-names-per-series"
+	MaxLabelNameLengthFlag                    = "validation.max-length-label-name"
+	MaxLabelValueLengthFlag                   = "validation.max-length-label-value"
+	MaxMetadataLengthFlag                     = "validation.max-metadata-length"
+	maxNativeHistogramBucketsFlag             = "validation.max-native-histogram-buckets"
+	ReduceNativeHistogramOverMaxBucketsFlag   = "validation.reduce-native-histogram-over-max-buckets"
+	CreationGracePeriodFlag                   = "validation.create-grace-period"
+	MaxPartialQueryLengthFlag                 = "querier.max-partial-query-length"
+	MaxTotalQueryLengthFlag                   = "query-frontend.max-total-query-length"
+	MaxQueryExpressionSizeBytesFlag           = "query-frontend.max-query-expression-size-bytes"
+	MaxEstimatedQueryCostFlag                 = "query-frontend.max-estimated-query-cost"
+	EstimatedQueryCostBudgetPerMinuteFlag     = "query-frontend.estimated-query-cost-budget-per-minute"
+	estimatedQueryCostLimitActionFlag         = "query-frontend.estimated-query-cost-limit-action"
+	RequestRateFlag                           = "distributor.request-rate-limit"
+	RequestBurstSizeFlag                      = "distributor.request-burst-size"
+	IngestionRateFlag                         = "distributor.ingestion-rate-limit"
+	IngestionBurstSizeFlag                    = "distributor.ingestion-burst-size"
+	IngestionBurstFactorFlag                  = "distributor.ingestion-burst-factor"
+	HATrackerMaxClustersFlag                  = "distributor.ha-tracker.max-clusters"
+	resultsCacheTTLFlag                       = "query-frontend.results-cache-ttl"
+	resultsCacheTTLForOutOfOrderWindowFlag    = "query-frontend.results-cache-ttl-for-out-of-order-time-window"
+	alignQueriesWithStepFlag                  = "query-frontend.align-queries-with-step"
+	QueryIngestersWithinFlag                  = "querier.query-ingesters-within"
+
+	// EstimatedQueryCostLimitActionReject rejects the queries exceeding the estimated query cost limits.
+	EstimatedQueryCostLimitActionReject = "reject"
+	// EstimatedQueryCostLimitActionDeprioritize runs the queries exceeding the estimated query cost limits with lower
+	// priority.
+	EstimatedQueryCostLimitActionDeprioritize = "deprioritize"
 
 	// MinCompactorPartialBlockDeletionDelay is the minimum partial blocks deletion delay that can be configured in Mimir.
 	MinCompactorPartialBlockDeletionDelay = 4 * time.Hour
@@ -65,8 +75,12 @@ const (
 var (
 	errInvalidIngestStorageReadConsistency         = fmt.Errorf("invalid ingest storage read consistency (supported values: %s)", strings.Join(api.ReadConsistencies, ", "))
 	errInvalidMaxEstimatedChunksPerQueryMultiplier = errors.New("invalid value for -" + MaxEstimatedChunksPerQueryMultiplierFlag + ": must be 0 or greater than or equal to 1")
+	errInvalidEstimatedQueryCostLimitAction        = fmt.Errorf("invalid value for -%s (supported values: %s)", estimatedQueryCostLimitActionFlag, strings.Join(EstimatedQueryCostLimitActions, ", "))
 )
 
+// EstimatedQueryCostLimitActions is the list of supported values for the estimated query cost limit action.
+var EstimatedQueryCostLimitActions = []string{EstimatedQueryCostLimitActionReject, EstimatedQueryCostLimitActionDeprioritize}
+
 // LimitError is a marker interface for the errors that do not comply with the specified limits.
 type LimitError interface {
 	error
@@ -140,32 +154,39 @@ type Limits struct {
 	SeparateMetricsGroupLabel string `yaml:"separate_metrics_group_label" json:"separate_metrics_group_label" category:"experimental"`
 
 	// Querier enforced limits.
-	MaxChunksPerQuery                    int            `yaml:"max_fetched_chunks_per_query" json:"max_fetched_chunks_per_query"`
-	MaxEstimatedChunksPerQueryMultiplier float64        `yaml:"max_estimated_fetched_chunks_per_query_multiplier" json:"max_estimated_fetched_chunks_per_query_multiplier" category:"experimental"`
-	MaxFetchedSeriesPerQuery             int            `yaml:"max_fetched_series_per_query" json:"max_fetched_series_per_query"`
-	MaxFetchedChunkBytesPerQuery         int            `yaml:"max_fetched_chunk_bytes_per_query" json:"max_fetched_chunk_bytes_per_query"`
-	MaxQueryLookback                     model.Duration `yaml:"max_query_lookback" json:"max_query_lookback"`
-	MaxPartialQueryLength                model.Duration `yaml:"max_partial_query_length" json:"max_partial_query_length"`
-	MaxQueryParallelism                  int            `yaml:"max_query_parallelism" json:"max_query_parallelism"`
-	MaxLabelsQueryLength                 model.Duration `yaml:"max_labels_query_length" json:"max_labels_query_length"`
-	MaxCacheFreshness                    model.Duration `yaml:"max_cache_freshness" json:"max_cache_freshness" category:"advanced"`
-	MaxQueriersPerTenant                 int            `yaml:"max_queriers_per_tenant" json:"max_queriers_per_tenant"`
-	QueryShardingTotalShards             int            `yaml:"query_sharding_total_shards" json:"query_sharding_total_shards"`
-	QueryShardingMaxShardedQueries       int            `yaml:"query_sharding_max_sharded_queries" json:"query_sharding_max_sharded_queries"`
-	QueryShardingMaxRegexpSizeBytes      int            `yaml:"query_sharding_max_regexp_size_bytes" json:"query_sharding_max_regexp_size_bytes"`
-	SplitInstantQueriesByInterval        model.Duration `yaml:"split_instant_queries_by_interval" json:"split_instant_queries_by_interval" category:"experimental"`
-	QueryIngestersWithin                 model.Duration `yaml:"query_ingesters_within" json:"query_ingesters_within" category:"advanced"`
+	MaxChunksPerQuery                     int            `yaml:"max_fetched_chunks_per_query" json:"max_fetched_chunks_per_query"`
+	MaxEstimatedChunksPerQueryMultiplier  float64        `yaml:"max_estimated_fetched_chunks_per_query_multiplier" json:"max_estimated_fetched_chunks_per_query_multiplier" category:"experimental"`
+	MaxFetchedSeriesPerQuery              int            `yaml:"max_fetched_series_per_query" json:"max_fetched_series_per_query"`
+	MaxFetchedChunkBytesPerQuery          int            `yaml:"max_fetched_chunk_bytes_per_query" json:"max_fetched_chunk_bytes_per_query"`
+	MaxEstimatedMemoryConsumptionPerQuery uint64         `yaml:"max_estimated_memory_consumption_per_query" json:"max_estimated_memory_consumption_per_query" category:"experimental"`
+	MaxQueryLookback                      model.Duration `yaml:"max_query_lookback" json:"max_query_lookback"`
+	MaxPartialQueryLength                 model.Duration `yaml:"max_partial_query_length" json:"max_partial_query_length"`
+	MaxQueryParallelism                   int            `yaml:"max_query_parallelism" json:"max_query_parallelism"`
+	MaxLabelsQueryLength                  model.Duration `yaml:"max_labels_query_length" json:"max_labels_query_length"`
+	MaxCacheFreshness                     model.Duration `yaml:"max_cache_freshness" json:"max_cache_freshness" category:"advanced"`
+	MaxQueriersPerTenant                  int            `yaml:"max_queriers_per_tenant" json:"max_queriers_per_tenant"`
+	QueryShardingTotalShards              int            `yaml:"query_sharding_total_shards" json:"query_sharding_total_shards"`
+	QueryShardingMaxShardedQueries        int            `yaml:"query_sharding_max_sharded_queries" json:"query_sharding_max_sharded_queries"`
+	QueryShardingMaxRegexpSizeBytes       int            `yaml:"query_sharding_max_regexp_size_bytes" json:"query_sharding_max_regexp_size_bytes"`
+	SplitInstantQueriesByInterval         model.Duration `yaml:"split_instant_queries_by_interval" json:"split_instant_queries_by_interval" category:"experimental"`
+	QueryIngestersWithin                  model.Duration `yaml:"query_ingesters_within" json:"query_ingesters_within" category:"advanced"`
 
 	// Query-frontend limits.
-	MaxTotalQueryLength                    model.Duration  `yaml:"max_total_query_length" json:"max_total_query_length"`
-	ResultsCacheTTL                        model.Duration  `yaml:"results_cache_ttl" json:"results_cache_ttl"`
-	ResultsCacheTTLForOutOfOrderTimeWindow model.Duration  `yaml:"results_cache_ttl_for_out_of_order_time_window" json:"results_cache_ttl_for_out_of_order_time_window"`
-	ResultsCacheTTLForCardinalityQuery     model.Duration  `yaml:"results_cache_ttl_for_cardinality_query" json:"results_cache_ttl_for_cardinality_query"`
-	ResultsCacheTTLForLabelsQuery          model.Duration  `yaml:"results_cache_ttl_for_labels_query" json:"results_cache_ttl_for_labels_query"`
-	ResultsCacheForUnalignedQueryEnabled   bool            `yaml:"cache_unaligned_requests" json:"cache_unaligned_requests" category:"advanced"`
-	MaxQueryExpressionSizeBytes            int             `yaml:"max_query_expression_size_bytes" json:"max_query_expression_size_bytes"`
-	BlockedQueries                         []*BlockedQuery `yaml:"blocked_queries,omitempty" json:"blocked_queries,omitempty" doc:"nocli|description=List of queries to block." category:"experimental"`
-	AlignQueriesWithStep                   bool            `yaml:"align_queries_with_step" json:"align_queries_with_step"`
+	MaxTotalQueryLength                    model.Duration          `yaml:"max_total_query_length" json:"max_total_query_length"`
+	ResultsCacheTTL                        model.Duration          `yaml:"results_cache_ttl" json:"results_cache_ttl"`
+	ResultsCacheTTLForOutOfOrderTimeWindow model.Duration          `yaml:"results_cache_ttl_for_out_of_order_time_window" json:"results_cache_ttl_for_out_of_order_time_window"`
+	ResultsCacheTTLForCardinalityQuery     model.Duration          `yaml:"results_cache_ttl_for_cardinality_query" json:"results_cache_ttl_for_cardinality_query"`
+	ResultsCacheTTLForLabelsQuery          model.Duration          `yaml:"results_cache_ttl_for_labels_query" json:"results_cache_ttl_for_labels_query"`
+	ResultsCacheTTLForSeriesQuery          model.Duration          `yaml:"results_cache_ttl_for_series_query" json:"results_cache_ttl_for_series_query" category:"experimental"`
+	ResultsCacheForUnalignedQueryEnabled   bool                    `yaml:"cache_unaligned_requests" json:"cache_unaligned_requests" category:"advanced"`
+	MaxQueryExpressionSizeBytes            int                     `yaml:"max_query_expression_size_bytes" json:"max_query_expression_size_bytes"`
+	MaxEstimatedQueryCost                  uint64                  `yaml:"max_estimated_query_cost" json:"max_estimated_query_cost" category:"experimental"`
+	EstimatedQueryCostBudgetPerMinute      uint64                  `yaml:"estimated_query_cost_budget_per_minute" json:"estimated_query_cost_budget_per_minute" category:"experimental"`
+	EstimatedQueryCostLimitAction          string                  `yaml:"estimated_query_cost_limit_action" json:"estimated_query_cost_limit_action" category:"experimental"`
+	BlockedQueries                         []*BlockedQuery         `yaml:"blocked_queries,omitempty" json:"blocked_queries,omitempty" doc:"nocli|description=List of queries to block." category:"experimental"`
+	QueryRewriteRules                      []*QueryRewriteRule     `yaml:"query_rewrite_rules,omitempty" json:"query_rewrite_rules,omitempty" doc:"nocli|description=List of rules rewriting the range and instant queries of the tenant. Each rule replaces the queries matching its pattern, either exactly or as a regular expression, with its replacement, which can reference the capture groups of a regular expression. The first matching rule applies. Queries spanning multiple tenants are only rewritten if the rules of all the tenants rewrite them the same way." category:"experimental"`
+	AlignQueriesWithStep                   bool                    `yaml:"align_queries_with_step" json:"align_queries_with_step"`
+	QueryPriorityMatchers                  []*QueryPriorityMatcher `yaml:"query_priority_matchers,omitempty" json:"query_priority_matchers,omitempty" doc:"nocli|description=List of rules assigning a priority to the queries of the tenant that don't have the X-Query-Priority header. Each rule matches a request header against a regular expression, and the first matching rule applies. The query-scheduler dequeues queries by priority when -query-scheduler.query-priority-weights is set." category:"experimental"`
 
 	// Cardinality
 	CardinalityAnalysisEnabled                    bool `yaml:"cardinality_analysis_enabled" json:"cardinality_analysis_enabled"`
@@ -217,7 +238,14 @@ type Limits struct {
 	AlertmanagerMaxAlertsSizeBytes             int `yaml:"alertmanager_max_alerts_size_bytes" json:"alertmanager_max_alerts_size_bytes"`
 
 	// OpenTelemetry
-	OTelMetricSuffixesEnabled bool `yaml:"otel_metric_suffixes_enabled" json:"otel_metric_suffixes_enabled" category:"advanced"`
+	OTelMetricSuffixesEnabled           bool                   `yaml:"otel_metric_suffixes_enabled" json:"otel_metric_suffixes_enabled" category:"advanced"`
+	PromoteOTelResourceAttributes       flagext.StringSliceCSV `yaml:"promote_otel_resource_attributes" json:"promote_otel_resource_attributes" category:"experimental"`
+	OTelConvertDeltaToCumulativeEnabled bool                   `yaml:"otel_convert_delta_to_cumulative_enabled" json:"otel_convert_delta_to_cumulative_enabled" category:"experimental"`
+	OTelDeltaToCumulativeMaxStreams     int                    `yaml:"otel_delta_to_cumulative_max_streams" json:"otel_delta_to_cumulative_max_streams" category:"experimental"`
+
+	// Influx line protocol and Datadog
+	InfluxMetricSuffixesEnabled  bool `yaml:"influx_metric_suffixes_enabled" json:"influx_metric_suffixes_enabled" category:"experimental"`
+	DatadogMetricSuffixesEnabled bool `yaml:"datadog_metric_suffixes_enabled" json:"datadog_metric_suffixes_enabled" category:"experimental"`
 
 	// Ingest storage.
 	IngestStorageReadConsistency       string `yaml:"ingest_storage_read_consistency" json:"ingest_storage_read_consistency" category:"experimental" doc:"hidden"`
@@ -252,6 +280,11 @@ func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 	f.BoolVar(&l.MetricRelabelingEnabled, "distributor.metric-relabeling-enabled", true, "Enable metric relabeling for the tenant. This configuration option can be used to forcefully disable metric relabeling on a per-tenant basis.")
 	f.BoolVar(&l.ServiceOverloadStatusCodeOnRateLimitEnabled, "distributor.service-overload-status-code-on-rate-limit-enabled", false, "If enabled, rate limit errors will be reported to the client with HTTP status code 529 (Service is overloaded). If disabled, status code 429 (Too Many Requests) is used. Enabling -distributor.retry-after-header.enabled before utilizing this option is strongly recommended as it helps prevent premature request retries by the client.")
 	f.BoolVar(&l.OTelMetricSuffixesEnabled, "distributor.otel-metric-suffixes-enabled", false, "Whether to enable automatic suffixes to names of metrics ingested through OTLP.")
+	f.Var(&l.PromoteOTelResourceAttributes, "distributor.otel-promote-resource-attributes", "Comma-separated list of OTel resource attributes to promote to labels of the metrics ingested through OTLP. The resource attributes are still added to the target_info metric.")
+	f.BoolVar(&l.OTelConvertDeltaToCumulativeEnabled, "distributor.otel-convert-delta-to-cumulative-enabled", false, "Whether to convert OTLP sums and histograms with delta temporality to cumulative, keeping the running totals of each stream in the distributor receiving it. The delta data points of a stream must all be sent to the same distributor to be converted correctly. When disabled, metrics with delta temporality are discarded.")
+	f.IntVar(&l.OTelDeltaToCumulativeMaxStreams, "distributor.otel-delta-to-cumulative-max-streams", 100000, "Maximum number of OTLP delta streams whose running totals are kept by each distributor for a tenant, when the conversion of delta temporality to cumulative is enabled. The data points of new streams exceeding the limit are discarded. 0 to disable.")
+	f.BoolVar(&l.InfluxMetricSuffixesEnabled, "distributor.influx-metric-suffixes-enabled", false, "Whether to add the field name as a suffix to the measurement name of metrics ingested through the Influx line protocol also when the field is named \"value\". When disabled, fields named \"value\" are ingested with the measurement name.")
+	f.BoolVar(&l.DatadogMetricSuffixesEnabled, "distributor.datadog-metric-suffixes-enabled", false, "Whether to add the unit as a suffix to the names of metrics ingested through the Datadog series API.")
 
 	f.IntVar(&l.MaxGlobalSeriesPerUser, MaxSeriesPerUserFlag, 150000, "The maximum number of in-memory series per tenant, across the cluster before replication. 0 to disable.")
 	f.IntVar(&l.MaxGlobalSeriesPerMetric, MaxSeriesPerMetricFlag, 0, "The maximum number of in-memory series per metric name, across the cluster before replication. 0 to disable.")
@@ -270,6 +303,7 @@ func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 	f.Float64Var(&l.MaxEstimatedChunksPerQueryMultiplier, MaxEstimatedChunksPerQueryMultiplierFlag, 0, "Maximum number of chunks estimated to be fetched in a single query from ingesters and store-gateways, as a multiple of -"+MaxChunksPerQueryFlag+". This limit is enforced in the querier. Must be greater than or equal to 1, or 0 to disable.")
 	f.IntVar(&l.MaxFetchedSeriesPerQuery, MaxSeriesPerQueryFlag, 0, "The maximum number of unique series for which a query can fetch samples from ingesters and store-gateways. This limit is enforced in the querier, ruler and store-gateway. 0 to disable")
 	f.IntVar(&l.MaxFetchedChunkBytesPerQuery, MaxChunkBytesPerQueryFlag, 0, "The maximum size of all chunks in bytes that a query can fetch from ingesters and store-gateways. This limit is enforced in the querier and ruler. 0 to disable.")
+	f.Uint64Var(&l.MaxEstimatedMemoryConsumptionPerQuery, MaxEstimatedMemoryConsumptionPerQueryFlag, 0, "The maximum estimated memory a single query can consume at once, in bytes. This limit is only enforced when the streaming PromQL engine is in use, and is enforced in the querier. 0 to disable.")
 	f.Var(&l.MaxPartialQueryLength, MaxPartialQueryLengthFlag, "Limit the time range for partial queries at the querier level.")
 	f.Var(&l.MaxQueryLookback, "querier.max-query-lookback", "Limit how long back data (series and metadata) can be queried, up until <lookback> duration ago. This limit is enforced in the query-frontend, querier and ruler. If the requested time range is outside the allowed range, the request will not fail but will be manipulated to only query data within the allowed time range. 0 to disable.")
 	f.IntVar(&l.MaxQueryParallelism, "querier.max-query-parallelism", 14, "Maximum number of split (by time) or partial (by shard) queries that will be scheduled in parallel by the query-frontend for a single input query. This limit is introduced to have a fairer query scheduling and avoid a single query over a large time range saturating all available queriers.")
@@ -317,8 +351,12 @@ func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 	f.Var(&l.ResultsCacheTTLForOutOfOrderTimeWindow, resultsCacheTTLForOutOfOrderWindowFlag, fmt.Sprintf("Time to live duration for cached query results if query falls into out-of-order time window. This is lower than -%s so that incoming out-of-order samples are returned in the query results sooner.", resultsCacheTTLFlag))
 	f.Var(&l.ResultsCacheTTLForCardinalityQuery, "query-frontend.results-cache-ttl-for-cardinality-query", "Time to live duration for cached cardinality query results. The value 0 disables the cache.")
 	f.Var(&l.ResultsCacheTTLForLabelsQuery, "query-frontend.results-cache-ttl-for-labels-query", "Time to live duration for cached label names and label values query results. The value 0 disables the cache.")
+	f.Var(&l.ResultsCacheTTLForSeriesQuery, "query-frontend.results-cache-ttl-for-series-query", "Time to live duration for cached series query results. The value 0 disables the cache.")
 	f.BoolVar(&l.ResultsCacheForUnalignedQueryEnabled, "query-frontend.cache-unaligned-requests", false, "Cache requests that are not step-aligned.")
 	f.IntVar(&l.MaxQueryExpressionSizeBytes, MaxQueryExpressionSizeBytesFlag, 0, "Max size of the raw query, in bytes. 0 to not apply a limit to the size of the query.")
+	f.Uint64Var(&l.MaxEstimatedQueryCost, MaxEstimatedQueryCostFlag, 0, "Maximum estimated cost of a range or instant query. The estimated cost is the estimated number of series selected by the query, or the number of selectors if greater, multiplied by the number of evaluation steps plus the number of minutes of data selected. Series estimates are only available when -query-frontend.query-sharding-target-series-per-shard is set: otherwise, each selector is assumed to select a single series. This limit is enforced in the query-frontend. 0 to disable.")
+	f.Uint64Var(&l.EstimatedQueryCostBudgetPerMinute, EstimatedQueryCostBudgetPerMinuteFlag, 0, "Maximum sum of the estimated cost of the range and instant queries run in the last minute. Each query-frontend tracks the budget separately. This limit is enforced in the query-frontend. 0 to disable.")
+	f.StringVar(&l.EstimatedQueryCostLimitAction, estimatedQueryCostLimitActionFlag, EstimatedQueryCostLimitActionReject, fmt.Sprintf("What to do with queries exceeding -%s or -%s. Deprioritized queries are enqueued in the query-scheduler with the \"%s\" query priority, which must be listed in -query-scheduler.query-priority-weights with a weight lower than the \"default\" query priority, for example default:2,%s:1. Deprioritized queries are not charged to -%s. Supported values: %s.", MaxEstimatedQueryCostFlag, EstimatedQueryCostBudgetPerMinuteFlag, api.DeprioritizedQueryPriority, api.DeprioritizedQueryPriority, EstimatedQueryCostBudgetPerMinuteFlag, strings.Join(EstimatedQueryCostLimitActions, ", ")))
 	f.BoolVar(&l.AlignQueriesWithStep, alignQueriesWithStepFlag, false, "Mutate incoming queries to align their start and end with their step to improve result caching.")
 
 	// Store-gateway.
@@ -415,6 +453,28 @@ func (l *Limits) validate() error {
 		return errInvalidIngestStorageReadConsistency
 	}
 
+	if !util.StringsContain(EstimatedQueryCostLimitActions, l.EstimatedQueryCostLimitAction) {
+		return errInvalidEstimatedQueryCostLimitAction
+	}
+
+	for _, r := range l.QueryRewriteRules {
+		if r == nil {
+			return errors.New("invalid query_rewrite_rules")
+		}
+		if err := r.compile(); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range l.QueryPriorityMatchers {
+		if m == nil {
+			return errors.New("invalid query_priority_matchers")
+		}
+		if err := m.compile(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -604,6 +664,11 @@ func (o *Overrides) MaxFetchedChunkBytesPerQuery(userID string) int {
 	return o.getOverridesForUser(userID).MaxFetchedChunkBytesPerQuery
 }
 
+// MaxEstimatedMemoryConsumptionPerQuery returns the maximum estimated memory a single query can consume at once, in bytes.
+func (o *Overrides) MaxEstimatedMemoryConsumptionPerQuery(userID string) uint64 {
+	return o.getOverridesForUser(userID).MaxEstimatedMemoryConsumptionPerQuery
+}
+
 // MaxQueryLookback returns the max lookback period of queries.
 func (o *Overrides) MaxQueryLookback(userID string) time.Duration {
 	return time.Duration(o.getOverridesForUser(userID).MaxQueryLookback)
@@ -624,6 +689,32 @@ func (o *Overrides) MaxQueryExpressionSizeBytes(userID string) int {
 	return o.getOverridesForUser(userID).MaxQueryExpressionSizeBytes
 }
 
+// MaxEstimatedQueryCost returns the limit to the estimated cost of a query. 0 means "unlimited".
+func (o *Overrides) MaxEstimatedQueryCost(userID string) uint64 {
+	return o.getOverridesForUser(userID).MaxEstimatedQueryCost
+}
+
+// EstimatedQueryCostBudgetPerMinute returns the limit to the estimated cost of the queries run in the last minute.
+// 0 means "unlimited".
+func (o *Overrides) EstimatedQueryCostBudgetPerMinute(userID string) uint64 {
+	return o.getOverridesForUser(userID).EstimatedQueryCostBudgetPerMinute
+}
+
+// EstimatedQueryCostLimitAction returns what to do with queries exceeding the estimated query cost limits.
+func (o *Overrides) EstimatedQueryCostLimitAction(userID string) string {
+	return o.getOverridesForUser(userID).EstimatedQueryCostLimitAction
+}
+
+// QueryPriorityMatchers returns the rules assigning a query priority to the queries of the tenant.
+func (o *Overrides) QueryPriorityMatchers(userID string) []*QueryPriorityMatcher {
+	return o.getOverridesForUser(userID).QueryPriorityMatchers
+}
+
+// QueryRewriteRules returns the rules rewriting the queries of the tenant.
+func (o *Overrides) QueryRewriteRules(userID string) []*QueryRewriteRule {
+	return o.getOverridesForUser(userID).QueryRewriteRules
+}
+
 // BlockedQueries returns the blocked queries.
 func (o *Overrides) BlockedQueries(userID string) []*BlockedQuery {
 	return o.getOverridesForUser(userID).BlockedQueries
@@ -958,6 +1049,10 @@ func (o *Overrides) ResultsCacheTTLForLabelsQuery(user string) time.Duration {
 	return time.Duration(o.getOverridesForUser(user).ResultsCacheTTLForLabelsQuery)
 }
 
+func (o *Overrides) ResultsCacheTTLForSeriesQuery(user string) time.Duration {
+	return time.Duration(o.getOverridesForUser(user).ResultsCacheTTLForSeriesQuery)
+}
+
 func (o *Overrides) ResultsCacheForUnalignedQueryEnabled(userID string) bool {
 	return o.getOverridesForUser(userID).ResultsCacheForUnalignedQueryEnabled
 }
@@ -966,6 +1061,26 @@ func (o *Overrides) OTelMetricSuffixesEnabled(tenantID string) bool {
 	return o.getOverridesForUser(tenantID).OTelMetricSuffixesEnabled
 }
 
+func (o *Overrides) PromoteOTelResourceAttributes(tenantID string) []string {
+	return o.getOverridesForUser(tenantID).PromoteOTelResourceAttributes
+}
+
+func (o *Overrides) OTelConvertDeltaToCumulativeEnabled(tenantID string) bool {
+	return o.getOverridesForUser(tenantID).OTelConvertDeltaToCumulativeEnabled
+}
+
+func (o *Overrides) OTelDeltaToCumulativeMaxStreams(tenantID string) int {
+	return o.getOverridesForUser(tenantID).OTelDeltaToCumulativeMaxStreams
+}
+
+func (o *Overrides) InfluxMetricSuffixesEnabled(tenantID string) bool {
+	return o.getOverridesForUser(tenantID).InfluxMetricSuffixesEnabled
+}
+
+func (o *Overrides) DatadogMetricSuffixesEnabled(tenantID string) bool {
+	return o.getOverridesForUser(tenantID).DatadogMetricSuffixesEnabled
+}
+
 func (o *Overrides) AlignQueriesWithStep(userID string) bool {
 	return o.getOverridesForUser(userID).AlignQueriesWithStep
 }
@@ -1032,6 +1147,24 @@ func SmallestPositiveNonZeroIntPerTenant(tenantIDs []string, f func(string) int)
 	return *result
 }
 
+// SmallestPositiveNonZeroUint64PerTenant is returning the minimal positive and
+// non-zero value of the supplied limit function for all given tenants. In many
+// limits a value of 0 means unlimited so the method will return 0 only if all
+// inputs have a limit of 0 or an empty tenant list is given.
+func SmallestPositiveNonZeroUint64PerTenant(tenantIDs []string, f func(string) uint64) uint64 {
+	var result *uint64
+	for _, tenantID := range tenantIDs {
+		v := f(tenantID)
+		if v > 0 && (result == nil || v < *result) {
+			result = &v
+		}
+	}
+	if result == nil {
+		return 0
+	}
+	return *result
+}
+
 // SmallestPositiveNonZeroDurationPerTenant is returning the minimal positive
 // and non-zero value of the supplied limit function for all given tenants. In
 // many limits a value of 0 means unlimited so the method will return 0 only if
@@ -232,6 +232,38 @@ func TestSmallestPositiveNonZeroIntPerTenant(t *testing.T) {
 	}
 }
 
+func TestSmallestPositiveNonZeroUint64PerTenant(t *testing.T) {
+	tenantLimits := map[string]*Limits{
+		"tenant-a": {
+			MaxEstimatedMemoryConsumptionPerQuery: 5,
+		},
+		"tenant-b": {
+			MaxEstimatedMemoryConsumptionPerQuery: 10,
+		},
+	}
+
+	defaults := Limits{
+		MaxEstimatedMemoryConsumptionPerQuery: 0,
+	}
+	ov, err := NewOverrides(defaults, NewMockTenantLimits(tenantLimits))
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		tenantIDs []string
+		expLimit  uint64
+	}{
+		{tenantIDs: []string{}, expLimit: 0},
+		{tenantIDs: []string{"tenant-a"}, expLimit: 5},
+		{tenantIDs: []string{"tenant-b"}, expLimit: 10},
+		{tenantIDs: []string{"tenant-c"}, expLimit: 0},
+		{tenantIDs: []string{"tenant-a", "tenant-b"}, expLimit: 5},
+		{tenantIDs: []string{"tenant-c", "tenant-d", "tenant-e"}, expLimit: 0},
+		{tenantIDs: []string{"tenant-a", "tenant-b", "tenant-c"}, expLimit: 5},
+	} {
+		assert.Equal(t, tc.expLimit, SmallestPositiveNonZeroUint64PerTenant(tc.tenantIDs, ov.MaxEstimatedMemoryConsumptionPerQuery))
+	}
+}
+
 func TestSmallestPositiveNonZeroDurationPerTenant(t *testing.T) {
 	tenantLimits := map[string]*Limits{
 		"tenant-a": {
@@ -670,6 +702,61 @@ metric_relabel_configs:
 			cfg:         `ingest_storage_read_consistency: xyz`,
 			expectedErr: errInvalidIngestStorageReadConsistency.Error(),
 		},
+		"should fail on invalid query_priority_matchers regex": {
+			cfg: `
+query_priority_matchers:
+  - priority: ruler
+    header: User-Agent
+    regex: "("
+`,
+			expectedErr: "invalid regex \"(\" in query_priority_matchers",
+		},
+		"should fail on invalid query_rewrite_rules regex": {
+			cfg: `
+query_rewrite_rules:
+  - pattern: "("
+    regex: true
+    replacement: up
+`,
+			expectedErr: "invalid regex \"(\" in query_rewrite_rules",
+		},
+		"should fail on query_rewrite_rules replacement referencing a missing capture group": {
+			cfg: `
+query_rewrite_rules:
+  - pattern: "sum by \\((\\w+)\\) \\(up\\)"
+    regex: true
+    replacement: "max by ($1, $2) (up)"
+`,
+			expectedErr: "reference to missing capture group $2",
+		},
+		"should fail on invalid query_rewrite_rules replacement": {
+			cfg: `
+query_rewrite_rules:
+  - pattern: up
+    replacement: "sum("
+`,
+			expectedErr: "invalid replacement \"sum(\" in query_rewrite_rules",
+		},
+		"should pass on valid query_rewrite_rules": {
+			cfg: `
+query_rewrite_rules:
+  - pattern: up
+    replacement: max(up)
+  - pattern: "sum by \\((?P<label>\\w+)\\) \\(up\\)"
+    regex: true
+    replacement: "max by ($1, ${label}) (up) * $$"
+`,
+			expectedErr: "",
+		},
+		"should pass on valid query_priority_matchers": {
+			cfg: `
+query_priority_matchers:
+  - priority: ruler
+    header: User-Agent
+    regex: "mimir-ruler/.*"
+`,
+			expectedErr: "",
+		},
 	}
 
 	for testName, testData := range tests {
@@ -686,6 +773,23 @@ metric_relabel_configs:
 	}
 }
 
+func TestQueryPriorityMatchersLoadingFromYaml(t *testing.T) {
+	inp := `
+query_priority_matchers:
+  - priority: ruler
+    header: User-Agent
+    regex: "mimir-ruler/.*"
+`
+	l := Limits{}
+	dec := yaml.NewDecoder(strings.NewReader(inp))
+	dec.KnownFields(true)
+	require.NoError(t, dec.Decode(&l))
+
+	require.Len(t, l.QueryPriorityMatchers, 1)
+	assert.True(t, l.QueryPriorityMatchers[0].Matches("mimir-ruler/2.12.0"))
+	assert.False(t, l.QueryPriorityMatchers[0].Matches("Grafana/11.0.0"))
+}
+
 func TestUnmarshalJSON_ShouldValidateConfig(t *testing.T) {
 	tests := map[string]struct {
 		cfg         string
@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package validation
+
+import (
+	"fmt"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// QueryPriorityMatcher assigns a query priority to the queries having a request header matching a regular expression.
+type QueryPriorityMatcher struct {
+	Priority string `yaml:"priority"`
+	Header   string `yaml:"header"`
+	Regex    string `yaml:"regex"`
+
+	// matcher is the compiled Regex, set when the limits are loaded.
+	matcher *labels.FastRegexMatcher
+}
+
+// NewQueryPriorityMatcher returns a QueryPriorityMatcher with its regular expression compiled, or an error if the
+// regular expression is invalid.
+func NewQueryPriorityMatcher(priority, header, regex string) (*QueryPriorityMatcher, error) {
+	m := &QueryPriorityMatcher{Priority: priority, Header: header, Regex: regex}
+	if err := m.compile(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *QueryPriorityMatcher) compile() error {
+	matcher, err := labels.NewFastRegexMatcher(m.Regex)
+	if err != nil {
+		return fmt.Errorf("invalid regex %q in query_priority_matchers: %w", m.Regex, err)
+	}
+	m.matcher = matcher
+	return nil
+}
+
+// Matches returns whether the value of the request header matches the regular expression. It never matches if the
+// regular expression hasn't been compiled, either by loading the limits or by NewQueryPriorityMatcher.
+func (m *QueryPriorityMatcher) Matches(headerValue string) bool {
+	return m.matcher != nil && m.matcher.MatchString(headerValue)
+}
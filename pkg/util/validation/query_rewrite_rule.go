@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// QueryRewriteRule replaces the queries matching a pattern with another query.
+type QueryRewriteRule struct {
+	Pattern     string `yaml:"pattern"`
+	Regex       bool   `yaml:"regex"`
+	Replacement string `yaml:"replacement"`
+
+	// regex is the compiled Pattern of regular expression rules, set when the limits are loaded.
+	regex *regexp.Regexp
+}
+
+// NewQueryRewriteRule returns a QueryRewriteRule with its pattern compiled, or an error if the pattern or the
+// replacement is invalid.
+func NewQueryRewriteRule(pattern string, regex bool, replacement string) (*QueryRewriteRule, error) {
+	r := &QueryRewriteRule{Pattern: pattern, Regex: regex, Replacement: replacement}
+	if err := r.compile(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *QueryRewriteRule) compile() error {
+	if !r.Regex {
+		if _, err := parser.ParseExpr(r.Replacement); err != nil {
+			return fmt.Errorf("invalid replacement %q in query_rewrite_rules: %w", r.Replacement, err)
+		}
+		return nil
+	}
+
+	regex, err := regexp.Compile("^(?:" + r.Pattern + ")$")
+	if err != nil {
+		return fmt.Errorf("invalid regex %q in query_rewrite_rules: %w", r.Pattern, err)
+	}
+	if err := checkCaptureGroupReferences(regex, r.Replacement); err != nil {
+		return fmt.Errorf("invalid replacement %q in query_rewrite_rules: %w", r.Replacement, err)
+	}
+	r.regex = regex
+	return nil
+}
+
+// Rewrite returns the query rewritten by the rule, or false if the rule doesn't match the query. Rules without a
+// regular expression match the queries equal to their pattern, ignoring the leading and trailing spaces. Rules with
+// a regular expression never match if the regular expression hasn't been compiled, either by loading the limits or
+// by NewQueryRewriteRule.
+func (r *QueryRewriteRule) Rewrite(query string) (string, bool) {
+	if !r.Regex {
+		if strings.TrimSpace(r.Pattern) != strings.TrimSpace(query) {
+			return "", false
+		}
+		return r.Replacement, true
+	}
+
+	if r.regex == nil || !r.regex.MatchString(query) {
+		return "", false
+	}
+	return r.regex.ReplaceAllString(query, r.Replacement), true
+}
+
+// checkCaptureGroupReferences returns an error if the template references a capture group the regular expression
+// doesn't have. The references follow the syntax of regexp.Regexp.Expand.
+func checkCaptureGroupReferences(regex *regexp.Regexp, template string) error {
+	for i := 0; i < len(template); i++ {
+		if template[i] != '$' {
+			continue
+		}
+		i++
+		if i < len(template) && template[i] == '$' {
+			continue
+		}
+
+		var name string
+		if i < len(template) && template[i] == '{' {
+			end := strings.IndexByte(template[i:], '}')
+			if end < 0 {
+				// Not a reference, expanded as is.
+				continue
+			}
+			name = template[i+1 : i+end]
+			i += end
+		} else {
+			start := i
+			for i < len(template) && isCaptureGroupNameChar(template[i]) {
+				i++
+			}
+			name = template[start:i]
+			i--
+		}
+		if !isCaptureGroupName(name) {
+			// Not a reference, expanded as is.
+			continue
+		}
+
+		if index, err := strconv.Atoi(name); err == nil {
+			if index > regex.NumSubexp() {
+				return fmt.Errorf("reference to missing capture group $%s", name)
+			}
+		} else if regex.SubexpIndex(name) < 0 {
+			return fmt.Errorf("reference to missing capture group $%s", name)
+		}
+	}
+	return nil
+}
+
+func isCaptureGroupName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isCaptureGroupNameChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isCaptureGroupNameChar(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
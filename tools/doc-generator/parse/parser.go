@@ -355,6 +355,10 @@ func getFieldCustomType(t reflect.Type) (string, bool) {
 		return "relabel_config...", true
 	case reflect.TypeOf([]*validation.BlockedQuery{}).String():
 		return "blocked_queries_config...", true
+	case reflect.TypeOf([]*validation.QueryPriorityMatcher{}).String():
+		return "query_priority_matchers_config...", true
+	case reflect.TypeOf([]*validation.QueryRewriteRule{}).String():
+		return "query_rewrite_rules_config...", true
 	case reflect.TypeOf(activeseries.CustomTrackersConfig{}).String():
 		return "map of tracker name (string) to matcher (string)", true
 	default:
@@ -437,6 +441,10 @@ func getCustomFieldType(t reflect.Type) (string, bool) {
 		return "relabel_config...", true
 	case reflect.TypeOf([]*validation.BlockedQuery{}).String():
 		return "blocked_queries_config...", true
+	case reflect.TypeOf([]*validation.QueryPriorityMatcher{}).String():
+		return "query_priority_matchers_config...", true
+	case reflect.TypeOf([]*validation.QueryRewriteRule{}).String():
+		return "query_rewrite_rules_config...", true
 	case reflect.TypeOf(activeseries.CustomTrackersConfig{}).String():
 		return "map of tracker name (string) to matcher (string)", true
 	default:
@@ -470,6 +478,10 @@ func ReflectType(typ string) reflect.Type {
 		return reflect.TypeOf([]*relabel.Config{})
 	case "blocked_queries_config...":
 		return reflect.TypeOf([]*validation.BlockedQuery{})
+	case "query_priority_matchers_config...":
+		return reflect.TypeOf([]*validation.QueryPriorityMatcher{})
+	case "query_rewrite_rules_config...":
+		return reflect.TypeOf([]*validation.QueryRewriteRule{})
 	case "map of string to float64":
 		return reflect.TypeOf(map[string]float64{})
 	case "list of durations":
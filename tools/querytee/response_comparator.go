@@ -8,7 +8,6 @@ package querytee
 import (
 	"encoding/json"
 	"fmt"
-	"math"
 	"time"
 
 	"github.com/go-kit/log/level"
@@ -16,6 +15,7 @@ import (
 	"github.com/prometheus/common/model"
 
 	util_log "github.com/grafana/mimir/pkg/util/log"
+	util_math "github.com/grafana/mimir/pkg/util/math"
 )
 
 // SamplesComparatorFunc helps with comparing different types of samples coming from /api/v1/query and /api/v1/query_range routes.
@@ -239,18 +239,5 @@ func compareSamplePair(expected, actual model.SamplePair, opts SampleComparisonO
 }
 
 func compareSampleValue(first, second model.SampleValue, opts SampleComparisonOptions) bool {
-	f := float64(first)
-	s := float64(second)
-
-	if (math.IsNaN(f) && math.IsNaN(s)) ||
-		(math.IsInf(f, 1) && math.IsInf(s, 1)) ||
-		(math.IsInf(f, -1) && math.IsInf(s, -1)) {
-		return true
-	} else if opts.Tolerance <= 0 {
-		return math.Float64bits(f) == math.Float64bits(s)
-	}
-	if opts.UseRelativeError && s != 0 {
-		return math.Abs(f-s)/math.Abs(s) <= opts.Tolerance
-	}
-	return math.Abs(f-s) <= opts.Tolerance
+	return util_math.EqualWithinTolerance(float64(first), float64(second), opts.Tolerance, opts.UseRelativeError)
 }